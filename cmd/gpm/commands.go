@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gcp-proxy-mity/pkg/client"
+)
+
+// signBatchSize is the number of objects signed per request
+const signBatchSize = 100
+
+// parseArgs parses the flags of a command and checks it has at least min arguments
+func parseArgs(fs *flag.FlagSet, args []string, min int) ([]string, bool) {
+	if err := fs.Parse(args); err != nil {
+		return nil, false
+	}
+	if len(fs.Args()) < min {
+		fs.Usage()
+		return nil, false
+	}
+	return fs.Args(), true
+}
+
+// isDirTarget reports whether a destination names a directory rather than a single file
+func isDirTarget(dst string, sources []source, args []string, recursive bool) bool {
+	if len(sources) > 1 || len(args) > 1 || recursive || dst == "" || strings.HasSuffix(dst, "/") {
+		return true
+	}
+	return hasGlob(args[0])
+}
+
+func runUpload(ctx context.Context, args []string) int {
+	var o options
+	fs := newFlagSet("upload", "<local>... <remote>", &o)
+	contentType := fs.String("content-type", "", "content type of the uploads (default detected by the proxy)")
+	args, ok := parseArgs(fs, args, 2)
+	if !ok {
+		return 2
+	}
+	c, err := o.client()
+	if err != nil {
+		return fail(err)
+	}
+
+	locals, dst := args[:len(args)-1], strings.TrimPrefix(args[len(args)-1], "/")
+	sources, err := expandLocal(locals, o.recursive)
+	if err != nil {
+		return fail(err)
+	}
+	intoDir := isDirTarget(dst, sources, locals, o.recursive)
+
+	p := newProgress(os.Stderr, o.quiet)
+	failed := parallel(ctx, o.jobs, sources, func(ctx context.Context, src source) error {
+		target := destination(dst, src, intoDir)
+		b := p.start(target, src.size)
+		err := uploadFile(ctx, c, src, target, *contentType, b)
+		p.finish(b, "uploaded", err)
+		return err
+	})
+	p.close()
+	return exitCode(failed, len(sources))
+}
+
+func uploadFile(ctx context.Context, c *client.Client, src source, name, contentType string, b *bar) error {
+	f, err := os.Open(src.name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = c.WriteFile(ctx, name, b.reader(f), src.size, contentType)
+	return err
+}
+
+func runDownload(ctx context.Context, args []string) int {
+	var o options
+	fs := newFlagSet("download", "<remote>... <local | ->", &o)
+	args, ok := parseArgs(fs, args, 2)
+	if !ok {
+		return 2
+	}
+	c, err := o.client()
+	if err != nil {
+		return fail(err)
+	}
+
+	remotes, dst := args[:len(args)-1], args[len(args)-1]
+	sources, err := expandRemote(ctx, c, remotes, o.recursive)
+	if err != nil {
+		return fail(err)
+	}
+
+	p := newProgress(os.Stderr, o.quiet)
+	defer p.close()
+	if dst == "-" {
+		if len(sources) != 1 {
+			return fail(errors.New("only a single object can be written to stdout"))
+		}
+		b := p.start(sources[0].name, sources[0].size)
+		err := downloadTo(ctx, c, sources[0].name, os.Stdout, b)
+		p.finish(b, "downloaded", err)
+		if err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	info, err := os.Stat(dst)
+	intoDir := isDirTarget(filepath.ToSlash(dst), sources, remotes, o.recursive) ||
+		strings.HasSuffix(dst, string(filepath.Separator)) || err == nil && info.IsDir()
+
+	failed := parallel(ctx, o.jobs, sources, func(ctx context.Context, src source) error {
+		b := p.start(src.name, src.size)
+		err := downloadFile(ctx, c, src, dst, intoDir, b)
+		p.finish(b, "downloaded", err)
+		return err
+	})
+	return exitCode(failed, len(sources))
+}
+
+// downloadFile writes an object to its local destination through a temporary
+// file, so an interrupted download never leaves a truncated file behind
+func downloadFile(ctx context.Context, c *client.Client, src source, dst string, intoDir bool, b *bar) error {
+	if intoDir && !filepath.IsLocal(filepath.FromSlash(src.rel)) {
+		return fmt.Errorf("object name %q would be written outside %s", src.name, dst)
+	}
+	target := filepath.FromSlash(destination(filepath.ToSlash(dst), src, intoDir))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".gpm-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := downloadTo(ctx, c, src.name, tmp, b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func downloadTo(ctx context.Context, c *client.Client, name string, w io.Writer, b *bar) error {
+	file, err := c.ReadFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if file.Size >= 0 {
+		b.total.Store(file.Size)
+	}
+	_, err = io.Copy(w, b.reader(file))
+	return err
+}
+
+func runList(ctx context.Context, args []string) int {
+	var o options
+	fs := newFlagSet("ls", "[prefix | pattern]", &o)
+	long := fs.Bool("l", false, "show size, modification time and storage class")
+	args, ok := parseArgs(fs, args, 0)
+	if !ok {
+		return 2
+	}
+	c, err := o.client()
+	if err != nil {
+		return fail(err)
+	}
+
+	var arg string
+	if len(args) > 0 {
+		arg = strings.TrimPrefix(args[0], "/")
+	}
+	prefix, delimiter := arg, "/"
+	if o.recursive {
+		delimiter = ""
+	}
+	if i := strings.IndexAny(arg, "*?["); i >= 0 {
+		prefix, delimiter = arg[:i], ""
+	}
+
+	result, err := c.List(ctx, prefix, delimiter)
+	if err != nil {
+		return fail(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	for _, common := range result.Prefixes {
+		if *long {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", "DIR", "", "", common)
+		} else {
+			fmt.Fprintln(w, common)
+		}
+	}
+	for _, file := range result.Files {
+		if hasGlob(arg) {
+			if ok, err := path.Match(arg, file.Name); err != nil {
+				return fail(fmt.Errorf("invalid pattern %q: %w", arg, err))
+			} else if !ok {
+				continue
+			}
+		}
+		if *long {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", formatSize(file.Size), file.Updated.Local().Format(time.DateTime), file.StorageClass, file.Name)
+		} else {
+			fmt.Fprintln(w, file.Name)
+		}
+	}
+	return 0
+}
+
+func runRemove(ctx context.Context, args []string) int {
+	var o options
+	fs := newFlagSet("rm", "<remote>...", &o)
+	args, ok := parseArgs(fs, args, 1)
+	if !ok {
+		return 2
+	}
+	c, err := o.client()
+	if err != nil {
+		return fail(err)
+	}
+
+	sources, err := expandRemote(ctx, c, args, o.recursive)
+	if err != nil {
+		return fail(err)
+	}
+
+	p := newProgress(os.Stderr, o.quiet)
+	failed := parallel(ctx, o.jobs, sources, func(ctx context.Context, src source) error {
+		err := c.Delete(ctx, src.name)
+		p.report(src.name, "deleted "+src.name, err)
+		return err
+	})
+	p.close()
+	return exitCode(failed, len(sources))
+}
+
+func runCopy(ctx context.Context, args []string) int {
+	var o options
+	fs := newFlagSet("cp", "<remote>... <remote>", &o)
+	args, ok := parseArgs(fs, args, 2)
+	if !ok {
+		return 2
+	}
+	c, err := o.client()
+	if err != nil {
+		return fail(err)
+	}
+
+	remotes, dst := args[:len(args)-1], strings.TrimPrefix(args[len(args)-1], "/")
+	sources, err := expandRemote(ctx, c, remotes, o.recursive)
+	if err != nil {
+		return fail(err)
+	}
+	intoDir := isDirTarget(dst, sources, remotes, o.recursive)
+
+	p := newProgress(os.Stderr, o.quiet)
+	failed := parallel(ctx, o.jobs, sources, func(ctx context.Context, src source) error {
+		target := destination(dst, src, intoDir)
+		_, err := c.Copy(ctx, src.name, target)
+		p.report(src.name, "copied "+src.name+" to "+target, err)
+		return err
+	})
+	p.close()
+	return exitCode(failed, len(sources))
+}
+
+func runSignURL(ctx context.Context, args []string) int {
+	var o options
+	fs := newFlagSet("sign-url", "<remote>...", &o)
+	args, ok := parseArgs(fs, args, 1)
+	if !ok {
+		return 2
+	}
+	c, err := o.client()
+	if err != nil {
+		return fail(err)
+	}
+
+	sources, err := expandRemote(ctx, c, args, o.recursive)
+	if err != nil {
+		return fail(err)
+	}
+
+	failed := 0
+	for start := 0; start < len(sources); start += signBatchSize {
+		batch := sources[start:min(start+signBatchSize, len(sources))]
+		names := make([]string, len(batch))
+		for i, src := range batch {
+			names[i] = src.name
+		}
+
+		result, err := c.SignedURLs(ctx, names)
+		if err != nil {
+			return fail(err)
+		}
+		for _, file := range result.Files {
+			if len(sources) == 1 {
+				fmt.Println(file.URL)
+			} else {
+				fmt.Printf("%s\t%s\n", file.Name, file.URL)
+			}
+		}
+		for _, e := range result.Errors {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", e.FilePath, e.Error)
+			failed++
+		}
+	}
+	return exitCode(failed, len(sources))
+}
+
+// exitCode reports the number of failures and returns 1 if there were any
+func exitCode(failed, total int) int {
+	if failed == 0 {
+		return 0
+	}
+	if total > 1 {
+		fmt.Fprintf(os.Stderr, "gpm: %d of %d failed\n", failed, total)
+	}
+	return 1
+}
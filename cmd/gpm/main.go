@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"gcp-proxy-mity/pkg/client"
+)
+
+// command is a gpm subcommand; it returns the process exit code
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) int
+}
+
+var commands = []command{
+	{"upload", "upload local files", runUpload},
+	{"download", "download objects", runDownload},
+	{"ls", "list objects", runList},
+	{"rm", "delete objects", runRemove},
+	{"cp", "copy objects within the bucket", runCopy},
+	{"sign-url", "print signed download URLs", runSignURL},
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	name := flag.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			code := cmd.run(ctx, flag.Args()[1:])
+			stop()
+			os.Exit(code)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "gpm: unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: gpm <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun gpm <command> -h for the flags of a command.")
+	fmt.Fprintf(os.Stderr, "Profiles are read from %s ($GPM_CONFIG).\n", configPath())
+}
+
+// options are the flags shared by all commands
+type options struct {
+	profile   string
+	url       string
+	apiKey    string
+	jobs      int
+	quiet     bool
+	recursive bool
+}
+
+// newFlagSet creates the flag set of a command with the shared flags
+func newFlagSet(name, arguments string, o *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&o.profile, "profile", "", "config profile to use (default $GPM_PROFILE or default_profile)")
+	fs.StringVar(&o.url, "url", "", "proxy base URL, overriding the profile")
+	fs.StringVar(&o.apiKey, "api-key", "", "API key, overriding the profile")
+	fs.IntVar(&o.jobs, "j", 4, "number of parallel transfers")
+	fs.BoolVar(&o.quiet, "q", false, "only print errors")
+	fs.BoolVar(&o.recursive, "r", false, "include directories and prefixes recursively")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gpm %s [flags] %s\n\nFlags:\n", name, arguments)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// client resolves the profile into an API client
+func (o *options) client() (*client.Client, error) {
+	p, err := resolveProfile(configPath(), o.profile, o.url, o.apiKey)
+	if err != nil {
+		return nil, err
+	}
+	var opts []client.Option
+	if p.APIKey != "" {
+		opts = append(opts, client.WithAPIKey(p.APIKey))
+	}
+	return client.New(p.URL, opts...), nil
+}
+
+// fail prints err and returns the exit code for failed commands
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "gpm: %v\n", err)
+	return 1
+}
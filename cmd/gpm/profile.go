@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profile holds the proxy address and credentials a command talks to
+type profile struct {
+	URL    string `yaml:"url"`
+	APIKey string `yaml:"api_key"`
+}
+
+// profilesFile is the CLI config file, by default ~/.config/gpm/config.yaml:
+//
+//	default_profile: prod
+//	profiles:
+//	  prod:
+//	    url: https://media-proxy.example.com
+//	    api_key: ...
+type profilesFile struct {
+	DefaultProfile string             `yaml:"default_profile"`
+	Profiles       map[string]profile `yaml:"profiles"`
+}
+
+// configPath returns $GPM_CONFIG or config.yaml in the user's config directory
+func configPath() string {
+	if path := os.Getenv("GPM_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gpm", "config.yaml")
+}
+
+// resolveProfile picks the profile named by the flag, $GPM_PROFILE or the file's
+// default, then applies $GPM_URL and $GPM_API_KEY and finally the flags
+func resolveProfile(path, name, url, apiKey string) (profile, error) {
+	var file profilesFile
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := yaml.Unmarshal(data, &file); err != nil {
+				return profile{}, fmt.Errorf("invalid config file %s: %w", path, err)
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			return profile{}, err
+		}
+	}
+
+	if name == "" {
+		name = os.Getenv("GPM_PROFILE")
+	}
+	explicit := name != ""
+	if name == "" {
+		name = file.DefaultProfile
+	}
+
+	p, ok := file.Profiles[name]
+	if !ok && explicit {
+		return profile{}, fmt.Errorf("profile %q is not defined in %s", name, path)
+	}
+
+	if value := os.Getenv("GPM_URL"); value != "" {
+		p.URL = value
+	}
+	if value := os.Getenv("GPM_API_KEY"); value != "" {
+		p.APIKey = value
+	}
+	if url != "" {
+		p.URL = url
+	}
+	if apiKey != "" {
+		p.APIKey = apiKey
+	}
+
+	if p.URL == "" {
+		return profile{}, errors.New("no proxy URL: set -url, GPM_URL or a profile in " + path)
+	}
+	return p, nil
+}
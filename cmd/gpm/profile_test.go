@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+default_profile: prod
+profiles:
+  prod:
+    url: https://media.example.com
+    api_key: prod-key
+  local:
+    url: http://localhost:8080
+`), 0o600)
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		profile string
+		url     string
+		want    profile
+		wantErr bool
+	}{
+		{
+			name: "default profile",
+			want: profile{URL: "https://media.example.com", APIKey: "prod-key"},
+		},
+		{
+			name:    "profile flag",
+			profile: "local",
+			want:    profile{URL: "http://localhost:8080"},
+		},
+		{
+			name: "profile from the environment",
+			env:  map[string]string{"GPM_PROFILE": "local", "GPM_API_KEY": "env-key"},
+			want: profile{URL: "http://localhost:8080", APIKey: "env-key"},
+		},
+		{
+			name: "flags override the environment",
+			env:  map[string]string{"GPM_URL": "http://env:8080"},
+			url:  "http://flag:8080",
+			want: profile{URL: "http://flag:8080", APIKey: "prod-key"},
+		},
+		{
+			name:    "unknown profile",
+			profile: "staging",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"GPM_PROFILE", "GPM_URL", "GPM_API_KEY"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			got, err := resolveProfile(path, tt.profile, tt.url, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResolveProfile_MissingURL(t *testing.T) {
+	t.Setenv("GPM_URL", "")
+	t.Setenv("GPM_PROFILE", "")
+	if _, err := resolveProfile(filepath.Join(t.TempDir(), "missing.yaml"), "", "", ""); err == nil {
+		t.Error("Expected an error without a proxy URL")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	barWidth       = 24
+	nameWidth      = 36
+	redrawInterval = 200 * time.Millisecond
+)
+
+// progress renders a bar per running transfer on a terminal. Finished transfers
+// are printed as a single line above the bars, which is also all that is printed
+// when the output is not a terminal.
+type progress struct {
+	out         io.Writer
+	interactive bool
+	quiet       bool
+
+	mu    sync.Mutex
+	bars  []*bar
+	lines int
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// bar tracks one transfer
+type bar struct {
+	name    string
+	total   atomic.Int64
+	current atomic.Int64
+	started time.Time
+}
+
+func newProgress(out *os.File, quiet bool) *progress {
+	p := &progress{out: out, quiet: quiet}
+	if info, err := out.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 && !quiet {
+		p.interactive = true
+		p.stop = make(chan struct{})
+		p.done = make(chan struct{})
+		go p.run()
+	}
+	return p
+}
+
+// start adds a bar for a transfer of total bytes, or -1 if unknown
+func (p *progress) start(name string, total int64) *bar {
+	b := &bar{name: name, started: time.Now()}
+	b.total.Store(total)
+	p.mu.Lock()
+	p.bars = append(p.bars, b)
+	p.mu.Unlock()
+	return b
+}
+
+// reader counts the bytes read from r towards the bar
+func (b *bar) reader(r io.Reader) io.Reader {
+	return &countingReader{Reader: r, bar: b}
+}
+
+type countingReader struct {
+	io.Reader
+	bar *bar
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.bar.current.Add(int64(n))
+	return n, err
+}
+
+// finish removes the bar and prints the outcome of the transfer
+func (p *progress) finish(b *bar, verb string, err error) {
+	p.mu.Lock()
+	for i, active := range p.bars {
+		if active == b {
+			p.bars = append(p.bars[:i], p.bars[i+1:]...)
+			break
+		}
+	}
+	p.mu.Unlock()
+
+	p.report(b.name, fmt.Sprintf("%s %s (%s in %s)", verb, b.name, formatSize(b.current.Load()), time.Since(b.started).Round(time.Millisecond)), err)
+}
+
+// report prints the outcome of an operation on name above the bars; errors are
+// printed even when quiet
+func (p *progress) report(name, message string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clear()
+	if err != nil {
+		fmt.Fprintf(p.out, "%s: %v\n", name, err)
+	} else if !p.quiet {
+		fmt.Fprintln(p.out, message)
+	}
+	p.draw()
+}
+
+// close stops redrawing and removes the remaining bars
+func (p *progress) close() {
+	if !p.interactive {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+}
+
+func (p *progress) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(redrawInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			p.clear()
+			p.draw()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// clear erases the bars drawn last
+func (p *progress) clear() {
+	if p.lines > 0 {
+		fmt.Fprintf(p.out, "\x1b[%dA\x1b[J", p.lines)
+		p.lines = 0
+	}
+}
+
+func (p *progress) draw() {
+	if !p.interactive {
+		return
+	}
+	for _, b := range p.bars {
+		fmt.Fprintln(p.out, b.render())
+		p.lines++
+	}
+}
+
+func (b *bar) render() string {
+	name := b.name
+	if len(name) > nameWidth {
+		name = "..." + name[len(name)-nameWidth+3:]
+	}
+	current := b.current.Load()
+	elapsed := time.Since(b.started).Seconds()
+	rate := ""
+	if elapsed > 0 {
+		rate = formatSize(int64(float64(current)/elapsed)) + "/s"
+	}
+
+	total := b.total.Load()
+	if total <= 0 {
+		return fmt.Sprintf("%-*s %10s %12s", nameWidth, name, formatSize(current), rate)
+	}
+	filled := int(float64(barWidth) * float64(current) / float64(total))
+	filled = min(filled, barWidth)
+	return fmt.Sprintf("%-*s [%s%s] %3d%% %10s %12s", nameWidth, name,
+		strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+		current*100/total, formatSize(current), rate)
+}
+
+// formatSize renders a byte count with a binary unit
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
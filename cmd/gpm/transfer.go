@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gcp-proxy-mity/pkg/client"
+)
+
+// source is a file or object selected by an argument. rel is the part of its
+// name kept when it is placed under a destination directory.
+type source struct {
+	name string
+	rel  string
+	size int64
+}
+
+// hasGlob reports whether pattern contains glob metacharacters
+func hasGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// expandRemote resolves remote arguments into objects. An argument is an exact
+// object name, a glob matched against object names (* does not cross /), or
+// with recursive a prefix ending in / selecting every object below it.
+func expandRemote(ctx context.Context, c *client.Client, args []string, recursive bool) ([]source, error) {
+	var sources []source
+	for _, arg := range args {
+		arg = strings.TrimPrefix(arg, "/")
+		if !hasGlob(arg) && !strings.HasSuffix(arg, "/") && arg != "" {
+			sources = append(sources, source{name: arg, rel: path.Base(arg), size: -1})
+			continue
+		}
+		if !hasGlob(arg) && !recursive {
+			return nil, fmt.Errorf("%q is a prefix; use -r to select the objects below it", arg)
+		}
+
+		prefix := arg
+		if i := strings.IndexAny(arg, "*?["); i >= 0 {
+			prefix = arg[:i]
+		}
+		listed, err := c.List(ctx, prefix, "")
+		if err != nil {
+			return nil, err
+		}
+		matched, err := selectObjects(arg, listed.Files)
+		if err != nil {
+			return nil, err
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("no objects match %q", arg)
+		}
+		sources = append(sources, matched...)
+	}
+	return sources, nil
+}
+
+// selectObjects picks the listed objects that a glob or prefix argument selects
+func selectObjects(arg string, files []client.FileMetadata) ([]source, error) {
+	var sources []source
+	if !hasGlob(arg) {
+		base := path.Base(strings.TrimSuffix(arg, "/"))
+		for _, file := range files {
+			rel := strings.TrimPrefix(file.Name, arg)
+			if rel == "" {
+				continue
+			}
+			if arg != "" {
+				rel = base + "/" + rel
+			}
+			sources = append(sources, source{name: file.Name, rel: rel, size: file.Size})
+		}
+		return sources, nil
+	}
+
+	for _, file := range files {
+		ok, err := path.Match(arg, file.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+		}
+		if ok {
+			sources = append(sources, source{name: file.Name, rel: path.Base(file.Name), size: file.Size})
+		}
+	}
+	return sources, nil
+}
+
+// expandLocal resolves local arguments, which may be globs, into files. With
+// recursive, directories are walked and their files keep their path below the
+// directory's parent.
+func expandLocal(args []string, recursive bool) ([]source, error) {
+	var sources []source
+	for _, arg := range args {
+		paths := []string{arg}
+		if hasGlob(arg) {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", arg)
+			}
+			paths = matches
+		}
+
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				sources = append(sources, source{name: p, rel: filepath.Base(p), size: info.Size()})
+				continue
+			}
+			if !recursive {
+				return nil, fmt.Errorf("%s is a directory; use -r to upload its files", p)
+			}
+
+			root := filepath.Dir(filepath.Clean(p))
+			err = filepath.WalkDir(p, func(file string, entry fs.DirEntry, err error) error {
+				if err != nil || entry.IsDir() {
+					return err
+				}
+				info, err := entry.Info()
+				if err != nil {
+					return err
+				}
+				rel, err := filepath.Rel(root, file)
+				if err != nil {
+					return err
+				}
+				sources = append(sources, source{name: file, rel: filepath.ToSlash(rel), size: info.Size()})
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sources, nil
+}
+
+// destination returns where a source is placed: dst itself, or rel below dst
+// when dst is a directory
+func destination(dst string, src source, intoDir bool) string {
+	if !intoDir {
+		return dst
+	}
+	if dst == "" || strings.HasSuffix(dst, "/") {
+		return dst + src.rel
+	}
+	return dst + "/" + src.rel
+}
+
+// parallel runs fn for every item with at most jobs running at once and returns
+// the number of items that failed; fn reports its own errors
+func parallel[T any](ctx context.Context, jobs int, items []T, fn func(context.Context, T) error) int {
+	var failed atomic.Int64
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(jobs, 1))
+	for _, item := range items {
+		if ctx.Err() != nil {
+			failed.Add(1)
+			continue
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := fn(ctx, item); err != nil {
+				failed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	return int(failed.Load())
+}
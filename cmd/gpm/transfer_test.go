@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"gcp-proxy-mity/pkg/client"
+)
+
+func TestSelectObjects(t *testing.T) {
+	media := []client.FileMetadata{
+		{Name: "media/a.jpg"},
+		{Name: "media/b.png"},
+		{Name: "media/clips/1.jpg"},
+	}
+
+	tests := []struct {
+		name    string
+		arg     string
+		listed  []client.FileMetadata
+		want    []source
+		wantErr bool
+	}{
+		{
+			name:   "glob does not cross slashes",
+			arg:    "media/*.jpg",
+			listed: media,
+			want:   []source{{name: "media/a.jpg", rel: "a.jpg"}},
+		},
+		{
+			name:   "prefix keeps the path below its parent",
+			arg:    "media/",
+			listed: media,
+			want: []source{
+				{name: "media/a.jpg", rel: "media/a.jpg"},
+				{name: "media/b.png", rel: "media/b.png"},
+				{name: "media/clips/1.jpg", rel: "media/clips/1.jpg"},
+			},
+		},
+		{
+			name:   "nested prefix",
+			arg:    "media/clips/",
+			listed: media[2:],
+			want:   []source{{name: "media/clips/1.jpg", rel: "clips/1.jpg"}},
+		},
+		{
+			name:    "invalid pattern",
+			arg:     "media/[",
+			listed:  media,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectObjects(tt.arg, tt.listed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDestination(t *testing.T) {
+	src := source{name: "media/clips/1.jpg", rel: "clips/1.jpg"}
+
+	tests := []struct {
+		dst     string
+		intoDir bool
+		want    string
+	}{
+		{dst: "backup/1.jpg", want: "backup/1.jpg"},
+		{dst: "backup", intoDir: true, want: "backup/clips/1.jpg"},
+		{dst: "backup/", intoDir: true, want: "backup/clips/1.jpg"},
+		{dst: "", intoDir: true, want: "clips/1.jpg"},
+	}
+
+	for _, tt := range tests {
+		if got := destination(tt.dst, src, tt.intoDir); got != tt.want {
+			t.Errorf("destination(%q, %v): expected %q, got %q", tt.dst, tt.intoDir, tt.want, got)
+		}
+	}
+}
+
+func TestExpandLocal(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.jpg", "b.png", "photos/c.jpg", "photos/raw/d.jpg"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte(name), 0o644)
+	}
+
+	sources, err := expandLocal([]string{filepath.Join(dir, "*.jpg"), filepath.Join(dir, "photos")}, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var rels []string
+	for _, src := range sources {
+		rels = append(rels, src.rel)
+	}
+	sort.Strings(rels)
+	want := []string{"a.jpg", "photos/c.jpg", "photos/raw/d.jpg"}
+	if !reflect.DeepEqual(rels, want) {
+		t.Errorf("Expected %v, got %v", want, rels)
+	}
+
+	if _, err := expandLocal([]string{filepath.Join(dir, "photos")}, false); err == nil {
+		t.Error("Expected an error for a directory without -r")
+	}
+}
+
+func TestParallel(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	failed := parallel(context.Background(), 2, items, func(ctx context.Context, n int) error {
+		if n%2 == 0 {
+			return errors.New("even")
+		}
+		return nil
+	})
+	if failed != 2 {
+		t.Errorf("Expected 2 failures, got %d", failed)
+	}
+}
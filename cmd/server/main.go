@@ -2,73 +2,1512 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/api/idtoken"
+
+	"gcp-proxy-mity/internal/admission"
+	"gcp-proxy-mity/internal/appendlog"
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/cache"
+	"gcp-proxy-mity/internal/catalog"
+	"gcp-proxy-mity/internal/changes"
+	"gcp-proxy-mity/internal/clientip"
 	"gcp-proxy-mity/internal/config"
+	"gcp-proxy-mity/internal/diagnostics"
+	"gcp-proxy-mity/internal/drain"
+	"gcp-proxy-mity/internal/election"
+	"gcp-proxy-mity/internal/envelope"
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/fetch"
 	"gcp-proxy-mity/internal/handler"
+	"gcp-proxy-mity/internal/health"
+	"gcp-proxy-mity/internal/hotlink"
+	"gcp-proxy-mity/internal/iap"
+	"gcp-proxy-mity/internal/idempotency"
+	"gcp-proxy-mity/internal/ipfilter"
+	"gcp-proxy-mity/internal/jobs"
+	"gcp-proxy-mity/internal/lease"
+	"gcp-proxy-mity/internal/listener"
+	"gcp-proxy-mity/internal/maintenance"
+	"gcp-proxy-mity/internal/media"
+	"gcp-proxy-mity/internal/metrics"
+	"gcp-proxy-mity/internal/middleware"
+	"gcp-proxy-mity/internal/moderation"
+	"gcp-proxy-mity/internal/mount"
+	"gcp-proxy-mity/internal/oidc"
+	"gcp-proxy-mity/internal/openapi"
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/progress"
+	"gcp-proxy-mity/internal/quota"
+	"gcp-proxy-mity/internal/ratelimit"
+	"gcp-proxy-mity/internal/redisstore"
+	"gcp-proxy-mity/internal/replication"
+	"gcp-proxy-mity/internal/s3"
+	"gcp-proxy-mity/internal/scheduler"
+	"gcp-proxy-mity/internal/search"
+	"gcp-proxy-mity/internal/selfcheck"
 	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/sftpd"
+	"gcp-proxy-mity/internal/share"
+	"gcp-proxy-mity/internal/spool"
+	"gcp-proxy-mity/internal/stats"
 	"gcp-proxy-mity/internal/storage"
+	"gcp-proxy-mity/internal/throttle"
+	"gcp-proxy-mity/internal/tlsconfig"
+	"gcp-proxy-mity/internal/version"
 	"gcp-proxy-mity/pkg/storage/gcs"
 )
 
+// checkTimeout bounds the self-check run with -check
+const checkTimeout = 30 * time.Second
+
+// checkPrefix is where the self-check writes its test object
+const checkPrefix = "_selfcheck"
+
 func main() {
-	cfg := config.Load()
+	configPath := flag.String("config", "", "path to a YAML or JSON config file (defaults to $CONFIG_FILE)")
+	validateConfig := flag.Bool("validate-config", false, "validate the configuration, print it without secrets and exit")
+	printVersion := flag.Bool("version", false, "print the version and exit")
+	check := flag.Bool("check", false, "check the configuration, credentials and bucket access, print a report and exit")
+	flag.Parse()
+
+	build := version.Get()
+	if *printVersion {
+		fmt.Printf("gcp-proxy-mity %s\ncommit: %s\nbuilt: %s\ngo: %s\n", build.Version, build.Commit, build.BuildDate, build.GoVersion)
+		return
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	if *validateConfig {
+		os.Exit(printConfig(cfg))
+	}
+	if *check {
+		os.Exit(runCheck(cfg))
+	}
+
 	if err := cfg.Validate(); err != nil {
 		log.Fatalf("Configuration error: %v", err)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// Every structured log line, like the request log, names the version that wrote it
+	slog.SetDefault(slog.Default().With("version", build.Version))
+	log.Printf("Starting gcp-proxy-mity %s", build)
+
+	// Work started from here rather than by a request, like scheduled jobs and
+	// bucket notifications, is the proxy's own
+	ctx, cancel := context.WithCancel(auth.WithSystem(context.Background()))
 	defer cancel()
 
 	// Initialize GCS client
-	gcsClient, err := gcs.NewClient(ctx, cfg.GCPProjectID, cfg.GCSBucketName, cfg.GoogleCredentials)
+	credentials := gcsCredentials(cfg)
+	if cfg.GCSEmulatorHost != "" {
+		log.Printf("Using the Cloud Storage emulator at %s", cfg.GCSEmulatorHost)
+	}
+	gcsClient, err := newGCSClient(ctx, cfg, cfg.GCSBucketName)
 	if err != nil {
 		log.Fatalf("Failed to create GCS client: %v", err)
 	}
 	defer gcsClient.Close()
 
 	gcsStorage := storage.NewGCSStorage(gcsClient)
-	storageService := service.NewStorageService(gcsStorage)
-	storageHandler := handler.NewStorageHandler(storageService)
-
-	// Setup routes
-	mux := http.NewServeMux()
-	storageHandler.SetupRoutes(mux)
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+	gcsStorage.SetParallelReads(storage.ParallelReads{PartSize: cfg.GCSReadPartSize, Concurrency: cfg.GCSReadConcurrency})
+	// buckets are the served bucket and those mounted at a prefix
+	buckets := &bucketSet{}
+	buckets.add(ctx, gcsStorage)
+
+	// Redis is optional; replicas fall back to local state while it is unreachable
+	var redisClient *redisstore.Client
+	if cfg.RedisURL != "" {
+		redisClient, err = redisstore.New(cfg.RedisURL, cfg.RedisKeyPrefix)
+		if err != nil {
+			log.Fatalf("Failed to set up Redis: %v", err)
+		}
+		defer redisClient.Close()
+		if err := redisClient.Ping(ctx); err != nil {
+			log.Printf("Warning: Redis is not reachable, continuing with local state: %v", err)
+		}
+	}
+
+	// Small hot objects such as thumbnails can be served from memory, or from Redis when it is shared
+	var objectStorage storage.Storage = gcsStorage
+	var readCache cache.Cache
+	var readCacheConfig service.ReadCacheConfig
+	if cfg.ReadCacheMaxBytes > 0 {
+		readCacheConfig = service.ReadCacheConfig{
+			Backend:        service.CacheBackendRedis,
+			MaxBytes:       cfg.ReadCacheMaxBytes,
+			MaxObjectBytes: cfg.ReadCacheMaxObjectBytes,
+			CDNBaseURL:     cfg.PublicBaseURL,
+		}
+		if redisClient != nil {
+			readCache = redisstore.NewCache(redisClient, cfg.ReadCacheTTL)
+		} else {
+			lru := cache.NewLRU(cfg.ReadCacheMaxBytes, cfg.ReadCacheTTL)
+			metrics.NewGaugeFunc("gcs_proxy_read_cache_bytes", "Bytes held by the read cache", func() float64 {
+				return float64(lru.Size())
+			})
+			metrics.NewGaugeFunc("gcs_proxy_read_cache_entries", "Objects held by the read cache", func() float64 {
+				return float64(lru.Len())
+			})
+			readCache = lru
+			readCacheConfig.Backend = service.CacheBackendMemory
+			readCacheConfig.Size = func() (int, int64) { return lru.Len(), lru.Size() }
+		}
+		readCacheConfig.Cache = readCache
+		objectStorage = storage.NewCachedStorage(gcsStorage, readCache, cfg.ReadCacheMaxObjectBytes)
+	}
+	// Concurrent reads of the same object, like a burst of requests for a new thumbnail, share one GCS read
+	if cfg.ReadCoalescing {
+		objectStorage = storage.NewCoalescedStorage(objectStorage)
+	}
+
+	// Files under a mount's prefix are stored in another bucket or in a
+	// directory; mounts come from the storage routes and the mount API
+	mounts := mount.NewTable(func(_ context.Context, m mount.Mount) (storage.Storage, func() error, error) {
+		scheme, location, err := mount.ParseTarget(m.Target)
+		if err != nil {
+			return nil, nil, err
+		}
+		if m.Cache && readCache == nil {
+			return nil, nil, errors.New("caching requires the read cache")
+		}
+		var backend storage.Storage
+		var closeBackend func() error
+		switch scheme {
+		case "gs":
+			// The client outlives the request adding the mount
+			client, err := newGCSClient(ctx, cfg, location)
+			if err != nil {
+				return nil, nil, err
+			}
+			bucket := storage.NewGCSStorage(client)
+			bucket.SetParallelReads(storage.ParallelReads{PartSize: cfg.GCSReadPartSize, Concurrency: cfg.GCSReadConcurrency})
+			buckets.add(ctx, bucket)
+			backend = bucket
+			closeBackend = func() error {
+				buckets.remove(bucket)
+				return client.Close()
+			}
+		case "file":
+			backend = storage.NewFSStorage(location)
+		}
+		if m.Cache {
+			backend = storage.NewCachedStorage(backend, readCache, cfg.ReadCacheMaxObjectBytes)
+		}
+		if cfg.ReadCoalescing {
+			backend = storage.NewCoalescedStorage(backend)
+		}
+		return backend, closeBackend, nil
 	})
+	defer mounts.Close()
+	for _, route := range cfg.StorageRoutes {
+		err := mounts.AddStatic(ctx, mount.Mount{
+			Prefix:     route.Prefix,
+			Target:     route.Target,
+			QuotaBytes: route.QuotaBytes,
+			Cache:      route.Cache,
+			Scope:      route.Scope,
+			ReadOnly:   route.ReadOnly,
+		})
+		if err != nil {
+			log.Fatalf("Failed to mount %s at %s: %v", route.Target, route.Prefix, err)
+		}
+		log.Printf("Storing files under %s in %s", route.Prefix, route.Target)
+	}
+	if len(cfg.StorageRoutes) > 0 || cfg.MountsEnabled {
+		objectStorage = service.NewMountedStorage(objectStorage, mounts)
+	}
 
-	server := &http.Server{
-		Addr:    ":" + cfg.Port,
-		Handler: mux,
+	serviceOpts := []service.Option{
+		service.WithImageTransforms(service.ImageConfig{
+			SigningKey:    cfg.ImageSigningKey,
+			VariantPrefix: cfg.ImageVariantPrefix,
+		}),
+		service.WithMetadataStripping(cfg.StripImageMetadata),
+		service.WithDeduplication(cfg.DedupPrefix),
+		service.WithTrash(cfg.TrashPrefix),
+		service.WithTagIndex(cfg.TagIndexPrefix),
+		service.WithBatchLimits(service.BatchLimits{
+			MaxFiles: cfg.MaxBatchFiles,
+			MaxBytes: cfg.MaxBatchBytes,
+		}),
+		service.WithStreaming(service.StreamConfig{
+			Bucket:         cfg.GCSBucketName,
+			PlaylistMaxAge: cfg.StreamPlaylistMaxAge,
+			SegmentMaxAge:  cfg.StreamSegmentMaxAge,
+		}),
+		service.WithUsage(cfg.UsageCacheTTL, cfg.UsageWait),
+		service.WithExpiry(cfg.ExpiryIndexPrefix),
 	}
 
-	go func() {
-		log.Printf("Server starting on port %s", cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
+	kmsKeys := make([]service.KMSKeyRule, 0, len(cfg.KMSKeys))
+	for _, rule := range cfg.KMSKeys {
+		kmsKeys = append(kmsKeys, service.KMSKeyRule{Prefix: rule.Prefix, KeyName: rule.Key})
+	}
+	storageClasses := make([]service.StorageClassRule, 0, len(cfg.StorageClassRules))
+	for _, rule := range cfg.StorageClassRules {
+		storageClasses = append(storageClasses, service.StorageClassRule{Prefix: rule.Prefix, StorageClass: strings.ToUpper(rule.StorageClass)})
+	}
+	serviceOpts = append(serviceOpts, service.WithKMSKeys(kmsKeys), service.WithStorageClasses(storageClasses))
+	cacheControl := make([]service.CacheControlRule, 0, len(cfg.CacheControlRules))
+	for _, rule := range cfg.CacheControlRules {
+		cacheControl = append(cacheControl, service.CacheControlRule(rule))
+	}
+	serviceOpts = append(serviceOpts, service.WithCacheControl(cacheControl))
+	redirects := make([]service.RedirectRule, 0, len(cfg.RedirectRules))
+	for _, rule := range cfg.RedirectRules {
+		redirects = append(redirects, service.RedirectRule(rule))
+	}
+	serviceOpts = append(serviceOpts, service.WithRedirects(redirects, cfg.RedirectURLTTL))
+	compression := make([]service.CompressionRule, 0, len(cfg.CompressionRules))
+	for _, rule := range cfg.CompressionRules {
+		compression = append(compression, service.CompressionRule(rule))
+	}
+	serviceOpts = append(serviceOpts, service.WithCompression(compression))
+
+	// Objects the proxy encrypts itself carry their data key, wrapped by Cloud KMS or a master key
+	envelopeEnabled := cfg.EnvelopeKMSKey != "" || cfg.EnvelopeMasterKey != ""
+	if envelopeEnabled {
+		keyring, err := envelopeKeyring(ctx, cfg, credentials)
+		if err != nil {
+			log.Fatalf("Failed to set up envelope encryption: %v", err)
 		}
-	}()
+		buckets.setKeyring(keyring)
+		serviceOpts = append(serviceOpts, service.WithEnvelope(cfg.EnvelopePrefixes))
+	}
+	uploads := service.UploadConfig{
+		URLTTL:             cfg.UploadURLTTL,
+		RegistrationPrefix: strings.Trim(cfg.UploadRegistrationPrefix, "/"),
+		ThumbnailWidth:     cfg.UploadThumbnailWidth,
+		CallbackSecret:     cfg.UploadCallbackSecret,
+		CallbackHosts:      cfg.UploadCallbackHosts,
+	}
+	if redisClient != nil {
+		uploads.Sessions = redisstore.NewSessions(redisClient)
+	}
+	serviceOpts = append(serviceOpts, service.WithUploads(uploads))
+
+	// Uploads are counted per API key; Redis makes the counts shared and durable
+	var quotaStore quota.Store = quota.NewMemoryStore()
+	if redisClient != nil {
+		quotaStore = redisstore.NewQuotaStore(redisClient)
+	}
+	quotas := quota.NewTracker(quotaStore)
+	serviceOpts = append(serviceOpts, service.WithQuotas(quotas))
+
+	// Server-side downloads only reach public addresses
+	if cfg.FetchEnabled {
+		maxBytes := cfg.FetchMaxBytes
+		if maxBytes == 0 {
+			maxBytes = cfg.MaxUploadBytes
+		}
+		serviceOpts = append(serviceOpts, service.WithFetcher(fetch.New(fetch.Config{
+			MaxBytes:     maxBytes,
+			Timeout:      cfg.FetchTimeout,
+			AllowedHosts: cfg.FetchAllowedHosts,
+		})))
+	}
+
+	// Poster-frame extraction is optional and only enabled when ffmpeg is configured
+	if cfg.FFmpegPath != "" {
+		extractor, err := media.NewFFmpegExtractor(cfg.FFmpegPath)
+		if err != nil {
+			log.Fatalf("Failed to set up frame extraction: %v", err)
+		}
+		serviceOpts = append(serviceOpts, service.WithFrameExtractor(extractor))
+	}
+
+	// Upload notifications are delivered in the background with retries; events
+	// that still fail are stored under the dead letter prefix
+	eventsCfg := events.AsyncConfig{
+		MaxAttempts: cfg.EventsMaxAttempts,
+		DeadLetter:  events.StorageDeadLetter(gcsStorage, cfg.EventsDeadLetterPrefix),
+	}
+	if cfg.EventsPubSubTopic != "" {
+		topic := cfg.EventsPubSubTopic
+		if !strings.HasPrefix(topic, "projects/") {
+			topic = "projects/" + cfg.GCPProjectID + "/topics/" + topic
+		}
+		clientOpts, err := gcs.ClientOptions(credentials)
+		if err != nil {
+			log.Fatalf("Failed to load credentials: %v", err)
+		}
+		sender, err := events.NewPubSubSender(ctx, topic, clientOpts...)
+		if err != nil {
+			log.Fatalf("Failed to set up Pub/Sub notifications: %v", err)
+		}
+		publisher := events.NewAsyncPublisher("pubsub", sender, eventsCfg)
+		defer publisher.Close()
+		serviceOpts = append(serviceOpts, service.WithPublisher(publisher))
+	}
+	if cfg.EventsWebhookURL != "" {
+		sender := events.NewWebhookSender(cfg.EventsWebhookURL, cfg.EventsWebhookSecret)
+		publisher := events.NewAsyncPublisher("webhook", sender, eventsCfg)
+		defer publisher.Close()
+		serviceOpts = append(serviceOpts, service.WithPublisher(publisher))
+	}
+
+	// Written images and videos are rated in the background like other events;
+	// flagged ones are moved under the quarantine prefix, which only admins can read
+	if cfg.ModerationProvider != "" {
+		clientOpts, err := gcs.ClientOptions(credentials)
+		if err != nil {
+			log.Fatalf("Failed to load credentials: %v", err)
+		}
+		provider, err := moderation.NewGoogleProvider(ctx, cfg.GCSBucketName, clientOpts...)
+		if err != nil {
+			log.Fatalf("Failed to set up moderation: %v", err)
+		}
+		threshold, _ := moderation.ParseLikelihood(cfg.ModerationThreshold)
+		moderator := moderation.NewModerator(provider, objectStorage, moderation.Config{
+			Categories:       cfg.ModerationCategories,
+			Threshold:        threshold,
+			QuarantinePrefix: cfg.ModerationQuarantinePrefix,
+		})
+		moderationCfg := eventsCfg
+		moderationCfg.Timeout = cfg.ModerationTimeout
+		publisher := events.NewAsyncPublisher("moderation", moderator, moderationCfg)
+		defer publisher.Close()
+		serviceOpts = append(serviceOpts, service.WithPublisher(publisher))
+	}
+	serviceOpts = append(serviceOpts, service.WithQuarantine(cfg.ModerationQuarantinePrefix))
+
+	// Full-text search runs on an in-memory index filled by listing the bucket and
+	// kept current by the service's events and, optionally, bucket notifications
+	if cfg.SearchEnabled {
+		index := search.New(append(internalPrefixes(cfg), cfg.SearchExcludePrefixes...))
+		metrics.NewGaugeFunc("gcs_proxy_search_entries", "Objects in the search index", func() float64 {
+			return float64(index.Len())
+		})
+		go index.Sync(ctx, objectStorage, cfg.SearchResyncInterval)
+		serviceOpts = append(serviceOpts, service.WithPublisher(index), service.WithSearchIndex(index))
+
+		if cfg.SearchSubscription != "" {
+			subscription := cfg.SearchSubscription
+			if !strings.HasPrefix(subscription, "projects/") {
+				subscription = "projects/" + cfg.GCPProjectID + "/subscriptions/" + subscription
+			}
+			clientOpts, err := gcs.ClientOptions(credentials)
+			if err != nil {
+				log.Fatalf("Failed to load credentials: %v", err)
+			}
+			subscriber, err := events.NewPubSubSubscriber(ctx, subscription, clientOpts...)
+			if err != nil {
+				log.Fatalf("Failed to set up search notifications: %v", err)
+			}
+			subscriber.SetEventTypes(events.NotificationFinalize, events.NotificationDelete, events.NotificationMetadataUpdate)
+			go subscriber.Receive(ctx, index.HandleNotification)
+		}
+	}
+
+	// Live event streams follow the proxy's own events, or all changes to the
+	// bucket when its notifications are subscribed to. Redis relays the events
+	// between replicas, so every stream sees every write.
+	if cfg.EventsStreamEnabled {
+		broadcaster := events.NewBroadcaster(internalPrefixes(cfg))
+		metrics.NewGaugeFunc("gcs_proxy_event_stream_subscribers", "Clients following the event stream", func() float64 {
+			return float64(broadcaster.Len())
+		})
+		var streamPublisher events.Publisher = broadcaster
+		if redisClient != nil {
+			bus := redisstore.NewEventBus(redisClient, broadcaster)
+			go bus.Run(ctx)
+			streamPublisher = bus
+		}
+		serviceOpts = append(serviceOpts, service.WithEventStream(broadcaster))
+
+		if cfg.EventsStreamSubscription != "" {
+			subscription := cfg.EventsStreamSubscription
+			if !strings.HasPrefix(subscription, "projects/") {
+				subscription = "projects/" + cfg.GCPProjectID + "/subscriptions/" + subscription
+			}
+			clientOpts, err := gcs.ClientOptions(credentials)
+			if err != nil {
+				log.Fatalf("Failed to load credentials: %v", err)
+			}
+			subscriber, err := events.NewPubSubSubscriber(ctx, subscription, clientOpts...)
+			if err != nil {
+				log.Fatalf("Failed to set up event stream notifications: %v", err)
+			}
+			subscriber.SetEventTypes(events.NotificationFinalize, events.NotificationDelete, events.NotificationMetadataUpdate)
+			go subscriber.Receive(ctx, func(ctx context.Context, notification events.ObjectNotification) error {
+				if event, ok := events.NotificationEvent(notification); ok {
+					return streamPublisher.Publish(ctx, event)
+				}
+				return nil
+			})
+		} else {
+			serviceOpts = append(serviceOpts, service.WithPublisher(streamPublisher))
+		}
+	}
+
+	if cfg.LeasesEnabled {
+		var store lease.Store = lease.NewMemoryStore()
+		if redisClient != nil {
+			store = redisstore.NewLeaseStore(redisClient)
+		}
+		leases := lease.New(store, cfg.LeasesDefaultTTL, cfg.LeasesMaxTTL)
+		serviceOpts = append(serviceOpts, service.WithLeases(leases, cfg.LeasesRequired))
+	}
+
+	if cfg.LogsEnabled {
+		serviceOpts = append(serviceOpts, service.WithAppendLogs(appendlog.New(objectStorage, cfg.LogsPrefix, cfg.LogsMaxRecordBytes)))
+	}
+
+	if cfg.SiteEnabled {
+		serviceOpts = append(serviceOpts, service.WithSite(service.SiteConfig{
+			Prefix:      cfg.SitePrefix,
+			Index:       cfg.SiteIndex,
+			SPAFallback: cfg.SiteSPAFallback,
+			AssetMaxAge: cfg.SiteAssetMaxAge,
+		}))
+	}
+
+	// The change feed records the proxy's own events, or all changes to the bucket
+	// when its notifications are subscribed to
+	if cfg.ChangesEnabled {
+		var store changes.Store = changes.NewMemoryStore(cfg.ChangesRetention)
+		if redisClient != nil {
+			store = redisstore.NewChangeLog(redisClient, int64(cfg.ChangesRetention))
+		}
+		feed := changes.New(store, internalPrefixes(cfg))
+		serviceOpts = append(serviceOpts, service.WithChangeFeed(feed))
+
+		if cfg.ChangesSubscription != "" {
+			subscription := cfg.ChangesSubscription
+			if !strings.HasPrefix(subscription, "projects/") {
+				subscription = "projects/" + cfg.GCPProjectID + "/subscriptions/" + subscription
+			}
+			clientOpts, err := gcs.ClientOptions(credentials)
+			if err != nil {
+				log.Fatalf("Failed to load credentials: %v", err)
+			}
+			subscriber, err := events.NewPubSubSubscriber(ctx, subscription, clientOpts...)
+			if err != nil {
+				log.Fatalf("Failed to set up change feed notifications: %v", err)
+			}
+			subscriber.SetEventTypes(events.NotificationFinalize, events.NotificationDelete, events.NotificationMetadataUpdate)
+			go subscriber.Receive(ctx, feed.HandleNotification)
+		} else {
+			serviceOpts = append(serviceOpts, service.WithPublisher(feed))
+		}
+	}
+
+	// The catalog records every write and deletion through the proxy as it happens
+	var objectCatalog *catalog.Catalog
+	if cfg.CatalogDriver != "" {
+		objectCatalog, err = catalog.Open(ctx, cfg.CatalogDriver, cfg.CatalogDSN)
+		if err != nil {
+			log.Fatalf("Failed to open metadata catalog: %v", err)
+		}
+		defer objectCatalog.Close()
+		serviceOpts = append(serviceOpts, service.WithPublisher(objectCatalog), service.WithCatalog(objectCatalog, cfg.CatalogListings))
+	}
+
+	// Share links are kept in the catalog, which makes them survive restarts and work through every replica
+	if cfg.SharesEnabled {
+		var store share.Store = share.NewMemoryStore()
+		if objectCatalog != nil {
+			store = catalog.NewShareStore(objectCatalog)
+		} else {
+			log.Printf("Warning: share links are kept in memory; configure the catalog to persist them")
+		}
+		serviceOpts = append(serviceOpts, service.WithShares(share.New(store, cfg.SharesDefaultTTL, cfg.SharesMaxTTL)))
+	}
+
+	// Mounts added through the API are kept in the catalog, which makes them survive restarts and reach every replica
+	if cfg.MountsEnabled {
+		var store mount.Store = mount.NewMemoryStore()
+		if objectCatalog != nil {
+			store = catalog.NewMountStore(objectCatalog)
+		} else {
+			log.Printf("Warning: mounts added through the API are kept in memory; configure the catalog to persist them")
+		}
+		if err := mounts.Persist(ctx, store); err != nil {
+			log.Printf("Warning: failed to load mounts: %v", err)
+		}
+		if objectCatalog != nil {
+			go mounts.Run(ctx, cfg.MountsRefreshInterval)
+		}
+	}
+
+	// Downloads are summed in memory off the request path and flushed to the catalog when it is configured
+	var downloadStats *stats.Recorder
+	if cfg.StatsEnabled {
+		var store stats.Store = stats.NewMemoryStore()
+		if objectCatalog != nil {
+			store = catalog.NewStatsStore(objectCatalog)
+		}
+		downloadStats = stats.NewRecorder(store, cfg.StatsFlushInterval)
+		go downloadStats.Run(ctx)
+		serviceOpts = append(serviceOpts, service.WithDownloadStats(downloadStats))
+	}
+
+	// Writes and deletions are queued in the bucket and copied to the
+	// replication target in the background, retrying until it is reachable
+	var replicator *replication.Replicator
+	if cfg.ReplicationTarget != "" {
+		var target replication.Target
+		targetURL, _ := url.Parse(cfg.ReplicationTarget)
+		switch targetURL.Scheme {
+		case "gs":
+			targetCredentials := credentials
+			if cfg.ReplicationCredentials != "" {
+				targetCredentials = gcs.Credentials{Mode: gcs.CredentialsBase64JSON, Value: cfg.ReplicationCredentials}
+			}
+			client, err := gcs.NewClient(ctx, cfg.GCPProjectID, targetURL.Host, targetCredentials)
+			if err != nil {
+				log.Fatalf("Failed to create GCS client for replication bucket %s: %v", targetURL.Host, err)
+			}
+			defer client.Close()
+			target = storage.NewGCSStorage(client)
+		case "s3":
+			endpoint := cfg.ReplicationS3Endpoint
+			if endpoint == "" {
+				endpoint = "https://s3." + cfg.ReplicationS3Region + ".amazonaws.com"
+			}
+			target, err = replication.NewS3Target(endpoint, targetURL.Host, cfg.ReplicationS3Region, cfg.ReplicationS3AccessKeyID, cfg.ReplicationS3SecretAccessKey)
+			if err != nil {
+				log.Fatalf("Failed to set up replication: %v", err)
+			}
+		case "file":
+			target = replication.NewDirTarget(targetURL.Path)
+		}
+		replicator = replication.New(gcsStorage, gcsStorage, target, replication.Config{
+			Prefix:      cfg.ReplicationQueuePrefix,
+			Interval:    cfg.ReplicationInterval,
+			MaxAttempts: cfg.ReplicationMaxAttempts,
+			Exclude:     internalPrefixes(cfg),
+		})
+		go replicator.Run(ctx)
+		serviceOpts = append(serviceOpts, service.WithPublisher(replicator))
+	}
+
+	// Retried writes are recognized by every replica when their keys are kept in Redis or the catalog
+	idempotent := middleware.Middleware(func(next http.Handler) http.Handler { return next })
+	if cfg.IdempotencyEnabled {
+		var store idempotency.Store = idempotency.NewMemoryStore()
+		switch {
+		case redisClient != nil:
+			store = redisstore.NewIdempotencyStore(redisClient)
+		case objectCatalog != nil:
+			store = catalog.NewIdempotencyStore(objectCatalog)
+		}
+		idempotent = idempotency.New(store, cfg.IdempotencyTTL).Middleware
+	}
+
+	// Multipart uploads that outgrow their memory budget are spooled to disk
+	spooler, err := spool.New(cfg.SpoolDir, cfg.MaxSpoolBytes)
+	if err != nil {
+		log.Fatalf("Failed to set up upload spooling: %v", err)
+	}
+	metrics.NewGaugeFunc("gcs_proxy_spool_bytes", "Bytes of multipart uploads spooled to disk", func() float64 {
+		return float64(spooler.Used())
+	})
+
+	authenticator := auth.NewAPIKeyAuthenticator(nil)
+	handlerOpts := []handler.Option{
+		handler.WithLimits(cfg.MaxUploadBytes, cfg.MaxMultipartMemory),
+		handler.WithSpooler(spooler),
+	}
+	if cfg.DownloadURLSigningKey != "" {
+		signer := auth.NewURLSigner(cfg.DownloadURLSigningKey)
+		authenticator.AcceptSignedURLs(signer)
+		handlerOpts = append(handlerOpts, handler.WithSignedURLs(signer, cfg.DownloadURLTTL, cfg.PublicBaseURL))
+	}
+	if cfg.CDNSigningKey != "" {
+		signer, err := auth.NewCDNSigner(cfg.CDNSigningKeyName, cfg.CDNSigningKey, handler.DownloadPaths)
+		if err != nil {
+			log.Fatalf("Failed to set up CDN tokens: %v", err)
+		}
+		authenticator.AcceptCDNTokens(signer)
+		handlerOpts = append(handlerOpts, handler.WithCDNTokens(signer, cfg.CDNTokenTTL, cfg.CDNTokenMaxTTL, cfg.PublicBaseURL))
+		readCacheConfig.CDNSigner = signer
+	}
+	if readCache != nil {
+		serviceOpts = append(serviceOpts, service.WithReadCache(readCacheConfig))
+	}
+	// Replays sent to other replicas are only caught when signatures are kept in Redis
+	if cfg.SignedRequests {
+		var replays auth.ReplayCache
+		if redisClient != nil {
+			replays = redisstore.NewReplayCache(redisClient)
+		}
+		authenticator.AcceptSignedRequests(auth.NewRequestVerifier(cfg.SignedRequestMaxSkew, replays))
+	}
+
+	storageService := service.NewStorageService(objectStorage, serviceOpts...)
+	storageHandler := handler.NewStorageHandler(storageService, handlerOpts...)
+
+	// Directly uploaded objects are post-processed when their bucket notification arrives
+	if cfg.UploadSubscription != "" {
+		subscription := cfg.UploadSubscription
+		if !strings.HasPrefix(subscription, "projects/") {
+			subscription = "projects/" + cfg.GCPProjectID + "/subscriptions/" + subscription
+		}
+		clientOpts, err := gcs.ClientOptions(credentials)
+		if err != nil {
+			log.Fatalf("Failed to load credentials: %v", err)
+		}
+		subscriber, err := events.NewPubSubSubscriber(ctx, subscription, clientOpts...)
+		if err != nil {
+			log.Fatalf("Failed to set up upload notifications: %v", err)
+		}
+		go subscriber.Receive(ctx, storageService.CompleteUpload)
+	}
+
+	// Admins can transfer objects between the served bucket and the configured ones
+	transfer := storage.NewGCSTransfer()
+	transfer.AddBucket(service.DefaultBucket, gcsClient, credentials)
+	for _, bucket := range cfg.TransferBuckets {
+		projectID, bucketCredentials := bucket.ProjectID, credentials
+		if projectID == "" {
+			projectID = cfg.GCPProjectID
+		}
+		if bucket.Credentials != "" {
+			bucketCredentials = gcs.Credentials{Mode: gcs.CredentialsBase64JSON, Value: bucket.Credentials}
+		}
+		client, err := gcs.NewClient(ctx, projectID, bucket.Bucket, bucketCredentials)
+		if err != nil {
+			log.Fatalf("Failed to create GCS client for transfer bucket %s: %v", bucket.Name, err)
+		}
+		defer client.Close()
+		transfer.AddBucket(bucket.Name, client, bucketCredentials)
+	}
+
+	jobManager := jobs.NewManager(cfg.JobWorkers, cfg.JobQueueSize, cfg.JobRetention)
+	defer jobManager.Close()
+	jobService := service.NewJobService(objectStorage, jobManager, service.JobConfig{
+		ResultPrefix: cfg.JobResultPrefix,
+		Trash: service.TrashConfig{
+			Prefix:    cfg.TrashPrefix,
+			Retention: cfg.TrashRetention,
+		},
+		GC: service.GCConfig{
+			Prefixes: cfg.GCPrefixes,
+			TTL:      cfg.GCTTL,
+		},
+		Transfer:         transfer,
+		QuarantinePrefix: cfg.ModerationQuarantinePrefix,
+		Verify:           verifyConfig(cfg, replicator),
+		Envelope:         envelopeEnabled,
+		ExpiryPrefix:     cfg.ExpiryIndexPrefix,
+	})
+	jobHandler := handler.NewJobHandler(jobService)
+
+	// With several replicas, only the one holding the election lock runs periodic jobs
+	var leader scheduler.Leader
+	if cfg.JobElection != "" {
+		prefix := strings.Trim(cfg.JobElectionPrefix, "/")
+		var store lease.Store = storage.NewGCSLeaseStore(gcsClient, prefix)
+		path := "scheduler"
+		if cfg.JobElection == "redis" {
+			// Kept apart from the leases clients take on paths
+			store, path = redisstore.NewLeaseStore(redisClient), prefix+"/scheduler"
+		}
+		hostname, _ := os.Hostname()
+		elector := election.New(store, path, fmt.Sprintf("%s/%d", hostname, os.Getpid()), cfg.JobElectionTTL)
+		metrics.NewGaugeFunc("gcs_proxy_scheduler_leader", "1 while this replica leads and runs periodic jobs", func() float64 {
+			if elector.IsLeader() {
+				return 1
+			}
+			return 0
+		})
+		go elector.Run(ctx)
+		leader = elector
+	}
+	// Periodic jobs run every interval, or on the cron schedules configured for them
+	jobScheduler := scheduler.New(cfg.JobScheduleJitter, leader)
+	// Deleted files are purged once they have been in the trash for the retention
+	if cfg.TrashPrefix != "" {
+		scheduleJob(jobScheduler, jobService, cfg.JobSchedules, service.JobPurgeTrash, cfg.TrashPurgeInterval)
+	}
+	// Chunks and other temporary objects left behind by abandoned uploads are collected
+	if len(cfg.GCPrefixes) > 0 {
+		scheduleJob(jobScheduler, jobService, cfg.JobSchedules, service.JobGC, cfg.GCInterval)
+	}
+	// Objects written with an expiry are deleted once it passes
+	if cfg.ExpiryIndexPrefix != "" {
+		scheduleJob(jobScheduler, jobService, cfg.JobSchedules, service.JobExpire, cfg.ExpiryInterval)
+	}
+	// A sample of the replicas is compared with the objects to catch drift
+	if replicator != nil && (cfg.ReplicationVerifyInterval > 0 || cfg.JobSchedules[service.JobVerifyReplication] != "") {
+		scheduleJob(jobScheduler, jobService, cfg.JobSchedules, service.JobVerifyReplication, cfg.ReplicationVerifyInterval)
+	}
+	go jobScheduler.Run(ctx)
+
+	adminService := service.NewAdminService(storage.NewGCSBucketAdmin(gcsClient))
+	adminHandler := handler.NewAdminHandler(adminService)
+
+	readiness := health.NewChecker(gcsClient.CheckBucket, cfg.ReadinessCacheTTL, cfg.ReadinessTimeout)
+	// Maintenance mode refuses every request but the probes, metrics and the
+	// route turning it off, and fails readiness so load balancers drain the replica
+	page, err := maintenancePage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load maintenance page: %v", err)
+	}
+	maintenanceMode := maintenance.New(page, "/health", "/healthz", "/readyz", "/version", "/metrics", handler.MaintenancePath)
+	if cfg.MaintenanceEnabled {
+		maintenanceMode.Enable("", "")
+		log.Printf("Starting in maintenance mode")
+	}
+	healthHandler := handler.NewHealthHandler(readiness, maintenanceMode)
+
+	// Setup routes; every route documents its operations in the OpenAPI spec
+	spec := openapi.New("gcp-proxy-mity", "1.0")
+	router := handler.NewRouter(spec)
+	storageHandler.SetupRoutes(router)
+	jobHandler.SetupRoutes(router)
+	healthHandler.SetupRoutes(router)
+	adminHandler.SetupRoutes(router)
+	handler.NewMaintenanceHandler(maintenanceMode).SetupRoutes(router)
+	if cfg.MountsEnabled {
+		handler.NewMountHandler(service.NewMountService(mounts)).SetupRoutes(router)
+	}
+	if cfg.SharesEnabled {
+		shareHandler := handler.NewShareHandler(storageService, cfg.PublicBaseURL)
+		shareHandler.SetupRoutes(router)
+		authenticator.AllowAnonymous(shareHandler.Anonymous(router))
+	}
+	if cfg.UIEnabled {
+		uiHandler := handler.NewUIHandler()
+		uiHandler.SetupRoutes(router)
+		authenticator.AllowAnonymous(uiHandler.Anonymous(router))
+	}
+	// The website takes every GET request no other route serves
+	if cfg.SiteEnabled {
+		siteHandler := handler.NewSiteHandler(storageService, cfg.SitePublic)
+		siteHandler.SetupRoutes(router)
+		authenticator.AllowAnonymous(siteHandler.Anonymous(router))
+	}
+	router.Handle("GET /metrics", metrics.Handler(), openapi.Operation{
+		ID:        "metrics",
+		Tag:       "metrics",
+		Summary:   "Prometheus metrics",
+		Responses: []openapi.Response{{Status: http.StatusOK, Description: "Metrics in the Prometheus text format", Body: &openapi.Body{ContentType: "text/plain", Schema: ""}}},
+	})
+	// Profiles expose the process' internals, so only admins get them on the API port
+	if cfg.DebugEnabled && cfg.DebugAddr == "" {
+		debug := auth.RequireScope(auth.ScopeAdmin, diagnostics.Handler())
+		router.Handle("GET /debug/pprof/{profile...}", debug, openapi.Operation{
+			ID:          "profile",
+			Tag:         "debug",
+			Summary:     "Runtime profiles of net/http/pprof",
+			Description: "Lists the profiles without a name. Use with go tool pprof.",
+			Params:      []openapi.Param{openapi.PathParam("profile", "Profile, like heap, goroutine or profile")},
+			Responses:   []openapi.Response{{Status: http.StatusOK, Description: "The profile", Body: &openapi.Body{ContentType: "application/octet-stream", Schema: ""}}},
+			Errors:      []int{http.StatusForbidden, http.StatusNotFound},
+		})
+		router.Handle("GET /debug/vars", debug, openapi.Operation{
+			ID:        "debugVars",
+			Tag:       "debug",
+			Summary:   "Runtime variables of expvar",
+			Responses: []openapi.Response{{Status: http.StatusOK, Description: "Goroutines, memory and GC statistics and build information", Body: &openapi.Body{ContentType: "application/json", Schema: map[string]any{}}}},
+			Errors:    []int{http.StatusForbidden},
+		})
+	}
+	router.Handle("GET /openapi.json", spec, openapi.Operation{
+		ID:        "openAPI",
+		Tag:       "docs",
+		Summary:   "This OpenAPI document",
+		Responses: []openapi.Response{{Status: http.StatusOK, Description: "OpenAPI 3 document", Body: &openapi.Body{ContentType: "application/json", Schema: map[string]any{}}}},
+		Public:    true,
+	})
+	if cfg.SwaggerUI {
+		router.Handle("GET /docs", spec.SwaggerUI("/openapi.json"), openapi.Operation{
+			ID:        "swaggerUI",
+			Tag:       "docs",
+			Summary:   "Swagger UI for this document",
+			Responses: []openapi.Response{{Status: http.StatusOK, Description: "HTML page", Body: &openapi.Body{ContentType: "text/html", Schema: ""}}},
+			Public:    true,
+		})
+	}
+
+	limiter := ratelimit.New(0, 0)
+	if redisClient != nil {
+		limiter.SetShared(redisstore.NewRateLimiter(redisClient))
+	}
+	bandwidth := throttle.New(throttle.Config{})
+	hotlinks := hotlink.New(hotlink.Config{})
+	access := handler.ObjectAccess(router)
+	// Google identities asserted by Identity-Aware Proxy are limited to their grants
+	identities := iap.NewAuthorizer(access)
+	// The access policy decides who may read, write, delete and list which objects
+	actions := handler.ObjectAction(router)
+	policies := policy.New(actions)
+	enforce := middleware.Middleware(func(next http.Handler) http.Handler { return next })
+	if cfg.PolicyEnabled {
+		enforce = policies.Middleware
+		handler.NewPolicyHandler(policies).SetupRoutes(router)
+	}
+	if cfg.PolicyEnabled && cfg.PolicyObject != "" {
+		source := "gs://" + cfg.GCSBucketName + "/" + strings.TrimPrefix(cfg.PolicyObject, "/")
+		readPolicy := func(ctx context.Context) ([]byte, error) {
+			file, err := gcsStorage.ReadFile(ctx, cfg.PolicyObject)
+			if err != nil {
+				return nil, err
+			}
+			return file.Content, nil
+		}
+		policies.Protect(cfg.PolicyObject)
+		if err := policies.Refresh(ctx, source, readPolicy); err != nil {
+			log.Fatalf("Failed to load the access policy from %s: %v", source, err)
+		}
+		go policies.Run(ctx, source, readPolicy, cfg.PolicyRefreshInterval)
+	}
+	// An external authorizer decides after the policy, when one is configured
+	delegate := middleware.Middleware(func(next http.Handler) http.Handler { return next })
+	var authorizer *policy.Webhook
+	if cfg.AuthorizerURL != "" {
+		authorizer = policy.NewWebhook(actions, policy.WebhookConfig{
+			URL:      cfg.AuthorizerURL,
+			Secret:   cfg.AuthorizerSecret,
+			Timeout:  cfg.AuthorizerTimeout,
+			CacheTTL: cfg.AuthorizerCacheTTL,
+			FailOpen: cfg.AuthorizerFailOpen,
+		})
+		delegate = authorizer.Middleware
+	}
+	// Raw uploads can be polled for their progress while they run
+	tracked := middleware.Middleware(func(next http.Handler) http.Handler { return next })
+	if cfg.UploadProgressEnabled {
+		uploads := progress.New(handler.RawUpload(router), progress.Config{
+			MinBytes: cfg.UploadProgressMinBytes,
+			TTL:      cfg.UploadProgressTTL,
+		})
+		tracked = uploads.Middleware
+		handler.NewTransferHandler(uploads).SetupRoutes(router)
+	}
+	writes := func(r *http.Request) bool {
+		_, write := access(r)
+		return write
+	}
+	addresses := ipfilter.New(writes, ipfilter.Config{})
+	// Liveness probes keep passing while uploads drain on shutdown
+	transfers := drain.New(writes, "/healthz")
+	// Load is shed before the process runs out of memory, sparing probes and metrics
+	admissions := admission.New(writes, "/healthz", "/readyz", "/metrics", "/debug/")
+	if cfg.IAPEnabled {
+		validator, err := idtoken.NewValidator(ctx)
+		if err != nil {
+			log.Fatalf("Failed to set up IAP assertion checks: %v", err)
+		}
+		authenticator.AcceptIdentities(iap.NewVerifier(validator, cfg.IAPAudience).Identify)
+	}
+	// People log in with their Google account and use the API with a session cookie
+	var login *oidc.Login
+	if cfg.OIDCEnabled {
+		provider, err := oidc.NewGoogleProvider(ctx, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL, cfg.OIDCHostedDomain)
+		if err != nil {
+			log.Fatalf("Failed to set up the OIDC login: %v", err)
+		}
+		clientOpts, err := gcs.ClientOptions(credentials)
+		if err != nil {
+			log.Fatalf("Failed to load credentials: %v", err)
+		}
+		groups, err := oidc.NewCloudIdentityGroups(ctx, clientOpts...)
+		if err != nil {
+			log.Fatalf("Failed to set up group lookups: %v", err)
+		}
+		// Validated with the config, so the key decodes
+		sessionKey, _ := base64.StdEncoding.DecodeString(cfg.OIDCSessionKey)
+		login, err = oidc.New(provider, groups, oidc.Config{
+			RedirectURL:  cfg.OIDCRedirectURL,
+			HostedDomain: cfg.OIDCHostedDomain,
+			SessionKey:   sessionKey,
+			SessionTTL:   cfg.OIDCSessionTTL,
+		})
+		if err != nil {
+			log.Fatalf("Failed to set up the OIDC login: %v", err)
+		}
+		loginHandler := handler.NewLoginHandler(login)
+		loginHandler.SetupRoutes(router)
+		authenticator.AllowAnonymous(loginHandler.Anonymous(router))
+		authenticator.AcceptSessions(login.Authenticate)
+	}
+
+	s3Bucket := cfg.S3Bucket
+	if s3Bucket == "" {
+		s3Bucket = cfg.GCSBucketName
+	}
+	s3Verifier := s3.NewVerifier(cfg.S3Region, nil)
+	s3Handler := s3.NewHandler(storageService, s3Verifier, s3Bucket, cfg.S3Region, cfg.MaxUploadBytes)
+	// The gateways are held to the access policy and the authorizer like the storage API
+	if cfg.PolicyEnabled {
+		s3Handler.Use(policies.MiddlewareFor(s3.Action(s3Bucket)))
+	}
+	if authorizer != nil {
+		s3Handler.Use(authorizer.MiddlewareFor(s3.Action(s3Bucket)))
+	}
+
+	var sftpServer *sftpd.Server
+	if cfg.SFTPPort != "" {
+		hostKey, err := sftpd.LoadHostKey(cfg.SFTPHostKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load SFTP host key: %v", err)
+		}
+		sftpServer = sftpd.NewServer(storageService, hostKey, cfg.MaxUploadBytes)
+		if cfg.PolicyEnabled {
+			sftpServer.AddCheck(policies.Check)
+		}
+		if authorizer != nil {
+			sftpServer.AddCheck(authorizer.Check)
+		}
+	}
+
+	// Tunable settings are applied at startup and again on every config reload
+	applyTunables := func(c *config.Config) {
+		keys := make([]auth.Key, 0, len(c.APIKeys))
+		for _, key := range c.APIKeys {
+			keys = append(keys, auth.Key{Name: key.Name, Key: key.Key, Scopes: key.Scopes})
+		}
+		authenticator.SetKeys(keys)
+		keyQuotas := make(map[string]quota.Limits)
+		for _, key := range c.APIKeys {
+			if key.Quota != nil {
+				keyQuotas[key.Name] = quotaLimits(*key.Quota)
+			}
+		}
+		quotas.SetLimits(quotaLimits(c.Quota), keyQuotas)
+		serviceAccounts := make(map[string]string)
+		for _, key := range c.APIKeys {
+			if key.ServiceAccount != "" {
+				serviceAccounts[key.Name] = key.ServiceAccount
+			}
+		}
+		buckets.setServiceAccounts(ctx, serviceAccounts)
+		s3Keys := make(map[string]string, len(c.S3AccessKeys))
+		for _, key := range c.S3AccessKeys {
+			s3Keys[key.AccessKeyID] = key.SecretAccessKey
+		}
+		s3Verifier.SetKeys(s3Keys)
+		if sftpServer != nil {
+			sftpServer.SetUsers(sftpUsers(c.SFTPUsers))
+		}
+		limiter.SetLimit(c.RateLimit, c.RateLimitBurst)
+		bandwidth.SetConfig(throttleConfig(c.Bandwidth))
+		admissions.SetConfig(admission.Config(c.Admission))
+		hotlinks.SetConfig(hotlinkConfig(c.HotlinkConfig))
+		addresses.SetConfig(ipFilterConfig(c.IPFilterConfig))
+		identities.SetGrants(iapGrants(c.IAPGrants))
+		if login != nil {
+			login.SetRoles(oidcRoles(c.OIDCRoles))
+		}
+		if cfg.PolicyObject == "" {
+			if err := policies.SetRules("config", policyRules(c.PolicyRules)); err != nil {
+				log.Printf("Failed to apply the access policy, keeping the previous rules: %v", err)
+			}
+		}
+		storageService.SetAllowedContentTypes(c.AllowedContentTypes)
+		storageService.SetTrustClientContentTypes(c.TrustContentTypes)
+		if page, err := maintenancePage(c); err != nil {
+			log.Printf("Failed to load maintenance page, keeping the previous one: %v", err)
+		} else {
+			maintenanceMode.SetPage(page)
+		}
+
+		var level slog.Level
+		level.UnmarshalText([]byte(c.LogLevel))
+		slog.SetLogLoggerLevel(level)
+	}
+	applyTunables(cfg)
+
+	reloader := config.NewReloader(*configPath, cfg, applyTunables)
+	go reloader.Run(ctx, cfg.ConfigWatchInterval)
+
+	// Clients behind the trusted load balancers are resolved first, for the
+	// logs, rate limits, IP filter and generated links. Validated with the
+	// config, so the ranges parse.
+	trustedProxies, _ := clientip.ParsePrefixes(cfg.TrustedProxies)
+	clients := clientip.New(trustedProxies)
+
+	secure := middleware.Middleware(func(next http.Handler) http.Handler { return next })
+	if cfg.SecurityHeadersEnabled {
+		secure = middleware.Security(securityConfig(cfg.HeadersConfig))
+	}
+
+	router.Use(clients.Middleware, middleware.RequestID, middleware.Version(build.String()), secure, middleware.Logger, middleware.Metrics, middleware.Recover, transfers.Middleware, maintenanceMode.Middleware, admissions.Middleware, addresses.Middleware, authenticator.Middleware, identities.Middleware, enforce, delegate, hotlinks.Middleware, limiter.Middleware, idempotent, bandwidth.Middleware, tracked)
+	server := newHTTPServer(cfg, router)
+
+	if cfg.TLSEnabled() {
+		server.TLSConfig, err = tlsconfig.New(tlsconfig.Options{
+			CertFile:         cfg.TLSCertFile,
+			KeyFile:          cfg.TLSKeyFile,
+			AutocertDomains:  cfg.TLSAutocertDomains,
+			AutocertCacheDir: cfg.TLSAutocertCacheDir,
+			AutocertEmail:    cfg.TLSAutocertEmail,
+			ClientCAFile:     cfg.TLSClientCAFile,
+			ClientAuth:       cfg.TLSClientAuth,
+		})
+		if err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+	}
+
+	// Sockets passed by systemd are used instead of binding the ports
+	listeners := listener.Config{ReusePort: cfg.ReusePort}
+	go serve(server, "Server", listeners, listener.HTTP)
+
+	// The S3 API signs requests itself, so it bypasses API key authentication
+	var s3Server *http.Server
+	if cfg.S3Port != "" {
+		s3Server = newHTTPServer(cfg, middleware.Chain(s3Handler, clients.Middleware, middleware.RequestID, middleware.Version(build.String()), secure, middleware.Logger, middleware.Metrics, middleware.Recover, transfers.Middleware, maintenanceMode.Middleware, admissions.Middleware, addresses.Middleware, limiter.Middleware, bandwidth.Middleware))
+		s3Server.Addr = ":" + cfg.S3Port
+		s3Server.TLSConfig = server.TLSConfig
+		go serve(s3Server, "S3 API", listeners, listener.S3)
+	}
+
+	// Without authentication on its own address, so it must not be reachable from outside
+	var debugServer *http.Server
+	if cfg.DebugEnabled && cfg.DebugAddr != "" {
+		debugServer = newHTTPServer(cfg, middleware.Chain(diagnostics.Handler(), middleware.RequestID, middleware.Logger, middleware.Recover))
+		debugServer.Addr = cfg.DebugAddr
+		go serve(debugServer, "Debug server", listeners, listener.Debug)
+	}
+
+	if sftpServer != nil {
+		go func() {
+			l, err := listeners.Listen(listener.SFTP, ":"+cfg.SFTPPort)
+			if err != nil {
+				log.Fatalf("SFTP gateway failed to start: %v", err)
+			}
+			log.Printf("SFTP gateway starting on %s", l.Addr())
+			if err := sftpServer.Serve(l); err != nil {
+				log.Fatalf("SFTP gateway failed to start: %v", err)
+			}
+		}()
+	}
 
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down server...")
+	log.Printf("Shutting down server, %d requests in flight...", transfers.InFlight())
 
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// New requests are refused while those in flight finish; reads are cut
+	// off after ShutdownTimeout and writes after ShutdownUploadTimeout
+	drained := make(chan []drain.Transfer, 1)
+	go func() {
+		drained <- transfers.Drain(cfg.ShutdownTimeout, cfg.ShutdownUploadTimeout)
+	}()
+	// Canceled handlers get a few seconds to return
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownUploadTimeout+5*time.Second)
 	defer shutdownCancel()
 
+	if sftpServer != nil {
+		if err := sftpServer.Close(); err != nil {
+			log.Printf("SFTP gateway forced to shutdown: %v", err)
+		}
+	}
+	if debugServer != nil {
+		if err := debugServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Debug server forced to shutdown: %v", err)
+		}
+	}
+	if s3Server != nil {
+		if err := s3Server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("S3 API forced to shutdown: %v", err)
+		}
+	}
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	for _, transfer := range <-drained {
+		log.Printf("Shutdown cut off %s %s after %s (request %s)", transfer.Method, transfer.Path, time.Since(transfer.Started).Round(time.Second), transfer.RequestID)
+	}
+	// Downloads counted since the last flush would be lost on exit
+	if downloadStats != nil {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer flushCancel()
+		if err := downloadStats.Flush(flushCtx); err != nil {
+			log.Printf("Failed to record download statistics: %v", err)
+		}
+	}
 
 	log.Println("Server exited")
 }
+
+// serve runs the server on the socket named socket until it is shut down
+func serve(server *http.Server, name string, listeners listener.Config, socket string) {
+	l, err := listeners.Listen(socket, server.Addr)
+	if err != nil {
+		log.Fatalf("%s failed to start: %v", name, err)
+	}
+	if server.TLSConfig != nil {
+		log.Printf("%s starting with TLS on %s", name, l.Addr())
+		// Certificates come from TLSConfig
+		err = server.ServeTLS(l, "", "")
+	} else {
+		log.Printf("%s starting on %s", name, l.Addr())
+		err = server.Serve(l)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("%s failed to start: %v", name, err)
+	}
+}
+
+// sftpUsers converts the configured SFTP accounts; keys were checked by Validate
+func sftpUsers(users []config.SFTPUser) []sftpd.User {
+	converted := make([]sftpd.User, 0, len(users))
+	for _, user := range users {
+		keys := make([]ssh.PublicKey, 0, len(user.AuthorizedKeys))
+		for _, authorized := range user.AuthorizedKeys {
+			if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorized)); err == nil {
+				keys = append(keys, key)
+			}
+		}
+		converted = append(converted, sftpd.User{Name: user.Name, AuthorizedKeys: keys, Prefix: user.Prefix})
+	}
+	return converted
+}
+
+// scheduleJob queues a job of the given type every interval, or on the cron
+// schedule configured for the type instead
+func scheduleJob(s *scheduler.Scheduler, jobService *service.JobService, schedules map[string]string, jobType string, interval time.Duration) {
+	var schedule scheduler.Schedule = scheduler.Every(interval)
+	if spec := schedules[jobType]; spec != "" {
+		// Schedules are checked when the config is validated
+		schedule, _ = scheduler.Parse(spec)
+	}
+	s.Add(scheduler.Task{Name: jobType, Schedule: schedule, Run: func(ctx context.Context) error {
+		_, err := jobService.Create(ctx, jobs.Spec{Type: jobType})
+		return err
+	}})
+}
+
+// verifyConfig disables replication verification unless replication is configured
+func verifyConfig(cfg *config.Config, replicator *replication.Replicator) service.VerifyConfig {
+	if replicator == nil {
+		return service.VerifyConfig{}
+	}
+	return service.VerifyConfig{
+		Replicas: replicator,
+		Sample:   cfg.ReplicationVerifySample,
+		Repair:   cfg.ReplicationVerifyRepair,
+		Exclude:  internalPrefixes(cfg),
+	}
+}
+
+// envelopeKeyring wraps new data keys with the KMS key, or else the master key.
+// Master keys stay available to unwrap the data keys of older objects.
+func envelopeKeyring(ctx context.Context, cfg *config.Config, credentials gcs.Credentials) (*envelope.Keyring, error) {
+	var wrappers []envelope.KeyWrapper
+	if cfg.EnvelopeKMSKey != "" {
+		clientOpts, err := gcs.ClientOptions(credentials)
+		if err != nil {
+			return nil, err
+		}
+		wrapper, err := envelope.NewKMSWrapper(ctx, cfg.EnvelopeKMSKey, clientOpts...)
+		if err != nil {
+			return nil, err
+		}
+		wrappers = append(wrappers, wrapper)
+	}
+	for _, encoded := range append([]string{cfg.EnvelopeMasterKey}, cfg.EnvelopePreviousMasterKeys...) {
+		if encoded == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		wrapper, err := envelope.NewLocalWrapper(key)
+		if err != nil {
+			return nil, err
+		}
+		wrappers = append(wrappers, wrapper)
+	}
+	return envelope.NewKeyring(wrappers[0], wrappers[1:]...), nil
+}
+
+// hotlinkConfig protects nothing unless hotlink protection is enabled
+func hotlinkConfig(h config.HotlinkConfig) hotlink.Config {
+	if !h.HotlinkEnabled {
+		return hotlink.Config{}
+	}
+	return hotlink.Config{
+		Paths:          h.HotlinkPaths,
+		AllowedOrigins: h.HotlinkAllowedOrigins,
+		AllowEmpty:     h.HotlinkAllowEmptyReferer,
+	}
+}
+
+func ipFilterConfig(c config.IPFilterConfig) ipfilter.Config {
+	allow, _ := clientip.ParsePrefixes(c.IPAllow)
+	deny, _ := clientip.ParsePrefixes(c.IPDeny)
+	internal, _ := clientip.ParsePrefixes(c.InternalRanges)
+	return ipfilter.Config{Allow: allow, Deny: deny, PrivateWrites: c.PrivateWrites, Internal: internal}
+}
+
+func iapGrants(grants []config.IAPGrant) []iap.Grant {
+	converted := make([]iap.Grant, 0, len(grants))
+	for _, grant := range grants {
+		converted = append(converted, iap.Grant{Members: grant.Members, Prefix: grant.Prefix, Access: grant.Access})
+	}
+	return converted
+}
+
+func oidcRoles(roles []config.OIDCRole) []oidc.Role {
+	converted := make([]oidc.Role, 0, len(roles))
+	for _, role := range roles {
+		converted = append(converted, oidc.Role{Members: role.Members, Scopes: role.Scopes})
+	}
+	return converted
+}
+
+func policyRules(rules []config.PolicyRule) []policy.Rule {
+	converted := make([]policy.Rule, 0, len(rules))
+	for _, rule := range rules {
+		converted = append(converted, policy.Rule{Name: rule.Name, Principals: rule.Principals, Actions: rule.Actions, Paths: rule.Paths, Effect: rule.Effect})
+	}
+	return converted
+}
+
+func securityConfig(h config.HeadersConfig) middleware.SecurityConfig {
+	cfg := middleware.SecurityConfig{
+		SecurityHeaders: middleware.SecurityHeaders{
+			ContentSecurityPolicy: h.ContentSecurityPolicy,
+			FrameOptions:          h.FrameOptions,
+			ReferrerPolicy:        h.ReferrerPolicy,
+		},
+		HSTSMaxAge:            h.HSTSMaxAge,
+		HSTSIncludeSubdomains: h.HSTSIncludeSubdomains,
+	}
+	for _, route := range h.SecurityHeaderRoutes {
+		cfg.Routes = append(cfg.Routes, middleware.SecurityRoute{
+			Prefix: route.Prefix,
+			SecurityHeaders: middleware.SecurityHeaders{
+				ContentSecurityPolicy: route.ContentSecurityPolicy,
+				FrameOptions:          route.FrameOptions,
+				ReferrerPolicy:        route.ReferrerPolicy,
+			},
+		})
+	}
+	return cfg
+}
+
+func throttleConfig(b config.Bandwidth) throttle.Config {
+	cfg := throttle.Config{
+		Request: throttle.Limits{Download: b.DownloadBytes, Upload: b.UploadBytes},
+		Tenant:  throttle.Limits{Download: b.TenantDownloadBytes, Upload: b.TenantUploadBytes},
+	}
+	for _, route := range b.Routes {
+		cfg.Routes = append(cfg.Routes, throttle.Route{
+			Prefix: route.Prefix,
+			Limits: throttle.Limits{Download: route.DownloadBytes, Upload: route.UploadBytes},
+		})
+	}
+	return cfg
+}
+
+func quotaLimits(q config.Quota) quota.Limits {
+	return quota.Limits{
+		DailyBytes:     q.DailyBytes,
+		DailyObjects:   q.DailyObjects,
+		MonthlyBytes:   q.MonthlyBytes,
+		MonthlyObjects: q.MonthlyObjects,
+	}
+}
+
+// internalPrefixes returns the prefixes of the proxy's own objects, which are
+// kept out of search, the change feed and the event stream
+func internalPrefixes(cfg *config.Config) []string {
+	var prefixes []string
+	for _, prefix := range []string{cfg.DedupPrefix, cfg.TrashPrefix, cfg.TagIndexPrefix, cfg.EventsDeadLetterPrefix, cfg.JobResultPrefix, cfg.UploadRegistrationPrefix, cfg.LogsPrefix, cfg.ReplicationQueuePrefix, cfg.ExpiryIndexPrefix, cfg.JobElectionPrefix} {
+		if prefix = strings.Trim(prefix, "/"); prefix != "" {
+			prefixes = append(prefixes, prefix+"/")
+		}
+	}
+	return prefixes
+}
+
+// newHTTPServer creates the HTTP server with the configured protocols, limits and timeouts
+func newHTTPServer(cfg *config.Config, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		Protocols:         new(http.Protocols),
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: cfg.HTTP2MaxConcurrentStreams,
+		},
+	}
+
+	server.Protocols.SetHTTP1(true)
+	// HTTP/2 is negotiated over TLS; h2c serves it on plain connections, e.g. behind Cloud Run
+	server.Protocols.SetHTTP2(cfg.HTTP2)
+	server.Protocols.SetUnencryptedHTTP2(cfg.H2C)
+
+	server.SetKeepAlivesEnabled(cfg.KeepAlives)
+	return server
+}
+
+// maintenancePage returns the response to requests during maintenance
+func maintenancePage(c *config.Config) (maintenance.Page, error) {
+	page := maintenance.Page{Message: c.MaintenanceMessage, RetryAfter: c.MaintenanceRetryAfter}
+	if c.MaintenancePageFile != "" {
+		html, err := template.ParseFiles(c.MaintenancePageFile)
+		if err != nil {
+			return page, err
+		}
+		page.HTML = html
+	}
+	return page, nil
+}
+
+// newGCSClient creates a client of bucket with the configured credentials, or of the emulator
+func newGCSClient(ctx context.Context, cfg *config.Config, bucket string) (*gcs.Client, error) {
+	if cfg.GCSEmulatorHost != "" {
+		return gcs.NewEmulatorClient(ctx, cfg.GCPProjectID, bucket, cfg.GCSEmulatorHost)
+	}
+	return gcs.NewClient(ctx, cfg.GCPProjectID, bucket, gcsCredentials(cfg))
+}
+
+func gcsCredentials(cfg *config.Config) gcs.Credentials {
+	return gcs.Credentials{
+		Mode:           cfg.GoogleCredentialsMode,
+		Value:          cfg.GoogleCredentials,
+		ServiceAccount: cfg.GoogleImpersonateServiceAccount,
+	}
+}
+
+// runCheck checks the configuration, the credentials and what the server
+// needs to do with the bucket, prints a report and returns the process exit
+// code. A test object is written under checkPrefix and deleted.
+func runCheck(cfg *config.Config) int {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	results := []selfcheck.Result{selfcheck.Run("config", func() (string, error) {
+		return cfg.GCSBucketName, cfg.Validate()
+	})}
+	var client *gcs.Client
+	results = append(results, selfcheck.Run("credentials", func() (string, error) {
+		var err error
+		client, err = newGCSClient(ctx, cfg, cfg.GCSBucketName)
+		if cfg.GCSEmulatorHost != "" {
+			return "emulator at " + cfg.GCSEmulatorHost, err
+		}
+		mode := gcsCredentials(cfg).ResolvedMode()
+		if mode == gcs.CredentialsImpersonation {
+			mode += " of " + cfg.GoogleImpersonateServiceAccount
+		}
+		return mode, err
+	}))
+	if client != nil {
+		defer client.Close()
+		results = append(results, selfcheck.CheckBucket(ctx, client, checkPrefix, cfg.GCSEmulatorHost == "")...)
+	}
+	if cfg.RedisURL != "" {
+		results = append(results, selfcheck.Run("redis", func() (string, error) {
+			redisClient, err := redisstore.New(cfg.RedisURL, cfg.RedisKeyPrefix)
+			if err != nil {
+				return "", err
+			}
+			defer redisClient.Close()
+			return "", redisClient.Ping(ctx)
+		}))
+	}
+
+	if !selfcheck.Print(os.Stdout, results) {
+		fmt.Fprintln(os.Stderr, "Self-check failed")
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "Self-check passed")
+	return 0
+}
+
+// printConfig prints the effective configuration with secrets redacted and
+// returns the process exit code
+func printConfig(cfg *config.Config) int {
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to render configuration: %v\n", err)
+		return 1
+	}
+	fmt.Print(string(out))
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration is invalid:\n%v\n", err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "Configuration is valid")
+	return 0
+}
+
+// bucketSet holds the buckets files are stored in, which mounts added at
+// runtime join and leave, so the keyring and the service accounts of every
+// reload reach all of them
+type bucketSet struct {
+	mu       sync.Mutex
+	buckets  []*storage.GCSStorage
+	keyring  *envelope.Keyring
+	accounts map[string]string
+}
+
+// add sets the keyring and service accounts of the set on bucket and adds it
+func (b *bucketSet) add(ctx context.Context, bucket *storage.GCSStorage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.keyring != nil {
+		bucket.SetKeyring(b.keyring)
+	}
+	if b.accounts != nil {
+		if err := bucket.SetServiceAccounts(ctx, b.accounts); err != nil {
+			log.Printf("Failed to impersonate service accounts: %v", err)
+		}
+	}
+	b.buckets = append(b.buckets, bucket)
+}
+
+func (b *bucketSet) remove(bucket *storage.GCSStorage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buckets = slices.DeleteFunc(b.buckets, func(other *storage.GCSStorage) bool { return other == bucket })
+}
+
+func (b *bucketSet) setKeyring(keyring *envelope.Keyring) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.keyring = keyring
+	for _, bucket := range b.buckets {
+		bucket.SetKeyring(keyring)
+	}
+}
+
+func (b *bucketSet) setServiceAccounts(ctx context.Context, accounts map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.accounts = accounts
+	for _, bucket := range b.buckets {
+		if err := bucket.SetServiceAccounts(ctx, accounts); err != nil {
+			log.Printf("Failed to impersonate service accounts, keeping the previous ones: %v", err)
+		}
+	}
+}
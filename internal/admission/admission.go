@@ -0,0 +1,150 @@
+package admission
+
+import (
+	"math"
+	"net/http"
+	"runtime/metrics"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	proxymetrics "gcp-proxy-mity/internal/metrics"
+)
+
+// heapSampleInterval is how long a reading of the heap size is reused
+const heapSampleInterval = 100 * time.Millisecond
+
+// heapMetric is the memory taken by heap objects, live or not yet swept
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+var (
+	rejectedUploads   = proxymetrics.NewCounter("gcs_proxy_admission_rejected_uploads_total", "Uploads refused because too many were in progress")
+	rejectedDownloads = proxymetrics.NewCounter("gcs_proxy_admission_rejected_downloads_total", "Downloads refused because too many were in progress")
+	rejectedMemory    = proxymetrics.NewCounter("gcs_proxy_admission_rejected_memory_total", "Uploads and downloads refused because the heap was over its limit")
+)
+
+// Config bounds the transfers served at once; 0 disables a limit
+type Config struct {
+	// MaxUploads caps requests writing with a body
+	MaxUploads int
+	// MaxDownloads caps GET requests
+	MaxDownloads int
+	// MaxHeapBytes is the heap size above which new transfers are refused
+	MaxHeapBytes int64
+	// RetryAfter is what refused clients are told to wait
+	RetryAfter time.Duration
+}
+
+// Controller refuses new uploads and downloads with 503 Service Unavailable
+// while too many are in progress or the heap is too large, so that load is
+// shed before the process runs out of memory. Requests in progress are never
+// interrupted.
+type Controller struct {
+	writes func(*http.Request) bool
+	exempt []string
+
+	mu  sync.RWMutex
+	cfg Config
+
+	uploads   atomic.Int64
+	downloads atomic.Int64
+
+	heapMu      sync.Mutex
+	heapSample  []metrics.Sample
+	heapBytes   int64
+	heapSampled time.Time
+}
+
+// New creates a controller. writes reports whether a request makes changes;
+// requests to the exempt paths, like probes and metrics, are always served.
+// An exempt path ending in a slash exempts the paths under it.
+func New(writes func(*http.Request) bool, exempt ...string) *Controller {
+	c := &Controller{
+		writes:     writes,
+		exempt:     exempt,
+		heapSample: []metrics.Sample{{Name: heapMetric}},
+	}
+	proxymetrics.NewGaugeFunc("gcs_proxy_uploads_in_flight", "Uploads being served", func() float64 {
+		return float64(c.uploads.Load())
+	})
+	proxymetrics.NewGaugeFunc("gcs_proxy_downloads_in_flight", "Downloads being served", func() float64 {
+		return float64(c.downloads.Load())
+	})
+	return c
+}
+
+// SetConfig changes the limits at runtime; transfers in progress count against the new ones
+func (c *Controller) SetConfig(cfg Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg = cfg
+}
+
+// Middleware counts uploads and downloads and refuses new ones over the limits
+func (c *Controller) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.isExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var inFlight *atomic.Int64
+		var limit int
+		var rejected *proxymetrics.Counter
+		c.mu.RLock()
+		cfg := c.cfg
+		c.mu.RUnlock()
+		switch {
+		case r.Method == http.MethodGet:
+			inFlight, limit, rejected = &c.downloads, cfg.MaxDownloads, rejectedDownloads
+		case r.ContentLength != 0 && c.writes(r):
+			inFlight, limit, rejected = &c.uploads, cfg.MaxUploads, rejectedUploads
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if cfg.MaxHeapBytes > 0 && c.heapInUse() > cfg.MaxHeapBytes {
+			rejectedMemory.Inc()
+			c.refuse(w, cfg, "the server is low on memory")
+			return
+		}
+		if n := inFlight.Add(1); limit > 0 && n > int64(limit) {
+			inFlight.Add(-1)
+			rejected.Inc()
+			c.refuse(w, cfg, "too many transfers are in progress")
+			return
+		}
+		defer inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *Controller) isExempt(path string) bool {
+	return slices.ContainsFunc(c.exempt, func(exempt string) bool {
+		return path == exempt || strings.HasSuffix(exempt, "/") && strings.HasPrefix(path, exempt)
+	})
+}
+
+// refuse answers with 503 and when to try again
+func (c *Controller) refuse(w http.ResponseWriter, cfg Config, reason string) {
+	retryAfter := max(int(math.Ceil(cfg.RetryAfter.Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, "Service Unavailable: "+reason, http.StatusServiceUnavailable)
+}
+
+// heapInUse returns the bytes of heap objects, read at most every heapSampleInterval
+func (c *Controller) heapInUse() int64 {
+	c.heapMu.Lock()
+	defer c.heapMu.Unlock()
+	if time.Since(c.heapSampled) >= heapSampleInterval {
+		metrics.Read(c.heapSample)
+		if c.heapSample[0].Value.Kind() == metrics.KindUint64 {
+			c.heapBytes = int64(c.heapSample[0].Value.Uint64())
+		}
+		c.heapSampled = time.Now()
+	}
+	return c.heapBytes
+}
@@ -0,0 +1,75 @@
+package admission
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestController(t *testing.T) {
+	writes := func(r *http.Request) bool { return r.Method != http.MethodGet }
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		held     string
+		method   string
+		path     string
+		body     string
+		expected int
+	}{
+		{name: "unlimited", held: "GET", method: http.MethodGet, path: "/file", expected: http.StatusOK},
+		{name: "too many downloads", cfg: Config{MaxDownloads: 1}, held: "GET", method: http.MethodGet, path: "/file", expected: http.StatusServiceUnavailable},
+		{name: "upload while downloads are full", cfg: Config{MaxDownloads: 1}, held: "GET", method: http.MethodPut, path: "/file", body: "a", expected: http.StatusOK},
+		{name: "too many uploads", cfg: Config{MaxUploads: 1}, held: "PUT", method: http.MethodPut, path: "/file", body: "a", expected: http.StatusServiceUnavailable},
+		{name: "deletes aren't uploads", cfg: Config{MaxUploads: 1}, held: "PUT", method: http.MethodDelete, path: "/file", expected: http.StatusOK},
+		{name: "exempt path", cfg: Config{MaxDownloads: 1}, held: "GET", method: http.MethodGet, path: "/healthz", expected: http.StatusOK},
+		{name: "exempt prefix", cfg: Config{MaxHeapBytes: 1}, method: http.MethodGet, path: "/debug/pprof/heap", expected: http.StatusOK},
+		{name: "heap over its limit", cfg: Config{MaxHeapBytes: 1}, method: http.MethodGet, path: "/file", expected: http.StatusServiceUnavailable},
+		{name: "heap over its limit spares small requests", cfg: Config{MaxHeapBytes: 1}, method: http.MethodDelete, path: "/file", expected: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := New(writes, "/healthz", "/debug/")
+			tt.cfg.RetryAfter = 1500 * time.Millisecond
+			controller.SetConfig(tt.cfg)
+			release := make(chan struct{})
+			h := controller.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/held" {
+					<-release
+				}
+			}))
+
+			done := make(chan struct{})
+			if tt.held != "" {
+				go func() {
+					defer close(done)
+					h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.held, "/held", strings.NewReader("held")))
+				}()
+				for controller.uploads.Load()+controller.downloads.Load() == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			} else {
+				close(done)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			close(release)
+			<-done
+
+			if rec.Code != tt.expected {
+				t.Fatalf("Expected status %d, got %d", tt.expected, rec.Code)
+			}
+			if tt.expected == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") != "2" {
+				t.Errorf("Expected Retry-After 2, got %q", rec.Header().Get("Retry-After"))
+			}
+			if n := controller.uploads.Load() + controller.downloads.Load(); n != 0 {
+				t.Errorf("Expected no transfers in flight once done, got %d", n)
+			}
+		})
+	}
+}
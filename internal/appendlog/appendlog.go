@@ -0,0 +1,271 @@
+package appendlog
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Limits of the attempts to append or read while other clients change the log
+const (
+	maxAppendAttempts = 10
+	maxReadAttempts   = 3
+)
+
+// defaultMergeThreshold is how many pieces a log may have before they are
+// composed into one; a compose request takes at most 32 sources
+const defaultMergeThreshold = 32
+
+// composedGrace is how long pieces are kept after they were composed into a
+// larger one. An append whose listing is older than this can't tell whether
+// its offset was taken, so it must finish within it.
+const composedGrace = 10 * time.Minute
+
+// Record is the position of an appended record in its log
+type Record struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// Segment is a part of a log read from an offset
+type Segment struct {
+	Data   []byte
+	Offset int64
+	// Next is the offset to continue reading from
+	Next int64
+	// Size is the length of the whole log when it was read
+	Size int64
+}
+
+// piece is an object holding the bytes [start, end) of a log. Appended
+// records are named by their start, composed pieces by their start and end.
+type piece struct {
+	name       string
+	start, end int64
+	updated    time.Time
+}
+
+// Logs stores append-only logs as objects under a prefix, one object per
+// appended record until they are composed into one. Records are written with
+// a does-not-exist precondition on their offset, so concurrent appends from
+// any replica are serialized by the bucket and offsets never change. Composed
+// records are only deleted after composedGrace, so their offsets can't be
+// taken again by an append with an older listing.
+type Logs struct {
+	storage        storage.Storage
+	prefix         string
+	maxRecordBytes int64
+	mergeThreshold int
+	grace          time.Duration
+	now            func() time.Time
+}
+
+// New creates logs stored under prefix taking records of up to maxRecordBytes
+func New(store storage.Storage, prefix string, maxRecordBytes int64) *Logs {
+	return &Logs{
+		storage:        store,
+		prefix:         strings.Trim(prefix, "/") + "/",
+		maxRecordBytes: maxRecordBytes,
+		mergeThreshold: defaultMergeThreshold,
+		grace:          composedGrace,
+		now:            time.Now,
+	}
+}
+
+// Append adds a record to the end of the log at logPath, creating the log if needed
+func (l *Logs) Append(ctx context.Context, logPath string, data []byte) (*Record, error) {
+	if len(data) == 0 {
+		return nil, ErrEmptyRecord
+	}
+	if int64(len(data)) > l.maxRecordBytes {
+		return nil, fmt.Errorf("%w: records may have up to %d bytes", ErrRecordTooLarge, l.maxRecordBytes)
+	}
+	for range maxAppendAttempts {
+		listed := l.now()
+		pieces, covered, err := l.pieces(ctx, logPath)
+		if err != nil {
+			return nil, err
+		}
+		offset := end(pieces)
+		file, err := l.storage.CreateFile(ctx, storage.WriteRequest{
+			Path:        l.dir(logPath) + fmt.Sprintf("%020d", offset),
+			Content:     bytes.NewReader(data),
+			ContentType: "application/octet-stream",
+		})
+		if errors.Is(err, storage.ErrExists) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if l.now().Sub(listed) >= l.grace {
+			return nil, fmt.Errorf("%w: the append took longer than %s", ErrContention, l.grace)
+		}
+
+		pieces = append(pieces, piece{name: file.Name, start: offset, end: offset + int64(len(data)), updated: file.Updated})
+		if len(pieces) >= l.mergeThreshold {
+			l.merge(ctx, logPath, pieces, covered)
+		}
+		return &Record{Path: logPath, Offset: offset, Size: int64(len(data))}, nil
+	}
+	return nil, ErrContention
+}
+
+// Read returns up to limit bytes of the log at logPath from offset
+func (l *Logs) Read(ctx context.Context, logPath string, offset, limit int64) (*Segment, error) {
+	var err error
+	for range maxReadAttempts {
+		var segment *Segment
+		segment, err = l.read(ctx, logPath, offset, limit)
+		// Pieces vanish when they are composed; the next listing has the composed one
+		if !errors.Is(err, ErrChanged) {
+			return segment, err
+		}
+	}
+	return nil, err
+}
+
+func (l *Logs) read(ctx context.Context, logPath string, offset, limit int64) (*Segment, error) {
+	pieces, _, err := l.pieces(ctx, logPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(pieces) == 0 {
+		return nil, fmt.Errorf("log %s: %w", logPath, storage.ErrNotFound)
+	}
+	size := end(pieces)
+	if offset > size {
+		return nil, fmt.Errorf("%w: the log has %d bytes", ErrInvalidRange, size)
+	}
+
+	stop := min(offset+limit, size)
+	var ranges []storage.ReadRange
+	for _, p := range pieces {
+		if p.end <= offset || p.start >= stop {
+			continue
+		}
+		from := max(offset, p.start)
+		ranges = append(ranges, storage.ReadRange{Path: p.name, Offset: from - p.start, Length: min(stop, p.end) - from})
+	}
+
+	segment := &Segment{Offset: offset, Next: stop, Size: size, Data: make([]byte, 0, stop-offset)}
+	if len(ranges) == 0 {
+		return segment, nil
+	}
+	response, err := l.storage.ReadRanges(ctx, ranges)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Errors) > 0 {
+		return nil, fmt.Errorf("%w: %s: %s", ErrChanged, response.Errors[0].FilePath, response.Errors[0].Error)
+	}
+	for _, file := range response.Files {
+		segment.Data = append(segment.Data, file.Content...)
+	}
+	return segment, nil
+}
+
+// merge composes the pieces of a log into one and deletes the covered pieces
+// older than the grace period. Composing the same pieces always gives the same
+// object, so merges on several replicas at once are harmless.
+func (l *Logs) merge(ctx context.Context, logPath string, pieces, covered []piece) {
+	ctx = context.WithoutCancel(ctx)
+	names := make([]string, 0, len(pieces))
+	for _, p := range pieces {
+		names = append(names, p.name)
+	}
+	merged := l.dir(logPath) + fmt.Sprintf("%020d-%020d", pieces[0].start, end(pieces))
+	if _, err := l.storage.ComposeFiles(ctx, storage.WriteRequest{Path: merged, ContentType: "application/octet-stream"}, names); err != nil {
+		log.Printf("Failed to compose log %s: %v", logPath, err)
+		return
+	}
+
+	for _, p := range covered {
+		i := slices.IndexFunc(pieces, func(c piece) bool { return c.start <= p.start && p.start < c.end })
+		if i < 0 || l.now().Sub(pieces[i].updated) < l.grace {
+			continue
+		}
+		if err := l.storage.DeleteFile(ctx, p.name); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Failed to delete composed piece %s of log %s: %v", p.name, logPath, err)
+		}
+	}
+}
+
+// pieces lists the pieces that make up the log, in order, and the pieces
+// covered by longer ones, like records that were composed. The log ends at
+// the first gap.
+func (l *Logs) pieces(ctx context.Context, logPath string) ([]piece, []piece, error) {
+	dir := l.dir(logPath)
+	var all []piece
+	err := l.storage.WalkFiles(ctx, dir, func(file storage.FileMetadata) error {
+		p, ok := parsePiece(dir, file)
+		if ok {
+			all = append(all, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slices.SortFunc(all, func(a, b piece) int {
+		return cmp.Or(cmp.Compare(a.start, b.start), cmp.Compare(b.end, a.end))
+	})
+	var pieces, covered []piece
+	var pos int64
+	for _, p := range all {
+		if p.start < pos {
+			covered = append(covered, p)
+			continue
+		}
+		if p.start > pos {
+			break
+		}
+		pieces = append(pieces, p)
+		pos = p.end
+	}
+	return pieces, covered, nil
+}
+
+// parsePiece reads the range of a piece from its name; records end after their size
+func parsePiece(dir string, file storage.FileMetadata) (piece, bool) {
+	name := strings.TrimPrefix(file.Name, dir)
+	if strings.Contains(name, "/") {
+		return piece{}, false
+	}
+	first, second, composed := strings.Cut(name, "-")
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return piece{}, false
+	}
+	p := piece{name: file.Name, start: start, end: start + file.Size, updated: file.Updated}
+	if composed {
+		stop, err := strconv.ParseInt(second, 10, 64)
+		if err != nil || stop != p.end {
+			return piece{}, false
+		}
+	}
+	return p, file.Size > 0
+}
+
+func (l *Logs) dir(logPath string) string {
+	return path.Join(l.prefix, logPath) + "/"
+}
+
+func end(pieces []piece) int64 {
+	if len(pieces) == 0 {
+		return 0
+	}
+	return pieces[len(pieces)-1].end
+}
@@ -0,0 +1,178 @@
+package appendlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+
+	"gcp-proxy-mity/internal/storage"
+	"gcp-proxy-mity/pkg/storage/gcs"
+)
+
+func newTestLogs(t *testing.T) (*Logs, storage.Storage) {
+	t.Helper()
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme:     "http",
+		Host:       "127.0.0.1",
+		PublicHost: "127.0.0.1",
+	})
+	if err != nil {
+		t.Fatalf("Failed to start fake-gcs-server: %v", err)
+	}
+	t.Cleanup(server.Stop)
+	server.CreateBucket("test-bucket")
+
+	client, err := gcs.NewEmulatorClient(context.Background(), "test-project", "test-bucket", server.URL())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	store := storage.NewGCSStorage(client)
+	return New(store, "_logs", 64), store
+}
+
+func TestLogs_AppendAndRead(t *testing.T) {
+	logs, _ := newTestLogs(t)
+	logs.mergeThreshold = 3
+	ctx := context.Background()
+
+	var offsets []int64
+	for _, record := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		appended, err := logs.Append(ctx, "events/app.log", []byte(record))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		offsets = append(offsets, appended.Offset)
+	}
+	if fmt.Sprint(offsets) != "[0 4 8 14]" {
+		t.Errorf("Expected offsets [0 4 8 14], got %v", offsets)
+	}
+
+	tests := []struct {
+		name     string
+		offset   int64
+		limit    int64
+		wantData string
+		wantNext int64
+		wantErr  error
+	}{
+		{name: "all", limit: 100, wantData: "one\ntwo\nthree\nfour\n", wantNext: 19},
+		{name: "from a record", offset: 8, limit: 100, wantData: "three\nfour\n", wantNext: 19},
+		{name: "across records", offset: 5, limit: 6, wantData: "wo\nthr", wantNext: 11},
+		{name: "at the end", offset: 19, limit: 100, wantNext: 19},
+		{name: "beyond the end", offset: 20, limit: 100, wantErr: ErrInvalidRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segment, err := logs.Read(ctx, "events/app.log", tt.offset, tt.limit)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(segment.Data) != tt.wantData || segment.Next != tt.wantNext || segment.Size != 19 {
+				t.Errorf("Expected %q up to %d of 19, got %q up to %d of %d", tt.wantData, tt.wantNext, segment.Data, segment.Next, segment.Size)
+			}
+		})
+	}
+
+	if _, err := logs.Read(ctx, "events/missing.log", 0, 100); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing log, got %v", err)
+	}
+	if _, err := logs.Append(ctx, "events/app.log", nil); !errors.Is(err, ErrEmptyRecord) {
+		t.Errorf("Expected ErrEmptyRecord, got %v", err)
+	}
+	if _, err := logs.Append(ctx, "events/app.log", make([]byte, 65)); !errors.Is(err, ErrRecordTooLarge) {
+		t.Errorf("Expected ErrRecordTooLarge, got %v", err)
+	}
+}
+
+func TestLogs_Merge(t *testing.T) {
+	logs, store := newTestLogs(t)
+	logs.mergeThreshold = 3
+	now := time.Now()
+	logs.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := range 5 {
+		if _, err := logs.Append(ctx, "app.log", []byte(fmt.Sprintf("%d\n", i))); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	pieces, covered, err := logs.pieces(ctx, "app.log")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(pieces) != 1 || pieces[0].end != 10 || len(covered) == 0 {
+		t.Fatalf("Expected the records to be composed into one piece, got %+v covering %+v", pieces, covered)
+	}
+
+	// Composed records are deleted by a later merge once the grace period passed
+	now = now.Add(composedGrace + time.Second)
+	for i := 5; i < 7; i++ {
+		if _, err := logs.Append(ctx, "app.log", []byte(fmt.Sprintf("%d\n", i))); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	names, err := store.ListNames(ctx, "_logs/app.log/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "[_logs/app.log/00000000000000000000-00000000000000000010 _logs/app.log/00000000000000000000-00000000000000000014 _logs/app.log/00000000000000000010 _logs/app.log/00000000000000000012]"
+	if fmt.Sprint(names) != want {
+		t.Errorf("Expected only the pieces composed within the grace period to be kept, got %v", names)
+	}
+
+	segment, err := logs.Read(ctx, "app.log", 0, 100)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(segment.Data) != "0\n1\n2\n3\n4\n5\n6\n" {
+		t.Errorf("Expected all records in order, got %q", segment.Data)
+	}
+}
+
+func TestLogs_ConcurrentAppends(t *testing.T) {
+	logs, _ := newTestLogs(t)
+	logs.mergeThreshold = 4
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := logs.Append(ctx, "app.log", []byte(fmt.Sprintf("record %d\n", i)))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	segment, err := logs.Read(ctx, "app.log", 0, 1000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := range 8 {
+		if n := strings.Count(string(segment.Data), fmt.Sprintf("record %d\n", i)); n != 1 {
+			t.Errorf("Expected record %d once, found it %d times in %q", i, n, segment.Data)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package appendlog
+
+import "errors"
+
+var (
+	ErrEmptyRecord    = errors.New("record is empty")
+	ErrRecordTooLarge = errors.New("record is too large")
+	ErrContention     = errors.New("too many concurrent appends to the log")
+	ErrInvalidRange   = errors.New("offset is beyond the end of the log")
+	ErrChanged        = errors.New("log changed while it was read")
+)
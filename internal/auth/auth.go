@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type contextKey struct{}
+
+type scopesContextKey struct{}
+
+type identityContextKey struct{}
+
+type systemContextKey struct{}
+
+// ScopeAdmin grants access to the admin API
+const ScopeAdmin = "admin"
+
+// publicPaths never require credentials so probes keep working and the API
+// documentation can be read before a key is set
+var publicPaths = map[string]bool{
+	"/health":       true,
+	"/healthz":      true,
+	"/readyz":       true,
+	"/openapi.json": true,
+	"/docs":         true,
+}
+
+// Key is a named API key and the scopes it grants beyond the storage API
+type Key struct {
+	Name   string
+	Key    string
+	Scopes []string
+}
+
+// IdentityFunc returns the identity a proxy in front asserted for a request,
+// like Identity-Aware Proxy; ok is false if the request carries no assertion
+type IdentityFunc func(r *http.Request) (identity string, ok bool, err error)
+
+// SessionFunc returns the principal and scopes of the login session a request
+// carries, like a session cookie; ok is false if it carries none
+type SessionFunc func(r *http.Request) (session Key, ok bool, err error)
+
+// APIKeyAuthenticator authenticates requests by API key. With no keys or
+// identities configured every request is allowed, except routes that require a scope.
+type APIKeyAuthenticator struct {
+	mu        sync.RWMutex
+	keys      []Key
+	signer    *URLSigner
+	cdn       *CDNSigner
+	requests  *RequestVerifier
+	identify  IdentityFunc
+	sessions  SessionFunc
+	anonymous []func(*http.Request) bool
+}
+
+// NewAPIKeyAuthenticator creates an authenticator accepting the given keys
+func NewAPIKeyAuthenticator(keys []Key) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{
+		keys: keys,
+	}
+}
+
+// SetKeys replaces the accepted keys at runtime
+func (a *APIKeyAuthenticator) SetKeys(keys []Key) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = keys
+}
+
+// AcceptSignedURLs lets requests carrying a valid signed URL through without an API key
+func (a *APIKeyAuthenticator) AcceptSignedURLs(signer *URLSigner) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.signer = signer
+}
+
+// AcceptCDNTokens lets requests carrying a valid CDN token through without an API key
+func (a *APIKeyAuthenticator) AcceptCDNTokens(signer *CDNSigner) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cdn = signer
+}
+
+// AcceptSignedRequests lets requests signed with an API key through, so
+// callers never send the key itself
+func (a *APIKeyAuthenticator) AcceptSignedRequests(verifier *RequestVerifier) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests = verifier
+}
+
+// AcceptIdentities lets requests with an identity asserted by a proxy in front
+// through. Other requests then need an API key even if none are configured.
+func (a *APIKeyAuthenticator) AcceptIdentities(identify IdentityFunc) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.identify = identify
+}
+
+// AcceptSessions lets requests with a login session through, with the
+// session's principal and scopes. Other requests then need an API key even if
+// none are configured.
+func (a *APIKeyAuthenticator) AcceptSessions(sessions SessionFunc) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sessions = sessions
+}
+
+// AllowAnonymous lets requests matching the predicate through without credentials
+func (a *APIKeyAuthenticator) AllowAnonymous(match func(*http.Request) bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.anonymous = append(a.anonymous, match)
+}
+
+func (a *APIKeyAuthenticator) allowedAnonymously(r *http.Request) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.ContainsFunc(a.anonymous, func(match func(*http.Request) bool) bool { return match(r) })
+}
+
+func (a *APIKeyAuthenticator) enabled() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.keys) > 0 || a.identify != nil || a.sessions != nil
+}
+
+// Authenticate returns the key presented by the request
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (Key, bool) {
+	presented := r.Header.Get("X-API-Key")
+	if presented == "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			presented = token
+		}
+	}
+	if presented == "" {
+		return Key{}, false
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	// Compare against every key to keep timing independent of which key matched
+	var match Key
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(key.Key)) == 1 {
+			match = key
+		}
+	}
+	return match, match.Name != ""
+}
+
+// key returns the key named name
+func (a *APIKeyAuthenticator) key(name string) (Key, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, key := range a.keys {
+		if key.Name == name {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// Middleware rejects requests without a valid API key
+func (a *APIKeyAuthenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] || !a.enabled() || a.allowedAnonymously(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		a.mu.RLock()
+		signer, cdn, requests, identify, sessions := a.signer, a.cdn, a.requests, a.identify, a.sessions
+		a.mu.RUnlock()
+		if signer != nil && signer.Verify(r) {
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), SignedURLPrincipal)))
+			return
+		}
+		if cdn != nil && cdn.Verify(r) {
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), CDNTokenPrincipal)))
+			return
+		}
+
+		if identify != nil {
+			identity, asserted, err := identify(r)
+			if err != nil {
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if asserted {
+				ctx := WithIdentity(WithPrincipal(r.Context(), identity), identity)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+		if sessions != nil {
+			session, ok, err := sessions(r)
+			if err != nil {
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if ok {
+				next.ServeHTTP(w, r.WithContext(withKey(r.Context(), session)))
+				return
+			}
+		}
+		if requests != nil && strings.HasPrefix(r.Header.Get("Authorization"), SignedRequestScheme+" ") {
+			key, err := requests.Verify(r, a.key)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", SignedRequestScheme+` realm="gcp-proxy-mity"`)
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withKey(r.Context(), key)))
+			return
+		}
+
+		key, ok := a.Authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gcp-proxy-mity"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withKey(r.Context(), key)))
+	})
+}
+
+// withKey stores the principal and scopes of an authenticated key in the context
+func withKey(ctx context.Context, key Key) context.Context {
+	return WithScopes(WithPrincipal(ctx, key.Name), key.Scopes)
+}
+
+// RequireScope rejects requests whose API key doesn't grant the scope. Without
+// configured keys nobody holds a scope, so these routes stay closed.
+func RequireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !HasScope(r.Context(), scope) {
+			http.Error(w, "Forbidden: requires the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HasScope reports whether the authenticated key grants the scope
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, _ := ctx.Value(scopesContextKey{}).([]string)
+	return slices.Contains(scopes, scope)
+}
+
+// ScopesFromContext returns the scopes granted to the authenticated key, if any
+func ScopesFromContext(ctx context.Context) []string {
+	scopes, _ := ctx.Value(scopesContextKey{}).([]string)
+	return scopes
+}
+
+// WithScopes stores the scopes granted to the authenticated key in the context
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesContextKey{}, scopes)
+}
+
+// WithPrincipal stores the authenticated principal in the context
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// WithIdentity marks the principal as an identity asserted by a proxy in front
+func WithIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// IdentityFromContext returns the identity asserted by a proxy in front, if any
+func IdentityFromContext(ctx context.Context) string {
+	identity, _ := ctx.Value(identityContextKey{}).(string)
+	return identity
+}
+
+// WithSystem marks work the proxy does on its own, like scheduled jobs and
+// bucket notifications, which no key's scopes apply to
+func WithSystem(ctx context.Context) context.Context {
+	return context.WithValue(ctx, systemContextKey{}, true)
+}
+
+// IsSystem reports whether ctx is work the proxy does on its own
+func IsSystem(ctx context.Context) bool {
+	system, _ := ctx.Value(systemContextKey{}).(bool)
+	return system
+}
+
+// PrincipalFromContext returns the authenticated principal, if any
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(contextKey{}).(string)
+	return principal
+}
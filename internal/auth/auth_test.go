@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireScope(t *testing.T) {
+	authenticator := NewAPIKeyAuthenticator([]Key{
+		{Name: "ci", Key: "ci-key"},
+		{Name: "ops", Key: "ops-key", Scopes: []string{ScopeAdmin}},
+	})
+	handler := authenticator.Middleware(RequireScope(ScopeAdmin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	tests := []struct {
+		name     string
+		key      string
+		expected int
+	}{
+		{name: "admin key", key: "ops-key", expected: http.StatusOK},
+		{name: "key without scope", key: "ci-key", expected: http.StatusForbidden},
+		{name: "missing key", key: "", expected: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/admin/buckets", nil)
+			if tt.key != "" {
+				r.Header.Set("X-API-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+
+	open := NewAPIKeyAuthenticator(nil).Middleware(RequireScope(ScopeAdmin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	w := httptest.NewRecorder()
+	open.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/buckets", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected admin routes to be refused without configured keys, got %d", w.Code)
+	}
+}
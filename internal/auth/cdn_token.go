@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CDNTokenPrincipal is the principal attached to requests authorized by a CDN token
+const CDNTokenPrincipal = "cdn-token"
+
+// CDNCookieName is the cookie Cloud CDN reads signed cookies from
+const CDNCookieName = "Cloud-CDN-Cookie"
+
+// CDNToken grants read access to every URL under Prefix until Expires
+type CDNToken struct {
+	Prefix  string
+	Expires time.Time
+	// Cookie is the value of a Cloud-CDN-Cookie
+	Cookie string
+	// Query are the URLPrefix, Expires, KeyName and Signature parameters of a signed URL
+	Query string
+}
+
+// CDNSigner issues and verifies tokens in the format of Cloud CDN signed
+// cookies and signed URL prefixes, so one token grants read access to every
+// path under a URL prefix both at the CDN and at the proxy behind it
+type CDNSigner struct {
+	keyName string
+	key     []byte
+	// paths are the URL path prefixes tokens may grant access below
+	paths []string
+}
+
+// NewCDNSigner creates a signer for the Cloud CDN key keyName, whose value is
+// base64url encoded like the keys Cloud CDN generates. Tokens only grant
+// access below the given URL paths.
+func NewCDNSigner(keyName, key string, paths []string) (*CDNSigner, error) {
+	decoded, err := base64.URLEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("CDN signing key must be base64url encoded: %w", err)
+	}
+	return &CDNSigner{keyName: keyName, key: decoded, paths: paths}, nil
+}
+
+// Sign issues a token for the URLs under prefix, an absolute URL or a path
+func (s *CDNSigner) Sign(prefix string, expires time.Time) (*CDNToken, error) {
+	if !s.allowed(prefix) {
+		return nil, fmt.Errorf("%w: the prefix must be below %s", ErrInvalidCDNToken, strings.Join(s.paths, ", "))
+	}
+	fields := []string{
+		"URLPrefix=" + base64.URLEncoding.EncodeToString([]byte(prefix)),
+		"Expires=" + strconv.FormatInt(expires.Unix(), 10),
+		"KeyName=" + s.keyName,
+	}
+	cookie := strings.Join(fields, ":")
+	query := strings.Join(fields, "&")
+	return &CDNToken{
+		Prefix:  prefix,
+		Expires: time.Unix(expires.Unix(), 0),
+		Cookie:  cookie + ":Signature=" + s.sign(cookie),
+		Query:   query + "&Signature=" + s.sign(query),
+	}, nil
+}
+
+// Verify reports whether the request is a GET or HEAD carrying a valid,
+// unexpired token, as a signed cookie or signed URL parameters, whose prefix
+// covers its path. Only the path of the prefix is compared since the host
+// the proxy sees may differ from the one the CDN serves.
+func (s *CDNSigner) Verify(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	q := r.URL.Query()
+	if q.Get("URLPrefix") != "" {
+		signed := "URLPrefix=" + q.Get("URLPrefix") + "&Expires=" + q.Get("Expires") + "&KeyName=" + q.Get("KeyName")
+		return s.verify(r, signed, q.Get("URLPrefix"), q.Get("Expires"), q.Get("KeyName"), q.Get("Signature"))
+	}
+
+	cookie, err := r.Cookie(CDNCookieName)
+	if err != nil {
+		return false
+	}
+	signed, signature, ok := strings.Cut(cookie.Value, ":Signature=")
+	if !ok {
+		return false
+	}
+	values := map[string]string{}
+	for _, field := range strings.Split(signed, ":") {
+		name, value, _ := strings.Cut(field, "=")
+		values[name] = value
+	}
+	return s.verify(r, signed, values["URLPrefix"], values["Expires"], values["KeyName"], signature)
+}
+
+func (s *CDNSigner) verify(r *http.Request, signed, encodedPrefix, expires, keyName, signature string) bool {
+	if keyName != s.keyName || !hmac.Equal([]byte(s.sign(signed)), []byte(signature)) {
+		return false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	prefix, err := base64.URLEncoding.DecodeString(encodedPrefix)
+	if err != nil || !s.allowed(string(prefix)) {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, prefixPath(string(prefix)))
+}
+
+// allowed reports whether the prefix is below one of the signer's paths
+func (s *CDNSigner) allowed(prefix string) bool {
+	path := prefixPath(prefix)
+	if strings.Contains(path, "/..") {
+		return false
+	}
+	for _, allowed := range s.paths {
+		if strings.HasPrefix(path, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *CDNSigner) sign(value string) string {
+	mac := hmac.New(sha1.New, s.key)
+	mac.Write([]byte(value))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// prefixPath returns the path of a URL prefix, or "" if it has none
+func prefixPath(prefix string) string {
+	if strings.HasPrefix(prefix, "/") {
+		return prefix
+	}
+	u, err := url.Parse(prefix)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Path
+}
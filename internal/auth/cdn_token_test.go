@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCDNSigner(t *testing.T) {
+	// A 16 byte key like the ones Cloud CDN generates
+	signer, err := NewCDNSigner("proxy-key", "nZtRohdNF9m3cKM24IcK4w==", []string{"/api/v1/storage/stream/"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	token, err := signer.Sign("https://cdn.example.com/api/v1/storage/stream/videos/a/", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expired, err := signer.Sign("/api/v1/storage/stream/videos/a/", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		cookie   string
+		expected bool
+	}{
+		{name: "cookie", method: http.MethodGet, target: "/api/v1/storage/stream/videos/a/index.m3u8", cookie: token.Cookie, expected: true},
+		{name: "signed url", method: http.MethodGet, target: "/api/v1/storage/stream/videos/a/seg1.ts?" + token.Query, expected: true},
+		{name: "head", method: http.MethodHead, target: "/api/v1/storage/stream/videos/a/seg1.ts", cookie: token.Cookie, expected: true},
+		{name: "write method", method: http.MethodPut, target: "/api/v1/storage/stream/videos/a/seg1.ts", cookie: token.Cookie, expected: false},
+		{name: "outside the prefix", method: http.MethodGet, target: "/api/v1/storage/stream/videos/b/seg1.ts", cookie: token.Cookie, expected: false},
+		{name: "expired", method: http.MethodGet, target: "/api/v1/storage/stream/videos/a/seg1.ts", cookie: expired.Cookie, expected: false},
+		{name: "tampered", method: http.MethodGet, target: "/api/v1/storage/stream/videos/a/seg1.ts", cookie: token.Cookie[:len(token.Cookie)-4] + "AAA=", expected: false},
+		{name: "no token", method: http.MethodGet, target: "/api/v1/storage/stream/videos/a/seg1.ts", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: CDNCookieName, Value: tt.cookie})
+			}
+			if got := signer.Verify(r); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	for _, prefix := range []string{"/api/v1/storage/list", "/api/v1/storage/stream/../list", "videos/a/"} {
+		if _, err := signer.Sign(prefix, time.Now().Add(time.Minute)); err == nil {
+			t.Errorf("Expected prefix %q to be rejected", prefix)
+		}
+	}
+}
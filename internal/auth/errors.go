@@ -0,0 +1,11 @@
+package auth
+
+import "errors"
+
+var (
+	ErrInvalidCDNToken       = errors.New("invalid CDN token")
+	ErrInvalidSignature      = errors.New("invalid request signature")
+	ErrRequestSkewed         = errors.New("request date is outside the allowed clock skew")
+	ErrReplayedRequest       = errors.New("request signature was already used")
+	ErrContentSHA256Mismatch = errors.New("X-Content-SHA256 does not match the body")
+)
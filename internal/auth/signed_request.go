@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SignedRequestScheme is the Authorization scheme of requests signed with an API key
+const SignedRequestScheme = "HMAC-SHA256"
+
+const (
+	// DateHeader carries the time a request was signed, like 20060102T150405Z
+	DateHeader = "X-Date"
+	// ContentSHA256Header carries the hex SHA-256 of the body, or UnsignedPayload
+	ContentSHA256Header = "X-Content-SHA256"
+	// UnsignedPayload leaves the body out of the signature, for bodies that
+	// are streamed and can't be hashed before they are sent
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+const dateFormat = "20060102T150405Z"
+
+// SignedHeaders change what a request does, like the path of an upload or the
+// key it is encrypted with. A signed request must sign each of them it carries.
+var SignedHeaders = []string{
+	"Content-Type",
+	"X-Encryption-Key",
+	"X-Expires-After",
+	"X-Expires-At",
+	"X-File-Path",
+	"X-KMS-Key-Name",
+	"X-Lease-ID",
+	"X-Storage-Class",
+	"X-Strip-Metadata",
+}
+
+// ReplayCache remembers the signatures of accepted requests, so each is accepted once
+type ReplayCache interface {
+	// Remember stores id for ttl and reports whether it was not stored before
+	Remember(ctx context.Context, id string, ttl time.Duration) (bool, error)
+}
+
+// RequestVerifier authenticates requests signed with the secret of an API key,
+// similar to AWS Signature Version 4, so the key itself is never sent. The
+// Authorization header is
+//
+//	HMAC-SHA256 KeyId=<key name>, SignedHeaders=<names>, Signature=<hex HMAC-SHA256 of the string to sign>
+//
+// where SignedHeaders lists the signed headers in lowercase separated by ";"
+// and may be left out when there are none. The string to sign joins the
+// scheme, X-Date, the method, the escaped path, the query sorted by name, a
+// name:value line for each signed header and X-Content-SHA256 with newlines.
+// A signature is accepted once, and only within maxSkew of its date.
+type RequestVerifier struct {
+	maxSkew time.Duration
+	replays ReplayCache
+	// local catches replays when the shared cache fails
+	local *MemoryReplayCache
+	now   func() time.Time
+}
+
+// NewRequestVerifier creates a verifier remembering signatures in replays, or
+// in memory if replays is nil
+func NewRequestVerifier(maxSkew time.Duration, replays ReplayCache) *RequestVerifier {
+	return &RequestVerifier{
+		maxSkew: maxSkew,
+		replays: replays,
+		local:   NewMemoryReplayCache(),
+		now:     time.Now,
+	}
+}
+
+// Verify returns the key that signed the request, looked up by name. A body
+// hash is checked while the body is read: a mismatch is returned as its final
+// read error.
+func (v *RequestVerifier) Verify(r *http.Request, lookup func(name string) (Key, bool)) (Key, error) {
+	keyID, signedHeaders, signature, ok := parseSignedAuthorization(r.Header.Get("Authorization"))
+	if !ok {
+		return Key{}, fmt.Errorf("%w: expected %s KeyId=..., Signature=...", ErrInvalidSignature, SignedRequestScheme)
+	}
+	for _, name := range SignedHeaders {
+		if len(r.Header.Values(name)) > 0 && !slices.Contains(signedHeaders, strings.ToLower(name)) {
+			return Key{}, fmt.Errorf("%w: %s must be signed", ErrInvalidSignature, name)
+		}
+	}
+	date, err := time.Parse(dateFormat, r.Header.Get(DateHeader))
+	if err != nil {
+		return Key{}, fmt.Errorf("%w: %s must be a time like %s", ErrInvalidSignature, DateHeader, dateFormat)
+	}
+	if skew := v.now().Sub(date); skew > v.maxSkew || skew < -v.maxSkew {
+		return Key{}, ErrRequestSkewed
+	}
+	payloadHash := r.Header.Get(ContentSHA256Header)
+	expected, err := hex.DecodeString(payloadHash)
+	if payloadHash != UnsignedPayload && (err != nil || len(expected) != sha256.Size) {
+		return Key{}, fmt.Errorf("%w: %s must be a hex SHA-256 or %s", ErrInvalidSignature, ContentSHA256Header, UnsignedPayload)
+	}
+
+	key, found := lookup(keyID)
+	// Unknown keys are signed with an empty secret to keep the timing the same
+	valid := hmac.Equal([]byte(signRequest(key.Key, r, signedHeaders, payloadHash)), []byte(signature))
+	if !found || !valid {
+		return Key{}, ErrInvalidSignature
+	}
+	if !v.remember(r.Context(), keyID+":"+signature) {
+		return Key{}, ErrReplayedRequest
+	}
+
+	if payloadHash != UnsignedPayload && r.Body != nil {
+		r.Body = &digestReader{ReadCloser: r.Body, hash: sha256.New(), expected: expected}
+	}
+	return key, nil
+}
+
+// remember reports whether the signature is used for the first time. The
+// signature stays valid for maxSkew either side of its date, so it is kept
+// for twice that.
+func (v *RequestVerifier) remember(ctx context.Context, id string) bool {
+	ttl := 2 * v.maxSkew
+	if v.replays != nil {
+		fresh, err := v.replays.Remember(ctx, id, ttl)
+		if err == nil {
+			return fresh
+		}
+		log.Printf("Failed to check request signature for replays, checking this replica only: %v", err)
+	}
+	fresh, _ := v.local.Remember(ctx, id, ttl)
+	return fresh
+}
+
+// SignRequest signs a request with an API key for a RequestVerifier, along
+// with those of SignedHeaders it carries. The body is hashed if it can be read
+// again through GetBody, and left unsigned otherwise.
+func SignRequest(r *http.Request, keyID, secret string, date time.Time) error {
+	payloadHash, err := payloadSHA256(r)
+	if err != nil {
+		return err
+	}
+	var signedHeaders []string
+	for _, name := range SignedHeaders {
+		if len(r.Header.Values(name)) > 0 {
+			signedHeaders = append(signedHeaders, strings.ToLower(name))
+		}
+	}
+	r.Header.Set(DateHeader, date.UTC().Format(dateFormat))
+	r.Header.Set(ContentSHA256Header, payloadHash)
+	signature := signRequest(secret, r, signedHeaders, payloadHash)
+	if len(signedHeaders) > 0 {
+		r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s, SignedHeaders=%s, Signature=%s", SignedRequestScheme, keyID, strings.Join(signedHeaders, ";"), signature))
+	} else {
+		r.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s, Signature=%s", SignedRequestScheme, keyID, signature))
+	}
+	return nil
+}
+
+func payloadSHA256(r *http.Request) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	if r.GetBody == nil {
+		return UnsignedPayload, nil
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func signRequest(secret string, r *http.Request, signedHeaders []string, payloadHash string) string {
+	lines := []string{
+		SignedRequestScheme,
+		r.Header.Get(DateHeader),
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.Query().Encode(),
+	}
+	for _, name := range signedHeaders {
+		values := slices.Clone(r.Header.Values(name))
+		for i, value := range values {
+			values[i] = strings.TrimSpace(value)
+		}
+		lines = append(lines, name+":"+strings.Join(values, ","))
+	}
+	stringToSign := strings.Join(append(lines, payloadHash), "\n")
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseSignedAuthorization returns the key ID, signed headers and signature of an Authorization header
+func parseSignedAuthorization(header string) (keyID string, signedHeaders []string, signature string, ok bool) {
+	params, ok := strings.CutPrefix(header, SignedRequestScheme+" ")
+	if !ok {
+		return "", nil, "", false
+	}
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "KeyId":
+			keyID = value
+		case "SignedHeaders":
+			signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			signature = value
+		}
+	}
+	return keyID, signedHeaders, signature, keyID != "" && signature != ""
+}
+
+// digestReader returns ErrContentSHA256Mismatch instead of io.EOF if the body's digest differs from expected
+type digestReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected []byte
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(r.hash.Sum(nil), r.expected) {
+		return n, ErrContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// MemoryReplayCache remembers signatures in memory, catching replays sent to
+// the same replica
+type MemoryReplayCache struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+	now    func() time.Time
+}
+
+// NewMemoryReplayCache creates an empty in-memory replay cache
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{
+		seen: make(map[string]time.Time),
+		now:  time.Now,
+	}
+}
+
+func (c *MemoryReplayCache) Remember(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	// Expired signatures are dropped once a minute rather than on every request
+	if now.Sub(c.pruned) >= time.Minute {
+		for seen, expires := range c.seen {
+			if !now.Before(expires) {
+				delete(c.seen, seen)
+			}
+		}
+		c.pruned = now
+	}
+	if expires, ok := c.seen[id]; ok && now.Before(expires) {
+		return false, nil
+	}
+	c.seen[id] = now.Add(ttl)
+	return true, nil
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestVerifier(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	keys := map[string]Key{"ci": {Name: "ci", Key: "ci-secret", Scopes: []string{ScopeAdmin}}}
+	lookup := func(name string) (Key, bool) {
+		key, ok := keys[name]
+		return key, ok
+	}
+
+	signed := func(method, target, body string, keyID, secret string, date time.Time) *http.Request {
+		r, _ := http.NewRequest(method, "http://proxy.test"+target, strings.NewReader(body))
+		if err := SignRequest(r, keyID, secret, date); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		request  func() *http.Request
+		expected error
+	}{
+		{
+			name: "valid",
+			request: func() *http.Request {
+				return signed(http.MethodGet, "/api/v1/storage/files/a%20b.txt?b=2&a=1", "", "ci", "ci-secret", now)
+			},
+		},
+		{
+			name: "within skew",
+			request: func() *http.Request {
+				return signed(http.MethodPut, "/api/v1/storage/files/a.txt", "hello", "ci", "ci-secret", now.Add(4*time.Minute))
+			},
+		},
+		{
+			name: "wrong secret",
+			request: func() *http.Request {
+				return signed(http.MethodGet, "/api/v1/storage/files/a.txt", "", "ci", "other", now)
+			},
+			expected: ErrInvalidSignature,
+		},
+		{
+			name:     "unknown key",
+			request:  func() *http.Request { return signed(http.MethodGet, "/api/v1/storage/files/a.txt", "", "ops", "", now) },
+			expected: ErrInvalidSignature,
+		},
+		{
+			name: "skewed date",
+			request: func() *http.Request {
+				return signed(http.MethodGet, "/api/v1/storage/files/a.txt", "", "ci", "ci-secret", now.Add(-6*time.Minute))
+			},
+			expected: ErrRequestSkewed,
+		},
+		{
+			name: "tampered path",
+			request: func() *http.Request {
+				r := signed(http.MethodGet, "/api/v1/storage/files/a.txt", "", "ci", "ci-secret", now)
+				r.URL.Path = "/api/v1/storage/files/b.txt"
+				return r
+			},
+			expected: ErrInvalidSignature,
+		},
+		{
+			name: "tampered query",
+			request: func() *http.Request {
+				r := signed(http.MethodGet, "/api/v1/storage/files/a.txt?download=1", "", "ci", "ci-secret", now)
+				r.URL.RawQuery = "download=0"
+				return r
+			},
+			expected: ErrInvalidSignature,
+		},
+		{
+			name: "signed header",
+			request: func() *http.Request {
+				r, _ := http.NewRequest(http.MethodPost, "http://proxy.test/api/v1/storage/files/raw", strings.NewReader("hello"))
+				r.Header.Set("X-File-Path", "uploads/a.txt")
+				r.Header.Set("X-Storage-Class", "COLDLINE")
+				if err := SignRequest(r, "ci", "ci-secret", now); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return r
+			},
+		},
+		{
+			name: "tampered header",
+			request: func() *http.Request {
+				r, _ := http.NewRequest(http.MethodPost, "http://proxy.test/api/v1/storage/files/raw", strings.NewReader("hello"))
+				r.Header.Set("X-File-Path", "uploads/a.txt")
+				if err := SignRequest(r, "ci", "ci-secret", now); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				r.Header.Set("X-File-Path", "config/settings.json")
+				return r
+			},
+			expected: ErrInvalidSignature,
+		},
+		{
+			name: "unsigned header",
+			request: func() *http.Request {
+				r := signed(http.MethodPut, "/api/v1/storage/files/a.txt", "hello", "ci", "ci-secret", now)
+				r.Header.Set("X-Encryption-Key", "c2VjcmV0")
+				return r
+			},
+			expected: ErrInvalidSignature,
+		},
+		{
+			name: "malformed header",
+			request: func() *http.Request {
+				r := signed(http.MethodGet, "/api/v1/storage/files/a.txt", "", "ci", "ci-secret", now)
+				r.Header.Set("Authorization", SignedRequestScheme+" Signature=abc")
+				return r
+			},
+			expected: ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := NewRequestVerifier(5*time.Minute, nil)
+			verifier.now = func() time.Time { return now }
+
+			key, err := verifier.Verify(tt.request(), lookup)
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("Expected error %v, got %v", tt.expected, err)
+			}
+			if err == nil && key.Name != "ci" {
+				t.Errorf("Expected key ci, got %q", key.Name)
+			}
+		})
+	}
+}
+
+func TestRequestVerifier_Replay(t *testing.T) {
+	verifier := NewRequestVerifier(5*time.Minute, nil)
+	lookup := func(name string) (Key, bool) { return Key{Name: "ci", Key: "ci-secret"}, name == "ci" }
+
+	r, _ := http.NewRequest(http.MethodDelete, "http://proxy.test/api/v1/storage/files/a.txt", nil)
+	if err := SignRequest(r, "ci", "ci-secret", time.Now()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := verifier.Verify(r, lookup); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := verifier.Verify(r, lookup); !errors.Is(err, ErrReplayedRequest) {
+		t.Errorf("Expected ErrReplayedRequest, got %v", err)
+	}
+}
+
+func TestRequestVerifier_Body(t *testing.T) {
+	lookup := func(name string) (Key, bool) { return Key{Name: "ci", Key: "ci-secret"}, name == "ci" }
+
+	tests := []struct {
+		name     string
+		body     string
+		sent     string
+		expected error
+	}{
+		{name: "matching body", body: "hello", sent: "hello"},
+		{name: "altered body", body: "hello", sent: "HELLO", expected: ErrContentSHA256Mismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodPut, "http://proxy.test/api/v1/storage/files/a.txt", strings.NewReader(tt.body))
+			if err := SignRequest(r, "ci", "ci-secret", time.Now()); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			r.Body = io.NopCloser(strings.NewReader(tt.sent))
+
+			verifier := NewRequestVerifier(5*time.Minute, nil)
+			if _, err := verifier.Verify(r, lookup); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, err := io.ReadAll(r.Body); !errors.Is(err, tt.expected) {
+				t.Errorf("Expected read error %v, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	streamed, _ := http.NewRequest(http.MethodPut, "http://proxy.test/api/v1/storage/files/a.txt", io.MultiReader(strings.NewReader("hello")))
+	if err := SignRequest(streamed, "ci", "ci-secret", time.Now()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := streamed.Header.Get(ContentSHA256Header); got != UnsignedPayload {
+		t.Errorf("Expected a body without GetBody to be unsigned, got %q", got)
+	}
+}
+
+func TestAPIKeyAuthenticator_SignedRequests(t *testing.T) {
+	authenticator := NewAPIKeyAuthenticator([]Key{{Name: "ci", Key: "ci-secret", Scopes: []string{ScopeAdmin}}})
+	authenticator.AcceptSignedRequests(NewRequestVerifier(5*time.Minute, nil))
+	handler := authenticator.Middleware(RequireScope(ScopeAdmin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal := PrincipalFromContext(r.Context()); principal != "ci" {
+			t.Errorf("Expected principal ci, got %q", principal)
+		}
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/admin/buckets", nil)
+	if err := SignRequest(r, "ci", "ci-secret", time.Now()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), ErrReplayedRequest.Error()) {
+		t.Errorf("Expected the replay to be refused with 401, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	signer := NewURLSigner("secret")
+	valid := signer.SignURL("/api/v1/storage/files/videos/a b.mp4", time.Now().Add(time.Minute))
+	expired := signer.SignURL("/api/v1/storage/files/videos/a.mp4", time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		expected bool
+	}{
+		{name: "valid", method: http.MethodGet, target: valid, expected: true},
+		{name: "head", method: http.MethodHead, target: valid, expected: true},
+		{name: "write method", method: http.MethodPut, target: valid, expected: false},
+		{name: "expired", method: http.MethodGet, target: expired, expected: false},
+		{name: "other path", method: http.MethodGet, target: "/api/v1/storage/files/other.mp4?" + valid[len("/api/v1/storage/files/videos/a%20b.mp4?"):], expected: false},
+		{name: "unsigned", method: http.MethodGet, target: "/api/v1/storage/files/videos/a.mp4", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if got := signer.Verify(r); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	if NewURLSigner("other").Verify(httptest.NewRequest(http.MethodGet, valid, nil)) {
+		t.Error("Expected signature from a different key to be rejected")
+	}
+}
@@ -0,0 +1,127 @@
+package bufpool
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+
+	"gcp-proxy-mity/internal/metrics"
+)
+
+// Buffers are pooled in size classes doubling from minClass to maxClass;
+// larger ones are allocated and left to the GC so the pool stays bounded
+const (
+	minClassBits = 15
+	maxClassBits = 23
+	minClass     = 1 << minClassBits
+	maxClass     = 1 << maxClassBits
+)
+
+// CopySize is the size of the buffers Copy uses
+const CopySize = minClass
+
+var (
+	pools [maxClassBits - minClassBits + 1]sync.Pool
+
+	gets        = metrics.NewCounter("gcs_proxy_buffer_pool_gets_total", "Buffers taken from the pool")
+	allocations = metrics.NewCounter("gcs_proxy_buffer_pool_allocations_total", "Buffers allocated because the pool had none to reuse")
+	oversized   = metrics.NewCounter("gcs_proxy_buffer_pool_oversized_total", "Buffers too large to be pooled")
+	inUse       atomic.Int64
+)
+
+func init() {
+	metrics.NewGaugeFunc("gcs_proxy_buffer_pool_in_use_bytes", "Bytes of pooled buffers taken and not yet returned", func() float64 {
+		return float64(inUse.Load())
+	})
+}
+
+// class returns the index of the smallest size class holding n bytes, or -1
+// if n is over maxClass
+func class(n int) int {
+	if n > maxClass {
+		return -1
+	}
+	if n <= minClass {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - minClassBits
+}
+
+// Get returns a buffer of length n, reused from the pool when one is free.
+// Its content is left over from earlier use. Pass it to Put once done with it.
+func Get(n int) []byte {
+	c := class(n)
+	if c < 0 {
+		oversized.Inc()
+		return make([]byte, n)
+	}
+	gets.Inc()
+	size := minClass << c
+	inUse.Add(int64(size))
+	if b, ok := pools[c].Get().(*[]byte); ok {
+		return (*b)[:n]
+	}
+	allocations.Inc()
+	return make([]byte, n, size)
+}
+
+// Put returns a buffer from Get to the pool. It must not be used afterwards.
+func Put(b []byte) {
+	c := class(cap(b))
+	if c < 0 || cap(b) != minClass<<c {
+		return
+	}
+	inUse.Add(-int64(cap(b)))
+	b = b[:cap(b)]
+	pools[c].Put(&b)
+}
+
+// Copy copies src to dst like io.Copy, through a pooled buffer
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := Get(CopySize)
+	defer Put(buf)
+	return io.CopyBuffer(dst, src, buf)
+}
+
+// ReadAll reads content of a known size from r into a buffer allocated once
+// at that size, instead of growing one as io.ReadAll does. It fails if r ends
+// early or has more to read. A negative size reads everything with io.ReadAll.
+func ReadAll(r io.Reader, size int64) ([]byte, error) {
+	if size < 0 {
+		return io.ReadAll(r)
+	}
+	buf := make([]byte, size)
+	if err := readFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// ReadPooled is ReadAll into a pooled buffer, for content only needed until
+// it is passed to Put
+func ReadPooled(r io.Reader, size int64) ([]byte, error) {
+	buf := Get(int(size))
+	if err := readFull(r, buf); err != nil {
+		Put(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readFull fills buf from r and checks r has nothing left
+func readFull(r io.Reader, buf []byte) error {
+	if n, err := io.ReadFull(r, buf); err == io.ErrUnexpectedEOF || err == io.EOF {
+		return fmt.Errorf("%w: read %d of %d bytes", ErrShortRead, n, len(buf))
+	} else if err != nil {
+		return err
+	}
+	var probe [1]byte
+	if n, err := io.ReadFull(r, probe[:]); n > 0 {
+		return fmt.Errorf("%w of %d bytes", ErrLongRead, len(buf))
+	} else if err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package bufpool
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		expectedCap int
+	}{
+		{name: "empty", size: 0, expectedCap: minClass},
+		{name: "smallest class", size: 100, expectedCap: minClass},
+		{name: "exactly a class", size: 64 << 10, expectedCap: 64 << 10},
+		{name: "just over a class", size: 64<<10 + 1, expectedCap: 128 << 10},
+		{name: "largest class", size: maxClass, expectedCap: maxClass},
+		{name: "oversized", size: maxClass + 1, expectedCap: maxClass + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := inUse.Load()
+			b := Get(tt.size)
+			if len(b) != tt.size {
+				t.Errorf("Expected length %d, got %d", tt.size, len(b))
+			}
+			if cap(b) != tt.expectedCap {
+				t.Errorf("Expected capacity %d, got %d", tt.expectedCap, cap(b))
+			}
+			Put(b)
+			if n := inUse.Load(); n != before {
+				t.Errorf("Expected %d bytes in use once returned, got %d", before, n)
+			}
+		})
+	}
+}
+
+func TestReadAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		size    int64
+		wantErr error
+	}{
+		{name: "known size", content: "hello", size: 5},
+		{name: "empty", content: "", size: 0},
+		{name: "unknown size", content: "hello", size: -1},
+		{name: "short", content: "hell", size: 5, wantErr: ErrShortRead},
+		{name: "long", content: "hello!", size: 5, wantErr: ErrLongRead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := ReadAll(strings.NewReader(tt.content), tt.size)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && string(content) != tt.content {
+				t.Errorf("Expected content %q, got %q", tt.content, content)
+			}
+			if tt.size < 0 {
+				return
+			}
+
+			pooled, err := ReadPooled(strings.NewReader(tt.content), tt.size)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected pooled error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil {
+				if string(pooled) != tt.content {
+					t.Errorf("Expected pooled content %q, got %q", tt.content, pooled)
+				}
+				Put(pooled)
+			}
+		})
+	}
+}
+
+func TestCopy(t *testing.T) {
+	content := strings.Repeat("a", 3*CopySize+1)
+	var dst bytes.Buffer
+	// Hiding ReadFrom and WriteTo makes the copy go through the pooled buffer
+	n, err := Copy(struct{ io.Writer }{&dst}, struct{ io.Reader }{strings.NewReader(content)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != int64(len(content)) || dst.String() != content {
+		t.Errorf("Expected %d bytes copied, got %d", len(content), n)
+	}
+}
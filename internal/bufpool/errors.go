@@ -0,0 +1,8 @@
+package bufpool
+
+import "errors"
+
+var (
+	ErrShortRead = errors.New("content ended before its expected size")
+	ErrLongRead  = errors.New("content continued past its expected size")
+)
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache stores byte values by key. Implementations may drop entries at any time.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	// Has reports whether key is cached without reading its value
+	Has(ctx context.Context, key string) bool
+	Set(ctx context.Context, key string, value []byte)
+	Delete(ctx context.Context, key string)
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// LRU is an in-memory cache capped by the total size of its values. The least
+// recently used entries are evicted first; entries also expire after a TTL.
+type LRU struct {
+	mu       sync.Mutex
+	maxBytes int64
+	ttl      time.Duration
+	size     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+// NewLRU creates a cache holding up to maxBytes of values. A ttl of zero keeps entries until evicted.
+func NewLRU(maxBytes int64, ttl time.Duration) *LRU {
+	return &LRU{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *LRU) Get(ctx context.Context, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Has doesn't count as a use, so checking an entry doesn't keep it from eviction
+func (c *LRU) Has(ctx context.Context, key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	e := el.Value.(*entry)
+	return e.expires.IsZero() || !time.Now().After(e.expires)
+}
+
+func (c *LRU) Set(ctx context.Context, key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	if int64(len(value)) > c.maxBytes {
+		return
+	}
+
+	e := &entry{key: key, value: value}
+	if c.ttl > 0 {
+		e.expires = time.Now().Add(c.ttl)
+	}
+	c.entries[key] = c.order.PushFront(e)
+	c.size += int64(len(value))
+
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRU) Delete(ctx context.Context, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Size returns the total size of the cached values in bytes
+func (c *LRU) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Len returns the number of cached entries
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// remove drops an entry; callers hold the lock
+func (c *LRU) remove(el *list.Element) {
+	e := c.order.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	c.size -= int64(len(e.value))
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(10, 0)
+
+	c.Set(ctx, "a", []byte("1234"))
+	c.Set(ctx, "b", []byte("1234"))
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("1234"))
+
+	if _, ok := c.Get(ctx, "b"); ok {
+		t.Error("Expected least recently used entry to be evicted")
+	}
+	if _, ok := c.Get(ctx, "a"); !ok {
+		t.Error("Expected recently used entry to be kept")
+	}
+	if !c.Has(ctx, "a") || c.Has(ctx, "b") {
+		t.Error("Expected Has to report the cached entries only")
+	}
+	if c.Size() != 8 || c.Len() != 2 {
+		t.Errorf("Expected 2 entries of 8 bytes, got %d entries of %d bytes", c.Len(), c.Size())
+	}
+
+	c.Set(ctx, "big", make([]byte, 11))
+	if _, ok := c.Get(ctx, "big"); ok {
+		t.Error("Expected values over the cap not to be cached")
+	}
+
+	c.Delete(ctx, "a")
+	if _, ok := c.Get(ctx, "a"); ok {
+		t.Error("Expected deleted entry to be gone")
+	}
+}
+
+func TestLRU_TTL(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(10, time.Millisecond)
+
+	c.Set(ctx, "a", []byte("1"))
+	time.Sleep(5 * time.Millisecond)
+	if c.Has(ctx, "a") {
+		t.Error("Expected Has to skip expired entries")
+	}
+	if _, ok := c.Get(ctx, "a"); ok {
+		t.Error("Expected entry to expire")
+	}
+	if c.Size() != 0 {
+		t.Errorf("Expected expired entry to be removed, got %d bytes", c.Size())
+	}
+}
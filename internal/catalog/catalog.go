@@ -0,0 +1,424 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/events"
+
+	// Database drivers
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// Supported drivers
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// Object is an object written through the proxy
+type Object struct {
+	Path        string            `json:"path"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"content_type"`
+	MD5         string            `json:"md5,omitempty"`
+	Tenant      string            `json:"tenant,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// Change is an entry of the audit log
+type Change struct {
+	ID     int64     `json:"id"`
+	Type   string    `json:"type"`
+	Path   string    `json:"path"`
+	Tenant string    `json:"tenant,omitempty"`
+	Size   int64     `json:"size"`
+	Time   time.Time `json:"time"`
+}
+
+// Usage is what a tenant currently stores
+type Usage struct {
+	Tenant  string `json:"tenant"`
+	Objects int64  `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// ObjectQuery selects objects in path order, starting after After
+type ObjectQuery struct {
+	Prefix string
+	Tenant string
+	After  string
+	Limit  int
+}
+
+// ChangeQuery selects audit log entries, newest first, below Before if set
+type ChangeQuery struct {
+	Path   string
+	Tenant string
+	Since  time.Time
+	Before int64
+	Limit  int
+}
+
+// Catalog records the objects written and deleted through the proxy in a SQL
+// database, so they can be listed, counted and audited without listing the bucket
+type Catalog struct {
+	db     *sql.DB
+	driver string
+}
+
+var schemas = map[string][]string{
+	DriverSQLite: {
+		`CREATE TABLE IF NOT EXISTS objects (
+			path TEXT PRIMARY KEY,
+			size INTEGER NOT NULL,
+			content_type TEXT NOT NULL,
+			md5 TEXT NOT NULL,
+			tenant TEXT NOT NULL,
+			metadata TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS changes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			path TEXT NOT NULL,
+			tenant TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			time TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS shares (
+			token TEXT PRIMARY KEY,
+			path TEXT NOT NULL,
+			prefix BOOLEAN NOT NULL,
+			expires TIMESTAMP NOT NULL,
+			max_downloads INTEGER NOT NULL,
+			downloads INTEGER NOT NULL,
+			password_hash TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS object_stats (
+			path TEXT PRIMARY KEY,
+			downloads INTEGER NOT NULL,
+			bytes INTEGER NOT NULL,
+			last_access TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			idempotency_key TEXT PRIMARY KEY,
+			record TEXT NOT NULL,
+			expires TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS mounts (
+			prefix TEXT PRIMARY KEY,
+			target TEXT NOT NULL,
+			quota_bytes INTEGER NOT NULL,
+			cache BOOLEAN NOT NULL,
+			scope TEXT NOT NULL,
+			read_only BOOLEAN NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+	},
+	DriverPostgres: {
+		// The C collation sorts and compares paths bytewise, like GCS
+		`CREATE TABLE IF NOT EXISTS objects (
+			path TEXT COLLATE "C" PRIMARY KEY,
+			size BIGINT NOT NULL,
+			content_type TEXT NOT NULL,
+			md5 TEXT NOT NULL,
+			tenant TEXT NOT NULL,
+			metadata TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS changes (
+			id BIGSERIAL PRIMARY KEY,
+			type TEXT NOT NULL,
+			path TEXT COLLATE "C" NOT NULL,
+			tenant TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			time TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS shares (
+			token TEXT PRIMARY KEY,
+			path TEXT COLLATE "C" NOT NULL,
+			prefix BOOLEAN NOT NULL,
+			expires TIMESTAMPTZ NOT NULL,
+			max_downloads BIGINT NOT NULL,
+			downloads BIGINT NOT NULL,
+			password_hash TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS object_stats (
+			path TEXT COLLATE "C" PRIMARY KEY,
+			downloads BIGINT NOT NULL,
+			bytes BIGINT NOT NULL,
+			last_access TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			idempotency_key TEXT PRIMARY KEY,
+			record TEXT NOT NULL,
+			expires TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS mounts (
+			prefix TEXT COLLATE "C" PRIMARY KEY,
+			target TEXT NOT NULL,
+			quota_bytes BIGINT NOT NULL,
+			cache BOOLEAN NOT NULL,
+			scope TEXT NOT NULL,
+			read_only BOOLEAN NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
+		)`,
+	},
+}
+
+var indexes = []string{
+	`CREATE INDEX IF NOT EXISTS objects_tenant ON objects (tenant, path)`,
+	`CREATE INDEX IF NOT EXISTS changes_path ON changes (path, id)`,
+	`CREATE INDEX IF NOT EXISTS changes_tenant ON changes (tenant, id)`,
+	`CREATE INDEX IF NOT EXISTS shares_created_by ON shares (created_by, created_at)`,
+	`CREATE INDEX IF NOT EXISTS idempotency_keys_expires ON idempotency_keys (expires)`,
+}
+
+// Open connects to the database and creates the tables if they don't exist.
+// dsn is a file path for SQLite and a connection URL for Postgres.
+func Open(ctx context.Context, driver, dsn string) (*Catalog, error) {
+	schema, ok := schemas[driver]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedDriver, driver)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open catalog: %w", err)
+	}
+	if driver == DriverSQLite {
+		// SQLite allows one writer at a time; a single connection avoids SQLITE_BUSY
+		db.SetMaxOpenConns(1)
+	}
+
+	for _, statement := range append(schema, indexes...) {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create catalog schema: %w", err)
+		}
+	}
+	return &Catalog{db: db, driver: driver}, nil
+}
+
+// Close closes the database connections
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+// Publish records an object event, attributed to the principal of ctx. It
+// writes to the database before returning, so it is used as a publisher directly.
+func (c *Catalog) Publish(ctx context.Context, event events.Event) error {
+	// The request may end before the event is recorded
+	ctx = context.WithoutCancel(ctx)
+	tenant := auth.PrincipalFromContext(ctx)
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record %s: %w", event.Path, err)
+	}
+	defer tx.Rollback()
+
+	switch event.Type {
+	case events.ObjectWritten:
+		metadata, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata of %s: %w", event.Path, err)
+		}
+		_, err = tx.ExecContext(ctx, c.rebind(`
+			INSERT INTO objects (path, size, content_type, md5, tenant, metadata, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+			ON CONFLICT (path) DO UPDATE SET
+				size = excluded.size, content_type = excluded.content_type, md5 = excluded.md5,
+				tenant = excluded.tenant, metadata = excluded.metadata, updated_at = excluded.updated_at`),
+			event.Path, event.Size, event.ContentType, event.MD5, tenant, string(metadata), event.Time.UTC())
+		if err != nil {
+			return fmt.Errorf("failed to record %s: %w", event.Path, err)
+		}
+	case events.ObjectDeleted:
+		if _, err := tx.ExecContext(ctx, c.rebind(`DELETE FROM objects WHERE path = $1`), event.Path); err != nil {
+			return fmt.Errorf("failed to record deletion of %s: %w", event.Path, err)
+		}
+	default:
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, c.rebind(`INSERT INTO changes (type, path, tenant, size, time) VALUES ($1, $2, $3, $4, $5)`),
+		event.Type, event.Path, tenant, event.Size, event.Time.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record change of %s: %w", event.Path, err)
+	}
+	return tx.Commit()
+}
+
+// Objects returns a page of the recorded objects
+func (c *Catalog) Objects(ctx context.Context, query ObjectQuery) ([]Object, error) {
+	var where []string
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if query.Prefix != "" {
+		where = append(where, "path >= "+arg(query.Prefix))
+		if end, ok := prefixEnd(query.Prefix); ok {
+			where = append(where, "path < "+arg(end))
+		}
+	}
+	if query.After != "" {
+		where = append(where, "path > "+arg(query.After))
+	}
+	if query.Tenant != "" {
+		where = append(where, "tenant = "+arg(query.Tenant))
+	}
+
+	statement := `SELECT path, size, content_type, md5, tenant, metadata, created_at, updated_at FROM objects`
+	if len(where) > 0 {
+		statement += " WHERE " + strings.Join(where, " AND ")
+	}
+	statement += " ORDER BY path"
+	if query.Limit > 0 {
+		statement += " LIMIT " + arg(query.Limit)
+	}
+
+	rows, err := c.db.QueryContext(ctx, c.rebind(statement), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query catalog: %w", err)
+	}
+	defer rows.Close()
+
+	objects := make([]Object, 0)
+	for rows.Next() {
+		var object Object
+		var metadata string
+		if err := rows.Scan(&object.Path, &object.Size, &object.ContentType, &object.MD5, &object.Tenant, &metadata, &object.CreatedAt, &object.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read catalog: %w", err)
+		}
+		if err := json.Unmarshal([]byte(metadata), &object.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode metadata of %s: %w", object.Path, err)
+		}
+		objects = append(objects, object)
+	}
+	return objects, rows.Err()
+}
+
+// Usage returns the number and size of the objects each tenant stores
+func (c *Catalog) Usage(ctx context.Context) ([]Usage, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT tenant, COUNT(*), COALESCE(SUM(size), 0) FROM objects GROUP BY tenant ORDER BY tenant`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query catalog: %w", err)
+	}
+	defer rows.Close()
+
+	usage := make([]Usage, 0)
+	for rows.Next() {
+		var u Usage
+		if err := rows.Scan(&u.Tenant, &u.Objects, &u.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to read catalog: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// PrefixUsage returns the number and size of the objects under prefix
+func (c *Catalog) PrefixUsage(ctx context.Context, prefix string) (objects, bytes int64, err error) {
+	statement := `SELECT COUNT(*), COALESCE(SUM(size), 0) FROM objects WHERE path >= $1`
+	args := []any{prefix}
+	if end, ok := prefixEnd(prefix); ok {
+		statement += ` AND path < $2`
+		args = append(args, end)
+	}
+	if err := c.db.QueryRowContext(ctx, c.rebind(statement), args...).Scan(&objects, &bytes); err != nil {
+		return 0, 0, fmt.Errorf("failed to query catalog: %w", err)
+	}
+	return objects, bytes, nil
+}
+
+// Changes returns a page of the audit log
+func (c *Catalog) Changes(ctx context.Context, query ChangeQuery) ([]Change, error) {
+	var where []string
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if query.Path != "" {
+		where = append(where, "path = "+arg(query.Path))
+	}
+	if query.Tenant != "" {
+		where = append(where, "tenant = "+arg(query.Tenant))
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "time >= "+arg(query.Since.UTC()))
+	}
+	if query.Before > 0 {
+		where = append(where, "id < "+arg(query.Before))
+	}
+
+	statement := `SELECT id, type, path, tenant, size, time FROM changes`
+	if len(where) > 0 {
+		statement += " WHERE " + strings.Join(where, " AND ")
+	}
+	statement += " ORDER BY id DESC"
+	if query.Limit > 0 {
+		statement += " LIMIT " + arg(query.Limit)
+	}
+
+	rows, err := c.db.QueryContext(ctx, c.rebind(statement), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query catalog: %w", err)
+	}
+	defer rows.Close()
+
+	changes := make([]Change, 0)
+	for rows.Next() {
+		var change Change
+		if err := rows.Scan(&change.ID, &change.Type, &change.Path, &change.Tenant, &change.Size, &change.Time); err != nil {
+			return nil, fmt.Errorf("failed to read catalog: %w", err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// rebind turns Postgres placeholders into SQLite's numbered ones
+func (c *Catalog) rebind(statement string) string {
+	if c.driver == DriverSQLite {
+		return strings.ReplaceAll(statement, "$", "?")
+	}
+	return statement
+}
+
+// prefixEnd returns the smallest string greater than every string starting
+// with prefix; there is none for prefixes of only the highest code point
+func prefixEnd(prefix string) (string, bool) {
+	runes := []rune(prefix)
+	for i := len(runes) - 1; i >= 0; i-- {
+		next := runes[i] + 1
+		if next == 0xD800 {
+			// Surrogates are not valid in UTF-8
+			next = 0xE000
+		}
+		if next <= utf8.MaxRune {
+			return string(append(runes[:i], next)), true
+		}
+	}
+	return "", false
+}
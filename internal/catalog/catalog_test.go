@@ -0,0 +1,205 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/events"
+)
+
+func openTestCatalog(t *testing.T) *Catalog {
+	t.Helper()
+	c, err := Open(context.Background(), DriverSQLite, filepath.Join(t.TempDir(), "catalog.db"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func paths(objects []Object) []string {
+	out := make([]string, 0, len(objects))
+	for _, object := range objects {
+		out = append(out, object.Path)
+	}
+	return out
+}
+
+func TestCatalog_Objects(t *testing.T) {
+	c := openTestCatalog(t)
+	acme := auth.WithPrincipal(context.Background(), "acme")
+	globex := auth.WithPrincipal(context.Background(), "globex")
+	written := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, event := range []struct {
+		ctx  context.Context
+		path string
+	}{
+		{acme, "videos/b.mp4"},
+		{acme, "videos/a.mp4"},
+		{globex, "videos0.mp4"},
+		{globex, "photos/c.jpg"},
+		{acme, "photos/d.jpg"},
+	} {
+		err := c.Publish(event.ctx, events.Event{Type: events.ObjectWritten, Path: event.path, Size: 10, Time: written})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := c.Publish(acme, events.Event{Type: events.ObjectDeleted, Path: "photos/d.jpg", Time: written}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query ObjectQuery
+		want  []string
+	}{
+		{name: "all", query: ObjectQuery{}, want: []string{"photos/c.jpg", "videos/a.mp4", "videos/b.mp4", "videos0.mp4"}},
+		{name: "prefix", query: ObjectQuery{Prefix: "videos/"}, want: []string{"videos/a.mp4", "videos/b.mp4"}},
+		{name: "tenant", query: ObjectQuery{Tenant: "globex"}, want: []string{"photos/c.jpg", "videos0.mp4"}},
+		{name: "page", query: ObjectQuery{After: "photos/c.jpg", Limit: 2}, want: []string{"videos/a.mp4", "videos/b.mp4"}},
+		{name: "no match", query: ObjectQuery{Prefix: "docs/"}, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := c.Objects(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := paths(objects); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCatalog_Overwrite(t *testing.T) {
+	c := openTestCatalog(t)
+	ctx := auth.WithPrincipal(context.Background(), "acme")
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+
+	c.Publish(ctx, events.Event{Type: events.ObjectWritten, Path: "a.jpg", Size: 10, ContentType: "image/png", Time: created})
+	c.Publish(ctx, events.Event{
+		Type:        events.ObjectWritten,
+		Path:        "a.jpg",
+		Size:        20,
+		ContentType: "image/jpeg",
+		MD5:         "9e107d9d372bb6826bd81d3542a419d6",
+		Metadata:    map[string]string{"camera": "x100"},
+		Time:        updated,
+	})
+
+	objects, err := c.Objects(context.Background(), ObjectQuery{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []Object{{
+		Path:        "a.jpg",
+		Size:        20,
+		ContentType: "image/jpeg",
+		MD5:         "9e107d9d372bb6826bd81d3542a419d6",
+		Tenant:      "acme",
+		Metadata:    map[string]string{"camera": "x100"},
+		CreatedAt:   created,
+		UpdatedAt:   updated,
+	}}
+	for i := range objects {
+		objects[i].CreatedAt = objects[i].CreatedAt.UTC()
+		objects[i].UpdatedAt = objects[i].UpdatedAt.UTC()
+	}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("Expected %+v, got %+v", want, objects)
+	}
+}
+
+func TestCatalog_UsageAndChanges(t *testing.T) {
+	c := openTestCatalog(t)
+	acme := auth.WithPrincipal(context.Background(), "acme")
+	globex := auth.WithPrincipal(context.Background(), "globex")
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	c.Publish(acme, events.Event{Type: events.ObjectWritten, Path: "a.mp4", Size: 100, Time: start})
+	c.Publish(acme, events.Event{Type: events.ObjectWritten, Path: "b.mp4", Size: 50, Time: start.Add(time.Minute)})
+	c.Publish(globex, events.Event{Type: events.ObjectWritten, Path: "c.mp4", Size: 7, Time: start.Add(2 * time.Minute)})
+	c.Publish(acme, events.Event{Type: events.ObjectDeleted, Path: "a.mp4", Time: start.Add(3 * time.Minute)})
+
+	usage, err := c.Usage(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := []Usage{{Tenant: "acme", Objects: 1, Bytes: 50}, {Tenant: "globex", Objects: 1, Bytes: 7}}; !reflect.DeepEqual(usage, want) {
+		t.Errorf("Expected %v, got %v", want, usage)
+	}
+	if objects, bytes, err := c.PrefixUsage(context.Background(), "b"); err != nil || objects != 1 || bytes != 50 {
+		t.Errorf("Expected 1 object of 50 bytes under b, got %d of %d (%v)", objects, bytes, err)
+	}
+	if objects, bytes, err := c.PrefixUsage(context.Background(), ""); err != nil || objects != 2 || bytes != 57 {
+		t.Errorf("Expected 2 objects of 57 bytes, got %d of %d (%v)", objects, bytes, err)
+	}
+
+	tests := []struct {
+		name  string
+		query ChangeQuery
+		want  []string
+	}{
+		{name: "all", query: ChangeQuery{}, want: []string{"deleted a.mp4", "written c.mp4", "written b.mp4", "written a.mp4"}},
+		{name: "path", query: ChangeQuery{Path: "a.mp4"}, want: []string{"deleted a.mp4", "written a.mp4"}},
+		{name: "tenant", query: ChangeQuery{Tenant: "globex"}, want: []string{"written c.mp4"}},
+		{name: "since", query: ChangeQuery{Since: start.Add(2 * time.Minute)}, want: []string{"deleted a.mp4", "written c.mp4"}},
+		{name: "page", query: ChangeQuery{Before: 3, Limit: 1}, want: []string{"written b.mp4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := c.Changes(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got := make([]string, 0, len(changes))
+			for _, change := range changes {
+				kind := "written"
+				if change.Type == events.ObjectDeleted {
+					kind = "deleted"
+				}
+				got = append(got, kind+" "+change.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestOpen_UnsupportedDriver(t *testing.T) {
+	if _, err := Open(context.Background(), "mysql", ""); !errors.Is(err, ErrUnsupportedDriver) {
+		t.Errorf("Expected ErrUnsupportedDriver, got %v", err)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+		ok     bool
+	}{
+		{"videos/", "videos0", true},
+		{"a", "b", true},
+		{"\U0010FFFF", "", false},
+		{"a\U0010FFFF", "b", true},
+		{"\uD7FF", "\uE000", true},
+	}
+
+	for _, tt := range tests {
+		if got, ok := prefixEnd(tt.prefix); got != tt.want || ok != tt.ok {
+			t.Errorf("Expected %q, %v for %q, got %q, %v", tt.want, tt.ok, tt.prefix, got, ok)
+		}
+	}
+}
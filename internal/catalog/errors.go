@@ -0,0 +1,7 @@
+package catalog
+
+import "errors"
+
+var (
+	ErrUnsupportedDriver = errors.New("unsupported catalog driver")
+)
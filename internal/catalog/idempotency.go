@@ -0,0 +1,81 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gcp-proxy-mity/internal/idempotency"
+)
+
+// IdempotencyStore keeps Idempotency-Key records in the catalog database, so
+// retries are recognized by every replica and after restarts
+type IdempotencyStore struct {
+	c *Catalog
+}
+
+// NewIdempotencyStore creates an idempotency store in the catalog's database
+func NewIdempotencyStore(c *Catalog) *IdempotencyStore {
+	return &IdempotencyStore{c: c}
+}
+
+func (s *IdempotencyStore) Reserve(ctx context.Context, key string, record idempotency.Record, ttl time.Duration) (*idempotency.Record, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	tx, err := s.c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Expired keys are dropped here, since nothing else would
+	if _, err := tx.ExecContext(ctx, s.c.rebind(`DELETE FROM idempotency_keys WHERE expires <= $1`), now); err != nil {
+		return nil, fmt.Errorf("failed to drop expired idempotency keys: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, s.c.rebind(`
+		INSERT INTO idempotency_keys (idempotency_key, record, expires) VALUES ($1, $2, $3)
+		ON CONFLICT (idempotency_key) DO NOTHING`), key, string(data), now.Add(ttl))
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if inserted, err := result.RowsAffected(); err == nil && inserted == 1 {
+		return nil, tx.Commit()
+	}
+
+	var existing string
+	err = tx.QueryRowContext(ctx, s.c.rebind(`SELECT record FROM idempotency_keys WHERE idempotency_key = $1`), key).Scan(&existing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+	var current idempotency.Record
+	if err := json.Unmarshal([]byte(existing), &current); err != nil {
+		return nil, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+	return &current, tx.Commit()
+}
+
+func (s *IdempotencyStore) Complete(ctx context.Context, key string, record idempotency.Record, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.c.db.ExecContext(ctx, s.c.rebind(`
+		INSERT INTO idempotency_keys (idempotency_key, record, expires) VALUES ($1, $2, $3)
+		ON CONFLICT (idempotency_key) DO UPDATE SET record = excluded.record, expires = excluded.expires`),
+		key, string(data), time.Now().UTC().Add(ttl))
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+func (s *IdempotencyStore) Release(ctx context.Context, key string) error {
+	if _, err := s.c.db.ExecContext(ctx, s.c.rebind(`DELETE FROM idempotency_keys WHERE idempotency_key = $1`), key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package catalog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/idempotency"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	store := NewIdempotencyStore(openTestCatalog(t))
+	ctx := context.Background()
+
+	existing, err := store.Reserve(ctx, "acme:k", idempotency.Record{Fingerprint: "f"}, time.Minute)
+	if err != nil || existing != nil {
+		t.Fatalf("Expected the key to be reserved, got %+v, %v", existing, err)
+	}
+	existing, err = store.Reserve(ctx, "acme:k", idempotency.Record{Fingerprint: "g"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if existing == nil || existing.Fingerprint != "f" || existing.Status != 0 {
+		t.Errorf("Expected the pending record, got %+v", existing)
+	}
+
+	record := idempotency.Record{Fingerprint: "f", Status: 201, Body: []byte(`{"ok":true}`)}
+	if err := store.Complete(ctx, "acme:k", record, time.Minute); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	existing, err = store.Reserve(ctx, "acme:k", idempotency.Record{Fingerprint: "f"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if existing == nil || existing.Status != 201 || string(existing.Body) != `{"ok":true}` {
+		t.Errorf("Expected the completed record, got %+v", existing)
+	}
+
+	if err := store.Release(ctx, "acme:k"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if existing, err := store.Reserve(ctx, "acme:k", idempotency.Record{Fingerprint: "f"}, -time.Second); err != nil || existing != nil {
+		t.Errorf("Expected a released key to be reserved again, got %+v, %v", existing, err)
+	}
+	// The record above expired at once
+	if existing, err := store.Reserve(ctx, "acme:k", idempotency.Record{Fingerprint: "f"}, time.Minute); err != nil || existing != nil {
+		t.Errorf("Expected an expired key to be reserved again, got %+v, %v", existing, err)
+	}
+}
@@ -0,0 +1,59 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+
+	"gcp-proxy-mity/internal/mount"
+)
+
+// MountStore keeps the mounts added at runtime in the catalog database, so
+// they survive restarts and every replica serves them
+type MountStore struct {
+	c *Catalog
+}
+
+// NewMountStore creates a mount store in the catalog's database
+func NewMountStore(c *Catalog) *MountStore {
+	return &MountStore{c: c}
+}
+
+const mountColumns = `prefix, target, quota_bytes, cache, scope, read_only, created_by, created_at`
+
+func (s *MountStore) Create(ctx context.Context, m mount.Mount) error {
+	_, err := s.c.db.ExecContext(ctx, s.c.rebind(`INSERT INTO mounts (`+mountColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`),
+		m.Prefix, m.Target, m.QuotaBytes, m.Cache, m.Scope, m.ReadOnly, m.CreatedBy, m.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record mount at %s: %w", m.Prefix, err)
+	}
+	return nil
+}
+
+func (s *MountStore) List(ctx context.Context) ([]mount.Mount, error) {
+	rows, err := s.c.db.QueryContext(ctx, `SELECT `+mountColumns+` FROM mounts ORDER BY prefix`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query mounts: %w", err)
+	}
+	defer rows.Close()
+
+	mounts := make([]mount.Mount, 0)
+	for rows.Next() {
+		var m mount.Mount
+		if err := rows.Scan(&m.Prefix, &m.Target, &m.QuotaBytes, &m.Cache, &m.Scope, &m.ReadOnly, &m.CreatedBy, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read mount: %w", err)
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, rows.Err()
+}
+
+func (s *MountStore) Delete(ctx context.Context, prefix string) error {
+	result, err := s.c.db.ExecContext(ctx, s.c.rebind(`DELETE FROM mounts WHERE prefix = $1`), prefix)
+	if err != nil {
+		return fmt.Errorf("failed to delete mount: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return mount.ErrNotFound
+	}
+	return nil
+}
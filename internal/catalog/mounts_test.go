@@ -0,0 +1,49 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/mount"
+)
+
+func TestMountStore(t *testing.T) {
+	store := NewMountStore(openTestCatalog(t))
+	ctx := context.Background()
+	created := time.Now().UTC().Truncate(time.Millisecond)
+
+	for _, m := range []mount.Mount{
+		{Prefix: "videos/", Target: "gs://videos", QuotaBytes: 1 << 30, Cache: true, CreatedBy: "ops", CreatedAt: created},
+		{Prefix: "scratch/", Target: "file:///var/lib/scratch", Scope: "scratch", ReadOnly: true, CreatedAt: created},
+	} {
+		if err := store.Create(ctx, m); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := store.Create(ctx, mount.Mount{Prefix: "videos/", Target: "gs://other", CreatedAt: created}); err == nil {
+		t.Error("Expected a second mount at the same prefix to be refused")
+	}
+
+	mounts, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(mounts) != 2 || mounts[0].Prefix != "scratch/" || mounts[1].Prefix != "videos/" {
+		t.Fatalf("Expected the mounts by prefix, got %+v", mounts)
+	}
+	if got := mounts[0]; got.Scope != "scratch" || !got.ReadOnly || got.Cache {
+		t.Errorf("Expected the scratch mount to round-trip, got %+v", got)
+	}
+	if got := mounts[1]; got.QuotaBytes != 1<<30 || !got.Cache || got.CreatedBy != "ops" || !got.CreatedAt.Equal(created) {
+		t.Errorf("Expected the videos mount to round-trip, got %+v", got)
+	}
+
+	if err := store.Delete(ctx, "videos/"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := store.Delete(ctx, "videos/"); !errors.Is(err, mount.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+}
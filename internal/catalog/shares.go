@@ -0,0 +1,118 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gcp-proxy-mity/internal/share"
+)
+
+// ShareStore keeps share links in the catalog database, so they survive
+// restarts and work through every replica
+type ShareStore struct {
+	c *Catalog
+}
+
+// NewShareStore creates a share store in the catalog's database
+func NewShareStore(c *Catalog) *ShareStore {
+	return &ShareStore{c: c}
+}
+
+const shareColumns = `token, path, prefix, expires, max_downloads, downloads, password_hash, created_by, created_at`
+
+func (s *ShareStore) Create(ctx context.Context, sh share.Share) error {
+	_, err := s.c.db.ExecContext(ctx, s.c.rebind(`INSERT INTO shares (`+shareColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`),
+		sh.Token, sh.Path, sh.Prefix, sh.Expires.UTC(), sh.MaxDownloads, sh.Downloads, sh.PasswordHash, sh.CreatedBy, sh.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record share of %s: %w", sh.Path, err)
+	}
+	// Expired shares are dropped here, since nothing else would
+	if _, err := s.c.db.ExecContext(ctx, s.c.rebind(`DELETE FROM shares WHERE expires <= $1`), time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to drop expired shares: %w", err)
+	}
+	return nil
+}
+
+func (s *ShareStore) Get(ctx context.Context, token string) (*share.Share, error) {
+	row := s.c.db.QueryRowContext(ctx, s.c.rebind(`SELECT `+shareColumns+` FROM shares WHERE token = $1`), token)
+	sh, err := scanShare(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+func (s *ShareStore) List(ctx context.Context, createdBy string) ([]share.Share, error) {
+	statement := `SELECT ` + shareColumns + ` FROM shares`
+	var args []any
+	if createdBy != "" {
+		statement += ` WHERE created_by = $1`
+		args = append(args, createdBy)
+	}
+	rows, err := s.c.db.QueryContext(ctx, s.c.rebind(statement+` ORDER BY created_at DESC, token`), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shares: %w", err)
+	}
+	defer rows.Close()
+
+	shares := make([]share.Share, 0)
+	for rows.Next() {
+		sh, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, *sh)
+	}
+	return shares, rows.Err()
+}
+
+func (s *ShareStore) Delete(ctx context.Context, token string) error {
+	result, err := s.c.db.ExecContext(ctx, s.c.rebind(`DELETE FROM shares WHERE token = $1`), token)
+	if err != nil {
+		return fmt.Errorf("failed to delete share: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return share.ErrNotFound
+	}
+	return nil
+}
+
+func (s *ShareStore) CountDownload(ctx context.Context, token string) error {
+	// The limit is checked in the update so concurrent downloads can't exceed it
+	result, err := s.c.db.ExecContext(ctx, s.c.rebind(`
+		UPDATE shares SET downloads = downloads + 1
+		WHERE token = $1 AND (max_downloads = 0 OR downloads < max_downloads)`), token)
+	if err != nil {
+		return fmt.Errorf("failed to count download: %w", err)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		sh, err := s.Get(ctx, token)
+		if err != nil {
+			return err
+		}
+		if sh == nil {
+			return share.ErrNotFound
+		}
+		return share.ErrExhausted
+	}
+	return nil
+}
+
+// scanShare reads a share from a row of shareColumns
+func scanShare(row interface{ Scan(...any) error }) (*share.Share, error) {
+	var sh share.Share
+	err := row.Scan(&sh.Token, &sh.Path, &sh.Prefix, &sh.Expires, &sh.MaxDownloads, &sh.Downloads, &sh.PasswordHash, &sh.CreatedBy, &sh.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read share: %w", err)
+	}
+	return &sh, nil
+}
@@ -0,0 +1,76 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/share"
+)
+
+func TestShareStore(t *testing.T) {
+	store := NewShareStore(openTestCatalog(t))
+	ctx := context.Background()
+	created := time.Now().UTC().Truncate(time.Millisecond)
+
+	for _, sh := range []share.Share{
+		{Token: "a", Path: "docs/a.pdf", Expires: created.Add(time.Hour), MaxDownloads: 2, CreatedBy: "acme", CreatedAt: created},
+		{Token: "b", Path: "docs/", Prefix: true, Expires: created.Add(time.Hour), PasswordHash: "hash", CreatedBy: "acme", CreatedAt: created.Add(time.Second)},
+		{Token: "c", Path: "c.pdf", Expires: created.Add(time.Hour), CreatedBy: "globex", CreatedAt: created},
+	} {
+		if err := store.Create(ctx, sh); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	got, err := store.Get(ctx, "b")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got == nil || !got.Prefix || got.PasswordHash != "hash" || !got.Expires.Equal(created.Add(time.Hour)) {
+		t.Errorf("Expected share b to round-trip, got %+v", got)
+	}
+	if got, err := store.Get(ctx, "missing"); got != nil || err != nil {
+		t.Errorf("Expected no share, got %+v, %v", got, err)
+	}
+
+	shares, err := store.List(ctx, "acme")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(shares) != 2 || shares[0].Token != "b" || shares[1].Token != "a" {
+		t.Errorf("Expected the shares of acme newest first, got %+v", shares)
+	}
+
+	for range 2 {
+		if err := store.CountDownload(ctx, "a"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := store.CountDownload(ctx, "a"); !errors.Is(err, share.ErrExhausted) {
+		t.Errorf("Expected ErrExhausted, got %v", err)
+	}
+	if got, _ := store.Get(ctx, "a"); got == nil || got.Downloads != 2 {
+		t.Errorf("Expected 2 downloads, got %+v", got)
+	}
+	if err := store.CountDownload(ctx, "missing"); !errors.Is(err, share.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := store.Delete(ctx, "c"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := store.Delete(ctx, "c"); !errors.Is(err, share.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+
+	// Creating a share drops the expired ones
+	expired := share.Share{Token: "d", Path: "d.pdf", Expires: created.Add(-time.Hour), CreatedAt: created}
+	if err := store.Create(ctx, expired); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, _ := store.Get(ctx, "d"); got != nil {
+		t.Errorf("Expected the expired share to be dropped, got %+v", got)
+	}
+}
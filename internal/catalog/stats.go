@@ -0,0 +1,124 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gcp-proxy-mity/internal/stats"
+)
+
+// StatsStore keeps download statistics in the catalog database, so they
+// survive restarts and add up the downloads of every replica
+type StatsStore struct {
+	c *Catalog
+}
+
+// NewStatsStore creates a statistics store in the catalog's database
+func NewStatsStore(c *Catalog) *StatsStore {
+	return &StatsStore{c: c}
+}
+
+// statsOrder maps sort orders to the columns they sort by
+var statsOrder = map[string]string{
+	stats.SortDownloads:  "downloads",
+	stats.SortBytes:      "bytes",
+	stats.SortLastAccess: "last_access",
+}
+
+func (s *StatsStore) Add(ctx context.Context, entries []stats.Stats) error {
+	tx, err := s.c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record download statistics: %w", err)
+	}
+	defer tx.Rollback()
+
+	statement, err := tx.PrepareContext(ctx, s.c.rebind(`
+		INSERT INTO object_stats (path, downloads, bytes, last_access) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (path) DO UPDATE SET
+			downloads = object_stats.downloads + excluded.downloads,
+			bytes = object_stats.bytes + excluded.bytes,
+			last_access = CASE WHEN excluded.last_access > object_stats.last_access
+				THEN excluded.last_access ELSE object_stats.last_access END`))
+	if err != nil {
+		return fmt.Errorf("failed to record download statistics: %w", err)
+	}
+	defer statement.Close()
+	for _, entry := range entries {
+		if _, err := statement.ExecContext(ctx, entry.Path, entry.Downloads, entry.Bytes, entry.LastAccess.UTC()); err != nil {
+			return fmt.Errorf("failed to record download statistics of %s: %w", entry.Path, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *StatsStore) Get(ctx context.Context, path string) (*stats.Stats, error) {
+	var entry stats.Stats
+	err := s.c.db.QueryRowContext(ctx, s.c.rebind(`SELECT path, downloads, bytes, last_access FROM object_stats WHERE path = $1`), path).
+		Scan(&entry.Path, &entry.Downloads, &entry.Bytes, &entry.LastAccess)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read download statistics: %w", err)
+	}
+	return &entry, nil
+}
+
+func (s *StatsStore) List(ctx context.Context, query stats.Query) ([]stats.Stats, error) {
+	column, ok := statsOrder[query.Sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown sort %q", stats.ErrInvalidQuery, query.Sort)
+	}
+	direction := "DESC"
+	if query.Ascending {
+		direction = "ASC"
+	}
+
+	var where []string
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if query.Prefix != "" {
+		where = append(where, "path >= "+arg(query.Prefix))
+		if end, ok := prefixEnd(query.Prefix); ok {
+			where = append(where, "path < "+arg(end))
+		}
+	}
+
+	statement := `SELECT path, downloads, bytes, last_access FROM object_stats`
+	if len(where) > 0 {
+		statement += " WHERE " + strings.Join(where, " AND ")
+	}
+	statement += " ORDER BY " + column + " " + direction + ", path"
+	if query.Limit > 0 {
+		statement += " LIMIT " + arg(query.Limit)
+	}
+
+	rows, err := s.c.db.QueryContext(ctx, s.c.rebind(statement), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query download statistics: %w", err)
+	}
+	defer rows.Close()
+
+	list := make([]stats.Stats, 0)
+	for rows.Next() {
+		var entry stats.Stats
+		if err := rows.Scan(&entry.Path, &entry.Downloads, &entry.Bytes, &entry.LastAccess); err != nil {
+			return nil, fmt.Errorf("failed to read download statistics: %w", err)
+		}
+		list = append(list, entry)
+	}
+	return list, rows.Err()
+}
+
+func (s *StatsStore) Delete(ctx context.Context, path string) error {
+	if _, err := s.c.db.ExecContext(ctx, s.c.rebind(`DELETE FROM object_stats WHERE path = $1`), path); err != nil {
+		return fmt.Errorf("failed to delete download statistics of %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package catalog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/stats"
+)
+
+func TestStatsStore(t *testing.T) {
+	store := NewStatsStore(openTestCatalog(t))
+	ctx := context.Background()
+	first := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := store.Add(ctx, []stats.Stats{
+		{Path: "videos/a.mp4", Downloads: 1, Bytes: 100, LastAccess: first.Add(time.Hour)},
+		{Path: "videos/b.mp4", Downloads: 3, Bytes: 30, LastAccess: first},
+		{Path: "photos/c.jpg", Downloads: 2, Bytes: 5, LastAccess: first.Add(2 * time.Hour)},
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// An older last access doesn't move it back
+	if err := store.Add(ctx, []stats.Stats{{Path: "videos/a.mp4", Downloads: 4, Bytes: 10, LastAccess: first}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	a, err := store.Get(ctx, "videos/a.mp4")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if a == nil || a.Downloads != 5 || a.Bytes != 110 || !a.LastAccess.Equal(first.Add(time.Hour)) {
+		t.Errorf("Expected the totals of a.mp4, got %+v", a)
+	}
+	if got, err := store.Get(ctx, "missing"); got != nil || err != nil {
+		t.Errorf("Expected no statistics, got %+v, %v", got, err)
+	}
+
+	tests := []struct {
+		name  string
+		query stats.Query
+		want  []string
+	}{
+		{name: "most downloaded", query: stats.Query{Sort: stats.SortDownloads}, want: []string{"videos/a.mp4", "videos/b.mp4", "photos/c.jpg"}},
+		{name: "coldest", query: stats.Query{Sort: stats.SortLastAccess, Ascending: true, Limit: 2}, want: []string{"videos/b.mp4", "videos/a.mp4"}},
+		{name: "prefix by bytes", query: stats.Query{Prefix: "videos/", Sort: stats.SortBytes}, want: []string{"videos/a.mp4", "videos/b.mp4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := store.List(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got := make([]string, 0, len(list))
+			for _, entry := range list {
+				got = append(got, entry.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if err := store.Delete(ctx, "videos/a.mp4"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, _ := store.Get(ctx, "videos/a.mp4"); got != nil {
+		t.Errorf("Expected the statistics to be deleted, got %+v", got)
+	}
+}
@@ -0,0 +1,198 @@
+package changes
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/events"
+)
+
+// Change types
+const (
+	// Written is a new object or a new version replacing one
+	Written = "written"
+	// MetadataUpdated is a change to the metadata of an object in place
+	MetadataUpdated = "metadata_updated"
+	Deleted         = "deleted"
+)
+
+// Sources of changes
+const (
+	SourceProxy        = "proxy"
+	SourceNotification = "notification"
+)
+
+// Change is an entry of the feed
+type Change struct {
+	// Cursor is the position of the change in the feed
+	Cursor      string    `json:"cursor"`
+	Type        string    `json:"type"`
+	Path        string    `json:"path"`
+	Size        int64     `json:"size,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	MD5         string    `json:"md5,omitempty"`
+	Source      string    `json:"source"`
+	Time        time.Time `json:"time"`
+}
+
+// Store is an append-only log of changes that drops the oldest ones beyond its retention
+type Store interface {
+	// Append adds a change and returns its cursor
+	Append(ctx context.Context, change Change) (string, error)
+	// Read returns up to limit changes after cursor, oldest first; an empty
+	// cursor reads from the oldest retained change. It fails with
+	// ErrCursorExpired if changes after cursor were dropped.
+	Read(ctx context.Context, cursor string, limit int) ([]Change, error)
+	// Latest returns the cursor of the newest change, to read only later ones
+	Latest(ctx context.Context) (string, error)
+}
+
+// Feed records object changes observed through the proxy or bucket
+// notifications in a Store. Objects under the excluded prefixes, like the
+// proxy's internal ones, are left out.
+type Feed struct {
+	store   Store
+	exclude []string
+}
+
+// New creates a feed recording into store
+func New(store Store, exclude []string) *Feed {
+	return &Feed{
+		store:   store,
+		exclude: exclude,
+	}
+}
+
+// Read returns up to limit changes after cursor, oldest first
+func (f *Feed) Read(ctx context.Context, cursor string, limit int) ([]Change, error) {
+	return f.store.Read(ctx, cursor, limit)
+}
+
+// Latest returns the cursor of the newest change
+func (f *Feed) Latest(ctx context.Context) (string, error) {
+	return f.store.Latest(ctx)
+}
+
+// Publish records an object event of the proxy. The change is appended before
+// returning, so the feed is used as a publisher directly.
+func (f *Feed) Publish(ctx context.Context, event events.Event) error {
+	change := Change{Path: event.Path, Source: SourceProxy, Time: event.Time}
+	switch event.Type {
+	case events.ObjectWritten:
+		change.Type = Written
+		change.Size = event.Size
+		change.ContentType = event.ContentType
+		change.MD5 = event.MD5
+	case events.ObjectDeleted:
+		change.Type = Deleted
+	default:
+		return nil
+	}
+	return f.append(context.WithoutCancel(ctx), change)
+}
+
+// HandleNotification records a Cloud Storage notification
+func (f *Feed) HandleNotification(ctx context.Context, notification events.ObjectNotification) error {
+	change := Change{Path: notification.Path, Source: SourceNotification, Time: time.Now().UTC()}
+	switch notification.Type {
+	case events.NotificationFinalize:
+		change.Type = Written
+	case events.NotificationMetadataUpdate:
+		change.Type = MetadataUpdated
+	case events.NotificationDelete:
+		// The replacing generation arrives as its own finalize notification
+		if notification.Overwritten {
+			return nil
+		}
+		change.Type = Deleted
+	default:
+		return nil
+	}
+	if change.Type != Deleted {
+		change.Size = notification.Size
+		change.ContentType = notification.ContentType
+	}
+	return f.append(ctx, change)
+}
+
+func (f *Feed) append(ctx context.Context, change Change) error {
+	for _, prefix := range f.exclude {
+		if strings.HasPrefix(change.Path, prefix) {
+			return nil
+		}
+	}
+	if _, err := f.store.Append(ctx, change); err != nil {
+		return fmt.Errorf("failed to record change of %s: %w", change.Path, err)
+	}
+	return nil
+}
+
+// MemoryStore keeps the newest changes in memory, so the feed is per replica
+// and starts over on restart. Cursors carry the start time of the store, so
+// cursors of an earlier run are reported as expired.
+type MemoryStore struct {
+	mu    sync.Mutex
+	epoch string
+	// changes is a ring buffer of the newest changes; sequence number n is at (n-1) % len(changes)
+	changes []Change
+	count   int
+	next    int64
+}
+
+// NewMemoryStore creates a store keeping up to retention changes
+func NewMemoryStore(retention int) *MemoryStore {
+	return &MemoryStore{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		changes: make([]Change, retention),
+		next:    1,
+	}
+}
+
+func (s *MemoryStore) Append(ctx context.Context, change Change) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change.Cursor = s.cursor(s.next)
+	s.changes[(s.next-1)%int64(len(s.changes))] = change
+	s.next++
+	s.count = min(s.count+1, len(s.changes))
+	return change.Cursor, nil
+}
+
+func (s *MemoryStore) Read(ctx context.Context, cursor string, limit int) ([]Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldest := s.next - int64(s.count)
+	from := oldest
+	if cursor != "" {
+		epoch, seq, ok := strings.Cut(cursor, ".")
+		n, err := strconv.ParseInt(seq, 10, 64)
+		if !ok || err != nil || n < 0 {
+			return nil, ErrInvalidCursor
+		}
+		if epoch != s.epoch || n >= s.next || n+1 < oldest {
+			return nil, ErrCursorExpired
+		}
+		from = n + 1
+	}
+
+	changes := make([]Change, 0, min(limit, int(s.next-from)))
+	for n := from; n < s.next && len(changes) < limit; n++ {
+		changes = append(changes, s.changes[(n-1)%int64(len(s.changes))])
+	}
+	return changes, nil
+}
+
+func (s *MemoryStore) Latest(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cursor(s.next - 1), nil
+}
+
+func (s *MemoryStore) cursor(seq int64) string {
+	return s.epoch + "." + strconv.FormatInt(seq, 10)
+}
@@ -0,0 +1,88 @@
+package changes
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gcp-proxy-mity/internal/events"
+)
+
+func describe(changes []Change) []string {
+	out := make([]string, 0, len(changes))
+	for _, change := range changes {
+		out = append(out, change.Type+" "+change.Path)
+	}
+	return out
+}
+
+func TestFeed_Record(t *testing.T) {
+	feed := New(NewMemoryStore(10), []string{"_trash/"})
+	ctx := context.Background()
+
+	feed.Publish(ctx, events.Event{Type: events.ObjectWritten, Path: "a.mp4", Size: 3, MD5: "abc"})
+	feed.Publish(ctx, events.Event{Type: events.ObjectWritten, Path: "_trash/a.mp4"})
+	feed.HandleNotification(ctx, events.ObjectNotification{Type: events.NotificationFinalize, Path: "b.mp4"})
+	feed.HandleNotification(ctx, events.ObjectNotification{Type: events.NotificationDelete, Path: "b.mp4", Overwritten: true})
+	feed.HandleNotification(ctx, events.ObjectNotification{Type: events.NotificationMetadataUpdate, Path: "b.mp4"})
+	feed.HandleNotification(ctx, events.ObjectNotification{Type: events.NotificationDelete, Path: "b.mp4"})
+	feed.Publish(ctx, events.Event{Type: events.ObjectDeleted, Path: "a.mp4"})
+
+	changes, err := feed.Read(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"written a.mp4", "written b.mp4", "metadata_updated b.mp4", "deleted b.mp4", "deleted a.mp4"}
+	if got := describe(changes); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if changes[0].Size != 3 || changes[0].MD5 != "abc" || changes[0].Source != SourceProxy || changes[1].Source != SourceNotification {
+		t.Errorf("Expected the details of the changes to be kept, got %+v", changes[:2])
+	}
+}
+
+func TestMemoryStore_Read(t *testing.T) {
+	store := NewMemoryStore(3)
+	ctx := context.Background()
+	empty, _ := store.Latest(ctx)
+
+	var cursors []string
+	for _, path := range []string{"a", "b", "c", "d", "e"} {
+		cursor, err := store.Append(ctx, Change{Type: Written, Path: path})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		cursors = append(cursors, cursor)
+	}
+	latest, _ := store.Latest(ctx)
+
+	tests := []struct {
+		name   string
+		cursor string
+		limit  int
+		want   []string
+		err    error
+	}{
+		{name: "oldest retained", cursor: "", limit: 10, want: []string{"written c", "written d", "written e"}},
+		{name: "after cursor", cursor: cursors[2], limit: 10, want: []string{"written d", "written e"}},
+		{name: "limit", cursor: cursors[1], limit: 1, want: []string{"written c"}},
+		{name: "latest", cursor: latest, limit: 10, want: []string{}},
+		{name: "dropped", cursor: cursors[0], limit: 10, err: ErrCursorExpired},
+		{name: "before the first change", cursor: empty, limit: 10, err: ErrCursorExpired},
+		{name: "earlier run", cursor: "x.4", limit: 10, err: ErrCursorExpired},
+		{name: "invalid", cursor: "latest", limit: 10, err: ErrInvalidCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := store.Read(ctx, tt.cursor, tt.limit)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected error %v, got %v", tt.err, err)
+			}
+			if tt.err == nil && !reflect.DeepEqual(describe(changes), tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, describe(changes))
+			}
+		})
+	}
+}
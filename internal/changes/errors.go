@@ -0,0 +1,8 @@
+package changes
+
+import "errors"
+
+var (
+	ErrInvalidCursor = errors.New("invalid change feed cursor")
+	ErrCursorExpired = errors.New("changes after the cursor are no longer retained")
+)
@@ -0,0 +1,145 @@
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type contextKey struct{}
+
+// Client is the client of a request as seen through the trusted proxies
+type Client struct {
+	Addr netip.Addr
+	// Scheme is the scheme the client used, http or https
+	Scheme string
+}
+
+// Resolver finds the address and scheme of the client behind the trusted
+// proxies, like load balancers, in front of the server
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// New creates a resolver trusting the X-Forwarded-For of requests from the
+// given ranges. Without trusted ranges the remote address is the client.
+func New(trusted []netip.Prefix) *Resolver {
+	return &Resolver{
+		trusted: trusted,
+	}
+}
+
+// Middleware stores the client in the request context for FromRequest
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, res.Resolve(r))))
+	})
+}
+
+// Resolve returns the client of a request
+func (res *Resolver) Resolve(r *http.Request) Client {
+	client := direct(r)
+	client.Addr = res.IP(r)
+	if res.Trusted(remoteAddr(r)) {
+		// Proxies that append to X-Forwarded-Proto put the scheme they
+		// received last
+		forwarded := r.Header.Values("X-Forwarded-Proto")
+		if len(forwarded) > 0 {
+			values := strings.Split(forwarded[len(forwarded)-1], ",")
+			switch scheme := strings.ToLower(strings.TrimSpace(values[len(values)-1])); scheme {
+			case "http", "https":
+				client.Scheme = scheme
+			}
+		}
+	}
+	return client
+}
+
+// FromRequest returns the client stored by Resolver.Middleware, or the peer
+// of the connection without it
+func FromRequest(r *http.Request) Client {
+	if client, ok := r.Context().Value(contextKey{}).(Client); ok {
+		return client
+	}
+	return direct(r)
+}
+
+func direct(r *http.Request) Client {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return Client{Addr: remoteAddr(r), Scheme: scheme}
+}
+
+// IP returns the client's address. X-Forwarded-For is read from the right,
+// since proxies append the address they received a request from, and the
+// first address that isn't a trusted proxy is the client. Entries left of it
+// were sent by the client and can't be trusted.
+func (res *Resolver) IP(r *http.Request) netip.Addr {
+	addr := remoteAddr(r)
+	if !res.Trusted(addr) {
+		return addr
+	}
+	hops := r.Header.Values("X-Forwarded-For")
+	for i := len(hops) - 1; i >= 0; i-- {
+		entries := strings.Split(hops[i], ",")
+		for j := len(entries) - 1; j >= 0; j-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(entries[j]))
+			if err != nil {
+				// A malformed entry ends the chain the proxies vouch for
+				return addr
+			}
+			addr = hop.Unmap()
+			if !res.Trusted(addr) {
+				return addr
+			}
+		}
+	}
+	return addr
+}
+
+// Trusted reports whether addr is a trusted proxy
+func (res *Resolver) Trusted(addr netip.Addr) bool {
+	return Contains(res.trusted, addr)
+}
+
+// Contains reports whether one of the prefixes contains addr
+func Contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePrefixes parses CIDR ranges like 10.0.0.0/8; single addresses are
+// taken as ranges of one
+func ParsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if addr, err := netip.ParseAddr(value); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr.Unmap()
+}
@@ -0,0 +1,117 @@
+package clientip
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestResolver_IP(t *testing.T) {
+	trusted, err := ParsePrefixes([]string{"35.191.0.0/16", "130.211.0.0/22", "34.120.1.1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resolver := New(trusted)
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		expected  string
+	}{
+		{name: "direct", remote: "203.0.113.7:51234", expected: "203.0.113.7"},
+		{name: "untrusted peer", remote: "203.0.113.7:51234", forwarded: []string{"10.0.0.1"}, expected: "203.0.113.7"},
+		{name: "behind load balancer", remote: "35.191.4.2:443", forwarded: []string{"198.51.100.9, 34.120.1.1"}, expected: "198.51.100.9"},
+		{name: "spoofed by client", remote: "35.191.4.2:443", forwarded: []string{"10.0.0.1, 198.51.100.9, 34.120.1.1"}, expected: "198.51.100.9"},
+		{name: "repeated headers", remote: "35.191.4.2:443", forwarded: []string{"10.0.0.1", "198.51.100.9", "34.120.1.1"}, expected: "198.51.100.9"},
+		{name: "mapped IPv4", remote: "[::ffff:35.191.4.2]:443", forwarded: []string{"198.51.100.9"}, expected: "198.51.100.9"},
+		{name: "IPv6 client", remote: "35.191.4.2:443", forwarded: []string{"2001:db8::1"}, expected: "2001:db8::1"},
+		{name: "malformed entry", remote: "35.191.4.2:443", forwarded: []string{"unknown, 34.120.1.1"}, expected: "34.120.1.1"},
+		{name: "only proxies", remote: "35.191.4.2:443", forwarded: []string{"130.211.0.5"}, expected: "130.211.0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, hop := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", hop)
+			}
+			if got := resolver.IP(r); got != netip.MustParseAddr(tt.expected) {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestResolver_Middleware(t *testing.T) {
+	resolver := New([]netip.Prefix{netip.MustParsePrefix("169.254.0.0/16")})
+
+	tests := []struct {
+		name     string
+		remote   string
+		tls      bool
+		proto    []string
+		expected Client
+	}{
+		{name: "direct", remote: "203.0.113.7:1234", expected: Client{Addr: netip.MustParseAddr("203.0.113.7"), Scheme: "http"}},
+		{name: "direct TLS", remote: "203.0.113.7:1234", tls: true, expected: Client{Addr: netip.MustParseAddr("203.0.113.7"), Scheme: "https"}},
+		{name: "spoofed scheme", remote: "203.0.113.7:1234", proto: []string{"https"}, expected: Client{Addr: netip.MustParseAddr("203.0.113.7"), Scheme: "http"}},
+		{name: "forwarded scheme", remote: "169.254.1.1:1234", proto: []string{"https"}, expected: Client{Addr: netip.MustParseAddr("169.254.1.1"), Scheme: "https"}},
+		{name: "appended scheme", remote: "169.254.1.1:1234", proto: []string{"https, HTTP"}, expected: Client{Addr: netip.MustParseAddr("169.254.1.1"), Scheme: "http"}},
+		{name: "unknown scheme", remote: "169.254.1.1:1234", tls: true, proto: []string{"ftp"}, expected: Client{Addr: netip.MustParseAddr("169.254.1.1"), Scheme: "https"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Client
+			h := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = FromRequest(r)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for _, proto := range tt.proto {
+				r.Header.Add("X-Forwarded-Proto", proto)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFromRequest_WithoutMiddleware(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "[2001:db8::1]:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.9")
+	r.Header.Set("X-Forwarded-Proto", "https")
+
+	expected := Client{Addr: netip.MustParseAddr("2001:db8::1"), Scheme: "http"}
+	if got := FromRequest(r); got != expected {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := ParsePrefixes([]string{"10.1.2.3/8", "192.0.2.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"10.0.0.0/8", "192.0.2.1/32", "2001:db8::/32"}
+	for i, prefix := range prefixes {
+		if prefix.String() != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], prefix)
+		}
+	}
+
+	if _, err := ParsePrefixes([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Expected an error for an invalid range")
+	}
+}
@@ -1,46 +1,2093 @@
 package config
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+
+	"gcp-proxy-mity/internal/scheduler"
 )
 
+// Config holds all settings. Sections are embedded so their fields are promoted
+// (cfg.Port, cfg.GCSBucketName, ...) while the config file nests them by section.
 type Config struct {
-	Port              string
-	GCPProjectID      string
-	GCSBucketName     string
-	GoogleCredentials string
+	ServerConfig       `yaml:"server"`
+	TLSConfig          `yaml:"tls"`
+	AuthConfig         `yaml:"auth"`
+	LimitsConfig       `yaml:"limits"`
+	BackendsConfig     `yaml:"backends"`
+	CachingConfig      `yaml:"caching"`
+	RedisConfig        `yaml:"redis"`
+	ImagesConfig       `yaml:"images"`
+	MediaConfig        `yaml:"media"`
+	EventsConfig       `yaml:"events"`
+	UploadsConfig      `yaml:"uploads"`
+	JobsConfig         `yaml:"jobs"`
+	EncryptionConfig   `yaml:"encryption"`
+	StorageClassConfig `yaml:"storage_class"`
+	CacheControlConfig `yaml:"cache_control"`
+	RedirectConfig     `yaml:"redirects"`
+	CompressionConfig  `yaml:"compression"`
+	HotlinkConfig      `yaml:"hotlink"`
+	IPFilterConfig     `yaml:"ip_filter"`
+	IAPConfig          `yaml:"iap"`
+	PolicyConfig       `yaml:"policy"`
+	AuthorizerConfig   `yaml:"authorizer"`
+	OIDCConfig         `yaml:"oidc"`
+	HeadersConfig      `yaml:"security_headers"`
+	DedupConfig        `yaml:"dedup"`
+	TrashConfig        `yaml:"trash"`
+	TagsConfig         `yaml:"tags"`
+	SearchConfig       `yaml:"search"`
+	CatalogConfig      `yaml:"catalog"`
+	ChangesConfig      `yaml:"changes"`
+	LeasesConfig       `yaml:"leases"`
+	SharesConfig       `yaml:"shares"`
+	MountsConfig       `yaml:"mounts"`
+	StatsConfig        `yaml:"stats"`
+	UsageConfig        `yaml:"usage"`
+	IdempotencyConfig  `yaml:"idempotency"`
+	LogsConfig         `yaml:"logs"`
+	SiteConfig         `yaml:"site"`
+	UIConfig           `yaml:"ui"`
+	GCConfig           `yaml:"gc"`
+	ExpiryConfig       `yaml:"expiry"`
+	FetchConfig        `yaml:"fetch"`
+	TransferConfig     `yaml:"transfer"`
+	ReplicationConfig  `yaml:"replication"`
+	ModerationConfig   `yaml:"moderation"`
+	S3Config           `yaml:"s3"`
+	SFTPConfig         `yaml:"sftp"`
+	MaintenanceConfig  `yaml:"maintenance"`
+	DebugConfig        `yaml:"debug"`
+}
+
+type ServerConfig struct {
+	Port                string        `yaml:"port"`
+	ReadinessTimeout    time.Duration `yaml:"readiness_timeout"`
+	LogLevel            string        `yaml:"log_level"`
+	ConfigWatchInterval time.Duration `yaml:"config_watch_interval"`
+	PublicBaseURL       string        `yaml:"public_base_url"`
+	// TrustedProxies are the load balancers whose X-Forwarded-For and
+	// X-Forwarded-Proto are trusted
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	HTTP2                     bool          `yaml:"http2"`
+	H2C                       bool          `yaml:"h2c"`
+	HTTP2MaxConcurrentStreams int           `yaml:"http2_max_concurrent_streams"`
+	MaxHeaderBytes            int           `yaml:"max_header_bytes"`
+	ReadTimeout               time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout         time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout              time.Duration `yaml:"write_timeout"`
+	IdleTimeout               time.Duration `yaml:"idle_timeout"`
+	KeepAlives                bool          `yaml:"keep_alives"`
+
+	// ShutdownTimeout is how long requests in flight get to finish on shutdown;
+	// writes, like long uploads, get up to ShutdownUploadTimeout
+	ShutdownTimeout       time.Duration `yaml:"shutdown_timeout"`
+	ShutdownUploadTimeout time.Duration `yaml:"shutdown_upload_timeout"`
+	// ReusePort lets a new version bind the ports while the old one drains
+	ReusePort bool `yaml:"reuse_port"`
+
+	// SwaggerUI serves a Swagger UI page for /openapi.json at /docs
+	SwaggerUI bool `yaml:"swagger_ui"`
+}
+
+type TLSConfig struct {
+	TLSCertFile         string   `yaml:"cert_file"`
+	TLSKeyFile          string   `yaml:"key_file"`
+	TLSAutocertDomains  []string `yaml:"autocert_domains"`
+	TLSAutocertCacheDir string   `yaml:"autocert_cache_dir"`
+	TLSAutocertEmail    string   `yaml:"autocert_email"`
+	TLSClientCAFile     string   `yaml:"client_ca_file"`
+	TLSClientAuth       string   `yaml:"client_auth"`
+}
+
+// TLSEnabled reports whether the server terminates TLS itself
+func (t TLSConfig) TLSEnabled() bool {
+	return t.TLSCertFile != "" || t.TLSKeyFile != "" || len(t.TLSAutocertDomains) > 0
+}
+
+type AuthConfig struct {
+	APIKeys               []APIKey      `yaml:"api_keys"`
+	DownloadURLSigningKey string        `yaml:"download_url_signing_key"`
+	DownloadURLTTL        time.Duration `yaml:"download_url_ttl"`
+	// CDNSigningKey is a base64url Cloud CDN signing key named CDNSigningKeyName;
+	// tokens signed with it grant read access below a URL prefix
+	CDNSigningKeyName string        `yaml:"cdn_signing_key_name"`
+	CDNSigningKey     string        `yaml:"cdn_signing_key"`
+	CDNTokenTTL       time.Duration `yaml:"cdn_token_ttl"`
+	CDNTokenMaxTTL    time.Duration `yaml:"cdn_token_max_ttl"`
+	// SignedRequests accepts requests signed with HMAC-SHA256 by an API key
+	// instead of carrying it, if their date is within SignedRequestMaxSkew
+	SignedRequests       bool          `yaml:"signed_requests"`
+	SignedRequestMaxSkew time.Duration `yaml:"signed_request_max_skew"`
+}
+
+// APIKey is a named key accepted by the API
+type APIKey struct {
+	Name   string   `yaml:"name"`
+	Key    string   `yaml:"key"`
+	Scopes []string `yaml:"scopes,omitempty"`
+	// Quota replaces limits.quota for this key
+	Quota *Quota `yaml:"quota,omitempty"`
+	// ServiceAccount is impersonated for the key's requests instead of using the configured credentials
+	ServiceAccount string `yaml:"service_account,omitempty"`
+}
+
+// Quota caps the uploads of an API key per UTC day and month; zero is unlimited
+type Quota struct {
+	DailyBytes     int64 `yaml:"daily_bytes"`
+	DailyObjects   int64 `yaml:"daily_objects"`
+	MonthlyBytes   int64 `yaml:"monthly_bytes"`
+	MonthlyObjects int64 `yaml:"monthly_objects"`
+}
+
+func (q Quota) valid() bool {
+	return q.DailyBytes >= 0 && q.DailyObjects >= 0 && q.MonthlyBytes >= 0 && q.MonthlyObjects >= 0
+}
+
+type LimitsConfig struct {
+	MaxUploadBytes      int64    `yaml:"max_upload_bytes"`
+	MaxMultipartMemory  int64    `yaml:"max_multipart_memory"`
+	SpoolDir            string   `yaml:"spool_dir"`
+	MaxSpoolBytes       int64    `yaml:"max_spool_bytes"`
+	RateLimit           float64  `yaml:"rate_limit"`
+	RateLimitBurst      int      `yaml:"rate_limit_burst"`
+	AllowedContentTypes []string `yaml:"allowed_content_types"`
+	TrustContentTypes   bool     `yaml:"trust_content_types"`
+	MaxBatchFiles       int      `yaml:"max_batch_files"`
+	MaxBatchBytes       int64    `yaml:"max_batch_bytes"`
+	// Quota applies to API keys without their own quota
+	Quota     Quota     `yaml:"quota"`
+	Bandwidth Bandwidth `yaml:"bandwidth"`
+	Admission Admission `yaml:"admission"`
+}
+
+// Admission refuses new uploads and downloads with 503 while too many are in
+// progress or the heap is too large; 0 disables a limit
+type Admission struct {
+	MaxUploads   int   `yaml:"max_uploads"`
+	MaxDownloads int   `yaml:"max_downloads"`
+	MaxHeapBytes int64 `yaml:"max_heap_bytes"`
+	// RetryAfter is what refused clients are told to wait
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+// Bandwidth caps transfer rates in bytes per second; 0 is unlimited
+type Bandwidth struct {
+	// DownloadBytes and UploadBytes limit each request
+	DownloadBytes int64 `yaml:"download_bytes"`
+	UploadBytes   int64 `yaml:"upload_bytes"`
+	// TenantDownloadBytes and TenantUploadBytes limit all requests of an API key,
+	// or of an IP without one, together
+	TenantDownloadBytes int64 `yaml:"tenant_download_bytes"`
+	TenantUploadBytes   int64 `yaml:"tenant_upload_bytes"`
+	// Routes replace the per-request limits for paths under a prefix
+	Routes []BandwidthRoute `yaml:"routes"`
+}
+
+type BandwidthRoute struct {
+	Prefix        string `yaml:"prefix"`
+	DownloadBytes int64  `yaml:"download_bytes"`
+	UploadBytes   int64  `yaml:"upload_bytes"`
+}
+
+type BackendsConfig struct {
+	GCSConfig `yaml:"gcs"`
+	// StorageRoutes store the files under a prefix somewhere other than the bucket
+	StorageRoutes []StorageRoute `yaml:"routes"`
+}
+
+// StorageRoute mounts Target, gs://bucket or file:///path, at Prefix for as
+// long as the server runs
+type StorageRoute struct {
+	Prefix string `yaml:"prefix"`
+	Target string `yaml:"target"`
+	// QuotaBytes caps the bytes stored under the prefix; zero is unlimited
+	QuotaBytes int64 `yaml:"quota_bytes"`
+	// Cache serves the route's small objects from the read cache
+	Cache bool `yaml:"cache"`
+	// Scope must be granted to the API keys using the route
+	Scope    string `yaml:"scope"`
+	ReadOnly bool   `yaml:"read_only"`
+}
+
+type GCSConfig struct {
+	GCPProjectID  string `yaml:"project_id"`
+	GCSBucketName string `yaml:"bucket"`
+	// GoogleCredentialsMode is adc, file, base64-json or impersonation; empty
+	// picks by GoogleCredentials: none uses ADC, base64 JSON is decoded and
+	// anything else is a key file path
+	GoogleCredentialsMode string `yaml:"credentials_mode"`
+	GoogleCredentials     string `yaml:"credentials"`
+	// GoogleImpersonateServiceAccount is the account impersonated in impersonation mode
+	GoogleImpersonateServiceAccount string `yaml:"impersonate_service_account"`
+	// GCSEmulatorHost points the storage client at an emulator like fake-gcs-server, without credentials
+	GCSEmulatorHost string `yaml:"emulator_host"`
+	// GCSReadConcurrency reads objects larger than GCSReadPartSize in that
+	// many ranges at a time; 1 reads them in a single stream
+	GCSReadConcurrency int   `yaml:"read_concurrency"`
+	GCSReadPartSize    int64 `yaml:"read_part_size"`
+}
+
+type CachingConfig struct {
+	StreamPlaylistMaxAge time.Duration `yaml:"stream_playlist_max_age"`
+	StreamSegmentMaxAge  time.Duration `yaml:"stream_segment_max_age"`
+	ReadinessCacheTTL    time.Duration `yaml:"readiness_cache_ttl"`
+
+	// ReadCacheMaxBytes caps the in-memory read cache; zero disables it
+	ReadCacheMaxBytes       int64         `yaml:"read_cache_max_bytes"`
+	ReadCacheMaxObjectBytes int64         `yaml:"read_cache_max_object_bytes"`
+	ReadCacheTTL            time.Duration `yaml:"read_cache_ttl"`
+	// ReadCoalescing shares a read of an object between concurrent requests for it
+	ReadCoalescing bool `yaml:"read_coalescing"`
+}
+
+// RedisConfig shares caches, rate limits and upload sessions between replicas
+type RedisConfig struct {
+	RedisURL       string `yaml:"url"`
+	RedisKeyPrefix string `yaml:"key_prefix"`
+}
+
+type ImagesConfig struct {
+	ImageSigningKey    string `yaml:"signing_key"`
+	ImageVariantPrefix string `yaml:"variant_prefix"`
+	StripImageMetadata bool   `yaml:"strip_metadata"`
+}
+
+type MediaConfig struct {
+	FFmpegPath string `yaml:"ffmpeg_path"`
+}
+
+type EventsConfig struct {
+	EventsPubSubTopic      string `yaml:"pubsub_topic"`
+	EventsWebhookURL       string `yaml:"webhook_url"`
+	EventsWebhookSecret    string `yaml:"webhook_secret"`
+	EventsMaxAttempts      int    `yaml:"max_attempts"`
+	EventsDeadLetterPrefix string `yaml:"dead_letter_prefix"`
+	// EventsStreamEnabled serves object events live as server-sent events
+	EventsStreamEnabled bool `yaml:"stream_enabled"`
+	// EventsStreamSubscription receives the bucket's notifications, which then
+	// feed the stream instead of the proxy's own events
+	EventsStreamSubscription string `yaml:"stream_pubsub_subscription"`
+}
+
+// UploadsConfig configures direct browser uploads through signed URLs. Post-processing
+// runs when the bucket's OBJECT_FINALIZE notification arrives on UploadSubscription.
+// Raw uploads through the proxy can be tracked so clients can poll their progress.
+type UploadsConfig struct {
+	UploadURLTTL             time.Duration `yaml:"url_ttl"`
+	UploadRegistrationPrefix string        `yaml:"registration_prefix"`
+	UploadSubscription       string        `yaml:"pubsub_subscription"`
+	UploadThumbnailWidth     int           `yaml:"thumbnail_width"`
+	UploadCallbackSecret     string        `yaml:"callback_secret"`
+	UploadCallbackHosts      []string      `yaml:"callback_hosts"`
+	UploadProgressEnabled    bool          `yaml:"progress_enabled"`
+	// UploadProgressMinBytes is the size from which raw uploads are tracked
+	// without the client asking for it with X-Transfer-ID
+	UploadProgressMinBytes int64 `yaml:"progress_min_bytes"`
+	// UploadProgressTTL is how long finished uploads can still be polled
+	UploadProgressTTL time.Duration `yaml:"progress_ttl"`
+}
+
+type EncryptionConfig struct {
+	KMSKeys []KMSKeyRule `yaml:"kms_keys"`
+	// EnvelopeKMSKey is a Cloud KMS key that wraps the data keys of objects
+	// the proxy encrypts itself; it takes precedence over EnvelopeMasterKey
+	EnvelopeKMSKey string `yaml:"envelope_kms_key"`
+	// EnvelopeMasterKey is a base64 AES-256 key that wraps data keys in the proxy
+	EnvelopeMasterKey string `yaml:"envelope_master_key"`
+	// EnvelopePreviousMasterKeys only unwrap the data keys of objects written before a rotation
+	EnvelopePreviousMasterKeys []string `yaml:"envelope_previous_master_keys"`
+	// EnvelopePrefixes limits envelope encryption to new objects under these prefixes; empty encrypts all
+	EnvelopePrefixes []string `yaml:"envelope_prefixes"`
+}
+
+// KMSKeyRule encrypts new objects under a path prefix with a Cloud KMS key
+type KMSKeyRule struct {
+	Prefix string `yaml:"prefix"`
+	Key    string `yaml:"key"`
+}
+
+type StorageClassConfig struct {
+	StorageClassRules []StorageClassRule `yaml:"rules"`
+}
+
+// StorageClassRule stores new objects under a path prefix in a storage class
+type StorageClassRule struct {
+	Prefix       string `yaml:"prefix"`
+	StorageClass string `yaml:"storage_class"`
+}
+
+// CacheControlConfig sets the Cache-Control of downloads and new objects by path
+// pattern; the first matching rule wins
+type CacheControlConfig struct {
+	CacheControlRules []CacheControlRule `yaml:"rules"`
+}
+
+// CacheControlRule caches objects whose path matches a glob for MaxAge, or not at all with NoStore
+type CacheControlRule struct {
+	Pattern   string        `yaml:"pattern"`
+	MaxAge    time.Duration `yaml:"max_age"`
+	Immutable bool          `yaml:"immutable"`
+	NoStore   bool          `yaml:"no_store"`
+}
+
+// RedirectConfig answers downloads of objects whose path matches a rule with
+// 307 to a signed URL of the bucket instead of proxying them; the first
+// matching rule wins
+type RedirectConfig struct {
+	RedirectRules []RedirectRule `yaml:"rules"`
+	// RedirectURLTTL is how long the signed URLs stay valid
+	RedirectURLTTL time.Duration `yaml:"url_ttl"`
+}
+
+// RedirectRule redirects downloads of objects whose path matches a glob and
+// that are at least MinBytes large
+type RedirectRule struct {
+	Pattern  string `yaml:"pattern"`
+	MinBytes int64  `yaml:"min_bytes"`
+}
+
+// CompressionConfig stores new objects compressed by content type; the first
+// matching rule wins
+type CompressionConfig struct {
+	CompressionRules []CompressionRule `yaml:"rules"`
+}
+
+// CompressionRule compresses objects whose content type matches ContentType,
+// like application/json or text/*, with Encoding, gzip or zstd
+type CompressionRule struct {
+	ContentType string `yaml:"content_type"`
+	Encoding    string `yaml:"encoding"`
+}
+
+// HotlinkConfig rejects downloads under HotlinkPaths embedded by sites other
+// than HotlinkAllowedOrigins; authenticated requests bypass it
+type HotlinkConfig struct {
+	HotlinkEnabled        bool     `yaml:"enabled"`
+	HotlinkPaths          []string `yaml:"paths"`
+	HotlinkAllowedOrigins []string `yaml:"allowed_origins"`
+	// HotlinkAllowEmptyReferer serves requests without Origin and Referer
+	HotlinkAllowEmptyReferer bool `yaml:"allow_empty_referer"`
+}
+
+// IPFilterConfig refuses clients by address. Ranges are CIDRs like
+// 10.0.0.0/8 or single addresses.
+type IPFilterConfig struct {
+	IPAllow []string `yaml:"allow"`
+	IPDeny  []string `yaml:"deny"`
+	// PrivateWrites accepts changes only from InternalRanges; reads stay public
+	PrivateWrites  bool     `yaml:"private_writes"`
+	InternalRanges []string `yaml:"internal_ranges"`
+}
+
+// validRanges reports whether every value is an IP address or CIDR range
+func validRanges(values []string) bool {
+	for _, value := range values {
+		if _, err := netip.ParseAddr(value); err == nil {
+			continue
+		}
+		if _, err := netip.ParsePrefix(value); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// IAPConfig authenticates requests by the JWT Identity-Aware Proxy adds to
+// them, and limits the Google identities to the prefixes IAPGrants give them
+type IAPConfig struct {
+	IAPEnabled bool `yaml:"enabled"`
+	// IAPAudience is /projects/NUMBER/global/backendServices/ID, or
+	// /projects/NUMBER/apps/PROJECT_ID on App Engine
+	IAPAudience string     `yaml:"audience"`
+	IAPGrants   []IAPGrant `yaml:"grants"`
+}
+
+// IAPGrant gives identities read or write access to the objects under Prefix
+type IAPGrant struct {
+	// Members are emails, or domain:example.com for every identity of a domain
+	Members []string `yaml:"members"`
+	Prefix  string   `yaml:"prefix"`
+	Access  string   `yaml:"access"`
+}
+
+// PolicyConfig evaluates path-glob rules for every request to objects,
+// loaded from PolicyRules or from the policy object in the bucket
+type PolicyConfig struct {
+	PolicyEnabled bool         `yaml:"enabled"`
+	PolicyRules   []PolicyRule `yaml:"rules"`
+	// PolicyObject is the path of a YAML or JSON document {"rules": [...]} in
+	// the bucket, read again every PolicyRefreshInterval
+	PolicyObject          string        `yaml:"object"`
+	PolicyRefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// PolicyRule allows or denies principals actions on the paths matching globs
+type PolicyRule struct {
+	Name string `yaml:"name"`
+	// Principals are key names or identities, *, anonymous, scope:NAME or domain:example.com
+	Principals []string `yaml:"principals"`
+	// Actions are read, write, delete, list or *
+	Actions []string `yaml:"actions"`
+	Paths   []string `yaml:"paths"`
+	Effect  string   `yaml:"effect"`
+}
+
+// AuthorizerConfig delegates the decision on every request to objects to an
+// external HTTP service; an empty AuthorizerURL disables it
+type AuthorizerConfig struct {
+	AuthorizerURL string `yaml:"url"`
+	// AuthorizerSecret signs each request with X-Signature, the hex HMAC-SHA256 of the body
+	AuthorizerSecret   string        `yaml:"secret"`
+	AuthorizerTimeout  time.Duration `yaml:"timeout"`
+	AuthorizerCacheTTL time.Duration `yaml:"cache_ttl"`
+	// AuthorizerFailOpen allows requests the service can't decide instead of refusing them
+	AuthorizerFailOpen bool `yaml:"fail_open"`
+}
+
+// OIDCConfig lets people log in with their Google account and use the API
+// with a session cookie, with the scopes of the roles they are members of
+type OIDCConfig struct {
+	OIDCEnabled      bool   `yaml:"enabled"`
+	OIDCClientID     string `yaml:"client_id"`
+	OIDCClientSecret string `yaml:"client_secret"`
+	// OIDCRedirectURL is the callback registered with the OAuth client, like
+	// https://storage.example.com/auth/callback
+	OIDCRedirectURL string `yaml:"redirect_url"`
+	// OIDCHostedDomain restricts logins to the accounts of a Google Workspace domain
+	OIDCHostedDomain string `yaml:"hosted_domain"`
+	// OIDCSessionKey is a base64 key of at least 32 bytes signing the session cookies
+	OIDCSessionKey string        `yaml:"session_key"`
+	OIDCSessionTTL time.Duration `yaml:"session_ttl"`
+	OIDCRoles      []OIDCRole    `yaml:"roles"`
+}
+
+// OIDCRole grants scopes to the people among Members
+type OIDCRole struct {
+	// Members are emails, domain:example.com or group:admins@example.com
+	Members []string `yaml:"members"`
+	Scopes  []string `yaml:"scopes"`
+}
+
+func validPolicyRule(rule PolicyRule) bool {
+	if len(rule.Principals) == 0 || len(rule.Paths) == 0 || len(rule.Actions) == 0 || rule.Effect != "allow" && rule.Effect != "deny" {
+		return false
+	}
+	for _, action := range rule.Actions {
+		switch action {
+		case "read", "write", "delete", "list", "*":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// HeadersConfig sets security headers on every response. Routes
+// replace the Content-Security-Policy, X-Frame-Options and Referrer-Policy
+// for paths under a prefix.
+type HeadersConfig struct {
+	SecurityHeadersEnabled bool `yaml:"enabled"`
+	// ContentSecurityPolicy is only sent with HTML responses
+	ContentSecurityPolicy string `yaml:"content_security_policy"`
+	FrameOptions          string `yaml:"frame_options"`
+	ReferrerPolicy        string `yaml:"referrer_policy"`
+	// HSTSMaxAge is sent in Strict-Transport-Security over HTTPS; 0 disables it
+	HSTSMaxAge            time.Duration         `yaml:"hsts_max_age"`
+	HSTSIncludeSubdomains bool                  `yaml:"hsts_include_subdomains"`
+	SecurityHeaderRoutes  []SecurityHeaderRoute `yaml:"routes"`
+}
+
+type SecurityHeaderRoute struct {
+	Prefix                string `yaml:"prefix"`
+	ContentSecurityPolicy string `yaml:"content_security_policy"`
+	FrameOptions          string `yaml:"frame_options"`
+	ReferrerPolicy        string `yaml:"referrer_policy"`
+}
+
+func validFrameOptions(value string) bool {
+	return value == "" || value == "DENY" || value == "SAMEORIGIN"
+}
+
+// DedupConfig indexes uploads by SHA-256 under DedupPrefix; empty disables deduplication
+type DedupConfig struct {
+	DedupPrefix string `yaml:"prefix"`
+}
+
+// TrashConfig moves deleted files under TrashPrefix, where they are kept for
+// TrashRetention; empty deletes files immediately
+type TrashConfig struct {
+	TrashPrefix        string        `yaml:"prefix"`
+	TrashRetention     time.Duration `yaml:"retention"`
+	TrashPurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+// TagsConfig indexes object tags under TagIndexPrefix; empty disables tags
+type TagsConfig struct {
+	TagIndexPrefix string `yaml:"index_prefix"`
+}
+
+// SearchConfig keeps an in-memory index of object names and metadata for full-text search
+type SearchConfig struct {
+	SearchEnabled bool `yaml:"enabled"`
+	// SearchResyncInterval is how often the index is rebuilt from a listing of the bucket
+	SearchResyncInterval time.Duration `yaml:"resync_interval"`
+	// SearchSubscription receives the bucket's notifications to pick up changes made around the proxy
+	SearchSubscription string `yaml:"pubsub_subscription"`
+	// SearchExcludePrefixes are not indexed, in addition to the proxy's internal prefixes
+	SearchExcludePrefixes []string `yaml:"exclude_prefixes"`
+}
+
+// CatalogConfig records the objects written through the proxy in a SQL database
+type CatalogConfig struct {
+	// CatalogDriver is "sqlite" or "postgres"; empty disables the catalog
+	CatalogDriver string `yaml:"driver"`
+	// CatalogDSN is a file path for SQLite and a connection URL for Postgres
+	CatalogDSN string `yaml:"dsn"`
+	// CatalogListings serves listings from the catalog instead of the bucket
+	CatalogListings bool `yaml:"listings"`
+}
+
+// ChangesConfig keeps a feed of object changes for incremental syncs, in Redis when it is configured
+type ChangesConfig struct {
+	ChangesEnabled bool `yaml:"enabled"`
+	// ChangesRetention is how many of the newest changes are kept
+	ChangesRetention int `yaml:"retention"`
+	// ChangesSubscription receives the bucket's notifications, which then feed
+	// the changes instead of the proxy's own events
+	ChangesSubscription string `yaml:"pubsub_subscription"`
+}
+
+// LeasesConfig lets clients lease paths to coordinate writers, in Redis when it is configured
+type LeasesConfig struct {
+	LeasesEnabled bool `yaml:"enabled"`
+	// LeasesRequired rejects writes to leased paths without the lease's ID
+	LeasesRequired   bool          `yaml:"required"`
+	LeasesDefaultTTL time.Duration `yaml:"default_ttl"`
+	LeasesMaxTTL     time.Duration `yaml:"max_ttl"`
+}
+
+// SharesConfig enables share links, kept in the catalog database when it is configured
+type SharesConfig struct {
+	SharesEnabled    bool          `yaml:"enabled"`
+	SharesDefaultTTL time.Duration `yaml:"default_ttl"`
+	SharesMaxTTL     time.Duration `yaml:"max_ttl"`
+}
+
+// MountsConfig enables the admin API adding and removing storage routes at
+// runtime, kept in the catalog database when it is configured
+type MountsConfig struct {
+	MountsEnabled bool `yaml:"enabled"`
+	// MountsRefreshInterval is how often the mounts added through other replicas are loaded
+	MountsRefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// StatsConfig counts the downloads of every object, in the catalog database when it is configured
+type StatsConfig struct {
+	StatsEnabled bool `yaml:"enabled"`
+	// StatsFlushInterval is how often the downloads counted in memory are added to the totals
+	StatsFlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+// UsageConfig tunes the reporting of the storage used under a prefix
+type UsageConfig struct {
+	// UsageCacheTTL is how long the usage of a prefix is reused once computed
+	UsageCacheTTL time.Duration `yaml:"cache_ttl"`
+	// UsageWait is how long a request waits for usage being computed before
+	// it is answered as pending; 0 waits until it is done
+	UsageWait time.Duration `yaml:"wait"`
+}
+
+// IdempotencyConfig replays responses to retried writes carrying an
+// Idempotency-Key, kept in Redis or the catalog database when configured
+type IdempotencyConfig struct {
+	IdempotencyEnabled bool `yaml:"enabled"`
+	// IdempotencyTTL is how long responses are kept for retries
+	IdempotencyTTL time.Duration `yaml:"ttl"`
+}
+
+// LogsConfig enables append-only logs, stored as objects under LogsPrefix
+type LogsConfig struct {
+	LogsEnabled        bool   `yaml:"enabled"`
+	LogsPrefix         string `yaml:"prefix"`
+	LogsMaxRecordBytes int64  `yaml:"max_record_bytes"`
+}
+
+// SiteConfig serves the files under SitePrefix as a website at the root of the proxy
+type SiteConfig struct {
+	SiteEnabled bool   `yaml:"enabled"`
+	SitePrefix  string `yaml:"prefix"`
+	// SiteIndex is the document served for directories
+	SiteIndex string `yaml:"index"`
+	// SiteSPAFallback serves the root index document for unknown paths without an extension
+	SiteSPAFallback bool `yaml:"spa_fallback"`
+	// SitePublic serves the site without an API key
+	SitePublic bool `yaml:"public"`
+	// SiteAssetMaxAge is the client cache lifetime of files other than HTML
+	SiteAssetMaxAge time.Duration `yaml:"asset_max_age"`
+}
+
+// UIConfig serves the web UI for browsing, previewing and uploading objects at /ui/
+type UIConfig struct {
+	UIEnabled bool `yaml:"enabled"`
+}
+
+// GCConfig periodically deletes objects under GCPrefixes that were last written
+// more than GCTTL ago; no prefixes disables garbage collection
+type GCConfig struct {
+	GCPrefixes []string      `yaml:"prefixes"`
+	GCTTL      time.Duration `yaml:"ttl"`
+	GCInterval time.Duration `yaml:"interval"`
+}
+
+// ExpiryConfig lets writes carry an expiry, indexed under ExpiryIndexPrefix and
+// deleted every ExpiryInterval once due; an empty prefix disables expiries
+type ExpiryConfig struct {
+	ExpiryIndexPrefix string        `yaml:"index_prefix"`
+	ExpiryInterval    time.Duration `yaml:"interval"`
+}
+
+// FetchConfig lets clients have the proxy download remote files into the bucket
+type FetchConfig struct {
+	FetchEnabled bool `yaml:"enabled"`
+	// FetchMaxBytes caps a single download; 0 uses MaxUploadBytes
+	FetchMaxBytes int64         `yaml:"max_bytes"`
+	FetchTimeout  time.Duration `yaml:"timeout"`
+	// FetchAllowedHosts restricts downloads to these hosts; empty allows any public host
+	FetchAllowedHosts []string `yaml:"allowed_hosts"`
+}
+
+// TransferConfig names the buckets admins can transfer objects between, in
+// addition to the served bucket, which is named "default"
+type TransferConfig struct {
+	TransferBuckets []TransferBucket `yaml:"buckets"`
+}
+
+// TransferBucket is a bucket, possibly in another project, reachable for transfers
+type TransferBucket struct {
+	Name   string `yaml:"name"`
+	Bucket string `yaml:"bucket"`
+	// ProjectID defaults to the served bucket's project
+	ProjectID string `yaml:"project_id"`
+	// Credentials are base64 service account credentials; empty uses the server's
+	Credentials string `yaml:"credentials"`
+}
+
+// ReplicationConfig copies every write and deletion to ReplicationTarget in
+// the background; an empty target disables replication
+type ReplicationConfig struct {
+	// ReplicationTarget is gs://bucket, s3://bucket or file:///path
+	ReplicationTarget string `yaml:"target"`
+	// ReplicationCredentials are base64 service account credentials for a
+	// gs:// target; empty uses the server's
+	ReplicationCredentials string `yaml:"credentials"`
+	// ReplicationS3Endpoint defaults to the AWS endpoint of ReplicationS3Region
+	ReplicationS3Endpoint        string `yaml:"s3_endpoint"`
+	ReplicationS3Region          string `yaml:"s3_region"`
+	ReplicationS3AccessKeyID     string `yaml:"s3_access_key_id"`
+	ReplicationS3SecretAccessKey string `yaml:"s3_secret_access_key"`
+	// ReplicationQueuePrefix is where queued changes are kept in the served bucket
+	ReplicationQueuePrefix string `yaml:"queue_prefix"`
+	// ReplicationInterval is how often the queue is checked for retries
+	ReplicationInterval    time.Duration `yaml:"interval"`
+	ReplicationMaxAttempts int           `yaml:"max_attempts"`
+	// ReplicationVerifyInterval is how often a sample of objects is compared
+	// with their replicas; 0 only verifies on request
+	ReplicationVerifyInterval time.Duration `yaml:"verify_interval"`
+	// ReplicationVerifySample is how many objects a verification compares; 0 compares all
+	ReplicationVerifySample int `yaml:"verify_sample"`
+	// ReplicationVerifyRepair copies objects whose replica differs again
+	ReplicationVerifyRepair bool `yaml:"verify_repair"`
+}
+
+// ModerationConfig rates written images and videos with ModerationProvider and
+// stores the verdict in their metadata; an empty provider disables moderation
+type ModerationConfig struct {
+	// ModerationProvider is "google" for Cloud Vision and Video Intelligence
+	ModerationProvider string `yaml:"provider"`
+	// ModerationThreshold is the likelihood, e.g. LIKELY, from which a category flags an object
+	ModerationThreshold  string   `yaml:"threshold"`
+	ModerationCategories []string `yaml:"categories"`
+	// ModerationQuarantinePrefix is where flagged objects are moved; empty only labels them
+	ModerationQuarantinePrefix string        `yaml:"quarantine_prefix"`
+	ModerationTimeout          time.Duration `yaml:"timeout"`
+}
+
+// S3Config serves an S3-compatible API on S3Port; empty disables it
+type S3Config struct {
+	S3Port   string `yaml:"port"`
+	S3Region string `yaml:"region"`
+	// S3Bucket is the bucket name S3 clients use; it defaults to the GCS bucket name
+	S3Bucket     string        `yaml:"bucket"`
+	S3AccessKeys []S3AccessKey `yaml:"access_keys"`
+}
+
+// S3AccessKey is a key pair S3 clients sign requests with
+type S3AccessKey struct {
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+// SFTPConfig serves an SFTP gateway on SFTPPort; empty disables it
+type SFTPConfig struct {
+	SFTPPort        string     `yaml:"port"`
+	SFTPHostKeyFile string     `yaml:"host_key_file"`
+	SFTPUsers       []SFTPUser `yaml:"users"`
+}
+
+// MaintenanceConfig is the response to requests while maintenance mode is on
+type MaintenanceConfig struct {
+	// MaintenanceEnabled starts the server in maintenance mode
+	MaintenanceEnabled bool   `yaml:"enabled"`
+	MaintenanceMessage string `yaml:"message"`
+	// MaintenancePageFile is an html/template served to browsers instead of the
+	// default page, with {{.Message}} and {{.RetryAfter}} in seconds
+	MaintenancePageFile   string        `yaml:"page_file"`
+	MaintenanceRetryAfter time.Duration `yaml:"retry_after"`
+}
+
+// DebugConfig serves runtime profiles and variables to admins under /debug/,
+// or to anyone reaching DebugAddr when it is set
+type DebugConfig struct {
+	DebugEnabled bool   `yaml:"enabled"`
+	DebugAddr    string `yaml:"addr"`
+}
+
+// SFTPUser is an SFTP account that logs in with one of its public keys
+type SFTPUser struct {
+	Name string `yaml:"name"`
+	// AuthorizedKeys are public keys in authorized_keys format
+	AuthorizedKeys []string `yaml:"authorized_keys"`
+	// Prefix confines the user to the objects under it
+	Prefix string `yaml:"prefix"`
+}
+
+type JobsConfig struct {
+	JobWorkers      int           `yaml:"workers"`
+	JobQueueSize    int           `yaml:"queue_size"`
+	JobRetention    time.Duration `yaml:"retention"`
+	JobResultPrefix string        `yaml:"result_prefix"`
+	// JobSchedules maps periodic job types to cron expressions replacing
+	// their intervals, like {"gc": "0 3 * * *"}
+	JobSchedules map[string]string `yaml:"schedules"`
+	// JobScheduleJitter delays each periodic job by a random duration up to it
+	JobScheduleJitter time.Duration `yaml:"schedule_jitter"`
+	// JobElection makes only the replica holding a lock in "gcs" or "redis"
+	// run periodic jobs; empty runs them on every replica
+	JobElection       string `yaml:"election"`
+	JobElectionPrefix string `yaml:"election_prefix"`
+	// JobElectionTTL is how long the lock is held without renewal, and so
+	// how long a crashed leader's jobs may pause
+	JobElectionTTL time.Duration `yaml:"election_ttl"`
+}
+
+// scheduledJobs are the job types that run periodically
+var scheduledJobs = []string{"purge-trash", "gc", "expire", "verify-replication"}
+
+// Default returns the built-in defaults
+func Default() *Config {
+	cfg := &Config{}
+
+	cfg.Port = "8080"
+	cfg.ReadinessTimeout = 2 * time.Second
+	cfg.LogLevel = "info"
+	cfg.HTTP2 = true
+	cfg.HTTP2MaxConcurrentStreams = 250
+	cfg.MaxHeaderBytes = 1 << 20
+	cfg.ReadHeaderTimeout = 10 * time.Second
+	cfg.IdleTimeout = 120 * time.Second
+	cfg.KeepAlives = true
+	cfg.ShutdownTimeout = 10 * time.Second
+	cfg.ShutdownUploadTimeout = 5 * time.Minute
+
+	cfg.DownloadURLTTL = 15 * time.Minute
+	cfg.CDNTokenTTL = time.Hour
+	cfg.CDNTokenMaxTTL = 24 * time.Hour
+	cfg.SignedRequestMaxSkew = 5 * time.Minute
+
+	cfg.HotlinkPaths = []string{"/api/v1/storage/files/", "/api/v1/storage/stream/", "/api/v1/storage/posters/"}
+	cfg.HotlinkAllowEmptyReferer = true
+
+	cfg.InternalRanges = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "127.0.0.0/8", "::1/128", "fc00::/7"}
+
+	cfg.SecurityHeadersEnabled = true
+	cfg.FrameOptions = "SAMEORIGIN"
+	cfg.ReferrerPolicy = "strict-origin-when-cross-origin"
+	cfg.HSTSMaxAge = 365 * 24 * time.Hour
+	// Uploaded HTML is served from the API in a sandbox, so its scripts can't
+	// act on the proxy's origin
+	cfg.SecurityHeaderRoutes = []SecurityHeaderRoute{{
+		Prefix:                "/api/",
+		ContentSecurityPolicy: "default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'unsafe-inline'; sandbox",
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "no-referrer",
+	}}
+
+	cfg.MaxUploadBytes = 100 << 20
+	cfg.MaxMultipartMemory = 32 << 20
+	cfg.MaxBatchFiles = 100
+	cfg.MaxBatchBytes = 256 << 20
+	cfg.TrustContentTypes = true
+	cfg.Admission.RetryAfter = 5 * time.Second
+
+	cfg.GCSReadConcurrency = 1
+	cfg.GCSReadPartSize = 64 << 20
+
+	cfg.StreamPlaylistMaxAge = 2 * time.Second
+	cfg.StreamSegmentMaxAge = 24 * time.Hour
+	cfg.ReadinessCacheTTL = 10 * time.Second
+	cfg.ReadCacheMaxObjectBytes = 1 << 20
+	cfg.ReadCacheTTL = 5 * time.Minute
+
+	cfg.RedisKeyPrefix = "gcs-proxy:"
+
+	cfg.ImageVariantPrefix = "_variants"
+
+	cfg.EventsMaxAttempts = 5
+	cfg.EventsDeadLetterPrefix = "_deadletter/events"
+
+	cfg.UploadURLTTL = 15 * time.Minute
+	cfg.UploadRegistrationPrefix = "_uploads"
+	cfg.UploadThumbnailWidth = 320
+	cfg.UploadProgressMinBytes = 8 << 20
+	cfg.UploadProgressTTL = 10 * time.Minute
+
+	cfg.TrashRetention = 30 * 24 * time.Hour
+	cfg.TrashPurgeInterval = time.Hour
+
+	cfg.SearchResyncInterval = time.Hour
+	cfg.ChangesRetention = 100000
+
+	cfg.LeasesDefaultTTL = time.Minute
+	cfg.LeasesMaxTTL = time.Hour
+
+	cfg.SharesDefaultTTL = 24 * time.Hour
+	cfg.SharesMaxTTL = 30 * 24 * time.Hour
+
+	cfg.MountsRefreshInterval = 30 * time.Second
+	cfg.StatsFlushInterval = 10 * time.Second
+
+	cfg.UsageCacheTTL = 5 * time.Minute
+	cfg.UsageWait = 10 * time.Second
+
+	cfg.IdempotencyTTL = 24 * time.Hour
+
+	cfg.LogsPrefix = "_logs"
+	cfg.LogsMaxRecordBytes = 1 << 20
+
+	cfg.SiteIndex = "index.html"
+	cfg.SiteAssetMaxAge = time.Hour
+
+	cfg.GCTTL = 24 * time.Hour
+	cfg.GCInterval = time.Hour
+
+	cfg.ExpiryInterval = time.Minute
+
+	cfg.FetchTimeout = 10 * time.Minute
+
+	cfg.RedirectURLTTL = 5 * time.Minute
+
+	cfg.MaintenanceMessage = "The service is down for maintenance. Please try again later."
+	cfg.MaintenanceRetryAfter = 5 * time.Minute
+	cfg.PolicyRefreshInterval = time.Minute
+	cfg.AuthorizerTimeout = 2 * time.Second
+	cfg.AuthorizerCacheTTL = 30 * time.Second
+	cfg.OIDCSessionTTL = 8 * time.Hour
+
+	cfg.ReplicationS3Region = "us-east-1"
+	cfg.ReplicationQueuePrefix = "_replication"
+	cfg.ReplicationInterval = 30 * time.Second
+	cfg.ReplicationMaxAttempts = 10
+	cfg.ReplicationVerifyInterval = 24 * time.Hour
+	cfg.ReplicationVerifySample = 1000
+
+	cfg.ModerationThreshold = "LIKELY"
+	cfg.ModerationCategories = []string{"adult", "violence", "racy"}
+	cfg.ModerationTimeout = 10 * time.Minute
+
+	cfg.S3Region = "us-east-1"
+
+	cfg.JobWorkers = 4
+	cfg.JobQueueSize = 100
+	cfg.JobRetention = 24 * time.Hour
+	cfg.JobResultPrefix = "_jobs"
+	cfg.JobScheduleJitter = 5 * time.Second
+	cfg.JobElectionPrefix = "_election"
+	cfg.JobElectionTTL = 30 * time.Second
+
+	return cfg
 }
 
-func Load() *Config {
+// Load builds the configuration from defaults, an optional YAML/JSON config file
+// and environment variables, in increasing order of precedence. The file path is
+// taken from the argument or, if empty, from CONFIG_FILE.
+func Load(path string) (*Config, error) {
 	if err := godotenv.Load(); err != nil {
 		if _, statErr := os.Stat(".env"); statErr == nil {
 			log.Printf("Warning: .env file exists but could not be loaded: %v\n", err)
 		}
 	}
 
-	return &Config{
-		Port:              getEnv("PORT", "8080"),
-		GCPProjectID:      getEnv("GCP_PROJECT_ID", ""),
-		GCSBucketName:     getEnv("GCS_BUCKET_NAME", ""),
-		GoogleCredentials: getEnv("STORAGE_GOOGLE_APPLICATION_CREDENTIALS", ""),
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
 	}
+	return cfg, nil
 }
 
-func (c *Config) Validate() error {
-	if c.GCPProjectID == "" {
-		return ErrMissingProjectID
+// loadFile overlays settings from a YAML or JSON file; unknown keys are rejected
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
 	}
-	if c.GCSBucketName == "" {
-		return ErrMissingBucketName
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
 	}
 	return nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+func (c *Config) applyEnv() error {
+	c.Port = getEnv("PORT", c.Port)
+	c.ReadinessTimeout = getEnvDuration("READINESS_TIMEOUT", c.ReadinessTimeout)
+	c.LogLevel = getEnv("LOG_LEVEL", c.LogLevel)
+	c.ConfigWatchInterval = getEnvDuration("CONFIG_WATCH_INTERVAL", c.ConfigWatchInterval)
+	c.PublicBaseURL = getEnv("PUBLIC_BASE_URL", c.PublicBaseURL)
+	c.TrustedProxies = getEnvList("TRUSTED_PROXIES", c.TrustedProxies)
+	c.HTTP2 = getEnvBool("HTTP2", c.HTTP2)
+	c.H2C = getEnvBool("H2C", c.H2C)
+	c.HTTP2MaxConcurrentStreams = getEnvInt("HTTP2_MAX_CONCURRENT_STREAMS", c.HTTP2MaxConcurrentStreams)
+	c.MaxHeaderBytes = getEnvInt("MAX_HEADER_BYTES", c.MaxHeaderBytes)
+	c.ReadTimeout = getEnvDuration("READ_TIMEOUT", c.ReadTimeout)
+	c.ReadHeaderTimeout = getEnvDuration("READ_HEADER_TIMEOUT", c.ReadHeaderTimeout)
+	c.WriteTimeout = getEnvDuration("WRITE_TIMEOUT", c.WriteTimeout)
+	c.IdleTimeout = getEnvDuration("IDLE_TIMEOUT", c.IdleTimeout)
+	c.KeepAlives = getEnvBool("KEEP_ALIVES", c.KeepAlives)
+	c.ShutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", c.ShutdownTimeout)
+	c.ShutdownUploadTimeout = getEnvDuration("SHUTDOWN_UPLOAD_TIMEOUT", c.ShutdownUploadTimeout)
+	c.ReusePort = getEnvBool("REUSE_PORT", c.ReusePort)
+	c.SwaggerUI = getEnvBool("SWAGGER_UI", c.SwaggerUI)
+
+	c.TLSCertFile = getEnv("TLS_CERT_FILE", c.TLSCertFile)
+	c.TLSKeyFile = getEnv("TLS_KEY_FILE", c.TLSKeyFile)
+	c.TLSAutocertDomains = getEnvList("TLS_AUTOCERT_DOMAINS", c.TLSAutocertDomains)
+	c.TLSAutocertCacheDir = getEnv("TLS_AUTOCERT_CACHE_DIR", c.TLSAutocertCacheDir)
+	c.TLSAutocertEmail = getEnv("TLS_AUTOCERT_EMAIL", c.TLSAutocertEmail)
+	c.TLSClientCAFile = getEnv("TLS_CLIENT_CA_FILE", c.TLSClientCAFile)
+	c.TLSClientAuth = getEnv("TLS_CLIENT_AUTH", c.TLSClientAuth)
+
+	if keys := os.Getenv("API_KEYS"); keys != "" {
+		apiKeys, err := parseAPIKeys(keys)
+		if err != nil {
+			return err
+		}
+		c.APIKeys = apiKeys
+	}
+	c.DownloadURLSigningKey = getEnv("DOWNLOAD_URL_SIGNING_KEY", c.DownloadURLSigningKey)
+	c.DownloadURLTTL = getEnvDuration("DOWNLOAD_URL_TTL", c.DownloadURLTTL)
+	c.CDNSigningKeyName = getEnv("CDN_SIGNING_KEY_NAME", c.CDNSigningKeyName)
+	c.CDNSigningKey = getEnv("CDN_SIGNING_KEY", c.CDNSigningKey)
+	c.CDNTokenTTL = getEnvDuration("CDN_TOKEN_TTL", c.CDNTokenTTL)
+	c.CDNTokenMaxTTL = getEnvDuration("CDN_TOKEN_MAX_TTL", c.CDNTokenMaxTTL)
+	c.SignedRequests = getEnvBool("SIGNED_REQUESTS", c.SignedRequests)
+	c.SignedRequestMaxSkew = getEnvDuration("SIGNED_REQUEST_MAX_SKEW", c.SignedRequestMaxSkew)
+
+	c.MaxUploadBytes = getEnvInt64("MAX_UPLOAD_BYTES", c.MaxUploadBytes)
+	c.MaxMultipartMemory = getEnvInt64("MAX_MULTIPART_MEMORY", c.MaxMultipartMemory)
+	c.SpoolDir = getEnv("SPOOL_DIR", c.SpoolDir)
+	c.MaxSpoolBytes = getEnvInt64("MAX_SPOOL_BYTES", c.MaxSpoolBytes)
+	c.RateLimit = getEnvFloat("RATE_LIMIT", c.RateLimit)
+	c.RateLimitBurst = getEnvInt("RATE_LIMIT_BURST", c.RateLimitBurst)
+	c.AllowedContentTypes = getEnvList("ALLOWED_CONTENT_TYPES", c.AllowedContentTypes)
+	c.TrustContentTypes = getEnvBool("TRUST_CONTENT_TYPES", c.TrustContentTypes)
+	c.MaxBatchFiles = getEnvInt("MAX_BATCH_FILES", c.MaxBatchFiles)
+	c.MaxBatchBytes = getEnvInt64("MAX_BATCH_BYTES", c.MaxBatchBytes)
+	c.Quota.DailyBytes = getEnvInt64("QUOTA_DAILY_BYTES", c.Quota.DailyBytes)
+	c.Quota.DailyObjects = getEnvInt64("QUOTA_DAILY_OBJECTS", c.Quota.DailyObjects)
+	c.Quota.MonthlyBytes = getEnvInt64("QUOTA_MONTHLY_BYTES", c.Quota.MonthlyBytes)
+	c.Quota.MonthlyObjects = getEnvInt64("QUOTA_MONTHLY_OBJECTS", c.Quota.MonthlyObjects)
+	c.Bandwidth.DownloadBytes = getEnvInt64("BANDWIDTH_DOWNLOAD_BYTES", c.Bandwidth.DownloadBytes)
+	c.Bandwidth.UploadBytes = getEnvInt64("BANDWIDTH_UPLOAD_BYTES", c.Bandwidth.UploadBytes)
+	c.Bandwidth.TenantDownloadBytes = getEnvInt64("BANDWIDTH_TENANT_DOWNLOAD_BYTES", c.Bandwidth.TenantDownloadBytes)
+	c.Bandwidth.TenantUploadBytes = getEnvInt64("BANDWIDTH_TENANT_UPLOAD_BYTES", c.Bandwidth.TenantUploadBytes)
+	c.Admission.MaxUploads = getEnvInt("ADMISSION_MAX_UPLOADS", c.Admission.MaxUploads)
+	c.Admission.MaxDownloads = getEnvInt("ADMISSION_MAX_DOWNLOADS", c.Admission.MaxDownloads)
+	c.Admission.MaxHeapBytes = getEnvInt64("ADMISSION_MAX_HEAP_BYTES", c.Admission.MaxHeapBytes)
+	c.Admission.RetryAfter = getEnvDuration("ADMISSION_RETRY_AFTER", c.Admission.RetryAfter)
+
+	c.GCPProjectID = getEnv("GCP_PROJECT_ID", c.GCPProjectID)
+	c.GCSBucketName = getEnv("GCS_BUCKET_NAME", c.GCSBucketName)
+	c.GoogleCredentialsMode = getEnv("STORAGE_GOOGLE_CREDENTIALS_MODE", c.GoogleCredentialsMode)
+	c.GoogleCredentials = getEnv("STORAGE_GOOGLE_APPLICATION_CREDENTIALS", c.GoogleCredentials)
+	c.GoogleImpersonateServiceAccount = getEnv("STORAGE_GOOGLE_IMPERSONATE_SERVICE_ACCOUNT", c.GoogleImpersonateServiceAccount)
+	c.GCSEmulatorHost = getEnv("STORAGE_EMULATOR_HOST", c.GCSEmulatorHost)
+	c.GCSReadConcurrency = getEnvInt("STORAGE_READ_CONCURRENCY", c.GCSReadConcurrency)
+	c.GCSReadPartSize = getEnvInt64("STORAGE_READ_PART_SIZE", c.GCSReadPartSize)
+	if value := os.Getenv("STORAGE_ROUTES"); value != "" {
+		pairs, err := parsePrefixPairs("STORAGE_ROUTES", value)
+		if err != nil {
+			return err
+		}
+		c.StorageRoutes = nil
+		for _, pair := range pairs {
+			c.StorageRoutes = append(c.StorageRoutes, StorageRoute{Prefix: pair[0], Target: pair[1]})
+		}
+	}
+
+	c.StreamPlaylistMaxAge = getEnvDuration("STREAM_PLAYLIST_MAX_AGE", c.StreamPlaylistMaxAge)
+	c.StreamSegmentMaxAge = getEnvDuration("STREAM_SEGMENT_MAX_AGE", c.StreamSegmentMaxAge)
+	c.ReadinessCacheTTL = getEnvDuration("READINESS_CACHE_TTL", c.ReadinessCacheTTL)
+	c.ReadCacheMaxBytes = getEnvInt64("READ_CACHE_MAX_BYTES", c.ReadCacheMaxBytes)
+	c.ReadCacheMaxObjectBytes = getEnvInt64("READ_CACHE_MAX_OBJECT_BYTES", c.ReadCacheMaxObjectBytes)
+	c.ReadCacheTTL = getEnvDuration("READ_CACHE_TTL", c.ReadCacheTTL)
+	c.ReadCoalescing = getEnvBool("READ_COALESCING", c.ReadCoalescing)
+
+	c.RedisURL = getEnv("REDIS_URL", c.RedisURL)
+	c.RedisKeyPrefix = getEnv("REDIS_KEY_PREFIX", c.RedisKeyPrefix)
+
+	c.ImageSigningKey = getEnv("IMAGE_SIGNING_KEY", c.ImageSigningKey)
+	c.ImageVariantPrefix = getEnv("IMAGE_VARIANT_PREFIX", c.ImageVariantPrefix)
+	c.StripImageMetadata = getEnvBool("STRIP_IMAGE_METADATA", c.StripImageMetadata)
+
+	c.FFmpegPath = getEnv("FFMPEG_PATH", c.FFmpegPath)
+
+	c.EventsPubSubTopic = getEnv("EVENTS_PUBSUB_TOPIC", c.EventsPubSubTopic)
+	c.EventsWebhookURL = getEnv("EVENTS_WEBHOOK_URL", c.EventsWebhookURL)
+	c.EventsWebhookSecret = getEnv("EVENTS_WEBHOOK_SECRET", c.EventsWebhookSecret)
+	c.EventsMaxAttempts = getEnvInt("EVENTS_MAX_ATTEMPTS", c.EventsMaxAttempts)
+	c.EventsDeadLetterPrefix = getEnv("EVENTS_DEAD_LETTER_PREFIX", c.EventsDeadLetterPrefix)
+	c.EventsStreamEnabled = getEnvBool("EVENTS_STREAM_ENABLED", c.EventsStreamEnabled)
+	c.EventsStreamSubscription = getEnv("EVENTS_STREAM_PUBSUB_SUBSCRIPTION", c.EventsStreamSubscription)
+
+	c.UploadURLTTL = getEnvDuration("UPLOAD_URL_TTL", c.UploadURLTTL)
+	c.UploadRegistrationPrefix = getEnv("UPLOAD_REGISTRATION_PREFIX", c.UploadRegistrationPrefix)
+	c.UploadSubscription = getEnv("UPLOAD_PUBSUB_SUBSCRIPTION", c.UploadSubscription)
+	c.UploadThumbnailWidth = getEnvInt("UPLOAD_THUMBNAIL_WIDTH", c.UploadThumbnailWidth)
+	c.UploadCallbackSecret = getEnv("UPLOAD_CALLBACK_SECRET", c.UploadCallbackSecret)
+	c.UploadCallbackHosts = getEnvList("UPLOAD_CALLBACK_HOSTS", c.UploadCallbackHosts)
+	c.UploadProgressEnabled = getEnvBool("UPLOAD_PROGRESS_ENABLED", c.UploadProgressEnabled)
+	c.UploadProgressMinBytes = getEnvInt64("UPLOAD_PROGRESS_MIN_BYTES", c.UploadProgressMinBytes)
+	c.UploadProgressTTL = getEnvDuration("UPLOAD_PROGRESS_TTL", c.UploadProgressTTL)
+
+	c.DedupPrefix = getEnv("DEDUP_PREFIX", c.DedupPrefix)
+
+	c.TrashPrefix = getEnv("TRASH_PREFIX", c.TrashPrefix)
+	c.TrashRetention = getEnvDuration("TRASH_RETENTION", c.TrashRetention)
+	c.TrashPurgeInterval = getEnvDuration("TRASH_PURGE_INTERVAL", c.TrashPurgeInterval)
+
+	c.TagIndexPrefix = getEnv("TAG_INDEX_PREFIX", c.TagIndexPrefix)
+
+	c.SearchEnabled = getEnvBool("SEARCH_ENABLED", c.SearchEnabled)
+	c.SearchResyncInterval = getEnvDuration("SEARCH_RESYNC_INTERVAL", c.SearchResyncInterval)
+	c.SearchSubscription = getEnv("SEARCH_PUBSUB_SUBSCRIPTION", c.SearchSubscription)
+	c.SearchExcludePrefixes = getEnvList("SEARCH_EXCLUDE_PREFIXES", c.SearchExcludePrefixes)
+
+	c.CatalogDriver = getEnv("CATALOG_DRIVER", c.CatalogDriver)
+	c.CatalogDSN = getEnv("CATALOG_DSN", c.CatalogDSN)
+	c.CatalogListings = getEnvBool("CATALOG_LISTINGS", c.CatalogListings)
+
+	c.ChangesEnabled = getEnvBool("CHANGES_ENABLED", c.ChangesEnabled)
+	c.ChangesRetention = getEnvInt("CHANGES_RETENTION", c.ChangesRetention)
+	c.ChangesSubscription = getEnv("CHANGES_PUBSUB_SUBSCRIPTION", c.ChangesSubscription)
+
+	c.LeasesEnabled = getEnvBool("LEASES_ENABLED", c.LeasesEnabled)
+	c.LeasesRequired = getEnvBool("LEASES_REQUIRED", c.LeasesRequired)
+	c.LeasesDefaultTTL = getEnvDuration("LEASES_DEFAULT_TTL", c.LeasesDefaultTTL)
+	c.LeasesMaxTTL = getEnvDuration("LEASES_MAX_TTL", c.LeasesMaxTTL)
+
+	c.SharesEnabled = getEnvBool("SHARES_ENABLED", c.SharesEnabled)
+	c.SharesDefaultTTL = getEnvDuration("SHARES_DEFAULT_TTL", c.SharesDefaultTTL)
+	c.SharesMaxTTL = getEnvDuration("SHARES_MAX_TTL", c.SharesMaxTTL)
+
+	c.MountsEnabled = getEnvBool("MOUNTS_ENABLED", c.MountsEnabled)
+	c.MountsRefreshInterval = getEnvDuration("MOUNTS_REFRESH_INTERVAL", c.MountsRefreshInterval)
+
+	c.StatsEnabled = getEnvBool("STATS_ENABLED", c.StatsEnabled)
+	c.StatsFlushInterval = getEnvDuration("STATS_FLUSH_INTERVAL", c.StatsFlushInterval)
+
+	c.UsageCacheTTL = getEnvDuration("USAGE_CACHE_TTL", c.UsageCacheTTL)
+	c.UsageWait = getEnvDuration("USAGE_WAIT", c.UsageWait)
+
+	c.IdempotencyEnabled = getEnvBool("IDEMPOTENCY_ENABLED", c.IdempotencyEnabled)
+	c.IdempotencyTTL = getEnvDuration("IDEMPOTENCY_TTL", c.IdempotencyTTL)
+
+	c.LogsEnabled = getEnvBool("LOGS_ENABLED", c.LogsEnabled)
+	c.LogsPrefix = getEnv("LOGS_PREFIX", c.LogsPrefix)
+	c.LogsMaxRecordBytes = getEnvInt64("LOGS_MAX_RECORD_BYTES", c.LogsMaxRecordBytes)
+
+	c.SiteEnabled = getEnvBool("SITE_ENABLED", c.SiteEnabled)
+	c.UIEnabled = getEnvBool("UI_ENABLED", c.UIEnabled)
+	c.SitePrefix = getEnv("SITE_PREFIX", c.SitePrefix)
+	c.SiteIndex = getEnv("SITE_INDEX", c.SiteIndex)
+	c.SiteSPAFallback = getEnvBool("SITE_SPA_FALLBACK", c.SiteSPAFallback)
+	c.SitePublic = getEnvBool("SITE_PUBLIC", c.SitePublic)
+	c.SiteAssetMaxAge = getEnvDuration("SITE_ASSET_MAX_AGE", c.SiteAssetMaxAge)
+
+	c.GCPrefixes = getEnvList("GC_PREFIXES", c.GCPrefixes)
+	c.GCTTL = getEnvDuration("GC_TTL", c.GCTTL)
+	c.GCInterval = getEnvDuration("GC_INTERVAL", c.GCInterval)
+
+	c.ExpiryIndexPrefix = getEnv("EXPIRY_INDEX_PREFIX", c.ExpiryIndexPrefix)
+	c.ExpiryInterval = getEnvDuration("EXPIRY_INTERVAL", c.ExpiryInterval)
+
+	c.FetchEnabled = getEnvBool("FETCH_ENABLED", c.FetchEnabled)
+	c.FetchMaxBytes = getEnvInt64("FETCH_MAX_BYTES", c.FetchMaxBytes)
+	c.FetchTimeout = getEnvDuration("FETCH_TIMEOUT", c.FetchTimeout)
+	c.FetchAllowedHosts = getEnvList("FETCH_ALLOWED_HOSTS", c.FetchAllowedHosts)
+
+	c.ModerationProvider = getEnv("MODERATION_PROVIDER", c.ModerationProvider)
+	c.ModerationThreshold = getEnv("MODERATION_THRESHOLD", c.ModerationThreshold)
+	c.ModerationCategories = getEnvList("MODERATION_CATEGORIES", c.ModerationCategories)
+	c.ModerationQuarantinePrefix = getEnv("MODERATION_QUARANTINE_PREFIX", c.ModerationQuarantinePrefix)
+	c.ModerationTimeout = getEnvDuration("MODERATION_TIMEOUT", c.ModerationTimeout)
+
+	if value := os.Getenv("TRANSFER_BUCKETS"); value != "" {
+		c.TransferBuckets = nil
+		for _, entry := range strings.Split(value, ",") {
+			name, bucket, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				return fmt.Errorf("%w: TRANSFER_BUCKETS entries must be name=bucket", ErrInvalidConfig)
+			}
+			c.TransferBuckets = append(c.TransferBuckets, TransferBucket{Name: name, Bucket: bucket})
+		}
+	}
+
+	c.ReplicationTarget = getEnv("REPLICATION_TARGET", c.ReplicationTarget)
+	c.ReplicationCredentials = getEnv("REPLICATION_CREDENTIALS", c.ReplicationCredentials)
+	c.ReplicationS3Endpoint = getEnv("REPLICATION_S3_ENDPOINT", c.ReplicationS3Endpoint)
+	c.ReplicationS3Region = getEnv("REPLICATION_S3_REGION", c.ReplicationS3Region)
+	c.ReplicationS3AccessKeyID = getEnv("REPLICATION_S3_ACCESS_KEY_ID", c.ReplicationS3AccessKeyID)
+	c.ReplicationS3SecretAccessKey = getEnv("REPLICATION_S3_SECRET_ACCESS_KEY", c.ReplicationS3SecretAccessKey)
+	c.ReplicationQueuePrefix = getEnv("REPLICATION_QUEUE_PREFIX", c.ReplicationQueuePrefix)
+	c.ReplicationInterval = getEnvDuration("REPLICATION_INTERVAL", c.ReplicationInterval)
+	c.ReplicationMaxAttempts = getEnvInt("REPLICATION_MAX_ATTEMPTS", c.ReplicationMaxAttempts)
+	c.ReplicationVerifyInterval = getEnvDuration("REPLICATION_VERIFY_INTERVAL", c.ReplicationVerifyInterval)
+	c.ReplicationVerifySample = getEnvInt("REPLICATION_VERIFY_SAMPLE", c.ReplicationVerifySample)
+	c.ReplicationVerifyRepair = getEnvBool("REPLICATION_VERIFY_REPAIR", c.ReplicationVerifyRepair)
+
+	c.S3Port = getEnv("S3_PORT", c.S3Port)
+	c.S3Region = getEnv("S3_REGION", c.S3Region)
+	c.S3Bucket = getEnv("S3_BUCKET", c.S3Bucket)
+	if value := os.Getenv("S3_ACCESS_KEYS"); value != "" {
+		c.S3AccessKeys = nil
+		for _, entry := range strings.Split(value, ",") {
+			id, secret, ok := strings.Cut(strings.TrimSpace(entry), ":")
+			if !ok {
+				return fmt.Errorf("%w: S3_ACCESS_KEYS entries must be access_key_id:secret_access_key", ErrInvalidConfig)
+			}
+			c.S3AccessKeys = append(c.S3AccessKeys, S3AccessKey{AccessKeyID: id, SecretAccessKey: secret})
+		}
+	}
+
+	c.SFTPPort = getEnv("SFTP_PORT", c.SFTPPort)
+	c.SFTPHostKeyFile = getEnv("SFTP_HOST_KEY_FILE", c.SFTPHostKeyFile)
+
+	c.MaintenanceEnabled = getEnvBool("MAINTENANCE_ENABLED", c.MaintenanceEnabled)
+	c.MaintenanceMessage = getEnv("MAINTENANCE_MESSAGE", c.MaintenanceMessage)
+	c.MaintenancePageFile = getEnv("MAINTENANCE_PAGE_FILE", c.MaintenancePageFile)
+	c.MaintenanceRetryAfter = getEnvDuration("MAINTENANCE_RETRY_AFTER", c.MaintenanceRetryAfter)
+
+	c.DebugEnabled = getEnvBool("DEBUG_ENABLED", c.DebugEnabled)
+	c.DebugAddr = getEnv("DEBUG_ADDR", c.DebugAddr)
+
+	c.JobWorkers = getEnvInt("JOB_WORKERS", c.JobWorkers)
+	c.JobQueueSize = getEnvInt("JOB_QUEUE_SIZE", c.JobQueueSize)
+	c.JobRetention = getEnvDuration("JOB_RETENTION", c.JobRetention)
+	c.JobResultPrefix = getEnv("JOB_RESULT_PREFIX", c.JobResultPrefix)
+	if value := os.Getenv("JOB_SCHEDULES"); value != "" {
+		// Cron expressions hold commas, so entries are separated by semicolons
+		c.JobSchedules = make(map[string]string)
+		for _, entry := range strings.Split(value, ";") {
+			jobType, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				return fmt.Errorf("%w: JOB_SCHEDULES entries must be type=schedule", ErrInvalidConfig)
+			}
+			c.JobSchedules[jobType] = spec
+		}
+	}
+	c.JobScheduleJitter = getEnvDuration("JOB_SCHEDULE_JITTER", c.JobScheduleJitter)
+	c.JobElection = getEnv("JOB_ELECTION", c.JobElection)
+	c.JobElectionPrefix = getEnv("JOB_ELECTION_PREFIX", c.JobElectionPrefix)
+	c.JobElectionTTL = getEnvDuration("JOB_ELECTION_TTL", c.JobElectionTTL)
+
+	if value := os.Getenv("KMS_KEYS"); value != "" {
+		pairs, err := parsePrefixPairs("KMS_KEYS", value)
+		if err != nil {
+			return err
+		}
+		c.KMSKeys = nil
+		for _, pair := range pairs {
+			c.KMSKeys = append(c.KMSKeys, KMSKeyRule{Prefix: pair[0], Key: pair[1]})
+		}
+	}
+	c.EnvelopeKMSKey = getEnv("ENVELOPE_KMS_KEY", c.EnvelopeKMSKey)
+	c.EnvelopeMasterKey = getEnv("ENVELOPE_MASTER_KEY", c.EnvelopeMasterKey)
+	c.EnvelopePreviousMasterKeys = getEnvList("ENVELOPE_PREVIOUS_MASTER_KEYS", c.EnvelopePreviousMasterKeys)
+	c.EnvelopePrefixes = getEnvList("ENVELOPE_PREFIXES", c.EnvelopePrefixes)
+	if value := os.Getenv("STORAGE_CLASSES"); value != "" {
+		pairs, err := parsePrefixPairs("STORAGE_CLASSES", value)
+		if err != nil {
+			return err
+		}
+		c.StorageClassRules = nil
+		for _, pair := range pairs {
+			c.StorageClassRules = append(c.StorageClassRules, StorageClassRule{Prefix: pair[0], StorageClass: pair[1]})
+		}
+	}
+	c.HotlinkEnabled = getEnvBool("HOTLINK_ENABLED", c.HotlinkEnabled)
+	c.HotlinkPaths = getEnvList("HOTLINK_PATHS", c.HotlinkPaths)
+	c.HotlinkAllowedOrigins = getEnvList("HOTLINK_ALLOWED_ORIGINS", c.HotlinkAllowedOrigins)
+	c.HotlinkAllowEmptyReferer = getEnvBool("HOTLINK_ALLOW_EMPTY_REFERER", c.HotlinkAllowEmptyReferer)
+	c.IPAllow = getEnvList("IP_ALLOW", c.IPAllow)
+	c.IPDeny = getEnvList("IP_DENY", c.IPDeny)
+	c.PrivateWrites = getEnvBool("PRIVATE_WRITES", c.PrivateWrites)
+	c.InternalRanges = getEnvList("INTERNAL_RANGES", c.InternalRanges)
+	c.IAPEnabled = getEnvBool("IAP_ENABLED", c.IAPEnabled)
+	c.IAPAudience = getEnv("IAP_AUDIENCE", c.IAPAudience)
+	c.PolicyEnabled = getEnvBool("POLICY_ENABLED", c.PolicyEnabled)
+	c.PolicyObject = getEnv("POLICY_OBJECT", c.PolicyObject)
+	c.PolicyRefreshInterval = getEnvDuration("POLICY_REFRESH_INTERVAL", c.PolicyRefreshInterval)
+	c.AuthorizerURL = getEnv("AUTHORIZER_URL", c.AuthorizerURL)
+	c.AuthorizerSecret = getEnv("AUTHORIZER_SECRET", c.AuthorizerSecret)
+	c.AuthorizerTimeout = getEnvDuration("AUTHORIZER_TIMEOUT", c.AuthorizerTimeout)
+	c.AuthorizerCacheTTL = getEnvDuration("AUTHORIZER_CACHE_TTL", c.AuthorizerCacheTTL)
+	c.AuthorizerFailOpen = getEnvBool("AUTHORIZER_FAIL_OPEN", c.AuthorizerFailOpen)
+	c.OIDCEnabled = getEnvBool("OIDC_ENABLED", c.OIDCEnabled)
+	c.OIDCClientID = getEnv("OIDC_CLIENT_ID", c.OIDCClientID)
+	c.OIDCClientSecret = getEnv("OIDC_CLIENT_SECRET", c.OIDCClientSecret)
+	c.OIDCRedirectURL = getEnv("OIDC_REDIRECT_URL", c.OIDCRedirectURL)
+	c.OIDCHostedDomain = getEnv("OIDC_HOSTED_DOMAIN", c.OIDCHostedDomain)
+	c.OIDCSessionKey = getEnv("OIDC_SESSION_KEY", c.OIDCSessionKey)
+	c.OIDCSessionTTL = getEnvDuration("OIDC_SESSION_TTL", c.OIDCSessionTTL)
+	c.SecurityHeadersEnabled = getEnvBool("SECURITY_HEADERS_ENABLED", c.SecurityHeadersEnabled)
+	c.ContentSecurityPolicy = getEnv("SECURITY_HEADERS_CONTENT_SECURITY_POLICY", c.ContentSecurityPolicy)
+	c.FrameOptions = getEnv("SECURITY_HEADERS_FRAME_OPTIONS", c.FrameOptions)
+	c.ReferrerPolicy = getEnv("SECURITY_HEADERS_REFERRER_POLICY", c.ReferrerPolicy)
+	c.HSTSMaxAge = getEnvDuration("SECURITY_HEADERS_HSTS_MAX_AGE", c.HSTSMaxAge)
+	c.HSTSIncludeSubdomains = getEnvBool("SECURITY_HEADERS_HSTS_INCLUDE_SUBDOMAINS", c.HSTSIncludeSubdomains)
+
+	if value := os.Getenv("COMPRESSION_RULES"); value != "" {
+		pairs, err := parsePrefixPairs("COMPRESSION_RULES", value)
+		if err != nil {
+			return err
+		}
+		c.CompressionRules = nil
+		for _, pair := range pairs {
+			c.CompressionRules = append(c.CompressionRules, CompressionRule{ContentType: pair[0], Encoding: pair[1]})
+		}
+	}
+	if value := os.Getenv("CACHE_CONTROL_RULES"); value != "" {
+		pairs, err := parsePrefixPairs("CACHE_CONTROL_RULES", value)
+		if err != nil {
+			return err
+		}
+		c.CacheControlRules = nil
+		for _, pair := range pairs {
+			rule, err := parseCacheControlRule(pair[0], pair[1])
+			if err != nil {
+				return err
+			}
+			c.CacheControlRules = append(c.CacheControlRules, rule)
+		}
+	}
+	if value := os.Getenv("REDIRECT_RULES"); value != "" {
+		pairs, err := parsePrefixPairs("REDIRECT_RULES", value)
+		if err != nil {
+			return err
+		}
+		c.RedirectRules = nil
+		for _, pair := range pairs {
+			minBytes, err := strconv.ParseInt(pair[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: REDIRECT_RULES values must be a size in bytes, got %q", ErrInvalidConfig, pair[1])
+			}
+			c.RedirectRules = append(c.RedirectRules, RedirectRule{Pattern: pair[0], MinBytes: minBytes})
+		}
+	}
+	c.RedirectURLTTL = getEnvDuration("REDIRECT_URL_TTL", c.RedirectURLTTL)
+
+	return nil
+}
+
+// parseAPIKeys parses a comma separated list of name:key pairs, optionally
+// followed by :scope|scope
+func parseAPIKeys(value string) ([]APIKey, error) {
+	var keys []APIKey
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("%w: API_KEYS entries must be name:key or name:key:scopes", ErrInvalidConfig)
+		}
+		key := APIKey{Name: parts[0], Key: parts[1]}
+		if len(parts) == 3 {
+			key.Scopes = strings.Split(parts[2], "|")
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parsePrefixPairs parses a comma separated list of prefix=value rules
+func parsePrefixPairs(name, value string) ([][2]string, error) {
+	var pairs [][2]string
+	for _, entry := range strings.Split(value, ",") {
+		prefix, v, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %s entries must be prefix=value", ErrInvalidConfig, name)
+		}
+		pairs = append(pairs, [2]string{prefix, v})
+	}
+	return pairs, nil
+}
+
+// parseCacheControlRule parses the value of a CACHE_CONTROL_RULES entry, a max
+// age optionally followed by "immutable", like "24h immutable", or "no-store"
+func parseCacheControlRule(pattern, value string) (CacheControlRule, error) {
+	rule := CacheControlRule{Pattern: pattern}
+	fields := strings.Fields(value)
+	switch {
+	case len(fields) == 1 && fields[0] == "no-store":
+		rule.NoStore = true
+		return rule, nil
+	case len(fields) == 2 && fields[1] == "immutable":
+		rule.Immutable = true
+	case len(fields) != 1:
+		return rule, fmt.Errorf("%w: CACHE_CONTROL_RULES values must be a max age, optionally followed by immutable, or no-store", ErrInvalidConfig)
+	}
+	maxAge, err := time.ParseDuration(fields[0])
+	if err != nil {
+		return rule, fmt.Errorf("%w: CACHE_CONTROL_RULES max age %q: %v", ErrInvalidConfig, fields[0], err)
+	}
+	rule.MaxAge = maxAge
+	return rule, nil
+}
+
+// Validate checks the configuration and reports every violation found
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidConfig}, args...)...))
+	}
+
+	if c.GCPProjectID == "" {
+		errs = append(errs, ErrMissingProjectID)
+	}
+	if c.GCSBucketName == "" {
+		errs = append(errs, ErrMissingBucketName)
+	}
+	switch c.GoogleCredentialsMode {
+	case "":
+	case "adc":
+		if c.GoogleCredentials != "" {
+			invalid("backends.gcs.credentials must be empty with credentials_mode adc; set GOOGLE_APPLICATION_CREDENTIALS instead")
+		}
+	case "file", "base64-json":
+		if c.GoogleCredentials == "" {
+			invalid("backends.gcs.credentials_mode %s requires backends.gcs.credentials", c.GoogleCredentialsMode)
+		}
+	case "impersonation":
+		if !strings.Contains(c.GoogleImpersonateServiceAccount, "@") {
+			invalid("backends.gcs.credentials_mode impersonation requires impersonate_service_account to be a service account email")
+		}
+	default:
+		invalid("backends.gcs.credentials_mode must be adc, file, base64-json or impersonation, got %q", c.GoogleCredentialsMode)
+	}
+	if c.GoogleImpersonateServiceAccount != "" && c.GoogleCredentialsMode != "impersonation" {
+		invalid("backends.gcs.impersonate_service_account requires credentials_mode impersonation")
+	}
+	if c.GCSReadConcurrency < 1 {
+		invalid("backends.gcs.read_concurrency must be at least 1, got %d", c.GCSReadConcurrency)
+	}
+	if c.GCSReadPartSize < 1<<20 {
+		invalid("backends.gcs.read_part_size must be at least 1 MiB, got %d", c.GCSReadPartSize)
+	}
+	routePrefixes := make(map[string]bool, len(c.StorageRoutes))
+	for i, route := range c.StorageRoutes {
+		if route.Prefix == "" || strings.HasPrefix(route.Prefix, "/") || routePrefixes[route.Prefix] {
+			invalid("backends.routes[%d].prefix must be set, unique and not start with a slash", i)
+		}
+		routePrefixes[route.Prefix] = true
+		target, err := url.Parse(route.Target)
+		switch {
+		case err != nil:
+			invalid("backends.routes[%d].target must be a URL: %v", i, err)
+		case target.Scheme == "gs":
+			if target.Host == "" || strings.Trim(target.Path, "/") != "" {
+				invalid("backends.routes[%d].target must name a bucket, like gs://bucket", i)
+			}
+		case target.Scheme == "file":
+			if target.Host != "" || !strings.HasPrefix(target.Path, "/") {
+				invalid("backends.routes[%d].target must be an absolute path, like file:///var/lib/proxy", i)
+			}
+		default:
+			invalid("backends.routes[%d].target must be a gs:// or file:// URL, got %q", i, route.Target)
+		}
+		if route.QuotaBytes < 0 {
+			invalid("backends.routes[%d].quota_bytes must not be negative", i)
+		}
+		if route.Cache && c.ReadCacheMaxBytes == 0 {
+			invalid("backends.routes[%d].cache requires caching.read_cache_max_bytes", i)
+		}
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		invalid("server.port must be a valid TCP port, got %q", c.Port)
+	}
+	if c.ReadinessTimeout <= 0 {
+		invalid("server.readiness_timeout must be positive")
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		invalid("server.log_level must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+	if c.ConfigWatchInterval < 0 {
+		invalid("server.config_watch_interval must not be negative")
+	}
+	if c.ReadTimeout < 0 || c.ReadHeaderTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 {
+		invalid("server timeouts must not be negative")
+	}
+	if c.ShutdownTimeout < 0 || c.ShutdownUploadTimeout < c.ShutdownTimeout {
+		invalid("server.shutdown_timeout must not be negative or longer than server.shutdown_upload_timeout")
+	}
+	if c.MaxHeaderBytes < 0 || c.HTTP2MaxConcurrentStreams < 0 {
+		invalid("server.max_header_bytes and server.http2_max_concurrent_streams must not be negative")
+	}
+	if c.PublicBaseURL != "" {
+		if u, err := url.Parse(c.PublicBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("server.public_base_url must be an http(s) URL")
+		}
+	}
+	if !validRanges(c.TrustedProxies) {
+		invalid("server.trusted_proxies must be IP addresses or CIDR ranges")
+	}
+	if c.H2C && !c.HTTP2 {
+		invalid("server.h2c requires server.http2")
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		invalid("tls.cert_file and tls.key_file must be set together")
+	}
+	if c.TLSCertFile != "" && len(c.TLSAutocertDomains) > 0 {
+		invalid("tls.cert_file and tls.autocert_domains are mutually exclusive")
+	}
+	if c.TLSClientCAFile != "" && !c.TLSEnabled() {
+		invalid("tls.client_ca_file requires a certificate or autocert domains")
+	}
+	if c.TLSClientAuth != "" && c.TLSClientAuth != "require" && c.TLSClientAuth != "optional" {
+		invalid("tls.client_auth must be require or optional, got %q", c.TLSClientAuth)
+	}
+
+	names := make(map[string]bool)
+	for i, key := range c.APIKeys {
+		if key.Name == "" || key.Key == "" {
+			invalid("auth.api_keys[%d] requires name and key", i)
+		}
+		if names[key.Name] {
+			invalid("auth.api_keys[%d] duplicates name %q", i, key.Name)
+		}
+		for _, scope := range key.Scopes {
+			if scope != "admin" {
+				invalid("auth.api_keys[%d] has unknown scope %q", i, scope)
+			}
+		}
+		if key.Quota != nil && !key.Quota.valid() {
+			invalid("auth.api_keys[%d].quota must not be negative", i)
+		}
+		if key.ServiceAccount != "" && !strings.Contains(key.ServiceAccount, "@") {
+			invalid("auth.api_keys[%d].service_account must be a service account email, got %q", i, key.ServiceAccount)
+		}
+		names[key.Name] = true
+	}
+
+	if c.DownloadURLTTL <= 0 {
+		invalid("auth.download_url_ttl must be positive")
+	}
+	if c.CDNSigningKey != "" {
+		if _, err := base64.URLEncoding.DecodeString(c.CDNSigningKey); err != nil || c.CDNSigningKeyName == "" {
+			invalid("auth.cdn_signing_key must be base64url encoded and named by auth.cdn_signing_key_name")
+		}
+		if c.CDNTokenTTL <= 0 || c.CDNTokenMaxTTL < c.CDNTokenTTL {
+			invalid("auth.cdn_token_ttl must be positive and no longer than auth.cdn_token_max_ttl")
+		}
+	}
+	if c.SignedRequests && c.SignedRequestMaxSkew < time.Second {
+		invalid("auth.signed_request_max_skew must be at least 1s")
+	}
+
+	if c.MaxUploadBytes <= 0 {
+		invalid("limits.max_upload_bytes must be positive")
+	}
+	if c.MaxMultipartMemory <= 0 {
+		invalid("limits.max_multipart_memory must be positive")
+	}
+	if !c.Quota.valid() {
+		invalid("limits.quota must not be negative")
+	}
+	if b := c.Bandwidth; b.DownloadBytes < 0 || b.UploadBytes < 0 || b.TenantDownloadBytes < 0 || b.TenantUploadBytes < 0 {
+		invalid("limits.bandwidth must not be negative")
+	}
+	for i, route := range c.Bandwidth.Routes {
+		if !strings.HasPrefix(route.Prefix, "/") || route.DownloadBytes < 0 || route.UploadBytes < 0 {
+			invalid("limits.bandwidth.routes[%d] requires a path prefix starting with / and non-negative limits", i)
+		}
+	}
+	if a := c.Admission; a.MaxUploads < 0 || a.MaxDownloads < 0 || a.MaxHeapBytes < 0 {
+		invalid("limits.admission must not be negative")
+	}
+	if c.Admission.RetryAfter < time.Second {
+		invalid("limits.admission.retry_after must be at least 1s")
+	}
+	if c.MaxSpoolBytes < 0 {
+		invalid("limits.max_spool_bytes must not be negative")
+	}
+	if c.MaxBatchFiles < 0 || c.MaxBatchBytes < 0 {
+		invalid("limits.max_batch_files and limits.max_batch_bytes must not be negative")
+	}
+	if c.RateLimit < 0 || c.RateLimitBurst < 0 {
+		invalid("limits.rate_limit and limits.rate_limit_burst must not be negative")
+	}
+	for i, contentType := range c.AllowedContentTypes {
+		if !strings.Contains(contentType, "/") {
+			invalid("limits.allowed_content_types[%d] must be a MIME type or type/*, got %q", i, contentType)
+		}
+	}
+
+	if c.StreamPlaylistMaxAge < 0 || c.StreamSegmentMaxAge < 0 || c.ReadinessCacheTTL < 0 || c.ReadCacheTTL < 0 {
+		invalid("caching durations must not be negative")
+	}
+	if c.ReadCacheMaxBytes < 0 || c.ReadCacheMaxObjectBytes < 0 {
+		invalid("caching.read_cache_max_bytes and caching.read_cache_max_object_bytes must not be negative")
+	}
+
+	if c.RedisURL != "" {
+		if u, err := url.Parse(c.RedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss" && u.Scheme != "unix") {
+			invalid("redis.url must be a redis://, rediss:// or unix:// URL")
+		}
+	}
+
+	if c.EventsWebhookURL != "" {
+		if u, err := url.Parse(c.EventsWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("events.webhook_url must be an http(s) URL")
+		}
+	}
+	if c.EventsMaxAttempts < 1 {
+		invalid("events.max_attempts must be at least 1")
+	}
+
+	if c.UploadURLTTL <= 0 || c.UploadURLTTL > 7*24*time.Hour {
+		invalid("uploads.url_ttl must be between 0 and 7 days")
+	}
+	if c.UploadRegistrationPrefix == "" {
+		invalid("uploads.registration_prefix must not be empty")
+	}
+	if c.UploadThumbnailWidth < 1 || c.UploadThumbnailWidth > 4096 {
+		invalid("uploads.thumbnail_width must be between 1 and 4096")
+	}
+	if c.UploadProgressMinBytes < 0 || c.UploadProgressTTL <= 0 {
+		invalid("uploads.progress_min_bytes must not be negative and uploads.progress_ttl must be positive")
+	}
+
+	if c.TrashPrefix != "" {
+		if strings.Trim(c.TrashPrefix, "/") == "" {
+			invalid("trash.prefix must not be only slashes")
+		}
+		if c.TrashRetention <= 0 || c.TrashPurgeInterval <= 0 {
+			invalid("trash.retention and trash.purge_interval must be positive")
+		}
+	}
+
+	if c.TagIndexPrefix != "" && strings.Trim(c.TagIndexPrefix, "/") == "" {
+		invalid("tags.index_prefix must not be only slashes")
+	}
+
+	for i, prefix := range c.GCPrefixes {
+		// An empty prefix would collect the whole bucket
+		if strings.Trim(prefix, "/") == "" {
+			invalid("gc.prefixes[%d] must not be empty", i)
+		}
+	}
+	if len(c.GCPrefixes) > 0 && (c.GCTTL <= 0 || c.GCInterval <= 0) {
+		invalid("gc.ttl and gc.interval must be positive")
+	}
+
+	if c.ExpiryIndexPrefix != "" {
+		if strings.Trim(c.ExpiryIndexPrefix, "/") == "" {
+			invalid("expiry.index_prefix must not be only slashes")
+		}
+		if c.ExpiryInterval <= 0 {
+			invalid("expiry.interval must be positive")
+		}
+	}
+
+	for jobType, spec := range c.JobSchedules {
+		if !slices.Contains(scheduledJobs, jobType) {
+			invalid("jobs.schedules.%s: only %s jobs are scheduled", jobType, strings.Join(scheduledJobs, ", "))
+		} else if _, err := scheduler.Parse(spec); err != nil {
+			invalid("jobs.schedules.%s: %v", jobType, err)
+		}
+	}
+	if c.JobScheduleJitter < 0 {
+		invalid("jobs.schedule_jitter must not be negative")
+	}
+	switch c.JobElection {
+	case "", "gcs":
+	case "redis":
+		if c.RedisURL == "" {
+			invalid("jobs.election redis needs redis.url")
+		}
+	default:
+		invalid("jobs.election must be gcs or redis, got %q", c.JobElection)
+	}
+	if c.JobElection != "" {
+		if strings.Trim(c.JobElectionPrefix, "/") == "" {
+			invalid("jobs.election_prefix must not be empty")
+		}
+		// The lock is renewed every third of the TTL
+		if c.JobElectionTTL < 3*time.Second {
+			invalid("jobs.election_ttl must be at least 3s")
+		}
+	}
+
+	if c.SearchEnabled && c.SearchResyncInterval <= 0 {
+		invalid("search.resync_interval must be positive")
+	}
+
+	switch c.CatalogDriver {
+	case "":
+		if c.CatalogListings {
+			invalid("catalog.listings requires catalog.driver")
+		}
+	case "sqlite", "postgres":
+		if c.CatalogDSN == "" {
+			invalid("catalog.dsn is required with catalog.driver")
+		}
+	default:
+		invalid("catalog.driver must be empty, sqlite or postgres, got %q", c.CatalogDriver)
+	}
+
+	if c.ChangesEnabled && c.ChangesRetention <= 0 {
+		invalid("changes.retention must be positive")
+	}
+
+	if c.LeasesEnabled && (c.LeasesDefaultTTL < time.Second || c.LeasesMaxTTL < c.LeasesDefaultTTL) {
+		invalid("leases.default_ttl must be at least 1s and no longer than leases.max_ttl")
+	}
+
+	if c.SharesEnabled && (c.SharesDefaultTTL < time.Second || c.SharesMaxTTL < c.SharesDefaultTTL) {
+		invalid("shares.default_ttl must be at least 1s and no longer than shares.max_ttl")
+	}
+
+	if c.MountsEnabled && c.MountsRefreshInterval <= 0 {
+		invalid("mounts.refresh_interval must be positive")
+	}
+
+	if c.StatsEnabled && c.StatsFlushInterval <= 0 {
+		invalid("stats.flush_interval must be positive")
+	}
+
+	if c.UsageCacheTTL < 0 || c.UsageWait < 0 {
+		invalid("usage.cache_ttl and usage.wait must not be negative")
+	}
+
+	if c.IdempotencyEnabled && c.IdempotencyTTL < time.Minute {
+		invalid("idempotency.ttl must be at least 1m")
+	}
+
+	if c.LogsEnabled && (strings.Trim(c.LogsPrefix, "/") == "" || c.LogsMaxRecordBytes <= 0) {
+		invalid("logs.prefix and a positive logs.max_record_bytes are required")
+	}
+
+	if c.SiteEnabled && (strings.Trim(c.SitePrefix, "/") == "" || c.SiteIndex == "" || strings.Contains(c.SiteIndex, "/") || c.SiteAssetMaxAge < 0) {
+		invalid("site.prefix and a site.index file name are required, and site.asset_max_age must not be negative")
+	}
+
+	if c.FetchMaxBytes < 0 || c.FetchTimeout < 0 {
+		invalid("fetch.max_bytes and fetch.timeout must not be negative")
+	}
+
+	transferBuckets := map[string]bool{"default": true}
+	for i, bucket := range c.TransferBuckets {
+		if bucket.Name == "" || bucket.Bucket == "" {
+			invalid("transfer.buckets[%d] requires name and bucket", i)
+		}
+		if transferBuckets[bucket.Name] {
+			invalid("transfer.buckets[%d] duplicates name %q; default is the served bucket", i, bucket.Name)
+		}
+		transferBuckets[bucket.Name] = true
+	}
+
+	if c.ReplicationTarget != "" {
+		target, err := url.Parse(c.ReplicationTarget)
+		switch {
+		case err != nil:
+			invalid("replication.target must be a URL: %v", err)
+		case target.Scheme == "gs" || target.Scheme == "s3":
+			if target.Host == "" || strings.Trim(target.Path, "/") != "" {
+				invalid("replication.target must name a bucket, like %s://bucket", target.Scheme)
+			}
+			if target.Scheme == "s3" && (c.ReplicationS3Region == "" || c.ReplicationS3AccessKeyID == "" || c.ReplicationS3SecretAccessKey == "") {
+				invalid("replication.s3_region, replication.s3_access_key_id and replication.s3_secret_access_key are required for an s3:// target")
+			}
+		case target.Scheme == "file":
+			if target.Host != "" || !strings.HasPrefix(target.Path, "/") {
+				invalid("replication.target must be an absolute path, like file:///mnt/backup")
+			}
+		default:
+			invalid("replication.target must be a gs://, s3:// or file:// URL, got %q", c.ReplicationTarget)
+		}
+		if strings.Trim(c.ReplicationQueuePrefix, "/") == "" || c.ReplicationInterval <= 0 || c.ReplicationMaxAttempts <= 0 {
+			invalid("replication.queue_prefix, a positive replication.interval and replication.max_attempts are required")
+		}
+		if c.ReplicationVerifyInterval < 0 || c.ReplicationVerifySample < 0 {
+			invalid("replication.verify_interval and replication.verify_sample must not be negative")
+		}
+	}
+
+	switch c.ModerationProvider {
+	case "", "google":
+	default:
+		invalid("moderation.provider must be empty or google, got %q", c.ModerationProvider)
+	}
+	if c.ModerationProvider != "" {
+		switch strings.ToUpper(c.ModerationThreshold) {
+		case "VERY_UNLIKELY", "UNLIKELY", "POSSIBLE", "LIKELY", "VERY_LIKELY":
+		default:
+			invalid("moderation.threshold must be VERY_UNLIKELY, UNLIKELY, POSSIBLE, LIKELY or VERY_LIKELY, got %q", c.ModerationThreshold)
+		}
+		if c.ModerationTimeout <= 0 {
+			invalid("moderation.timeout must be positive")
+		}
+	}
+	if c.ModerationQuarantinePrefix != "" && strings.Trim(c.ModerationQuarantinePrefix, "/") == "" {
+		invalid("moderation.quarantine_prefix must not be only slashes")
+	}
+
+	if c.S3Port != "" {
+		if port, err := strconv.Atoi(c.S3Port); err != nil || port < 1 || port > 65535 || c.S3Port == c.Port {
+			invalid("s3.port must be a valid TCP port other than server.port, got %q", c.S3Port)
+		}
+		if c.S3Region == "" {
+			invalid("s3.region must not be empty")
+		}
+		if len(c.S3AccessKeys) == 0 {
+			invalid("s3.access_keys must not be empty when s3.port is set")
+		}
+	}
+	for i, key := range c.S3AccessKeys {
+		if key.AccessKeyID == "" || key.SecretAccessKey == "" {
+			invalid("s3.access_keys[%d] requires access_key_id and secret_access_key", i)
+		}
+	}
+
+	if c.SFTPPort != "" {
+		if port, err := strconv.Atoi(c.SFTPPort); err != nil || port < 1 || port > 65535 || c.SFTPPort == c.Port || c.SFTPPort == c.S3Port {
+			invalid("sftp.port must be a valid TCP port other than server.port and s3.port, got %q", c.SFTPPort)
+		}
+		if c.SFTPHostKeyFile == "" {
+			invalid("sftp.host_key_file is required when sftp.port is set")
+		}
+		if len(c.SFTPUsers) == 0 {
+			invalid("sftp.users must not be empty when sftp.port is set")
+		}
+	}
+	if c.MaintenanceRetryAfter < time.Second {
+		invalid("maintenance.retry_after must be at least 1s")
+	}
+
+	if c.DebugAddr != "" {
+		_, port, err := net.SplitHostPort(c.DebugAddr)
+		if n, _ := strconv.Atoi(port); err != nil || n < 1 || n > 65535 || slices.Contains([]string{c.Port, c.S3Port, c.SFTPPort}, port) {
+			invalid("debug.addr must be a host:port on a port other than server.port, s3.port and sftp.port, got %q", c.DebugAddr)
+		}
+	}
+	sftpUsers := make(map[string]bool, len(c.SFTPUsers))
+	for i, user := range c.SFTPUsers {
+		if user.Name == "" || len(user.AuthorizedKeys) == 0 {
+			invalid("sftp.users[%d] requires name and authorized_keys", i)
+		}
+		if sftpUsers[user.Name] {
+			invalid("sftp.users[%d] duplicates name %q", i, user.Name)
+		}
+		sftpUsers[user.Name] = true
+		for j, key := range user.AuthorizedKeys {
+			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
+				invalid("sftp.users[%d].authorized_keys[%d] is not a valid public key: %v", i, j, err)
+			}
+		}
+	}
+
+	if c.JobWorkers < 1 || c.JobQueueSize < 1 {
+		invalid("jobs.workers and jobs.queue_size must be at least 1")
+	}
+
+	for i, rule := range c.KMSKeys {
+		if !strings.HasPrefix(rule.Key, "projects/") || !strings.Contains(rule.Key, "/cryptoKeys/") {
+			invalid("encryption.kms_keys[%d].key must be a projects/*/locations/*/keyRings/*/cryptoKeys/* name", i)
+		}
+	}
+	if c.EnvelopeKMSKey != "" && (!strings.HasPrefix(c.EnvelopeKMSKey, "projects/") || !strings.Contains(c.EnvelopeKMSKey, "/cryptoKeys/")) {
+		invalid("encryption.envelope_kms_key must be a projects/*/locations/*/keyRings/*/cryptoKeys/* name")
+	}
+	for i, key := range append([]string{c.EnvelopeMasterKey}, c.EnvelopePreviousMasterKeys...) {
+		if decoded, err := base64.StdEncoding.DecodeString(key); (key != "" || i > 0) && (err != nil || len(decoded) != 32) {
+			invalid("encryption.envelope_master_key and envelope_previous_master_keys must be base64 encoded 32 byte keys")
+			break
+		}
+	}
+	if len(c.EnvelopePrefixes) > 0 && c.EnvelopeKMSKey == "" && c.EnvelopeMasterKey == "" {
+		invalid("encryption.envelope_prefixes requires envelope_kms_key or envelope_master_key")
+	}
+	for i, rule := range c.StorageClassRules {
+		switch strings.ToUpper(rule.StorageClass) {
+		case "STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE":
+		default:
+			invalid("storage_class.rules[%d].storage_class must be STANDARD, NEARLINE, COLDLINE or ARCHIVE", i)
+		}
+	}
+	for i, path := range c.HotlinkPaths {
+		if !strings.HasPrefix(path, "/") {
+			invalid("hotlink.paths[%d] must start with /", i)
+		}
+	}
+	if !validRanges(c.IPAllow) || !validRanges(c.IPDeny) || !validRanges(c.InternalRanges) {
+		invalid("ip_filter.allow, ip_filter.deny and ip_filter.internal_ranges must be IP addresses or CIDR ranges")
+	}
+	if c.PrivateWrites && len(c.InternalRanges) == 0 {
+		invalid("ip_filter.private_writes requires ip_filter.internal_ranges")
+	}
+	if c.IAPEnabled && !strings.HasPrefix(c.IAPAudience, "/projects/") {
+		invalid("iap.audience must be like /projects/NUMBER/global/backendServices/ID")
+	}
+	for i, grant := range c.IAPGrants {
+		if len(grant.Members) == 0 || grant.Access != "read" && grant.Access != "write" {
+			invalid("iap.grants[%d] requires members and access read or write", i)
+		}
+	}
+	if c.PolicyObject != "" && len(c.PolicyRules) > 0 {
+		invalid("policy.object and policy.rules are exclusive")
+	}
+	if c.PolicyObject != "" && c.PolicyRefreshInterval < time.Second {
+		invalid("policy.refresh_interval must be at least 1s")
+	}
+	for i, rule := range c.PolicyRules {
+		if !validPolicyRule(rule) {
+			invalid("policy.rules[%d] requires principals, paths, actions read, write, delete, list or * and effect allow or deny", i)
+		}
+	}
+	if c.AuthorizerURL != "" {
+		if u, err := url.Parse(c.AuthorizerURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("authorizer.url must be an http(s) URL")
+		}
+		if c.AuthorizerTimeout <= 0 {
+			invalid("authorizer.timeout must be positive")
+		}
+		if c.AuthorizerCacheTTL < 0 {
+			invalid("authorizer.cache_ttl must not be negative")
+		}
+	}
+	if c.OIDCEnabled {
+		if c.OIDCClientID == "" || c.OIDCClientSecret == "" {
+			invalid("oidc.client_id and oidc.client_secret are required")
+		}
+		if u, err := url.Parse(c.OIDCRedirectURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "/auth/callback" {
+			invalid("oidc.redirect_url must be an http(s) URL ending in /auth/callback")
+		}
+		if key, err := base64.StdEncoding.DecodeString(c.OIDCSessionKey); err != nil || len(key) < 32 {
+			invalid("oidc.session_key must be at least 32 bytes, base64 encoded")
+		}
+		if c.OIDCSessionTTL < time.Minute {
+			invalid("oidc.session_ttl must be at least 1m")
+		}
+		if len(c.OIDCRoles) == 0 {
+			invalid("oidc.roles must grant at least one role, or nobody can log in")
+		}
+	}
+	for i, role := range c.OIDCRoles {
+		if len(role.Members) == 0 {
+			invalid("oidc.roles[%d] requires members", i)
+		}
+	}
+	if !validFrameOptions(c.FrameOptions) {
+		invalid("security_headers.frame_options must be DENY, SAMEORIGIN or empty, got %q", c.FrameOptions)
+	}
+	if c.HSTSMaxAge < 0 {
+		invalid("security_headers.hsts_max_age must not be negative")
+	}
+	for i, route := range c.SecurityHeaderRoutes {
+		if !strings.HasPrefix(route.Prefix, "/") || !validFrameOptions(route.FrameOptions) {
+			invalid("security_headers.routes[%d] requires a path prefix starting with / and frame_options DENY, SAMEORIGIN or empty", i)
+		}
+	}
+	for i, rule := range c.CacheControlRules {
+		if rule.Pattern == "" || rule.MaxAge < 0 || rule.NoStore && (rule.MaxAge != 0 || rule.Immutable) {
+			invalid("cache_control.rules[%d] needs a pattern and either a non-negative max_age or no_store", i)
+		}
+	}
+	for i, rule := range c.RedirectRules {
+		if rule.Pattern == "" || rule.MinBytes < 0 {
+			invalid("redirects.rules[%d] needs a pattern and a non-negative min_bytes", i)
+		}
+	}
+	if c.RedirectURLTTL <= 0 {
+		invalid("redirects.url_ttl must be positive")
+	}
+	for i, rule := range c.CompressionRules {
+		mediaType, subtype, _ := strings.Cut(rule.ContentType, "/")
+		if mediaType == "" || mediaType == "*" || subtype == "" || rule.Encoding != "" && rule.Encoding != "gzip" && rule.Encoding != "zstd" {
+			invalid("compression.rules[%d] needs a content type like text/* or application/json and an encoding of gzip, zstd or empty", i)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of the configuration with secrets masked, safe to print
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.GoogleCredentials = redact(r.GoogleCredentials)
+	r.ImageSigningKey = redact(r.ImageSigningKey)
+	r.EventsWebhookSecret = redact(r.EventsWebhookSecret)
+	r.AuthorizerSecret = redact(r.AuthorizerSecret)
+	r.OIDCClientSecret = redact(r.OIDCClientSecret)
+	r.OIDCSessionKey = redact(r.OIDCSessionKey)
+	r.DownloadURLSigningKey = redact(r.DownloadURLSigningKey)
+	r.CDNSigningKey = redact(r.CDNSigningKey)
+	r.UploadCallbackSecret = redact(r.UploadCallbackSecret)
+	r.ReplicationCredentials = redact(r.ReplicationCredentials)
+	r.ReplicationS3SecretAccessKey = redact(r.ReplicationS3SecretAccessKey)
+	r.EnvelopeMasterKey = redact(r.EnvelopeMasterKey)
+	r.EnvelopePreviousMasterKeys = make([]string, len(c.EnvelopePreviousMasterKeys))
+	for i, key := range c.EnvelopePreviousMasterKeys {
+		r.EnvelopePreviousMasterKeys[i] = redact(key)
+	}
+	if u, err := url.Parse(r.RedisURL); err == nil {
+		r.RedisURL = u.Redacted()
+	} else {
+		r.RedisURL = redact(r.RedisURL)
+	}
+	// Postgres DSNs carry a password, either in a URL or as key=value pairs
+	if r.CatalogDriver == "postgres" {
+		if u, err := url.Parse(r.CatalogDSN); err == nil && u.Scheme != "" {
+			r.CatalogDSN = u.Redacted()
+		} else {
+			r.CatalogDSN = redact(r.CatalogDSN)
+		}
+	}
+
+	r.S3AccessKeys = make([]S3AccessKey, len(c.S3AccessKeys))
+	for i, key := range c.S3AccessKeys {
+		r.S3AccessKeys[i] = S3AccessKey{AccessKeyID: key.AccessKeyID, SecretAccessKey: redact(key.SecretAccessKey)}
+	}
+
+	r.TransferBuckets = make([]TransferBucket, len(c.TransferBuckets))
+	for i, bucket := range c.TransferBuckets {
+		bucket.Credentials = redact(bucket.Credentials)
+		r.TransferBuckets[i] = bucket
+	}
+
+	r.APIKeys = make([]APIKey, len(c.APIKeys))
+	for i, key := range c.APIKeys {
+		r.APIKeys[i] = APIKey{Name: key.Name, Key: redact(key.Key), Scopes: key.Scopes, Quota: key.Quota}
+	}
+	return &r
+}
+
+// YAML renders the configuration in config file format
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "REDACTED"
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt64(key string, defaultValue int64) int64 {
+	if value, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvList reads a comma separated list
+func getEnvList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
 		return value
 	}
 	return defaultValue
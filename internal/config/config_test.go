@@ -0,0 +1,212 @@
+package config
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoad_FileAndEnvOverride(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+server:
+  port: "9090"
+auth:
+  api_keys:
+    - name: ci
+      key: secret-key
+backends:
+  gcs:
+    project_id: file-project
+    bucket: file-bucket
+jobs:
+  retention: 1h
+`)
+	t.Setenv("GCS_BUCKET_NAME", "env-bucket")
+	t.Setenv("CACHE_CONTROL_RULES", "assets/**=8760h immutable,tmp/*=no-store")
+	t.Setenv("COMPRESSION_RULES", "text/event-stream=,text/*=gzip,application/json=zstd")
+	t.Setenv("REDIRECT_RULES", "videos/**=0,**/*.iso=104857600")
+	t.Setenv("JOB_SCHEDULES", "gc=0 3 * * 1,3,5; expire=@every 30s")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if cfg.Port != "9090" {
+		t.Errorf("Expected port from file, got %s", cfg.Port)
+	}
+	if cfg.GCPProjectID != "file-project" {
+		t.Errorf("Expected project from file, got %s", cfg.GCPProjectID)
+	}
+	if cfg.GCSBucketName != "env-bucket" {
+		t.Errorf("Expected env to override bucket, got %s", cfg.GCSBucketName)
+	}
+	if cfg.JobRetention != time.Hour {
+		t.Errorf("Expected job retention from file, got %s", cfg.JobRetention)
+	}
+	if cfg.JobWorkers != 4 {
+		t.Errorf("Expected default job workers, got %d", cfg.JobWorkers)
+	}
+	if len(cfg.APIKeys) != 1 || cfg.APIKeys[0].Name != "ci" {
+		t.Errorf("Expected API key from file, got %+v", cfg.APIKeys)
+	}
+	wantRules := []CacheControlRule{{Pattern: "assets/**", MaxAge: 8760 * time.Hour, Immutable: true}, {Pattern: "tmp/*", NoStore: true}}
+	if !slices.Equal(cfg.CacheControlRules, wantRules) {
+		t.Errorf("Expected cache control rules %+v from env, got %+v", wantRules, cfg.CacheControlRules)
+	}
+	wantRedirects := []RedirectRule{{Pattern: "videos/**"}, {Pattern: "**/*.iso", MinBytes: 100 << 20}}
+	if !slices.Equal(cfg.RedirectRules, wantRedirects) {
+		t.Errorf("Expected redirect rules %+v from env, got %+v", wantRedirects, cfg.RedirectRules)
+	}
+	wantCompression := []CompressionRule{{ContentType: "text/event-stream"}, {ContentType: "text/*", Encoding: "gzip"}, {ContentType: "application/json", Encoding: "zstd"}}
+	if !slices.Equal(cfg.CompressionRules, wantCompression) {
+		t.Errorf("Expected compression rules %+v from env, got %+v", wantCompression, cfg.CompressionRules)
+	}
+	wantSchedules := map[string]string{"gc": "0 3 * * 1,3,5", "expire": "@every 30s"}
+	if !maps.Equal(cfg.JobSchedules, wantSchedules) {
+		t.Errorf("Expected job schedules %v from env, got %v", wantSchedules, cfg.JobSchedules)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+}
+
+func TestLoad_JSONFile(t *testing.T) {
+	path := writeConfigFile(t, "config.json", `{"backends": {"gcs": {"project_id": "p", "bucket": "b"}}, "caching": {"readiness_cache_ttl": "30s"}}`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.GCSBucketName != "b" || cfg.ReadinessCacheTTL != 30*time.Second {
+		t.Errorf("Unexpected config: bucket=%s ttl=%s", cfg.GCSBucketName, cfg.ReadinessCacheTTL)
+	}
+}
+
+func TestLoad_UnknownField(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "server:\n  prot: \"8080\"\n")
+
+	if _, err := Load(path); err == nil {
+		t.Error("Expected error for unknown field")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Port = "http"
+	cfg.EventsWebhookURL = "ftp://example.com"
+	cfg.APIKeys = []APIKey{{Name: "a", Key: "1"}, {Name: "a", Key: "2", ServiceAccount: "tenant-a"}}
+	cfg.TLSCertFile = "server.crt"
+	cfg.S3Port = cfg.Port
+	cfg.SFTPPort = "2022"
+	cfg.SFTPUsers = []SFTPUser{{Name: "acme", AuthorizedKeys: []string{"ssh-ed25519 not-a-key"}}}
+	cfg.DebugAddr = "6060"
+	cfg.GCSReadConcurrency = 0
+	cfg.StorageRoutes = []StorageRoute{{Prefix: "tmp/", Target: "s3://bucket"}}
+	cfg.CatalogDriver = "mysql"
+	cfg.GoogleCredentialsMode = "json"
+	cfg.LeasesEnabled = true
+	cfg.LeasesMaxTTL = time.Second
+	cfg.SharesEnabled = true
+	cfg.SharesDefaultTTL = 0
+	cfg.MountsEnabled = true
+	cfg.MountsRefreshInterval = 0
+	cfg.MaintenanceRetryAfter = 0
+	cfg.StatsEnabled = true
+	cfg.StatsFlushInterval = 0
+	cfg.UsageWait = -time.Second
+	cfg.ExpiryIndexPrefix = "_expiry"
+	cfg.ExpiryInterval = 0
+	cfg.JobSchedules = map[string]string{"gc": "0 3 * *", "bulk-read": "@daily"}
+	cfg.JobElection = "redis"
+	cfg.JobElectionTTL = time.Second
+	cfg.Admission.MaxUploads = -1
+	cfg.IdempotencyEnabled = true
+	cfg.IdempotencyTTL = time.Second
+	cfg.LogsEnabled = true
+	cfg.LogsMaxRecordBytes = 0
+	cfg.SiteEnabled = true
+	cfg.CDNSigningKey = "not base64"
+	cfg.HotlinkPaths = []string{"api/v1/storage/stream/"}
+	cfg.TrustedProxies = []string{"35.191.0.0/33"}
+	cfg.ShutdownUploadTimeout = time.Second
+	cfg.IPDeny = []string{"not an address"}
+	cfg.CacheControlRules = []CacheControlRule{{Pattern: "*.html", MaxAge: time.Hour, NoStore: true}}
+	cfg.CompressionRules = []CompressionRule{{ContentType: "text/*", Encoding: "br"}}
+	cfg.RedirectRules = []RedirectRule{{Pattern: "videos/**", MinBytes: -1}}
+	cfg.EnvelopeMasterKey = "c2hvcnQ="
+	cfg.FrameOptions = "ALLOW-FROM https://example.com"
+	cfg.SignedRequests = true
+	cfg.IAPEnabled = true
+	cfg.IAPGrants = []IAPGrant{{Members: []string{"alice@example.com"}, Access: "admin"}}
+	cfg.PolicyRules = []PolicyRule{{Principals: []string{"*"}, Actions: []string{"read"}, Paths: []string{"public/**"}, Effect: "permit"}}
+	cfg.PolicyObject = "_policy.yaml"
+	cfg.AuthorizerURL = "authz.internal:8080"
+	cfg.AuthorizerTimeout = 0
+	cfg.OIDCEnabled = true
+	cfg.OIDCRedirectURL = "https://storage.example.com/callback"
+	cfg.OIDCRoles = []OIDCRole{{Scopes: []string{"admin"}}}
+	cfg.UploadProgressTTL = 0
+	cfg.SignedRequestMaxSkew = 0
+	cfg.SecurityHeaderRoutes = []SecurityHeaderRoute{{Prefix: "api/"}}
+	cfg.ReplicationTarget = "s3://backups/proxy"
+	cfg.ReplicationVerifySample = -1
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	if !errors.Is(err, ErrMissingProjectID) || !errors.Is(err, ErrMissingBucketName) || !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected all violations to be reported, got %v", err)
+	}
+	for _, field := range []string{"server.port", "webhook_url", "duplicates name", "tls.key_file", "s3.port", "s3.access_keys", "sftp.host_key_file", "authorized_keys[0]", "maintenance.retry_after", "debug.addr", "catalog.driver", "service_account", "credentials_mode", "backends.gcs.read_concurrency", "backends.routes[0]", "leases.default_ttl", "shares.default_ttl", "mounts.refresh_interval", "stats.flush_interval", "usage.wait", "expiry.interval", "jobs.schedules.gc", "jobs.schedules.bulk-read", "jobs.election", "jobs.election_ttl", "limits.admission", "idempotency.ttl", "logs.prefix", "site.prefix", "cache_control.rules[0]", "redirects.rules[0]", "compression.rules[0]", "envelope_master_key", "auth.cdn_signing_key", "hotlink.paths[0]", "server.trusted_proxies", "server.shutdown_timeout", "ip_filter.allow", "security_headers.frame_options", "security_headers.routes[0]", "auth.signed_request_max_skew", "iap.audience", "iap.grants[0]", "policy.rules[0]", "policy.object", "authorizer.url", "authorizer.timeout", "oidc.client_id", "oidc.redirect_url", "oidc.session_key", "oidc.roles[0]", "uploads.progress_ttl", "replication.target", "replication.s3_access_key_id", "replication.verify_sample"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("Expected error to mention %q, got %v", field, err)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.GoogleCredentials = "base64-credentials"
+	cfg.ImageSigningKey = "signing-key"
+	cfg.APIKeys = []APIKey{{Name: "ci", Key: "secret-key"}}
+	cfg.S3AccessKeys = []S3AccessKey{{AccessKeyID: "AKID", SecretAccessKey: "s3-secret"}}
+	cfg.TransferBuckets = []TransferBucket{{Name: "archive", Bucket: "archive-bucket", Credentials: "archive-credentials"}}
+	cfg.CatalogDriver = "postgres"
+	cfg.CatalogDSN = "postgres://proxy:catalog-password@db/catalog"
+	cfg.CDNSigningKey = "cdn-signing-key"
+	cfg.ReplicationS3SecretAccessKey = "replication-secret"
+	cfg.EnvelopeMasterKey = "envelope-master-key"
+	cfg.EnvelopePreviousMasterKeys = []string{"previous-master-key"}
+	cfg.AuthorizerSecret = "authorizer-secret"
+	cfg.OIDCClientSecret = "oidc-client-secret"
+	cfg.OIDCSessionKey = "oidc-session-key"
+
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, secret := range []string{"base64-credentials", "signing-key", "secret-key", "s3-secret", "archive-credentials", "catalog-password", "cdn-signing-key", "replication-secret", "envelope-master-key", "previous-master-key", "authorizer-secret", "oidc-client-secret", "oidc-session-key"} {
+		if strings.Contains(string(out), secret) {
+			t.Errorf("Expected %q to be redacted", secret)
+		}
+	}
+	if cfg.APIKeys[0].Key != "secret-key" || cfg.TransferBuckets[0].Credentials != "archive-credentials" {
+		t.Error("Redacted must not modify the original config")
+	}
+}
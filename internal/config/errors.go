@@ -3,6 +3,7 @@ package config
 import "errors"
 
 var (
-	ErrMissingProjectID = errors.New("GCP_PROJECT_ID is required")
+	ErrMissingProjectID  = errors.New("GCP_PROJECT_ID is required")
 	ErrMissingBucketName = errors.New("GCS_BUCKET_NAME is required")
-)
\ No newline at end of file
+	ErrInvalidConfig     = errors.New("invalid configuration")
+)
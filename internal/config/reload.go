@@ -0,0 +1,158 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ApplyFunc applies tunable settings of a reloaded configuration to running components
+type ApplyFunc func(cfg *Config)
+
+// Reloader reloads the configuration on SIGHUP and, optionally, when the config file
+// changes. Only tunable settings (auth keys, rate limits, admission limits, allowed
+// content types, log level, hotlink protection, IP filtering) take effect; other settings
+// such as port and bucket stay fixed until restart.
+type Reloader struct {
+	path  string
+	apply ApplyFunc
+
+	mu      sync.Mutex
+	current *Config
+	modTime time.Time
+}
+
+// NewReloader creates a reloader for the config file at path (or $CONFIG_FILE)
+func NewReloader(path string, current *Config, apply ApplyFunc) *Reloader {
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	r := &Reloader{
+		path:    path,
+		apply:   apply,
+		current: current,
+	}
+	r.modTime = r.fileModTime()
+	return r
+}
+
+// Current returns the active configuration
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload loads and validates the configuration and applies its tunable settings.
+// An invalid configuration is rejected and the active one is kept.
+func (r *Reloader) Reload() error {
+	next, err := Load(r.path)
+	if err != nil {
+		return err
+	}
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	effective := *r.current
+	effective.APIKeys = next.APIKeys
+	effective.RateLimit = next.RateLimit
+	effective.RateLimitBurst = next.RateLimitBurst
+	effective.Admission = next.Admission
+	effective.AllowedContentTypes = next.AllowedContentTypes
+	effective.TrustContentTypes = next.TrustContentTypes
+	effective.LogLevel = next.LogLevel
+	effective.HotlinkConfig = next.HotlinkConfig
+	effective.IPFilterConfig = next.IPFilterConfig
+	effective.IAPGrants = next.IAPGrants
+
+	if ignored := changedFields(&effective, next); len(ignored) > 0 {
+		log.Printf("Configuration reload ignores settings that require a restart: %s", strings.Join(ignored, ", "))
+	}
+
+	r.current = &effective
+	r.apply(&effective)
+	return nil
+}
+
+// Run reloads on SIGHUP, and on file changes when interval is positive, until ctx is done
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 && r.path != "" {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload("SIGHUP")
+		case <-tick:
+			if modTime := r.fileModTime(); !modTime.Equal(r.modTime) {
+				r.modTime = modTime
+				r.reload("config file change")
+			}
+		}
+	}
+}
+
+func (r *Reloader) reload(trigger string) {
+	if err := r.Reload(); err != nil {
+		log.Printf("Configuration reload on %s failed, keeping current settings: %v", trigger, err)
+		return
+	}
+	log.Printf("Configuration reloaded on %s", trigger)
+}
+
+func (r *Reloader) fileModTime() time.Time {
+	if r.path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// changedFields lists the config keys (e.g. "server.port") whose values differ
+func changedFields(a, b *Config) []string {
+	var changed []string
+	var walk func(prefix string, va, vb reflect.Value)
+	walk = func(prefix string, va, vb reflect.Value) {
+		t := va.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			fa, fb := va.Field(i), vb.Field(i)
+			if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+				walk(name, fa, fb)
+				continue
+			}
+			if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+				changed = append(changed, name)
+			}
+		}
+	}
+	walk("", reflect.ValueOf(*a), reflect.ValueOf(*b))
+	return changed
+}
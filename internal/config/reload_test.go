@@ -0,0 +1,84 @@
+package config
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReloader_AppliesOnlyTunables(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", `
+server:
+  port: "8080"
+backends:
+  gcs:
+    project_id: p
+    bucket: b
+limits:
+  rate_limit: 5
+`)
+	initial, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var applied *Config
+	reloader := NewReloader(path, initial, func(cfg *Config) { applied = cfg })
+
+	err = os.WriteFile(path, []byte(`
+server:
+  port: "9999"
+  log_level: debug
+backends:
+  gcs:
+    project_id: p
+    bucket: other-bucket
+limits:
+  rate_limit: 50
+  allowed_content_types: ["image/*"]
+`), 0o600)
+	if err != nil {
+		t.Fatalf("Failed to update config file: %v", err)
+	}
+
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if applied == nil {
+		t.Fatal("Expected apply to be called")
+	}
+	if applied.RateLimit != 50 || applied.LogLevel != "debug" || len(applied.AllowedContentTypes) != 1 {
+		t.Errorf("Expected tunables to be reloaded, got %+v", applied.LimitsConfig)
+	}
+	if applied.Port != "8080" || applied.GCSBucketName != "b" {
+		t.Errorf("Expected immutable settings to stay fixed, got port=%s bucket=%s", applied.Port, applied.GCSBucketName)
+	}
+}
+
+func TestReloader_RejectsInvalidConfig(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "backends:\n  gcs:\n    project_id: p\n    bucket: b\n")
+	initial, _ := Load(path)
+
+	reloader := NewReloader(path, initial, func(cfg *Config) {
+		t.Error("Apply must not be called for an invalid config")
+	})
+
+	os.WriteFile(path, []byte("backends:\n  gcs:\n    project_id: p\n    bucket: b\nlimits:\n  rate_limit: -1\n"), 0o600)
+	if err := reloader.Reload(); err == nil {
+		t.Error("Expected reload of invalid config to fail")
+	}
+	if reloader.Current() != initial {
+		t.Error("Expected current config to be kept")
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	a, b := Default(), Default()
+	b.Port = "9090"
+	b.GCSBucketName = "other"
+
+	changed := changedFields(a, b)
+	if len(changed) != 2 || changed[0] != "server.port" || changed[1] != "backends.gcs.bucket" {
+		t.Errorf("Unexpected changed fields: %v", changed)
+	}
+}
@@ -0,0 +1,51 @@
+package diagnostics
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+var publish sync.Once
+
+// Handler serves the runtime profiles of net/http/pprof under /debug/pprof/
+// and the expvar variables at /debug/vars, which add goroutines, GC
+// statistics and build information to the command line and memory
+// statistics expvar always reports. Profiles expose the process' internals,
+// so the handler must only be reachable by admins.
+func Handler() http.Handler {
+	publish.Do(func() {
+		expvar.Publish("goroutines", expvar.Func(func() any {
+			return runtime.NumGoroutine()
+		}))
+		expvar.Publish("gc", expvar.Func(gcStats))
+		expvar.Publish("build", expvar.Func(func() any {
+			info, _ := debug.ReadBuildInfo()
+			return info
+		}))
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	return mux
+}
+
+// gcStats summarizes the collections so far; memstats holds the heap sizes
+func gcStats() any {
+	var stats debug.GCStats
+	debug.ReadGCStats(&stats)
+	return map[string]any{
+		"num_gc":      stats.NumGC,
+		"last_gc":     stats.LastGC,
+		"pause_total": stats.PauseTotal.String(),
+	}
+}
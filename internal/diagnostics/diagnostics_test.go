@@ -0,0 +1,51 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		expected int
+	}{
+		{name: "profile index", method: http.MethodGet, path: "/debug/pprof/", expected: http.StatusOK},
+		{name: "heap profile", method: http.MethodGet, path: "/debug/pprof/heap?debug=1", expected: http.StatusOK},
+		{name: "goroutine profile", method: http.MethodGet, path: "/debug/pprof/goroutine?debug=1", expected: http.StatusOK},
+		{name: "unknown profile", method: http.MethodGet, path: "/debug/pprof/missing", expected: http.StatusNotFound},
+		{name: "vars", method: http.MethodGet, path: "/debug/vars", expected: http.StatusOK},
+		{name: "vars are read only", method: http.MethodPost, path: "/debug/vars", expected: http.StatusMethodNotAllowed},
+		{name: "outside debug", method: http.MethodGet, path: "/metrics", expected: http.StatusNotFound},
+	}
+
+	h := Handler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_Vars(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, name := range []string{"goroutines", "gc", "build", "memstats", "cmdline"} {
+		if _, ok := vars[name]; !ok {
+			t.Errorf("Expected %q in the vars, got %v", name, vars)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package drain
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/middleware"
+)
+
+// Transfer is a request being served
+type Transfer struct {
+	Method    string
+	Path      string
+	RequestID string
+	// Write is set for requests that make changes, like uploads
+	Write   bool
+	Started time.Time
+
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+// Tracker keeps track of the requests in flight, so a shutdown can wait for
+// them and refuse new ones
+type Tracker struct {
+	writes func(*http.Request) bool
+	live   []string
+
+	mu        sync.Mutex
+	draining  bool
+	transfers map[*Transfer]struct{}
+	// changed is closed and replaced whenever a transfer finishes
+	changed chan struct{}
+}
+
+// New creates a tracker. writes reports whether a request makes changes;
+// requests to the live paths, like liveness probes, are served while
+// draining so the process isn't restarted before it is done.
+func New(writes func(*http.Request) bool, live ...string) *Tracker {
+	return &Tracker{
+		writes:    writes,
+		live:      live,
+		transfers: make(map[*Transfer]struct{}),
+		changed:   make(chan struct{}),
+	}
+}
+
+// Middleware tracks requests, and answers new ones with 503 Service
+// Unavailable while draining. It must run after middleware.RequestID.
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(t.live, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		transfer := &Transfer{
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			RequestID: middleware.RequestIDFrom(ctx),
+			Write:     t.writes(r),
+			Started:   time.Now(),
+			cancel:    cancel,
+		}
+		if !t.start(transfer) {
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Service Unavailable: the server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		defer t.finish(transfer)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (t *Tracker) start(transfer *Transfer) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.transfers[transfer] = struct{}{}
+	return true
+}
+
+func (t *Tracker) finish(transfer *Transfer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.transfers, transfer)
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// InFlight returns the number of requests being served
+func (t *Tracker) InFlight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.transfers)
+}
+
+// Drain refuses new requests and waits for the ones in flight. Reads get
+// timeout to finish, while writes, like multi-minute uploads, get up to
+// maxTimeout. Requests still running then are canceled and returned.
+func (t *Tracker) Drain(timeout, maxTimeout time.Duration) []Transfer {
+	t.mu.Lock()
+	t.draining = true
+	t.mu.Unlock()
+
+	start := time.Now()
+	if t.wait(timeout, func(transfer *Transfer) bool { return true }) {
+		return nil
+	}
+	cutOff := t.cancel(func(transfer *Transfer) bool { return !transfer.Write })
+	if !t.wait(maxTimeout-time.Since(start), func(transfer *Transfer) bool { return transfer.Write }) {
+		cutOff = append(cutOff, t.cancel(func(transfer *Transfer) bool { return true })...)
+	}
+	return cutOff
+}
+
+// wait reports whether the matching transfers finished within timeout
+func (t *Tracker) wait(timeout time.Duration, match func(*Transfer) bool) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		t.mu.Lock()
+		pending := false
+		for transfer := range t.transfers {
+			pending = pending || match(transfer)
+		}
+		changed := t.changed
+		t.mu.Unlock()
+		if !pending {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// cancel cancels the matching transfers and returns them
+func (t *Tracker) cancel(match func(*Transfer) bool) []Transfer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var canceled []Transfer
+	for transfer := range t.transfers {
+		if match(transfer) && !transfer.canceled {
+			transfer.cancel()
+			transfer.canceled = true
+			canceled = append(canceled, *transfer)
+		}
+	}
+	return canceled
+}
@@ -0,0 +1,86 @@
+package drain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTracker_Drain(t *testing.T) {
+	writes := func(r *http.Request) bool { return r.Method != http.MethodGet }
+
+	tests := []struct {
+		name     string
+		requests []string
+		expected []string
+	}{
+		{name: "idle"},
+		{name: "read finishes", requests: []string{http.MethodGet + " /fast"}},
+		{name: "slow read is cut off", requests: []string{http.MethodGet + " /slow"}, expected: []string{http.MethodGet}},
+		{name: "slow upload gets longer", requests: []string{http.MethodPut + " /slow"}},
+		{name: "stuck upload is cut off", requests: []string{http.MethodPut + " /stuck"}, expected: []string{http.MethodPut}},
+		{name: "read cut off while upload finishes", requests: []string{http.MethodGet + " /stuck", http.MethodPut + " /slow"}, expected: []string{http.MethodGet}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := New(writes, "/healthz")
+			h := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				delay := map[string]time.Duration{"/fast": 10 * time.Millisecond, "/slow": 100 * time.Millisecond, "/stuck": time.Second}[r.URL.Path]
+				select {
+				case <-time.After(delay):
+				case <-r.Context().Done():
+				}
+			}))
+
+			var wg sync.WaitGroup
+			for _, request := range tt.requests {
+				method, path, _ := strings.Cut(request, " ")
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+				}()
+			}
+			for tracker.InFlight() < len(tt.requests) {
+				time.Sleep(time.Millisecond)
+			}
+
+			cutOff := tracker.Drain(50*time.Millisecond, 300*time.Millisecond)
+			wg.Wait()
+			if len(cutOff) != len(tt.expected) {
+				t.Fatalf("Expected %d transfers cut off, got %+v", len(tt.expected), cutOff)
+			}
+			for i, transfer := range cutOff {
+				if transfer.Method != tt.expected[i] {
+					t.Errorf("Expected %s to be cut off, got %s", tt.expected[i], transfer.Method)
+				}
+			}
+		})
+	}
+}
+
+func TestTracker_RefusesWhileDraining(t *testing.T) {
+	tracker := New(func(r *http.Request) bool { return false }, "/healthz")
+	h := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tracker.Drain(time.Second, time.Second)
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{path: "/api/v1/storage/files/a.txt", expected: http.StatusServiceUnavailable},
+		{path: "/healthz", expected: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.expected {
+			t.Errorf("Expected status %d for %s, got %d", tt.expected, tt.path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package dto
+
+import (
+	"gcp-proxy-mity/internal/jobs"
+	"gcp-proxy-mity/internal/mount"
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Lifecycle actions
+const (
+	actionDelete          = "Delete"
+	actionSetStorageClass = "SetStorageClass"
+)
+
+// CreateBucket creates a bucket
+type CreateBucket struct {
+	Name string `json:"name"`
+	storage.BucketOptions
+}
+
+func (b *CreateBucket) Validate() error {
+	var v validator
+	v.required("name", b.Name)
+	return v.err()
+}
+
+// Mount stores the files under a prefix in another bucket or a directory
+type Mount struct {
+	Prefix string `json:"prefix"`
+	// Target is gs://bucket or file:///path
+	Target     string `json:"target"`
+	QuotaBytes int64  `json:"quota_bytes,omitempty"`
+	Cache      bool   `json:"cache,omitempty"`
+	Scope      string `json:"scope,omitempty"`
+	ReadOnly   bool   `json:"read_only,omitempty"`
+}
+
+func (m *Mount) Validate() error {
+	var v validator
+	v.required("prefix", m.Prefix)
+	v.required("target", m.Target)
+	if m.QuotaBytes < 0 {
+		v.add("quota_bytes", "must not be negative")
+	}
+	return v.err()
+}
+
+// Mount returns the mount to create
+func (m *Mount) Mount() mount.Mount {
+	return mount.Mount{
+		Prefix:     m.Prefix,
+		Target:     m.Target,
+		QuotaBytes: m.QuotaBytes,
+		Cache:      m.Cache,
+		Scope:      m.Scope,
+		ReadOnly:   m.ReadOnly,
+	}
+}
+
+// Maintenance turns maintenance mode on or off
+type Maintenance struct {
+	Enabled bool `json:"enabled"`
+	// Message replaces the configured message while maintenance mode is on
+	Message string `json:"message,omitempty"`
+}
+
+func (m *Maintenance) Validate() error {
+	var v validator
+	if !m.Enabled && m.Message != "" {
+		v.add("message", "is only valid with enabled")
+	}
+	return v.err()
+}
+
+// PolicyExplain asks whether the access policy allows a request
+type PolicyExplain struct {
+	// Principal is an API key name or identity, "" for anonymous requests
+	Principal string   `json:"principal,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	// Action is read, write, delete or list
+	Action string `json:"action"`
+	Path   string `json:"path"`
+}
+
+func (p *PolicyExplain) Validate() error {
+	var v validator
+	switch p.Action {
+	case policy.ActionRead, policy.ActionWrite, policy.ActionDelete, policy.ActionList:
+	default:
+		v.add("action", "must be read, write, delete or list")
+	}
+	return v.err()
+}
+
+// Request returns the request to evaluate
+func (p *PolicyExplain) Request() policy.Request {
+	return policy.Request{Principal: p.Principal, Scopes: p.Scopes, Action: p.Action, Path: p.Path}
+}
+
+// Lifecycle is the body of lifecycle requests and responses
+type Lifecycle struct {
+	Rules []storage.LifecycleRule `json:"rules"`
+}
+
+func (l *Lifecycle) Validate() error {
+	var v validator
+	for i, rule := range l.Rules {
+		field := index("rules", i)
+		switch rule.Action {
+		case actionDelete, actionSetStorageClass:
+		default:
+			v.add(field+".action", "must be %s or %s", actionDelete, actionSetStorageClass)
+		}
+		if (rule.Action == actionSetStorageClass) != (rule.StorageClass != "") {
+			v.add(field+".storage_class", "is required for %s and only valid with it", actionSetStorageClass)
+		}
+		if rule.AgeDays < 0 {
+			v.add(field+".age_days", "must not be negative")
+		}
+		if rule.DaysSinceCustomTime < 0 {
+			v.add(field+".days_since_custom_time", "must not be negative")
+		}
+	}
+	return v.err()
+}
+
+// Job queues a bulk operation
+type Job struct {
+	jobs.Spec
+}
+
+func (j *Job) Validate() error {
+	var v validator
+	v.required("type", j.Type)
+	for i, p := range j.Paths {
+		v.path(index("paths", i), p)
+	}
+	return v.err()
+}
+
+// Transfer queues a job copying objects between configured buckets
+type Transfer struct {
+	SourceBucket      string   `json:"source_bucket"`
+	DestinationBucket string   `json:"destination_bucket"`
+	Prefix            string   `json:"prefix,omitempty"`
+	Paths             []string `json:"paths,omitempty"`
+	Destination       string   `json:"destination,omitempty"`
+}
+
+func (t *Transfer) Validate() error {
+	var v validator
+	v.required("source_bucket", t.SourceBucket)
+	v.required("destination_bucket", t.DestinationBucket)
+	for i, p := range t.Paths {
+		v.path(index("paths", i), p)
+	}
+	return v.err()
+}
+
+// Spec returns the transfer job
+func (t *Transfer) Spec() jobs.Spec {
+	return jobs.Spec{
+		SourceBucket:      t.SourceBucket,
+		DestinationBucket: t.DestinationBucket,
+		Prefix:            t.Prefix,
+		Paths:             t.Paths,
+		Destination:       t.Destination,
+	}
+}
@@ -0,0 +1,148 @@
+// Package dto defines the request bodies of the API and the constraints on
+// their fields, independent of the transport they arrive over
+package dto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxPathBytes is the longest object name GCS accepts
+const maxPathBytes = 1024
+
+// Violation is a constraint a request field doesn't meet
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every constraint a request violates
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Field + " " + v.Message
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+// Request is a request body that checks its fields
+type Request interface {
+	Validate() error
+}
+
+// Invalid returns the error of a request violating one constraint
+func Invalid(field, message string) error {
+	return &ValidationError{Violations: []Violation{{Field: field, Message: message}}}
+}
+
+// Decode reads a JSON body into request and validates it. Fields tagged "-"
+// that constrain the request, like limits from the configuration, are set
+// before decoding.
+func Decode(r io.Reader, request Request) error {
+	if err := json.NewDecoder(r).Decode(request); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return Invalid(typeErr.Field, "must be a JSON "+jsonType(typeErr.Type.Kind()))
+		}
+		return Invalid("body", "must be a JSON object: "+err.Error())
+	}
+	return request.Validate()
+}
+
+// jsonType names the JSON type a Go kind is decoded from
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// CheckPath returns what's wrong with an object path, or "" if it is valid.
+// Paths are UTF-8 of at most 1024 bytes without control characters, and
+// without . or .. segments, which would escape the prefixes access is scoped to.
+func CheckPath(path string) string {
+	switch {
+	case path == "":
+		return "is required"
+	case !utf8.ValidString(path):
+		return "must be valid UTF-8"
+	case len(path) > maxPathBytes:
+		return fmt.Sprintf("must be at most %d bytes", maxPathBytes)
+	case strings.ContainsFunc(path, func(r rune) bool { return r < 0x20 || r == 0x7f }):
+		return "must not contain control characters"
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." {
+			return "must not contain . or .. segments"
+		}
+	}
+	return ""
+}
+
+// validator collects the violations of a request
+type validator struct {
+	violations []Violation
+}
+
+func (v *validator) add(field, format string, args ...any) {
+	v.violations = append(v.violations, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) required(field, value string) {
+	if value == "" {
+		v.add(field, "is required")
+	}
+}
+
+func (v *validator) path(field, value string) {
+	if message := CheckPath(value); message != "" {
+		v.add(field, "%s", message)
+	}
+}
+
+// duration checks an optional duration like "72h" and returns it, or 0
+func (v *validator) duration(field, value string, max time.Duration) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	switch {
+	case err != nil || d <= 0:
+		v.add(field, "must be a positive duration like 72h")
+	case max > 0 && d > max:
+		v.add(field, "must be at most %s", max)
+	}
+	return d
+}
+
+func (v *validator) err() error {
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return &ValidationError{Violations: v.violations}
+}
+
+// index names the element i of a list field
+func index(field string, i int) string {
+	return field + "[" + strconv.Itoa(i) + "]"
+}
@@ -0,0 +1,100 @@
+package dto
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{path: "videos/intro.mp4", valid: true},
+		{path: "docs/", valid: true},
+		{path: "/videos/intro.mp4", valid: true},
+		{path: "a..b/c", valid: true},
+		{path: "", valid: false},
+		{path: "../secret", valid: false},
+		{path: "videos/./intro.mp4", valid: false},
+		{path: "videos/..", valid: false},
+		{path: "line\nbreak", valid: false},
+		{path: "bad\xffutf8", valid: false},
+		{path: strings.Repeat("a", 1025), valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if message := CheckPath(tt.path); (message == "") != tt.valid {
+				t.Errorf("Expected valid %v, got %q", tt.valid, message)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		request Request
+		fields  []string
+	}{
+		{name: "valid batch read", body: `{"file_paths": ["a.jpg"], "files": [{"path": "b.mp4", "offset": -100}]}`, request: &ReadFiles{}},
+		{name: "empty batch read", body: `{}`, request: &ReadFiles{}, fields: []string{"file_paths"}},
+		{name: "every violation is listed", body: `{"file_paths": ["", "../a"], "files": [{"path": "b", "length": -1}]}`, request: &ReadFiles{Encoding: "hex"},
+			fields: []string{"file_paths[0]", "file_paths[1]", "files[0].length", "encoding"}},
+		{name: "url encoding of ranges", body: `{"files": [{"path": "b"}]}`, request: &ReadFiles{Encoding: EncodingURL}, fields: []string{"encoding"}},
+		{name: "malformed JSON", body: `{"file_paths": [`, request: &ReadFiles{}, fields: []string{"body"}},
+		{name: "wrong JSON type", body: `{"max_downloads": "5", "path": "a"}`, request: &Share{}, fields: []string{"max_downloads"}},
+		{name: "share with a bad ttl", body: `{"path": "a", "ttl": "soon", "max_downloads": -1}`, request: &Share{}, fields: []string{"ttl", "max_downloads"}},
+		{name: "CDN token over the max ttl", body: `{"prefix": "/a/", "ttl": "2h"}`, request: &CDNToken{MaxTTL: time.Hour}, fields: []string{"ttl"}},
+		{name: "compose without chunks", body: `{"chunks": []}`, request: &Compose{}, fields: []string{"chunks"}},
+		{name: "copy without a destination", body: `{}`, request: &Copy{}, fields: []string{"destination"}},
+		{name: "fetch of a non-HTTP URL", body: `{"files": [{"url": "file:///etc/passwd", "path": "a"}]}`, request: &FetchFiles{}, fields: []string{"files[0].url"}},
+		{name: "access without public", body: `{}`, request: &Access{}, fields: []string{"public"}},
+		{name: "removing all tags", body: `{"tags": {}}`, request: &Tags{}},
+		{name: "holds without a hold", body: `{}`, request: &Holds{}, fields: []string{"body"}},
+		{name: "upload with an unknown step", body: `{"path": "a.jpg", "post_process": ["resize"]}`, request: &Upload{}, fields: []string{"post_process[0]"}},
+		{name: "lifecycle rule", body: `{"rules": [{"action": "SetStorageClass", "age_days": -1, "days_since_custom_time": -1}]}`, request: &Lifecycle{}, fields: []string{"rules[0].storage_class", "rules[0].age_days", "rules[0].days_since_custom_time"}},
+		{name: "transfer without buckets", body: `{"paths": ["a"]}`, request: &Transfer{}, fields: []string{"source_bucket", "destination_bucket"}},
+		{name: "job without a type", body: `{}`, request: &Job{}, fields: []string{"type"}},
+		{name: "cache warm of a prefix", body: `{"prefix": "launch/", "cdn": true}`, request: &WarmCache{}},
+		{name: "cache purge without objects", body: `{"paths": ["../a"]}`, request: &CacheFiles{}, fields: []string{"paths[0]"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Decode(strings.NewReader(tt.body), tt.request)
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Expected a ValidationError, got %v", err)
+			}
+			var fields []string
+			for _, v := range invalid.Violations {
+				fields = append(fields, v.Field)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("Expected violations of %v, got %v", tt.fields, fields)
+			}
+		})
+	}
+}
+
+func TestShare_Request(t *testing.T) {
+	request := Share{Path: "a.pdf", TTL: "72h", MaxDownloads: 3}
+	if got := request.Request(); got.TTL != 72*time.Hour || got.MaxDownloads != 3 {
+		t.Errorf("Expected a 72h share with 3 downloads, got %+v", got)
+	}
+	if got := (&Share{Path: "a.pdf"}).Request(); got.TTL != 0 {
+		t.Errorf("Expected the default ttl, got %s", got.TTL)
+	}
+}
@@ -0,0 +1,219 @@
+package dto
+
+import (
+	"net/url"
+
+	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Batch read response encodings
+const (
+	EncodingBase64 = "base64"
+	EncodingURL    = "url"
+)
+
+// maxComposeChunks is the most chunk objects a compose request concatenates
+const maxComposeChunks = 1024
+
+// ReadFiles is the body of a batch read. Files read only part of each file
+// and can be combined with whole files in FilePaths.
+type ReadFiles struct {
+	FilePaths []string    `json:"file_paths"`
+	Files     []ReadRange `json:"files,omitempty"`
+	// Encoding is the response encoding from the encoding query parameter
+	Encoding string `json:"-"`
+}
+
+// ReadRange selects length bytes from offset; a negative offset counts from
+// the end of the file and a length of 0 reads to the end
+type ReadRange struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset,omitempty"`
+	Length int64  `json:"length,omitempty"`
+}
+
+func (r *ReadFiles) Validate() error {
+	var v validator
+	if len(r.FilePaths) == 0 && len(r.Files) == 0 {
+		v.add("file_paths", "is required unless files are given")
+	}
+	for i, filePath := range r.FilePaths {
+		v.path(index("file_paths", i), filePath)
+	}
+	for i, file := range r.Files {
+		v.path(index("files", i)+".path", file.Path)
+		if file.Length < 0 {
+			v.add(index("files", i)+".length", "must not be negative")
+		}
+	}
+	switch r.Encoding {
+	case "", EncodingBase64:
+	case EncodingURL:
+		if len(r.Files) > 0 {
+			v.add("encoding", "must be base64 for files, whose ranges are returned inline")
+		}
+	default:
+		v.add("encoding", "must be base64 or url")
+	}
+	return v.err()
+}
+
+// Ranges returns the whole files followed by the ranges of the request
+func (r *ReadFiles) Ranges() []storage.ReadRange {
+	ranges := make([]storage.ReadRange, 0, len(r.FilePaths)+len(r.Files))
+	for _, filePath := range r.FilePaths {
+		ranges = append(ranges, storage.ReadRange{Path: filePath})
+	}
+	for _, file := range r.Files {
+		ranges = append(ranges, storage.ReadRange{Path: file.Path, Offset: file.Offset, Length: file.Length})
+	}
+	return ranges
+}
+
+// Compose lists the chunk objects to concatenate, in order
+type Compose struct {
+	Chunks      []string `json:"chunks"`
+	ContentType string   `json:"content_type"`
+}
+
+func (c *Compose) Validate() error {
+	var v validator
+	if len(c.Chunks) == 0 || len(c.Chunks) > maxComposeChunks {
+		v.add("chunks", "must list between 1 and %d objects", maxComposeChunks)
+	}
+	for i, chunk := range c.Chunks {
+		v.path(index("chunks", i), chunk)
+	}
+	return v.err()
+}
+
+// Copy names the destination of a copy
+type Copy struct {
+	Destination string `json:"destination"`
+}
+
+func (c *Copy) Validate() error {
+	var v validator
+	v.path("destination", c.Destination)
+	return v.err()
+}
+
+// StorageClass moves an object to another storage class
+type StorageClass struct {
+	StorageClass string `json:"storage_class"`
+}
+
+func (s *StorageClass) Validate() error {
+	var v validator
+	v.required("storage_class", s.StorageClass)
+	return v.err()
+}
+
+// FetchFiles lists remote files to download into the bucket
+type FetchFiles struct {
+	Files []service.FetchRequest `json:"files"`
+}
+
+func (f *FetchFiles) Validate() error {
+	var v validator
+	if len(f.Files) == 0 {
+		v.add("files", "is required")
+	}
+	for i, file := range f.Files {
+		if !httpURL(file.URL) {
+			v.add(index("files", i)+".url", "must be an http(s) URL")
+		}
+		v.path(index("files", i)+".path", file.Path)
+	}
+	return v.err()
+}
+
+// Upload asks for a signed URL to upload an object directly to the bucket
+type Upload struct {
+	service.UploadRequest
+}
+
+func (u *Upload) Validate() error {
+	var v validator
+	v.path("path", u.Path)
+	for i, step := range u.PostProcess {
+		if step != service.PostProcessThumbnail {
+			v.add(index("post_process", i), "must be %s", service.PostProcessThumbnail)
+		}
+	}
+	if u.CallbackURL != "" && !httpURL(u.CallbackURL) {
+		v.add("callback_url", "must be an http(s) URL")
+	}
+	return v.err()
+}
+
+// Tags replaces the tags of a file
+type Tags struct {
+	Tags map[string]string `json:"tags"`
+}
+
+func (t *Tags) Validate() error {
+	var v validator
+	if t.Tags == nil {
+		v.add("tags", "is required; send {} to remove all tags")
+	}
+	return v.err()
+}
+
+// Holds places or releases the holds on an object
+type Holds struct {
+	storage.HoldUpdate
+}
+
+func (h *Holds) Validate() error {
+	var v validator
+	if h.TemporaryHold == nil && h.EventBasedHold == nil {
+		v.add("body", "must set temporary_hold or event_based_hold")
+	}
+	return v.err()
+}
+
+// Access makes an object public-read or private
+type Access struct {
+	Public *bool `json:"public"`
+}
+
+func (a *Access) Validate() error {
+	var v validator
+	if a.Public == nil {
+		v.add("public", "is required")
+	}
+	return v.err()
+}
+
+// CacheFiles selects objects of the read cache by path and prefix
+type CacheFiles struct {
+	Paths  []string `json:"paths,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+}
+
+func (c *CacheFiles) Validate() error {
+	var v validator
+	if len(c.Paths) == 0 && c.Prefix == "" {
+		v.add("paths", "is required unless prefix is given")
+	}
+	for i, filePath := range c.Paths {
+		v.path(index("paths", i), filePath)
+	}
+	if c.Prefix != "" {
+		v.path("prefix", c.Prefix)
+	}
+	return v.err()
+}
+
+// WarmCache reads objects into the read cache, and through the CDN with CDN
+type WarmCache struct {
+	CacheFiles
+	CDN bool `json:"cdn,omitempty"`
+}
+
+func httpURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
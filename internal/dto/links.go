@@ -0,0 +1,66 @@
+package dto
+
+import (
+	"time"
+
+	"gcp-proxy-mity/internal/share"
+)
+
+// Share asks for a link to an object, or to every object under a prefix
+type Share struct {
+	Path         string `json:"path"`
+	Prefix       bool   `json:"prefix,omitempty"`
+	TTL          string `json:"ttl,omitempty"`
+	MaxDownloads int64  `json:"max_downloads,omitempty"`
+	Password     string `json:"password,omitempty"`
+}
+
+func (s *Share) Validate() error {
+	var v validator
+	v.path("path", s.Path)
+	v.duration("ttl", s.TTL, 0)
+	if s.MaxDownloads < 0 {
+		v.add("max_downloads", "must not be negative")
+	}
+	return v.err()
+}
+
+// Request returns the share to create; a zero TTL is the default
+func (s *Share) Request() share.Request {
+	return share.Request{
+		Path:         s.Path,
+		Prefix:       s.Prefix,
+		TTL:          lifetime(s.TTL, 0),
+		MaxDownloads: s.MaxDownloads,
+		Password:     s.Password,
+	}
+}
+
+// CDNToken asks for a token for the URLs under Prefix
+type CDNToken struct {
+	Prefix string `json:"prefix"`
+	TTL    string `json:"ttl,omitempty"`
+	// MaxTTL is the longest lifetime a token may have
+	MaxTTL time.Duration `json:"-"`
+}
+
+func (t *CDNToken) Validate() error {
+	var v validator
+	v.required("prefix", t.Prefix)
+	v.duration("ttl", t.TTL, t.MaxTTL)
+	return v.err()
+}
+
+// Lifetime returns how long the token lasts, def if no ttl was given
+func (t *CDNToken) Lifetime(def time.Duration) time.Duration {
+	return lifetime(t.TTL, def)
+}
+
+// lifetime parses a validated duration, returning def for an empty one
+func lifetime(value string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,114 @@
+package election
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"gcp-proxy-mity/internal/lease"
+)
+
+// releaseTimeout bounds giving up leadership on shutdown
+const releaseTimeout = 5 * time.Second
+
+// Elector makes one replica at a time the leader by holding a lease, renewed
+// three times per ttl. A leader that stops renewing, because it crashed or
+// can't reach the store, steps down once its lease expires, and another
+// replica takes over within a third of the ttl after that.
+type Elector struct {
+	store  lease.Store
+	path   string
+	holder string
+	ttl    time.Duration
+	now    func() time.Time
+
+	id string
+	// expires is the Unix time in nanoseconds the held lease expires, 0 when following
+	expires atomic.Int64
+}
+
+// New creates an elector campaigning for the lease on path in store, held by
+// holder, a name of the replica, for ttl at a time
+func New(store lease.Store, path, holder string, ttl time.Duration) *Elector {
+	return &Elector{
+		store:  store,
+		path:   path,
+		holder: holder,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// IsLeader reports whether this replica holds the lease
+func (e *Elector) IsLeader() bool {
+	return e.now().UnixNano() < e.expires.Load()
+}
+
+// Run campaigns until ctx is done, then releases the lease if it is held
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			e.resign()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign renews the lease if it is held, or else tries to acquire it
+func (e *Elector) campaign(ctx context.Context) {
+	expires := e.now().Add(e.ttl).UTC().Truncate(time.Millisecond)
+	if e.IsLeader() {
+		if _, err := e.store.Renew(ctx, e.path, e.id, expires); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Stepping down as leader: failed to renew the lease on %s: %v", e.path, err)
+				e.expires.Store(0)
+			}
+			return
+		}
+		e.expires.Store(expires.UnixNano())
+		return
+	}
+
+	id := newID()
+	err := e.store.Acquire(ctx, lease.Lease{Path: e.path, ID: id, Holder: e.holder, Expires: expires})
+	if errors.Is(err, lease.ErrLocked) {
+		return
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to campaign for the lease on %s: %v", e.path, err)
+		}
+		return
+	}
+	e.id = id
+	e.expires.Store(expires.UnixNano())
+	log.Printf("Elected leader as %s until the lease on %s expires or is released", e.holder, e.path)
+}
+
+// resign releases the lease so another replica takes over without waiting for it to expire
+func (e *Elector) resign() {
+	if !e.IsLeader() {
+		return
+	}
+	e.expires.Store(0)
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	if err := e.store.Release(ctx, e.path, e.id); err != nil && !errors.Is(err, lease.ErrNotHeld) {
+		log.Printf("Failed to release the lease on %s: %v", e.path, err)
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
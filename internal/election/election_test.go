@@ -0,0 +1,53 @@
+package election
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/lease"
+)
+
+func TestElector(t *testing.T) {
+	ctx := context.Background()
+	store := lease.NewMemoryStore()
+	ttl := 50 * time.Millisecond
+	a := New(store, "_election/scheduler", "a", ttl)
+	b := New(store, "_election/scheduler", "b", ttl)
+
+	a.campaign(ctx)
+	b.campaign(ctx)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("Expected only a to lead, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+
+	// Renewing keeps a leading past its first lease
+	time.Sleep(ttl / 2)
+	a.campaign(ctx)
+	time.Sleep(ttl / 2)
+	b.campaign(ctx)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("Expected a to keep leading by renewing, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+
+	// A leader that stops renewing is replaced once its lease expires
+	time.Sleep(ttl)
+	if a.IsLeader() {
+		t.Error("Expected a to step down once its lease expired")
+	}
+	b.campaign(ctx)
+	if !b.IsLeader() {
+		t.Fatal("Expected b to take over the expired lease")
+	}
+	a.campaign(ctx)
+	if a.IsLeader() {
+		t.Error("Expected a not to lead again while b holds the lease")
+	}
+
+	// Resigning hands over right away
+	b.resign()
+	a.campaign(ctx)
+	if b.IsLeader() || !a.IsLeader() {
+		t.Errorf("Expected a to lead once b resigned, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+}
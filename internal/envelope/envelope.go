@@ -0,0 +1,199 @@
+// Package envelope encrypts object content in the proxy with a data key per
+// object, which is itself wrapped by a master key or Cloud KMS and stored next
+// to the content.
+//
+// Content is split into segments of SegmentSize bytes, each sealed with
+// AES-256-GCM under a nonce holding its index and whether it is the last one,
+// so segments can't be reordered, dropped or truncated without failing
+// authentication, and ranges can be decrypted without reading the whole object.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// SegmentSize is the number of plaintext bytes sealed together
+	SegmentSize = 64 << 10
+	// Overhead is what sealing adds to each segment
+	Overhead = 16
+
+	sealedSize = SegmentSize + Overhead
+)
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the nonce of the segment at index. Every data key encrypts a
+// single object, so the index alone keeps nonces unique.
+func nonce(index uint64, last bool) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n, index)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+// PlaintextSize returns the size of the content stored in ciphertextSize bytes
+func PlaintextSize(ciphertextSize int64) int64 {
+	segments := max((ciphertextSize+sealedSize-1)/sealedSize, 1)
+	return max(ciphertextSize-segments*Overhead, 0)
+}
+
+// CiphertextRange returns where the segments holding length bytes of plaintext
+// from offset start and end in the ciphertext, and the index of the first one
+func CiphertextRange(offset, length, ciphertextSize int64) (start, end int64, first uint64) {
+	firstSegment := offset / SegmentSize
+	lastSegment := max(offset+length-1, offset) / SegmentSize
+	start = firstSegment * sealedSize
+	end = min((lastSegment+1)*sealedSize, ciphertextSize)
+	return start, end, uint64(firstSegment)
+}
+
+// Open decrypts a whole object
+func Open(key, ciphertext []byte) ([]byte, error) {
+	return OpenSegments(key, ciphertext, 0, true)
+}
+
+// OpenSegments decrypts consecutive segments starting with the one at index
+// first; last tells whether they end with the object's last segment
+func OpenSegments(key, ciphertext []byte, first uint64, last bool) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, 0, PlaintextSize(int64(len(ciphertext))))
+	index := first
+	for {
+		segment := ciphertext[:min(len(ciphertext), sealedSize)]
+		ciphertext = ciphertext[len(segment):]
+		final := last && len(ciphertext) == 0
+		plaintext, err = aead.Open(plaintext, nonce(index, final), segment, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: segment %d", ErrDecrypt, index)
+		}
+		if len(ciphertext) == 0 {
+			return plaintext, nil
+		}
+		index++
+	}
+}
+
+// writer seals content in segments as it is written. A full segment is only
+// sealed once more content follows, since the last one is sealed differently.
+type writer struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	index uint64
+	buf   []byte
+}
+
+// NewWriter returns a writer encrypting into w with key. Close seals the last
+// segment but leaves w open.
+func NewWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, buf: make([]byte, 0, sealedSize)}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(w.buf) == SegmentSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):SegmentSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *writer) Close() error {
+	return w.seal(true)
+}
+
+func (w *writer) seal(last bool) error {
+	sealed := w.aead.Seal(w.buf[:0], nonce(w.index, last), w.buf, nil)
+	if _, err := w.w.Write(sealed); err != nil {
+		return err
+	}
+	w.index++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// reader opens segments as they are read. One byte more than a segment is
+// read ahead to tell whether the segment is the last one.
+type reader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	index   uint64
+	sealed  []byte
+	held    int
+	pending []byte
+	err     error
+}
+
+// NewReader returns a reader decrypting the content read from r with key
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{r: r, aead: aead, sealed: make([]byte, sealedSize+1)}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.open()
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// open reads and opens the next segment into pending
+func (r *reader) open() {
+	n, err := io.ReadFull(r.r, r.sealed[r.held:])
+	r.held += n
+	last := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !last {
+		r.err = err
+		return
+	}
+
+	size := min(r.held, sealedSize)
+	plaintext, err := r.aead.Open(nil, nonce(r.index, last), r.sealed[:size], nil)
+	if err != nil {
+		r.err = fmt.Errorf("%w: segment %d", ErrDecrypt, r.index)
+		return
+	}
+	r.pending = plaintext
+	r.index++
+	r.held = copy(r.sealed, r.sealed[size:r.held])
+	if last {
+		r.err = io.EOF
+	}
+}
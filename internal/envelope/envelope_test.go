@@ -0,0 +1,184 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func seal(t *testing.T, key, plaintext []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Odd write sizes cross segment boundaries
+	for chunk := plaintext; len(chunk) > 0; {
+		n := min(len(chunk), 7001)
+		if _, err := w.Write(chunk[:n]); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		chunk = chunk[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "small", size: 10},
+		{name: "one segment", size: SegmentSize},
+		{name: "one byte over", size: SegmentSize + 1},
+		{name: "several segments", size: 3*SegmentSize + 123},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext := make([]byte, tt.size)
+			rand.Read(plaintext)
+			ciphertext := seal(t, key, plaintext)
+
+			if got := PlaintextSize(int64(len(ciphertext))); got != int64(tt.size) {
+				t.Errorf("Expected plaintext size %d, got %d", tt.size, got)
+			}
+
+			opened, err := Open(key, ciphertext)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				t.Error("Expected Open to return the plaintext")
+			}
+
+			reader, err := NewReader(bytes.NewReader(ciphertext), key)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			streamed, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(streamed, plaintext) {
+				t.Error("Expected the reader to return the plaintext")
+			}
+		})
+	}
+}
+
+func TestEnvelope_Tampering(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	plaintext := make([]byte, 2*SegmentSize+5)
+	ciphertext := seal(t, key, plaintext)
+	otherKey := make([]byte, 32)
+	rand.Read(otherKey)
+
+	flipped := bytes.Clone(ciphertext)
+	flipped[10] ^= 1
+
+	tests := []struct {
+		name       string
+		key        []byte
+		ciphertext []byte
+	}{
+		{name: "flipped bit", key: key, ciphertext: flipped},
+		{name: "truncated at a segment", key: key, ciphertext: ciphertext[:2*(SegmentSize+Overhead)]},
+		{name: "last segment dropped and reordered", key: key, ciphertext: append(bytes.Clone(ciphertext[SegmentSize+Overhead:2*(SegmentSize+Overhead)]), ciphertext[:SegmentSize+Overhead]...)},
+		{name: "wrong key", key: otherKey, ciphertext: ciphertext},
+		{name: "empty", key: key, ciphertext: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Open(tt.key, tt.ciphertext); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Expected ErrDecrypt from Open, got %v", err)
+			}
+			reader, _ := NewReader(bytes.NewReader(tt.ciphertext), tt.key)
+			if _, err := io.ReadAll(reader); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Expected ErrDecrypt from the reader, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEnvelope_Ranges(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	plaintext := make([]byte, 3*SegmentSize+100)
+	rand.Read(plaintext)
+	ciphertext := seal(t, key, plaintext)
+	size := int64(len(ciphertext))
+
+	tests := []struct {
+		name           string
+		offset, length int64
+	}{
+		{name: "head", offset: 0, length: 10},
+		{name: "across segments", offset: SegmentSize - 5, length: 10},
+		{name: "tail", offset: 3*SegmentSize + 50, length: 50},
+		{name: "everything", offset: 0, length: int64(len(plaintext))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, first := CiphertextRange(tt.offset, tt.length, size)
+			opened, err := OpenSegments(key, ciphertext[start:end], first, end == size)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			skip := tt.offset - int64(first)*SegmentSize
+			if got := opened[skip : skip+tt.length]; !bytes.Equal(got, plaintext[tt.offset:tt.offset+tt.length]) {
+				t.Error("Expected the range of the plaintext")
+			}
+		})
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKey := make([]byte, 32), make([]byte, 32)
+	rand.Read(oldKey)
+	rand.Read(newKey)
+	oldWrapper, _ := NewLocalWrapper(oldKey)
+	newWrapper, _ := NewLocalWrapper(newKey)
+
+	dataKey, wrapped, keyID, err := NewKeyring(oldWrapper).NewDataKey(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if keyID != oldWrapper.KeyID() || bytes.Contains(wrapped, dataKey) {
+		t.Errorf("Expected the data key wrapped by %s, got %s", oldWrapper.KeyID(), keyID)
+	}
+
+	rotated := NewKeyring(newWrapper, oldWrapper)
+	unwrapped, err := rotated.DataKey(ctx, wrapped, keyID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(unwrapped, dataKey) {
+		t.Error("Expected a rotated keyring to unwrap data keys of the previous key")
+	}
+	if _, _, keyID, _ := rotated.NewDataKey(ctx); keyID != newWrapper.KeyID() {
+		t.Errorf("Expected new data keys wrapped by %s, got %s", newWrapper.KeyID(), keyID)
+	}
+
+	if _, err := NewKeyring(newWrapper).DataKey(ctx, wrapped, keyID); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+	if _, err := NewLocalWrapper([]byte("short")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+}
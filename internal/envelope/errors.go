@@ -0,0 +1,9 @@
+package envelope
+
+import "errors"
+
+var (
+	ErrInvalidKey = errors.New("envelope keys must be 32 bytes")
+	ErrUnknownKey = errors.New("the data key was wrapped with a key that isn't configured")
+	ErrDecrypt    = errors.New("envelope encrypted content failed authentication")
+)
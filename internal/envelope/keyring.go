@@ -0,0 +1,183 @@
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/option"
+)
+
+// maxCachedKeys bounds the unwrapped data keys kept to spare repeated KMS calls
+const maxCachedKeys = 4096
+
+// KeyWrapper wraps data keys with a key that never leaves it
+type KeyWrapper interface {
+	// KeyID identifies the wrapping key in object metadata
+	KeyID() string
+	Wrap(ctx context.Context, dataKey []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// Keyring wraps new data keys with its current key and unwraps those of
+// objects written with any of its keys, so wrapping keys can be rotated
+type Keyring struct {
+	current KeyWrapper
+	keys    map[string]KeyWrapper
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+// NewKeyring creates a keyring wrapping new data keys with current
+func NewKeyring(current KeyWrapper, previous ...KeyWrapper) *Keyring {
+	k := &Keyring{
+		current: current,
+		keys:    make(map[string]KeyWrapper, len(previous)+1),
+		cache:   make(map[string][]byte),
+	}
+	for _, wrapper := range previous {
+		k.keys[wrapper.KeyID()] = wrapper
+	}
+	k.keys[current.KeyID()] = current
+	return k
+}
+
+// NewDataKey returns a random data key, the key wrapped and the ID of the key that wrapped it
+func (k *Keyring) NewDataKey(ctx context.Context) (dataKey, wrapped []byte, keyID string, err error) {
+	dataKey = make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, "", err
+	}
+	wrapped, err = k.current.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	return dataKey, wrapped, k.current.KeyID(), nil
+}
+
+// DataKey unwraps a data key wrapped by the key with keyID
+func (k *Keyring) DataKey(ctx context.Context, wrapped []byte, keyID string) ([]byte, error) {
+	wrapper, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+
+	cacheKey := keyID + "/" + string(wrapped)
+	k.mu.Lock()
+	dataKey, ok := k.cache[cacheKey]
+	k.mu.Unlock()
+	if ok {
+		return dataKey, nil
+	}
+
+	dataKey, err := wrapper.Unwrap(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	if len(dataKey) != 32 {
+		return nil, ErrInvalidKey
+	}
+
+	k.mu.Lock()
+	if len(k.cache) >= maxCachedKeys {
+		clear(k.cache)
+	}
+	k.cache[cacheKey] = dataKey
+	k.mu.Unlock()
+	return dataKey, nil
+}
+
+// LocalWrapper wraps data keys with AES-256-GCM under a master key held by the proxy
+type LocalWrapper struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewLocalWrapper creates a wrapper for a 32 byte master key. Its ID is derived
+// from the key, so objects name the master key they need without revealing it.
+func NewLocalWrapper(masterKey []byte) (*LocalWrapper, error) {
+	if len(masterKey) != 32 {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(masterKey)
+	return &LocalWrapper{id: "local:" + hex.EncodeToString(sum[:8]), aead: aead}, nil
+}
+
+func (w *LocalWrapper) KeyID() string {
+	return w.id
+}
+
+func (w *LocalWrapper) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, w.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return w.aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func (w *LocalWrapper) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < w.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, sealed := wrapped[:w.aead.NonceSize()], wrapped[w.aead.NonceSize():]
+	dataKey, err := w.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return dataKey, nil
+}
+
+// KMSWrapper wraps data keys with a Cloud KMS key, which rotates its own versions
+type KMSWrapper struct {
+	keyName string
+	keys    *cloudkms.ProjectsLocationsKeyRingsCryptoKeysService
+}
+
+// NewKMSWrapper creates a wrapper for the key projects/*/locations/*/keyRings/*/cryptoKeys/*
+func NewKMSWrapper(ctx context.Context, keyName string, opts ...option.ClientOption) (*KMSWrapper, error) {
+	service, err := cloudkms.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud KMS client: %w", err)
+	}
+	return &KMSWrapper{keyName: keyName, keys: service.Projects.Locations.KeyRings.CryptoKeys}, nil
+}
+
+func (w *KMSWrapper) KeyID() string {
+	return w.keyName
+}
+
+func (w *KMSWrapper) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	resp, err := w.keys.Encrypt(w.keyName, &cloudkms.EncryptRequest{
+		Plaintext: base64.StdEncoding.EncodeToString(dataKey),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Ciphertext)
+}
+
+func (w *KMSWrapper) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	resp, err := w.keys.Decrypt(w.keyName, &cloudkms.DecryptRequest{
+		Ciphertext: base64.StdEncoding.EncodeToString(wrapped),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
@@ -0,0 +1,141 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// DeadLetterFunc receives events that could not be delivered after all attempts
+type DeadLetterFunc func(ctx context.Context, event Event, err error)
+
+// AsyncConfig configures background delivery
+type AsyncConfig struct {
+	QueueSize   int
+	Workers     int
+	MaxAttempts int
+	// InitialBackoff is doubled after every failed attempt
+	InitialBackoff time.Duration
+	Timeout        time.Duration
+	DeadLetter     DeadLetterFunc
+}
+
+// AsyncPublisher queues events and delivers them in the background with retries
+type AsyncPublisher struct {
+	name   string
+	sender Sender
+	cfg    AsyncConfig
+	queue  chan Event
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// NewAsyncPublisher starts workers delivering events through sender
+func NewAsyncPublisher(name string, sender Sender, cfg AsyncConfig) *AsyncPublisher {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 2
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = 500 * time.Millisecond
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	p := &AsyncPublisher{
+		name:   name,
+		sender: sender,
+		cfg:    cfg,
+		queue:  make(chan Event, cfg.QueueSize),
+	}
+
+	for i := 0; i < cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+
+	return p
+}
+
+// Publish enqueues an event without blocking; a full queue sends the event to the dead letter sink
+func (p *AsyncPublisher) Publish(ctx context.Context, event Event) error {
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	select {
+	case p.queue <- event:
+		return nil
+	default:
+		err := fmt.Errorf("%s queue is full", p.name)
+		p.deadLetter(event, err)
+		return err
+	}
+}
+
+// Close stops accepting events and waits for queued events to be delivered
+func (p *AsyncPublisher) Close() {
+	p.once.Do(func() {
+		close(p.queue)
+	})
+	p.wg.Wait()
+}
+
+func (p *AsyncPublisher) worker() {
+	defer p.wg.Done()
+	for event := range p.queue {
+		if err := p.deliver(event); err != nil {
+			p.deadLetter(event, err)
+		}
+	}
+}
+
+func (p *AsyncPublisher) deliver(event Event) error {
+	backoff := p.cfg.InitialBackoff
+	var err error
+
+	for attempt := 1; attempt <= p.cfg.MaxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), p.cfg.Timeout)
+		err = p.sender.Send(ctx, event)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		if attempt < p.cfg.MaxAttempts {
+			log.Printf("Event %s delivery via %s failed (attempt %d/%d): %v", event.ID, p.name, attempt, p.cfg.MaxAttempts, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return fmt.Errorf("delivery failed after %d attempts: %w", p.cfg.MaxAttempts, err)
+}
+
+func (p *AsyncPublisher) deadLetter(event Event, err error) {
+	log.Printf("Event %s could not be delivered via %s: %v", event.ID, p.name, err)
+	if p.cfg.DeadLetter != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), p.cfg.Timeout)
+		defer cancel()
+		p.cfg.DeadLetter(ctx, event, err)
+	}
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
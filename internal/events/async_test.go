@@ -0,0 +1,99 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type mockSender struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	sent     []Event
+}
+
+func (m *mockSender) Send(ctx context.Context, event Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls++
+	if m.calls <= m.failures {
+		return errors.New("temporary failure")
+	}
+	m.sent = append(m.sent, event)
+	return nil
+}
+
+func TestAsyncPublisher_RetriesUntilDelivered(t *testing.T) {
+	sender := &mockSender{failures: 2}
+	publisher := NewAsyncPublisher("test", sender, AsyncConfig{
+		Workers:        1,
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+	})
+
+	if err := publisher.Publish(context.Background(), Event{Type: ObjectWritten, Path: "a.mp4"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	publisher.Close()
+
+	if sender.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", sender.calls)
+	}
+	if len(sender.sent) != 1 || sender.sent[0].ID == "" {
+		t.Errorf("Expected one delivered event with an ID, got %+v", sender.sent)
+	}
+}
+
+func TestAsyncPublisher_DeadLetter(t *testing.T) {
+	sender := &mockSender{failures: 10}
+	var deadLettered []Event
+	publisher := NewAsyncPublisher("test", sender, AsyncConfig{
+		Workers:        1,
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		DeadLetter: func(ctx context.Context, event Event, err error) {
+			deadLettered = append(deadLettered, event)
+		},
+	})
+
+	publisher.Publish(context.Background(), Event{Type: ObjectWritten, Path: "a.mp4"})
+	publisher.Close()
+
+	if sender.calls != 2 {
+		t.Errorf("Expected 2 attempts, got %d", sender.calls)
+	}
+	if len(deadLettered) != 1 || deadLettered[0].Path != "a.mp4" {
+		t.Errorf("Expected event to be dead-lettered, got %+v", deadLettered)
+	}
+}
+
+func TestWebhookSender(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	var signature string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Signature")
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	sender := NewWebhookSender(server.URL, "secret")
+	if err := sender.Send(context.Background(), Event{ID: "1", Type: ObjectWritten}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if signature == "" {
+		t.Error("Expected signature header to be set")
+	}
+
+	status.Store(http.StatusBadGateway)
+	if err := sender.Send(context.Background(), Event{ID: "2", Type: ObjectWritten}); err == nil {
+		t.Error("Expected error for non-2xx response")
+	}
+}
@@ -0,0 +1,127 @@
+package events
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ObjectMetadataUpdated is only observed through bucket notifications
+const ObjectMetadataUpdated = "storage.object.metadata_updated"
+
+// subscriptionBuffer is how many events a subscriber may fall behind before it is dropped
+const subscriptionBuffer = 256
+
+// Subscription receives the events matching its filter until it is closed
+type Subscription struct {
+	// Events is closed when the subscription ends, also when the subscriber fell too far behind
+	Events <-chan Event
+	events chan Event
+	filter func(Event) bool
+	b      *Broadcaster
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.b.remove(s)
+}
+
+// Broadcaster fans events out to live subscribers, like the server-sent event
+// stream. It never blocks publishing: subscribers that fall behind are dropped.
+type Broadcaster struct {
+	mu          sync.Mutex
+	exclude     []string
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBroadcaster creates a broadcaster without subscribers. Events of objects
+// under the excluded prefixes, like the proxy's internal ones, are not passed on.
+func NewBroadcaster(exclude []string) *Broadcaster {
+	return &Broadcaster{
+		exclude:     exclude,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe starts receiving the events for which filter returns true; a nil filter receives all
+func (b *Broadcaster) Subscribe(filter func(Event) bool) *Subscription {
+	events := make(chan Event, subscriptionBuffer)
+	s := &Subscription{Events: events, events: events, filter: filter, b: b}
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Len returns the number of subscribers
+func (b *Broadcaster) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// Publish passes an event to the matching subscribers
+func (b *Broadcaster) Publish(ctx context.Context, event Event) error {
+	for _, prefix := range b.exclude {
+		if strings.HasPrefix(event.Path, prefix) {
+			return nil
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subscribers {
+		if s.filter != nil && !s.filter(event) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			b.drop(s)
+		}
+	}
+	return nil
+}
+
+func (b *Broadcaster) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drop(s)
+}
+
+// drop ends a subscription; callers hold the lock
+func (b *Broadcaster) drop(s *Subscription) {
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
+
+// NotificationEvent converts a Cloud Storage notification into an event. The
+// deletion of an overwritten generation has none, since the new generation
+// arrives as its own notification.
+func NotificationEvent(notification ObjectNotification) (Event, bool) {
+	event := Event{
+		ID:          newEventID(),
+		Path:        notification.Path,
+		Size:        notification.Size,
+		ContentType: notification.ContentType,
+		Metadata:    notification.Metadata,
+		Time:        time.Now().UTC(),
+	}
+	switch notification.Type {
+	case NotificationFinalize:
+		event.Type = ObjectWritten
+	case NotificationMetadataUpdate:
+		event.Type = ObjectMetadataUpdated
+	case NotificationDelete:
+		if notification.Overwritten {
+			return Event{}, false
+		}
+		event = Event{ID: event.ID, Type: ObjectDeleted, Path: notification.Path, Time: event.Time}
+	default:
+		return Event{}, false
+	}
+	return event, true
+}
@@ -0,0 +1,83 @@
+package events
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBroadcaster_Publish(t *testing.T) {
+	b := NewBroadcaster([]string{"_trash/"})
+	videos := b.Subscribe(func(event Event) bool { return strings.HasPrefix(event.Path, "videos/") })
+	all := b.Subscribe(nil)
+	defer videos.Close()
+	defer all.Close()
+
+	ctx := context.Background()
+	for _, path := range []string{"videos/a.mp4", "photos/b.jpg", "_trash/videos/c.mp4"} {
+		b.Publish(ctx, Event{Type: ObjectWritten, Path: path})
+	}
+
+	tests := []struct {
+		name         string
+		subscription *Subscription
+		want         []string
+	}{
+		{name: "filtered", subscription: videos, want: []string{"videos/a.mp4"}},
+		{name: "all", subscription: all, want: []string{"videos/a.mp4", "photos/b.jpg"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for len(tt.subscription.Events) > 0 {
+				got = append(got, (<-tt.subscription.Events).Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBroadcaster_DropsSlowSubscribers(t *testing.T) {
+	b := NewBroadcaster(nil)
+	slow := b.Subscribe(nil)
+
+	for range subscriptionBuffer + 1 {
+		b.Publish(context.Background(), Event{Type: ObjectWritten, Path: "a.mp4"})
+	}
+	if b.Len() != 0 {
+		t.Errorf("Expected the slow subscriber to be dropped, got %d subscribers", b.Len())
+	}
+
+	received := 0
+	for range slow.Events {
+		received++
+	}
+	if received != subscriptionBuffer {
+		t.Errorf("Expected %d buffered events before the stream closed, got %d", subscriptionBuffer, received)
+	}
+	// Closing a dropped subscription is harmless
+	slow.Close()
+}
+
+func TestNotificationEvent(t *testing.T) {
+	tests := []struct {
+		notification ObjectNotification
+		want         string
+	}{
+		{ObjectNotification{Type: NotificationFinalize, Path: "a"}, ObjectWritten},
+		{ObjectNotification{Type: NotificationMetadataUpdate, Path: "a"}, ObjectMetadataUpdated},
+		{ObjectNotification{Type: NotificationDelete, Path: "a"}, ObjectDeleted},
+		{ObjectNotification{Type: NotificationDelete, Path: "a", Overwritten: true}, ""},
+	}
+
+	for _, tt := range tests {
+		event, ok := NotificationEvent(tt.notification)
+		if ok != (tt.want != "") || event.Type != tt.want {
+			t.Errorf("Expected %q for %+v, got %q", tt.want, tt.notification, event.Type)
+		}
+	}
+}
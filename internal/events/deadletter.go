@@ -0,0 +1,47 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"path"
+	"time"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// deadLetterRecord is the stored form of an undeliverable event
+type deadLetterRecord struct {
+	Event    Event     `json:"event"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// StorageDeadLetter stores undeliverable events as JSON objects under prefix
+func StorageDeadLetter(store storage.Storage, prefix string) DeadLetterFunc {
+	return func(ctx context.Context, event Event, err error) {
+		now := time.Now().UTC()
+		body, encodeErr := json.Marshal(deadLetterRecord{
+			Event:    event,
+			Error:    err.Error(),
+			FailedAt: now,
+		})
+		if encodeErr != nil {
+			log.Printf("Failed to encode dead letter for event %s: %v", event.ID, encodeErr)
+			return
+		}
+
+		name := path.Join(prefix, now.Format("2006/01/02"), now.Format("150405.000")+"-"+event.ID+".json")
+		response, writeErr := store.WriteFiles(ctx, []storage.WriteRequest{{
+			Path:        name,
+			Content:     bytes.NewReader(body),
+			ContentType: "application/json",
+		}})
+		if writeErr == nil && len(response.Errors) > 0 {
+			log.Printf("Failed to store dead letter %s: %s", name, response.Errors[0].Error)
+		} else if writeErr != nil {
+			log.Printf("Failed to store dead letter %s: %v", name, writeErr)
+		}
+	}
+}
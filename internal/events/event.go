@@ -0,0 +1,50 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Event types
+const (
+	ObjectWritten = "storage.object.written"
+	ObjectDeleted = "storage.object.deleted"
+)
+
+// Event describes a change to a stored object
+type Event struct {
+	ID          string            `json:"id"`
+	Type        string            `json:"type"`
+	Path        string            `json:"path"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"content_type"`
+	MD5         string            `json:"md5,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Time        time.Time         `json:"time"`
+}
+
+// Publisher accepts events for delivery to downstream consumers
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Sender delivers a single event synchronously
+type Sender interface {
+	Send(ctx context.Context, event Event) error
+}
+
+// NewObjectEvent creates an event for a stored object
+func NewObjectEvent(eventType string, file storage.FileMetadata, metadata map[string]string) Event {
+	return Event{
+		ID:          newEventID(),
+		Type:        eventType,
+		Path:        file.Name,
+		Size:        file.Size,
+		ContentType: file.ContentType,
+		MD5:         file.MD5,
+		Metadata:    metadata,
+		Time:        time.Now().UTC(),
+	}
+}
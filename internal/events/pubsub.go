@@ -0,0 +1,192 @@
+package events
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/pubsub/v1"
+)
+
+// PubSubSender publishes events to a Google Cloud Pub/Sub topic
+type PubSubSender struct {
+	topic   string
+	service *pubsub.Service
+}
+
+// NewPubSubSender creates a sender for a topic in the form projects/{project}/topics/{topic}
+func NewPubSubSender(ctx context.Context, topic string, opts ...option.ClientOption) (*PubSubSender, error) {
+	service, err := pubsub.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	}
+	return &PubSubSender{
+		topic:   topic,
+		service: service,
+	}, nil
+}
+
+func (s *PubSubSender) Send(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	message := &pubsub.PubsubMessage{
+		Data: base64.StdEncoding.EncodeToString(data),
+		Attributes: map[string]string{
+			"eventType":   event.Type,
+			"eventId":     event.ID,
+			"path":        event.Path,
+			"contentType": event.ContentType,
+			"size":        strconv.FormatInt(event.Size, 10),
+		},
+	}
+
+	_, err = s.service.Projects.Topics.Publish(s.topic, &pubsub.PublishRequest{
+		Messages: []*pubsub.PubsubMessage{message},
+	}).Context(ctx).Do()
+	return err
+}
+
+// Cloud Storage notification event types
+const (
+	NotificationFinalize       = "OBJECT_FINALIZE"
+	NotificationDelete         = "OBJECT_DELETE"
+	NotificationMetadataUpdate = "OBJECT_METADATA_UPDATE"
+)
+
+// ObjectNotification is a Cloud Storage notification about an object
+type ObjectNotification struct {
+	// Type is one of the Notification event types
+	Type        string
+	Bucket      string
+	Path        string
+	ContentType string
+	Size        int64
+	Updated     time.Time
+	Metadata    map[string]string
+	// Overwritten is set on the deletion of a generation replaced by a newer one
+	Overwritten bool
+}
+
+// PubSubSubscriber pulls Cloud Storage notifications from a Pub/Sub subscription
+type PubSubSubscriber struct {
+	subscription string
+	service      *pubsub.Service
+	types        []string
+}
+
+// NewPubSubSubscriber creates a subscriber for a subscription in the form projects/{project}/subscriptions/{subscription}
+func NewPubSubSubscriber(ctx context.Context, subscription string, opts ...option.ClientOption) (*PubSubSubscriber, error) {
+	service, err := pubsub.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	}
+	return &PubSubSubscriber{
+		subscription: subscription,
+		service:      service,
+		types:        []string{NotificationFinalize},
+	}, nil
+}
+
+// SetEventTypes selects the notifications passed on by Receive; OBJECT_FINALIZE by default
+func (s *PubSubSubscriber) SetEventTypes(types ...string) {
+	s.types = types
+}
+
+// Receive pulls the selected notifications and passes them to handle until ctx is done.
+// A message is acknowledged once handle succeeds, so failed notifications are redelivered.
+func (s *PubSubSubscriber) Receive(ctx context.Context, handle func(context.Context, ObjectNotification) error) {
+	for ctx.Err() == nil {
+		resp, err := s.service.Projects.Subscriptions.Pull(s.subscription, &pubsub.PullRequest{
+			MaxMessages: 10,
+		}).Context(ctx).Do()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to pull from %s: %v", s.subscription, err)
+				sleep(ctx, 5*time.Second)
+			}
+			continue
+		}
+
+		ackIDs := make([]string, 0, len(resp.ReceivedMessages))
+		for _, received := range resp.ReceivedMessages {
+			if !slices.Contains(s.types, received.Message.Attributes["eventType"]) {
+				ackIDs = append(ackIDs, received.AckId)
+				continue
+			}
+
+			notification, err := parseObjectNotification(received.Message)
+			if err != nil {
+				// Malformed messages would fail again on redelivery
+				log.Printf("Dropping notification %s: %v", received.Message.MessageId, err)
+				ackIDs = append(ackIDs, received.AckId)
+				continue
+			}
+
+			if err := handle(ctx, notification); err != nil {
+				log.Printf("Failed to handle notification for %s: %v", notification.Path, err)
+				continue
+			}
+			ackIDs = append(ackIDs, received.AckId)
+		}
+
+		if len(ackIDs) > 0 {
+			_, err := s.service.Projects.Subscriptions.Acknowledge(s.subscription, &pubsub.AcknowledgeRequest{
+				AckIds: ackIDs,
+			}).Context(ctx).Do()
+			if err != nil {
+				log.Printf("Failed to acknowledge notifications: %v", err)
+			}
+		}
+	}
+}
+
+// parseObjectNotification decodes a notification in the JSON_API_V1 payload format
+func parseObjectNotification(message *pubsub.PubsubMessage) (ObjectNotification, error) {
+	data, err := base64.StdEncoding.DecodeString(message.Data)
+	if err != nil {
+		return ObjectNotification{}, fmt.Errorf("invalid message data: %w", err)
+	}
+
+	var object struct {
+		Bucket      string            `json:"bucket"`
+		Name        string            `json:"name"`
+		ContentType string            `json:"contentType"`
+		Size        string            `json:"size"`
+		Updated     time.Time         `json:"updated"`
+		Metadata    map[string]string `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return ObjectNotification{}, fmt.Errorf("invalid object resource: %w", err)
+	}
+	if object.Name == "" {
+		return ObjectNotification{}, fmt.Errorf("object resource has no name")
+	}
+
+	size, _ := strconv.ParseInt(object.Size, 10, 64)
+	return ObjectNotification{
+		Type:        message.Attributes["eventType"],
+		Bucket:      object.Bucket,
+		Path:        object.Name,
+		ContentType: object.ContentType,
+		Size:        size,
+		Updated:     object.Updated,
+		Metadata:    object.Metadata,
+		Overwritten: message.Attributes["overwrittenByGeneration"] != "",
+	}, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
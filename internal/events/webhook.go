@@ -0,0 +1,62 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// WebhookSender posts events as JSON to an HTTP endpoint
+type WebhookSender struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhookSender creates a sender for the given URL. When secret is set, each
+// request carries an X-Signature header with the hex HMAC-SHA256 of the body.
+func NewWebhookSender(url, secret string) *WebhookSender {
+	return &WebhookSender{
+		url:    url,
+		secret: secret,
+		client: &http.Client{},
+	}
+}
+
+func (s *WebhookSender) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Type", event.Type)
+	req.Header.Set("X-Event-ID", event.ID)
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
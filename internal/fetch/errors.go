@@ -0,0 +1,11 @@
+package fetch
+
+import "errors"
+
+var (
+	ErrInvalidURL      = errors.New("invalid URL")
+	ErrHostNotAllowed  = errors.New("host is not allowed")
+	ErrBlockedAddress  = errors.New("address is not publicly routable")
+	ErrTooLarge        = errors.New("remote file too large")
+	ErrUnexpectedReply = errors.New("unexpected response from remote server")
+)
@@ -0,0 +1,185 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// maxRedirects is the number of redirects followed for a single download
+const maxRedirects = 5
+
+// Ranges that are not covered by netip's checks but don't reach the public internet
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64, may map to private IPv4
+}
+
+// Config limits what a Fetcher downloads
+type Config struct {
+	// MaxBytes caps the size of a download; 0 is unlimited
+	MaxBytes int64
+	// Timeout bounds a whole download, including reading the body; 0 is unlimited
+	Timeout time.Duration
+	// AllowedHosts restricts downloads to these host names; empty allows any host
+	AllowedHosts []string
+	// AllowPrivate permits loopback, private and link-local addresses, e.g. in tests
+	AllowPrivate bool
+}
+
+// Fetcher downloads remote files over HTTP(S). Connections to addresses that are
+// not publicly routable are refused after DNS resolution, so neither redirects
+// nor DNS tricks reach internal services such as the metadata server.
+type Fetcher struct {
+	client       *http.Client
+	maxBytes     int64
+	allowedHosts []string
+}
+
+// Response is a download in progress; the caller closes Body
+type Response struct {
+	Body        io.ReadCloser
+	ContentType string
+	// Size is the announced length, -1 if unknown
+	Size int64
+}
+
+// New creates a fetcher
+func New(cfg Config) *Fetcher {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !cfg.AllowPrivate {
+		dialer.Control = checkAddress
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy from the environment would connect on our behalf, bypassing the address check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	f := &Fetcher{
+		maxBytes:     cfg.MaxBytes,
+		allowedHosts: cfg.AllowedHosts,
+	}
+	f.client = &http.Client{
+		Transport:     transport,
+		Timeout:       cfg.Timeout,
+		CheckRedirect: f.checkRedirect,
+	}
+	return f
+}
+
+// Fetch starts downloading rawURL. Bodies over the size limit fail with ErrTooLarge while being read.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if err := f.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrUnexpectedReply, resp.Status)
+	}
+	if f.maxBytes > 0 && resp.ContentLength > f.maxBytes {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d bytes exceed the limit of %d", ErrTooLarge, resp.ContentLength, f.maxBytes)
+	}
+
+	response := &Response{
+		Body: resp.Body,
+		Size: resp.ContentLength,
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		response.ContentType = mediaType
+	}
+	if f.maxBytes > 0 {
+		response.Body = &limitedBody{ReadCloser: resp.Body, limit: f.maxBytes, remaining: f.maxBytes}
+	}
+	return response, nil
+}
+
+func (f *Fetcher) checkURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("%w: an absolute http(s) URL is required", ErrInvalidURL)
+	}
+	if len(f.allowedHosts) > 0 && !slices.Contains(f.allowedHosts, u.Hostname()) {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Hostname())
+	}
+	return nil
+}
+
+func (f *Fetcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("%w: more than %d redirects", ErrUnexpectedReply, maxRedirects)
+	}
+	return f.checkURL(req.URL)
+}
+
+// checkAddress refuses connections to addresses that are not publicly routable
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !public(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, ip)
+	}
+	return nil
+}
+
+func public(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// limitedBody fails reads once more than limit bytes were received
+type limitedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		return n, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, b.limit)
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,103 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestPublic(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := public(netip.MustParseAddr(tt.ip)); got != tt.public {
+				t.Errorf("Expected public %v, got %v", tt.public, got)
+			}
+		})
+	}
+}
+
+func TestFetcher_Fetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.jpg":
+			w.Header().Set("Content-Type", "image/jpeg; charset=binary")
+			w.Write([]byte("0123456789"))
+		case "/stream":
+			// Flushing first sends the body chunked without a length
+			w.(http.Flusher).Flush()
+			w.Write([]byte("0123456789abcdef"))
+		case "/redirect":
+			http.Redirect(w, r, "http://example.com/image.jpg", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		url     string
+		want    string
+		wantErr error
+	}{
+		{name: "download", cfg: Config{AllowPrivate: true, MaxBytes: 10}, url: server.URL + "/image.jpg", want: "0123456789"},
+		{name: "loopback", url: server.URL + "/image.jpg", wantErr: ErrBlockedAddress},
+		{name: "announced too large", cfg: Config{AllowPrivate: true, MaxBytes: 5}, url: server.URL + "/image.jpg", wantErr: ErrTooLarge},
+		{name: "streamed too large", cfg: Config{AllowPrivate: true, MaxBytes: 10}, url: server.URL + "/stream", wantErr: ErrTooLarge},
+		{name: "not found", cfg: Config{AllowPrivate: true}, url: server.URL + "/missing", wantErr: ErrUnexpectedReply},
+		{name: "scheme", cfg: Config{AllowPrivate: true}, url: "file:///etc/passwd", wantErr: ErrInvalidURL},
+		{name: "host not allowed", cfg: Config{AllowedHosts: []string{"example.com"}}, url: server.URL + "/image.jpg", wantErr: ErrHostNotAllowed},
+		{name: "redirect to host not allowed", cfg: Config{AllowPrivate: true, AllowedHosts: []string{"127.0.0.1"}}, url: server.URL + "/redirect", wantErr: ErrHostNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := New(tt.cfg).Fetch(context.Background(), tt.url)
+			var body []byte
+			if err == nil {
+				body, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("Expected body %q, got %q", tt.want, body)
+			}
+			if resp.ContentType != "image/jpeg" {
+				t.Errorf("Expected content type image/jpeg, got %q", resp.ContentType)
+			}
+		})
+	}
+}
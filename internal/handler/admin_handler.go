@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/dto"
+	"gcp-proxy-mity/internal/openapi"
+	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// AdminHandler serves the bucket management API. All routes require the admin scope.
+type AdminHandler struct {
+	service *service.AdminService
+}
+
+func NewAdminHandler(service *service.AdminService) *AdminHandler {
+	return &AdminHandler{
+		service: service,
+	}
+}
+
+// ListBuckets lists the buckets of the project
+// GET /api/v1/admin/buckets
+func (h *AdminHandler) ListBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, err := h.service.ListBuckets(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to list buckets: "+err.Error(), adminErrorStatus(err))
+		return
+	}
+	writeJSON(w, buckets)
+}
+
+// CreateBucket creates a bucket
+// POST /api/v1/admin/buckets with {"name": "...", "location": "EU", "storage_class": "STANDARD"}
+func (h *AdminHandler) CreateBucket(w http.ResponseWriter, r *http.Request) {
+	var request dto.CreateBucket
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	bucket, err := h.service.CreateBucket(r.Context(), request.Name, request.BucketOptions)
+	if err != nil {
+		http.Error(w, "Failed to create bucket: "+err.Error(), adminErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/admin/buckets/"+bucket.Name)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(bucket)
+}
+
+// GetBucket returns a bucket's attributes
+// GET /api/v1/admin/buckets/{name}
+func (h *AdminHandler) GetBucket(w http.ResponseWriter, r *http.Request) {
+	bucket, err := h.service.GetBucket(r.Context(), r.PathValue("name"))
+	if err != nil {
+		http.Error(w, "Failed to get bucket: "+err.Error(), adminErrorStatus(err))
+		return
+	}
+	writeJSON(w, bucket)
+}
+
+// GetLifecycle returns a bucket's lifecycle rules
+// GET /api/v1/admin/buckets/{name}/lifecycle
+func (h *AdminHandler) GetLifecycle(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.service.GetLifecycle(r.Context(), r.PathValue("name"))
+	if err != nil {
+		http.Error(w, "Failed to handle bucket lifecycle: "+err.Error(), adminErrorStatus(err))
+		return
+	}
+	writeJSON(w, dto.Lifecycle{Rules: rules})
+}
+
+// SetLifecycle replaces a bucket's lifecycle rules
+// PUT /api/v1/admin/buckets/{name}/lifecycle
+// Body: {"rules": [{"action": "Delete", "age_days": 365, "matches_prefix": ["uploads/"]}]}
+func (h *AdminHandler) SetLifecycle(w http.ResponseWriter, r *http.Request) {
+	var request dto.Lifecycle
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	rules, err := h.service.SetLifecycle(r.Context(), r.PathValue("name"), request.Rules)
+	if err != nil {
+		http.Error(w, "Failed to handle bucket lifecycle: "+err.Error(), adminErrorStatus(err))
+		return
+	}
+	writeJSON(w, dto.Lifecycle{Rules: rules})
+}
+
+func adminErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidBucket), errors.Is(err, service.ErrInvalidStorageClass),
+		errors.Is(err, service.ErrInvalidLifecycle):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *AdminHandler) SetupRoutes(router *Router) {
+	bucketParam := openapi.PathParam("name", "Bucket name")
+	statuses := []int{http.StatusBadRequest, http.StatusForbidden}
+	admin := func(fn http.HandlerFunc) http.Handler {
+		return auth.RequireScope(auth.ScopeAdmin, fn)
+	}
+
+	router.Handle("GET /api/v1/admin/buckets", admin(h.ListBuckets), openapi.Operation{
+		ID:        "listBuckets",
+		Tag:       "admin",
+		Summary:   "List the buckets of the project",
+		Responses: []openapi.Response{openapi.JSONResponse("Buckets", []storage.BucketInfo{})},
+		Errors:    []int{http.StatusForbidden},
+	})
+	router.Handle("POST /api/v1/admin/buckets", admin(h.CreateBucket), openapi.Operation{
+		ID:        "createBucket",
+		Tag:       "admin",
+		Summary:   "Create a bucket",
+		Request:   openapi.JSONBody(dto.CreateBucket{}),
+		Responses: []openapi.Response{{Status: http.StatusCreated, Description: "The created bucket", Body: openapi.JSONBody(storage.BucketInfo{})}},
+		Errors:    statuses,
+	})
+	router.Handle("GET /api/v1/admin/buckets/{name}", admin(h.GetBucket), openapi.Operation{
+		ID:        "getBucket",
+		Tag:       "admin",
+		Summary:   "Get the attributes of a bucket",
+		Params:    []openapi.Param{bucketParam},
+		Responses: []openapi.Response{openapi.JSONResponse("The bucket", storage.BucketInfo{})},
+		Errors:    statuses,
+	})
+	router.Handle("GET /api/v1/admin/buckets/{name}/lifecycle", admin(h.GetLifecycle), openapi.Operation{
+		ID:        "getLifecycle",
+		Tag:       "admin",
+		Summary:   "Get the lifecycle rules of a bucket",
+		Params:    []openapi.Param{bucketParam},
+		Responses: []openapi.Response{openapi.JSONResponse("Lifecycle rules", dto.Lifecycle{})},
+		Errors:    statuses,
+	})
+	router.Handle("PUT /api/v1/admin/buckets/{name}/lifecycle", admin(h.SetLifecycle), openapi.Operation{
+		ID:        "setLifecycle",
+		Tag:       "admin",
+		Summary:   "Replace the lifecycle rules of a bucket",
+		Params:    []openapi.Param{bucketParam},
+		Request:   openapi.JSONBody(dto.Lifecycle{}),
+		Responses: []openapi.Response{openapi.JSONResponse("Lifecycle rules", dto.Lifecycle{})},
+		Errors:    statuses,
+	})
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http"
+
+	"gcp-proxy-mity/internal/dto"
+)
+
+// purgeResponse counts the objects dropped from the read cache
+type purgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+// GetCacheStats reports the size of the read cache and its hits and misses
+// GET /api/v1/storage/cache
+func (h *StorageHandler) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	cacheStats, err := h.service.ReadCacheStats()
+	if err != nil {
+		http.Error(w, "Failed to read cache statistics: "+err.Error(), errorStatus(err))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, cacheStats)
+}
+
+// WarmCache reads objects into the read cache ahead of a launch, and sends
+// HEAD requests for them through the CDN with cdn set
+// POST /api/v1/storage/cache/warm
+// Body: {"paths": ["launch/hero.jpg"], "prefix": "launch/thumbs/", "cdn": true}
+func (h *StorageHandler) WarmCache(w http.ResponseWriter, r *http.Request) {
+	var request dto.WarmCache
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	result, err := h.service.WarmCache(r.Context(), request.Paths, request.Prefix, request.CDN)
+	if err != nil {
+		http.Error(w, "Failed to warm cache: "+err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, result)
+}
+
+// PurgeCache drops objects from the read cache
+// POST /api/v1/storage/cache/purge
+// Body: {"paths": ["launch/hero.jpg"], "prefix": "launch/thumbs/"}
+func (h *StorageHandler) PurgeCache(w http.ResponseWriter, r *http.Request) {
+	var request dto.CacheFiles
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	purged, err := h.service.PurgeCache(r.Context(), request.Paths, request.Prefix)
+	if err != nil {
+		http.Error(w, "Failed to purge cache: "+err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, purgeResponse{Purged: purged})
+}
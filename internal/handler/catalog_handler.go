@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gcp-proxy-mity/internal/catalog"
+)
+
+// Page sizes of catalog queries
+const (
+	defaultCatalogLimit = 100
+	maxCatalogLimit     = 1000
+)
+
+type catalogObjectsResponse struct {
+	Objects       []catalog.Object `json:"objects"`
+	NextPageToken string           `json:"next_page_token,omitempty"`
+}
+
+type catalogChangesResponse struct {
+	Changes       []catalog.Change `json:"changes"`
+	NextPageToken string           `json:"next_page_token,omitempty"`
+}
+
+// CatalogObjects lists the objects recorded in the catalog in path order
+// GET /api/v1/admin/catalog/objects?prefix=videos/&tenant=...&limit=100&page_token=...
+func (h *StorageHandler) CatalogObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, ok := catalogLimit(w, r)
+	if !ok {
+		return
+	}
+
+	// Page tokens are the path of the last object of the previous page
+	objects, err := h.service.CatalogObjects(r.Context(), catalog.ObjectQuery{
+		Prefix: query.Get("prefix"),
+		Tenant: query.Get("tenant"),
+		After:  query.Get("page_token"),
+		Limit:  limit,
+	})
+	if err != nil {
+		http.Error(w, "Failed to query catalog: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	response := catalogObjectsResponse{Objects: objects}
+	if len(objects) == limit {
+		response.NextPageToken = objects[len(objects)-1].Path
+	}
+	writeJSON(w, response)
+}
+
+// CatalogUsage returns the number and size of the objects each tenant wrote
+// GET /api/v1/admin/catalog/usage
+func (h *StorageHandler) CatalogUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := h.service.CatalogUsage(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to query catalog: "+err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, usage)
+}
+
+// CatalogChanges lists the recorded writes and deletions, newest first
+// GET /api/v1/admin/catalog/changes?path=...&tenant=...&since=2026-01-02T15:04:05Z&limit=100&page_token=...
+func (h *StorageHandler) CatalogChanges(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, ok := catalogLimit(w, r)
+	if !ok {
+		return
+	}
+
+	changesQuery := catalog.ChangeQuery{Path: query.Get("path"), Tenant: query.Get("tenant"), Limit: limit}
+	if value := query.Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			http.Error(w, "Invalid since: must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		changesQuery.Since = since
+	}
+	// Page tokens are the id of the last change of the previous page
+	if token := query.Get("page_token"); token != "" {
+		id, err := strconv.ParseInt(token, 10, 64)
+		if err != nil || id < 1 {
+			http.Error(w, "Invalid page_token", http.StatusBadRequest)
+			return
+		}
+		changesQuery.Before = id
+	}
+
+	changes, err := h.service.CatalogChanges(r.Context(), changesQuery)
+	if err != nil {
+		http.Error(w, "Failed to query catalog: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	response := catalogChangesResponse{Changes: changes}
+	if len(changes) == limit {
+		response.NextPageToken = strconv.FormatInt(changes[len(changes)-1].ID, 10)
+	}
+	writeJSON(w, response)
+}
+
+// catalogLimit parses the limit parameter, answering the request if it is invalid
+func catalogLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultCatalogLimit, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxCatalogLimit {
+		http.Error(w, fmt.Sprintf("Invalid limit: must be between 1 and %d", maxCatalogLimit), http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/dto"
+)
+
+// DownloadPaths are the routes CDN tokens may grant read access below
+var DownloadPaths = []string{"/api/v1/storage/files/", "/api/v1/storage/stream/", "/api/v1/storage/posters/"}
+
+// WithCDNTokens enables minting CDN tokens valid for ttl by default and at most maxTTL.
+// baseURL is prepended to prefixes given as paths, since Cloud CDN needs absolute prefixes.
+func WithCDNTokens(signer *auth.CDNSigner, ttl, maxTTL time.Duration, baseURL string) Option {
+	return func(h *StorageHandler) {
+		h.cdnSigner = signer
+		h.cdnTTL = ttl
+		h.cdnMaxTTL = maxTTL
+		h.cdnBaseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// cdnTokenResponse is a minted token as a cookie value and as signed URL parameters
+type cdnTokenResponse struct {
+	Prefix  string    `json:"prefix"`
+	Expires time.Time `json:"expires"`
+	Cookie  string    `json:"cookie"`
+	Query   string    `json:"query"`
+}
+
+// CreateCDNToken mints a token granting read access to every URL under a prefix
+// POST /api/v1/storage/cdn-tokens
+// Body: {"prefix": "/api/v1/storage/stream/videos/a/", "ttl": "1h"}
+func (h *StorageHandler) CreateCDNToken(w http.ResponseWriter, r *http.Request) {
+	if h.cdnSigner == nil {
+		http.Error(w, "CDN tokens require CDN_SIGNING_KEY", http.StatusNotImplemented)
+		return
+	}
+
+	request := dto.CDNToken{MaxTTL: h.cdnMaxTTL}
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	ttl := request.Lifetime(h.cdnTTL)
+
+	prefix := request.Prefix
+	if strings.HasPrefix(prefix, "/") {
+		prefix = h.cdnBaseURL + prefix
+	}
+	token, err := h.cdnSigner.Sign(prefix, time.Now().Add(ttl))
+	if errors.Is(err, auth.ErrInvalidCDNToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to sign token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, cdnTokenResponse{
+		Prefix:  token.Prefix,
+		Expires: token.Expires,
+		Cookie:  token.Cookie,
+		Query:   token.Query,
+	})
+}
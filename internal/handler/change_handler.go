@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Page sizes of the change feed
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+)
+
+// Changes returns the changes after a cursor, oldest first
+// GET /api/v1/storage/changes?since={cursor}&limit=100
+// since=latest returns the current cursor without changes
+func (h *StorageHandler) Changes(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultChangesLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxChangesLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit: must be between 1 and %d", maxChangesLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	page, err := h.service.Changes(r.Context(), query.Get("since"), limit)
+	if err != nil {
+		http.Error(w, "Failed to read changes: "+err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, page)
+}
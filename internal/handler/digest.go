@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// setDigest sets the SHA-256 and MD5 of content, the whole representation a
+// response serves, as Repr-Digest (RFC 9530) and as the older Digest field
+// (RFC 3230) still checked by some clients. Both are hashed in a single pass.
+// Range responses carry the digest of the whole representation too, so
+// resumed downloads can be checked once complete.
+func setDigest(header http.Header, content []byte) {
+	sha, md := sha256.New(), md5.New()
+	io.MultiWriter(sha, md).Write(content)
+	shaSum := base64.StdEncoding.EncodeToString(sha.Sum(nil))
+	mdSum := base64.StdEncoding.EncodeToString(md.Sum(nil))
+	header.Set("Repr-Digest", "sha-256=:"+shaSum+":, md5=:"+mdSum+":")
+	header.Set("Digest", "SHA-256="+shaSum+",MD5="+mdSum)
+}
+
+// setStoredDigest sets the MD5 GCS keeps for an object as its Repr-Digest,
+// for range responses, which don't hold the whole representation to hash.
+// Objects the proxy compressed or encrypted, and composite objects, have no
+// MD5 of the content served.
+func setStoredDigest(header http.Header, metadata storage.FileMetadata) {
+	if metadata.MD5 == "" || metadata.ContentEncoding != "" || metadata.Envelope {
+		return
+	}
+	sum, err := hex.DecodeString(metadata.MD5)
+	if err != nil {
+		return
+	}
+	mdSum := base64.StdEncoding.EncodeToString(sum)
+	header.Set("Repr-Digest", "md5=:"+mdSum+":")
+	header.Set("Digest", "MD5="+mdSum)
+}
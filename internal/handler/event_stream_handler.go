@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// eventStreamHeartbeat keeps idle streams open through proxies and load balancers
+const eventStreamHeartbeat = 30 * time.Second
+
+// StreamEvents streams object events as server-sent events until the client disconnects
+// GET /api/v1/storage/events?prefix=videos/&prefix=photos/
+func (h *StorageHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	subscription, err := h.service.SubscribeEvents(r.Context(), r.URL.Query()["prefix"])
+	if err != nil {
+		http.Error(w, "Failed to subscribe to events: "+err.Error(), errorStatus(err))
+		return
+	}
+	defer subscription.Close()
+
+	// The stream outlives any server write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event, ok := <-subscription.Events:
+			if !ok {
+				// The client fell behind; EventSource reconnects by itself
+				return
+			}
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// fieldSelection holds the file properties requested with ?fields=name,size.
+// Names are compared in lower case without underscores, so content_type also
+// selects the ContentType of responses using Go's default field names.
+type fieldSelection map[string]bool
+
+// parseFields reads ?fields= and checks the names against the properties of
+// file, the type of the files in the response. A nil selection keeps everything.
+func parseFields(r *http.Request, file any) (fieldSelection, error) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return nil, nil
+	}
+
+	known := make(map[string]bool)
+	t := reflect.TypeOf(file)
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		known[normalizeField(name)] = true
+	}
+
+	fields := make(fieldSelection)
+	for _, name := range strings.Split(value, ",") {
+		key := normalizeField(name)
+		if !known[key] {
+			return nil, fmt.Errorf("unknown field %q", strings.TrimSpace(name))
+		}
+		fields[key] = true
+	}
+	return fields, nil
+}
+
+// namesOnly reports whether only the names of the files were requested
+func (f fieldSelection) namesOnly() bool {
+	return len(f) == 1 && f["name"]
+}
+
+func normalizeField(name string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), "_", ""))
+}
+
+// writeJSONFields writes v like writeJSON, keeping only the selected properties
+// of the objects in its files array
+func writeJSONFields(w http.ResponseWriter, v any, fields fieldSelection) {
+	if fields == nil {
+		writeJSON(w, v)
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		http.Error(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for key, value := range body {
+		if normalizeField(key) != "files" {
+			continue
+		}
+		var files []map[string]json.RawMessage
+		if err := json.Unmarshal(value, &files); err != nil {
+			continue
+		}
+		for _, file := range files {
+			fields.filter(file)
+		}
+		body[key], _ = json.Marshal(files)
+	}
+	writeJSON(w, body)
+}
+
+// selectFields returns the selected properties of a single file
+func selectFields(file any, fields fieldSelection) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+	var properties map[string]json.RawMessage
+	if err := json.Unmarshal(data, &properties); err != nil {
+		return nil, err
+	}
+	fields.filter(properties)
+	return properties, nil
+}
+
+func (f fieldSelection) filter(properties map[string]json.RawMessage) {
+	for property := range properties {
+		if !f[normalizeField(property)] {
+			delete(properties, property)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gcp-proxy-mity/internal/health"
+	"gcp-proxy-mity/internal/maintenance"
+	"gcp-proxy-mity/internal/openapi"
+	"gcp-proxy-mity/internal/version"
+)
+
+type HealthHandler struct {
+	readiness   *health.Checker
+	maintenance *maintenance.Mode
+}
+
+func NewHealthHandler(readiness *health.Checker, maintenance *maintenance.Mode) *HealthHandler {
+	return &HealthHandler{
+		readiness:   readiness,
+		maintenance: maintenance,
+	}
+}
+
+// Liveness reports that the process is running, degraded during maintenance
+// GET /healthz
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	if h.maintenance.Enabled() {
+		writeHealth(w, http.StatusOK, "degraded", "down for maintenance")
+		return
+	}
+	writeHealth(w, http.StatusOK, "ok", "")
+}
+
+// Readiness reports whether the storage backend is reachable, failing during
+// maintenance so load balancers stop sending traffic
+// GET /readyz
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	if h.maintenance.Enabled() {
+		writeHealth(w, http.StatusServiceUnavailable, "maintenance", "down for maintenance")
+		return
+	}
+	if err := h.readiness.Check(r.Context()); err != nil {
+		writeHealth(w, http.StatusServiceUnavailable, "unavailable", err.Error())
+		return
+	}
+	writeHealth(w, http.StatusOK, "ok", "")
+}
+
+// Version reports the build of the running binary
+// GET /version
+func (h *HealthHandler) Version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(version.Get())
+}
+
+// healthStatus is the body of the probe responses
+type healthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func writeHealth(w http.ResponseWriter, status int, state, errMsg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(healthStatus{state, errMsg})
+}
+
+func (h *HealthHandler) SetupRoutes(router *Router) {
+	router.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}, openapi.Operation{
+		ID:        "health",
+		Tag:       "health",
+		Summary:   "Report that the process is running (legacy)",
+		Responses: []openapi.Response{{Status: http.StatusOK, Description: "OK", Body: &openapi.Body{ContentType: "text/plain", Schema: ""}}},
+		Public:    true,
+	})
+	router.HandleFunc("GET /healthz", h.Liveness, openapi.Operation{
+		ID:        "liveness",
+		Tag:       "health",
+		Summary:   "Report that the process is running",
+		Responses: []openapi.Response{openapi.JSONResponse("The process is running, with status degraded during maintenance", healthStatus{})},
+		Public:    true,
+	})
+	router.HandleFunc("GET /readyz", h.Readiness, openapi.Operation{
+		ID:      "readiness",
+		Tag:     "health",
+		Summary: "Report whether the storage backend is reachable",
+		Responses: []openapi.Response{
+			openapi.JSONResponse("The backend is reachable", healthStatus{}),
+			{Status: http.StatusServiceUnavailable, Description: "The backend is unreachable, or maintenance mode is on", Body: openapi.JSONBody(healthStatus{})},
+		},
+		Public: true,
+	})
+	router.HandleFunc("GET /version", h.Version, openapi.Operation{
+		ID:        "version",
+		Tag:       "health",
+		Summary:   "Report the version, commit and build of the running binary",
+		Responses: []openapi.Response{openapi.JSONResponse("Build information", version.Info{})},
+		Public:    true,
+	})
+}
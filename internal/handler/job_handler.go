@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/dto"
+	"gcp-proxy-mity/internal/jobs"
+	"gcp-proxy-mity/internal/openapi"
+	"gcp-proxy-mity/internal/service"
+)
+
+type JobHandler struct {
+	service *service.JobService
+}
+
+func NewJobHandler(service *service.JobService) *JobHandler {
+	return &JobHandler{
+		service: service,
+	}
+}
+
+// CreateJob queues a bulk operation
+// POST /api/v1/jobs
+func (h *JobHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
+	var request dto.Job
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	if err := h.service.Authorize(r.Context(), request.Spec); err != nil {
+		http.Error(w, "Failed to create job: "+err.Error(), errorStatus(err))
+		return
+	}
+	job, err := h.service.Create(r.Context(), request.Spec)
+	writeCreatedJob(w, job, err)
+}
+
+// CollectGarbage queues a gc job deleting expired temporary objects right away
+// POST /api/v1/admin/gc
+func (h *JobHandler) CollectGarbage(w http.ResponseWriter, r *http.Request) {
+	job, err := h.service.Create(r.Context(), jobs.Spec{Type: service.JobGC})
+	writeCreatedJob(w, job, err)
+}
+
+// CreateTransfer queues a job copying objects between configured buckets
+// POST /api/v1/admin/transfers
+// Body: {"source_bucket": "default", "destination_bucket": "archive", "prefix": "videos/"}
+func (h *JobHandler) CreateTransfer(w http.ResponseWriter, r *http.Request) {
+	var request dto.Transfer
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	job, err := h.service.Transfer(r.Context(), request.Spec())
+	writeCreatedJob(w, job, err)
+}
+
+// writeCreatedJob answers with a queued job, or the error that prevented queueing it
+func writeCreatedJob(w http.ResponseWriter, job *jobs.Job, err error) {
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, service.ErrInvalidJob), errors.Is(err, jobs.ErrUnknownJobType):
+			status = http.StatusBadRequest
+		case errors.Is(err, jobs.ErrQueueFull):
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, "Failed to create job: "+err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetJob reports the progress of a job
+// GET /api/v1/jobs/{id}
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "Job ID is required", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.service.Get(id)
+	if err != nil {
+		if errors.Is(err, jobs.ErrJobNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to get job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(job)
+}
+
+func (h *JobHandler) SetupRoutes(router *Router) {
+	router.HandleFunc("POST /api/v1/jobs", h.CreateJob, openapi.Operation{
+		ID:        "createJob",
+		Tag:       "jobs",
+		Summary:   "Queue a bulk operation",
+		Request:   openapi.JSONBody(dto.Job{}),
+		Responses: []openapi.Response{{Status: http.StatusAccepted, Description: "The queued job", Body: openapi.JSONBody(jobs.Job{})}},
+		Errors:    []int{http.StatusBadRequest, http.StatusForbidden, http.StatusServiceUnavailable},
+	})
+	router.HandleFunc("GET /api/v1/jobs/{id}", h.GetJob, openapi.Operation{
+		ID:        "getJob",
+		Tag:       "jobs",
+		Summary:   "Get the progress of a job",
+		Params:    []openapi.Param{openapi.PathParam("id", "Job ID")},
+		Responses: []openapi.Response{openapi.JSONResponse("The job", jobs.Job{})},
+		Errors:    []int{http.StatusNotFound},
+	})
+	router.Handle("POST /api/v1/admin/transfers", auth.RequireScope(auth.ScopeAdmin, http.HandlerFunc(h.CreateTransfer)), openapi.Operation{
+		ID:          "createTransfer",
+		Tag:         "admin",
+		Summary:     "Copy objects between configured buckets",
+		Description: "Queues a transfer job; poll GET /api/v1/jobs/{id} for progress. The served bucket is named default.",
+		Request:     openapi.JSONBody(dto.Transfer{}),
+		Responses:   []openapi.Response{{Status: http.StatusAccepted, Description: "The queued job", Body: openapi.JSONBody(jobs.Job{})}},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusServiceUnavailable},
+	})
+	router.Handle("POST /api/v1/admin/gc", auth.RequireScope(auth.ScopeAdmin, http.HandlerFunc(h.CollectGarbage)), openapi.Operation{
+		ID:          "collectGarbage",
+		Tag:         "admin",
+		Summary:     "Delete expired temporary objects now",
+		Description: "Queues a gc job; the job also runs every GC_INTERVAL.",
+		Responses:   []openapi.Response{{Status: http.StatusAccepted, Description: "The queued job", Body: openapi.JSONBody(jobs.Job{})}},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusServiceUnavailable},
+	})
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// leaseTTL parses the ttl query parameter, zero for the default duration
+func leaseTTL(r *http.Request) (time.Duration, bool) {
+	value := r.URL.Query().Get("ttl")
+	if value == "" {
+		return 0, true
+	}
+	ttl, err := time.ParseDuration(value)
+	return ttl, err == nil && ttl > 0
+}
+
+// GetLease returns the current lease on a path
+// GET /api/v1/storage/leases/{filePath}
+func (h *StorageHandler) GetLease(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	lease, err := h.service.GetLease(r.Context(), filePath)
+	if err != nil {
+		http.Error(w, "Failed to get lease: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, lease)
+}
+
+// AcquireLease leases a path to the caller
+// POST /api/v1/storage/leases/{filePath}?ttl=60s
+func (h *StorageHandler) AcquireLease(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+	ttl, ok := leaseTTL(r)
+	if !ok {
+		http.Error(w, "Invalid ttl: must be a duration like 60s", http.StatusBadRequest)
+		return
+	}
+
+	lease, err := h.service.AcquireLease(r.Context(), filePath, ttl)
+	if err != nil {
+		http.Error(w, "Failed to acquire lease: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(lease)
+}
+
+// RenewLease extends the lease named by X-Lease-ID
+// PUT /api/v1/storage/leases/{filePath}?ttl=60s
+func (h *StorageHandler) RenewLease(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	id := r.Header.Get("X-Lease-ID")
+	if filePath == "" || id == "" {
+		http.Error(w, "File path and X-Lease-ID are required", http.StatusBadRequest)
+		return
+	}
+	ttl, ok := leaseTTL(r)
+	if !ok {
+		http.Error(w, "Invalid ttl: must be a duration like 60s", http.StatusBadRequest)
+		return
+	}
+
+	lease, err := h.service.RenewLease(r.Context(), filePath, id, ttl)
+	if err != nil {
+		http.Error(w, "Failed to renew lease: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, lease)
+}
+
+// ReleaseLease ends the lease named by X-Lease-ID
+// DELETE /api/v1/storage/leases/{filePath}
+func (h *StorageHandler) ReleaseLease(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	id := r.Header.Get("X-Lease-ID")
+	if filePath == "" || id == "" {
+		http.Error(w, "File path and X-Lease-ID are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.ReleaseLease(r.Context(), filePath, id); err != nil {
+		http.Error(w, "Failed to release lease: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Sizes of the parts of a log returned by one read
+const (
+	defaultLogReadLimit = 1 << 20
+	maxLogReadLimit     = 16 << 20
+)
+
+// AppendLog adds the request body as a record to the end of a log
+// POST /api/v1/storage/logs/{logPath}
+func (h *StorageHandler) AppendLog(w http.ResponseWriter, r *http.Request) {
+	logPath := r.PathValue("path")
+	if logPath == "" {
+		http.Error(w, "Log path is required", http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxUploadBytes))
+	if err != nil {
+		http.Error(w, "Failed to read record: "+err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	record, err := h.service.AppendLog(r.Context(), logPath, data)
+	if err != nil {
+		http.Error(w, "Failed to append to log: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, record)
+}
+
+// ReadLog returns the bytes of a log from an offset
+// GET /api/v1/storage/logs/{logPath}?offset=0&limit=1048576
+// X-Log-Next-Offset is where to continue reading, X-Log-Size is the length of the log
+func (h *StorageHandler) ReadLog(w http.ResponseWriter, r *http.Request) {
+	logPath := r.PathValue("path")
+	if logPath == "" {
+		http.Error(w, "Log path is required", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	var offset int64
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	limit := int64(defaultLogReadLimit)
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 1 || n > maxLogReadLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit: must be between 1 and %d", maxLogReadLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	segment, err := h.service.ReadLog(r.Context(), logPath, offset, limit)
+	if err != nil {
+		http.Error(w, "Failed to read log: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(segment.Data)))
+	w.Header().Set("X-Log-Offset", strconv.FormatInt(segment.Offset, 10))
+	w.Header().Set("X-Log-Next-Offset", strconv.FormatInt(segment.Next, 10))
+	w.Header().Set("X-Log-Size", strconv.FormatInt(segment.Size, 10))
+	w.WriteHeader(http.StatusOK)
+	w.Write(segment.Data)
+}
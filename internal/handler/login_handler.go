@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"gcp-proxy-mity/internal/oidc"
+	"gcp-proxy-mity/internal/openapi"
+)
+
+// loginPrefix is where the routes of the login flow live
+const loginPrefix = "/auth/"
+
+// LoginHandler logs users in with OpenID Connect and reports their session.
+// Its routes need no credentials.
+type LoginHandler struct {
+	login *oidc.Login
+}
+
+func NewLoginHandler(login *oidc.Login) *LoginHandler {
+	return &LoginHandler{
+		login: login,
+	}
+}
+
+// GetSession returns the session of the logged-in user
+// GET /auth/session
+func (h *LoginHandler) GetSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := h.login.Current(r)
+	if !ok {
+		http.Error(w, "Unauthorized: not logged in", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, session)
+}
+
+// Anonymous reports whether the request is part of the login flow, so it can
+// be let through without credentials
+func (h *LoginHandler) Anonymous(router *Router) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		_, route, _ := strings.Cut(router.Pattern(r), " ")
+		return strings.HasPrefix(route, loginPrefix)
+	}
+}
+
+func (h *LoginHandler) SetupRoutes(router *Router) {
+	router.HandleFunc("GET "+loginPrefix+"login", h.login.Start, openapi.Operation{
+		ID:          "login",
+		Tag:         "auth",
+		Summary:     "Log in with the identity provider",
+		Description: "Redirects to the identity provider, and after the login back to the relative URL in next with a session cookie.",
+		Params:      []openapi.Param{openapi.QueryParam("next", "Relative URL to return to after the login", "")},
+		Responses:   []openapi.Response{{Status: http.StatusFound, Description: "Redirect to the identity provider"}},
+		Public:      true,
+	})
+	router.HandleFunc("GET "+loginPrefix+"callback", h.login.Callback, openapi.Operation{
+		ID:          "loginCallback",
+		Tag:         "auth",
+		Summary:     "Complete a login",
+		Description: "The identity provider redirects here. Identities that aren't granted a role are refused with 403.",
+		Params:      []openapi.Param{openapi.QueryParam("code", "Authorization code", ""), openapi.QueryParam("state", "State of the login", "")},
+		Responses:   []openapi.Response{{Status: http.StatusFound, Description: "Redirect to the page the login started from, with the session cookie"}},
+		Errors:      []int{http.StatusUnauthorized, http.StatusForbidden},
+		Public:      true,
+	})
+	router.HandleFunc("POST "+loginPrefix+"logout", h.login.Logout, openapi.Operation{
+		ID:        "logout",
+		Tag:       "auth",
+		Summary:   "Log out",
+		Responses: []openapi.Response{{Status: http.StatusNoContent, Description: "The session cookie is cleared"}},
+		Public:    true,
+	})
+	router.HandleFunc("GET "+loginPrefix+"session", h.GetSession, openapi.Operation{
+		ID:        "getSession",
+		Tag:       "auth",
+		Summary:   "Get the session of the logged-in user",
+		Responses: []openapi.Response{openapi.JSONResponse("Session", oidc.Session{})},
+		Errors:    []int{http.StatusUnauthorized},
+		Public:    true,
+	})
+}
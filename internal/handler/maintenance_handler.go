@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net/http"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/dto"
+	"gcp-proxy-mity/internal/maintenance"
+	"gcp-proxy-mity/internal/openapi"
+)
+
+// MaintenancePath turns maintenance mode on and off, so it is served during maintenance
+const MaintenancePath = "/api/v1/admin/maintenance"
+
+// MaintenanceHandler toggles maintenance mode. All routes require the admin scope.
+type MaintenanceHandler struct {
+	mode *maintenance.Mode
+}
+
+func NewMaintenanceHandler(mode *maintenance.Mode) *MaintenanceHandler {
+	return &MaintenanceHandler{
+		mode: mode,
+	}
+}
+
+// GetMaintenance reports whether maintenance mode is on
+// GET /api/v1/admin/maintenance
+func (h *MaintenanceHandler) GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.mode.State())
+}
+
+// SetMaintenance turns maintenance mode on or off
+// PUT /api/v1/admin/maintenance with {"enabled": true, "message": "..."}
+func (h *MaintenanceHandler) SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var request dto.Maintenance
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	if !request.Enabled {
+		writeJSON(w, h.mode.Disable())
+		return
+	}
+	writeJSON(w, h.mode.Enable(request.Message, auth.PrincipalFromContext(r.Context())))
+}
+
+func (h *MaintenanceHandler) SetupRoutes(router *Router) {
+	admin := func(fn http.HandlerFunc) http.Handler {
+		return auth.RequireScope(auth.ScopeAdmin, fn)
+	}
+
+	router.Handle("GET "+MaintenancePath, admin(h.GetMaintenance), openapi.Operation{
+		ID:        "getMaintenance",
+		Tag:       "admin",
+		Summary:   "Report whether maintenance mode is on",
+		Responses: []openapi.Response{openapi.JSONResponse("Maintenance mode", maintenance.State{})},
+		Errors:    []int{http.StatusForbidden},
+	})
+	router.Handle("PUT "+MaintenancePath, admin(h.SetMaintenance), openapi.Operation{
+		ID:          "setMaintenance",
+		Tag:         "admin",
+		Summary:     "Turn maintenance mode on or off",
+		Description: "While maintenance mode is on, this replica answers every request but the health probes, metrics and this route with 503 Service Unavailable, Retry-After and the maintenance page, and /readyz fails so load balancers drain it.",
+		Request:     openapi.JSONBody(dto.Maintenance{}),
+		Responses:   []openapi.Response{openapi.JSONResponse("Maintenance mode", maintenance.State{})},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden},
+	})
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/dto"
+	"gcp-proxy-mity/internal/mount"
+	"gcp-proxy-mity/internal/openapi"
+	"gcp-proxy-mity/internal/service"
+)
+
+// MountHandler serves the mount table API. All routes require the admin scope.
+type MountHandler struct {
+	service *service.MountService
+}
+
+func NewMountHandler(service *service.MountService) *MountHandler {
+	return &MountHandler{
+		service: service,
+	}
+}
+
+type mountsResponse struct {
+	Mounts []mount.Mount `json:"mounts"`
+}
+
+// ListMounts lists the mounts, static ones from the configuration included
+// GET /api/v1/admin/mounts
+func (h *MountHandler) ListMounts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, mountsResponse{Mounts: h.service.ListMounts()})
+}
+
+// CreateMount mounts a bucket or directory at a prefix
+// POST /api/v1/admin/mounts
+// Body: {"prefix": "videos/", "target": "gs://videos", "quota_bytes": 1099511627776, "cache": true, "scope": "media"}
+func (h *MountHandler) CreateMount(w http.ResponseWriter, r *http.Request) {
+	var request dto.Mount
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	created, err := h.service.CreateMount(r.Context(), request.Mount())
+	if err != nil {
+		http.Error(w, "Failed to create mount: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/admin/mounts/"+created.Prefix)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// DeleteMount removes a mount; its files stay in its backend
+// DELETE /api/v1/admin/mounts/{prefix}
+func (h *MountHandler) DeleteMount(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.DeleteMount(r.Context(), r.PathValue("prefix")); err != nil {
+		http.Error(w, "Failed to delete mount: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *MountHandler) SetupRoutes(router *Router) {
+	admin := func(fn http.HandlerFunc) http.Handler {
+		return auth.RequireScope(auth.ScopeAdmin, fn)
+	}
+
+	router.Handle("GET /api/v1/admin/mounts", admin(h.ListMounts), openapi.Operation{
+		ID:        "listMounts",
+		Tag:       "admin",
+		Summary:   "List the mounts",
+		Responses: []openapi.Response{openapi.JSONResponse("Mounts", mountsResponse{})},
+		Errors:    []int{http.StatusForbidden},
+	})
+	router.Handle("POST /api/v1/admin/mounts", admin(h.CreateMount), openapi.Operation{
+		ID:          "createMount",
+		Tag:         "admin",
+		Summary:     "Mount a bucket or directory at a prefix",
+		Description: "Stores the files under the prefix in the target, gs://bucket or file:///path, with an optional quota, read caching, scope required of API keys and read-only flag. The mount is kept in the catalog when it is configured and picked up by every replica.",
+		Request:     openapi.JSONBody(dto.Mount{}),
+		Responses:   []openapi.Response{{Status: http.StatusCreated, Description: "The mount", Body: openapi.JSONBody(mount.Mount{})}},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusConflict},
+	})
+	router.Handle("DELETE /api/v1/admin/mounts/{prefix...}", admin(h.DeleteMount), openapi.Operation{
+		ID:        "deleteMount",
+		Tag:       "admin",
+		Summary:   "Remove a mount",
+		Params:    []openapi.Param{openapi.PathParam("prefix", "Prefix of the mount, which may contain slashes")},
+		Responses: []openapi.Response{{Status: http.StatusNoContent, Description: "The mount was removed"}},
+		Errors:    []int{http.StatusForbidden, http.StatusNotFound, http.StatusConflict},
+	})
+}
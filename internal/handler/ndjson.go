@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ndjsonContentType is accepted by clients that want large responses streamed
+// as one JSON object per line instead of a single document
+const ndjsonContentType = "application/x-ndjson"
+
+// acceptsNDJSON reports whether the Accept header asks for newline-delimited JSON
+func acceptsNDJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			mediaType, _, _ = strings.Cut(mediaType, ";")
+			if strings.TrimSpace(mediaType) == ndjsonContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// streamError is the last line of a stream that failed after it started
+type streamError struct {
+	Error string `json:"error"`
+}
+
+// ndjsonStream writes one JSON object per line, flushing each line as it is
+// produced. The status is only sent with the first line, so errors before it
+// are still answered with a proper status.
+type ndjsonStream struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	fields  fieldSelection
+	started bool
+}
+
+func newNDJSONStream(w http.ResponseWriter, fields fieldSelection) *ndjsonStream {
+	return &ndjsonStream{
+		w:      w,
+		rc:     http.NewResponseController(w),
+		fields: fields,
+	}
+}
+
+// file writes a file, keeping only the selected fields
+func (s *ndjsonStream) file(v any) error {
+	if s.fields == nil {
+		return s.write(v)
+	}
+	selected, err := selectFields(v, s.fields)
+	if err != nil {
+		return err
+	}
+	return s.write(selected)
+}
+
+func (s *ndjsonStream) write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if !s.started {
+		s.started = true
+		s.w.Header().Set("Content-Type", ndjsonContentType)
+		s.w.Header().Set("Cache-Control", "no-cache")
+		s.w.WriteHeader(http.StatusOK)
+	}
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// finish ends the stream. An error before the first line is answered with its
+// status, a later one as a final error line.
+func (s *ndjsonStream) finish(message string, err error) {
+	switch {
+	case err == nil && !s.started:
+		// An empty stream still has to send its headers
+		s.w.Header().Set("Content-Type", ndjsonContentType)
+		s.w.WriteHeader(http.StatusOK)
+	case err == nil:
+	case !s.started:
+		http.Error(s.w, message+err.Error(), errorStatus(err))
+	default:
+		s.write(streamError{Error: message + err.Error()})
+	}
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"gcp-proxy-mity/internal/policy"
+)
+
+// objectRoutePrefix is where routes naming an object in their URL live
+const objectRoutePrefix = "/api/v1/storage/"
+
+// ObjectAccess returns what a request does to objects, for authorizing it by
+// path: the object path or listing prefix it names in its URL, "" for
+// requests that may touch any object, and whether it writes
+func ObjectAccess(router *Router) func(*http.Request) (path string, write bool) {
+	return func(r *http.Request) (string, bool) {
+		write := r.Method != http.MethodGet && r.Method != http.MethodHead
+		switch pattern := router.Pattern(r); pattern {
+		case "GET /api/v1/storage/list", "GET /api/v1/storage/trash", "GET /api/v1/storage/usage/prefix":
+			return r.URL.Query().Get("prefix"), false
+		case "POST /api/v1/storage/files/read":
+			return "", false
+		case "GET /api/v1/storage/ws":
+			// WebSocket sessions upload as well as download
+			return "", true
+		default:
+			_, route, _ := strings.Cut(pattern, " ")
+			prefix, ok := strings.CutSuffix(route, "{path...}")
+			if !ok || !strings.HasPrefix(prefix, objectRoutePrefix) {
+				return "", write
+			}
+			path := strings.TrimPrefix(r.URL.Path, prefix)
+			if pattern == "POST /api/v1/storage/files/{path...}" {
+				// Compose, copy and restore act on the object before their suffix
+				for _, suffix := range []string{"/compose", "/copy", "/restore"} {
+					if trimmed, ok := strings.CutSuffix(path, suffix); ok {
+						path = trimmed
+						break
+					}
+				}
+			}
+			return path, write
+		}
+	}
+}
+
+// ObjectAction returns what a request does to objects, for evaluating the
+// access policy: read, write, delete or list, and the path like ObjectAccess.
+// Requests naming their objects in the body, and those queueing jobs, are
+// marked InBody; the service authorizes each of those objects. ok is false
+// for other requests outside the storage routes.
+func ObjectAction(router *Router) func(*http.Request) (target policy.Target, ok bool) {
+	access := ObjectAccess(router)
+	return func(r *http.Request) (policy.Target, bool) {
+		pattern := router.Pattern(r)
+		if pattern == "POST /api/v1/jobs" {
+			// Jobs are authorized by the objects they act on
+			return policy.Target{Action: policy.ActionWrite, InBody: true}, true
+		}
+		_, route, _ := strings.Cut(pattern, " ")
+		if !strings.HasPrefix(route, objectRoutePrefix) {
+			return policy.Target{}, false
+		}
+		path, write := access(r)
+		target := policy.Target{Action: policy.ActionRead, Path: path}
+		switch {
+		case pattern == "GET /api/v1/storage/list" || pattern == "GET /api/v1/storage/trash":
+			target.Action = policy.ActionList
+		case r.Method == http.MethodDelete:
+			target.Action = policy.ActionDelete
+		case write:
+			target.Action = policy.ActionWrite
+		}
+		switch pattern {
+		case "POST /api/v1/storage/files", "POST /api/v1/storage/files/raw", "POST /api/v1/storage/files/fetch",
+			"POST /api/v1/storage/files/read", "GET /api/v1/storage/ws", "POST /api/v1/storage/uploads",
+			"POST /api/v1/storage/shares":
+			target.InBody = true
+		}
+		return target, true
+	}
+}
+
+// RawUpload returns whether a request uploads one object with its body, for
+// tracking its progress, and the object path
+func RawUpload(router *Router) func(*http.Request) (path string, ok bool) {
+	access := ObjectAccess(router)
+	return func(r *http.Request) (string, bool) {
+		switch router.Pattern(r) {
+		case "PUT /api/v1/storage/files/{path...}":
+			path, _ := access(r)
+			return path, true
+		case "POST /api/v1/storage/files":
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+				return "", false
+			}
+			fallthrough
+		case "POST /api/v1/storage/files/raw":
+			if path := r.Header.Get("X-File-Path"); path != "" {
+				return path, true
+			}
+			return r.URL.Query().Get("path"), true
+		default:
+			return "", false
+		}
+	}
+}
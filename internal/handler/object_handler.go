@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/dto"
+	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// ListFiles lists the files under a prefix
+// GET /api/v1/storage/list?prefix=media/&delimiter=/&fields=name,size
+// With a delimiter, nested files are grouped into "prefixes" like directories.
+// With Accept: application/x-ndjson the listing is streamed line by line.
+func (h *StorageHandler) ListFiles(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	fields, err := parseFields(r, storage.FileMetadata{})
+	if err != nil {
+		http.Error(w, "Invalid fields: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if acceptsNDJSON(r) {
+		h.streamListing(w, r, fields)
+		return
+	}
+
+	list := h.service.List
+	if fields.namesOnly() {
+		list = h.service.ListNames
+	}
+	listing, err := list(r.Context(), query.Get("prefix"), query.Get("delimiter"))
+	if err != nil {
+		http.Error(w, "Failed to list files: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSONFields(w, listing, fields)
+}
+
+// listingPrefix is a line of a streamed listing standing in for the files below a common prefix
+type listingPrefix struct {
+	Prefix string `json:"prefix"`
+}
+
+// streamListing writes a listing as NDJSON, one file or common prefix per line
+// as the bucket listing is paged in
+func (h *StorageHandler) streamListing(w http.ResponseWriter, r *http.Request, fields fieldSelection) {
+	query := r.URL.Query()
+	stream := newNDJSONStream(w, fields)
+	err := h.service.WalkList(r.Context(), query.Get("prefix"), query.Get("delimiter"), func(entry service.ListingEntry) error {
+		if entry.File == nil {
+			return stream.write(listingPrefix{Prefix: entry.Prefix})
+		}
+		return stream.file(entry.File)
+	})
+	stream.finish("Failed to list files: ", err)
+}
+
+// DeleteFile deletes a single file
+// DELETE /api/v1/storage/files/{filePath}
+func (h *StorageHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.DeleteFile(r.Context(), filePath); err != nil {
+		http.Error(w, "Failed to delete file: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CopyFile copies a file within the bucket, replacing the destination if it exists
+// POST /api/v1/storage/files/{filePath}/copy
+// Body: {"destination": "archive/video.mp4"}
+func (h *StorageHandler) CopyFile(w http.ResponseWriter, r *http.Request) {
+	filePath := strings.TrimSuffix(r.PathValue("path"), "/copy")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	var request dto.Copy
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	metadata, err := h.service.CopyFile(r.Context(), filePath, request.Destination)
+	if err != nil {
+		http.Error(w, "Failed to copy file: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, metadata)
+}
+
+// RestoreFile moves a deleted file back from the trash
+// POST /api/v1/storage/files/{filePath}/restore?deleted_at=2026-01-02T15:04:05Z
+// Without deleted_at the latest deletion of the file is restored
+func (h *StorageHandler) RestoreFile(w http.ResponseWriter, r *http.Request) {
+	filePath := strings.TrimSuffix(r.PathValue("path"), "/restore")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	var deletedAt time.Time
+	if value := r.URL.Query().Get("deleted_at"); value != "" {
+		var err error
+		if deletedAt, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			http.Error(w, "Invalid deleted_at: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	metadata, err := h.service.RestoreFile(r.Context(), filePath, deletedAt)
+	if err != nil {
+		http.Error(w, "Failed to restore file: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, metadata)
+}
+
+// ListTrash lists deleted files that can still be restored
+// GET /api/v1/storage/trash?prefix=media/
+func (h *StorageHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	trashed, err := h.service.ListTrash(r.Context(), r.URL.Query().Get("prefix"))
+	if err != nil {
+		http.Error(w, "Failed to list trash: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, trashed)
+}
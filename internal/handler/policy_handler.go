@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/dto"
+	"gcp-proxy-mity/internal/openapi"
+	"gcp-proxy-mity/internal/policy"
+)
+
+// PolicyHandler shows the access policy and explains its decisions. All
+// routes require the admin scope.
+type PolicyHandler struct {
+	engine *policy.Engine
+}
+
+func NewPolicyHandler(engine *policy.Engine) *PolicyHandler {
+	return &PolicyHandler{
+		engine: engine,
+	}
+}
+
+// GetPolicy returns the rules in effect
+// GET /api/v1/admin/policy
+func (h *PolicyHandler) GetPolicy(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.engine.Policy())
+}
+
+// ExplainPolicy evaluates a request against the rules and tells which rule
+// decided it
+// POST /api/v1/admin/policy/explain with {"principal": "ci", "action": "write", "path": "reports/q3.pdf"}
+func (h *PolicyHandler) ExplainPolicy(w http.ResponseWriter, r *http.Request) {
+	var request dto.PolicyExplain
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	writeJSON(w, h.engine.Evaluate(request.Request()))
+}
+
+func (h *PolicyHandler) SetupRoutes(router *Router) {
+	admin := func(fn http.HandlerFunc) http.Handler {
+		return auth.RequireScope(auth.ScopeAdmin, fn)
+	}
+
+	router.Handle("GET /api/v1/admin/policy", admin(h.GetPolicy), openapi.Operation{
+		ID:        "getPolicy",
+		Tag:       "admin",
+		Summary:   "Get the access policy rules in effect",
+		Responses: []openapi.Response{openapi.JSONResponse("Access policy", policy.Policy{})},
+		Errors:    []int{http.StatusForbidden},
+	})
+	router.Handle("POST /api/v1/admin/policy/explain", admin(h.ExplainPolicy), openapi.Operation{
+		ID:          "explainPolicy",
+		Tag:         "admin",
+		Summary:     "Explain whether the access policy allows a request",
+		Description: "Evaluates the principal, scopes, action and path against the rules like a request to the storage routes, and returns the decision, the rule that made it and every rule that matched.",
+		Request:     openapi.JSONBody(dto.PolicyExplain{}),
+		Responses:   []openapi.Response{openapi.JSONResponse("Policy decision", policy.Decision{})},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden},
+	})
+}
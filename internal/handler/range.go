@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// readFileRange answers a Range request for a file with 206 Partial Content,
+// reading only the range from the bucket. It reports false when the whole file
+// must be served instead: the header asks for several ranges or can't be
+// parsed, or If-Range names a version of the file other than the current one.
+func (h *StorageHandler) readFileRange(w http.ResponseWriter, r *http.Request, filePath, disposition string) bool {
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" {
+		return false
+	}
+	metadata, err := h.service.StatFile(r.Context(), filePath)
+	if err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), errorStatus(err))
+		return true
+	}
+	offset, length, ok := parseRange(rangeHeader, metadata.Size)
+	if !ok || !ifRangeMatches(r.Header.Get("If-Range"), *metadata) {
+		return false
+	}
+	if length == 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", metadata.Size))
+		http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+
+	file, err := h.service.ReadRange(r.Context(), storage.ReadRange{Path: filePath, Offset: offset, Length: length})
+	if err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), errorStatus(err))
+		return true
+	}
+	// The object was replaced since it was checked against If-Range
+	if file.Metadata.Generation != metadata.Generation {
+		return false
+	}
+
+	header := w.Header()
+	setContentType(header, file.Metadata.ContentType)
+	setValidators(header, file.Metadata, "")
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(file.Content))-1, metadata.Size))
+	header.Set("Content-Length", strconv.Itoa(len(file.Content)))
+	setStoredDigest(header, file.Metadata)
+	header.Set("Content-Disposition", contentDisposition(disposition, r.URL.Query().Get("filename"), file.Metadata))
+	if cacheControl := h.service.CacheControl(filePath); cacheControl != "" {
+		header.Set("Cache-Control", cacheControl)
+	}
+	if file.Metadata.KMSKeyName != "" {
+		header.Set("X-KMS-Key-Name", file.Metadata.KMSKeyName)
+	}
+
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(file.Content)
+	return true
+}
+
+// parseRange parses a Range header asking for a single byte range of a file of
+// size bytes into where the range starts and how many bytes it covers, 0 when
+// it lies past the end of the file. ok is false for headers to ignore, like
+// those asking for several ranges, which are answered with the whole file.
+func parseRange(header string, size int64) (offset, length int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+	if first == "" {
+		// bytes=-n is the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		n = min(n, size)
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	if start >= size {
+		return start, 0, true
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, true
+}
+
+// ifRangeMatches reports whether an If-Range header, if any, names the current
+// version of a file, so a range of it can be served. An entity tag must be
+// strong and equal to the file's; a date must be exactly when it was updated.
+func ifRangeMatches(ifRange string, metadata storage.FileMetadata) bool {
+	switch {
+	case ifRange == "":
+		return true
+	case strings.HasPrefix(ifRange, `"`):
+		return ifRange == etag(metadata, "")
+	case strings.HasPrefix(ifRange, "W/"):
+		return false
+	}
+	date, err := http.ParseTime(ifRange)
+	return err == nil && !metadata.Updated.IsZero() && metadata.Updated.Truncate(time.Second).Equal(date)
+}
+
+// etag returns the strong entity tag of a file served with encoding, or
+// without one when encoding is empty: its MD5, or its generation for
+// composite objects. It is empty when the file has neither.
+func etag(metadata storage.FileMetadata, encoding string) string {
+	tag := metadata.MD5
+	if tag == "" && metadata.Generation != 0 {
+		tag = fmt.Sprintf("%x", metadata.Generation)
+	}
+	if tag == "" {
+		return ""
+	}
+	// The compressed and decompressed representations must not share a tag
+	if encoding != "" {
+		tag += "-" + encoding
+	}
+	return `"` + tag + `"`
+}
+
+// setValidators sets the ETag and Last-Modified of a file served with encoding
+func setValidators(header http.Header, metadata storage.FileMetadata, encoding string) {
+	if tag := etag(metadata, encoding); tag != "" {
+		header.Set("ETag", tag)
+	}
+	if !metadata.Updated.IsZero() {
+		header.Set("Last-Modified", metadata.Updated.UTC().Format(http.TimeFormat))
+	}
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"net/http"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// redirectFile answers a download under a redirect rule with 307 Temporary
+// Redirect to a signed URL of the bucket, so its bytes don't pass through the
+// proxy. The request was authorized and logged by then. It reports false when
+// the file must be served by the proxy instead.
+func (h *StorageHandler) redirectFile(w http.ResponseWriter, r *http.Request, filePath, disposition string) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	filename := r.URL.Query().Get("filename")
+	signedURL, err := h.service.DownloadURL(r.Context(), filePath, func(metadata storage.FileMetadata) string {
+		return contentDisposition(disposition, filename, metadata)
+	})
+	if err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), errorStatus(err))
+		return true
+	}
+	if signedURL == "" {
+		return false
+	}
+
+	// Caches must not keep the URL beyond its expiry
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, signedURL, http.StatusTemporaryRedirect)
+	return true
+}
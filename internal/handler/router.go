@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"gcp-proxy-mity/internal/middleware"
+	"gcp-proxy-mity/internal/openapi"
+)
+
+// Router routes requests by method and path pattern, runs them through its
+// middleware chain and records the operations it serves in the OpenAPI
+// document, so the document can't drift from the routes
+type Router struct {
+	mux        *http.ServeMux
+	spec       *openapi.Spec
+	middleware []middleware.Middleware
+	handler    http.Handler
+}
+
+func NewRouter(spec *openapi.Spec) *Router {
+	mux := http.NewServeMux()
+	return &Router{
+		mux:     mux,
+		spec:    spec,
+		handler: mux,
+	}
+}
+
+// Use appends middleware to the chain; the first middleware added is the
+// outermost. Requests that match no route pass through the chain as well.
+func (r *Router) Use(mw ...middleware.Middleware) {
+	r.middleware = append(r.middleware, mw...)
+	r.handler = middleware.Chain(r.mux, r.middleware...)
+}
+
+// Handle registers handler for a "METHOD /path" pattern and documents the
+// operations it serves. Operations without a method or path take them from
+// the pattern. Requests with a method no pattern allows get a 405.
+func (r *Router) Handle(pattern string, handler http.Handler, ops ...openapi.Operation) {
+	r.mux.Handle(pattern, handler)
+
+	method, path, _ := strings.Cut(pattern, " ")
+	for i := range ops {
+		if ops[i].Method == "" {
+			ops[i].Method = method
+		}
+		if ops[i].Path == "" {
+			ops[i].Path = strings.ReplaceAll(path, "...}", "}")
+		}
+	}
+	r.spec.Add(ops...)
+}
+
+// HandleFunc registers fn for pattern and documents the operations it serves
+func (r *Router) HandleFunc(pattern string, fn http.HandlerFunc, ops ...openapi.Operation) {
+	r.Handle(pattern, fn, ops...)
+}
+
+// Pattern returns the pattern of the route that serves the request, or "" if none does
+func (r *Router) Pattern(req *http.Request) string {
+	_, pattern := r.mux.Handler(req)
+	return pattern
+}
+
+// Spec returns the document the routes are recorded in
+func (r *Router) Spec() *openapi.Spec {
+	return r.spec
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.handler.ServeHTTP(w, req)
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/jobs"
+	"gcp-proxy-mity/internal/openapi"
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// TestSetupRoutes registers the routes of every handler on one router, like
+// the server does, so patterns registered twice fail here instead of at startup
+func TestSetupRoutes(t *testing.T) {
+	storageService := service.NewStorageService(nil)
+	router := NewRouter(openapi.New("test", "test"))
+	handlers := []interface{ SetupRoutes(*Router) }{
+		NewStorageHandler(storageService),
+		NewJobHandler(nil),
+		NewHealthHandler(nil, nil),
+		NewAdminHandler(nil),
+		NewMaintenanceHandler(nil),
+		NewMountHandler(nil),
+		NewShareHandler(storageService, ""),
+		NewUIHandler(),
+		NewSiteHandler(storageService, false),
+		NewPolicyHandler(nil),
+		NewTransferHandler(nil),
+		NewLoginHandler(nil),
+	}
+	for _, h := range handlers {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					t.Fatalf("Expected %T to register its routes, got panic: %v", h, err)
+				}
+			}()
+			h.SetupRoutes(router)
+		}()
+	}
+
+	ids := map[string]string{}
+	for _, op := range router.Spec().Operations() {
+		route := op.Method + " " + op.Path
+		if other, ok := ids[op.ID]; ok && op.ID != "" {
+			t.Errorf("Expected unique operation IDs, got %s for %s and %s", op.ID, other, route)
+		}
+		ids[op.ID] = route
+	}
+}
+
+// TestObjectAction_BodyPaths checks the access policy for the objects routes
+// name in their body, which the policy middleware can't see
+func TestObjectAction_BodyPaths(t *testing.T) {
+	backend := storage.NewFSStorage(t.TempDir())
+	if _, err := backend.WriteFiles(context.Background(), []storage.WriteRequest{
+		{Path: "public/a.txt", Content: strings.NewReader("a")},
+		{Path: "secret/x.txt", Content: strings.NewReader("x")},
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	router := NewRouter(openapi.New("test", "test"))
+	NewStorageHandler(service.NewStorageService(backend)).SetupRoutes(router)
+	NewJobHandler(service.NewJobService(backend, jobs.NewManager(1, 1, time.Minute), service.JobConfig{})).SetupRoutes(router)
+	engine := policy.New(ObjectAction(router))
+	if err := engine.SetRules("config", []policy.Rule{
+		{Principals: []string{"ci"}, Actions: []string{"*"}, Paths: []string{"**"}, Effect: policy.EffectAllow},
+		{Principals: []string{"ci"}, Actions: []string{policy.ActionRead}, Paths: []string{"secret/**"}, Effect: policy.EffectDeny},
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), "ci")))
+		})
+	}, engine.Middleware)
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		wantStatus int
+	}{
+		{name: "batch read allowed", method: http.MethodPost, url: "/api/v1/storage/files/read", body: `{"file_paths": ["public/a.txt"]}`, wantStatus: http.StatusOK},
+		{name: "batch read denied", method: http.MethodPost, url: "/api/v1/storage/files/read", body: `{"file_paths": ["public/a.txt", "secret/x.txt"]}`, wantStatus: http.StatusForbidden},
+		{name: "batch range read denied", method: http.MethodPost, url: "/api/v1/storage/files/read", body: `{"files": [{"path": "secret/x.txt", "length": 1}]}`, wantStatus: http.StatusForbidden},
+		{name: "single read denied", method: http.MethodGet, url: "/api/v1/storage/files/secret/x.txt", wantStatus: http.StatusForbidden},
+		{name: "copy of a denied source", method: http.MethodPost, url: "/api/v1/storage/files/secret/x.txt/copy", body: `{"destination": "public/x.txt"}`, wantStatus: http.StatusForbidden},
+		{name: "search of any object", method: http.MethodGet, url: "/api/v1/storage/search?q=x", wantStatus: http.StatusForbidden},
+		{name: "bulk read of a prefix", method: http.MethodPost, url: "/api/v1/jobs", body: `{"type": "bulk-read", "prefix": "s"}`, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
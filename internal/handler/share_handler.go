@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gcp-proxy-mity/internal/clientip"
+	"gcp-proxy-mity/internal/dto"
+	"gcp-proxy-mity/internal/openapi"
+	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/share"
+)
+
+// Routes serving share links without credentials
+const (
+	sharePattern       = "GET /s/{token}"
+	sharePrefixPattern = "GET /s/{token}/{path...}"
+)
+
+// SharePasswordHeader carries the password of password-protected share links
+const SharePasswordHeader = "X-Share-Password"
+
+// ShareHandler manages share links and serves their content
+type ShareHandler struct {
+	service *service.StorageService
+	// baseURL is prepended to the paths of share links
+	baseURL string
+}
+
+func NewShareHandler(service *service.StorageService, baseURL string) *ShareHandler {
+	return &ShareHandler{
+		service: service,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// shareResponse is a share with the link serving it
+type shareResponse struct {
+	share.Share
+	URL               string `json:"url"`
+	PasswordProtected bool   `json:"password_protected"`
+}
+
+type sharesResponse struct {
+	Shares []shareResponse `json:"shares"`
+}
+
+func (h *ShareHandler) response(r *http.Request, s share.Share) shareResponse {
+	base := h.baseURL
+	if base == "" {
+		base = clientip.FromRequest(r).Scheme + "://" + r.Host
+	}
+	link := base + "/s/" + s.Token
+	if s.Prefix {
+		link += "/"
+	}
+	return shareResponse{Share: s, URL: link, PasswordProtected: s.PasswordHash != ""}
+}
+
+// CreateShare creates a share link
+// POST /api/v1/storage/shares
+// Body: {"path": "reports/2026.pdf", "ttl": "72h", "max_downloads": 5, "password": "..."}
+func (h *ShareHandler) CreateShare(w http.ResponseWriter, r *http.Request) {
+	var request dto.Share
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	created, err := h.service.CreateShare(r.Context(), request.Request())
+	if err != nil {
+		http.Error(w, "Failed to create share: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h.response(r, *created))
+}
+
+// ListShares lists the caller's share links
+// GET /api/v1/storage/shares
+func (h *ShareHandler) ListShares(w http.ResponseWriter, r *http.Request) {
+	shares, err := h.service.ListShares(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to list shares: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	response := sharesResponse{Shares: make([]shareResponse, 0, len(shares))}
+	for _, s := range shares {
+		response.Shares = append(response.Shares, h.response(r, s))
+	}
+	writeJSON(w, response)
+}
+
+// RevokeShare deletes a share link before it expires
+// DELETE /api/v1/storage/shares/{token}
+func (h *ShareHandler) RevokeShare(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.RevokeShare(r.Context(), r.PathValue("token")); err != nil {
+		http.Error(w, "Failed to revoke share: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ServeShare serves the object of a share link, or a file under its prefix
+// GET /s/{token} and GET /s/{token}/{path}
+func (h *ShareHandler) ServeShare(w http.ResponseWriter, r *http.Request) {
+	password := r.Header.Get(SharePasswordHeader)
+	if password == "" {
+		password = r.URL.Query().Get("password")
+	}
+	// Only requests for the start of the content count as downloads, not
+	// HEAD requests or the later ranges of a resumed or streamed download
+	rangeHeader := r.Header.Get("Range")
+	count := r.Method == http.MethodGet && (rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-"))
+
+	file, err := h.service.ReadShare(r.Context(), r.PathValue("token"), r.PathValue("path"), password, count)
+	if err != nil {
+		http.Error(w, "Failed to read share: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	metadata := file.Metadata
+	if metadata.ContentType != "" {
+		setContentType(w.Header(), metadata.ContentType)
+	}
+	setValidators(w.Header(), metadata, "")
+	w.Header().Set("Content-Disposition", contentDisposition(r.URL.Query().Get("disposition"), "", metadata))
+	// Shared content must not outlive a revoked or expired link in shared caches
+	w.Header().Set("Cache-Control", "private, no-cache")
+	setDigest(w.Header(), file.Content)
+	http.ServeContent(w, r, metadata.Name, metadata.Updated, bytes.NewReader(file.Content))
+}
+
+// Anonymous reports whether a share link serves the request, so it can be
+// let through without credentials
+func (h *ShareHandler) Anonymous(router *Router) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		pattern := router.Pattern(r)
+		return pattern == sharePattern || pattern == sharePrefixPattern
+	}
+}
+
+func (h *ShareHandler) SetupRoutes(router *Router) {
+	tokenParam := openapi.PathParam("token", "Token of the share")
+
+	router.HandleFunc("POST /api/v1/storage/shares", h.CreateShare, openapi.Operation{
+		ID:          "createShare",
+		Tag:         "shares",
+		Summary:     "Create a share link",
+		Description: "Shares an object, or with prefix every object under a path, through a link that works without an API key until it expires, is revoked or runs out of downloads.",
+		Request:     openapi.JSONBody(dto.Share{}),
+		Responses:   []openapi.Response{{Status: http.StatusCreated, Description: "The share", Body: openapi.JSONBody(shareResponse{})}},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+	})
+	router.HandleFunc("GET /api/v1/storage/shares", h.ListShares, openapi.Operation{
+		ID:          "listShares",
+		Tag:         "shares",
+		Summary:     "List share links",
+		Description: "Lists the unexpired shares created with the caller's API key, or all shares for keys with the admin scope.",
+		Responses:   []openapi.Response{openapi.JSONResponse("The shares", sharesResponse{})},
+	})
+	router.HandleFunc("DELETE /api/v1/storage/shares/{token}", h.RevokeShare, openapi.Operation{
+		ID:        "revokeShare",
+		Tag:       "shares",
+		Summary:   "Revoke a share link",
+		Params:    []openapi.Param{tokenParam},
+		Responses: []openapi.Response{{Status: http.StatusNoContent, Description: "The share was revoked"}},
+		Errors:    []int{http.StatusNotFound},
+	})
+
+	passwordParam := openapi.HeaderParam(SharePasswordHeader, "Password of a password-protected share, or the password query parameter")
+	serveResponses := []openapi.Response{
+		{Status: http.StatusOK, Description: "File content", Body: openapi.BinaryBody("File content")},
+		{Status: http.StatusNotModified, Description: "The ETag in If-None-Match still matches"},
+	}
+	serveErrors := []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusGone}
+	router.HandleFunc(sharePattern, h.ServeShare, openapi.Operation{
+		ID:        "serveShare",
+		Tag:       "shares",
+		Summary:   "Download a shared file",
+		Params:    []openapi.Param{tokenParam, passwordParam},
+		Responses: serveResponses,
+		Errors:    serveErrors,
+		Public:    true,
+	})
+	router.HandleFunc(sharePrefixPattern, h.ServeShare, openapi.Operation{
+		ID:        "serveSharedPrefix",
+		Tag:       "shares",
+		Summary:   "Download a file under a shared prefix",
+		Params:    []openapi.Param{tokenParam, openapi.PathParam("path", "Path of the file below the shared prefix"), passwordParam},
+		Responses: serveResponses,
+		Errors:    serveErrors,
+		Public:    true,
+	})
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"gcp-proxy-mity/internal/openapi"
+	"gcp-proxy-mity/internal/service"
+)
+
+// sitePattern is the route serving the website; every more specific route,
+// like the API, takes precedence over it
+const sitePattern = "GET /{path...}"
+
+// SiteHandler serves a prefix of the bucket as a website
+type SiteHandler struct {
+	service *service.StorageService
+	// public sites are served without credentials
+	public bool
+}
+
+func NewSiteHandler(service *service.StorageService, public bool) *SiteHandler {
+	return &SiteHandler{
+		service: service,
+		public:  public,
+	}
+}
+
+// ServePage serves the website file for the request path
+// GET /{path}
+// Directories serve their index document, and conditional and range requests are answered from the object's ETag
+func (h *SiteHandler) ServePage(w http.ResponseWriter, r *http.Request) {
+	// Unknown API paths are not website paths, even with the SPA fallback
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	page, err := h.service.ReadSitePage(r.Context(), r.URL.Path)
+	if err != nil {
+		http.Error(w, "Failed to read page: "+err.Error(), errorStatus(err))
+		return
+	}
+	if page.Redirect != "" {
+		target := page.Redirect
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	metadata := page.File.Metadata
+	if metadata.ContentType != "" && metadata.ContentType != "application/octet-stream" {
+		setContentType(w.Header(), metadata.ContentType)
+	}
+	setValidators(w.Header(), metadata, "")
+	w.Header().Set("Cache-Control", page.CacheControl)
+	setDigest(w.Header(), page.File.Content)
+	// ServeContent detects the content type from the name when the object has none
+	http.ServeContent(w, r, metadata.Name, metadata.Updated, bytes.NewReader(page.File.Content))
+}
+
+// Anonymous reports whether a public site serves the request rather than
+// another route, so it can be let through without credentials
+func (h *SiteHandler) Anonymous(router *Router) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		return h.public && router.Pattern(r) == sitePattern && !strings.HasPrefix(r.URL.Path, "/api/")
+	}
+}
+
+func (h *SiteHandler) SetupRoutes(router *Router) {
+	router.HandleFunc(sitePattern, h.ServePage, openapi.Operation{
+		ID:          "serveSitePage",
+		Tag:         "site",
+		Summary:     "Serve a website file",
+		Description: "Serves the file under the site prefix, the index document of a directory, or with the SPA fallback the root index document.",
+		Params:      []openapi.Param{openapi.PathParam("path", "Website path")},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Description: "File content", Body: openapi.BinaryBody("File content")},
+			{Status: http.StatusMovedPermanently, Description: "Directory requested without its trailing slash"},
+			{Status: http.StatusNotModified, Description: "The ETag in If-None-Match still matches"},
+		},
+		Errors: []int{http.StatusNotFound},
+		Public: h.public,
+	})
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"gcp-proxy-mity/internal/stats"
+)
+
+// Page sizes of download statistics
+const (
+	defaultStatsLimit = 100
+	maxStatsLimit     = 1000
+)
+
+// statsResponse lists the download statistics of objects
+type statsResponse struct {
+	Objects []stats.Stats `json:"objects"`
+}
+
+// ListStats lists objects by downloads, bytes served or last access
+// GET /api/v1/storage/stats?prefix=videos/&sort=downloads&order=desc&limit=100
+func (h *StorageHandler) ListStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	statsQuery := stats.Query{Prefix: query.Get("prefix"), Sort: query.Get("sort"), Limit: defaultStatsLimit}
+	if statsQuery.Sort == "" {
+		statsQuery.Sort = stats.SortDownloads
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		statsQuery.Ascending = true
+	default:
+		http.Error(w, "order must be asc or desc", http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxStatsLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit: must be between 1 and %d", maxStatsLimit), http.StatusBadRequest)
+			return
+		}
+		statsQuery.Limit = n
+	}
+
+	objects, err := h.service.DownloadStats(r.Context(), statsQuery)
+	if err != nil {
+		http.Error(w, "Failed to read statistics: "+err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, statsResponse{Objects: objects})
+}
+
+// GetStats returns the download statistics of an object
+// GET /api/v1/storage/stats/{filePath}
+func (h *StorageHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	objectStats, err := h.service.ObjectStats(r.Context(), filePath)
+	if err != nil {
+		http.Error(w, "Failed to read statistics: "+err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, objectStats)
+}
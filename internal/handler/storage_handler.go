@@ -1,62 +1,124 @@
 package handler
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
-	"path/filepath"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"gcp-proxy-mity/internal/appendlog"
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/catalog"
+	"gcp-proxy-mity/internal/changes"
+	"gcp-proxy-mity/internal/dto"
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/imaging"
+	"gcp-proxy-mity/internal/lease"
+	"gcp-proxy-mity/internal/media"
+	"gcp-proxy-mity/internal/mount"
+	"gcp-proxy-mity/internal/openapi"
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/quota"
+	"gcp-proxy-mity/internal/search"
 	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/share"
+	"gcp-proxy-mity/internal/spool"
+	"gcp-proxy-mity/internal/stats"
 	"gcp-proxy-mity/internal/storage"
 )
 
 type StorageHandler struct {
-	service *service.StorageService
+	service            *service.StorageService
+	maxUploadBytes     int64
+	maxMultipartMemory int64
+	spooler            *spool.Spooler
+	urlSigner          *auth.URLSigner
+	urlTTL             time.Duration
+	baseURL            string
+	cdnSigner          *auth.CDNSigner
+	cdnTTL             time.Duration
+	cdnMaxTTL          time.Duration
+	cdnBaseURL         string
 }
 
-func NewStorageHandler(service *service.StorageService) *StorageHandler {
-	return &StorageHandler{
-		service: service,
+// Option configures a StorageHandler
+type Option func(*StorageHandler)
+
+// WithLimits sets the maximum raw upload size and the memory used to buffer multipart forms
+func WithLimits(maxUploadBytes, maxMultipartMemory int64) Option {
+	return func(h *StorageHandler) {
+		h.maxUploadBytes = maxUploadBytes
+		h.maxMultipartMemory = maxMultipartMemory
 	}
 }
 
-func (h *StorageHandler) WriteFiles(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// WithSpooler spools multipart uploads that outgrow the multipart memory to disk
+func WithSpooler(spooler *spool.Spooler) Option {
+	return func(h *StorageHandler) {
+		h.spooler = spooler
 	}
+}
 
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		http.Error(w, "Failed to parse multipart form: "+err.Error(), http.StatusBadRequest)
-		return
+// WithSignedURLs enables batch reads that return signed download URLs valid for ttl.
+// baseURL is prepended to the returned paths when set.
+func WithSignedURLs(signer *auth.URLSigner, ttl time.Duration, baseURL string) Option {
+	return func(h *StorageHandler) {
+		h.urlSigner = signer
+		h.urlTTL = ttl
+		h.baseURL = strings.TrimSuffix(baseURL, "/")
 	}
+}
 
-	var requests []storage.WriteRequest
-
-	for key, files := range r.MultipartForm.File {
-		for _, fileHeader := range files {
-			file, err := fileHeader.Open()
-			if err != nil {
-				http.Error(w, "Failed to open file: "+err.Error(), http.StatusBadRequest)
-				return
-			}
+func NewStorageHandler(service *service.StorageService, opts ...Option) *StorageHandler {
+	h := &StorageHandler{
+		service:            service,
+		maxUploadBytes:     100 << 20,
+		maxMultipartMemory: 32 << 20,
+		spooler:            new(spool.Spooler),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
 
-			filePath := key
-			if filePath == "" {
-				filePath = fileHeader.Filename
-			}
+func (h *StorageHandler) WriteFiles(w http.ResponseWriter, r *http.Request) {
+	switch progress := r.URL.Query().Get("progress"); progress {
+	case "":
+	case progressNDJSON, progressSSE:
+		h.writeFilesWithProgress(w, r, progress)
+		return
+	default:
+		http.Error(w, "progress must be ndjson or sse", http.StatusBadRequest)
+		return
+	}
 
-			requests = append(requests, storage.WriteRequest{
-				Path:        filePath,
-				Content:     file,
-				ContentType: fileHeader.Header.Get("Content-Type"),
-			})
+	if maxBytes := h.service.BatchLimits().MaxBytes; maxBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	}
 
+	requests, err := h.readMultipartFiles(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			http.Error(w, fmt.Sprintf("%v: upload exceeds the limit of %d bytes", service.ErrBatchPayloadTooLarge, tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, spool.ErrFull):
+			http.Error(w, "Failed to buffer upload: "+err.Error(), http.StatusInsufficientStorage)
+		case r.Context().Err() != nil:
+			http.Error(w, "Upload aborted: "+err.Error(), statusClientClosedRequest)
+		default:
+			http.Error(w, "Failed to parse multipart form: "+err.Error(), http.StatusBadRequest)
 		}
+		return
 	}
 
 	if len(requests) == 0 {
@@ -64,17 +126,11 @@ func (h *StorageHandler) WriteFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	defer func() {
-		for _, req := range requests {
-			if closer, ok := req.Content.(io.Closer); ok {
-				closer.Close()
-			}
-		}
-	}()
+	defer closeContents(requests)
 
-	response, err := h.service.WriteFiles(r.Context(), requests)
+	response, err := h.service.WriteFiles(r.Context(), requests, writeOptions(r)...)
 	if err != nil {
-		http.Error(w, "Failed to write files: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to write files: "+err.Error(), errorStatus(err))
 		return
 	}
 
@@ -83,127 +139,660 @@ func (h *StorageHandler) WriteFiles(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// readMultipartFiles reads the file parts of a multipart form, keyed by field
+// name. Parts are held in memory up to maxMultipartMemory in total and spooled
+// to disk beyond that, so large uploads don't need a large instance.
+func (h *StorageHandler) readMultipartFiles(r *http.Request) ([]storage.WriteRequest, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	memory := h.maxMultipartMemory
+	var requests []storage.WriteRequest
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return requests, nil
+		}
+		if err != nil {
+			closeContents(requests)
+			return nil, err
+		}
+		// Form values are not files
+		if part.FileName() == "" {
+			continue
+		}
+
+		file, err := h.spooler.Spool(part, max(memory, 0))
+		if err != nil {
+			closeContents(requests)
+			return nil, err
+		}
+		if !file.OnDisk() {
+			memory -= file.Size()
+		}
+
+		filePath := part.FormName()
+		if filePath == "" {
+			filePath = part.FileName()
+		}
+		requests = append(requests, storage.WriteRequest{
+			Path:        filePath,
+			Content:     file,
+			ContentType: part.Header.Get("Content-Type"),
+		})
+	}
+}
+
+func closeContents(requests []storage.WriteRequest) {
+	for _, req := range requests {
+		if closer, ok := req.Content.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
 func (h *StorageHandler) ReadFiles(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	encoding := r.URL.Query().Get("encoding")
+	stream := acceptsNDJSON(r)
+	if stream && encoding == "" {
+		encoding = encodingBase64
+	}
+
+	request := dto.ReadFiles{Encoding: encoding}
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+	if encoding == encodingURL && h.urlSigner == nil {
+		http.Error(w, "URL encoding requires DOWNLOAD_URL_SIGNING_KEY", http.StatusNotImplemented)
 		return
 	}
 
-	var request struct {
-		FilePaths []string `json:"file_paths"`
+	fields, err := parseFields(r, batchReadFile{})
+	if err != nil {
+		http.Error(w, "Invalid fields: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if fields != nil && encoding == "" {
+		http.Error(w, "fields requires encoding=base64 or encoding=url", http.StatusBadRequest)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+	if stream {
+		h.streamReadFiles(w, r, request, encoding, fields)
 		return
 	}
 
-	if len(request.FilePaths) == 0 {
-		http.Error(w, "No file paths provided", http.StatusBadRequest)
+	if encoding == encodingURL {
+		files, errs, err := h.service.StatFiles(r.Context(), request.FilePaths)
+		if err != nil {
+			http.Error(w, "Failed to read files: "+err.Error(), errorStatus(err))
+			return
+		}
+		writeJSONFields(w, h.batchReadURLs(files, errs), fields)
 		return
 	}
 
-	response, err := h.service.ReadFiles(r.Context(), request.FilePaths)
+	var response *storage.ReadResponse
+	if len(request.Files) > 0 {
+		response, err = h.service.ReadRanges(r.Context(), request.Ranges())
+	} else {
+		response, err = h.service.ReadFiles(r.Context(), request.FilePaths)
+	}
 	if err != nil {
-		http.Error(w, "Failed to read files: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to read files: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	if encoding == encodingBase64 {
+		writeJSONFields(w, batchReadBase64(response), fields)
+		return
+	}
+
+	// Legacy format: Go's default field names with content base64 encoded by encoding/json
+	writeJSON(w, response)
+}
+
+// streamReadFiles writes a batch read as NDJSON, one file or error per line as
+// each file is read, in the documented format of the encoding
+func (h *StorageHandler) streamReadFiles(w http.ResponseWriter, r *http.Request, request dto.ReadFiles, encoding string, fields fieldSelection) {
+	stream := newNDJSONStream(w, fields)
+	if encoding == encodingURL {
+		files, errs, err := h.service.StatFiles(r.Context(), request.FilePaths)
+		if err != nil {
+			stream.finish("Failed to read files: ", err)
+			return
+		}
+		response := h.batchReadURLs(files, errs)
+		for _, file := range response.Files {
+			if err := stream.file(file); err != nil {
+				return
+			}
+		}
+		for _, e := range response.Errors {
+			if err := stream.write(e); err != nil {
+				return
+			}
+		}
+		stream.finish("", nil)
 		return
 	}
 
+	err := h.service.StreamRanges(r.Context(), request.Ranges(), func(file *storage.FileData, readErr *storage.ReadError) error {
+		if readErr != nil {
+			return stream.write(batchReadError{FilePath: readErr.FilePath, Error: readErr.Error})
+		}
+		return stream.file(batchReadBase64File(file))
+	})
+	stream.finish("Failed to read files: ", err)
+}
+
+// Batch read response encodings
+const (
+	encodingBase64 = dto.EncodingBase64
+	encodingURL    = dto.EncodingURL
+)
+
+// batchReadResponse is the documented batch read format selected with ?encoding=
+type batchReadResponse struct {
+	Encoding string           `json:"encoding"`
+	Files    []batchReadFile  `json:"files"`
+	Errors   []batchReadError `json:"errors"`
+}
+
+type batchReadFile struct {
+	Name         string `json:"name"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+	KMSKeyName   string `json:"kms_key_name,omitempty"`
+	StorageClass string `json:"storage_class,omitempty"`
+	Content      string `json:"content,omitempty"`
+	// Offset is where a ranged read's content starts in the file
+	Offset    int64      `json:"offset,omitempty"`
+	URL       string     `json:"url,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type batchReadError struct {
+	FilePath string `json:"file_path"`
+	Error    string `json:"error"`
+}
+
+func batchReadBase64(response *storage.ReadResponse) batchReadResponse {
+	out := batchReadResponse{
+		Encoding: encodingBase64,
+		Files:    make([]batchReadFile, 0, len(response.Files)),
+		Errors:   batchReadErrors(response.Errors),
+	}
+	for _, file := range response.Files {
+		out.Files = append(out.Files, batchReadBase64File(&file))
+	}
+	return out
+}
+
+func batchReadBase64File(file *storage.FileData) batchReadFile {
+	return batchReadFile{
+		Name:         file.Metadata.Name,
+		ContentType:  file.Metadata.ContentType,
+		Size:         file.Metadata.Size,
+		KMSKeyName:   file.Metadata.KMSKeyName,
+		StorageClass: file.Metadata.StorageClass,
+		Content:      base64.StdEncoding.EncodeToString(file.Content),
+		Offset:       file.Offset,
+	}
+}
+
+func (h *StorageHandler) batchReadURLs(files []storage.FileMetadata, errs []storage.ReadError) batchReadResponse {
+	expires := time.Now().Add(h.urlTTL).UTC().Truncate(time.Second)
+	out := batchReadResponse{
+		Encoding: encodingURL,
+		Files:    make([]batchReadFile, 0, len(files)),
+		Errors:   batchReadErrors(errs),
+	}
+	for _, file := range files {
+		out.Files = append(out.Files, batchReadFile{
+			Name:         file.Name,
+			ContentType:  file.ContentType,
+			Size:         file.Size,
+			KMSKeyName:   file.KMSKeyName,
+			StorageClass: file.StorageClass,
+			URL:          h.baseURL + h.urlSigner.SignURL("/api/v1/storage/files/"+file.Name, expires),
+			ExpiresAt:    &expires,
+		})
+	}
+	return out
+}
+
+func batchReadErrors(errs []storage.ReadError) []batchReadError {
+	out := make([]batchReadError, 0, len(errs))
+	for _, e := range errs {
+		out = append(out, batchReadError{FilePath: e.FilePath, Error: e.Error})
+	}
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(v)
 }
 
 func (h *StorageHandler) ReadFile(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
 		return
 	}
 
-	path := r.URL.Path
-	prefix := "/api/v1/storage/files/"
+	opts, err := imaging.ParseOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if !strings.HasPrefix(path, prefix) {
-		http.Error(w, "Invalid file path", http.StatusBadRequest)
+	disposition := r.URL.Query().Get("disposition")
+	if disposition != "" && disposition != "inline" && disposition != "attachment" {
+		http.Error(w, "disposition must be inline or attachment", http.StatusBadRequest)
 		return
 	}
 
-	filePath := strings.TrimPrefix(path, prefix)
+	if opts.IsZero() && h.redirectFile(w, r, filePath, disposition) {
+		return
+	}
+	if opts.IsZero() && h.readFileRange(w, r, filePath, disposition) {
+		return
+	}
+
+	var fileData *storage.FileData
+	if opts.IsZero() {
+		ctx := storage.WithAcceptedEncodings(r.Context(), acceptedEncodings(r.Header.Get("Accept-Encoding"))...)
+		fileData, err = h.service.ReadFile(ctx, filePath)
+	} else {
+		fileData, err = h.service.ReadImage(r.Context(), filePath, opts, r.URL.Query().Get("sig"))
+	}
+	if err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	setContentType(w.Header(), fileData.Metadata.ContentType)
+	// Objects stored compressed are served as stored to clients accepting their encoding
+	if fileData.Metadata.ContentEncoding != "" {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if fileData.ContentEncoding != "" {
+		w.Header().Set("Content-Encoding", fileData.ContentEncoding)
+	}
+	if opts.IsZero() {
+		setValidators(w.Header(), fileData.Metadata, fileData.ContentEncoding)
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(fileData.Content)))
+	setDigest(w.Header(), fileData.Content)
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, r.URL.Query().Get("filename"), fileData.Metadata))
+	if cacheControl := h.service.CacheControl(filePath); cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	if fileData.Metadata.KMSKeyName != "" {
+		w.Header().Set("X-KMS-Key-Name", fileData.Metadata.KMSKeyName)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(fileData.Content)
+}
+
+// setContentType sets the type of served content and keeps browsers from
+// sniffing another one, like HTML in an upload stored as text/plain
+func setContentType(header http.Header, contentType string) {
+	header.Set("Content-Type", contentType)
+	header.Set("X-Content-Type-Options", "nosniff")
+}
+
+// acceptedEncodings returns the encodings an Accept-Encoding header allows,
+// leaving out those with a q-value of 0
+func acceptedEncodings(header string) []string {
+	var encodings []string
+	for _, entry := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(entry, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err != nil || weight <= 0 {
+				continue
+			}
+		}
+		switch name {
+		case "*":
+			encodings = append(encodings, storage.EncodingGzip, storage.EncodingZstd)
+		case "":
+		default:
+			encodings = append(encodings, name)
+		}
+	}
+	return encodings
+}
+
+// contentDisposition builds the Content-Disposition header. Without an explicit
+// disposition, images, video, audio, PDF and plain text are served inline and
+// everything else as an attachment. SVG defaults to attachment since it can carry scripts.
+func contentDisposition(disposition, filename string, metadata storage.FileMetadata) string {
+	if disposition == "" {
+		disposition = "attachment"
+		contentType := metadata.ContentType
+		switch {
+		case contentType == "image/svg+xml":
+		case strings.HasPrefix(contentType, "image/"), strings.HasPrefix(contentType, "video/"),
+			strings.HasPrefix(contentType, "audio/"), contentType == "application/pdf",
+			strings.HasPrefix(contentType, "text/plain"):
+			disposition = "inline"
+		}
+	}
+	if filename == "" {
+		filename = path.Base(metadata.Name)
+	}
+	// FormatMediaType quotes the name and falls back to RFC 2231 encoding for non-ASCII names
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); header != "" {
+		return header
+	}
+	return disposition
+}
+
+// ReadPoster returns a still frame of a stored video
+// GET /api/v1/storage/posters/{filePath}?t=2.5
+// The frame is extracted at t seconds (default 0) and stored next to the video for later requests
+func (h *StorageHandler) ReadPoster(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
 	if filePath == "" {
 		http.Error(w, "File path is required", http.StatusBadRequest)
 		return
 	}
 
-	fileData, err := h.service.ReadFile(r.Context(), filePath)
+	var at time.Duration
+	if t := r.URL.Query().Get("t"); t != "" {
+		seconds, err := strconv.ParseFloat(t, 64)
+		if err != nil || seconds < 0 {
+			http.Error(w, "Invalid timestamp", http.StatusBadRequest)
+			return
+		}
+		at = time.Duration(seconds * float64(time.Second))
+	}
+
+	fileData, err := h.service.ReadPoster(r.Context(), filePath, at)
 	if err != nil {
-		http.Error(w, "Failed to read file: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to extract poster frame: "+err.Error(), errorStatus(err))
 		return
 	}
 
-	w.Header().Set("Content-Type", fileData.Metadata.ContentType)
+	setContentType(w.Header(), fileData.Metadata.ContentType)
 	w.Header().Set("Content-Length", strconv.FormatInt(fileData.Metadata.Size, 10))
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileData.Metadata.Name))
+	setDigest(w.Header(), fileData.Content)
+	w.WriteHeader(http.StatusOK)
+	w.Write(fileData.Content)
+}
 
+// ReadStream serves HLS/DASH playlists and segments
+// GET /api/v1/storage/stream/{filePath}
+// Playlist references are rewritten to this route so players can fetch segments through the proxy
+func (h *StorageHandler) ReadStream(w http.ResponseWriter, r *http.Request) {
+	prefix := "/api/v1/storage/stream/"
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	fileData, err := h.service.ReadStream(r.Context(), filePath, prefix)
+	if err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	setContentType(w.Header(), fileData.Metadata.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(fileData.Metadata.Size, 10))
+	setDigest(w.Header(), fileData.Content)
+	w.Header().Set("Cache-Control", h.service.StreamCacheControl(filePath))
 	w.WriteHeader(http.StatusOK)
 	w.Write(fileData.Content)
 }
 
-// WriteFileRaw handles raw binary media data upload
-// PUT /api/v1/storage/files/{filePath}
-// Accepts raw binary data in request body with file path in URL
-func (h *StorageHandler) WriteFileRaw(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// ComposeFile concatenates uploaded chunk objects into one object and deletes the chunks
+// POST /api/v1/storage/files/{filePath}/compose
+// Body: {"chunks": ["uploads/tmp/a.part1", "uploads/tmp/a.part2"], "content_type": "video/mp4"}
+func (h *StorageHandler) ComposeFile(w http.ResponseWriter, r *http.Request) {
+	filePath := strings.TrimSuffix(r.PathValue("path"), "/compose")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
 		return
 	}
 
-	// Extract file path from URL
-	path := r.URL.Path
-	prefix := "/api/v1/storage/files/"
-	
-	if !strings.HasPrefix(path, prefix) {
-		http.Error(w, "Invalid file path", http.StatusBadRequest)
+	var request dto.Compose
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	metadata, err := h.service.ComposeFile(r.Context(), filePath, request.Chunks, request.ContentType, writeOptions(r)...)
+	if err != nil {
+		http.Error(w, "Failed to compose file: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, metadata)
+}
+
+// SetStorageClass moves an existing object to another storage class by rewriting it
+// PUT /api/v1/storage/storage-class/{filePath}
+// Body: {"storage_class": "COLDLINE"}
+func (h *StorageHandler) SetStorageClass(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	var request dto.StorageClass
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	metadata, err := h.service.SetStorageClass(r.Context(), filePath, request.StorageClass)
+	if err != nil {
+		http.Error(w, "Failed to change storage class: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, metadata)
+}
+
+// FetchFiles downloads remote files into the bucket so clients don't relay them
+// POST /api/v1/storage/files/fetch
+// Body: {"files": [{"url": "https://example.com/a.jpg", "path": "images/a.jpg"}]}
+func (h *StorageHandler) FetchFiles(w http.ResponseWriter, r *http.Request) {
+	var request dto.FetchFiles
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	response, err := h.service.FetchFiles(r.Context(), request.Files, writeOptions(r)...)
+	if err != nil {
+		http.Error(w, "Failed to fetch files: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, response)
+}
+
+// Usage reports the caller's upload consumption against its quotas
+// GET /api/v1/storage/usage
+func (h *StorageHandler) Usage(w http.ResponseWriter, r *http.Request) {
+	usage, err := h.service.Usage(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to get usage: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, usage)
+}
+
+// CreateUpload issues a signed URL for uploading an object directly to the bucket and
+// registers the post-processing to run once the object lands
+// POST /api/v1/storage/uploads
+// Body: {"path": "...", "content_type": "image/jpeg", "callback_url": "...", "post_process": ["thumbnail"]}
+func (h *StorageHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	var request dto.Upload
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	upload, err := h.service.CreateUpload(r.Context(), request.UploadRequest)
+	if err != nil {
+		http.Error(w, "Failed to create upload: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, upload)
+}
+
+// GetHolds returns the holds and retention expiration of an object
+// GET /api/v1/storage/holds/{filePath}
+func (h *StorageHandler) GetHolds(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	retention, err := h.service.GetRetention(r.Context(), filePath)
+	if err != nil {
+		http.Error(w, "Failed to access holds: "+err.Error(), errorStatus(err))
 		return
 	}
 
-	filePath := strings.TrimPrefix(path, prefix)
+	writeJSON(w, retention)
+}
+
+// SetHolds places or releases the holds on an object
+// PUT /api/v1/storage/holds/{filePath} with {"temporary_hold": true, "event_based_hold": false}
+func (h *StorageHandler) SetHolds(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	var request dto.Holds
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	retention, err := h.service.SetHolds(r.Context(), filePath, request.HoldUpdate)
+	if err != nil {
+		http.Error(w, "Failed to access holds: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, retention)
+}
+
+// GetAccess reports whether an object is public and its public URL
+// GET /api/v1/storage/access/{filePath}
+func (h *StorageHandler) GetAccess(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	access, err := h.service.GetAccess(r.Context(), filePath)
+	if err != nil {
+		http.Error(w, "Failed to access ACL: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, access)
+}
+
+// SetAccess makes an object public-read or private
+// PUT /api/v1/storage/access/{filePath} with {"public": true}
+func (h *StorageHandler) SetAccess(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	var request dto.Access
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	access, err := h.service.SetAccess(r.Context(), filePath, *request.Public)
+	if err != nil {
+		http.Error(w, "Failed to access ACL: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, access)
+}
+
+// WriteFileRaw handles raw binary media data upload
+// PUT /api/v1/storage/files/{filePath}
+// Accepts raw binary data in request body with file path in URL
+func (h *StorageHandler) WriteFileRaw(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
 	// Filter out reserved paths
 	if filePath == "" || filePath == "read" || filePath == "raw" {
 		http.Error(w, "Invalid file path", http.StatusBadRequest)
 		return
 	}
 
-	// Get content type from header or detect from file extension
+	// Check the content type from header or file extension; without a header
+	// the service sniffs the type from the content
 	contentType := r.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = detectContentType(filePath)
+	checkedType := contentType
+	if checkedType == "" {
+		checkedType = storage.DetectContentType(filePath)
+	}
+	if err := h.service.CheckContentType(checkedType); err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
 	}
 
-	// Limit request body size (e.g., 100MB)
-	r.Body = http.MaxBytesReader(w, r.Body, 100<<20)
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadBytes)
 
 	// Create write request with raw body data
 	request := storage.WriteRequest{
 		Path:        filePath,
 		Content:     r.Body,
 		ContentType: contentType,
+		SHA256:      r.Header.Get("X-Content-SHA256"),
 	}
 
-	response, err := h.service.WriteFiles(r.Context(), []storage.WriteRequest{request})
+	response, err := h.service.WriteFiles(r.Context(), []storage.WriteRequest{request}, writeOptions(r)...)
 	if err != nil {
-		http.Error(w, "Failed to write file: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to write file: "+err.Error(), errorStatus(err))
 		return
 	}
 
 	if len(response.FilesWritten) == 0 {
 		if len(response.Errors) > 0 {
-			http.Error(w, "Failed to write file: "+response.Errors[0].Error, http.StatusInternalServerError)
+			http.Error(w, "Failed to write file: "+response.Errors[0].Error, writeErrorStatus(response.Errors[0]))
 			return
 		}
 		http.Error(w, "No file was written", http.StatusInternalServerError)
 		return
 	}
 
+	if len(response.Deduplicated) > 0 {
+		w.Header().Set("X-Deduplicated", "true")
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response.FilesWritten[0])
@@ -213,11 +802,6 @@ func (h *StorageHandler) WriteFileRaw(w http.ResponseWriter, r *http.Request) {
 // POST /api/v1/storage/files/raw
 // Accepts raw binary data in request body, file path in X-File-Path header or query parameter
 func (h *StorageHandler) WriteFileRawFromBody(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Get file path from header or query parameter
 	filePath := r.Header.Get("X-File-Path")
 	if filePath == "" {
@@ -228,125 +812,797 @@ func (h *StorageHandler) WriteFileRawFromBody(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Get content type from header or detect from file extension
+	// Check the content type from header or file extension; without a header
+	// the service sniffs the type from the content
 	contentType := r.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = detectContentType(filePath)
+	checkedType := contentType
+	if checkedType == "" {
+		checkedType = storage.DetectContentType(filePath)
+	}
+	if err := h.service.CheckContentType(checkedType); err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
 	}
 
-	// Limit request body size (e.g., 100MB)
-	r.Body = http.MaxBytesReader(w, r.Body, 100<<20)
+	// Limit request body size
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadBytes)
 
 	// Create write request with raw body data
 	request := storage.WriteRequest{
 		Path:        filePath,
 		Content:     r.Body,
 		ContentType: contentType,
+		SHA256:      r.Header.Get("X-Content-SHA256"),
 	}
 
-	response, err := h.service.WriteFiles(r.Context(), []storage.WriteRequest{request})
+	response, err := h.service.WriteFiles(r.Context(), []storage.WriteRequest{request}, writeOptions(r)...)
 	if err != nil {
-		http.Error(w, "Failed to write file: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to write file: "+err.Error(), errorStatus(err))
 		return
 	}
 
 	if len(response.FilesWritten) == 0 {
 		if len(response.Errors) > 0 {
-			http.Error(w, "Failed to write file: "+response.Errors[0].Error, http.StatusInternalServerError)
+			http.Error(w, "Failed to write file: "+response.Errors[0].Error, writeErrorStatus(response.Errors[0]))
 			return
 		}
 		http.Error(w, "No file was written", http.StatusInternalServerError)
 		return
 	}
 
+	if len(response.Deduplicated) > 0 {
+		w.Header().Set("X-Deduplicated", "true")
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response.FilesWritten[0])
 }
 
-// detectContentType detects content type from file extension
-func detectContentType(filePath string) string {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	
-	// Map common media file extensions to MIME types
-	mediaTypes := map[string]string{
-		".mp4":  "video/mp4",
-		".m4v":  "video/mp4",
-		".mov":  "video/quicktime",
-		".avi":  "video/x-msvideo",
-		".webm": "video/webm",
-		".heim": "image/heic",
-		".heic": "image/heic",
-		".heif": "image/heif",
-		".jpg":  "image/jpeg",
-		".jpeg": "image/jpeg",
-		".png":  "image/png",
-		".gif":  "image/gif",
-		".webp": "image/webp",
-		".bmp":  "image/bmp",
+// statusClientClosedRequest answers uploads the client abandoned, like nginx's
+// 499, so the logs tell them apart from failures of the proxy
+const statusClientClosedRequest = 499
+
+// writeErrorStatus maps a per-file write error to an HTTP status for single file uploads
+func writeErrorStatus(writeErr storage.WriteError) int {
+	switch {
+	case strings.HasPrefix(writeErr.Error, storage.ErrAborted.Error()):
+		return statusClientClosedRequest
+	case strings.HasPrefix(writeErr.Error, service.ErrInvalidContentHash.Error()):
+		return http.StatusBadRequest
+	case strings.HasPrefix(writeErr.Error, service.ErrContentHashMismatch.Error()):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeOptions extracts per-request write options from headers
+func writeOptions(r *http.Request) []service.WriteOption {
+	var opts []service.WriteOption
+	if strip, _ := strconv.ParseBool(r.Header.Get("X-Strip-Metadata")); strip {
+		opts = append(opts, service.StripMetadata())
+	}
+	if keyName := r.Header.Get("X-KMS-Key-Name"); keyName != "" {
+		opts = append(opts, service.KMSKey(keyName))
+	}
+	if storageClass := r.Header.Get("X-Storage-Class"); storageClass != "" {
+		opts = append(opts, service.StorageClass(storageClass))
 	}
+	if after := r.Header.Get("X-Expires-After"); after != "" {
+		opts = append(opts, service.ExpiresAfter(after))
+	}
+	if at := r.Header.Get("X-Expires-At"); at != "" {
+		opts = append(opts, service.ExpiresAt(at))
+	}
+	return opts
+}
 
-	if mimeType, ok := mediaTypes[ext]; ok {
-		return mimeType
+// withEncryptionKey attaches the customer-supplied encryption key from the
+// X-Encryption-Key header (base64 AES-256, optionally checked against
+// X-Encryption-Key-SHA256) to the request context
+func withEncryptionKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encoded := r.Header.Get("X-Encryption-Key")
+		if encoded == "" {
+			next(w, r)
+			return
+		}
+		key, err := storage.ParseEncryptionKey(encoded, r.Header.Get("X-Encryption-Key-SHA256"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next(w, r.WithContext(storage.WithEncryptionKey(r.Context(), key)))
 	}
+}
 
-	// Try system MIME type detection
-	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
-		return mimeType
+// withLeases attaches the lease IDs of the X-Lease-ID header, repeated or
+// comma-separated, to the request context, so writes to leased paths go through
+func withLeases(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		for _, value := range r.Header.Values("X-Lease-ID") {
+			for _, id := range strings.Split(value, ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					ids = append(ids, id)
+				}
+			}
+		}
+		if len(ids) == 0 {
+			next(w, r)
+			return
+		}
+		next(w, r.WithContext(lease.WithIDs(r.Context(), ids)))
 	}
+}
 
-	// Default to binary if unknown
-	return "application/octet-stream"
+// errorStatus maps service errors to HTTP status codes
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrAborted):
+		return statusClientClosedRequest
+	case errors.Is(err, service.ErrBatchTooManyFiles):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, service.ErrBatchPayloadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrInvalidKMSKey), errors.Is(err, service.ErrInvalidStorageClass),
+		errors.Is(err, service.ErrInvalidHoldUpdate), errors.Is(err, service.ErrInvalidUpload),
+		errors.Is(err, service.ErrInvalidCompose), errors.Is(err, service.ErrInvalidCopy),
+		errors.Is(err, service.ErrInvalidRange), errors.Is(err, service.ErrInvalidTags),
+		errors.Is(err, service.ErrInvalidSearch), errors.Is(err, service.ErrInvalidExpiry),
+		errors.Is(err, service.ErrInvalidCacheRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrInvalidPath):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrUnsupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, storage.ErrMixedEncodings):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrChecksumMismatch):
+		return http.StatusBadGateway
+	case errors.Is(err, service.ErrContentTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, imaging.ErrInvalidSignature), errors.Is(err, policy.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, policy.ErrAuthorizerUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, imaging.ErrInvalidOptions):
+		return http.StatusBadRequest
+	case errors.Is(err, imaging.ErrUnsupportedImage), errors.Is(err, imaging.ErrUnsupportedFormat),
+		errors.Is(err, service.ErrNotVideo):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, imaging.ErrImageTooLarge):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, service.ErrFrameExtractionDisabled), errors.Is(err, service.ErrUploadsDisabled),
+		errors.Is(err, service.ErrQuotasDisabled), errors.Is(err, service.ErrTrashDisabled),
+		errors.Is(err, service.ErrFetchDisabled), errors.Is(err, service.ErrTagsDisabled),
+		errors.Is(err, service.ErrSearchDisabled), errors.Is(err, service.ErrCatalogDisabled),
+		errors.Is(err, service.ErrChangesDisabled), errors.Is(err, service.ErrEventStreamDisabled),
+		errors.Is(err, service.ErrLeasesDisabled), errors.Is(err, service.ErrLogsDisabled),
+		errors.Is(err, service.ErrSiteDisabled), errors.Is(err, service.ErrSharesDisabled),
+		errors.Is(err, service.ErrStatsDisabled), errors.Is(err, service.ErrExpiryDisabled),
+		errors.Is(err, service.ErrCacheDisabled):
+		return http.StatusNotImplemented
+	case errors.Is(err, stats.ErrInvalidQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, share.ErrInvalidShare):
+		return http.StatusBadRequest
+	case errors.Is(err, share.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, share.ErrPasswordRequired):
+		return http.StatusUnauthorized
+	case errors.Is(err, share.ErrWrongPassword):
+		return http.StatusForbidden
+	case errors.Is(err, share.ErrExhausted):
+		return http.StatusGone
+	case errors.Is(err, appendlog.ErrEmptyRecord), errors.Is(err, appendlog.ErrInvalidRange):
+		return http.StatusBadRequest
+	case errors.Is(err, appendlog.ErrRecordTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, appendlog.ErrContention):
+		return http.StatusConflict
+	case errors.Is(err, lease.ErrLocked):
+		return http.StatusLocked
+	case errors.Is(err, lease.ErrNotHeld):
+		return http.StatusConflict
+	case errors.Is(err, lease.ErrNotLeased):
+		return http.StatusNotFound
+	case errors.Is(err, lease.ErrInvalidTTL):
+		return http.StatusBadRequest
+	case errors.Is(err, changes.ErrInvalidCursor):
+		return http.StatusBadRequest
+	case errors.Is(err, changes.ErrCursorExpired):
+		return http.StatusGone
+	case errors.Is(err, search.ErrNotReady):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, service.ErrNotInTrash):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrRestoreConflict), errors.Is(err, storage.ErrUniformAccess):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrNoPrincipal):
+		return http.StatusBadRequest
+	case errors.Is(err, quota.ErrExceeded), errors.Is(err, service.ErrQuarantined):
+		return http.StatusForbidden
+	case errors.Is(err, media.ErrFrameNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, mount.ErrInvalidMount):
+		return http.StatusBadRequest
+	case errors.Is(err, mount.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, mount.ErrExists), errors.Is(err, mount.ErrStatic):
+		return http.StatusConflict
+	case errors.Is(err, mount.ErrForbidden), errors.Is(err, mount.ErrReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, mount.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
-func (h *StorageHandler) SetupRoutes(mux *http.ServeMux) {
+// Parameters shared by the documented storage routes
+var (
+	pathParam = openapi.PathParam("path", "Object path, which may contain slashes")
+
+	encryptionParams = []openapi.Param{
+		openapi.HeaderParam("X-Encryption-Key", "Base64 AES-256 customer-supplied encryption key"),
+		openapi.HeaderParam("X-Encryption-Key-SHA256", "Base64 SHA-256 of the encryption key, checked if set"),
+	}
+
+	writeParams = []openapi.Param{
+		openapi.HeaderParam("X-Strip-Metadata", "Remove EXIF and other metadata from images"),
+		openapi.HeaderParam("X-KMS-Key-Name", "Cloud KMS key to encrypt the object with"),
+		openapi.HeaderParam("X-Storage-Class", "Storage class of the object"),
+		openapi.HeaderParam("X-Expires-After", "Delete the object after this duration, e.g. 24h, or number of seconds"),
+		openapi.HeaderParam("X-Expires-At", "Delete the object at this RFC 3339 time"),
+		leaseParam,
+	}
+
+	leaseParam = openapi.HeaderParam("X-Lease-ID", "IDs of the leases held on the written paths, comma-separated")
+
+	hashParam = openapi.HeaderParam("X-Content-SHA256", "Hex SHA-256 of the content; the upload fails if it doesn't match")
+
+	transferParam = openapi.HeaderParam("X-Transfer-ID", "ID to poll the progress of the upload with at GET /api/v1/transfers/{id}")
+)
+
+func (h *StorageHandler) SetupRoutes(router *Router) {
+	written := openapi.JSONResponse("The written file", storage.FileMetadata{})
+
 	// Multipart file upload (existing, for backward compatibility)
-	mux.HandleFunc("/api/v1/storage/files", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			// Check if it's multipart or raw
-			contentType := r.Header.Get("Content-Type")
-			if strings.HasPrefix(contentType, "multipart/form-data") {
-				h.WriteFiles(w, r)
-			} else {
-				// For POST without multipart, use raw endpoint logic
-				h.WriteFileRawFromBody(w, r)
-			}
+	router.HandleFunc("POST /api/v1/storage/files", withLeases(withEncryptionKey(func(w http.ResponseWriter, r *http.Request) {
+		// Check if it's multipart or raw
+		contentType := r.Header.Get("Content-Type")
+		if strings.HasPrefix(contentType, "multipart/form-data") {
+			h.WriteFiles(w, r)
 		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			// For POST without multipart, use raw endpoint logic
+			h.WriteFileRawFromBody(w, r)
 		}
+	})), openapi.Operation{
+		ID:          "writeFiles",
+		Tag:         "storage",
+		Summary:     "Upload files",
+		Description: "Uploads the files of a multipart form, each stored under its field name. A raw body is handled like POST /api/v1/storage/files/raw. With progress, the parts are streamed to storage as they arrive and progress events are returned instead.",
+		Params: slices.Concat([]openapi.Param{
+			openapi.QueryParam("progress", "Stream progress events as ndjson or sse", ""),
+		}, writeParams, encryptionParams),
+		Request: openapi.MultipartBody("File fields named after the object paths"),
+		Responses: []openapi.Response{
+			openapi.JSONResponse("Files written and per-file errors", storage.WriteResponse{}),
+			{Status: http.StatusOK, Body: &openapi.Body{ContentType: "application/x-ndjson", Schema: progressEvent{}}},
+			{Status: http.StatusOK, Body: &openapi.Body{ContentType: "text/event-stream", Schema: progressEvent{}}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusLocked, http.StatusUnprocessableEntity, http.StatusInsufficientStorage},
+	})
+
+	// Server-side downloads from remote URLs
+	router.HandleFunc("POST /api/v1/storage/files/fetch", withLeases(withEncryptionKey(h.FetchFiles)), openapi.Operation{
+		ID:          "fetchFiles",
+		Tag:         "storage",
+		Summary:     "Download remote files into the bucket",
+		Description: "Each URL is downloaded by the proxy and streamed into its path. Only public addresses are fetched, and downloads over the size limit fail.",
+		Params:      slices.Concat(writeParams, encryptionParams),
+		Request:     openapi.JSONBody(dto.FetchFiles{}),
+		Responses:   []openapi.Response{openapi.JSONResponse("Files written and per-file errors", storage.WriteResponse{})},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusLocked, http.StatusUnprocessableEntity, http.StatusNotImplemented},
 	})
 
 	// Raw binary upload with path in header/query
-	mux.HandleFunc("/api/v1/storage/files/raw", h.WriteFileRawFromBody)
-
-	// Raw binary upload with path in URL (PUT)
-	// This must be registered before the generic "/api/v1/storage/files/" handler
-	// to avoid conflicts with ReadFile
-	mux.HandleFunc("/api/v1/storage/files/", func(w http.ResponseWriter, r *http.Request) {
-		path := strings.TrimPrefix(r.URL.Path, "/api/v1/storage/files/")
-		
-		// Reserved paths
-		if path == "read" || path == "raw" {
-			if path == "read" && r.Method == http.MethodPost {
-				h.ReadFiles(w, r)
-				return
-			}
-			if path == "raw" && r.Method == http.MethodPost {
-				h.WriteFileRawFromBody(w, r)
-				return
-			}
-		}
-		
-		// PUT = write raw file, GET = read file
-		if r.Method == http.MethodPut {
-			h.WriteFileRaw(w, r)
-		} else if r.Method == http.MethodGet {
-			h.ReadFile(w, r)
-		} else {
+	router.HandleFunc("POST /api/v1/storage/files/raw", withLeases(withEncryptionKey(h.WriteFileRawFromBody)), openapi.Operation{
+		ID:          "writeFileRaw",
+		Tag:         "storage",
+		Summary:     "Upload a file with the path in a header",
+		Description: "The content type is taken from Content-Type or detected from the path.",
+		Params: slices.Concat([]openapi.Param{
+			openapi.HeaderParam("X-File-Path", "Object path"),
+			openapi.QueryParam("path", "Object path, if X-File-Path is not set", ""),
+			hashParam,
+			transferParam,
+		}, writeParams, encryptionParams),
+		Request:   openapi.BinaryBody("File content"),
+		Responses: []openapi.Response{written},
+		Errors:    []int{http.StatusBadRequest, http.StatusForbidden, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusLocked, http.StatusUnprocessableEntity},
+	})
+
+	// Files addressed by path; POST {path}/compose = compose chunks, POST {path}/copy = copy
+	router.HandleFunc("GET /api/v1/storage/files/{path...}", withEncryptionKey(h.ReadFile), openapi.Operation{
+		ID:          "readFile",
+		Tag:         "storage",
+		Summary:     "Download a file",
+		Description: "Images are resized and converted when any of width, height, fit, quality or format is set. The SHA-256 and MD5 of the content served are sent in Repr-Digest and Digest. A single byte range is served with 206 Partial Content; with If-Range, only if the file still has the given ETag or Last-Modified, and otherwise in full. Files under a redirect rule are answered with 307 to a short-lived signed URL of the bucket.",
+		Params: slices.Concat([]openapi.Param{
+			pathParam,
+			openapi.QueryParam("width", "Target width in pixels", 0),
+			openapi.QueryParam("height", "Target height in pixels", 0),
+			openapi.QueryParam("fit", "contain, cover or fill", ""),
+			openapi.QueryParam("quality", "Encoder quality from 1 to 100", 0),
+			openapi.QueryParam("format", "jpeg, png or gif; webp and avif only with a registered encoder", ""),
+			openapi.QueryParam("sig", "Signature of the transformation, required with IMAGE_SIGNING_KEY", ""),
+			openapi.QueryParam("disposition", "inline or attachment", ""),
+			openapi.QueryParam("filename", "File name in Content-Disposition", ""),
+			openapi.HeaderParam("Range", "A single byte range, e.g. bytes=1048576-"),
+			openapi.HeaderParam("If-Range", "ETag or Last-Modified the range was read from before"),
+		}, encryptionParams),
+		Responses: []openapi.Response{
+			openapi.BinaryResponse("File content"),
+			{Status: http.StatusPartialContent, Description: "The requested range of the file", Body: &openapi.Body{ContentType: "*/*"}},
+			{Status: http.StatusTemporaryRedirect, Description: "Signed URL of the file in the bucket, in Location"},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusRequestedRangeNotSatisfiable, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity, http.StatusBadGateway},
+	})
+	router.HandleFunc("PUT /api/v1/storage/files/{path...}", withLeases(withEncryptionKey(h.WriteFileRaw)), openapi.Operation{
+		ID:        "writeFile",
+		Tag:       "storage",
+		Summary:   "Upload a file",
+		Params:    slices.Concat([]openapi.Param{pathParam, hashParam, transferParam}, writeParams, encryptionParams),
+		Request:   openapi.BinaryBody("File content"),
+		Responses: []openapi.Response{written},
+		Errors:    []int{http.StatusBadRequest, http.StatusForbidden, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusLocked, http.StatusUnprocessableEntity},
+	})
+	router.HandleFunc("DELETE /api/v1/storage/files/{path...}", withLeases(withEncryptionKey(h.DeleteFile)), openapi.Operation{
+		ID:          "deleteFile",
+		Tag:         "storage",
+		Summary:     "Delete a file",
+		Description: "With a trash configured, the file is moved there and can be restored until it is purged.",
+		Params:      []openapi.Param{pathParam, leaseParam},
+		Responses:   []openapi.Response{{Status: http.StatusNoContent, Description: "The file was deleted"}},
+		Errors:      []int{http.StatusNotFound, http.StatusLocked},
+	})
+	router.HandleFunc("POST /api/v1/storage/files/{path...}", withLeases(withEncryptionKey(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.PathValue("path"); {
+		case strings.HasSuffix(path, "/compose"):
+			h.ComposeFile(w, r)
+		case strings.HasSuffix(path, "/copy"):
+			h.CopyFile(w, r)
+		case strings.HasSuffix(path, "/restore"):
+			h.RestoreFile(w, r)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
+	})), openapi.Operation{
+		ID:          "composeFile",
+		Path:        "/api/v1/storage/files/{path}/compose",
+		Tag:         "storage",
+		Summary:     "Concatenate uploaded chunks into a file",
+		Description: "The chunk objects are deleted once the file is written.",
+		Params:      slices.Concat([]openapi.Param{pathParam}, writeParams, encryptionParams),
+		Request:     openapi.JSONBody(dto.Compose{}),
+		Responses:   []openapi.Response{written},
+		Errors:      []int{http.StatusBadRequest, http.StatusNotFound, http.StatusLocked},
+	}, openapi.Operation{
+		ID:        "copyFile",
+		Path:      "/api/v1/storage/files/{path}/copy",
+		Tag:       "storage",
+		Summary:   "Copy a file within the bucket",
+		Params:    slices.Concat([]openapi.Param{pathParam, leaseParam}, encryptionParams),
+		Request:   openapi.JSONBody(dto.Copy{}),
+		Responses: []openapi.Response{openapi.JSONResponse("The copy", storage.FileMetadata{})},
+		Errors:    []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusLocked},
+	}, openapi.Operation{
+		ID:          "restoreFile",
+		Path:        "/api/v1/storage/files/{path}/restore",
+		Tag:         "storage",
+		Summary:     "Restore a deleted file from the trash",
+		Description: "Without deleted_at the latest deletion is restored. A file that exists at the path is not replaced.",
+		Params: []openapi.Param{
+			pathParam,
+			openapi.QueryParam("deleted_at", "Deletion time of the trashed file, as listed in the trash", ""),
+		},
+		Responses: []openapi.Response{openapi.JSONResponse("The restored file", storage.FileMetadata{})},
+		Errors:    []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusNotImplemented},
+	})
+
+	// Deleted files waiting in the trash
+	router.HandleFunc("GET /api/v1/storage/trash", h.ListTrash, openapi.Operation{
+		ID:        "listTrash",
+		Tag:       "storage",
+		Summary:   "List deleted files that can be restored",
+		Params:    []openapi.Param{openapi.QueryParam("prefix", "Prefix of the original paths", "")},
+		Responses: []openapi.Response{openapi.JSONResponse("Trashed files, oldest deletion first", []service.TrashedFile{})},
+		Errors:    []int{http.StatusNotImplemented},
+	})
+
+	// Listing by prefix
+	router.HandleFunc("GET /api/v1/storage/list", h.ListFiles, openapi.Operation{
+		ID:          "listFiles",
+		Tag:         "storage",
+		Summary:     "List files under a prefix",
+		Description: "With Accept: application/x-ndjson each file, or {\"prefix\": ...} for a grouped prefix, is streamed as a line as the bucket is listed.",
+		Params: []openapi.Param{
+			openapi.QueryParam("prefix", "Path prefix", ""),
+			openapi.QueryParam("delimiter", "Group files below the next delimiter into prefixes", ""),
+			openapi.QueryParam("fields", "Comma-separated file fields to return, e.g. name,size", ""),
+		},
+		Responses: []openapi.Response{
+			openapi.JSONResponse("Files and grouped prefixes", service.Listing{}),
+			{Status: http.StatusOK, Body: &openapi.Body{ContentType: ndjsonContentType, Schema: storage.FileMetadata{}}},
+		},
+	})
+
+	// Video poster frames
+	router.HandleFunc("GET /api/v1/storage/posters/{path...}", withEncryptionKey(h.ReadPoster), openapi.Operation{
+		ID:          "readPoster",
+		Tag:         "storage",
+		Summary:     "Get a still frame of a video",
+		Description: "The frame is stored next to the video and reused by later requests.",
+		Params: slices.Concat([]openapi.Param{
+			pathParam,
+			openapi.QueryParam("t", "Position of the frame in seconds", 0.0),
+		}, encryptionParams),
+		Responses: []openapi.Response{openapi.BinaryResponse("JPEG frame")},
+		Errors:    []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnsupportedMediaType, http.StatusNotImplemented},
+	})
+
+	// HLS/DASH playlists and segments
+	router.HandleFunc("GET /api/v1/storage/stream/{path...}", withEncryptionKey(h.ReadStream), openapi.Operation{
+		ID:          "readStream",
+		Tag:         "storage",
+		Summary:     "Get an HLS or DASH playlist or segment",
+		Description: "Playlist references are rewritten to this route.",
+		Params:      slices.Concat([]openapi.Param{pathParam}, encryptionParams),
+		Responses:   []openapi.Response{openapi.BinaryResponse("Playlist or segment")},
+		Errors:      []int{http.StatusNotFound},
+	})
+
+	// Storage class changes
+	router.HandleFunc("PUT /api/v1/storage/storage-class/{path...}", withEncryptionKey(h.SetStorageClass), openapi.Operation{
+		ID:        "setStorageClass",
+		Tag:       "storage",
+		Summary:   "Change the storage class of a file",
+		Params:    slices.Concat([]openapi.Param{pathParam}, encryptionParams),
+		Request:   openapi.JSONBody(dto.StorageClass{}),
+		Responses: []openapi.Response{openapi.JSONResponse("The rewritten file", storage.FileMetadata{})},
+		Errors:    []int{http.StatusBadRequest, http.StatusNotFound},
+	})
+
+	// Object holds and retention
+	router.HandleFunc("GET /api/v1/storage/holds/{path...}", h.GetHolds, openapi.Operation{
+		ID:        "getHolds",
+		Tag:       "storage",
+		Summary:   "Get the holds and retention of a file",
+		Params:    []openapi.Param{pathParam},
+		Responses: []openapi.Response{openapi.JSONResponse("Holds and retention", storage.Retention{})},
+		Errors:    []int{http.StatusNotFound},
+	})
+	router.HandleFunc("PUT /api/v1/storage/holds/{path...}", h.SetHolds, openapi.Operation{
+		ID:          "setHolds",
+		Tag:         "storage",
+		Summary:     "Place or release holds on a file",
+		Description: "Holds left out of the body are unchanged.",
+		Params:      []openapi.Param{pathParam},
+		Request:     openapi.JSONBody(dto.Holds{}),
+		Responses:   []openapi.Response{openapi.JSONResponse("Holds and retention", storage.Retention{})},
+		Errors:      []int{http.StatusBadRequest, http.StatusNotFound},
+	})
+
+	// Object ACLs
+	router.HandleFunc("GET /api/v1/storage/access/{path...}", h.GetAccess, openapi.Operation{
+		ID:        "getAccess",
+		Tag:       "storage",
+		Summary:   "Get whether a file is public",
+		Params:    []openapi.Param{pathParam},
+		Responses: []openapi.Response{openapi.JSONResponse("Access of the file", storage.Access{})},
+		Errors:    []int{http.StatusNotFound, http.StatusConflict},
+	})
+	router.HandleFunc("PUT /api/v1/storage/access/{path...}", h.SetAccess, openapi.Operation{
+		ID:          "setAccess",
+		Tag:         "storage",
+		Summary:     "Make a file public-read or private",
+		Description: "Grants or revokes read access for allUsers in the object's ACL and returns the public URL of public files. Buckets with uniform bucket-level access answer 409 Conflict; grant access there with IAM on a prefix instead.",
+		Params:      []openapi.Param{pathParam},
+		Request:     openapi.JSONBody(dto.Access{}),
+		Responses:   []openapi.Response{openapi.JSONResponse("Access of the file", storage.Access{})},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict},
+	})
+
+	// Download statistics
+	router.HandleFunc("GET /api/v1/storage/stats", h.ListStats, openapi.Operation{
+		ID:          "listStats",
+		Tag:         "storage",
+		Summary:     "List objects by downloads",
+		Description: "Lists the download statistics of objects that were downloaded, sorted by downloads, bytes served or last access. Sort by last_access with order=asc to find cold objects. Statistics lag downloads by up to the flush interval.",
+		Params: []openapi.Param{
+			openapi.QueryParam("prefix", "Only objects under this prefix", ""),
+			openapi.QueryParam("sort", "downloads, bytes or last_access", stats.SortDownloads),
+			openapi.QueryParam("order", "asc or desc", "desc"),
+			openapi.QueryParam("limit", "Objects to return, up to 1000", 100),
+		},
+		Responses: []openapi.Response{openapi.JSONResponse("Download statistics", statsResponse{})},
+		Errors:    []int{http.StatusBadRequest, http.StatusNotImplemented},
+	})
+	router.HandleFunc("GET /api/v1/storage/stats/{path...}", h.GetStats, openapi.Operation{
+		ID:        "getStats",
+		Tag:       "storage",
+		Summary:   "Get the download statistics of a file",
+		Params:    []openapi.Param{pathParam},
+		Responses: []openapi.Response{openapi.JSONResponse("Download statistics", stats.Stats{})},
+		Errors:    []int{http.StatusNotImplemented},
+	})
+	// Read cache
+	router.Handle("GET /api/v1/storage/cache", auth.RequireScope(auth.ScopeAdmin, http.HandlerFunc(h.GetCacheStats)), openapi.Operation{
+		ID:          "getCacheStats",
+		Tag:         "storage",
+		Summary:     "Get read cache statistics",
+		Description: "Reports the hits, misses and invalidations of the read cache since the server started and, for the in-memory cache, its entries and bytes. Requires the admin scope.",
+		Responses:   []openapi.Response{openapi.JSONResponse("Read cache statistics", service.ReadCacheStats{})},
+		Errors:      []int{http.StatusForbidden, http.StatusNotImplemented},
+	})
+	router.Handle("POST /api/v1/storage/cache/warm", auth.RequireScope(auth.ScopeAdmin, http.HandlerFunc(h.WarmCache)), openapi.Operation{
+		ID:          "warmCache",
+		Tag:         "storage",
+		Summary:     "Read objects into the read cache",
+		Description: "Reads the objects at paths and under prefix into the read cache, e.g. before a content launch. Objects larger than the cache takes or envelope encrypted are skipped. With cdn, a HEAD request for each object is sent through the CDN at the public base URL as well. Requires the admin scope.",
+		Request:     openapi.JSONBody(dto.WarmCache{}),
+		Responses:   []openapi.Response{openapi.JSONResponse("What became of each object", service.CacheWarmResult{})},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusUnprocessableEntity, http.StatusNotImplemented},
+	})
+	router.Handle("POST /api/v1/storage/cache/purge", auth.RequireScope(auth.ScopeAdmin, http.HandlerFunc(h.PurgeCache)), openapi.Operation{
+		ID:          "purgeCache",
+		Tag:         "storage",
+		Summary:     "Drop objects from the read cache",
+		Description: "Drops the objects at paths and under prefix from the read cache, so the next reads go to the bucket. Requires the admin scope.",
+		Request:     openapi.JSONBody(dto.CacheFiles{}),
+		Responses:   []openapi.Response{openapi.JSONResponse("Objects dropped", purgeResponse{})},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotImplemented},
+	})
+	router.Handle("GET /api/v1/storage/usage/prefix", auth.RequireScope(auth.ScopeAdmin, http.HandlerFunc(h.GetPrefixUsage)), openapi.Operation{
+		ID:          "getPrefixUsage",
+		Tag:         "storage",
+		Summary:     "Get the storage used under a prefix",
+		Description: "Counts the objects under the prefix and their bytes. Results are cached; usage that takes longer than the configured wait to compute, or any with async=true, is answered with 202 and pending set while it is computed in the background, and a later request returns it. Requires the admin scope.",
+		Params: []openapi.Param{
+			openapi.QueryParam("prefix", "Path prefix", ""),
+			openapi.QueryParam("async", "Don't wait for usage that isn't cached", false),
+		},
+		Responses: []openapi.Response{
+			openapi.JSONResponse("Usage under the prefix", service.Usage{}),
+			{Status: http.StatusAccepted, Description: "Usage still being computed", Body: openapi.JSONBody(service.Usage{})},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusForbidden},
+	})
+
+	// Object tags and search by tag
+	router.HandleFunc("GET /api/v1/storage/tags/{path...}", h.GetTags, openapi.Operation{
+		ID:        "getTags",
+		Tag:       "storage",
+		Summary:   "Get the tags of a file",
+		Params:    []openapi.Param{pathParam},
+		Responses: []openapi.Response{openapi.JSONResponse("Tags of the file", tagsBody{})},
+		Errors:    []int{http.StatusForbidden, http.StatusNotFound, http.StatusNotImplemented},
+	})
+	router.HandleFunc("PUT /api/v1/storage/tags/{path...}", h.SetTags, openapi.Operation{
+		ID:          "setTags",
+		Tag:         "storage",
+		Summary:     "Replace the tags of a file",
+		Description: "Tags left out of the body are removed. An empty object removes all tags.",
+		Params:      []openapi.Param{pathParam},
+		Request:     openapi.JSONBody(dto.Tags{}),
+		Responses:   []openapi.Response{openapi.JSONResponse("Tags of the file", tagsBody{})},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusNotImplemented},
+	})
+	router.HandleFunc("GET /api/v1/storage/search", h.Search, openapi.Operation{
+		ID:          "search",
+		Tag:         "storage",
+		Summary:     "Find files by name or tag",
+		Description: "With q, files whose names or metadata contain all of the words, allowing prefixes and typos, best match first. Otherwise, repeat tag to find files carrying all of the tags, in path order.",
+		Params: []openapi.Param{
+			openapi.QueryParam("q", "Words to search for", ""),
+			openapi.QueryParam("limit", "Files per page of a q search, up to 1000", 50),
+			openapi.QueryParam("page_token", "next_page_token of the previous page", ""),
+			openapi.QueryParam("tag", "Tag as key:value", ""),
+			openapi.QueryParam("fields", "Comma-separated file fields to return, e.g. name,size", ""),
+		},
+		Responses: []openapi.Response{openapi.JSONResponse("Matching files", searchResponse{})},
+		Errors:    []int{http.StatusBadRequest, http.StatusNotImplemented, http.StatusServiceUnavailable},
+	})
+
+	// Feed of changes for incremental syncs
+	router.HandleFunc("GET /api/v1/storage/changes", h.Changes, openapi.Operation{
+		ID:          "listChanges",
+		Tag:         "storage",
+		Summary:     "List changes to objects after a cursor",
+		Description: "Changes come oldest first; pass the returned cursor as since to get the next ones. since=latest returns the current cursor only. 410 means changes after the cursor were dropped and a full listing is needed.",
+		Params: []openapi.Param{
+			openapi.QueryParam("since", "cursor of the previous response, or latest", ""),
+			openapi.QueryParam("limit", "Changes per page, up to 1000", 100),
+		},
+		Responses: []openapi.Response{openapi.JSONResponse("Changes and the cursor to continue from", service.ChangePage{})},
+		Errors:    []int{http.StatusBadRequest, http.StatusGone, http.StatusNotImplemented},
+	})
+
+	// Advisory leases so concurrent writers don't overwrite each other
+	leased := openapi.JSONResponse("The lease", lease.Lease{})
+	router.HandleFunc("GET /api/v1/storage/leases/{path...}", h.GetLease, openapi.Operation{
+		ID:        "getLease",
+		Tag:       "storage",
+		Summary:   "Get the current lease on a path",
+		Params:    []openapi.Param{pathParam},
+		Responses: []openapi.Response{leased},
+		Errors:    []int{http.StatusNotFound, http.StatusNotImplemented},
+	})
+	router.HandleFunc("POST /api/v1/storage/leases/{path...}", h.AcquireLease, openapi.Operation{
+		ID:          "acquireLease",
+		Tag:         "storage",
+		Summary:     "Lease a path",
+		Description: "Pass the returned id as X-Lease-ID to write the path, renew or release the lease. 423 means another client holds the lease.",
+		Params: []openapi.Param{
+			pathParam,
+			openapi.QueryParam("ttl", "Duration of the lease, e.g. 60s", ""),
+		},
+		Responses: []openapi.Response{{Status: http.StatusCreated, Description: "The lease", Body: openapi.JSONBody(lease.Lease{})}},
+		Errors:    []int{http.StatusBadRequest, http.StatusLocked, http.StatusNotImplemented},
+	})
+	router.HandleFunc("PUT /api/v1/storage/leases/{path...}", h.RenewLease, openapi.Operation{
+		ID:      "renewLease",
+		Tag:     "storage",
+		Summary: "Renew a lease",
+		Params: []openapi.Param{
+			pathParam,
+			openapi.HeaderParam("X-Lease-ID", "ID of the lease"),
+			openapi.QueryParam("ttl", "New duration of the lease from now, e.g. 60s", ""),
+		},
+		Responses: []openapi.Response{leased},
+		Errors:    []int{http.StatusBadRequest, http.StatusConflict, http.StatusNotImplemented},
+	})
+	router.HandleFunc("DELETE /api/v1/storage/leases/{path...}", h.ReleaseLease, openapi.Operation{
+		ID:        "releaseLease",
+		Tag:       "storage",
+		Summary:   "Release a lease",
+		Params:    []openapi.Param{pathParam, openapi.HeaderParam("X-Lease-ID", "ID of the lease")},
+		Responses: []openapi.Response{{Status: http.StatusNoContent, Description: "The lease was released"}},
+		Errors:    []int{http.StatusBadRequest, http.StatusConflict, http.StatusNotImplemented},
+	})
+
+	// Append-only logs
+	router.HandleFunc("POST /api/v1/storage/logs/{path...}", withLeases(h.AppendLog), openapi.Operation{
+		ID:          "appendLog",
+		Tag:         "storage",
+		Summary:     "Append a record to a log",
+		Description: "The body is added to the end of the log, which is created by the first append. Concurrent appends from any client are ordered and never overwrite each other.",
+		Params:      []openapi.Param{pathParam, leaseParam},
+		Request:     openapi.BinaryBody("Record"),
+		Responses:   []openapi.Response{openapi.JSONResponse("Position of the record in the log", appendlog.Record{})},
+		Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusLocked, http.StatusNotImplemented},
+	})
+	router.HandleFunc("GET /api/v1/storage/logs/{path...}", h.ReadLog, openapi.Operation{
+		ID:          "readLog",
+		Tag:         "storage",
+		Summary:     "Read a log from an offset",
+		Description: "X-Log-Next-Offset is the offset to continue from and X-Log-Size the length of the log.",
+		Params: []openapi.Param{
+			pathParam,
+			openapi.QueryParam("offset", "Byte offset to read from", 0),
+			openapi.QueryParam("limit", "Bytes to read, up to 16MB", defaultLogReadLimit),
+		},
+		Responses: []openapi.Response{openapi.BinaryResponse("Bytes of the log from the offset")},
+		Errors:    []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotImplemented},
+	})
+
+	// Live object events for dashboards
+	router.HandleFunc("GET /api/v1/storage/events", h.StreamEvents, openapi.Operation{
+		ID:          "streamEvents",
+		Tag:         "storage",
+		Summary:     "Stream object events as they happen",
+		Description: "Server-sent events named after the event type, with the upload notification as data. Repeat prefix to follow several prefixes.",
+		Params:      []openapi.Param{openapi.QueryParam("prefix", "Only events of objects under this prefix", "")},
+		Responses: []openapi.Response{
+			{Status: http.StatusOK, Body: &openapi.Body{ContentType: "text/event-stream", Schema: events.Event{}}},
+		},
+		Errors: []int{http.StatusNotImplemented},
+	})
+
+	// Metadata catalog of the objects written through the proxy
+	catalogPageParams := []openapi.Param{
+		openapi.QueryParam("tenant", "Only entries of this principal", ""),
+		openapi.QueryParam("limit", "Entries per page, up to 1000", 100),
+		openapi.QueryParam("page_token", "next_page_token of the previous page", ""),
+	}
+	router.Handle("GET /api/v1/admin/catalog/objects", auth.RequireScope(auth.ScopeAdmin, http.HandlerFunc(h.CatalogObjects)), openapi.Operation{
+		ID:        "listCatalogObjects",
+		Tag:       "admin",
+		Summary:   "List the objects recorded in the metadata catalog",
+		Params:    append([]openapi.Param{openapi.QueryParam("prefix", "Path prefix", "")}, catalogPageParams...),
+		Responses: []openapi.Response{openapi.JSONResponse("Objects in path order", catalogObjectsResponse{})},
+		Errors:    []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotImplemented},
+	})
+	router.Handle("GET /api/v1/admin/catalog/usage", auth.RequireScope(auth.ScopeAdmin, http.HandlerFunc(h.CatalogUsage)), openapi.Operation{
+		ID:        "getCatalogUsage",
+		Tag:       "admin",
+		Summary:   "Count the objects and bytes each tenant stores",
+		Responses: []openapi.Response{openapi.JSONResponse("Usage by tenant", []catalog.Usage{})},
+		Errors:    []int{http.StatusForbidden, http.StatusNotImplemented},
+	})
+	router.Handle("GET /api/v1/admin/catalog/changes", auth.RequireScope(auth.ScopeAdmin, http.HandlerFunc(h.CatalogChanges)), openapi.Operation{
+		ID:      "listCatalogChanges",
+		Tag:     "admin",
+		Summary: "List the writes and deletions recorded in the metadata catalog",
+		Params: append([]openapi.Param{
+			openapi.QueryParam("path", "Only changes of this object", ""),
+			openapi.QueryParam("since", "Only changes at or after this RFC 3339 time", ""),
+		}, catalogPageParams...),
+		Responses: []openapi.Response{openapi.JSONResponse("Changes, newest first", catalogChangesResponse{})},
+		Errors:    []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotImplemented},
+	})
+
+	// Signed URLs for direct browser uploads
+	router.HandleFunc("POST /api/v1/storage/uploads", h.CreateUpload, openapi.Operation{
+		ID:          "createUpload",
+		Tag:         "storage",
+		Summary:     "Create a signed URL for a direct upload",
+		Description: "The requested post-processing runs once the object lands in the bucket.",
+		Request:     openapi.JSONBody(dto.Upload{}),
+		Responses:   []openapi.Response{openapi.JSONResponse("Where and how to send the file", service.Upload{})},
+		Errors:      []int{http.StatusBadRequest, http.StatusNotImplemented},
+	})
+	router.HandleFunc("POST /api/v1/storage/cdn-tokens", h.CreateCDNToken, openapi.Operation{
+		ID:          "createCDNToken",
+		Tag:         "storage",
+		Summary:     "Mint a token granting read access to every URL under a prefix",
+		Description: "Tokens use the Cloud CDN signed cookie and signed URL prefix formats, so they are accepted both by Cloud CDN and by the proxy. Prefixes must be below the file, stream or poster routes.",
+		Request:     openapi.JSONBody(dto.CDNToken{}),
+		Responses:   []openapi.Response{openapi.JSONResponse("The token as a cookie value and as signed URL parameters", cdnTokenResponse{})},
+		Errors:      []int{http.StatusBadRequest, http.StatusNotImplemented},
+	})
+
+	// Upload quota consumption of the caller
+	router.HandleFunc("GET /api/v1/storage/usage", h.Usage, openapi.Operation{
+		ID:          "getUsage",
+		Tag:         "storage",
+		Summary:     "Get the upload consumption of the API key",
+		Description: "Bytes and objects uploaded in the current UTC day and month, with the quotas that apply.",
+		Responses:   []openapi.Response{openapi.JSONResponse("Consumption and quotas", quota.Usage{})},
+		Errors:      []int{http.StatusBadRequest, http.StatusNotImplemented},
+	})
+
+	// Framed uploads and downloads over WebSocket
+	router.HandleFunc("GET /api/v1/storage/ws", withLeases(withEncryptionKey(h.WebSocket)), openapi.Operation{
+		ID:          "webSocket",
+		Tag:         "storage",
+		Summary:     "Transfer files over a WebSocket",
+		Description: `Uploads start with {"op":"upload","path":...}, continue with binary frames and finish with {"op":"end"}. Downloads are requested with {"op":"download","path":...}.`,
+		Params:      slices.Concat(writeParams, encryptionParams),
+		Responses:   []openapi.Response{{Status: http.StatusSwitchingProtocols, Description: "The connection is upgraded to a WebSocket"}},
+		Errors:      []int{http.StatusBadRequest},
 	})
 
 	// Explicit read endpoint
-	mux.HandleFunc("/api/v1/storage/files/read", h.ReadFiles)
+	router.HandleFunc("POST /api/v1/storage/files/read", withEncryptionKey(h.ReadFiles), openapi.Operation{
+		ID:          "readFiles",
+		Tag:         "storage",
+		Summary:     "Read several files",
+		Description: "With encoding=base64 the content is returned inline, with encoding=url as signed download URLs. Without encoding the legacy format with Go field names is returned. Entries in files read a byte range of each file, inline only. fields needs an encoding. With Accept: application/x-ndjson each file or error is streamed as a line as soon as it is read, base64 encoded unless encoding=url.",
+		Params: slices.Concat([]openapi.Param{
+			openapi.QueryParam("encoding", "base64 or url", ""),
+			openapi.QueryParam("fields", "Comma-separated file fields to return, e.g. name,size", ""),
+		}, encryptionParams),
+		Request: openapi.JSONBody(dto.ReadFiles{}),
+		Responses: []openapi.Response{
+			openapi.JSONResponse("Files and per-file errors", batchReadResponse{}),
+			{Status: http.StatusOK, Body: &openapi.Body{ContentType: ndjsonContentType, Schema: batchReadFile{}}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity, http.StatusNotImplemented},
+	})
 }
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gcp-proxy-mity/internal/dto"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// tagsBody is the body of tag responses
+type tagsBody struct {
+	Tags map[string]string `json:"tags"`
+}
+
+// Page sizes of full-text searches
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 1000
+)
+
+type searchResponse struct {
+	Files []storage.FileMetadata `json:"files"`
+	// Total and NextPageToken are set by full-text searches
+	Total         int    `json:"total,omitempty"`
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// GetTags returns the tags of a file
+// GET /api/v1/storage/tags/{filePath}
+func (h *StorageHandler) GetTags(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	tags, err := h.service.GetTags(r.Context(), filePath)
+	if err != nil {
+		http.Error(w, "Failed to get tags: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, tagsBody{Tags: tags})
+}
+
+// SetTags replaces the tags of a file
+// PUT /api/v1/storage/tags/{filePath} with {"tags": {"project": "apollo"}}
+func (h *StorageHandler) SetTags(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	var request dto.Tags
+	if err := dto.Decode(r.Body, &request); err != nil {
+		writeInvalid(w, r, err)
+		return
+	}
+
+	tags, err := h.service.SetTags(r.Context(), filePath, request.Tags)
+	if err != nil {
+		http.Error(w, "Failed to set tags: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSON(w, tagsBody{Tags: tags})
+}
+
+// Search finds files by name or by tag
+// GET /api/v1/storage/search?q=intro+video&limit=50&page_token=...&fields=name,size
+// GET /api/v1/storage/search?tag=project:apollo&tag=stage:final
+// Files must match all of the words or carry all of the tags
+func (h *StorageHandler) Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	fields, err := parseFields(r, storage.FileMetadata{})
+	if err != nil {
+		http.Error(w, "Invalid fields: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.Has("q") {
+		if query.Has("tag") {
+			http.Error(w, "Search either by q or by tag", http.StatusBadRequest)
+			return
+		}
+		h.searchText(w, r, fields)
+		return
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range query["tag"] {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok || key == "" {
+			http.Error(w, "Tags must be given as key:value", http.StatusBadRequest)
+			return
+		}
+		tags[key] = value
+	}
+
+	files, err := h.service.SearchTags(r.Context(), tags)
+	if err != nil {
+		http.Error(w, "Failed to search files: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	writeJSONFields(w, searchResponse{Files: files}, fields)
+}
+
+// searchText serves a page of the full-text search; page tokens are offsets into the results
+func (h *StorageHandler) searchText(w http.ResponseWriter, r *http.Request, fields fieldSelection) {
+	query := r.URL.Query()
+	limit, offset := defaultSearchLimit, 0
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit: must be between 1 and %d", maxSearchLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if token := query.Get("page_token"); token != "" {
+		n, err := strconv.Atoi(token)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid page_token", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	result, err := h.service.SearchText(r.Context(), query.Get("q"), offset, limit)
+	if err != nil {
+		http.Error(w, "Failed to search files: "+err.Error(), errorStatus(err))
+		return
+	}
+
+	response := searchResponse{Files: make([]storage.FileMetadata, 0, len(result.Entries)), Total: result.Total}
+	for _, entry := range result.Entries {
+		response.Files = append(response.Files, storage.FileMetadata{
+			Name:        entry.Name,
+			ContentType: entry.ContentType,
+			Size:        entry.Size,
+			Updated:     entry.Updated,
+		})
+	}
+	if next := offset + len(result.Entries); next < result.Total {
+		response.NextPageToken = strconv.Itoa(next)
+	}
+	writeJSONFields(w, response, fields)
+}
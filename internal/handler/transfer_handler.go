@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/openapi"
+	"gcp-proxy-mity/internal/progress"
+)
+
+// TransferHandler reports the progress of uploads. Callers only see their own
+// uploads, unless they have the admin scope.
+type TransferHandler struct {
+	tracker *progress.Tracker
+}
+
+func NewTransferHandler(tracker *progress.Tracker) *TransferHandler {
+	return &TransferHandler{
+		tracker: tracker,
+	}
+}
+
+// ListTransfers returns the caller's uploads in progress and recently finished, newest first
+// GET /api/v1/transfers
+func (h *TransferHandler) ListTransfers(w http.ResponseWriter, r *http.Request) {
+	transfers := []progress.Transfer{}
+	for _, transfer := range h.tracker.List() {
+		if visible(r, &transfer) {
+			transfers = append(transfers, transfer)
+		}
+	}
+	writeJSON(w, transfers)
+}
+
+// GetTransfer reports the bytes received and written of an upload
+// GET /api/v1/transfers/{id}
+func (h *TransferHandler) GetTransfer(w http.ResponseWriter, r *http.Request) {
+	transfer, err := h.tracker.Get(r.PathValue("id"))
+	if err != nil && !errors.Is(err, progress.ErrNotFound) {
+		http.Error(w, "Failed to get transfer: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Other principals' transfers are reported missing rather than forbidden
+	if err != nil || !visible(r, transfer) {
+		http.Error(w, progress.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, transfer)
+}
+
+// visible reports whether the caller of r may see transfer
+func visible(r *http.Request, transfer *progress.Transfer) bool {
+	return transfer.Principal == auth.PrincipalFromContext(r.Context()) || auth.HasScope(r.Context(), auth.ScopeAdmin)
+}
+
+func (h *TransferHandler) SetupRoutes(router *Router) {
+	router.HandleFunc("GET /api/v1/transfers", h.ListTransfers, openapi.Operation{
+		ID:        "listTransfers",
+		Tag:       "storage",
+		Summary:   "List uploads in progress and recently finished",
+		Responses: []openapi.Response{openapi.JSONResponse("Transfers, newest first", []progress.Transfer{})},
+	})
+	router.HandleFunc("GET /api/v1/transfers/{id}", h.GetTransfer, openapi.Operation{
+		ID:          "getTransfer",
+		Tag:         "storage",
+		Summary:     "Get the progress of an upload",
+		Description: "Raw uploads get a transfer ID, returned in X-Transfer-ID, when they are large or of unknown length. Clients may choose the ID by sending X-Transfer-ID, so they can poll the progress while the upload runs.",
+		Params:      []openapi.Param{openapi.PathParam("id", "Transfer ID")},
+		Responses:   []openapi.Response{openapi.JSONResponse("The transfer", progress.Transfer{})},
+		Errors:      []int{http.StatusNotFound},
+	})
+}
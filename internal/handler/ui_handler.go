@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"net/http"
+
+	"gcp-proxy-mity/internal/openapi"
+	"gcp-proxy-mity/internal/webui"
+)
+
+// uiPath is where the web UI is served
+const uiPath = "/ui/"
+
+// UIHandler serves the embedded web UI for browsing, previewing and uploading
+// objects. Its files need no credentials; the API calls it makes do.
+type UIHandler struct {
+	files http.Handler
+}
+
+func NewUIHandler() *UIHandler {
+	return &UIHandler{
+		files: http.StripPrefix("/ui", webui.Handler()),
+	}
+}
+
+// ServeUI serves a file of the web UI
+// GET /ui/{path}
+func (h *UIHandler) ServeUI(w http.ResponseWriter, r *http.Request) {
+	h.files.ServeHTTP(w, r)
+}
+
+// Anonymous reports whether the request is for a file of the web UI, so it
+// can be let through without credentials
+func (h *UIHandler) Anonymous(router *Router) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		pattern := router.Pattern(r)
+		return pattern == "GET "+uiPath+"{path...}" || pattern == "GET /ui"
+	}
+}
+
+func (h *UIHandler) SetupRoutes(router *Router) {
+	router.Handle("GET /ui", http.RedirectHandler(uiPath, http.StatusMovedPermanently), openapi.Operation{
+		ID:        "redirectUI",
+		Tag:       "ui",
+		Summary:   "Redirect to the web UI",
+		Responses: []openapi.Response{{Status: http.StatusMovedPermanently, Description: "Redirect to /ui/"}},
+		Public:    true,
+	})
+	router.HandleFunc("GET "+uiPath+"{path...}", h.ServeUI, openapi.Operation{
+		ID:          "serveUI",
+		Tag:         "ui",
+		Summary:     "Serve the web UI",
+		Description: "A single-page UI to browse prefixes, preview images, videos and text, upload files by drag and drop and read object metadata, with a login session or an API key.",
+		Params:      []openapi.Param{openapi.PathParam("path", "File of the UI")},
+		Responses:   []openapi.Response{{Status: http.StatusOK, Description: "HTML page or asset", Body: &openapi.Body{ContentType: "text/html", Schema: ""}}},
+		Public:      true,
+	})
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Progress stream formats for multipart uploads
+const (
+	progressNDJSON = "ndjson"
+	progressSSE    = "sse"
+)
+
+// progressInterval is how many bytes of a file are written between progress events
+const progressInterval = 1 << 20
+
+// progressEvent is one line of the progress stream. Type is "progress", "file", "error" or "done".
+type progressEvent struct {
+	Type         string                `json:"type"`
+	Path         string                `json:"path,omitempty"`
+	Bytes        int64                 `json:"bytes,omitempty"`
+	File         *storage.FileMetadata `json:"file,omitempty"`
+	Deduplicated bool                  `json:"deduplicated,omitempty"`
+	Error        string                `json:"error,omitempty"`
+	FilesWritten *int                  `json:"files_written,omitempty"`
+	Errors       *int                  `json:"errors,omitempty"`
+}
+
+// progressStream writes progress events as NDJSON lines or server-sent events
+type progressStream struct {
+	w   http.ResponseWriter
+	rc  *http.ResponseController
+	sse bool
+}
+
+func newProgressStream(w http.ResponseWriter, format string) *progressStream {
+	s := &progressStream{
+		w:   w,
+		rc:  http.NewResponseController(w),
+		sse: format == progressSSE,
+	}
+
+	// HTTP/1.1 normally stops reading the request once the response starts
+	s.rc.EnableFullDuplex()
+
+	if s.sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	return s
+}
+
+func (s *progressStream) emit(event progressEvent) {
+	data, _ := json.Marshal(event)
+	if s.sse {
+		fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event.Type, data)
+	} else {
+		s.w.Write(append(data, '\n'))
+	}
+	s.rc.Flush()
+}
+
+// progressReader reports the bytes read through it every progressInterval bytes
+type progressReader struct {
+	io.Reader
+	read     int64
+	reported int64
+	report   func(read int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	if r.read-r.reported >= progressInterval {
+		r.reported = r.read
+		r.report(r.read)
+	}
+	return n, err
+}
+
+// writeFilesWithProgress streams each part of a multipart upload to storage as it
+// arrives and reports progress instead of buffering the form and answering at the end
+func (h *StorageHandler) writeFilesWithProgress(w http.ResponseWriter, r *http.Request, format string) {
+	limits := h.service.BatchLimits()
+	if limits.MaxBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limits.MaxBytes)
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Failed to read multipart body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stream := newProgressStream(w, format)
+	opts := writeOptions(r)
+	written, failed, files := 0, 0, 0
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				err = fmt.Errorf("%w: upload exceeds the limit of %d bytes", service.ErrBatchPayloadTooLarge, tooLarge.Limit)
+			}
+			stream.emit(progressEvent{Type: "error", Error: "Failed to read multipart body: " + err.Error()})
+			failed++
+			break
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		filePath := part.FormName()
+		if filePath == "" {
+			filePath = part.FileName()
+		}
+
+		files++
+		if limits.MaxFiles > 0 && files > limits.MaxFiles {
+			stream.emit(progressEvent{Type: "error", Path: filePath, Error: fmt.Sprintf("%v: the limit is %d files", service.ErrBatchTooManyFiles, limits.MaxFiles)})
+			part.Close()
+			failed++
+			break
+		}
+
+		content := &progressReader{Reader: part, report: func(read int64) {
+			stream.emit(progressEvent{Type: "progress", Path: filePath, Bytes: read})
+		}}
+		response, err := h.service.WriteFiles(r.Context(), []storage.WriteRequest{{
+			Path:        filePath,
+			Content:     content,
+			ContentType: part.Header.Get("Content-Type"),
+		}}, opts...)
+		part.Close()
+
+		switch {
+		case err != nil:
+			stream.emit(progressEvent{Type: "error", Path: filePath, Error: err.Error()})
+			failed++
+		case len(response.FilesWritten) == 0:
+			for _, writeErr := range response.Errors {
+				stream.emit(progressEvent{Type: "error", Path: writeErr.FilePath, Error: writeErr.Error})
+			}
+			failed++
+		default:
+			file := response.FilesWritten[0]
+			stream.emit(progressEvent{Type: "file", Path: filePath, Bytes: content.read, File: &file, Deduplicated: len(response.Deduplicated) > 0})
+			written++
+		}
+	}
+
+	stream.emit(progressEvent{Type: "done", FilesWritten: &written, Errors: &failed})
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// usageRetryAfter is how many seconds clients are asked to wait before asking
+// again for usage still being computed
+const usageRetryAfter = 5
+
+// GetPrefixUsage returns the number and size of the objects under a prefix
+// GET /api/v1/storage/usage/prefix?prefix=users/42/&async=true
+// Usage that takes too long to compute is answered with 202 and pending set; it
+// is computed in the background and returned by a later request
+func (h *StorageHandler) GetPrefixUsage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	async := false
+	if value := query.Get("async"); value != "" {
+		var err error
+		if async, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "async must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	usage, err := h.service.PrefixUsage(r.Context(), query.Get("prefix"), async)
+	if err != nil {
+		http.Error(w, "Failed to compute usage: "+err.Error(), errorStatus(err))
+		return
+	}
+	if usage.Pending {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(usageRetryAfter))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(usage)
+		return
+	}
+	writeJSON(w, usage)
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gcp-proxy-mity/internal/dto"
+	"gcp-proxy-mity/internal/middleware"
+)
+
+// invalidResponse is the JSON error envelope of requests failing validation
+type invalidResponse struct {
+	middleware.ErrorResponse
+	Violations []dto.Violation `json:"violations"`
+}
+
+// writeInvalid answers a request failing validation with 400 Bad Request,
+// listing every violated constraint
+func writeInvalid(w http.ResponseWriter, r *http.Request, err error) {
+	var invalid *dto.ValidationError
+	if !errors.As(err, &invalid) {
+		middleware.WriteError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(invalidResponse{
+		ErrorResponse: middleware.ErrorResponse{
+			Error:     invalid.Error(),
+			RequestID: middleware.RequestIDFrom(r.Context()),
+		},
+		Violations: invalid.Violations,
+	})
+}
+
+// checkPath validates the object path of a message that has no body DTO
+func checkPath(filePath string) error {
+	if message := dto.CheckPath(filePath); message != "" {
+		return dto.Invalid("path", message)
+	}
+	return nil
+}
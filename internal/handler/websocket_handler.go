@@ -0,0 +1,317 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/storage"
+)
+
+const (
+	// wsChunkSize is the size of the binary frames a download is sent in
+	wsChunkSize = 256 << 10
+	// wsMaxFrame is the largest frame accepted from clients
+	wsMaxFrame = 4 << 20
+	// wsIdleTimeout closes connections that send nothing for this long
+	wsIdleTimeout = 60 * time.Second
+	// wsWriteTimeout bounds how long a single frame may take to send
+	wsWriteTimeout = 30 * time.Second
+)
+
+var (
+	errUploadFinished = errors.New("upload already finished")
+	errUploadAborted  = errors.New("upload aborted")
+)
+
+// Browsers can't set headers on WebSocket requests; the default origin check
+// still rejects cross-site pages from using a user's credentials
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  64 << 10,
+	WriteBufferSize: 64 << 10,
+}
+
+// wsRequest is a control message sent by the client as a text frame
+type wsRequest struct {
+	Op          string `json:"op"`
+	Path        string `json:"path,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+}
+
+// wsMessage is a message sent to the client as a text frame. Type is "ready",
+// "ack", "file", "begin", "end" or "error".
+type wsMessage struct {
+	Type         string                `json:"type"`
+	Path         string                `json:"path,omitempty"`
+	Bytes        int64                 `json:"bytes,omitempty"`
+	File         *storage.FileMetadata `json:"file,omitempty"`
+	SHA256       string                `json:"sha256,omitempty"`
+	Deduplicated bool                  `json:"deduplicated,omitempty"`
+	Error        string                `json:"error,omitempty"`
+}
+
+// wsUpload is an upload in progress. Binary frames are written to pipe, which
+// the service reads from, so a slow bucket write holds back the next frame.
+type wsUpload struct {
+	path     string
+	pipe     *io.PipeWriter
+	cancel   context.CancelFunc
+	hash     hash.Hash
+	received int64
+	// failed is set once the content can no longer be written; later frames are discarded
+	failed error
+	done   chan wsResult
+}
+
+type wsResult struct {
+	response *storage.WriteResponse
+	err      error
+}
+
+// wsSession serves one WebSocket connection. Operations run one at a time.
+type wsSession struct {
+	h      *StorageHandler
+	conn   *websocket.Conn
+	ctx    context.Context
+	opts   []service.WriteOption
+	upload *wsUpload
+}
+
+// WebSocket uploads and downloads objects over a WebSocket connection
+// GET /api/v1/storage/ws
+// Uploads start with {"op":"upload","path":...}, continue with binary frames and
+// finish with {"op":"end"}. Downloads are requested with {"op":"download","path":...}.
+func (h *StorageHandler) WebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an error
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(wsMaxFrame)
+
+	s := &wsSession{
+		h:    h,
+		conn: conn,
+		ctx:  r.Context(),
+		opts: writeOptions(r),
+	}
+	s.run()
+}
+
+func (s *wsSession) run() {
+	defer s.abortUpload(errUploadAborted)
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+		kind, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if kind == websocket.BinaryMessage {
+			s.chunk(data)
+			continue
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.fail("", "Invalid message: "+err.Error())
+			continue
+		}
+
+		switch req.Op {
+		case "upload":
+			s.startUpload(req)
+		case "end":
+			s.finishUpload()
+		case "abort":
+			if s.upload != nil {
+				path := s.upload.path
+				s.abortUpload(errUploadAborted)
+				s.fail(path, errUploadAborted.Error())
+			}
+		case "download":
+			s.download(strings.TrimPrefix(req.Path, "/"))
+		default:
+			s.fail(req.Path, fmt.Sprintf("Unknown op %q", req.Op))
+		}
+	}
+}
+
+func (s *wsSession) send(msg wsMessage) error {
+	data, _ := json.Marshal(msg)
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (s *wsSession) fail(path, message string) {
+	s.send(wsMessage{Type: "error", Path: path, Error: message})
+}
+
+func (s *wsSession) startUpload(req wsRequest) {
+	if s.upload != nil {
+		s.fail(req.Path, "An upload is already in progress")
+		return
+	}
+
+	filePath := strings.TrimPrefix(req.Path, "/")
+	if err := checkPath(filePath); err != nil {
+		s.fail(filePath, err.Error())
+		return
+	}
+	// Without a content type the service sniffs it from the content
+	checkedType := req.ContentType
+	if checkedType == "" {
+		checkedType = storage.DetectContentType(filePath)
+	}
+	if err := s.h.service.CheckContentType(checkedType); err != nil {
+		s.fail(filePath, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	reader, pipe := io.Pipe()
+	upload := &wsUpload{
+		path:   filePath,
+		pipe:   pipe,
+		cancel: cancel,
+		hash:   sha256.New(),
+		done:   make(chan wsResult, 1),
+	}
+
+	go func() {
+		response, err := s.h.service.WriteFiles(ctx, []storage.WriteRequest{{
+			Path:        filePath,
+			Content:     reader,
+			ContentType: req.ContentType,
+			SHA256:      req.SHA256,
+		}}, s.opts...)
+		// Deduplicated or rejected writes stop reading early; unblock the next frame
+		reader.CloseWithError(errUploadFinished)
+		upload.done <- wsResult{response: response, err: err}
+	}()
+
+	s.upload = upload
+	s.send(wsMessage{Type: "ready", Path: filePath})
+}
+
+// chunk writes a binary frame of the current upload and acknowledges it once the
+// bucket writer has taken it, so clients can bound the data they have in flight
+func (s *wsSession) chunk(data []byte) {
+	upload := s.upload
+	if upload == nil {
+		s.fail("", "Binary frame without an upload")
+		return
+	}
+	if upload.failed != nil {
+		return
+	}
+
+	upload.received += int64(len(data))
+	if s.h.maxUploadBytes > 0 && upload.received > s.h.maxUploadBytes {
+		upload.failed = fmt.Errorf("upload exceeds the limit of %d bytes", s.h.maxUploadBytes)
+		upload.cancel()
+		upload.pipe.CloseWithError(upload.failed)
+		return
+	}
+
+	upload.hash.Write(data)
+	if _, err := upload.pipe.Write(data); err != nil {
+		upload.failed = err
+		return
+	}
+	s.send(wsMessage{Type: "ack", Path: upload.path, Bytes: upload.received})
+}
+
+func (s *wsSession) finishUpload() {
+	upload := s.upload
+	if upload == nil {
+		s.fail("", "No upload in progress")
+		return
+	}
+	s.upload = nil
+	defer upload.cancel()
+
+	upload.pipe.Close()
+	result := <-upload.done
+
+	switch {
+	case upload.failed != nil && upload.failed != errUploadFinished:
+		s.fail(upload.path, upload.failed.Error())
+	case result.err != nil:
+		s.fail(upload.path, result.err.Error())
+	case len(result.response.FilesWritten) == 0:
+		message := "No file was written"
+		if len(result.response.Errors) > 0 {
+			message = result.response.Errors[0].Error
+		}
+		s.fail(upload.path, message)
+	default:
+		file := result.response.FilesWritten[0]
+		s.send(wsMessage{
+			Type:         "file",
+			Path:         upload.path,
+			Bytes:        upload.received,
+			File:         &file,
+			SHA256:       hex.EncodeToString(upload.hash.Sum(nil)),
+			Deduplicated: len(result.response.Deduplicated) > 0,
+		})
+	}
+}
+
+// abortUpload drops the current upload. The write context is cancelled before the
+// pipe is closed so the bucket discards the object instead of storing a partial one.
+func (s *wsSession) abortUpload(err error) {
+	upload := s.upload
+	if upload == nil {
+		return
+	}
+	s.upload = nil
+	upload.cancel()
+	upload.pipe.CloseWithError(err)
+	<-upload.done
+}
+
+// download sends the object as a begin message, binary frames and an end message with its SHA-256
+func (s *wsSession) download(filePath string) {
+	if s.upload != nil {
+		s.fail(filePath, "An upload is in progress")
+		return
+	}
+	if err := checkPath(filePath); err != nil {
+		s.fail(filePath, err.Error())
+		return
+	}
+
+	data, err := s.h.service.ReadFile(s.ctx, filePath)
+	if err != nil {
+		s.fail(filePath, "Failed to read file: "+err.Error())
+		return
+	}
+
+	if err := s.send(wsMessage{Type: "begin", Path: filePath, File: &data.Metadata}); err != nil {
+		return
+	}
+	for offset := 0; offset < len(data.Content); offset += wsChunkSize {
+		end := min(offset+wsChunkSize, len(data.Content))
+		s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := s.conn.WriteMessage(websocket.BinaryMessage, data.Content[offset:end]); err != nil {
+			return
+		}
+	}
+	sum := sha256.Sum256(data.Content)
+	s.send(wsMessage{Type: "end", Path: filePath, Bytes: int64(len(data.Content)), SHA256: hex.EncodeToString(sum[:])})
+}
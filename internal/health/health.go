@@ -0,0 +1,48 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CheckFunc probes a dependency
+type CheckFunc func(ctx context.Context) error
+
+// Checker runs a dependency check with a timeout and caches the result so
+// frequent probes don't translate into backend calls
+type Checker struct {
+	check   CheckFunc
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu        sync.Mutex
+	lastErr   error
+	checkedAt time.Time
+}
+
+// NewChecker creates a checker caching results for ttl
+func NewChecker(check CheckFunc, ttl, timeout time.Duration) *Checker {
+	return &Checker{
+		check:   check,
+		ttl:     ttl,
+		timeout: timeout,
+	}
+}
+
+// Check returns the cached result, or runs the check if the cache expired
+func (c *Checker) Check(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < c.ttl {
+		return c.lastErr
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	c.lastErr = c.check(ctx)
+	c.checkedAt = time.Now()
+	return c.lastErr
+}
@@ -0,0 +1,37 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChecker_CachesResult(t *testing.T) {
+	calls := 0
+	checker := NewChecker(func(ctx context.Context) error {
+		calls++
+		return errors.New("unreachable")
+	}, time.Hour, time.Second)
+
+	for i := 0; i < 3; i++ {
+		if err := checker.Check(context.Background()); err == nil {
+			t.Error("Expected cached error")
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("Expected 1 backend call, got %d", calls)
+	}
+}
+
+func TestChecker_Timeout(t *testing.T) {
+	checker := NewChecker(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, 0, 10*time.Millisecond)
+
+	if err := checker.Check(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
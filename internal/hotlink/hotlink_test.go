@@ -0,0 +1,68 @@
+package hotlink
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gcp-proxy-mity/internal/auth"
+)
+
+func TestGuard_Allow(t *testing.T) {
+	guard := New(Config{
+		Paths:          []string{"/api/v1/storage/stream/"},
+		AllowedOrigins: []string{"example.com", "*.partner.org"},
+		AllowEmpty:     true,
+	})
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		origin    string
+		referer   string
+		principal string
+		expected  bool
+	}{
+		{name: "allowed referer", path: "/api/v1/storage/stream/a.m3u8", referer: "https://example.com/watch", expected: true},
+		{name: "allowed origin", path: "/api/v1/storage/stream/a.m3u8", origin: "https://example.com", expected: true},
+		{name: "allowed subdomain", path: "/api/v1/storage/stream/a.m3u8", referer: "https://www.partner.org/", expected: true},
+		{name: "apex of a wildcard", path: "/api/v1/storage/stream/a.m3u8", referer: "https://partner.org/", expected: false},
+		{name: "other site", path: "/api/v1/storage/stream/a.m3u8", referer: "https://evil.example/", expected: false},
+		{name: "origin wins over referer", path: "/api/v1/storage/stream/a.m3u8", origin: "https://evil.example", referer: "https://example.com/", expected: false},
+		{name: "lookalike domain", path: "/api/v1/storage/stream/a.m3u8", referer: "https://notexample.com/", expected: false},
+		{name: "same host", path: "/api/v1/storage/stream/a.m3u8", referer: "http://proxy.test/player", expected: true},
+		{name: "no referer", path: "/api/v1/storage/stream/a.m3u8", expected: true},
+		{name: "unprotected path", path: "/api/v1/storage/files/a.mp4", referer: "https://evil.example/", expected: true},
+		{name: "write method", method: http.MethodPut, path: "/api/v1/storage/stream/a.m3u8", referer: "https://evil.example/", expected: true},
+		{name: "token bypass", path: "/api/v1/storage/stream/a.m3u8", referer: "https://evil.example/", principal: auth.CDNTokenPrincipal, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "http://proxy.test:8080"+tt.path, nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			if tt.principal != "" {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), tt.principal))
+			}
+			if got := guard.Allow(r); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	guard.SetConfig(Config{Paths: []string{"/api/v1/storage/stream/"}})
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/storage/stream/a.m3u8", nil)
+	if guard.Allow(r) {
+		t.Error("Expected requests without a referer to be rejected unless allowed")
+	}
+}
@@ -0,0 +1,7 @@
+package iap
+
+import "errors"
+
+var (
+	ErrInvalidAssertion = errors.New("invalid IAP assertion")
+)
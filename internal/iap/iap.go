@@ -0,0 +1,141 @@
+package iap
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/idtoken"
+
+	"gcp-proxy-mity/internal/auth"
+)
+
+// Header carries the JWT Identity-Aware Proxy signs for the requests it lets through
+const Header = "X-Goog-IAP-JWT-Assertion"
+
+// issuer is the iss claim of IAP assertions
+const issuer = "https://cloud.google.com/iap"
+
+// Access levels of grants; write includes read
+const (
+	AccessRead  = "read"
+	AccessWrite = "write"
+)
+
+// Validator checks the signature, expiry and audience of a JWT, like idtoken.Validator
+type Validator interface {
+	Validate(ctx context.Context, token, audience string) (*idtoken.Payload, error)
+}
+
+// Verifier authenticates requests by the identity IAP asserts for them
+type Verifier struct {
+	validator Validator
+	audience  string
+}
+
+// NewVerifier creates a verifier accepting assertions for audience, which is
+// /projects/NUMBER/global/backendServices/ID, or /projects/NUMBER/apps/PROJECT_ID on App Engine
+func NewVerifier(validator Validator, audience string) *Verifier {
+	return &Verifier{
+		validator: validator,
+		audience:  audience,
+	}
+}
+
+// Identify returns the email of the Google identity IAP asserted for the
+// request; ok is false for requests without an assertion
+func (v *Verifier) Identify(r *http.Request) (email string, ok bool, err error) {
+	token := r.Header.Get(Header)
+	if token == "" {
+		return "", false, nil
+	}
+	payload, err := v.validator.Validate(r.Context(), token, v.audience)
+	if err != nil {
+		return "", true, fmt.Errorf("%w: %v", ErrInvalidAssertion, err)
+	}
+	email, _ = payload.Claims["email"].(string)
+	if payload.Issuer != issuer || email == "" {
+		return "", true, fmt.Errorf("%w: not issued by IAP for an identity", ErrInvalidAssertion)
+	}
+	return strings.ToLower(email), true, nil
+}
+
+// Grant gives Members access to the objects under Prefix
+type Grant struct {
+	// Members are emails like alice@example.com, or domain:example.com for
+	// every identity of a domain
+	Members []string
+	// Prefix "" grants access to the whole bucket
+	Prefix string
+	Access string
+}
+
+// Authorizer restricts the requests of IAP identities to the objects they
+// were granted. Requests authenticated otherwise, like by API key, pass.
+type Authorizer struct {
+	mu     sync.RWMutex
+	grants []Grant
+	// access returns the object path a request names in its URL, "" for
+	// requests that may touch any object, and whether it writes
+	access func(*http.Request) (string, bool)
+}
+
+// NewAuthorizer creates an authorizer without grants, which refuses every identity
+func NewAuthorizer(access func(*http.Request) (path string, write bool)) *Authorizer {
+	return &Authorizer{
+		access: access,
+	}
+}
+
+// SetGrants replaces the grants at runtime
+func (a *Authorizer) SetGrants(grants []Grant) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.grants = grants
+}
+
+// Middleware answers requests outside an identity's grants with 403 Forbidden.
+// It must run after authentication.
+func (a *Authorizer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := auth.IdentityFromContext(r.Context())
+		if identity == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path, write := a.access(r)
+		if !a.Allowed(identity, path, write) {
+			access := AccessRead
+			if write {
+				access = AccessWrite
+			}
+			http.Error(w, fmt.Sprintf("Forbidden: %s has no %s access to /%s", identity, access, path), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Allowed reports whether a grant gives identity access to path
+func (a *Authorizer) Allowed(identity, path string, write bool) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, grant := range a.grants {
+		if (grant.Access == AccessWrite || !write) && strings.HasPrefix(path, grant.Prefix) && member(identity, grant.Members) {
+			return true
+		}
+	}
+	return false
+}
+
+func member(identity string, members []string) bool {
+	for _, m := range members {
+		m = strings.ToLower(m)
+		if domain, ok := strings.CutPrefix(m, "domain:"); ok && strings.HasSuffix(identity, "@"+domain) || m == identity {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package iap
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/idtoken"
+
+	"gcp-proxy-mity/internal/auth"
+)
+
+const audience = "/projects/123/global/backendServices/456"
+
+type fakeValidator map[string]*idtoken.Payload
+
+func (v fakeValidator) Validate(ctx context.Context, token, aud string) (*idtoken.Payload, error) {
+	payload, ok := v[token]
+	if !ok || payload.Audience != aud {
+		return nil, errors.New("invalid token")
+	}
+	return payload, nil
+}
+
+func TestVerifier_Identify(t *testing.T) {
+	verifier := NewVerifier(fakeValidator{
+		"alice":  {Issuer: issuer, Audience: audience, Claims: map[string]any{"email": "Alice@Example.com"}},
+		"other":  {Issuer: issuer, Audience: "/projects/123/global/backendServices/789", Claims: map[string]any{"email": "bob@example.com"}},
+		"google": {Issuer: "https://accounts.google.com", Audience: audience, Claims: map[string]any{"email": "bob@example.com"}},
+	}, audience)
+
+	tests := []struct {
+		name     string
+		token    string
+		email    string
+		asserted bool
+		err      error
+	}{
+		{name: "valid", token: "alice", email: "alice@example.com", asserted: true},
+		{name: "no assertion", token: ""},
+		{name: "other audience", token: "other", asserted: true, err: ErrInvalidAssertion},
+		{name: "not from IAP", token: "google", asserted: true, err: ErrInvalidAssertion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/storage/files/a.txt", nil)
+			if tt.token != "" {
+				r.Header.Set(Header, tt.token)
+			}
+			email, asserted, err := verifier.Identify(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected error %v, got %v", tt.err, err)
+			}
+			if email != tt.email || asserted != tt.asserted {
+				t.Errorf("Expected %q asserted %v, got %q asserted %v", tt.email, tt.asserted, email, asserted)
+			}
+		})
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	authorizer := NewAuthorizer(func(r *http.Request) (string, bool) {
+		return strings.TrimPrefix(r.URL.Path, "/files/"), r.Method != http.MethodGet
+	})
+	authorizer.SetGrants([]Grant{
+		{Members: []string{"alice@example.com"}, Prefix: "reports/", Access: AccessWrite},
+		{Members: []string{"domain:example.com"}, Prefix: "public/", Access: AccessRead},
+		{Members: []string{"ops@example.com"}, Prefix: "", Access: AccessRead},
+	})
+	authenticator := auth.NewAPIKeyAuthenticator([]auth.Key{{Name: "ci", Key: "ci-key"}})
+	authenticator.AcceptIdentities(func(r *http.Request) (string, bool, error) {
+		identity := r.Header.Get("X-Test-Identity")
+		return identity, identity != "", nil
+	})
+	h := authenticator.Middleware(authorizer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		identity string
+		apiKey   string
+		expected int
+	}{
+		{name: "granted write", method: http.MethodPut, path: "/files/reports/q1.csv", identity: "alice@example.com", expected: http.StatusOK},
+		{name: "outside the prefix", method: http.MethodGet, path: "/files/private/a.txt", identity: "alice@example.com", expected: http.StatusForbidden},
+		{name: "domain read", method: http.MethodGet, path: "/files/public/logo.png", identity: "bob@example.com", expected: http.StatusOK},
+		{name: "domain write", method: http.MethodPut, path: "/files/public/logo.png", identity: "bob@example.com", expected: http.StatusForbidden},
+		{name: "lookalike domain", method: http.MethodGet, path: "/files/public/logo.png", identity: "eve@notexample.com", expected: http.StatusForbidden},
+		{name: "whole bucket", method: http.MethodGet, path: "/files/", identity: "ops@example.com", expected: http.StatusOK},
+		{name: "any object needs the whole bucket", method: http.MethodGet, path: "/files/", identity: "alice@example.com", expected: http.StatusForbidden},
+		{name: "api key", method: http.MethodPut, path: "/files/private/a.txt", apiKey: "ci-key", expected: http.StatusOK},
+		{name: "no credentials", method: http.MethodGet, path: "/files/public/logo.png", expected: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.identity != "" {
+				r.Header.Set("X-Test-Identity", tt.identity)
+			}
+			if tt.apiKey != "" {
+				r.Header.Set("X-API-Key", tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/metrics"
+)
+
+// Header carries the client's key for a request it may retry
+const Header = "Idempotency-Key"
+
+// ReplayedHeader marks responses replayed for a retry
+const ReplayedHeader = "Idempotent-Replayed"
+
+// maxKeyLength bounds the keys clients may send
+const maxKeyLength = 255
+
+// maxBodyBytes is the largest response stored for replays. Requests with
+// larger responses can be retried, but aren't replayed.
+const maxBodyBytes = 1 << 20
+
+// pendingTTL is how long a key stays claimed by a request in progress, so
+// the key of a request cut off by a crash is freed again
+const pendingTTL = 15 * time.Minute
+
+var replays = metrics.NewCounter("gcs_proxy_idempotent_replays_total", "Responses replayed for retried requests with an Idempotency-Key")
+
+// Record is the state of a key: claimed by a request in progress while
+// Status is zero, then the response to replay
+type Record struct {
+	// Fingerprint identifies the request the key was first used for
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// Store keeps the records of keys until they expire
+type Store interface {
+	// Reserve stores record under key for ttl unless the key exists. It
+	// returns the existing record if there is one, and nil if record was stored.
+	Reserve(ctx context.Context, key string, record Record, ttl time.Duration) (*Record, error)
+	// Complete replaces the record of key, keeping it for ttl
+	Complete(ctx context.Context, key string, record Record, ttl time.Duration) error
+	// Release deletes the record of key
+	Release(ctx context.Context, key string) error
+}
+
+// Replayer answers retries of write requests carrying an Idempotency-Key
+// with the response to the first request, instead of running them again.
+// Keys are scoped to the authenticated principal, so the middleware must run
+// after authentication.
+type Replayer struct {
+	store Store
+	ttl   time.Duration
+}
+
+// New creates a replayer keeping responses in store for ttl
+func New(store Store, ttl time.Duration) *Replayer {
+	return &Replayer{
+		store: store,
+		ttl:   ttl,
+	}
+}
+
+// Middleware replays the responses of requests whose key was used before.
+// A key still in use by another request is answered with 409 Conflict, and a
+// key used before for a different request with 422 Unprocessable Entity.
+func (p *Replayer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientKey := r.Header.Get(Header)
+		if clientKey == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(clientKey) > maxKeyLength {
+			http.Error(w, "Idempotency-Key must be at most 255 characters", http.StatusBadRequest)
+			return
+		}
+
+		key := auth.PrincipalFromContext(r.Context()) + ":" + clientKey
+		fingerprint := requestFingerprint(r)
+		existing, err := p.store.Reserve(r.Context(), key, Record{Fingerprint: fingerprint}, pendingTTL)
+		if err != nil {
+			// Without the store requests run as if they had no key
+			log.Printf("Failed to check Idempotency-Key, serving the request without it: %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+			case existing.Status == 0:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+			default:
+				replays.Inc()
+				replay(w, existing)
+			}
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			// The key is freed when the request panics or fails in a way a retry may fix
+			ctx := context.WithoutCancel(r.Context())
+			if !completed || !rec.storable() {
+				if err := p.store.Release(ctx, key); err != nil {
+					log.Printf("Failed to release Idempotency-Key: %v", err)
+				}
+				return
+			}
+			record := Record{Fingerprint: fingerprint, Status: rec.Status(), Header: rec.header, Body: rec.body}
+			if err := p.store.Complete(ctx, key, record, p.ttl); err != nil {
+				log.Printf("Failed to store the response for Idempotency-Key: %v", err)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		completed = true
+	})
+}
+
+// requestFingerprint identifies a request by its method and URL. Bodies are
+// not compared, since uploads may be too large to hash before they are stored.
+func requestFingerprint(r *http.Request) string {
+	sum := sha256.Sum256([]byte(r.Method + " " + r.URL.RequestURI()))
+	return hex.EncodeToString(sum[:])
+}
+
+// replay writes a stored response. Headers set for this request, like its
+// request ID, are kept.
+func replay(w http.ResponseWriter, record *Record) {
+	for name, values := range record.Header {
+		if _, ok := w.Header()[name]; !ok {
+			w.Header()[name] = values
+		}
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.Header().Set("Content-Length", strconv.Itoa(len(record.Body)))
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+// recorder keeps a copy of the response while writing it
+type recorder struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     []byte
+	overflow bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+		r.header = r.ResponseWriter.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	if !r.overflow {
+		if len(r.body)+len(p) > maxBodyBytes {
+			r.overflow = true
+			r.body = nil
+		} else {
+			r.body = append(r.body, p...)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Status returns the response status, 200 if the handler wrote nothing
+func (r *recorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// storable reports whether the response can be replayed. Server errors and
+// statuses that ask to retry later are not, so the retry runs the request.
+func (r *recorder) storable() bool {
+	if r.overflow {
+		return false
+	}
+	switch status := r.Status(); status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusLocked, http.StatusTooEarly, http.StatusTooManyRequests:
+		return false
+	default:
+		return status < 500
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// MemoryStore keeps records in memory, so retries are only recognized by the
+// replica that served the first request
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]memoryRecord
+	pruned  time.Time
+	now     func() time.Time
+}
+
+type memoryRecord struct {
+	record  Record
+	expires time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]memoryRecord),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, key string, record Record, ttl time.Duration) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	// Expired records are dropped once a minute rather than on every request
+	if now.Sub(s.pruned) >= time.Minute {
+		for k, existing := range s.records {
+			if !now.Before(existing.expires) {
+				delete(s.records, k)
+			}
+		}
+		s.pruned = now
+	}
+	if existing, ok := s.records[key]; ok && now.Before(existing.expires) {
+		return &existing.record, nil
+	}
+	s.records[key] = memoryRecord{record: record, expires: now.Add(ttl)}
+	return nil, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, key string, record Record, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = memoryRecord{record: record, expires: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
@@ -0,0 +1,148 @@
+package idempotency
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+)
+
+func TestReplayer_Middleware(t *testing.T) {
+	var calls atomic.Int64
+	status := http.StatusCreated
+	replayer := New(NewMemoryStore(), time.Hour)
+	handler := replayer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, `{"call":`+string(rune('0'+n))+`}`)
+	}))
+
+	send := func(method, target, key, principal string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader("data"))
+		if key != "" {
+			r.Header.Set(Header, key)
+		}
+		if principal != "" {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		key          string
+		principal    string
+		wantStatus   int
+		wantBody     string
+		wantReplayed bool
+	}{
+		{name: "first request", method: http.MethodPut, target: "/api/v1/storage/files/a.jpg", key: "k1", wantStatus: http.StatusCreated, wantBody: `{"call":1}`},
+		{name: "retry", method: http.MethodPut, target: "/api/v1/storage/files/a.jpg", key: "k1", wantStatus: http.StatusCreated, wantBody: `{"call":1}`, wantReplayed: true},
+		{name: "key of another principal", method: http.MethodPut, target: "/api/v1/storage/files/a.jpg", key: "k1", principal: "other", wantStatus: http.StatusCreated, wantBody: `{"call":2}`},
+		{name: "key reused for another path", method: http.MethodPut, target: "/api/v1/storage/files/b.jpg", key: "k1", wantStatus: http.StatusUnprocessableEntity},
+		{name: "no key", method: http.MethodPut, target: "/api/v1/storage/files/a.jpg", wantStatus: http.StatusCreated, wantBody: `{"call":3}`},
+		{name: "reads ignore keys", method: http.MethodGet, target: "/api/v1/storage/files/a.jpg", key: "k1", wantStatus: http.StatusCreated, wantBody: `{"call":4}`},
+		{name: "key too long", method: http.MethodPost, target: "/api/v1/storage/files", key: strings.Repeat("k", 256), wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := send(tt.method, tt.target, tt.key, tt.principal)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("Expected body %s, got %s", tt.wantBody, w.Body.String())
+			}
+			if replayed := w.Header().Get(ReplayedHeader) == "true"; replayed != tt.wantReplayed {
+				t.Errorf("Expected replayed %v, got %v", tt.wantReplayed, replayed)
+			}
+			if tt.wantReplayed && w.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Expected the original headers to be replayed, got %v", w.Header())
+			}
+		})
+	}
+
+	// Server errors free the key so the retry runs again
+	status = http.StatusBadGateway
+	before := calls.Load()
+	send(http.MethodPost, "/api/v1/storage/files", "k2", "")
+	status = http.StatusOK
+	if w := send(http.MethodPost, "/api/v1/storage/files", "k2", ""); w.Header().Get(ReplayedHeader) != "" || calls.Load() != before+2 {
+		t.Errorf("Expected the retry of a failed request to run, got %d calls", calls.Load()-before)
+	}
+}
+
+func TestReplayer_InProgress(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	replayer := New(NewMemoryStore(), time.Hour)
+	handler := replayer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/storage/files/a.jpg", nil)
+		r.Header.Set(Header, "k")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		close(done)
+	}()
+	<-started
+
+	r := httptest.NewRequest(http.MethodPut, "/api/v1/storage/files/a.jpg", nil)
+	r.Header.Set(Header, "k")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected %d while the first request runs, got %d", http.StatusConflict, w.Code)
+	}
+	close(release)
+	<-done
+}
+
+func TestMemoryStore_Expiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	if existing, err := store.Reserve(ctx, "a", Record{Fingerprint: "1"}, 10*time.Second); existing != nil || err != nil {
+		t.Fatalf("Expected the key to be reserved, got %v, %v", existing, err)
+	}
+	if existing, _ := store.Reserve(ctx, "a", Record{Fingerprint: "2"}, 10*time.Second); existing == nil || existing.Fingerprint != "1" {
+		t.Errorf("Expected the first reservation, got %+v", existing)
+	}
+
+	// An expired record is ignored before it is pruned
+	now = now.Add(20 * time.Second)
+	if existing, _ := store.Reserve(ctx, "a", Record{Fingerprint: "3"}, 10*time.Second); existing != nil {
+		t.Errorf("Expected the expired record to be ignored, got %+v", existing)
+	}
+	if _, err := store.Reserve(ctx, "b", Record{Fingerprint: "4"}, 10*time.Second); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(store.records) != 2 {
+		t.Errorf("Expected expired records to be kept until the next prune, got %d records", len(store.records))
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := store.Reserve(ctx, "c", Record{Fingerprint: "5"}, 10*time.Second); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(store.records) != 1 {
+		t.Errorf("Expected expired records to be pruned after a minute, got %d records", len(store.records))
+	}
+}
@@ -0,0 +1,11 @@
+package imaging
+
+import "errors"
+
+var (
+	ErrInvalidOptions    = errors.New("invalid image transformation options")
+	ErrUnsupportedImage  = errors.New("source is not a supported image")
+	ErrUnsupportedFormat = errors.New("output format is not supported by this build")
+	ErrInvalidSignature  = errors.New("invalid transformation signature")
+	ErrImageTooLarge     = errors.New("source image is too large to transform")
+)
@@ -0,0 +1,229 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// MaxDimension caps requested widths and heights to keep decode/encode bounded
+	MaxDimension = 4096
+
+	// MaxSourcePixels caps the width×height of images that are decoded, since a small file can expand to gigabytes
+	MaxSourcePixels = 50_000_000
+
+	defaultQuality = 85
+)
+
+// Fit modes control how an image is mapped onto the requested box
+const (
+	FitContain = "contain"
+	FitCover   = "cover"
+	FitFill    = "fill"
+)
+
+// Options describes a requested image transformation
+type Options struct {
+	Width   int
+	Height  int
+	Quality int
+	Format  string
+	Fit     string
+}
+
+// Encoder encodes an image into a specific output format
+type Encoder func(buf *bytes.Buffer, img image.Image, quality int) error
+
+var encoders = map[string]Encoder{
+	"jpeg": func(buf *bytes.Buffer, img image.Image, quality int) error {
+		return jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	},
+	"png": func(buf *bytes.Buffer, img image.Image, _ int) error {
+		return png.Encode(buf, img)
+	},
+	"gif": func(buf *bytes.Buffer, img image.Image, _ int) error {
+		return gif.Encode(buf, img, nil)
+	},
+}
+
+// knownFormats maps output formats to their MIME types; only formats with a registered encoder are accepted
+var knownFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+	"avif": "image/avif",
+}
+
+// RegisterEncoder installs an encoder for an output format (e.g. a cgo-backed webp or avif encoder)
+func RegisterEncoder(format string, enc Encoder) {
+	encoders[format] = enc
+}
+
+// ParseOptions reads transformation parameters from query values
+func ParseOptions(q url.Values) (Options, error) {
+	var opts Options
+	var err error
+
+	if opts.Width, err = parseDimension(q, "width"); err != nil {
+		return opts, err
+	}
+	if opts.Height, err = parseDimension(q, "height"); err != nil {
+		return opts, err
+	}
+
+	if v := q.Get("quality"); v != "" {
+		opts.Quality, err = strconv.Atoi(v)
+		if err != nil || opts.Quality < 1 || opts.Quality > 100 {
+			return opts, fmt.Errorf("%w: quality must be between 1 and 100", ErrInvalidOptions)
+		}
+	}
+
+	if v := strings.ToLower(q.Get("format")); v != "" {
+		if v == "jpg" {
+			v = "jpeg"
+		}
+		if _, ok := knownFormats[v]; !ok {
+			return opts, fmt.Errorf("%w: unknown format %q", ErrInvalidOptions, v)
+		}
+		if _, ok := encoders[v]; !ok {
+			return opts, fmt.Errorf("%w: format %q has no encoder in this build", ErrInvalidOptions, v)
+		}
+		opts.Format = v
+	}
+
+	switch v := strings.ToLower(q.Get("fit")); v {
+	case "":
+	case FitContain, FitCover, FitFill:
+		opts.Fit = v
+	default:
+		return opts, fmt.Errorf("%w: fit must be contain, cover or fill", ErrInvalidOptions)
+	}
+
+	return opts, nil
+}
+
+func parseDimension(q url.Values, key string) (int, error) {
+	v := q.Get(key)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > MaxDimension {
+		return 0, fmt.Errorf("%w: %s must be between 1 and %d", ErrInvalidOptions, key, MaxDimension)
+	}
+	return n, nil
+}
+
+// IsZero reports whether no transformation was requested
+func (o Options) IsZero() bool {
+	return o == Options{}
+}
+
+// Key returns a canonical representation of the options, used for signing and variant names
+func (o Options) Key() string {
+	return fmt.Sprintf("w%d_h%d_q%d_%s_%s", o.Width, o.Height, o.Quality, o.fit(), o.Format)
+}
+
+// ContentType returns the MIME type of the output format, or "" if the source format is kept
+func (o Options) ContentType() string {
+	return knownFormats[o.Format]
+}
+
+func (o Options) fit() string {
+	if o.Fit == "" {
+		return FitContain
+	}
+	return o.Fit
+}
+
+// Transform decodes src, applies opts and re-encodes the result
+func Transform(src []byte, opts Options) ([]byte, string, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxSourcePixels {
+		return nil, "", fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrImageTooLarge, cfg.Width, cfg.Height, MaxSourcePixels)
+	}
+
+	img, srcFormat, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+
+	format := opts.Format
+	if format == "" {
+		format = srcFormat
+	}
+	enc, ok := encoders[format]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	img = resize(img, opts.Width, opts.Height, opts.fit())
+
+	quality := opts.Quality
+	if quality == 0 {
+		quality = defaultQuality
+	}
+
+	var buf bytes.Buffer
+	if err := enc(&buf, img, quality); err != nil {
+		return nil, "", fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return buf.Bytes(), knownFormats[format], nil
+}
+
+func resize(img image.Image, width, height int, fit string) image.Image {
+	b := img.Bounds()
+	srcW, srcH := b.Dx(), b.Dy()
+	if (width == 0 && height == 0) || srcW == 0 || srcH == 0 {
+		return img
+	}
+
+	// A single dimension always scales proportionally
+	if width == 0 {
+		width = srcW * height / srcH
+		fit = FitFill
+	} else if height == 0 {
+		height = srcH * width / srcW
+		fit = FitFill
+	}
+
+	src := b
+	dstW, dstH := width, height
+
+	switch fit {
+	case FitContain:
+		scale := min(float64(width)/float64(srcW), float64(height)/float64(srcH))
+		dstW = max(1, int(float64(srcW)*scale))
+		dstH = max(1, int(float64(srcH)*scale))
+	case FitCover:
+		// Crop the source to the target aspect ratio around its center
+		if srcW*height > srcH*width {
+			cropW := srcH * width / height
+			x0 := b.Min.X + (srcW-cropW)/2
+			src = image.Rect(x0, b.Min.Y, x0+cropW, b.Max.Y)
+		} else {
+			cropH := srcW * height / width
+			y0 := b.Min.Y + (srcH-cropH)/2
+			src = image.Rect(b.Min.X, y0, b.Max.X, y0+cropH)
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, max(1, dstW), max(1, dstH)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
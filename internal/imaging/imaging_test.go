@@ -0,0 +1,192 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"testing"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		expected    Options
+		expectError bool
+	}{
+		{
+			name:     "empty",
+			query:    "",
+			expected: Options{},
+		},
+		{
+			name:     "all parameters",
+			query:    "width=200&height=100&quality=70&format=jpg&fit=cover",
+			expected: Options{Width: 200, Height: 100, Quality: 70, Format: "jpeg", Fit: FitCover},
+		},
+		{
+			name:        "width too large",
+			query:       "width=100000",
+			expectError: true,
+		},
+		{
+			name:        "invalid quality",
+			query:       "quality=0",
+			expectError: true,
+		},
+		{
+			name:        "unknown format",
+			query:       "format=tiff",
+			expectError: true,
+		},
+		{
+			name:        "format without an encoder",
+			query:       "format=webp",
+			expectError: true,
+		},
+		{
+			name:        "unknown fit",
+			query:       "fit=stretch",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, _ := url.ParseQuery(tt.query)
+			opts, err := ParseOptions(q)
+
+			if tt.expectError {
+				if !errors.Is(err, ErrInvalidOptions) {
+					t.Errorf("Expected ErrInvalidOptions, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if opts != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, opts)
+			}
+		})
+	}
+}
+
+func TestTransform(t *testing.T) {
+	src := testPNG(t, 200, 100)
+
+	tests := []struct {
+		name           string
+		opts           Options
+		expectedWidth  int
+		expectedHeight int
+		expectedType   string
+	}{
+		{
+			name:           "contain keeps aspect ratio",
+			opts:           Options{Width: 50, Height: 50},
+			expectedWidth:  50,
+			expectedHeight: 25,
+			expectedType:   "image/png",
+		},
+		{
+			name:           "cover crops to box",
+			opts:           Options{Width: 50, Height: 50, Fit: FitCover},
+			expectedWidth:  50,
+			expectedHeight: 50,
+			expectedType:   "image/png",
+		},
+		{
+			name:           "single dimension scales proportionally",
+			opts:           Options{Height: 20, Format: "jpeg"},
+			expectedWidth:  40,
+			expectedHeight: 20,
+			expectedType:   "image/jpeg",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, contentType, err := Transform(src, tt.opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if contentType != tt.expectedType {
+				t.Errorf("Expected content type '%s', got '%s'", tt.expectedType, contentType)
+			}
+
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("Failed to decode output: %v", err)
+			}
+
+			if cfg.Width != tt.expectedWidth || cfg.Height != tt.expectedHeight {
+				t.Errorf("Expected %dx%d, got %dx%d", tt.expectedWidth, tt.expectedHeight, cfg.Width, cfg.Height)
+			}
+		})
+	}
+}
+
+func TestTransform_UnsupportedFormat(t *testing.T) {
+	_, _, err := Transform(testPNG(t, 10, 10), Options{Format: "avif"})
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+
+	_, _, err = Transform([]byte("not an image"), Options{Width: 10})
+	if !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("Expected ErrUnsupportedImage, got %v", err)
+	}
+}
+
+func TestTransform_TooLarge(t *testing.T) {
+	// A blank 10000x10000 PNG compresses to a few hundred KB but decodes to 100 megapixels
+	img := image.NewGray(image.Rect(0, 0, 10000, 10000))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+
+	_, _, err := Transform(buf.Bytes(), Options{Width: 10})
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("Expected ErrImageTooLarge, got %v", err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	opts := Options{Width: 100, Format: "webp"}
+	sig := Sign("secret", "images/photo.jpg", opts)
+
+	if err := Verify("secret", "images/photo.jpg", opts, sig); err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+
+	if err := Verify("secret", "images/other.jpg", opts, sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for different path, got %v", err)
+	}
+
+	if err := Verify("secret", "images/photo.jpg", Options{Width: 200, Format: "webp"}, sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for different options, got %v", err)
+	}
+}
@@ -0,0 +1,340 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// JPEG markers relevant to metadata stripping
+const (
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerAPP0 = 0xE0
+	markerAPP2 = 0xE2
+	markerAPPE = 0xEE
+	markerCOM  = 0xFE
+)
+
+// IsScrubbable reports whether metadata stripping is supported for the content type
+func IsScrubbable(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/heic", "image/heif":
+		return true
+	}
+	return false
+}
+
+// StripMetadata removes EXIF, GPS, XMP and comment metadata from a JPEG or HEIC/HEIF image
+func StripMetadata(contentType string, r io.Reader) (io.Reader, error) {
+	switch contentType {
+	case "image/jpeg":
+		return StripJPEGMetadata(r)
+	case "image/heic", "image/heif":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := StripHEIFMetadata(data); err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedImage, contentType)
+}
+
+// StripJPEGMetadata drops APP1 (EXIF/XMP), other vendor APPn and COM segments from a JPEG.
+// JFIF (APP0), ICC profiles (APP2) and Adobe color info (APP14) are kept so the image renders
+// identically. Only the header is buffered; scan data is streamed through.
+func StripJPEGMetadata(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	var header bytes.Buffer
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != markerSOI {
+		return nil, fmt.Errorf("%w: missing JPEG start of image", ErrUnsupportedImage)
+	}
+	header.Write(soi[:])
+
+	for {
+		marker, err := readMarker(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+		}
+
+		// Standalone markers carry no length
+		if marker == markerEOI || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 {
+			header.Write([]byte{0xFF, marker})
+			if marker == markerEOI {
+				return &header, nil
+			}
+			continue
+		}
+
+		var lengthBytes [2]byte
+		if _, err := io.ReadFull(br, lengthBytes[:]); err != nil {
+			return nil, fmt.Errorf("%w: truncated segment", ErrUnsupportedImage)
+		}
+		length := int(binary.BigEndian.Uint16(lengthBytes[:]))
+		if length < 2 {
+			return nil, fmt.Errorf("%w: invalid segment length", ErrUnsupportedImage)
+		}
+
+		if isMetadataSegment(marker) {
+			if _, err := br.Discard(length - 2); err != nil {
+				return nil, fmt.Errorf("%w: truncated segment", ErrUnsupportedImage)
+			}
+			continue
+		}
+
+		header.Write([]byte{0xFF, marker})
+		header.Write(lengthBytes[:])
+		if _, err := io.CopyN(&header, br, int64(length-2)); err != nil {
+			return nil, fmt.Errorf("%w: truncated segment", ErrUnsupportedImage)
+		}
+
+		// Everything after the start of scan is entropy-coded image data
+		if marker == markerSOS {
+			return io.MultiReader(&header, br), nil
+		}
+	}
+}
+
+func readMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("expected marker, got 0x%02X", b)
+	}
+	// Skip fill bytes
+	for b == 0xFF {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+func isMetadataSegment(marker byte) bool {
+	if marker == markerCOM {
+		return true
+	}
+	if marker >= markerAPP0 && marker <= 0xEF {
+		return marker != markerAPP0 && marker != markerAPP2 && marker != markerAPPE
+	}
+	return false
+}
+
+// StripHEIFMetadata zeroes the payload of Exif and XMP items in a HEIC/HEIF file in place.
+// Payloads are blanked rather than removed so that item locations stay valid.
+func StripHEIFMetadata(data []byte) error {
+	meta, ok := findBox(data, "meta")
+	if !ok || len(meta) < 4 {
+		return fmt.Errorf("%w: missing meta box", ErrUnsupportedImage)
+	}
+	// meta is a full box: skip version and flags
+	meta = meta[4:]
+
+	iinf, ok := findBox(meta, "iinf")
+	if !ok {
+		// No item info means no metadata items to strip
+		return nil
+	}
+	metadataItems, err := parseMetadataItems(iinf)
+	if err != nil {
+		return err
+	}
+	if len(metadataItems) == 0 {
+		return nil
+	}
+
+	iloc, ok := findBox(meta, "iloc")
+	if !ok {
+		return fmt.Errorf("%w: missing iloc box", ErrUnsupportedImage)
+	}
+	extents, err := parseItemExtents(iloc, metadataItems)
+	if err != nil {
+		return err
+	}
+
+	for _, ext := range extents {
+		if ext.length == 0 || ext.offset+ext.length > uint64(len(data)) {
+			return fmt.Errorf("%w: metadata extent out of range", ErrUnsupportedImage)
+		}
+		clear(data[ext.offset : ext.offset+ext.length])
+	}
+	return nil
+}
+
+// findBox returns the payload of the first child box of the given type
+func findBox(data []byte, boxType string) ([]byte, bool) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		typ := string(data[4:8])
+		headerLen := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, false
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerLen = 16
+		}
+		if size < headerLen || size > uint64(len(data)) {
+			return nil, false
+		}
+
+		if typ == boxType {
+			return data[headerLen:size], true
+		}
+		data = data[size:]
+	}
+	return nil, false
+}
+
+// parseMetadataItems returns the IDs of Exif and XMP items listed in an iinf box
+func parseMetadataItems(iinf []byte) (map[uint32]bool, error) {
+	r := &boxReader{data: iinf}
+	version := r.u8()
+	r.skip(3)
+	if version == 0 {
+		r.u16()
+	} else {
+		r.u32()
+	}
+
+	items := make(map[uint32]bool)
+	entries := r.data[r.pos:]
+	for len(entries) >= 8 && r.err == nil {
+		size := int(binary.BigEndian.Uint32(entries[0:4]))
+		if size < 8 || size > len(entries) {
+			return nil, fmt.Errorf("%w: malformed item info", ErrUnsupportedImage)
+		}
+		if string(entries[4:8]) == "infe" {
+			e := &boxReader{data: entries[8:size]}
+			v := e.u8()
+			e.skip(3)
+			if v >= 2 {
+				var id uint32
+				if v == 2 {
+					id = uint32(e.u16())
+				} else {
+					id = e.u32()
+				}
+				e.u16() // protection index
+				itemType := string(e.bytes(4))
+				if e.err == nil && (itemType == "Exif" || itemType == "mime") {
+					items[id] = true
+				}
+			}
+		}
+		entries = entries[size:]
+	}
+
+	return items, r.err
+}
+
+type extent struct {
+	offset uint64
+	length uint64
+}
+
+// parseItemExtents returns the file extents of the requested items from an iloc box
+func parseItemExtents(iloc []byte, items map[uint32]bool) ([]extent, error) {
+	r := &boxReader{data: iloc}
+	version := r.u8()
+	r.skip(3)
+
+	sizes := r.u8()
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0x0F)
+	sizes = r.u8()
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0x0F)
+	}
+
+	var itemCount uint32
+	if version < 2 {
+		itemCount = uint32(r.u16())
+	} else {
+		itemCount = r.u32()
+	}
+
+	var extents []extent
+	for i := uint32(0); i < itemCount && r.err == nil; i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(r.u16())
+		} else {
+			id = r.u32()
+		}
+
+		constructionMethod := 0
+		if version == 1 || version == 2 {
+			constructionMethod = int(r.u16() & 0x0F)
+		}
+		r.u16() // data reference index
+		baseOffset := r.uint(baseOffsetSize)
+
+		extentCount := int(r.u16())
+		for j := 0; j < extentCount && r.err == nil; j++ {
+			r.uint(indexSize)
+			offset := r.uint(offsetSize)
+			length := r.uint(lengthSize)
+			if items[id] && constructionMethod == 0 {
+				extents = append(extents, extent{offset: baseOffset + offset, length: length})
+			}
+		}
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: malformed item locations", ErrUnsupportedImage)
+	}
+	return extents, nil
+}
+
+// boxReader reads big-endian fields and records the first out-of-bounds error
+type boxReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *boxReader) bytes(n int) []byte {
+	if r.err != nil || r.pos+n > len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *boxReader) skip(n int) { r.bytes(n) }
+
+func (r *boxReader) u8() uint8 { return r.bytes(1)[0] }
+
+func (r *boxReader) u16() uint16 { return binary.BigEndian.Uint16(r.bytes(2)) }
+
+func (r *boxReader) u32() uint32 { return binary.BigEndian.Uint32(r.bytes(4)) }
+
+func (r *boxReader) uint(size int) uint64 {
+	switch size {
+	case 0:
+		return 0
+	case 4:
+		return uint64(r.u32())
+	case 8:
+		return binary.BigEndian.Uint64(r.bytes(8))
+	}
+	r.err = fmt.Errorf("unsupported field size %d", size)
+	return 0
+}
@@ -0,0 +1,114 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+func testJPEGWithEXIF(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	exif := []byte("Exif\x00\x00GPS-SECRET")
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	segment = append(segment, exif...)
+
+	comment := []byte{0xFF, 0xFE, 0, 9}
+	comment = append(comment, []byte("comment")...)
+
+	// Insert APP1 and COM right after SOI
+	out := append([]byte{}, encoded[:2]...)
+	out = append(out, segment...)
+	out = append(out, comment...)
+	return append(out, encoded[2:]...)
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	src := testJPEGWithEXIF(t)
+
+	r, err := StripJPEGMetadata(bytes.NewReader(src))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read stripped image: %v", err)
+	}
+
+	if bytes.Contains(out, []byte("GPS-SECRET")) {
+		t.Error("Expected EXIF payload to be removed")
+	}
+	if bytes.Contains(out, []byte("comment")) {
+		t.Error("Expected comment segment to be removed")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("Stripped image no longer decodes: %v", err)
+	}
+}
+
+func TestStripJPEGMetadata_NotJPEG(t *testing.T) {
+	if _, err := StripJPEGMetadata(bytes.NewReader([]byte("plain text"))); err == nil {
+		t.Error("Expected error for non-JPEG input")
+	}
+}
+
+func box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(out, uint32(8+len(body)))
+	copy(out[4:], typ)
+	return append(out, body...)
+}
+
+func TestStripHEIFMetadata(t *testing.T) {
+	exifPayload := []byte("EXIF-GPS-DATA")
+	imagePayload := []byte("IMAGE-DATA")
+
+	infe := func(id uint16, itemType string) []byte {
+		return box("infe", []byte{2, 0, 0, 0}, binary.BigEndian.AppendUint16(nil, id), []byte{0, 0}, []byte(itemType))
+	}
+	iinf := box("iinf", []byte{0, 0, 0, 0}, []byte{0, 2}, infe(1, "hvc1"), infe(2, "Exif"))
+
+	build := func(exifOffset, imageOffset uint32) []byte {
+		iloc := []byte{0, 0, 0, 0, 0x44, 0x00, 0, 2}
+		for _, item := range []struct {
+			id     uint16
+			offset uint32
+			length int
+		}{{1, imageOffset, len(imagePayload)}, {2, exifOffset, len(exifPayload)}} {
+			iloc = binary.BigEndian.AppendUint16(iloc, item.id)
+			iloc = append(iloc, 0, 0, 0, 1)
+			iloc = binary.BigEndian.AppendUint32(iloc, item.offset)
+			iloc = binary.BigEndian.AppendUint32(iloc, uint32(item.length))
+		}
+		meta := box("meta", []byte{0, 0, 0, 0}, iinf, box("iloc", iloc))
+		ftyp := box("ftyp", []byte("heic\x00\x00\x00\x00"))
+		mdat := box("mdat", imagePayload, exifPayload)
+		return bytes.Join([][]byte{ftyp, meta, mdat}, nil)
+	}
+
+	// Build once to learn the layout, then again with real offsets
+	layout := build(0, 0)
+	mdatData := len(layout) - len(imagePayload) - len(exifPayload)
+	data := build(uint32(mdatData+len(imagePayload)), uint32(mdatData))
+
+	if err := StripHEIFMetadata(data); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if bytes.Contains(data, exifPayload) {
+		t.Error("Expected Exif item payload to be blanked")
+	}
+	if !bytes.Contains(data, imagePayload) {
+		t.Error("Expected image payload to be preserved")
+	}
+}
@@ -0,0 +1,25 @@
+package imaging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Sign returns the hex encoded HMAC-SHA256 of the file path and canonical options
+func Sign(key, filePath string, opts Options) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(filePath))
+	mac.Write([]byte{0})
+	mac.Write([]byte(opts.Key()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature produced by Sign
+func Verify(key, filePath string, opts Options, signature string) error {
+	expected := Sign(key, filePath, opts)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package ipfilter
+
+import (
+	"net/http"
+	"net/netip"
+	"sync"
+
+	"gcp-proxy-mity/internal/clientip"
+)
+
+// Config restricts which clients may use the proxy. A zero config allows everyone.
+type Config struct {
+	// Allow, when not empty, lets only clients in these ranges through
+	Allow []netip.Prefix
+	// Deny refuses clients in these ranges, even if they are allowed
+	Deny []netip.Prefix
+	// PrivateWrites lets only clients in Internal make changes, while reads
+	// stay open to everyone allowed
+	PrivateWrites bool
+	Internal      []netip.Prefix
+}
+
+// Filter refuses requests by the client's IP address. Behind load balancers
+// the middleware must run after clientip.Resolver's, so the clients are
+// filtered rather than the load balancers.
+type Filter struct {
+	writes func(*http.Request) bool
+
+	mu  sync.RWMutex
+	cfg Config
+}
+
+// New creates a filter. writes reports whether a request makes changes; nil
+// treats every method but GET, HEAD and OPTIONS as one.
+func New(writes func(*http.Request) bool, cfg Config) *Filter {
+	if writes == nil {
+		writes = func(r *http.Request) bool {
+			return r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+		}
+	}
+	f := &Filter{
+		writes: writes,
+	}
+	f.SetConfig(cfg)
+	return f
+}
+
+// SetConfig changes the ranges at runtime
+func (f *Filter) SetConfig(cfg Config) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cfg = cfg
+}
+
+// Middleware answers refused clients with 403 Forbidden
+func (f *Filter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason := f.Check(r); reason != "" {
+			http.Error(w, "Forbidden: "+reason, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Check returns why the request is refused, or "" if it may be served
+func (f *Filter) Check(r *http.Request) string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if len(f.cfg.Allow) == 0 && len(f.cfg.Deny) == 0 && !f.cfg.PrivateWrites {
+		return ""
+	}
+
+	addr := clientip.FromRequest(r).Addr
+	if clientip.Contains(f.cfg.Deny, addr) {
+		return "client address is denied"
+	}
+	if len(f.cfg.Allow) > 0 && !clientip.Contains(f.cfg.Allow, addr) {
+		return "client address is not allowed"
+	}
+	if f.cfg.PrivateWrites && !clientip.Contains(f.cfg.Internal, addr) && f.writes(r) {
+		return "changes are only accepted from internal networks"
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package ipfilter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"gcp-proxy-mity/internal/clientip"
+)
+
+func prefixes(values ...string) []netip.Prefix {
+	parsed, err := clientip.ParsePrefixes(values)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func TestFilter_Middleware(t *testing.T) {
+	resolver := clientip.New(prefixes("35.191.0.0/16"))
+	// Batch reads are POSTs but don't change anything
+	writes := func(r *http.Request) bool {
+		return r.Method != http.MethodGet && r.URL.Path != "/files/read"
+	}
+
+	tests := []struct {
+		name      string
+		cfg       Config
+		method    string
+		path      string
+		remote    string
+		forwarded string
+		expected  int
+	}{
+		{name: "no config", method: http.MethodPut, path: "/files/a.txt", remote: "203.0.113.7:1234", expected: http.StatusOK},
+		{name: "allowed", cfg: Config{Allow: prefixes("203.0.113.0/24")}, method: http.MethodGet, path: "/files/a.txt", remote: "203.0.113.7:1234", expected: http.StatusOK},
+		{name: "not allowed", cfg: Config{Allow: prefixes("203.0.113.0/24")}, method: http.MethodGet, path: "/files/a.txt", remote: "198.51.100.9:1234", expected: http.StatusForbidden},
+		{name: "denied within allowed", cfg: Config{Allow: prefixes("203.0.113.0/24"), Deny: prefixes("203.0.113.7")}, method: http.MethodGet, path: "/files/a.txt", remote: "203.0.113.7:1234", expected: http.StatusForbidden},
+		{name: "denied behind load balancer", cfg: Config{Deny: prefixes("198.51.100.0/24")}, method: http.MethodGet, path: "/files/a.txt", remote: "35.191.4.2:443", forwarded: "198.51.100.9", expected: http.StatusForbidden},
+		{name: "forwarded by untrusted peer", cfg: Config{Allow: prefixes("10.0.0.0/8")}, method: http.MethodGet, path: "/files/a.txt", remote: "203.0.113.7:1234", forwarded: "10.0.0.1", expected: http.StatusForbidden},
+		{name: "public read", cfg: Config{PrivateWrites: true, Internal: prefixes("10.0.0.0/8")}, method: http.MethodGet, path: "/files/a.txt", remote: "203.0.113.7:1234", expected: http.StatusOK},
+		{name: "public batch read", cfg: Config{PrivateWrites: true, Internal: prefixes("10.0.0.0/8")}, method: http.MethodPost, path: "/files/read", remote: "203.0.113.7:1234", expected: http.StatusOK},
+		{name: "public write", cfg: Config{PrivateWrites: true, Internal: prefixes("10.0.0.0/8")}, method: http.MethodPut, path: "/files/a.txt", remote: "203.0.113.7:1234", expected: http.StatusForbidden},
+		{name: "internal write", cfg: Config{PrivateWrites: true, Internal: prefixes("10.0.0.0/8")}, method: http.MethodPut, path: "/files/a.txt", remote: "10.1.2.3:1234", expected: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := New(writes, tt.cfg)
+			h := resolver.Middleware(filter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestNew_DefaultWrites(t *testing.T) {
+	filter := New(nil, Config{PrivateWrites: true, Internal: prefixes("10.0.0.0/8")})
+	for method, expected := range map[string]string{
+		http.MethodGet:     "",
+		http.MethodHead:    "",
+		http.MethodOptions: "",
+		http.MethodDelete:  "changes are only accepted from internal networks",
+	} {
+		r := httptest.NewRequest(method, "/files/a.txt", nil)
+		if got := filter.Check(r); got != expected {
+			t.Errorf("Expected %s to give %q, got %q", method, expected, got)
+		}
+	}
+}
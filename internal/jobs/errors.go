@@ -0,0 +1,9 @@
+package jobs
+
+import "errors"
+
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrUnknownJobType = errors.New("unknown job type")
+	ErrQueueFull      = errors.New("job queue is full")
+)
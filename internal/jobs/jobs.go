@@ -0,0 +1,284 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+)
+
+// Job statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusPartial   = "partial"
+	StatusFailed    = "failed"
+)
+
+// maxFailures caps how many per-item failures are kept on a job
+const maxFailures = 1000
+
+// Spec describes the work a job performs; fields are interpreted by the job type's runner
+type Spec struct {
+	Type        string   `json:"type"`
+	Prefix      string   `json:"prefix,omitempty"`
+	Destination string   `json:"destination,omitempty"`
+	Paths       []string `json:"paths,omitempty"`
+	Manifest    string   `json:"manifest,omitempty"`
+	// SourceBucket and DestinationBucket name the buckets of a transfer between buckets
+	SourceBucket      string `json:"source_bucket,omitempty"`
+	DestinationBucket string `json:"destination_bucket,omitempty"`
+}
+
+// ItemFailure records a single item that a job failed to process
+type ItemFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// Job is a snapshot of a background job
+type Job struct {
+	ID         string        `json:"id"`
+	Spec       Spec          `json:"spec"`
+	Status     string        `json:"status"`
+	Total      int           `json:"total"`
+	Processed  int           `json:"processed"`
+	Succeeded  int           `json:"succeeded"`
+	Failed     int           `json:"failed"`
+	Failures   []ItemFailure `json:"failures"`
+	Result     string        `json:"result,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	// Principal submitted the job, which runs with its scopes
+	Principal string `json:"principal,omitempty"`
+	scopes    []string
+	// system jobs are the proxy's own, like scheduled ones
+	system bool
+}
+
+// Runner executes a job, reporting progress through the tracker
+type Runner func(ctx context.Context, spec Spec, t *Tracker) error
+
+// Manager queues jobs and runs them on a worker pool. Jobs are kept in memory
+// and expire after the retention period once finished.
+type Manager struct {
+	mu        sync.RWMutex
+	jobs      map[string]*Job
+	runners   map[string]Runner
+	queue     chan string
+	retention time.Duration
+	wg        sync.WaitGroup
+	cancel    context.CancelFunc
+	ctx       context.Context
+}
+
+// NewManager starts a manager with the given number of workers
+func NewManager(workers, queueSize int, retention time.Duration) *Manager {
+	if workers <= 0 {
+		workers = 4
+	}
+	if queueSize <= 0 {
+		queueSize = 100
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		jobs:      make(map[string]*Job),
+		runners:   make(map[string]Runner),
+		queue:     make(chan string, queueSize),
+		retention: retention,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	for i := 0; i < workers; i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+
+	return m
+}
+
+// Register installs the runner for a job type
+func (m *Manager) Register(jobType string, runner Runner) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runners[jobType] = runner
+}
+
+// Submit queues a new job, which runs as the principal in ctx with its scopes
+func (m *Manager) Submit(ctx context.Context, spec Spec) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.runners[spec.Type]; !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownJobType, spec.Type)
+	}
+
+	m.evictExpired()
+
+	job := &Job{
+		ID:        newJobID(),
+		Spec:      spec,
+		Status:    StatusPending,
+		Failures:  make([]ItemFailure, 0),
+		CreatedAt: time.Now().UTC(),
+		Principal: auth.PrincipalFromContext(ctx),
+		scopes:    auth.ScopesFromContext(ctx),
+		system:    auth.IsSystem(ctx),
+	}
+
+	select {
+	case m.queue <- job.ID:
+	default:
+		return nil, ErrQueueFull
+	}
+
+	m.jobs[job.ID] = job
+	return job.snapshot(), nil
+}
+
+// Get returns a snapshot of a job
+func (m *Manager) Get(id string) (*Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job.snapshot(), nil
+}
+
+// Close cancels running jobs and waits for workers to exit
+func (m *Manager) Close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+func (m *Manager) worker() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case id := <-m.queue:
+			m.run(id)
+		}
+	}
+}
+
+func (m *Manager) run(id string) {
+	m.mu.Lock()
+	job := m.jobs[id]
+	runner := m.runners[job.Spec.Type]
+	now := time.Now().UTC()
+	job.Status = StatusRunning
+	job.StartedAt = &now
+	spec := job.Spec
+	ctx := auth.WithScopes(auth.WithPrincipal(m.ctx, job.Principal), job.scopes)
+	if job.system {
+		ctx = auth.WithSystem(ctx)
+	}
+	m.mu.Unlock()
+
+	tracker := &Tracker{manager: m, job: job}
+	err := runner(ctx, spec, tracker)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	finished := time.Now().UTC()
+	job.FinishedAt = &finished
+	switch {
+	case err != nil:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	case job.Failed > 0 && job.Succeeded > 0:
+		job.Status = StatusPartial
+	case job.Failed > 0:
+		job.Status = StatusFailed
+	default:
+		job.Status = StatusSucceeded
+	}
+
+	log.Printf("Job %s (%s) finished with status %s: %d succeeded, %d failed", job.ID, spec.Type, job.Status, job.Succeeded, job.Failed)
+}
+
+// evictExpired drops finished jobs older than the retention period; callers hold the lock
+func (m *Manager) evictExpired() {
+	if m.retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-m.retention)
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func (j *Job) snapshot() *Job {
+	c := *j
+	c.Failures = append([]ItemFailure(nil), j.Failures...)
+	c.Spec.Paths = append([]string(nil), j.Spec.Paths...)
+	return &c
+}
+
+// Tracker records the progress of a running job
+type Tracker struct {
+	manager *Manager
+	job     *Job
+}
+
+// JobID returns the ID of the job being tracked
+func (t *Tracker) JobID() string {
+	return t.job.ID
+}
+
+// SetTotal sets the number of items the job will process
+func (t *Tracker) SetTotal(total int) {
+	t.manager.mu.Lock()
+	defer t.manager.mu.Unlock()
+	t.job.Total = total
+}
+
+// Succeed records a successfully processed item
+func (t *Tracker) Succeed() {
+	t.manager.mu.Lock()
+	defer t.manager.mu.Unlock()
+	t.job.Processed++
+	t.job.Succeeded++
+}
+
+// Fail records an item that could not be processed
+func (t *Tracker) Fail(path string, err error) {
+	t.manager.mu.Lock()
+	defer t.manager.mu.Unlock()
+	t.job.Processed++
+	t.job.Failed++
+	if len(t.job.Failures) < maxFailures {
+		t.job.Failures = append(t.job.Failures, ItemFailure{Path: path, Error: err.Error()})
+	}
+}
+
+// SetResult records where the job's output was stored
+func (t *Tracker) SetResult(result string) {
+	t.manager.mu.Lock()
+	defer t.manager.mu.Unlock()
+	t.job.Result = result
+}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,150 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+)
+
+func waitForJob(t *testing.T, m *Manager, id string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := m.Get(id)
+		if err != nil {
+			t.Fatalf("Failed to get job: %v", err)
+		}
+		if job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Job did not finish in time")
+	return nil
+}
+
+func TestManager_RunsJobs(t *testing.T) {
+	tests := []struct {
+		name           string
+		runner         Runner
+		expectedStatus string
+		expectedFailed int
+	}{
+		{
+			name: "all items succeed",
+			runner: func(ctx context.Context, spec Spec, tr *Tracker) error {
+				tr.SetTotal(len(spec.Paths))
+				for range spec.Paths {
+					tr.Succeed()
+				}
+				return nil
+			},
+			expectedStatus: StatusSucceeded,
+		},
+		{
+			name: "some items fail",
+			runner: func(ctx context.Context, spec Spec, tr *Tracker) error {
+				tr.SetTotal(len(spec.Paths))
+				tr.Succeed()
+				tr.Fail(spec.Paths[1], errors.New("boom"))
+				return nil
+			},
+			expectedStatus: StatusPartial,
+			expectedFailed: 1,
+		},
+		{
+			name: "runner error",
+			runner: func(ctx context.Context, spec Spec, tr *Tracker) error {
+				return errors.New("listing failed")
+			},
+			expectedStatus: StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(1, 10, time.Hour)
+			defer m.Close()
+			m.Register("test", tt.runner)
+
+			job, err := m.Submit(context.Background(), Spec{Type: "test", Paths: []string{"a", "b"}})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if job.Status != StatusPending {
+				t.Errorf("Expected pending status, got %s", job.Status)
+			}
+
+			job = waitForJob(t, m, job.ID)
+			if job.Status != tt.expectedStatus {
+				t.Errorf("Expected status %s, got %s", tt.expectedStatus, job.Status)
+			}
+			if job.Failed != tt.expectedFailed || len(job.Failures) != tt.expectedFailed {
+				t.Errorf("Expected %d failures, got %d (%+v)", tt.expectedFailed, job.Failed, job.Failures)
+			}
+		})
+	}
+}
+
+func TestManager_Errors(t *testing.T) {
+	m := NewManager(1, 10, time.Hour)
+	defer m.Close()
+
+	if _, err := m.Submit(context.Background(), Spec{Type: "missing"}); !errors.Is(err, ErrUnknownJobType) {
+		t.Errorf("Expected ErrUnknownJobType, got %v", err)
+	}
+
+	if _, err := m.Get("nope"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound, got %v", err)
+	}
+}
+
+func TestManager_RunsAsSubmitter(t *testing.T) {
+	m := NewManager(1, 10, time.Hour)
+	defer m.Close()
+
+	type caller struct {
+		principal string
+		scopes    []string
+		system    bool
+	}
+	ran := make(chan caller, 1)
+	m.Register("test", func(ctx context.Context, spec Spec, t *Tracker) error {
+		ran <- caller{auth.PrincipalFromContext(ctx), auth.ScopesFromContext(ctx), auth.IsSystem(ctx)}
+		return nil
+	})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want caller
+	}{
+		{
+			name: "request",
+			ctx:  auth.WithScopes(auth.WithPrincipal(context.Background(), "ci"), []string{"finance"}),
+			want: caller{principal: "ci", scopes: []string{"finance"}},
+		},
+		{name: "anonymous request", ctx: context.Background()},
+		{name: "proxy itself", ctx: auth.WithSystem(context.Background()), want: caller{system: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := m.Submit(tt.ctx, Spec{Type: "test"})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got := <-ran
+			if got.principal != tt.want.principal || !slices.Equal(got.scopes, tt.want.scopes) || got.system != tt.want.system {
+				t.Errorf("Expected the job to run as %+v, got %+v", tt.want, got)
+			}
+			if job.Principal != tt.want.principal {
+				t.Errorf("Expected the job to record principal %q, got %q", tt.want.principal, job.Principal)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+package lease
+
+import "errors"
+
+var (
+	ErrLocked     = errors.New("path is leased by another client")
+	ErrNotHeld    = errors.New("lease is not held")
+	ErrNotLeased  = errors.New("path is not leased")
+	ErrInvalidTTL = errors.New("invalid lease duration")
+)
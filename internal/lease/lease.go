@@ -0,0 +1,207 @@
+package lease
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Lease is an advisory lock on a path. Whoever holds its ID may write the
+// path until the lease expires or is released.
+type Lease struct {
+	Path    string    `json:"path"`
+	ID      string    `json:"id"`
+	Holder  string    `json:"holder,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// Store keeps the leases of all paths
+type Store interface {
+	// Acquire stores lease unless another unexpired lease holds its path, which fails with ErrLocked
+	Acquire(ctx context.Context, lease Lease) error
+	// Renew moves the expiry of the lease with id to expires. It fails with
+	// ErrNotHeld if that lease expired or was released.
+	Renew(ctx context.Context, path, id string, expires time.Time) (*Lease, error)
+	// Release removes the lease with id, failing with ErrNotHeld if it isn't the current one
+	Release(ctx context.Context, path, id string) error
+	// Get returns the unexpired lease on path, nil if there is none
+	Get(ctx context.Context, path string) (*Lease, error)
+}
+
+// Manager hands out leases with bounded durations
+type Manager struct {
+	store      Store
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+	now        func() time.Time
+}
+
+// New creates a manager keeping leases in store. Leases requested without a
+// duration last defaultTTL, and none lasts longer than maxTTL.
+func New(store Store, defaultTTL, maxTTL time.Duration) *Manager {
+	return &Manager{
+		store:      store,
+		defaultTTL: defaultTTL,
+		maxTTL:     maxTTL,
+		now:        time.Now,
+	}
+}
+
+// Acquire leases path to holder for ttl, or the default duration if ttl is zero
+func (m *Manager) Acquire(ctx context.Context, path, holder string, ttl time.Duration) (*Lease, error) {
+	expires, err := m.expires(ttl)
+	if err != nil {
+		return nil, err
+	}
+	lease := Lease{Path: path, ID: newLeaseID(), Holder: holder, Expires: expires}
+	if err := m.store.Acquire(ctx, lease); err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+// Renew extends the lease with id by ttl from now
+func (m *Manager) Renew(ctx context.Context, path, id string, ttl time.Duration) (*Lease, error) {
+	expires, err := m.expires(ttl)
+	if err != nil {
+		return nil, err
+	}
+	return m.store.Renew(ctx, path, id, expires)
+}
+
+// Release ends the lease with id before it expires
+func (m *Manager) Release(ctx context.Context, path, id string) error {
+	return m.store.Release(ctx, path, id)
+}
+
+// Get returns the current lease on path, or ErrNotLeased
+func (m *Manager) Get(ctx context.Context, path string) (*Lease, error) {
+	lease, err := m.store.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if lease == nil {
+		return nil, ErrNotLeased
+	}
+	return lease, nil
+}
+
+// Check returns ErrLocked if path is leased under an ID other than ids.
+// Paths without a lease may be written by anyone.
+func (m *Manager) Check(ctx context.Context, path string, ids []string) error {
+	lease, err := m.store.Get(ctx, path)
+	if err != nil {
+		return fmt.Errorf("failed to check the lease on %s: %w", path, err)
+	}
+	if lease == nil || slices.Contains(ids, lease.ID) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is leased until %s", ErrLocked, path, lease.Expires.Format(time.RFC3339))
+}
+
+func (m *Manager) expires(ttl time.Duration) (time.Time, error) {
+	if ttl == 0 {
+		ttl = m.defaultTTL
+	}
+	if ttl < time.Second || ttl > m.maxTTL {
+		return time.Time{}, fmt.Errorf("%w: must be between 1s and %s", ErrInvalidTTL, m.maxTTL)
+	}
+	return m.now().Add(ttl).UTC().Truncate(time.Millisecond), nil
+}
+
+func newLeaseID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type idsKey struct{}
+
+// WithIDs attaches the IDs of the leases a request holds to its context
+func WithIDs(ctx context.Context, ids []string) context.Context {
+	return context.WithValue(ctx, idsKey{}, ids)
+}
+
+// IDsFromContext returns the lease IDs attached by WithIDs
+func IDsFromContext(ctx context.Context) []string {
+	ids, _ := ctx.Value(idsKey{}).([]string)
+	return ids
+}
+
+// MemoryStore keeps leases in memory, so they only guard writes through one
+// replica and are lost on restart
+type MemoryStore struct {
+	mu     sync.Mutex
+	leases map[string]Lease
+	now    func() time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		leases: make(map[string]Lease),
+		now:    time.Now,
+	}
+}
+
+func (s *MemoryStore) Acquire(ctx context.Context, lease Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	// Expired leases are dropped here, since nothing else would
+	for path, l := range s.leases {
+		if !now.Before(l.Expires) {
+			delete(s.leases, path)
+		}
+	}
+	if _, ok := s.leases[lease.Path]; ok {
+		return ErrLocked
+	}
+	s.leases[lease.Path] = lease
+	return nil
+}
+
+func (s *MemoryStore) Renew(ctx context.Context, path, id string, expires time.Time) (*Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lease, ok := s.current(path)
+	if !ok || lease.ID != id {
+		return nil, ErrNotHeld
+	}
+	lease.Expires = expires
+	s.leases[path] = lease
+	return &lease, nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, path, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lease, ok := s.current(path)
+	if !ok || lease.ID != id {
+		return ErrNotHeld
+	}
+	delete(s.leases, path)
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, path string) (*Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lease, ok := s.current(path); ok {
+		return &lease, nil
+	}
+	return nil, nil
+}
+
+// current returns the unexpired lease on path; callers hold the lock
+func (s *MemoryStore) current(path string) (Lease, bool) {
+	lease, ok := s.leases[path]
+	if !ok || !s.now().Before(lease.Expires) {
+		return Lease{}, false
+	}
+	return lease, true
+}
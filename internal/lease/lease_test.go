@@ -0,0 +1,116 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestManager() (*Manager, *time.Time) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	store := NewMemoryStore()
+	store.now = clock
+	manager := New(store, time.Minute, time.Hour)
+	manager.now = clock
+	return manager, &now
+}
+
+func TestManager_Acquire(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		want    time.Duration
+		wantErr error
+	}{
+		{name: "default", want: time.Minute},
+		{name: "explicit", ttl: 10 * time.Minute, want: 10 * time.Minute},
+		{name: "too short", ttl: time.Millisecond, wantErr: ErrInvalidTTL},
+		{name: "too long", ttl: 2 * time.Hour, wantErr: ErrInvalidTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, now := newTestManager()
+			lease, err := manager.Acquire(context.Background(), "a.txt", "ci", tt.ttl)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if lease.ID == "" || lease.Holder != "ci" || !lease.Expires.Equal(now.Add(tt.want)) {
+				t.Errorf("Expected a lease of ci until %s, got %+v", now.Add(tt.want), lease)
+			}
+		})
+	}
+}
+
+func TestManager_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	manager, now := newTestManager()
+
+	lease, err := manager.Acquire(ctx, "a.txt", "ci", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := manager.Acquire(ctx, "a.txt", "other", 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked acquiring a held lease, got %v", err)
+	}
+	if err := manager.Check(ctx, "a.txt", nil); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked writing without the lease, got %v", err)
+	}
+	if err := manager.Check(ctx, "a.txt", []string{"other", lease.ID}); err != nil {
+		t.Errorf("Expected the holder to write, got %v", err)
+	}
+	if err := manager.Check(ctx, "b.txt", nil); err != nil {
+		t.Errorf("Expected anyone to write an unleased path, got %v", err)
+	}
+
+	*now = now.Add(50 * time.Second)
+	renewed, err := manager.Renew(ctx, "a.txt", lease.ID, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !renewed.Expires.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected the lease to be extended to %s, got %s", now.Add(time.Minute), renewed.Expires)
+	}
+	if _, err := manager.Renew(ctx, "a.txt", "other", 0); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Expected ErrNotHeld renewing with another ID, got %v", err)
+	}
+
+	if err := manager.Release(ctx, "a.txt", lease.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := manager.Get(ctx, "a.txt"); !errors.Is(err, ErrNotLeased) {
+		t.Errorf("Expected ErrNotLeased after release, got %v", err)
+	}
+	if err := manager.Release(ctx, "a.txt", lease.ID); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Expected ErrNotHeld releasing twice, got %v", err)
+	}
+}
+
+func TestManager_Expiry(t *testing.T) {
+	ctx := context.Background()
+	manager, now := newTestManager()
+
+	lease, err := manager.Acquire(ctx, "a.txt", "ci", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	*now = now.Add(time.Minute)
+
+	if err := manager.Check(ctx, "a.txt", nil); err != nil {
+		t.Errorf("Expected an expired lease not to lock the path, got %v", err)
+	}
+	if _, err := manager.Renew(ctx, "a.txt", lease.ID, 0); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Expected ErrNotHeld renewing an expired lease, got %v", err)
+	}
+	if _, err := manager.Acquire(ctx, "a.txt", "other", 0); err != nil {
+		t.Errorf("Expected an expired lease to be taken over, got %v", err)
+	}
+}
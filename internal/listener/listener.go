@@ -0,0 +1,99 @@
+package listener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Names of the sockets systemd may pass, set with FileDescriptorName= in the socket unit
+const (
+	HTTP  = "http"
+	S3    = "s3"
+	SFTP  = "sftp"
+	Debug = "debug"
+)
+
+// systemd passes sockets from file descriptor 3 on
+const firstFD = 3
+
+// Config chooses how servers get their sockets
+type Config struct {
+	// ReusePort binds with SO_REUSEPORT, so a new process can listen on the
+	// port while the old one drains
+	ReusePort bool
+}
+
+// Listen returns the socket systemd passed for name, or listens on addr.
+// A single socket without the name of another server is the API's.
+func (c Config) Listen(name, addr string) (net.Listener, error) {
+	l, ok, err := inherited(name)
+	if err != nil || ok {
+		return l, err
+	}
+	lc := net.ListenConfig{}
+	if c.ReusePort {
+		lc.Control = reusePort
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+var (
+	mu       sync.Mutex
+	parsed   bool
+	sockets  map[string]net.Listener
+	parseErr error
+)
+
+// inherited hands out each socket passed by systemd once
+func inherited(name string) (net.Listener, bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if !parsed {
+		sockets, parseErr = activated()
+		parsed = true
+	}
+	if parseErr != nil {
+		return nil, false, parseErr
+	}
+	l, ok := sockets[name]
+	delete(sockets, name)
+	return l, ok, nil
+}
+
+// activated returns the sockets of systemd socket activation by name. The
+// variables are unset so child processes don't take the sockets as theirs.
+func activated() (map[string]net.Listener, error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	count, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid != os.Getpid() || count <= 0 {
+		return nil, nil
+	}
+
+	listeners := make(map[string]net.Listener, count)
+	for i := range count {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		if count == 1 && name != S3 && name != SFTP && name != Debug {
+			name = HTTP
+		}
+		f := os.NewFile(uintptr(firstFD+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %d passed by systemd (%s) is not a listening socket: %w", firstFD+i, name, err)
+		}
+		listeners[name] = l
+	}
+	return listeners, nil
+}
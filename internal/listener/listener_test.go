@@ -0,0 +1,115 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestConfig_Listen_ReusePort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SO_REUSEPORT is not supported on Windows")
+	}
+	first, err := Config{ReusePort: true}.Listen(HTTP, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer first.Close()
+
+	second, err := Config{ReusePort: true}.Listen(HTTP, first.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected a second listener on %s, got %v", first.Addr(), err)
+	}
+	second.Close()
+
+	if l, err := (Config{}).Listen(HTTP, first.Addr().String()); err == nil {
+		l.Close()
+		t.Error("Expected the port to be in use without SO_REUSEPORT")
+	}
+}
+
+// TestHelperListener runs in a child process that was passed sockets the way systemd passes them
+func TestHelperListener(t *testing.T) {
+	if os.Getenv("LISTENER_TEST_CHILD") == "" {
+		t.Skip("Only runs as a child process")
+	}
+	// systemd sets LISTEN_PID to the pid it execs
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	for _, name := range []string{HTTP, S3, SFTP, Debug} {
+		l, err := Config{}.Listen(name, "127.0.0.1:0")
+		if err != nil {
+			fmt.Printf("%s=error ", name)
+			continue
+		}
+		fmt.Printf("%s=%s ", name, l.Addr())
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		fmt.Print("env=kept")
+	}
+}
+
+func TestListen_SocketActivation(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   string
+		sockets int
+		// expected maps server names to the index of their socket, or -1 for a new one
+		expected map[string]int
+	}{
+		{name: "named", names: "s3:http", sockets: 2, expected: map[string]int{HTTP: 1, S3: 0, SFTP: -1}},
+		{name: "single unnamed", names: "gcp-proxy-mity.socket", sockets: 1, expected: map[string]int{HTTP: 0, S3: -1, SFTP: -1}},
+		{name: "single named", names: "sftp", sockets: 1, expected: map[string]int{HTTP: -1, S3: -1, SFTP: 0}},
+		{name: "single debug", names: "debug", sockets: 1, expected: map[string]int{HTTP: -1, Debug: 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var files []*os.File
+			var addrs []string
+			for range tt.sockets {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				defer l.Close()
+				f, err := l.(*net.TCPListener).File()
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				defer f.Close()
+				files = append(files, f)
+				addrs = append(addrs, l.Addr().String())
+			}
+
+			cmd := exec.Command(os.Args[0], "-test.run=^TestHelperListener$")
+			cmd.Env = append(os.Environ(), "LISTENER_TEST_CHILD=1", "LISTEN_FDS="+strconv.Itoa(tt.sockets), "LISTEN_FDNAMES="+tt.names)
+			cmd.ExtraFiles = files
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("Child failed: %v\n%s", err, out)
+			}
+			output := string(out)
+
+			if strings.Contains(output, "env=kept") {
+				t.Error("Expected the socket activation variables to be unset")
+			}
+			for name, index := range tt.expected {
+				inherited := false
+				for _, addr := range addrs {
+					inherited = inherited || strings.Contains(output, name+"="+addr+" ")
+				}
+				if index >= 0 && !strings.Contains(output, name+"="+addrs[index]+" ") {
+					t.Errorf("Expected %s to inherit %s, got %s", name, addrs[index], output)
+				}
+				if index < 0 && (inherited || strings.Contains(output, name+"=error")) {
+					t.Errorf("Expected %s to listen on a new socket, got %s", name, output)
+				}
+			}
+		})
+	}
+}
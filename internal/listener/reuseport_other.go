@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package listener
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(network, address string, conn syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
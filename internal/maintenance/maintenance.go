@@ -0,0 +1,179 @@
+package maintenance
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/middleware"
+)
+
+// defaultPage is served to browsers when no page is configured
+var defaultPage = template.Must(template.New("maintenance").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Down for maintenance</title></head>
+<body>
+<h1>Down for maintenance</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+// Page is the response to requests during maintenance
+type Page struct {
+	// Message explains the maintenance, unless the mode was enabled with its own
+	Message string
+	// HTML is served to clients accepting text/html, executed with the Message
+	// and RetryAfter of PageData; nil serves a plain default page
+	HTML *template.Template
+	// RetryAfter is sent in Retry-After for clients to come back after
+	RetryAfter time.Duration
+}
+
+// PageData is what the HTML page is executed with
+type PageData struct {
+	Message string
+	// RetryAfter is in seconds
+	RetryAfter int64
+}
+
+// State is whether maintenance mode is on, and since when and by whom
+type State struct {
+	Enabled bool      `json:"enabled"`
+	Message string    `json:"message,omitempty"`
+	Since   time.Time `json:"since,omitzero"`
+	By      string    `json:"by,omitempty"`
+}
+
+// Response is the JSON body of the requests refused during maintenance
+type Response struct {
+	middleware.ErrorResponse
+	Message string `json:"message"`
+	// RetryAfter is in seconds
+	RetryAfter int64 `json:"retry_after"`
+}
+
+// Mode answers requests with 503 Service Unavailable while it is enabled, so
+// deploys can drain the traffic of a replica. Probes and the paths to turn it
+// off are still served.
+type Mode struct {
+	exempt []string
+	now    func() time.Time
+
+	mu    sync.RWMutex
+	page  Page
+	state State
+}
+
+// New creates a disabled mode answering with page. Requests to the exempt
+// paths are served during maintenance; those ending with a slash exempt the
+// paths under them.
+func New(page Page, exempt ...string) *Mode {
+	return &Mode{
+		exempt: exempt,
+		now:    time.Now,
+		page:   page,
+	}
+}
+
+// SetPage replaces the response to requests during maintenance
+func (m *Mode) SetPage(page Page) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.page = page
+}
+
+// Enable turns maintenance mode on on behalf of by, with message replacing
+// the page's message if it is set. Enabling it again updates the message.
+func (m *Mode) Enable(message, by string) State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	since := m.state.Since
+	if !m.state.Enabled {
+		since = m.now().UTC().Truncate(time.Second)
+	}
+	m.state = State{Enabled: true, Message: message, Since: since, By: by}
+	return m.state
+}
+
+// Disable turns maintenance mode off
+func (m *Mode) Disable() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = State{}
+	return m.state
+}
+
+// State returns whether maintenance mode is on
+func (m *Mode) State() State {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// Enabled reports whether maintenance mode is on
+func (m *Mode) Enabled() bool {
+	return m.State().Enabled
+}
+
+// Middleware answers the requests to paths that aren't exempt with the page
+// and 503 Service Unavailable while maintenance mode is on
+func (m *Mode) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mu.RLock()
+		state, page := m.state, m.page
+		m.mu.RUnlock()
+		if !state.Enabled || m.exempted(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		message := state.Message
+		if message == "" {
+			message = page.Message
+		}
+		retryAfter := int64(page.RetryAfter.Round(time.Second) / time.Second)
+		h := w.Header()
+		h.Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+		h.Set("Cache-Control", "no-store")
+		h.Set("X-Content-Type-Options", "nosniff")
+		if acceptsHTML(r) {
+			html := page.HTML
+			if html == nil {
+				html = defaultPage
+			}
+			h.Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			html.Execute(w, PageData{Message: message, RetryAfter: retryAfter})
+			return
+		}
+		h.Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(Response{
+			ErrorResponse: middleware.ErrorResponse{
+				Error:     "Service Unavailable: down for maintenance",
+				RequestID: middleware.RequestIDFrom(r.Context()),
+			},
+			Message:    message,
+			RetryAfter: retryAfter,
+		})
+	})
+}
+
+func (m *Mode) exempted(path string) bool {
+	for _, exempt := range m.exempt {
+		if path == exempt || strings.HasSuffix(exempt, "/") && strings.HasPrefix(path, exempt) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsHTML reports whether the client, like a browser, asks for HTML
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
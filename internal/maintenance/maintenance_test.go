@@ -0,0 +1,88 @@
+package maintenance
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMode_Middleware(t *testing.T) {
+	page := Page{
+		Message:    "Upgrading the database",
+		HTML:       template.Must(template.New("page").Parse(`<p>{{.Message}}, back in {{.RetryAfter}}s</p>`)),
+		RetryAfter: 5 * time.Minute,
+	}
+	mode := New(page, "/healthz", "/api/v1/admin/maintenance")
+	h := mode.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		enabled    bool
+		message    string
+		path       string
+		accept     string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "disabled", path: "/api/v1/storage/files/a.txt", wantStatus: http.StatusOK},
+		{name: "probe", enabled: true, path: "/healthz", wantStatus: http.StatusOK},
+		{name: "toggle", enabled: true, path: "/api/v1/admin/maintenance", wantStatus: http.StatusOK},
+		{name: "api", enabled: true, path: "/api/v1/storage/files/a.txt", accept: "application/json", wantStatus: http.StatusServiceUnavailable, wantBody: `"message":"Upgrading the database"`},
+		{name: "browser", enabled: true, path: "/index.html", accept: "text/html,application/xhtml+xml", wantStatus: http.StatusServiceUnavailable, wantBody: "<p>Upgrading the database, back in 300s</p>"},
+		{name: "own message", enabled: true, message: "Moving to a new region", path: "/index.html", accept: "text/html", wantStatus: http.StatusServiceUnavailable, wantBody: "Moving to a new region"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode.Disable()
+			if tt.enabled {
+				mode.Enable(tt.message, "ops")
+			}
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("Expected the body to contain %q, got %q", tt.wantBody, w.Body.String())
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable && w.Header().Get("Retry-After") != "300" {
+				t.Errorf("Expected Retry-After 300, got %q", w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestMode_Enable(t *testing.T) {
+	mode := New(Page{})
+	since := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	mode.now = func() time.Time { return since }
+
+	state := mode.Enable("", "ops")
+	if !state.Enabled || !state.Since.Equal(since) || state.By != "ops" {
+		t.Errorf("Expected maintenance enabled by ops at %s, got %+v", since, state)
+	}
+	// Updating the message keeps when maintenance started
+	mode.now = func() time.Time { return since.Add(time.Hour) }
+	if state := mode.Enable("Almost done", "ops"); !state.Since.Equal(since) || state.Message != "Almost done" {
+		t.Errorf("Expected the message to be updated since %s, got %+v", since, state)
+	}
+
+	mode.Disable()
+	if mode.Enabled() {
+		t.Error("Expected maintenance to be disabled")
+	}
+	data, _ := json.Marshal(mode.State())
+	if string(data) != `{"enabled":false}` {
+		t.Errorf("Expected only enabled to be reported, got %s", data)
+	}
+}
@@ -0,0 +1,7 @@
+package media
+
+import "errors"
+
+var (
+	ErrFrameNotFound = errors.New("frame not found")
+)
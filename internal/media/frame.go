@@ -0,0 +1,72 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// FrameExtractor extracts a still frame from a video
+type FrameExtractor interface {
+	// ExtractFrame returns a JPEG encoded frame captured at the given offset
+	ExtractFrame(ctx context.Context, video io.Reader, at time.Duration) ([]byte, error)
+}
+
+// FFmpegExtractor extracts frames by shelling out to an ffmpeg binary
+type FFmpegExtractor struct {
+	binary string
+}
+
+// NewFFmpegExtractor creates an extractor using the ffmpeg binary at the given path
+func NewFFmpegExtractor(binary string) (*FFmpegExtractor, error) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg not found: %w", err)
+	}
+	return &FFmpegExtractor{
+		binary: path,
+	}, nil
+}
+
+func (e *FFmpegExtractor) ExtractFrame(ctx context.Context, video io.Reader, at time.Duration) ([]byte, error) {
+	// MP4 files may keep their index at the end, so ffmpeg needs a seekable input
+	tmp, err := os.CreateTemp("", "frame-*.video")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, video); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("failed to buffer video: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to buffer video: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.binary,
+		"-hide_banner", "-loglevel", "error",
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64),
+		"-i", tmp.Name(),
+		"-frames:v", "1",
+		"-f", "image2", "-c:v", "mjpeg",
+		"pipe:1",
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("%w: no frame at %s", ErrFrameNotFound, at)
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	value atomic.Int64
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add adds n to the counter
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+// Value returns the current count
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func() float64
+}
+
+// Registry holds metrics and renders them in the Prometheus text format
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+	}
+}
+
+// Default is the registry served by Handler
+var Default = NewRegistry()
+
+// NewCounter creates a counter registered in the default registry
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounter(name, help)
+}
+
+// NewGaugeFunc registers a gauge in the default registry whose value is read from fn on every scrape
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.NewGaugeFunc(name, help, fn)
+}
+
+// Handler serves the default registry
+func Handler() http.Handler {
+	return Default
+}
+
+// NewCounter creates and registers a counter. Registering a name twice replaces the earlier metric.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	r.register(metric{name: name, help: help, kind: "counter", value: func() float64 {
+		return float64(c.Value())
+	}})
+	return c
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(metric{name: name, help: help, kind: "gauge", value: fn})
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics[m.name] = m
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]metric, 0, len(names))
+	slices.Sort(names)
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", m.name, m.help, m.name, m.kind, m.name, strconv.FormatFloat(m.value(), 'g', -1, 64))
+	}
+}
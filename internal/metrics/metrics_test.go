@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	hits := registry.NewCounter("cache_hits_total", "Cache hits")
+	hits.Inc()
+	hits.Add(2)
+	registry.NewGaugeFunc("cache_bytes", "Cached bytes", func() float64 { return 1.5 })
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := "# HELP cache_bytes Cached bytes\n# TYPE cache_bytes gauge\ncache_bytes 1.5\n" +
+		"# HELP cache_hits_total Cache hits\n# TYPE cache_hits_total counter\ncache_hits_total 3\n"
+	if got := w.Body.String(); got != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ErrorResponse is the JSON error envelope
+type ErrorResponse struct {
+	Error     string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// WriteError replies with message in the JSON error envelope, tagged with the
+// ID of the request
+func WriteError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error:     message,
+		RequestID: RequestIDFrom(r.Context()),
+	})
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"gcp-proxy-mity/internal/clientip"
+)
+
+// Logger logs every request with its status, response size and duration
+func Logger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newRecorder(w)
+		// Deferred so aborted responses are logged as well
+		defer func() {
+			slog.Info("HTTP request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rec.Status(),
+				"bytes", rec.written,
+				"duration", time.Since(start).Round(time.Microsecond),
+				"remote", clientip.FromRequest(r).Addr.String(),
+				"request_id", RequestIDFrom(r.Context()),
+			)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"gcp-proxy-mity/internal/metrics"
+)
+
+var (
+	requestsTotal  = metrics.NewCounter("gcs_proxy_http_requests_total", "HTTP requests served")
+	clientErrors   = metrics.NewCounter("gcs_proxy_http_client_errors_total", "HTTP requests answered with a 4xx status")
+	serverErrors   = metrics.NewCounter("gcs_proxy_http_server_errors_total", "HTTP requests answered with a 5xx status")
+	responseBytes  = metrics.NewCounter("gcs_proxy_http_response_bytes_total", "Bytes written in HTTP response bodies")
+	aborted        = metrics.NewCounter("gcs_proxy_http_aborted_total", "HTTP requests whose client went away before they were answered")
+	requestsActive atomic.Int64
+)
+
+func init() {
+	metrics.NewGaugeFunc("gcs_proxy_http_requests_in_flight", "HTTP requests being served", func() float64 {
+		return float64(requestsActive.Load())
+	})
+}
+
+// Metrics counts requests by status class and the bytes served. Requests the
+// client abandoned, like uploads cut off by a disconnect, are counted as
+// aborted rather than by the status they were answered with.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsActive.Add(1)
+		rec := newRecorder(w)
+		defer func() {
+			requestsActive.Add(-1)
+			requestsTotal.Inc()
+			responseBytes.Add(rec.written)
+			switch status := rec.Status(); {
+			case r.Context().Err() != nil:
+				aborted.Inc()
+			case status >= 500:
+				serverErrors.Inc()
+			case status >= 400:
+				clientErrors.Inc()
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
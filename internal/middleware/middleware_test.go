@@ -0,0 +1,320 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/clientip"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}), tag("first"), tag("second"))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := strings.Join(order, ","); got != "first,second,handler" {
+		t.Errorf("Expected first,second,handler, got %s", got)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		disconnect    bool
+		clientErrors  int64
+		serverErrors  int64
+		aborted       int64
+		responseBytes int64
+	}{
+		{
+			name:          "ok",
+			handler:       func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) },
+			responseBytes: 5,
+		},
+		{
+			name:         "not found",
+			handler:      func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) },
+			clientErrors: 1,
+		},
+		{
+			name:         "server error",
+			handler:      func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadGateway) },
+			serverErrors: 1,
+		},
+		{
+			name:       "client disconnected",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+			disconnect: true,
+			aborted:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total, clients, servers, aborts, bytes := requestsTotal.Value(), clientErrors.Value(), serverErrors.Value(), aborted.Value(), responseBytes.Value()
+
+			r := httptest.NewRequest(http.MethodPut, "/", nil)
+			if tt.disconnect {
+				// net/http cancels the context of a request whose connection is gone
+				ctx, cancel := context.WithCancel(r.Context())
+				cancel()
+				r = r.WithContext(ctx)
+			}
+			Metrics(tt.handler).ServeHTTP(httptest.NewRecorder(), r)
+
+			if got := requestsTotal.Value() - total; got != 1 {
+				t.Errorf("Expected 1 request, got %d", got)
+			}
+			if got := clientErrors.Value() - clients; got != tt.clientErrors {
+				t.Errorf("Expected %d client errors, got %d", tt.clientErrors, got)
+			}
+			if got := serverErrors.Value() - servers; got != tt.serverErrors {
+				t.Errorf("Expected %d server errors, got %d", tt.serverErrors, got)
+			}
+			if got := aborted.Value() - aborts; got != tt.aborted {
+				t.Errorf("Expected %d aborted requests, got %d", tt.aborted, got)
+			}
+			if got := responseBytes.Value() - bytes; got != tt.responseBytes {
+				t.Errorf("Expected %d response bytes, got %d", tt.responseBytes, got)
+			}
+			if got := requestsActive.Load(); got != 0 {
+				t.Errorf("Expected no requests in flight, got %d", got)
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		status    int
+		wantPanic any
+	}{
+		{
+			name:    "panic before response",
+			handler: func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			status:  http.StatusInternalServerError,
+		},
+		{
+			name: "panic after response started",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				panic(errors.New("boom"))
+			},
+			status:    http.StatusOK,
+			wantPanic: http.ErrAbortHandler,
+		},
+		{
+			name:      "abort",
+			handler:   func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) },
+			status:    http.StatusOK,
+			wantPanic: http.ErrAbortHandler,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recovered := panics.Value()
+			rec := httptest.NewRecorder()
+			func() {
+				defer func() {
+					if got := recover(); got != tt.wantPanic {
+						t.Errorf("Expected panic %v, got %v", tt.wantPanic, got)
+					}
+				}()
+				Chain(tt.handler, RequestID, Recover).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			}()
+
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+			if tt.status != http.StatusInternalServerError {
+				return
+			}
+			if got := panics.Value() - recovered; got != 1 {
+				t.Errorf("Expected 1 recovered panic, got %d", got)
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if body.Error != "Internal server error" {
+				t.Errorf("Expected error message, got %q", body.Error)
+			}
+			if id := rec.Header().Get(RequestIDHeader); body.RequestID == "" || body.RequestID != id {
+				t.Errorf("Expected request ID %q, got %q", id, body.RequestID)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		generate bool
+	}{
+		{name: "missing", header: "", generate: true},
+		{name: "from client", header: "abc-123", generate: false},
+		{name: "too long", header: strings.Repeat("a", 129), generate: true},
+		{name: "control characters", header: "abc\n123", generate: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestIDFrom(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(RequestIDHeader, tt.header)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get(RequestIDHeader); got != seen {
+				t.Errorf("Expected response ID %q, got %q", seen, got)
+			}
+			if tt.generate && (len(seen) != 32 || seen == tt.header) {
+				t.Errorf("Expected a generated ID, got %q", seen)
+			}
+			if !tt.generate && seen != tt.header {
+				t.Errorf("Expected ID %q, got %q", tt.header, seen)
+			}
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	h := Version("v1.2.3 (4f2a9c1)")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := rec.Header().Get(VersionHeader); got != "v1.2.3 (4f2a9c1)" {
+		t.Errorf("Expected version %q, got %q", "v1.2.3 (4f2a9c1)", got)
+	}
+}
+
+func TestRecorder_Hijack(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := newRecorder(w)
+		conn, _, err := http.NewResponseController(rec).Hijack()
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+			return
+		}
+		if rec.Status() != http.StatusSwitchingProtocols {
+			t.Errorf("Expected status 101, got %d", rec.Status())
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+
+	http.Get(server.URL)
+}
+
+func TestSecurity(t *testing.T) {
+	h := Security(SecurityConfig{
+		SecurityHeaders: SecurityHeaders{ContentSecurityPolicy: "sandbox", FrameOptions: "SAMEORIGIN"},
+		Routes: []SecurityRoute{
+			{Prefix: "/api/", SecurityHeaders: SecurityHeaders{ContentSecurityPolicy: "default-src 'none'", FrameOptions: "DENY", ReferrerPolicy: "no-referrer"}},
+			{Prefix: "/docs", SecurityHeaders: SecurityHeaders{}},
+		},
+		HSTSMaxAge: time.Hour,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contentType := r.URL.Query().Get("type"); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Write([]byte(r.URL.Query().Get("body")))
+	}))
+	// httptest requests come from 192.0.2.1
+	h = clientip.New([]netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}).Middleware(h)
+
+	tests := []struct {
+		name     string
+		target   string
+		tls      bool
+		proto    string
+		expected map[string]string
+	}{
+		{
+			name:     "html",
+			target:   "/index.html?type=text/html%3B+charset=utf-8",
+			expected: map[string]string{"X-Content-Type-Options": "nosniff", "Content-Security-Policy": "sandbox", "X-Frame-Options": "SAMEORIGIN", "Referrer-Policy": "", "Strict-Transport-Security": ""},
+		},
+		{
+			name:     "sniffed html",
+			target:   "/page?body=%3Chtml%3E%3C/html%3E",
+			expected: map[string]string{"Content-Security-Policy": "sandbox"},
+		},
+		{
+			name:     "not html",
+			target:   "/photo.png?type=image/png",
+			expected: map[string]string{"X-Content-Type-Options": "nosniff", "Content-Security-Policy": "", "X-Frame-Options": "SAMEORIGIN"},
+		},
+		{
+			name:     "route group",
+			target:   "/api/v1/storage/files/a.html?type=text/html",
+			expected: map[string]string{"Content-Security-Policy": "default-src 'none'", "X-Frame-Options": "DENY", "Referrer-Policy": "no-referrer"},
+		},
+		{
+			name:     "route without headers",
+			target:   "/docs?type=text/html",
+			expected: map[string]string{"X-Content-Type-Options": "nosniff", "Content-Security-Policy": "", "X-Frame-Options": ""},
+		},
+		{
+			name:     "tls",
+			target:   "/",
+			tls:      true,
+			expected: map[string]string{"Strict-Transport-Security": "max-age=3600"},
+		},
+		{
+			name:     "https behind a trusted proxy",
+			target:   "/",
+			proto:    "https",
+			expected: map[string]string{"Strict-Transport-Security": "max-age=3600"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			for name, expected := range tt.expected {
+				if got := rec.Header().Get(name); got != expected {
+					t.Errorf("Expected %s %q, got %q", name, expected, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"gcp-proxy-mity/internal/metrics"
+)
+
+var panics = metrics.NewCounter("gcs_proxy_http_panics_total", "Handler panics recovered")
+
+// Recover turns a panicking handler into a 500 response in the JSON error
+// envelope instead of a dropped connection, and logs the panic with its stack
+// and request ID. Responses that already started are aborted, and
+// http.ErrAbortHandler is passed on as it aborts the response on purpose.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := newRecorder(w)
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			panics.Inc()
+			log.Printf("Panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, RequestIDFrom(r.Context()), err, debug.Stack())
+			if rec.wroteHeader() {
+				// The client would otherwise take the truncated body for a complete response
+				panic(http.ErrAbortHandler)
+			}
+			WriteError(rec, r, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
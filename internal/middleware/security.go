@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/clientip"
+)
+
+// SecurityHeaders are the headers of a route group; empty ones are not sent
+type SecurityHeaders struct {
+	// ContentSecurityPolicy is only sent with HTML responses
+	ContentSecurityPolicy string
+	FrameOptions          string
+	ReferrerPolicy        string
+}
+
+// SecurityRoute replaces the headers for paths starting with Prefix
+type SecurityRoute struct {
+	Prefix string
+	SecurityHeaders
+}
+
+// SecurityConfig sets the headers of responses; the longest matching route wins
+type SecurityConfig struct {
+	SecurityHeaders
+	Routes []SecurityRoute
+	// HSTSMaxAge is sent in Strict-Transport-Security on requests over HTTPS; 0 sends none
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+}
+
+// Security sets X-Content-Type-Options: nosniff and the configured security
+// headers on every response. Headers a handler sets itself are kept.
+func Security(cfg SecurityConfig) Middleware {
+	hsts := fmt.Sprintf("max-age=%d", int(cfg.HSTSMaxAge.Seconds()))
+	if cfg.HSTSIncludeSubdomains {
+		hsts += "; includeSubDomains"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers := cfg.headers(r.URL.Path)
+			header := w.Header()
+			header.Set("X-Content-Type-Options", "nosniff")
+			if headers.FrameOptions != "" {
+				header.Set("X-Frame-Options", headers.FrameOptions)
+			}
+			if headers.ReferrerPolicy != "" {
+				header.Set("Referrer-Policy", headers.ReferrerPolicy)
+			}
+			if clientip.FromRequest(r).Scheme == "https" && cfg.HSTSMaxAge > 0 {
+				header.Set("Strict-Transport-Security", hsts)
+			}
+			if headers.ContentSecurityPolicy != "" {
+				w = &policyWriter{ResponseWriter: w, policy: headers.ContentSecurityPolicy}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (c SecurityConfig) headers(path string) SecurityHeaders {
+	headers, matched := c.SecurityHeaders, 0
+	for _, route := range c.Routes {
+		if strings.HasPrefix(path, route.Prefix) && len(route.Prefix) > matched {
+			headers, matched = route.SecurityHeaders, len(route.Prefix)
+		}
+	}
+	return headers
+}
+
+// policyWriter adds a Content-Security-Policy once the response turns out to
+// be HTML, which is only known when the handler starts writing it
+type policyWriter struct {
+	http.ResponseWriter
+	policy  string
+	checked bool
+}
+
+func (w *policyWriter) check(body []byte) {
+	if w.checked {
+		return
+	}
+	w.checked = true
+	header := w.ResponseWriter.Header()
+	contentType := header.Get("Content-Type")
+	if _, typed := header["Content-Type"]; !typed && body != nil {
+		// net/http sniffs the type of responses without one the same way
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if (mediaType == "text/html" || mediaType == "application/xhtml+xml") && header.Get("Content-Security-Policy") == "" {
+		header.Set("Content-Security-Policy", w.policy)
+	}
+}
+
+func (w *policyWriter) WriteHeader(status int) {
+	w.check(nil)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *policyWriter) Write(p []byte) (int, error) {
+	w.check(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *policyWriter) Flush() {
+	w.check(nil)
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *policyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *policyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
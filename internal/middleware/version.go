@@ -0,0 +1,16 @@
+package middleware
+
+import "net/http"
+
+// VersionHeader tells clients which version of the proxy answered
+const VersionHeader = "X-Proxy-Version"
+
+// Version sets VersionHeader on every response
+func Version(version string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(VersionHeader, version)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
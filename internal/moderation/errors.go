@@ -0,0 +1,8 @@
+package moderation
+
+import "errors"
+
+var (
+	ErrInvalidLikelihood = errors.New("invalid likelihood")
+	ErrRatingFailed      = errors.New("moderation provider could not rate the object")
+)
@@ -0,0 +1,141 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/videointelligence/v1"
+	"google.golang.org/api/vision/v1"
+)
+
+// pollInterval is how often a running video annotation is checked
+const pollInterval = 5 * time.Second
+
+// GoogleProvider rates images with Cloud Vision SafeSearch and videos with Video
+// Intelligence explicit content detection. Both read the object from the bucket,
+// so nothing is downloaded through the proxy.
+type GoogleProvider struct {
+	bucket string
+	vision *vision.Service
+	video  *videointelligence.Service
+}
+
+// NewGoogleProvider creates a provider for objects in bucket
+func NewGoogleProvider(ctx context.Context, bucket string, opts ...option.ClientOption) (*GoogleProvider, error) {
+	visionService, err := vision.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Vision client: %w", err)
+	}
+	videoService, err := videointelligence.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Video Intelligence client: %w", err)
+	}
+	return &GoogleProvider{
+		bucket: bucket,
+		vision: visionService,
+		video:  videoService,
+	}, nil
+}
+
+func (p *GoogleProvider) Supports(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/")
+}
+
+func (p *GoogleProvider) Moderate(ctx context.Context, filePath, contentType string) (Labels, error) {
+	uri := "gs://" + p.bucket + "/" + filePath
+	if strings.HasPrefix(contentType, "video/") {
+		return p.moderateVideo(ctx, uri)
+	}
+	return p.moderateImage(ctx, uri)
+}
+
+func (p *GoogleProvider) moderateImage(ctx context.Context, uri string) (Labels, error) {
+	resp, err := p.vision.Images.Annotate(&vision.BatchAnnotateImagesRequest{
+		Requests: []*vision.AnnotateImageRequest{{
+			Image:    &vision.Image{Source: &vision.ImageSource{GcsImageUri: uri}},
+			Features: []*vision.Feature{{Type: "SAFE_SEARCH_DETECTION"}},
+		}},
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to annotate image: %w", err)
+	}
+	if len(resp.Responses) == 0 {
+		return nil, fmt.Errorf("%w: empty response", ErrRatingFailed)
+	}
+	result := resp.Responses[0]
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRatingFailed, result.Error.Message)
+	}
+	if result.SafeSearchAnnotation == nil {
+		return nil, fmt.Errorf("%w: no safe search annotation", ErrRatingFailed)
+	}
+
+	annotation := result.SafeSearchAnnotation
+	return Labels{
+		"adult":    likelihood(annotation.Adult),
+		"spoof":    likelihood(annotation.Spoof),
+		"medical":  likelihood(annotation.Medical),
+		"violence": likelihood(annotation.Violence),
+		"racy":     likelihood(annotation.Racy),
+	}, nil
+}
+
+// moderateVideo starts an explicit content annotation and waits for it. The
+// video is rated by its most explicit frame.
+func (p *GoogleProvider) moderateVideo(ctx context.Context, uri string) (Labels, error) {
+	op, err := p.video.Videos.Annotate(&videointelligence.GoogleCloudVideointelligenceV1AnnotateVideoRequest{
+		InputUri: uri,
+		Features: []string{"EXPLICIT_CONTENT_DETECTION"},
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to annotate video: %w", err)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for !op.Done {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		op, err = p.video.Projects.Locations.Operations.Get(op.Name).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to poll video annotation: %w", err)
+		}
+	}
+	if op.Error != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRatingFailed, op.Error.Message)
+	}
+
+	var resp videointelligence.GoogleCloudVideointelligenceV1AnnotateVideoResponse
+	if err := json.Unmarshal(op.Response, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode video annotation: %w", err)
+	}
+	adult := Unknown
+	for _, result := range resp.AnnotationResults {
+		if result.Error != nil {
+			return nil, fmt.Errorf("%w: %s", ErrRatingFailed, result.Error.Message)
+		}
+		if result.ExplicitAnnotation == nil {
+			continue
+		}
+		for _, frame := range result.ExplicitAnnotation.Frames {
+			adult = max(adult, likelihood(frame.PornographyLikelihood))
+		}
+	}
+	return Labels{"adult": adult}, nil
+}
+
+// likelihood converts an API likelihood, which may be LIKELIHOOD_UNSPECIFIED, to a Likelihood
+func likelihood(name string) Likelihood {
+	l, err := ParseLikelihood(name)
+	if err != nil {
+		return Unknown
+	}
+	return l
+}
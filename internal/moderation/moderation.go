@@ -0,0 +1,179 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/metrics"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Object metadata keys recording a verdict
+const (
+	// StatusKey is StatusApproved or StatusFlagged
+	StatusKey = "moderation-status"
+	// LabelKeyPrefix precedes each rated category, e.g. moderation-adult: VERY_UNLIKELY
+	LabelKeyPrefix = "moderation-"
+)
+
+// Verdicts
+const (
+	StatusApproved = "approved"
+	StatusFlagged  = "flagged"
+)
+
+var (
+	moderatedObjects   = metrics.NewCounter("gcs_proxy_moderated_objects_total", "Objects rated by the moderation provider")
+	flaggedObjects     = metrics.NewCounter("gcs_proxy_moderation_flagged_total", "Objects flagged by moderation")
+	quarantinedObjects = metrics.NewCounter("gcs_proxy_moderation_quarantined_total", "Flagged objects moved to the quarantine prefix")
+)
+
+// Likelihood is how likely an object shows a category, in the scale Cloud Vision
+// and Video Intelligence report
+type Likelihood int
+
+const (
+	Unknown Likelihood = iota
+	VeryUnlikely
+	Unlikely
+	Possible
+	Likely
+	VeryLikely
+)
+
+var likelihoodNames = []string{"UNKNOWN", "VERY_UNLIKELY", "UNLIKELY", "POSSIBLE", "LIKELY", "VERY_LIKELY"}
+
+func (l Likelihood) String() string {
+	if l < Unknown || l > VeryLikely {
+		return likelihoodNames[Unknown]
+	}
+	return likelihoodNames[l]
+}
+
+// ParseLikelihood parses a likelihood name such as LIKELY, ignoring case
+func ParseLikelihood(name string) (Likelihood, error) {
+	i := slices.Index(likelihoodNames, strings.ToUpper(name))
+	if i < 0 {
+		return Unknown, fmt.Errorf("%w: %q", ErrInvalidLikelihood, name)
+	}
+	return Likelihood(i), nil
+}
+
+// Labels maps each category a provider rated to its likelihood
+type Labels map[string]Likelihood
+
+// Provider rates stored objects for unsafe content
+type Provider interface {
+	// Supports reports whether the provider can rate objects of the content type
+	Supports(contentType string) bool
+	// Moderate rates the object at filePath
+	Moderate(ctx context.Context, filePath, contentType string) (Labels, error)
+}
+
+// Store is what the moderator changes rated objects through
+type Store interface {
+	UpdateMetadata(ctx context.Context, filePath string, metadata map[string]string) error
+	CopyFile(ctx context.Context, srcPath, dstPath string) error
+	DeleteFile(ctx context.Context, filePath string) error
+}
+
+// Config decides which verdicts flag an object and what happens to it
+type Config struct {
+	// Categories are the labels that can flag an object; empty means all of them
+	Categories []string
+	// Threshold is the likelihood from which a category flags an object
+	Threshold Likelihood
+	// QuarantinePrefix is where flagged objects are moved; empty only labels them
+	QuarantinePrefix string
+}
+
+// Moderator is an events.Sender that rates written images and videos, stores
+// the verdict in the object's metadata and quarantines flagged objects. Wrapped
+// in an events.AsyncPublisher it runs in the background with retries.
+type Moderator struct {
+	provider Provider
+	store    Store
+	cfg      Config
+}
+
+// NewModerator creates a moderator rating objects with provider
+func NewModerator(provider Provider, store Store, cfg Config) *Moderator {
+	cfg.QuarantinePrefix = strings.Trim(cfg.QuarantinePrefix, "/")
+	return &Moderator{
+		provider: provider,
+		store:    store,
+		cfg:      cfg,
+	}
+}
+
+// InQuarantine reports whether filePath is kept under the quarantine prefix
+func InQuarantine(prefix, filePath string) bool {
+	prefix = strings.Trim(prefix, "/")
+	return prefix != "" && strings.HasPrefix(filePath, prefix+"/")
+}
+
+func (m *Moderator) Send(ctx context.Context, event events.Event) error {
+	if event.Type != events.ObjectWritten || !m.provider.Supports(event.ContentType) || InQuarantine(m.cfg.QuarantinePrefix, event.Path) {
+		return nil
+	}
+
+	labels, err := m.provider.Moderate(ctx, event.Path, event.ContentType)
+	if err != nil {
+		return fmt.Errorf("failed to moderate %s: %w", event.Path, err)
+	}
+	moderatedObjects.Inc()
+
+	flagged := m.flagged(labels)
+	metadata := map[string]string{StatusKey: StatusApproved}
+	if flagged {
+		metadata[StatusKey] = StatusFlagged
+	}
+	for category, likelihood := range labels {
+		metadata[LabelKeyPrefix+category] = likelihood.String()
+	}
+	if err := m.store.UpdateMetadata(ctx, event.Path, metadata); err != nil {
+		// The object was deleted or replaced while it was being rated
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to label %s: %w", event.Path, err)
+	}
+	if !flagged {
+		return nil
+	}
+	flaggedObjects.Inc()
+
+	if m.cfg.QuarantinePrefix == "" {
+		log.Printf("Moderation flagged %s", event.Path)
+		return nil
+	}
+	// The labels are set first so the quarantined copy carries them
+	dst := m.cfg.QuarantinePrefix + "/" + event.Path
+	if err := m.store.CopyFile(ctx, event.Path, dst); err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", event.Path, err)
+	}
+	if err := m.store.DeleteFile(ctx, event.Path); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("failed to quarantine %s: %w", event.Path, err)
+	}
+	quarantinedObjects.Inc()
+	log.Printf("Moderation flagged %s and moved it to %s", event.Path, dst)
+	return nil
+}
+
+// flagged reports whether any of the configured categories reaches the threshold
+func (m *Moderator) flagged(labels Labels) bool {
+	for category, likelihood := range labels {
+		if likelihood == Unknown || likelihood < m.cfg.Threshold {
+			continue
+		}
+		if len(m.cfg.Categories) == 0 || slices.Contains(m.cfg.Categories, category) {
+			return true
+		}
+	}
+	return false
+}
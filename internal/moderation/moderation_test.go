@@ -0,0 +1,178 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/storage"
+)
+
+type mockProvider struct {
+	labels Labels
+	err    error
+	rated  []string
+}
+
+func (p *mockProvider) Supports(contentType string) bool {
+	return contentType == "image/jpeg"
+}
+
+func (p *mockProvider) Moderate(ctx context.Context, filePath, contentType string) (Labels, error) {
+	p.rated = append(p.rated, filePath)
+	return p.labels, p.err
+}
+
+type mockStore struct {
+	metadata    map[string]string
+	metadataErr error
+	copied      map[string]string
+	deleted     []string
+}
+
+func (s *mockStore) UpdateMetadata(ctx context.Context, filePath string, metadata map[string]string) error {
+	s.metadata = metadata
+	return s.metadataErr
+}
+
+func (s *mockStore) CopyFile(ctx context.Context, srcPath, dstPath string) error {
+	if s.copied == nil {
+		s.copied = make(map[string]string)
+	}
+	s.copied[srcPath] = dstPath
+	return nil
+}
+
+func (s *mockStore) DeleteFile(ctx context.Context, filePath string) error {
+	s.deleted = append(s.deleted, filePath)
+	return nil
+}
+
+func TestParseLikelihood(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Likelihood
+		wantErr bool
+	}{
+		{name: "LIKELY", want: Likely},
+		{name: "very_unlikely", want: VeryUnlikely},
+		{name: "LIKELIHOOD_UNSPECIFIED", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLikelihood(tt.name)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidLikelihood) {
+					t.Errorf("Expected ErrInvalidLikelihood, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestModerator_Send(t *testing.T) {
+	tests := []struct {
+		name         string
+		event        events.Event
+		labels       Labels
+		quarantine   string
+		metadataErr  error
+		wantRated    bool
+		wantMetadata map[string]string
+		wantCopied   map[string]string
+	}{
+		{
+			name:         "approved",
+			event:        events.Event{Type: events.ObjectWritten, Path: "a.jpg", ContentType: "image/jpeg"},
+			labels:       Labels{"adult": Possible, "medical": VeryLikely},
+			quarantine:   "_quarantine",
+			wantRated:    true,
+			wantMetadata: map[string]string{StatusKey: StatusApproved, "moderation-adult": "POSSIBLE", "moderation-medical": "VERY_LIKELY"},
+		},
+		{
+			name:         "flagged",
+			event:        events.Event{Type: events.ObjectWritten, Path: "a.jpg", ContentType: "image/jpeg"},
+			labels:       Labels{"adult": Likely},
+			wantRated:    true,
+			wantMetadata: map[string]string{StatusKey: StatusFlagged, "moderation-adult": "LIKELY"},
+		},
+		{
+			name:         "quarantined",
+			event:        events.Event{Type: events.ObjectWritten, Path: "a.jpg", ContentType: "image/jpeg"},
+			labels:       Labels{"violence": VeryLikely},
+			quarantine:   "/_quarantine/",
+			wantRated:    true,
+			wantMetadata: map[string]string{StatusKey: StatusFlagged, "moderation-violence": "VERY_LIKELY"},
+			wantCopied:   map[string]string{"a.jpg": "_quarantine/a.jpg"},
+		},
+		{
+			name:        "deleted before labeling",
+			event:       events.Event{Type: events.ObjectWritten, Path: "a.jpg", ContentType: "image/jpeg"},
+			labels:      Labels{"adult": VeryLikely},
+			quarantine:  "_quarantine",
+			metadataErr: storage.ErrNotFound,
+			wantRated:   true,
+		},
+		{
+			name:  "unsupported type",
+			event: events.Event{Type: events.ObjectWritten, Path: "a.txt", ContentType: "text/plain"},
+		},
+		{
+			name:       "already quarantined",
+			event:      events.Event{Type: events.ObjectWritten, Path: "_quarantine/a.jpg", ContentType: "image/jpeg"},
+			quarantine: "_quarantine",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &mockProvider{labels: tt.labels}
+			store := &mockStore{metadataErr: tt.metadataErr}
+			moderator := NewModerator(provider, store, Config{
+				Categories:       []string{"adult", "violence", "racy"},
+				Threshold:        Likely,
+				QuarantinePrefix: tt.quarantine,
+			})
+
+			if err := moderator.Send(context.Background(), tt.event); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if rated := len(provider.rated) > 0; rated != tt.wantRated {
+				t.Errorf("Expected rated %v, got %v", tt.wantRated, rated)
+			}
+			if tt.metadataErr == nil && !reflect.DeepEqual(store.metadata, tt.wantMetadata) {
+				t.Errorf("Expected metadata %v, got %v", tt.wantMetadata, store.metadata)
+			}
+			if !reflect.DeepEqual(store.copied, tt.wantCopied) {
+				t.Errorf("Expected copies %v, got %v", tt.wantCopied, store.copied)
+			}
+			if tt.wantCopied != nil && !reflect.DeepEqual(store.deleted, []string{tt.event.Path}) {
+				t.Errorf("Expected %s to be deleted, got %v", tt.event.Path, store.deleted)
+			}
+		})
+	}
+}
+
+func TestModerator_Send_ProviderError(t *testing.T) {
+	provider := &mockProvider{err: ErrRatingFailed}
+	store := &mockStore{}
+	moderator := NewModerator(provider, store, Config{Threshold: Likely})
+
+	err := moderator.Send(context.Background(), events.Event{Type: events.ObjectWritten, Path: "a.jpg", ContentType: "image/jpeg"})
+	if !errors.Is(err, ErrRatingFailed) {
+		t.Errorf("Expected ErrRatingFailed to be retried, got %v", err)
+	}
+	if store.metadata != nil {
+		t.Errorf("Expected no labels, got %v", store.metadata)
+	}
+}
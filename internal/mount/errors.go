@@ -0,0 +1,13 @@
+package mount
+
+import "errors"
+
+var (
+	ErrNotFound      = errors.New("mount not found")
+	ErrExists        = errors.New("a mount already exists at this prefix")
+	ErrInvalidMount  = errors.New("invalid mount")
+	ErrStatic        = errors.New("mount is configured statically and can't be removed")
+	ErrForbidden     = errors.New("mount requires a scope the API key doesn't grant")
+	ErrReadOnly      = errors.New("mount is read-only")
+	ErrQuotaExceeded = errors.New("mount quota exceeded")
+)
@@ -0,0 +1,452 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Mount stores the files under Prefix in the backend at Target instead of the
+// default bucket, with its own quota, caching and access policy
+type Mount struct {
+	Prefix string `json:"prefix"`
+	// Target is gs://bucket or file:///path
+	Target string `json:"target"`
+	// QuotaBytes caps the bytes stored under the mount; zero is unlimited
+	QuotaBytes int64 `json:"quota_bytes,omitempty"`
+	// Cache serves the mount's small objects from the read cache
+	Cache bool `json:"cache,omitempty"`
+	// Scope must be granted to the API keys using the mount
+	Scope    string `json:"scope,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+	// Static mounts come from the configuration and can't be removed
+	Static    bool      `json:"static,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+}
+
+// Validate checks the prefix, target and quota of a mount
+func (m Mount) Validate() error {
+	if m.Prefix == "" || strings.HasPrefix(m.Prefix, "/") {
+		return fmt.Errorf("%w: prefix must be set and not start with a slash", ErrInvalidMount)
+	}
+	if m.QuotaBytes < 0 {
+		return fmt.Errorf("%w: quota_bytes must not be negative", ErrInvalidMount)
+	}
+	_, _, err := ParseTarget(m.Target)
+	return err
+}
+
+// equal reports whether two mounts are the same, wherever their times were read
+func (m Mount) equal(other Mount) bool {
+	a, b := m, other
+	a.CreatedAt, b.CreatedAt = time.Time{}, time.Time{}
+	return a == b && m.CreatedAt.Equal(other.CreatedAt)
+}
+
+// ParseTarget splits a target into its scheme, gs or file, and the bucket or
+// absolute directory it names
+func ParseTarget(target string) (scheme, location string, err error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: target must be a URL: %v", ErrInvalidMount, err)
+	}
+	switch u.Scheme {
+	case "gs":
+		if u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return "", "", fmt.Errorf("%w: target must name a bucket, like gs://bucket", ErrInvalidMount)
+		}
+		return u.Scheme, u.Host, nil
+	case "file":
+		if u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+			return "", "", fmt.Errorf("%w: target must be an absolute path, like file:///var/lib/proxy", ErrInvalidMount)
+		}
+		return u.Scheme, u.Path, nil
+	default:
+		return "", "", fmt.Errorf("%w: target must be a gs:// or file:// URL, got %q", ErrInvalidMount, target)
+	}
+}
+
+// Store keeps the mounts added at runtime
+type Store interface {
+	// Create stores a new mount
+	Create(ctx context.Context, mount Mount) error
+	// List returns the stored mounts by prefix
+	List(ctx context.Context) ([]Mount, error)
+	// Delete removes the mount at prefix, failing with ErrNotFound if there is none
+	Delete(ctx context.Context, prefix string) error
+}
+
+// Opener creates the backend a mount's files are stored in, and the function
+// releasing it once the mount is removed, if it needs one
+type Opener func(ctx context.Context, mount Mount) (backend storage.Storage, close func() error, err error)
+
+// usageTTL is how long a mount's listed usage is trusted before listing it again
+const usageTTL = time.Minute
+
+// Entry is a mount of the table with its backend
+type Entry struct {
+	Mount
+	Storage storage.Storage
+	close   func() error
+	now     func() time.Time
+
+	mu       sync.Mutex
+	used     int64
+	measured time.Time
+}
+
+// Authorize checks that the principal in ctx may use the mount, to write to it
+// when write is set. Like auth.RequireScope, callers without a key, such as
+// share links, are refused a mount with a scope; only the proxy's own work,
+// marked with auth.WithSystem, isn't held to it.
+func (e *Entry) Authorize(ctx context.Context, write bool) error {
+	if e.Scope != "" && !auth.IsSystem(ctx) && !auth.HasScope(ctx, e.Scope) {
+		return fmt.Errorf("%w: %s requires the %s scope", ErrForbidden, e.Prefix, e.Scope)
+	}
+	if write && e.ReadOnly {
+		return fmt.Errorf("%w: %s", ErrReadOnly, e.Prefix)
+	}
+	return nil
+}
+
+// Reserve checks that the mount has room for more files. The bytes under it
+// are listed at most every minute and the writes since are added, so the quota
+// is soft: concurrent writes and the last one may go past it.
+func (e *Entry) Reserve(ctx context.Context) error {
+	if e.QuotaBytes == 0 {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if now := e.now(); now.Sub(e.measured) >= usageTTL {
+		var used int64
+		err := e.Storage.WalkFiles(ctx, e.Prefix, func(file storage.FileMetadata) error {
+			used += file.Size
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to measure mount %s: %w", e.Prefix, err)
+		}
+		e.used, e.measured = used, now
+	}
+	if e.used >= e.QuotaBytes {
+		return fmt.Errorf("%w: %s stores %d of its %d bytes", ErrQuotaExceeded, e.Prefix, e.used, e.QuotaBytes)
+	}
+	return nil
+}
+
+// Record counts bytes written to the mount until its usage is listed again
+func (e *Entry) Record(bytes int64) {
+	if e.QuotaBytes == 0 {
+		return
+	}
+	e.mu.Lock()
+	e.used += bytes
+	e.mu.Unlock()
+}
+
+// Table resolves paths to the mount they are under. Static mounts come from
+// the configuration; the others are added and removed at runtime and kept in
+// a store, which other replicas pick up when they refresh.
+type Table struct {
+	open Opener
+	now  func() time.Time
+
+	// update serializes the changes to the table, so a refresh doesn't undo them
+	update sync.Mutex
+
+	mu      sync.RWMutex
+	store   Store
+	entries []*Entry
+}
+
+// NewTable creates a table opening the backends of its mounts with open. Its
+// mounts are kept in memory until it is given a store with Persist.
+func NewTable(open Opener) *Table {
+	return &Table{
+		open:  open,
+		now:   time.Now,
+		store: NewMemoryStore(),
+	}
+}
+
+// AddStatic mounts m for as long as the process runs, without storing it
+func (t *Table) AddStatic(ctx context.Context, m Mount) error {
+	m.Static = true
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	t.update.Lock()
+	defer t.update.Unlock()
+	if t.lookup(m.Prefix) != nil {
+		return fmt.Errorf("%w: %s", ErrExists, m.Prefix)
+	}
+	entry, err := t.openEntry(ctx, m)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.entries = append(t.entries, entry)
+	t.mu.Unlock()
+	return nil
+}
+
+// Persist keeps the mounts added at runtime in store and loads those it holds
+func (t *Table) Persist(ctx context.Context, store Store) error {
+	t.mu.Lock()
+	t.store = store
+	t.mu.Unlock()
+	return t.Refresh(ctx)
+}
+
+// Refresh loads the mounts added and removed through other replicas. Mounts
+// whose backend fails to open are skipped and reported, and tried again on the
+// next refresh.
+func (t *Table) Refresh(ctx context.Context) error {
+	t.update.Lock()
+	defer t.update.Unlock()
+	t.mu.RLock()
+	store := t.store
+	t.mu.RUnlock()
+	stored, err := store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list mounts: %w", err)
+	}
+
+	var (
+		entries []*Entry
+		kept    = make(map[*Entry]bool)
+		errs    []error
+	)
+	for _, entry := range t.Entries() {
+		if entry.Static {
+			entries = append(entries, entry)
+			kept[entry] = true
+		}
+	}
+	for _, m := range stored {
+		if slices.ContainsFunc(entries, func(e *Entry) bool { return e.Prefix == m.Prefix }) {
+			errs = append(errs, fmt.Errorf("%w: %s is configured statically", ErrExists, m.Prefix))
+			continue
+		}
+		if existing := t.lookup(m.Prefix); existing != nil && existing.Mount.equal(m) {
+			entries = append(entries, existing)
+			kept[existing] = true
+			continue
+		}
+		entry, err := t.openEntry(ctx, m)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	t.mu.Lock()
+	removed := t.entries
+	t.entries = entries
+	t.mu.Unlock()
+	for _, entry := range removed {
+		if !kept[entry] {
+			entry.release()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run refreshes the table every interval until ctx is done
+func (t *Table) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.Refresh(ctx); err != nil {
+				log.Printf("Failed to refresh mounts: %v", err)
+			}
+		}
+	}
+}
+
+// Create mounts m on behalf of createdBy and stores it
+func (t *Table) Create(ctx context.Context, m Mount, createdBy string) (*Mount, error) {
+	m.Prefix = strings.TrimPrefix(m.Prefix, "/")
+	m.Static = false
+	m.CreatedBy = createdBy
+	m.CreatedAt = t.now().UTC().Truncate(time.Millisecond)
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	t.update.Lock()
+	defer t.update.Unlock()
+	if t.lookup(m.Prefix) != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExists, m.Prefix)
+	}
+	entry, err := t.openEntry(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.RLock()
+	store := t.store
+	t.mu.RUnlock()
+	if err := store.Create(ctx, m); err != nil {
+		entry.release()
+		return nil, err
+	}
+	t.mu.Lock()
+	t.entries = append(t.entries, entry)
+	t.mu.Unlock()
+	return &m, nil
+}
+
+// Delete unmounts the mount at prefix and removes it from the store. The files
+// stay in its backend.
+func (t *Table) Delete(ctx context.Context, prefix string) error {
+	t.update.Lock()
+	defer t.update.Unlock()
+	entry := t.lookup(strings.TrimPrefix(prefix, "/"))
+	if entry == nil {
+		return ErrNotFound
+	}
+	if entry.Static {
+		return fmt.Errorf("%w: %s", ErrStatic, entry.Prefix)
+	}
+	t.mu.RLock()
+	store := t.store
+	t.mu.RUnlock()
+	if err := store.Delete(ctx, entry.Prefix); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	t.mu.Lock()
+	t.entries = slices.DeleteFunc(t.entries, func(e *Entry) bool { return e == entry })
+	t.mu.Unlock()
+	entry.release()
+	return nil
+}
+
+// Close releases the backends of every mount
+func (t *Table) Close() {
+	t.update.Lock()
+	defer t.update.Unlock()
+	t.mu.Lock()
+	entries := t.entries
+	t.entries = nil
+	t.mu.Unlock()
+	for _, entry := range entries {
+		entry.release()
+	}
+}
+
+// List returns the mounts by prefix
+func (t *Table) List() []Mount {
+	entries := t.Entries()
+	mounts := make([]Mount, 0, len(entries))
+	for _, entry := range entries {
+		mounts = append(mounts, entry.Mount)
+	}
+	slices.SortFunc(mounts, func(a, b Mount) int { return strings.Compare(a.Prefix, b.Prefix) })
+	return mounts
+}
+
+// Entries returns the mounts of the table with their backends
+func (t *Table) Entries() []*Entry {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return slices.Clone(t.entries)
+}
+
+// Resolve returns the mount with the longest prefix of filePath, nil if it
+// isn't under any
+func (t *Table) Resolve(filePath string) *Entry {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var best *Entry
+	for _, entry := range t.entries {
+		if strings.HasPrefix(filePath, entry.Prefix) && (best == nil || len(entry.Prefix) > len(best.Prefix)) {
+			best = entry
+		}
+	}
+	return best
+}
+
+// lookup returns the mount at exactly prefix, nil if there is none
+func (t *Table) lookup(prefix string) *Entry {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	i := slices.IndexFunc(t.entries, func(e *Entry) bool { return e.Prefix == prefix })
+	if i < 0 {
+		return nil
+	}
+	return t.entries[i]
+}
+
+func (t *Table) openEntry(ctx context.Context, m Mount) (*Entry, error) {
+	backend, closeFn, err := t.open(ctx, m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open %s for %s: %v", ErrInvalidMount, m.Target, m.Prefix, err)
+	}
+	return &Entry{Mount: m, Storage: backend, close: closeFn, now: t.now}, nil
+}
+
+// release closes the backend of a mount that was removed
+func (e *Entry) release() {
+	if e.close != nil {
+		e.close()
+	}
+}
+
+// MemoryStore keeps mounts in memory, so the mounts added at runtime are lost
+// on restart and only served by the replica they were added through
+type MemoryStore struct {
+	mu     sync.Mutex
+	mounts map[string]Mount
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{mounts: make(map[string]Mount)}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, mount Mount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.mounts[mount.Prefix]; ok {
+		return fmt.Errorf("%w: %s", ErrExists, mount.Prefix)
+	}
+	s.mounts[mount.Prefix] = mount
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]Mount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mounts := make([]Mount, 0, len(s.mounts))
+	for _, mount := range s.mounts {
+		mounts = append(mounts, mount)
+	}
+	slices.SortFunc(mounts, func(a, b Mount) int { return strings.Compare(a.Prefix, b.Prefix) })
+	return mounts, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.mounts[prefix]; !ok {
+		return ErrNotFound
+	}
+	delete(s.mounts, prefix)
+	return nil
+}
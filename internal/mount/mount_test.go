@@ -0,0 +1,174 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// openDirs opens file:// targets as directories and counts the closed backends
+func openDirs(closed *int) Opener {
+	return func(ctx context.Context, m Mount) (storage.Storage, func() error, error) {
+		_, dir, err := ParseTarget(m.Target)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !strings.HasPrefix(m.Target, "file://") {
+			return nil, nil, errors.New("only directories are supported")
+		}
+		return storage.NewFSStorage(dir), func() error { *closed++; return nil }, nil
+	}
+}
+
+func TestMount_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mount   Mount
+		wantErr bool
+	}{
+		{name: "bucket", mount: Mount{Prefix: "videos/", Target: "gs://videos"}},
+		{name: "directory", mount: Mount{Prefix: "tmp/", Target: "file:///var/tmp"}},
+		{name: "no prefix", mount: Mount{Target: "gs://videos"}, wantErr: true},
+		{name: "leading slash", mount: Mount{Prefix: "/videos/", Target: "gs://videos"}, wantErr: true},
+		{name: "negative quota", mount: Mount{Prefix: "videos/", Target: "gs://videos", QuotaBytes: -1}, wantErr: true},
+		{name: "bucket with a path", mount: Mount{Prefix: "videos/", Target: "gs://videos/sub"}, wantErr: true},
+		{name: "relative directory", mount: Mount{Prefix: "tmp/", Target: "file://tmp"}, wantErr: true},
+		{name: "other scheme", mount: Mount{Prefix: "tmp/", Target: "s3://bucket"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.mount.Validate()
+			if tt.wantErr != (err != nil) {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidMount) {
+				t.Errorf("Expected ErrInvalidMount, got %v", err)
+			}
+		})
+	}
+}
+
+func TestTable(t *testing.T) {
+	ctx := context.Background()
+	var closed int
+	table := NewTable(openDirs(&closed))
+
+	if err := table.AddStatic(ctx, Mount{Prefix: "media/", Target: "file://" + t.TempDir()}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	created, err := table.Create(ctx, Mount{Prefix: "/media/clips/", Target: "file://" + t.TempDir()}, "ops")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if created.Prefix != "media/clips/" || created.CreatedBy != "ops" || created.CreatedAt.IsZero() {
+		t.Errorf("Expected the mount to be created by ops without the leading slash, got %+v", created)
+	}
+	if _, err := table.Create(ctx, Mount{Prefix: "media/clips/", Target: "file:///tmp"}, ""); !errors.Is(err, ErrExists) {
+		t.Errorf("Expected ErrExists, got %v", err)
+	}
+	if _, err := table.Create(ctx, Mount{Prefix: "cold/", Target: "gs://cold"}, ""); !errors.Is(err, ErrInvalidMount) {
+		t.Errorf("Expected ErrInvalidMount for a backend that fails to open, got %v", err)
+	}
+
+	for path, want := range map[string]string{"media/a.jpg": "media/", "media/clips/1.mp4": "media/clips/", "docs/a.pdf": ""} {
+		got := ""
+		if entry := table.Resolve(path); entry != nil {
+			got = entry.Prefix
+		}
+		if got != want {
+			t.Errorf("Expected %s to resolve to %q, got %q", path, want, got)
+		}
+	}
+
+	// Another replica removes the mount from the shared store
+	store := NewMemoryStore()
+	if err := table.Persist(ctx, store); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mounts := table.List(); len(mounts) != 1 || !mounts[0].Static {
+		t.Errorf("Expected only the static mount after loading an empty store, got %+v", mounts)
+	}
+	if closed != 1 {
+		t.Errorf("Expected the removed mount's backend to be closed, got %d closed", closed)
+	}
+	if err := store.Create(ctx, Mount{Prefix: "tmp/", Target: "file://" + t.TempDir()}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := table.Refresh(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if entry := table.Resolve("tmp/a"); entry == nil || entry.Prefix != "tmp/" {
+		t.Errorf("Expected the stored mount to be loaded, got %+v", entry)
+	}
+
+	if err := table.Delete(ctx, "media/"); !errors.Is(err, ErrStatic) {
+		t.Errorf("Expected ErrStatic, got %v", err)
+	}
+	if err := table.Delete(ctx, "tmp/"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := table.Delete(ctx, "tmp/"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if mounts, _ := store.List(ctx); len(mounts) != 0 {
+		t.Errorf("Expected the mount to be deleted from the store, got %+v", mounts)
+	}
+}
+
+func TestEntry_Authorize(t *testing.T) {
+	entry := &Entry{Mount: Mount{Prefix: "finance/", Scope: "finance", ReadOnly: true}}
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		write   bool
+		wantErr error
+	}{
+		{name: "no principal", ctx: context.Background(), wantErr: ErrForbidden},
+		{name: "proxy itself", ctx: auth.WithSystem(context.Background())},
+		{name: "granted scope", ctx: auth.WithScopes(auth.WithPrincipal(context.Background(), "acme"), []string{"finance"})},
+		{name: "missing scope", ctx: auth.WithPrincipal(context.Background(), "acme"), wantErr: ErrForbidden},
+		{name: "write", ctx: auth.WithSystem(context.Background()), write: true, wantErr: ErrReadOnly},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := entry.Authorize(tt.ctx, tt.write)
+			if tt.wantErr == nil && err != nil || !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestEntry_Reserve(t *testing.T) {
+	ctx := context.Background()
+	backend := storage.NewFSStorage(t.TempDir())
+	if _, err := backend.CreateFile(ctx, storage.WriteRequest{Path: "logs/a", Content: strings.NewReader("12345")}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	now := time.Now()
+	entry := &Entry{Mount: Mount{Prefix: "logs/", QuotaBytes: 8}, Storage: backend, now: func() time.Time { return now }}
+
+	if err := entry.Reserve(ctx); err != nil {
+		t.Fatalf("Expected room for 3 more bytes, got %v", err)
+	}
+	entry.Record(3)
+	if err := entry.Reserve(ctx); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded once the writes fill the quota, got %v", err)
+	}
+
+	// The usage is listed again once it is stale
+	if err := backend.DeleteFile(ctx, "logs/a"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	now = now.Add(usageTTL)
+	if err := entry.Reserve(ctx); err != nil {
+		t.Errorf("Expected room after the file was deleted, got %v", err)
+	}
+}
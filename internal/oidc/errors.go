@@ -0,0 +1,11 @@
+package oidc
+
+import "errors"
+
+var (
+	ErrInvalidState   = errors.New("login state is missing, expired or doesn't match")
+	ErrInvalidToken   = errors.New("invalid ID token")
+	ErrNotAllowed     = errors.New("identity isn't granted a role")
+	ErrInvalidSession = errors.New("invalid session")
+	ErrCrossSite      = errors.New("cross-site requests can't use the session cookie")
+)
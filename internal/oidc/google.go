@@ -0,0 +1,107 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudidentity/v1"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/api/option"
+)
+
+// googleIssuers are the iss claims of Google ID tokens
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// GoogleProvider logs users in with their Google account, like a Google
+// Workspace identity
+type GoogleProvider struct {
+	oauth        *oauth2.Config
+	hostedDomain string
+	validator    *idtoken.Validator
+}
+
+// NewGoogleProvider creates a provider for the OAuth client clientID, asking
+// for accounts of hostedDomain when it is set
+func NewGoogleProvider(ctx context.Context, clientID, clientSecret, redirectURL, hostedDomain string) (*GoogleProvider, error) {
+	validator, err := idtoken.NewValidator(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &GoogleProvider{
+		oauth: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     google.Endpoint,
+			Scopes:       []string{"openid", "email"},
+		},
+		hostedDomain: hostedDomain,
+		validator:    validator,
+	}, nil
+}
+
+func (p *GoogleProvider) AuthCodeURL(state, nonce string) string {
+	opts := []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("nonce", nonce), oauth2.SetAuthURLParam("prompt", "select_account")}
+	if p.hostedDomain != "" {
+		// hd only preselects the domain; the claim is checked after login
+		opts = append(opts, oauth2.SetAuthURLParam("hd", p.hostedDomain))
+	}
+	return p.oauth.AuthCodeURL(state, opts...)
+}
+
+func (p *GoogleProvider) Exchange(ctx context.Context, code string) (*Claims, error) {
+	token, err := p.oauth.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	raw, _ := token.Extra("id_token").(string)
+	if raw == "" {
+		return nil, fmt.Errorf("%w: the provider returned no ID token", ErrInvalidToken)
+	}
+	payload, err := p.validator.Validate(ctx, raw, p.oauth.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if payload.Issuer != googleIssuers[0] && payload.Issuer != googleIssuers[1] {
+		return nil, fmt.Errorf("%w: not issued by Google", ErrInvalidToken)
+	}
+	claims := &Claims{}
+	claims.Email, _ = payload.Claims["email"].(string)
+	claims.EmailVerified, _ = payload.Claims["email_verified"].(bool)
+	claims.HostedDomain, _ = payload.Claims["hd"].(string)
+	claims.Nonce, _ = payload.Claims["nonce"].(string)
+	return claims, nil
+}
+
+// CloudIdentityGroups looks up the Google groups of users, nested groups
+// included, with the Cloud Identity API
+type CloudIdentityGroups struct {
+	service *cloudidentity.Service
+}
+
+// NewCloudIdentityGroups creates a lookup whose credentials may read the
+// memberships of the groups roles name
+func NewCloudIdentityGroups(ctx context.Context, opts ...option.ClientOption) (*CloudIdentityGroups, error) {
+	service, err := cloudidentity.NewService(ctx, append(opts, option.WithScopes(cloudidentity.CloudIdentityGroupsReadonlyScope))...)
+	if err != nil {
+		return nil, err
+	}
+	return &CloudIdentityGroups{service: service}, nil
+}
+
+func (g *CloudIdentityGroups) GroupsOf(ctx context.Context, email string) ([]string, error) {
+	query := fmt.Sprintf("member_key_id == '%s' && 'cloudidentity.googleapis.com/groups.discussion_forum' in labels", strings.ReplaceAll(email, "'", ""))
+	var groups []string
+	err := g.service.Groups.Memberships.SearchTransitiveGroups("groups/-").Query(query).Pages(ctx, func(page *cloudidentity.SearchTransitiveGroupsResponse) error {
+		for _, membership := range page.Memberships {
+			if membership.GroupKey != nil {
+				groups = append(groups, strings.ToLower(membership.GroupKey.Id))
+			}
+		}
+		return nil
+	})
+	return groups, err
+}
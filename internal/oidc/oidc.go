@@ -0,0 +1,365 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+)
+
+// SessionCookie holds the signed session of a logged-in user
+const SessionCookie = "gpm_session"
+
+// stateCookie holds the state and nonce of a login in progress
+const stateCookie = "gpm_oidc_state"
+
+// stateTTL is how long a login may take at the identity provider
+const stateTTL = 10 * time.Minute
+
+// Purposes a value is signed for, so a state can't be passed off as a session
+const (
+	purposeState   = "state"
+	purposeSession = "session"
+)
+
+// Claims are what a verified ID token asserts about the user
+type Claims struct {
+	Email         string
+	EmailVerified bool
+	// HostedDomain is the Google Workspace domain of the account, "" for consumer accounts
+	HostedDomain string
+	Nonce        string
+}
+
+// Provider is the OpenID Connect identity provider users log in with
+type Provider interface {
+	// AuthCodeURL is where users are sent to log in
+	AuthCodeURL(state, nonce string) string
+	// Exchange trades the authorization code of the callback for the claims
+	// of a verified ID token
+	Exchange(ctx context.Context, code string) (*Claims, error)
+}
+
+// Groups looks up the groups a user belongs to, directly or not
+type Groups interface {
+	GroupsOf(ctx context.Context, email string) ([]string, error)
+}
+
+// Role grants scopes to the users among its members
+type Role struct {
+	// Members are emails like alice@example.com, domain:example.com for every
+	// account of a domain or group:admins@example.com for a group's members
+	Members []string
+	Scopes  []string
+}
+
+// Config is how users log in and how long their sessions last
+type Config struct {
+	// RedirectURL is the callback registered with the provider, like
+	// https://storage.example.com/auth/callback; its origin is the only one
+	// allowed to send requests with the session cookie
+	RedirectURL string
+	// HostedDomain restricts logins to the accounts of a Google Workspace domain
+	HostedDomain string
+	// SessionKey signs the session cookies, shared by every replica
+	SessionKey []byte
+	SessionTTL time.Duration
+}
+
+// Session is a logged-in user
+type Session struct {
+	Email   string    `json:"email"`
+	Scopes  []string  `json:"scopes,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// state is a login in progress
+type state struct {
+	State   string    `json:"state"`
+	Nonce   string    `json:"nonce"`
+	Next    string    `json:"next,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// Login logs users in with an identity provider and keeps them logged in with
+// a signed session cookie, mapping their groups to the scopes of roles. Users
+// without a role can't log in.
+type Login struct {
+	provider Provider
+	// groups is nil when no role has group members
+	groups Groups
+	cfg    Config
+	origin string
+	secure bool
+	now    func() time.Time
+
+	mu    sync.RWMutex
+	roles []Role
+}
+
+// New creates a login flow with provider, looking up the groups of users with groups
+func New(provider Provider, groups Groups, cfg Config) (*Login, error) {
+	u, err := url.Parse(cfg.RedirectURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("redirect URL must be absolute, got %q", cfg.RedirectURL)
+	}
+	return &Login{
+		provider: provider,
+		groups:   groups,
+		cfg:      cfg,
+		origin:   u.Scheme + "://" + u.Host,
+		secure:   u.Scheme == "https",
+		now:      time.Now,
+	}, nil
+}
+
+// SetRoles replaces the roles at runtime. Sessions keep the scopes they were
+// granted until they expire.
+func (l *Login) SetRoles(roles []Role) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.roles = roles
+}
+
+// Start sends the user to the provider to log in, and back to the relative
+// URL in next afterwards
+func (l *Login) Start(w http.ResponseWriter, r *http.Request) {
+	st := state{State: randomToken(), Nonce: randomToken(), Next: safeNext(r.URL.Query().Get("next")), Expires: l.now().Add(stateTTL)}
+	value, err := l.sign(purposeState, st)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, l.cookie(stateCookie, value, stateTTL))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, l.provider.AuthCodeURL(st.State, st.Nonce), http.StatusFound)
+}
+
+// Callback completes the login the provider redirected back from, sets the
+// session cookie and sends the user on
+func (l *Login) Callback(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, l.cookie(stateCookie, "", -1))
+	w.Header().Set("Cache-Control", "no-store")
+	if reason := r.URL.Query().Get("error"); reason != "" {
+		http.Error(w, "Unauthorized: login failed: "+reason, http.StatusUnauthorized)
+		return
+	}
+	var st state
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil || l.verify(purposeState, cookie.Value, &st) != nil || l.now().After(st.Expires) ||
+		!hmac.Equal([]byte(st.State), []byte(r.URL.Query().Get("state"))) {
+		http.Error(w, "Unauthorized: "+ErrInvalidState.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	session, err := l.login(r.Context(), r.URL.Query().Get("code"), st.Nonce)
+	if err != nil {
+		status := http.StatusUnauthorized
+		if errors.Is(err, ErrNotAllowed) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, http.StatusText(status)+": "+err.Error(), status)
+		return
+	}
+	value, err := l.sign(purposeSession, session)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, l.cookie(SessionCookie, value, l.cfg.SessionTTL))
+	next := st.Next
+	if next == "" {
+		next = "/"
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// Logout clears the session cookie
+func (l *Login) Logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, l.cookie(SessionCookie, "", -1))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Current returns the session the request carries, if any
+func (l *Login) Current(r *http.Request) (*Session, bool) {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return nil, false
+	}
+	var session Session
+	if l.verify(purposeSession, cookie.Value, &session) != nil || session.Email == "" || !l.now().Before(session.Expires) {
+		return nil, false
+	}
+	return &session, true
+}
+
+// Authenticate returns the principal and scopes of the session a request
+// carries, for auth.APIKeyAuthenticator.AcceptSessions. Requests that change
+// something must come from the origin of the redirect URL, so other sites
+// can't make them with the user's cookie.
+func (l *Login) Authenticate(r *http.Request) (auth.Key, bool, error) {
+	session, ok := l.Current(r)
+	if !ok {
+		return auth.Key{}, false, nil
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+		if origin := r.Header.Get("Origin"); origin != "" && origin != l.origin || r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+			return auth.Key{}, false, ErrCrossSite
+		}
+	}
+	return auth.Key{Name: session.Email, Scopes: session.Scopes}, true, nil
+}
+
+// login verifies the ID token the code is exchanged for and maps the user to roles
+func (l *Login) login(ctx context.Context, code, nonce string) (*Session, error) {
+	if code == "" {
+		return nil, fmt.Errorf("%w: no authorization code", ErrInvalidToken)
+	}
+	claims, err := l.provider.Exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(claims.Nonce), []byte(nonce)) {
+		return nil, fmt.Errorf("%w: nonce doesn't match", ErrInvalidToken)
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, fmt.Errorf("%w: no verified email", ErrInvalidToken)
+	}
+	if l.cfg.HostedDomain != "" && !strings.EqualFold(claims.HostedDomain, l.cfg.HostedDomain) {
+		return nil, fmt.Errorf("%w: not an account of %s", ErrInvalidToken, l.cfg.HostedDomain)
+	}
+
+	email := strings.ToLower(claims.Email)
+	scopes, err := l.scopes(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{Email: email, Scopes: scopes, Expires: l.now().Add(l.cfg.SessionTTL).UTC().Truncate(time.Second)}, nil
+}
+
+// scopes returns the scopes of the roles email is a member of, or
+// ErrNotAllowed if it is in none
+func (l *Login) scopes(ctx context.Context, email string) ([]string, error) {
+	l.mu.RLock()
+	roles := l.roles
+	l.mu.RUnlock()
+
+	var groups []string
+	if l.groups != nil && slices.ContainsFunc(roles, func(role Role) bool {
+		return slices.ContainsFunc(role.Members, func(m string) bool { return strings.HasPrefix(m, "group:") })
+	}) {
+		var err error
+		if groups, err = l.groups.GroupsOf(ctx, email); err != nil {
+			return nil, fmt.Errorf("failed to look up the groups of %s: %w", email, err)
+		}
+	}
+
+	scopes := []string{}
+	granted := false
+	for _, role := range roles {
+		if !member(email, groups, role.Members) {
+			continue
+		}
+		granted = true
+		for _, scope := range role.Scopes {
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	if !granted {
+		return nil, ErrNotAllowed
+	}
+	return scopes, nil
+}
+
+func member(email string, groups []string, members []string) bool {
+	for _, m := range members {
+		m = strings.ToLower(m)
+		if group, ok := strings.CutPrefix(m, "group:"); ok {
+			if slices.ContainsFunc(groups, func(g string) bool { return strings.EqualFold(g, group) }) {
+				return true
+			}
+		} else if domain, ok := strings.CutPrefix(m, "domain:"); ok && strings.HasSuffix(email, "@"+domain) || m == email {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Login) cookie(name, value string, ttl time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   l.secure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(ttl / time.Second),
+	}
+	if ttl < 0 {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// sign encodes v as base64url JSON followed by its HMAC-SHA256, which also
+// covers the purpose it is signed for
+func (l *Login) sign(purpose string, v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + l.mac(purpose, payload), nil
+}
+
+func (l *Login) verify(purpose, value string, v any) error {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(l.mac(purpose, payload))) {
+		return ErrInvalidSession
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return ErrInvalidSession
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrInvalidSession
+	}
+	return nil
+}
+
+func (l *Login) mac(purpose, payload string) string {
+	mac := hmac.New(sha256.New, l.cfg.SessionKey)
+	mac.Write([]byte(purpose))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// safeNext keeps next only if it is a path on this site, so logins can't
+// redirect elsewhere
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return ""
+	}
+	return next
+}
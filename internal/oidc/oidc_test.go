@@ -0,0 +1,214 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeProvider issues the claims of code after a login, with the nonce of the last login started
+type fakeProvider struct {
+	claims map[string]Claims
+	nonce  string
+}
+
+func (p *fakeProvider) AuthCodeURL(state, nonce string) string {
+	p.nonce = nonce
+	return "https://accounts.example.com/auth?state=" + url.QueryEscape(state)
+}
+
+func (p *fakeProvider) Exchange(ctx context.Context, code string) (*Claims, error) {
+	claims, ok := p.claims[code]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	claims.Nonce = p.nonce
+	return &claims, nil
+}
+
+type fakeGroups map[string][]string
+
+func (g fakeGroups) GroupsOf(ctx context.Context, email string) ([]string, error) {
+	return g[email], nil
+}
+
+func newLogin(t *testing.T) *Login {
+	provider := &fakeProvider{claims: map[string]Claims{
+		"alice":    {Email: "Alice@example.com", EmailVerified: true, HostedDomain: "example.com"},
+		"bob":      {Email: "bob@example.com", EmailVerified: true, HostedDomain: "example.com"},
+		"carol":    {Email: "carol@example.com", EmailVerified: true, HostedDomain: "example.com"},
+		"consumer": {Email: "dave@gmail.com", EmailVerified: true},
+	}}
+	login, err := New(provider, fakeGroups{"alice@example.com": {"Storage-Admins@example.com"}}, Config{
+		RedirectURL:  "https://storage.example.com/auth/callback",
+		HostedDomain: "example.com",
+		SessionKey:   []byte("0123456789abcdef0123456789abcdef"),
+		SessionTTL:   time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	login.SetRoles([]Role{
+		{Members: []string{"group:storage-admins@example.com"}, Scopes: []string{"admin"}},
+		{Members: []string{"alice@example.com", "bob@example.com"}, Scopes: []string{"finance"}},
+	})
+	return login
+}
+
+// logIn goes through the login flow for code and returns the response of the callback
+func logIn(login *Login, code, next string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	login.Start(w, httptest.NewRequest(http.MethodGet, "/auth/login?next="+url.QueryEscape(next), nil))
+	location, _ := url.Parse(w.Header().Get("Location"))
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/callback?code="+code+"&state="+url.QueryEscape(location.Query().Get("state")), nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	login.Callback(w, r)
+	return w
+}
+
+func TestLogin_Callback(t *testing.T) {
+	login := newLogin(t)
+
+	tests := []struct {
+		name       string
+		code       string
+		next       string
+		wantStatus int
+		wantNext   string
+		wantScopes []string
+	}{
+		{name: "group role", code: "alice", next: "/ui/reports/", wantStatus: http.StatusFound, wantNext: "/ui/reports/", wantScopes: []string{"admin", "finance"}},
+		{name: "member role", code: "bob", wantStatus: http.StatusFound, wantNext: "/", wantScopes: []string{"finance"}},
+		{name: "open redirect", code: "bob", next: "//evil.example.net/", wantStatus: http.StatusFound, wantNext: "/", wantScopes: []string{"finance"}},
+		{name: "no role", code: "carol", wantStatus: http.StatusForbidden},
+		{name: "other domain", code: "consumer", wantStatus: http.StatusUnauthorized},
+		{name: "invalid code", code: "mallory", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := logIn(login, tt.code, tt.next)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusFound {
+				return
+			}
+			if location := w.Header().Get("Location"); location != tt.wantNext {
+				t.Errorf("Expected a redirect to %s, got %s", tt.wantNext, location)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/storage/list", nil)
+			for _, cookie := range w.Result().Cookies() {
+				r.AddCookie(cookie)
+			}
+			key, ok, err := login.Authenticate(r)
+			if err != nil || !ok {
+				t.Fatalf("Expected the session cookie to authenticate, got %v", err)
+			}
+			if !slices.Equal(key.Scopes, tt.wantScopes) {
+				t.Errorf("Expected scopes %v, got %v", tt.wantScopes, key.Scopes)
+			}
+		})
+	}
+}
+
+func TestLogin_Callback_State(t *testing.T) {
+	login := newLogin(t)
+	w := httptest.NewRecorder()
+	login.Start(w, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+
+	// A callback without the state cookie of the browser that started the login
+	r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=alice&state=guessed", nil)
+	w = httptest.NewRecorder()
+	login.Callback(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestLogin_Authenticate(t *testing.T) {
+	login := newLogin(t)
+	cookies := logIn(login, "bob", "").Result().Cookies()
+
+	tests := []struct {
+		name    string
+		method  string
+		origin  string
+		expired bool
+		wantOK  bool
+		wantErr error
+	}{
+		{name: "download", method: http.MethodGet, origin: "https://evil.example.net", wantOK: true},
+		{name: "same origin upload", method: http.MethodPut, origin: "https://storage.example.com", wantOK: true},
+		{name: "cross-site upload", method: http.MethodPut, origin: "https://evil.example.net", wantErr: ErrCrossSite},
+		{name: "expired", method: http.MethodGet, expired: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login.now = time.Now
+			if tt.expired {
+				login.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+			}
+			r := httptest.NewRequest(tt.method, "/api/v1/storage/files/a.txt", nil)
+			r.Header.Set("Origin", tt.origin)
+			for _, cookie := range cookies {
+				r.AddCookie(cookie)
+			}
+			key, ok, err := login.Authenticate(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if ok != tt.wantOK || ok && key.Name != "bob@example.com" {
+				t.Errorf("Expected authenticated %v as bob@example.com, got %v as %q", tt.wantOK, ok, key.Name)
+			}
+		})
+	}
+}
+
+func TestLogin_Authenticate_Forged(t *testing.T) {
+	login := newLogin(t)
+	w := httptest.NewRecorder()
+	login.Start(w, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+	var state string
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == stateCookie {
+			state = cookie.Value
+		}
+	}
+	if state == "" {
+		t.Fatal("Expected a state cookie")
+	}
+	anonymous, err := login.sign(purposeSession, Session{Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "state replayed as session", value: state},
+		{name: "session without email", value: anonymous},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/storage/files/a.txt", nil)
+			r.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.value})
+			if _, ok, err := login.Authenticate(r); ok || err != nil {
+				t.Errorf("Expected the session to be refused, got authenticated %v, error %v", ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package openapi
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Operation describes one method of a route. Path uses OpenAPI templates,
+// e.g. /api/v1/storage/files/{path}.
+type Operation struct {
+	ID          string
+	Method      string
+	Path        string
+	Tag         string
+	Summary     string
+	Description string
+	Params      []Param
+	Request     *Body
+	Responses   []Response
+	// Errors lists the error statuses the operation answers with; 429 and 500
+	// are added to every operation and 401 to those that are not public
+	Errors []int
+	// Public operations need no credentials
+	Public bool
+}
+
+// Param is a path, query or header parameter
+type Param struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	// Type is a value of the parameter's Go type, e.g. "" or 0
+	Type any
+}
+
+// PathParam describes a required string path parameter
+func PathParam(name, description string) Param {
+	return Param{Name: name, In: "path", Description: description, Required: true, Type: ""}
+}
+
+// QueryParam describes an optional query parameter of typ's type
+func QueryParam(name, description string, typ any) Param {
+	return Param{Name: name, In: "query", Description: description, Type: typ}
+}
+
+// HeaderParam describes an optional string request header
+func HeaderParam(name, description string) Param {
+	return Param{Name: name, In: "header", Description: description, Type: ""}
+}
+
+// Body is a request or response body
+type Body struct {
+	ContentType string
+	Description string
+	// Schema is a value of the body's Go type; nil for binary content
+	Schema any
+}
+
+// JSONBody describes a JSON request body of v's type
+func JSONBody(v any) *Body {
+	return &Body{ContentType: "application/json", Schema: v}
+}
+
+// BinaryBody describes raw content of any media type
+func BinaryBody(description string) *Body {
+	return &Body{ContentType: "*/*", Description: description}
+}
+
+// MultipartBody describes a form whose file fields are named after the object paths
+func MultipartBody(description string) *Body {
+	return &Body{ContentType: "multipart/form-data", Description: description}
+}
+
+// Response is a successful response
+type Response struct {
+	Status      int
+	Description string
+	// Body is nil for responses without content
+	Body *Body
+}
+
+// JSONResponse describes a 200 response with a JSON body of v's type
+func JSONResponse(description string, v any) Response {
+	return Response{Status: http.StatusOK, Description: description, Body: JSONBody(v)}
+}
+
+// BinaryResponse describes a 200 response carrying object content
+func BinaryResponse(description string) Response {
+	return Response{Status: http.StatusOK, Description: description, Body: &Body{ContentType: "*/*"}}
+}
+
+// Spec collects the operations of the API and renders them as an OpenAPI 3 document
+type Spec struct {
+	title   string
+	version string
+
+	mu  sync.Mutex
+	ops []Operation
+}
+
+// New creates an empty spec
+func New(title, version string) *Spec {
+	return &Spec{title: title, version: version}
+}
+
+// Add records operations
+func (s *Spec) Add(ops ...Operation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops = append(s.ops, ops...)
+}
+
+// Operations returns the recorded operations
+func (s *Spec) Operations() []Operation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Operation(nil), s.ops...)
+}
+
+// Document builds the OpenAPI document
+func (s *Spec) Document() map[string]any {
+	g := newGenerator()
+	paths := map[string]map[string]any{}
+	var tags []map[string]any
+	seenTags := map[string]bool{}
+
+	for _, op := range s.Operations() {
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]any{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = g.operation(op)
+		if op.Tag != "" && !seenTags[op.Tag] {
+			seenTags[op.Tag] = true
+			tags = append(tags, map[string]any{"name": op.Tag})
+		}
+	}
+
+	g.schemas["Error"] = map[string]any{
+		"type":        "string",
+		"description": "Plain text error message",
+	}
+	g.schemas["ErrorResponse"] = map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"error":      map[string]any{"type": "string"},
+			"request_id": map[string]any{"type": "string", "description": "Also returned in the X-Request-ID header"},
+		},
+	}
+	g.schemas["ValidationError"] = map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"error":      map[string]any{"type": "string"},
+			"request_id": map[string]any{"type": "string", "description": "Also returned in the X-Request-ID header"},
+			"violations": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"field":   map[string]any{"type": "string", "description": "JSON path of the field, like files[0].path"},
+						"message": map[string]any{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]any{"title": s.title, "version": s.version},
+		"tags":    tags,
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey":     map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		"security": []map[string][]string{{"bearerAuth": {}}, {"apiKey": {}}},
+	}
+}
+
+// ServeHTTP serves the document as JSON
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s.Document())
+}
+
+func (g *generator) operation(op Operation) map[string]any {
+	out := map[string]any{}
+	if op.ID != "" {
+		out["operationId"] = op.ID
+	}
+	if op.Tag != "" {
+		out["tags"] = []string{op.Tag}
+	}
+	if op.Summary != "" {
+		out["summary"] = op.Summary
+	}
+	if op.Description != "" {
+		out["description"] = op.Description
+	}
+
+	if len(op.Params) > 0 {
+		params := make([]map[string]any, 0, len(op.Params))
+		for _, p := range op.Params {
+			param := map[string]any{
+				"name":   p.Name,
+				"in":     p.In,
+				"schema": g.schemaOf(p.Type),
+			}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+			if p.Required {
+				param["required"] = true
+			}
+			params = append(params, param)
+		}
+		out["parameters"] = params
+	}
+
+	if op.Request != nil {
+		body := map[string]any{
+			"required": true,
+			"content":  g.content(op.Request),
+		}
+		if op.Request.Description != "" {
+			body["description"] = op.Request.Description
+		}
+		out["requestBody"] = body
+	}
+
+	// Responses with the same status but different media types share an entry
+	responses := map[string]any{}
+	for _, resp := range op.Responses {
+		status := strconv.Itoa(resp.Status)
+		response, ok := responses[status].(map[string]any)
+		if !ok {
+			response = map[string]any{"description": resp.Description}
+			responses[status] = response
+		}
+		if resp.Body == nil {
+			continue
+		}
+		content, ok := response["content"].(map[string]any)
+		if !ok {
+			content = map[string]any{}
+			response["content"] = content
+		}
+		maps.Copy(content, g.content(resp.Body))
+	}
+	errors := append([]int(nil), op.Errors...)
+	if op.Public {
+		out["security"] = []map[string][]string{}
+	} else {
+		errors = append(errors, http.StatusUnauthorized)
+	}
+	errors = append(errors, http.StatusTooManyRequests)
+	for _, status := range errors {
+		content := map[string]any{
+			"text/plain": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+		}
+		// Request bodies failing validation are answered with the violated constraints
+		if status == http.StatusBadRequest && op.Request != nil && op.Request.Schema != nil {
+			content["application/json"] = map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/ValidationError"}}
+		}
+		responses[strconv.Itoa(status)] = map[string]any{
+			"description": http.StatusText(status),
+			"content":     content,
+		}
+	}
+	// Panicking handlers are answered in the JSON envelope by the recovery middleware
+	responses[strconv.Itoa(http.StatusInternalServerError)] = map[string]any{
+		"description": http.StatusText(http.StatusInternalServerError),
+		"content": map[string]any{
+			"text/plain":       map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+			"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/ErrorResponse"}},
+		},
+	}
+	out["responses"] = responses
+	return out
+}
+
+func (g *generator) content(b *Body) map[string]any {
+	var schema map[string]any
+	switch {
+	case b.Schema != nil:
+		schema = g.schemaOf(b.Schema)
+	case b.ContentType == "multipart/form-data":
+		schema = map[string]any{
+			"type":                 "object",
+			"additionalProperties": map[string]any{"type": "string", "format": "binary"},
+		}
+	default:
+		schema = map[string]any{"type": "string", "format": "binary"}
+	}
+	return map[string]any{b.ContentType: map[string]any{"schema": schema}}
+}
@@ -0,0 +1,141 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type inner struct {
+	Count int64 `json:"count"`
+}
+
+type sample struct {
+	Name     string            `json:"name"`
+	Tags     []string          `json:"tags,omitempty"`
+	Labels   map[string]string `json:"labels"`
+	Created  time.Time         `json:"created"`
+	Content  []byte
+	Inner    *inner `json:"inner,omitempty"`
+	Skipped  string `json:"-"`
+	internal string
+	inner
+}
+
+func TestSchema(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want map[string]any
+	}{
+		{name: "string", v: "", want: map[string]any{"type": "string"}},
+		{name: "float", v: 0.0, want: map[string]any{"type": "number"}},
+		{name: "slice", v: []int{}, want: map[string]any{"type": "array", "items": map[string]any{"type": "integer", "format": "int32"}}},
+		{name: "named struct", v: sample{}, want: map[string]any{"$ref": "#/components/schemas/Sample"}},
+		{
+			name: "anonymous struct",
+			v:    struct{ OK bool }{},
+			want: map[string]any{"type": "object", "properties": map[string]any{"OK": map[string]any{"type": "boolean"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newGenerator().schemaOf(tt.v); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSchema_Struct(t *testing.T) {
+	g := newGenerator()
+	g.schemaOf(sample{})
+
+	want := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"name":    map[string]any{"type": "string"},
+			"tags":    map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"labels":  map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}},
+			"created": map[string]any{"type": "string", "format": "date-time"},
+			"Content": map[string]any{"type": "string", "format": "byte"},
+			"inner":   map[string]any{"$ref": "#/components/schemas/Inner"},
+			"count":   map[string]any{"type": "integer", "format": "int64"},
+		},
+	}
+	if got := g.schemas["Sample"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if _, ok := g.schemas["Inner"]; !ok {
+		t.Error("Expected the referenced Inner schema to be generated")
+	}
+}
+
+func TestSpec_Document(t *testing.T) {
+	spec := New("proxy", "1.0")
+	spec.Add(Operation{
+		ID:        "readFile",
+		Method:    http.MethodGet,
+		Path:      "/files/{path}",
+		Params:    []Param{PathParam("path", "Object path")},
+		Responses: []Response{BinaryResponse("Content")},
+		Errors:    []int{http.StatusNotFound},
+	}, Operation{
+		ID:        "health",
+		Method:    http.MethodGet,
+		Path:      "/healthz",
+		Responses: []Response{JSONResponse("Status", sample{})},
+		Public:    true,
+	})
+
+	rec := httptest.NewRecorder()
+	spec.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			OperationID string                     `json:"operationId"`
+			Security    []map[string][]string      `json:"security"`
+			Responses   map[string]json.RawMessage `json:"responses"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("Expected OpenAPI 3.0.3, got %q", doc.OpenAPI)
+	}
+	read := doc.Paths["/files/{path}"]["get"]
+	if read.OperationID != "readFile" {
+		t.Errorf("Expected operation readFile, got %q", read.OperationID)
+	}
+	for _, status := range []string{"200", "401", "404", "429", "500"} {
+		if _, ok := read.Responses[status]; !ok {
+			t.Errorf("Expected a %s response for readFile", status)
+		}
+	}
+
+	health := doc.Paths["/healthz"]["get"]
+	if health.Security == nil || len(health.Security) != 0 {
+		t.Errorf("Expected public operation to clear security, got %v", health.Security)
+	}
+	if _, ok := health.Responses["401"]; ok {
+		t.Error("Expected no 401 response for a public operation")
+	}
+	for _, name := range []string{"Error", "ErrorResponse", "ValidationError", "Sample", "Inner"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("Expected schema %s", name)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package openapi
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// swaggerVersion is the swagger-ui-dist major version loaded from the CDN
+const swaggerVersion = "5"
+
+var swaggerPage = template.Must(template.New("swagger").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@{{.Version}}/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@{{.Version}}/swagger-ui-bundle.js" crossorigin></script>
+<script>
+window.onload = () => {
+  window.ui = SwaggerUIBundle({url: {{.SpecURL}}, dom_id: "#swagger-ui"});
+};
+</script>
+</body>
+</html>
+`))
+
+// SwaggerUI serves a Swagger UI page for the document at specURL. The UI
+// itself is loaded from unpkg.com.
+func (s *Spec) SwaggerUI(specURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		swaggerPage.Execute(w, struct {
+			Title, Version, SpecURL string
+		}{s.title, swaggerVersion, specURL})
+	})
+}
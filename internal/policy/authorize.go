@@ -0,0 +1,41 @@
+package policy
+
+import (
+	"context"
+	"slices"
+)
+
+type checksKey struct{}
+
+// check decides whether the request of ctx may take action on the object at path
+type check func(ctx context.Context, action, path string) error
+
+// withCheck adds a check for Authorize to run. Checks skip the object the
+// middleware adding them decided on already.
+func withCheck(ctx context.Context, target Target, c check) context.Context {
+	checks, _ := ctx.Value(checksKey{}).([]check)
+	decided := func(ctx context.Context, action, path string) error {
+		if !target.InBody && action == target.Action && path == target.Path {
+			return nil
+		}
+		return c(ctx, action, path)
+	}
+	return context.WithValue(ctx, checksKey{}, append(slices.Clip(checks), decided))
+}
+
+// Authorize checks that the request of ctx may take action on each object at
+// paths, for the objects a request names in its body, which the middleware
+// can't see. It returns an error wrapping ErrForbidden for the first object
+// denied, or ErrAuthorizerUnavailable, and nil for contexts no middleware
+// added checks to, like those of jobs and requests outside the storage API.
+func Authorize(ctx context.Context, action string, paths ...string) error {
+	checks, _ := ctx.Value(checksKey{}).([]check)
+	for _, path := range paths {
+		for _, c := range checks {
+			if err := c(ctx, action, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package policy
+
+import "errors"
+
+var (
+	ErrInvalidPolicy         = errors.New("invalid policy")
+	ErrForbidden             = errors.New("forbidden")
+	ErrAuthorizerUnavailable = errors.New("the authorizer is unavailable")
+)
@@ -0,0 +1,372 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/middleware"
+)
+
+// Actions rules allow or deny
+const (
+	ActionRead   = "read"
+	ActionWrite  = "write"
+	ActionDelete = "delete"
+	ActionList   = "list"
+)
+
+// Effects of rules
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Anonymous is the principal of requests that aren't authenticated
+const Anonymous = "anonymous"
+
+// Rule allows or denies principals actions on the paths matching its globs
+type Rule struct {
+	// Name identifies the rule in decisions; unnamed rules are rules[i]
+	Name string `json:"name,omitempty" yaml:"name"`
+	// Principals are API key names or identities, * for anyone, anonymous,
+	// scope:NAME for the keys granting a scope or domain:example.com for the
+	// identities of a domain
+	Principals []string `json:"principals" yaml:"principals"`
+	// Actions are read, write, delete, list or * for all of them
+	Actions []string `json:"actions" yaml:"actions"`
+	// Paths are globs where * and ? match within a path segment and **
+	// matches across segments, like reports/**/*.pdf
+	Paths  []string `json:"paths" yaml:"paths"`
+	Effect string   `json:"effect" yaml:"effect"`
+}
+
+// Request is what a request does, for evaluating the rules
+type Request struct {
+	Principal string   `json:"principal"`
+	Scopes    []string `json:"scopes,omitempty"`
+	Action    string   `json:"action"`
+	// Path is the object path or listing prefix, "" for requests that may
+	// touch any object, which every deny rule for the principal and action
+	// denies
+	Path string `json:"path"`
+}
+
+// Target is what a request does to objects
+type Target struct {
+	Action string
+	// Path is the object path or listing prefix named in the URL, "" for
+	// requests that may touch any object
+	Path string
+	// InBody is set for requests naming their objects in the body, like batch
+	// reads, which are authorized for each object by Authorize once it is parsed
+	InBody bool
+}
+
+// Decision is whether the rules allow a request, and why
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// Rule names the deciding rule, "" when no rule matched
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason"`
+	// Matched names every rule matching the request, in order
+	Matched []string `json:"matched,omitempty"`
+}
+
+// Policy is the rules in effect and where they were loaded from
+type Policy struct {
+	Source   string    `json:"source"`
+	Rules    []Rule    `json:"rules"`
+	LoadedAt time.Time `json:"loaded_at,omitzero"`
+}
+
+// document is the format of policy objects
+type document struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+type compiledRule struct {
+	Rule
+	name  string
+	paths []*regexp.Regexp
+}
+
+// Engine evaluates the rules for every request to objects. Deny rules
+// override allow rules, and requests no rule allows are denied.
+type Engine struct {
+	// access returns what a request does to objects, ok is false for
+	// requests that don't touch objects
+	access func(*http.Request) (target Target, ok bool)
+	now    func() time.Time
+
+	mu        sync.RWMutex
+	policy    Policy
+	rules     []compiledRule
+	protected string
+}
+
+// New creates an engine without rules, which denies every request to objects
+func New(access func(*http.Request) (target Target, ok bool)) *Engine {
+	return &Engine{
+		access: access,
+		now:    time.Now,
+	}
+}
+
+// Protect reserves every action on path, where the policy object is, to
+// keys granting the admin scope, so the rules can't be rewritten by them
+func (e *Engine) Protect(path string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.protected = strings.TrimPrefix(path, "/")
+}
+
+// SetRules replaces the rules at runtime, keeping the previous ones when
+// any is invalid
+func (e *Engine) SetRules(source string, rules []Rule) error {
+	compiled, err := compile(rules)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.policy = Policy{Source: source, Rules: rules, LoadedAt: e.now().UTC().Truncate(time.Second)}
+	e.rules = compiled
+	return nil
+}
+
+// Policy returns the rules in effect
+func (e *Engine) Policy() Policy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.policy
+}
+
+// Refresh loads the rules of the policy object read by load
+func (e *Engine) Refresh(ctx context.Context, source string, load func(context.Context) ([]byte, error)) error {
+	data, err := load(ctx)
+	if err != nil {
+		return err
+	}
+	rules, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	return e.SetRules(source, rules)
+}
+
+// Run refreshes the rules from the policy object every interval until ctx is
+// done. Rules that fail to load are logged and the previous ones kept.
+func (e *Engine) Run(ctx context.Context, source string, load func(context.Context) ([]byte, error), interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Refresh(ctx, source, load); err != nil {
+				log.Printf("Failed to refresh the policy from %s, keeping the previous rules: %v", source, err)
+			}
+		}
+	}
+}
+
+// Middleware answers requests to objects the rules don't allow with 403
+// Forbidden, and has Authorize check the objects named in their body. It must
+// run after authentication.
+func (e *Engine) Middleware(next http.Handler) http.Handler {
+	return e.MiddlewareFor(e.access)(next)
+}
+
+// MiddlewareFor is Middleware for another API, whose requests access maps to
+// the objects they touch, like the S3 API
+func (e *Engine) MiddlewareFor(access func(*http.Request) (target Target, ok bool)) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			target, ok := access(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r = r.WithContext(withCheck(r.Context(), target, e.Check))
+			if target.InBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			decision := e.Evaluate(Request{
+				Principal: auth.PrincipalFromContext(r.Context()),
+				Scopes:    auth.ScopesFromContext(r.Context()),
+				Action:    target.Action,
+				Path:      target.Path,
+			})
+			if !decision.Allowed {
+				http.Error(w, "Forbidden: "+decision.Reason, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Check returns an error wrapping ErrForbidden unless the rules allow the
+// caller of ctx to take action on the object at path, for operations outside
+// HTTP, like those of SFTP sessions
+func (e *Engine) Check(ctx context.Context, action, path string) error {
+	decision := e.Evaluate(Request{
+		Principal: auth.PrincipalFromContext(ctx),
+		Scopes:    auth.ScopesFromContext(ctx),
+		Action:    action,
+		Path:      path,
+	})
+	if !decision.Allowed {
+		return fmt.Errorf("%w: %s", ErrForbidden, decision.Reason)
+	}
+	return nil
+}
+
+// Evaluate decides whether the rules allow req
+func (e *Engine) Evaluate(req Request) Decision {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if req.Principal == "" {
+		req.Principal = Anonymous
+	}
+	req.Path = strings.TrimPrefix(req.Path, "/")
+	if e.protected != "" && req.Path == e.protected {
+		if slices.Contains(req.Scopes, auth.ScopeAdmin) {
+			return Decision{Allowed: true, Reason: fmt.Sprintf("/%s is the policy object, which the admin scope may %s", req.Path, req.Action)}
+		}
+		return Decision{Reason: fmt.Sprintf("/%s is the policy object, which requires the admin scope", req.Path)}
+	}
+
+	var decision Decision
+	for _, rule := range e.rules {
+		if !rule.matches(req) {
+			continue
+		}
+		decision.Matched = append(decision.Matched, rule.name)
+		if rule.Effect == EffectDeny && (decision.Rule == "" || decision.Allowed) {
+			decision.Allowed, decision.Rule = false, rule.name
+		} else if rule.Effect == EffectAllow && decision.Rule == "" {
+			decision.Allowed, decision.Rule = true, rule.name
+		}
+	}
+	switch {
+	case decision.Rule == "":
+		decision.Reason = fmt.Sprintf("no rule allows %s to %s /%s", req.Principal, req.Action, req.Path)
+	case decision.Allowed:
+		decision.Reason = fmt.Sprintf("rule %s allows %s to %s /%s", decision.Rule, req.Principal, req.Action, req.Path)
+	default:
+		decision.Reason = fmt.Sprintf("rule %s denies %s to %s /%s", decision.Rule, req.Principal, req.Action, req.Path)
+	}
+	return decision
+}
+
+// Parse reads the rules of a policy object, a YAML or JSON document like
+// {"rules": [{"principals": ["*"], "actions": ["read"], "paths": ["public/**"], "effect": "allow"}]}
+func Parse(data []byte) ([]Rule, error) {
+	var doc document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	if _, err := compile(doc.Rules); err != nil {
+		return nil, err
+	}
+	return doc.Rules, nil
+}
+
+// Validate reports the first invalid rule
+func Validate(rules []Rule) error {
+	_, err := compile(rules)
+	return err
+}
+
+func compile(rules []Rule) ([]compiledRule, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rules[%d]", i)
+		}
+		if len(rule.Principals) == 0 || len(rule.Actions) == 0 || len(rule.Paths) == 0 {
+			return nil, fmt.Errorf("%w: %s requires principals, actions and paths", ErrInvalidPolicy, name)
+		}
+		if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			return nil, fmt.Errorf("%w: %s has effect %q, expected allow or deny", ErrInvalidPolicy, name, rule.Effect)
+		}
+		for _, action := range rule.Actions {
+			switch action {
+			case ActionRead, ActionWrite, ActionDelete, ActionList, "*":
+			default:
+				return nil, fmt.Errorf("%w: %s has action %q, expected read, write, delete, list or *", ErrInvalidPolicy, name, action)
+			}
+		}
+		c := compiledRule{Rule: rule, name: name}
+		for _, glob := range rule.Paths {
+			c.paths = append(c.paths, globRegexp(glob))
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func (r compiledRule) matches(req Request) bool {
+	if !slices.Contains(r.Actions, req.Action) && !slices.Contains(r.Actions, "*") {
+		return false
+	}
+	if !slices.ContainsFunc(r.Principals, func(p string) bool { return principalMatches(p, req) }) {
+		return false
+	}
+	// A request that may touch any object may touch those the rule denies
+	if req.Path == "" && r.Effect == EffectDeny {
+		return true
+	}
+	return slices.ContainsFunc(r.paths, func(glob *regexp.Regexp) bool { return glob.MatchString(req.Path) })
+}
+
+func principalMatches(pattern string, req Request) bool {
+	if scope, ok := strings.CutPrefix(pattern, "scope:"); ok {
+		return slices.Contains(req.Scopes, scope)
+	}
+	if domain, ok := strings.CutPrefix(pattern, "domain:"); ok {
+		return strings.HasSuffix(strings.ToLower(req.Principal), "@"+strings.ToLower(domain))
+	}
+	return pattern == "*" || pattern == req.Principal
+}
+
+// globRegexp compiles a path glob: ** matches across segments, also none
+// when followed by a slash, * and ? match within a segment
+func globRegexp(glob string) *regexp.Regexp {
+	glob = strings.TrimPrefix(glob, "/")
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
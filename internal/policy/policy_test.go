@@ -0,0 +1,151 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"gcp-proxy-mity/internal/auth"
+)
+
+func TestEngine_Evaluate(t *testing.T) {
+	engine := New(nil)
+	err := engine.SetRules("config", []Rule{
+		{Name: "public", Principals: []string{"*"}, Actions: []string{ActionRead, ActionList}, Paths: []string{"public/**"}, Effect: EffectAllow},
+		{Name: "reports", Principals: []string{"scope:finance", "domain:example.com"}, Actions: []string{"*"}, Paths: []string{"reports/**/*.pdf"}, Effect: EffectAllow},
+		{Principals: []string{"*"}, Actions: []string{ActionDelete}, Paths: []string{"reports/archive/**"}, Effect: EffectDeny},
+		{Name: "ci", Principals: []string{"ci"}, Actions: []string{ActionWrite}, Paths: []string{"builds/*"}, Effect: EffectAllow},
+		{Name: "ops", Principals: []string{"ops"}, Actions: []string{"*"}, Paths: []string{"**"}, Effect: EffectAllow},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	engine.Protect("_policy.yaml")
+
+	tests := []struct {
+		name    string
+		req     Request
+		allowed bool
+		rule    string
+	}{
+		{name: "anonymous read", req: Request{Action: ActionRead, Path: "public/logo.png"}, allowed: true, rule: "public"},
+		{name: "anonymous write", req: Request{Action: ActionWrite, Path: "public/logo.png"}},
+		{name: "scope", req: Request{Principal: "acme", Scopes: []string{"finance"}, Action: ActionWrite, Path: "reports/q3.pdf"}, allowed: true, rule: "reports"},
+		{name: "nested", req: Request{Principal: "alice@Example.com", Action: ActionRead, Path: "/reports/2026/q3.pdf"}, allowed: true, rule: "reports"},
+		{name: "other extension", req: Request{Principal: "alice@example.com", Action: ActionRead, Path: "reports/q3.xlsx"}},
+		{name: "deny overrides allow", req: Request{Principal: "alice@example.com", Action: ActionDelete, Path: "reports/archive/q1.pdf"}, rule: "rules[2]"},
+		{name: "single segment", req: Request{Principal: "ci", Action: ActionWrite, Path: "builds/1/app.zip"}},
+		{name: "policy object", req: Request{Principal: "ci", Action: ActionWrite, Path: "_policy.yaml"}},
+		{name: "any object", req: Request{Principal: "ops", Action: ActionRead}, allowed: true, rule: "ops"},
+		{name: "any object with a deny rule", req: Request{Principal: "ops", Action: ActionDelete}, rule: "rules[2]"},
+		{name: "policy object as admin", req: Request{Principal: "ops", Scopes: []string{auth.ScopeAdmin}, Action: ActionWrite, Path: "_policy.yaml"}, allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := engine.Evaluate(tt.req)
+			if decision.Allowed != tt.allowed || decision.Rule != tt.rule {
+				t.Errorf("Expected allowed %v by %q, got %+v", tt.allowed, tt.rule, decision)
+			}
+			if decision.Reason == "" {
+				t.Error("Expected a reason")
+			}
+		})
+	}
+
+	if decision := engine.Evaluate(Request{Principal: "bob@example.com", Action: ActionDelete, Path: "reports/archive/q1.pdf"}); !slices.Equal(decision.Matched, []string{"reports", "rules[2]"}) {
+		t.Errorf("Expected both matching rules to be reported, got %v", decision.Matched)
+	}
+}
+
+func TestEngine_Middleware(t *testing.T) {
+	engine := New(func(r *http.Request) (Target, bool) {
+		path, ok := strings.CutPrefix(r.URL.Path, "/files/")
+		if r.Method == http.MethodPut {
+			return Target{Action: ActionWrite, Path: path}, ok
+		}
+		return Target{Action: ActionRead, Path: path}, ok
+	})
+	if err := engine.SetRules("config", []Rule{{Principals: []string{"ci"}, Actions: []string{ActionRead}, Paths: []string{"**"}, Effect: EffectAllow}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h := engine.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		principal  string
+		wantStatus int
+	}{
+		{name: "allowed", method: http.MethodGet, path: "/files/a.txt", principal: "ci", wantStatus: http.StatusOK},
+		{name: "denied action", method: http.MethodPut, path: "/files/a.txt", principal: "ci", wantStatus: http.StatusForbidden},
+		{name: "denied principal", method: http.MethodGet, path: "/files/a.txt", principal: "acme", wantStatus: http.StatusForbidden},
+		{name: "not an object", method: http.MethodGet, path: "/healthz", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.principal != "" {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), tt.principal))
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	engine := New(func(r *http.Request) (Target, bool) {
+		return Target{Action: ActionRead, InBody: true}, true
+	})
+	if err := engine.SetRules("config", []Rule{
+		{Principals: []string{"ci"}, Actions: []string{ActionRead}, Paths: []string{"**"}, Effect: EffectAllow},
+		{Principals: []string{"ci"}, Actions: []string{ActionRead}, Paths: []string{"secret/**"}, Effect: EffectDeny},
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var errs []error
+	h := engine.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errs = append(errs, Authorize(r.Context(), ActionRead, "public/a.txt"), Authorize(r.Context(), ActionRead, "public/a.txt", "secret/x.txt"))
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/files/read", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r.WithContext(auth.WithPrincipal(r.Context(), "ci")))
+
+	if len(errs) != 2 || errs[0] != nil || !errors.Is(errs[1], ErrForbidden) {
+		t.Errorf("Expected public/a.txt to be allowed and secret/x.txt forbidden, got %v", errs)
+	}
+	if err := Authorize(context.Background(), ActionRead, "secret/x.txt"); err != nil {
+		t.Errorf("Expected no checks outside requests, got %v", err)
+	}
+}
+
+func TestEngine_Refresh(t *testing.T) {
+	engine := New(nil)
+	object := []byte("rules:\n  - principals: [\"*\"]\n    actions: [read]\n    paths: [\"**\"]\n    effect: allow\n")
+	load := func(ctx context.Context) ([]byte, error) { return object, nil }
+
+	if err := engine.Refresh(context.Background(), "gs://bucket/_policy.yaml", load); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if policy := engine.Policy(); policy.Source != "gs://bucket/_policy.yaml" || len(policy.Rules) != 1 {
+		t.Errorf("Expected 1 rule from the object, got %+v", policy)
+	}
+
+	// Invalid rules keep the previous ones
+	object = []byte(`{"rules": [{"principals": ["*"], "actions": ["read"], "paths": ["**"], "effect": "permit"}]}`)
+	if err := engine.Refresh(context.Background(), "gs://bucket/_policy.yaml", load); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("Expected ErrInvalidPolicy, got %v", err)
+	}
+	if !engine.Evaluate(Request{Action: ActionRead, Path: "a.txt"}).Allowed {
+		t.Error("Expected the previous rules to be kept")
+	}
+}
@@ -0,0 +1,202 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/cache"
+	"gcp-proxy-mity/internal/middleware"
+)
+
+// decisionCacheBytes caps the decisions the webhook remembers
+const decisionCacheBytes = 4 << 20
+
+// WebhookConfig delegates decisions to an external HTTP authorizer
+type WebhookConfig struct {
+	URL string
+	// Secret signs each request with X-Signature, the hex HMAC-SHA256 of the body
+	Secret  string
+	Timeout time.Duration
+	// CacheTTL is how long decisions are reused for the same principal,
+	// scopes, action and path; zero asks the authorizer every time
+	CacheTTL time.Duration
+	// FailOpen allows requests when the authorizer can't be reached or
+	// answers with an error, instead of refusing them
+	FailOpen bool
+}
+
+// WebhookResponse is what the authorizer answers with
+type WebhookResponse struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Webhook asks an external HTTP service whether to allow each request to
+// objects. The service receives the Request as JSON and answers with a
+// WebhookResponse.
+type Webhook struct {
+	access    func(*http.Request) (target Target, ok bool)
+	cfg       WebhookConfig
+	client    *http.Client
+	decisions *cache.LRU
+}
+
+// NewWebhook creates an authorizer calling the service at cfg.URL
+func NewWebhook(access func(*http.Request) (target Target, ok bool), cfg WebhookConfig) *Webhook {
+	return &Webhook{
+		access:    access,
+		cfg:       cfg,
+		client:    &http.Client{Timeout: cfg.Timeout},
+		decisions: cache.NewLRU(decisionCacheBytes, cfg.CacheTTL),
+	}
+}
+
+// Middleware answers requests to objects the authorizer denies with 403
+// Forbidden, and those it couldn't decide with 503 Service Unavailable unless
+// it fails open. Objects named in the body are left to Authorize, which asks
+// about each of them. It must run after authentication.
+func (h *Webhook) Middleware(next http.Handler) http.Handler {
+	return h.MiddlewareFor(h.access)(next)
+}
+
+// MiddlewareFor is Middleware for another API, whose requests access maps to
+// the objects they touch, like the S3 API
+func (h *Webhook) MiddlewareFor(access func(*http.Request) (target Target, ok bool)) middleware.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			target, ok := access(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r = r.WithContext(withCheck(r.Context(), target, h.Check))
+			if target.InBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			decision, err := h.Authorize(r.Context(), Request{
+				Principal: auth.PrincipalFromContext(r.Context()),
+				Scopes:    auth.ScopesFromContext(r.Context()),
+				Action:    target.Action,
+				Path:      target.Path,
+			})
+			if err != nil && !h.cfg.FailOpen {
+				log.Printf("Refusing %s %s, the authorizer failed: %v", r.Method, r.URL.Path, err)
+				http.Error(w, "Service Unavailable: the authorizer is unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			if err != nil {
+				log.Printf("Allowing %s %s, the authorizer failed: %v", r.Method, r.URL.Path, err)
+			} else if !decision.Allowed {
+				http.Error(w, "Forbidden: "+decision.Reason, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Check asks the authorizer whether the caller of ctx may take action on the
+// object at path, for operations outside HTTP, like those of SFTP sessions. It
+// returns an error wrapping ErrForbidden when denied, or
+// ErrAuthorizerUnavailable when undecided unless it fails open.
+func (h *Webhook) Check(ctx context.Context, action, path string) error {
+	decision, err := h.Authorize(ctx, Request{
+		Principal: auth.PrincipalFromContext(ctx),
+		Scopes:    auth.ScopesFromContext(ctx),
+		Action:    action,
+		Path:      path,
+	})
+	if err != nil && !h.cfg.FailOpen {
+		log.Printf("Refusing to %s /%s, the authorizer failed: %v", action, path, err)
+		return fmt.Errorf("%w: %v", ErrAuthorizerUnavailable, err)
+	}
+	if err != nil {
+		log.Printf("Allowing to %s /%s, the authorizer failed: %v", action, path, err)
+	} else if !decision.Allowed {
+		return fmt.Errorf("%w: %s", ErrForbidden, decision.Reason)
+	}
+	return nil
+}
+
+// Authorize asks the authorizer whether to allow req, or returns its cached
+// decision. Failed calls aren't cached.
+func (h *Webhook) Authorize(ctx context.Context, req Request) (Decision, error) {
+	if req.Principal == "" {
+		req.Principal = Anonymous
+	}
+	req.Path = strings.TrimPrefix(req.Path, "/")
+	key := strings.Join([]string{req.Principal, strings.Join(req.Scopes, ","), req.Action, req.Path}, "\x00")
+	if h.cfg.CacheTTL > 0 {
+		if cached, ok := h.decisions.Get(ctx, key); ok {
+			var decision Decision
+			if json.Unmarshal(cached, &decision) == nil {
+				return decision, nil
+			}
+		}
+	}
+
+	answer, err := h.call(ctx, req)
+	if err != nil {
+		return Decision{}, err
+	}
+	decision := Decision{Allowed: answer.Allowed, Reason: answer.Reason}
+	if decision.Reason == "" && decision.Allowed {
+		decision.Reason = fmt.Sprintf("the authorizer allows %s to %s /%s", req.Principal, req.Action, req.Path)
+	} else if decision.Reason == "" {
+		decision.Reason = fmt.Sprintf("the authorizer denies %s to %s /%s", req.Principal, req.Action, req.Path)
+	}
+	if h.cfg.CacheTTL > 0 {
+		if encoded, err := json.Marshal(decision); err == nil {
+			h.decisions.Set(ctx, key, encoded)
+		}
+	}
+	return decision, nil
+}
+
+func (h *Webhook) call(ctx context.Context, req Request) (*WebhookResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the authorization request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if id := middleware.RequestIDFrom(ctx); id != "" {
+		httpReq.Header.Set("X-Request-ID", id)
+	}
+	if h.cfg.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.cfg.Secret))
+		mac.Write(body)
+		httpReq.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("authorizer returned status %d", resp.StatusCode)
+	}
+	var answer WebhookResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("failed to decode the authorizer's answer: %w", err)
+	}
+	return &answer, nil
+}
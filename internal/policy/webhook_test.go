@@ -0,0 +1,158 @@
+package policy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+)
+
+func TestWebhook_Authorize(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		if r.Header.Get("X-Signature") != hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req Request
+		json.Unmarshal(body, &req)
+		json.NewEncoder(w).Encode(WebhookResponse{Allowed: req.Principal == "ci" && strings.HasPrefix(req.Path, "builds/"), Reason: "builds are for ci"})
+	}))
+	defer server.Close()
+	webhook := NewWebhook(nil, WebhookConfig{URL: server.URL, Secret: "secret", Timeout: time.Second, CacheTTL: time.Minute})
+
+	tests := []struct {
+		name    string
+		req     Request
+		allowed bool
+	}{
+		{name: "allowed", req: Request{Principal: "ci", Action: ActionWrite, Path: "/builds/1.zip"}, allowed: true},
+		{name: "denied", req: Request{Principal: "acme", Action: ActionWrite, Path: "builds/1.zip"}},
+		{name: "anonymous", req: Request{Action: ActionRead, Path: "builds/1.zip"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := webhook.Authorize(context.Background(), tt.req)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if decision.Allowed != tt.allowed || decision.Reason != "builds are for ci" {
+				t.Errorf("Expected allowed %v with the authorizer's reason, got %+v", tt.allowed, decision)
+			}
+		})
+	}
+
+	// Decisions are reused while they are cached
+	webhook.Authorize(context.Background(), Request{Principal: "ci", Action: ActionWrite, Path: "builds/1.zip"})
+	if calls != len(tests) {
+		t.Errorf("Expected %d calls to the authorizer, got %d", len(tests), calls)
+	}
+}
+
+func TestWebhook_Middleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	access := func(r *http.Request) (Target, bool) {
+		path, ok := strings.CutPrefix(r.URL.Path, "/files/")
+		return Target{Action: ActionRead, Path: path}, ok
+	}
+
+	tests := []struct {
+		name       string
+		failOpen   bool
+		path       string
+		wantStatus int
+	}{
+		{name: "fail closed", path: "/files/a.txt", wantStatus: http.StatusServiceUnavailable},
+		{name: "fail open", failOpen: true, path: "/files/a.txt", wantStatus: http.StatusOK},
+		{name: "not an object", path: "/healthz", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook := NewWebhook(access, WebhookConfig{URL: server.URL, Timeout: time.Second, FailOpen: tt.failOpen})
+			h := webhook.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r = r.WithContext(auth.WithPrincipal(r.Context(), "ci"))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestWebhook_BodyPaths(t *testing.T) {
+	var asked []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		json.NewDecoder(r.Body).Decode(&req)
+		asked = append(asked, req.Path)
+		json.NewEncoder(w).Encode(WebhookResponse{Allowed: strings.HasPrefix(req.Path, "public/")})
+	}))
+	defer server.Close()
+	access := func(r *http.Request) (Target, bool) {
+		return Target{Action: ActionRead, InBody: true}, true
+	}
+	webhook := NewWebhook(access, WebhookConfig{URL: server.URL, Timeout: time.Second})
+
+	var err error
+	h := webhook.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err = Authorize(r.Context(), ActionRead, "public/a.txt", "secret/x.txt")
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/files/read", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r.WithContext(auth.WithPrincipal(r.Context(), "ci")))
+
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for secret/x.txt, got %v", err)
+	}
+	if want := []string{"public/a.txt", "secret/x.txt"}; !slices.Equal(asked, want) {
+		t.Errorf("Expected the authorizer to be asked about %v, got %v", want, asked)
+	}
+}
+
+func TestWebhook_Check(t *testing.T) {
+	var asked Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&asked)
+		json.NewEncoder(w).Encode(WebhookResponse{Allowed: asked.Principal == "acme" && strings.HasPrefix(asked.Path, "partners/acme/")})
+	}))
+	defer server.Close()
+	webhook := NewWebhook(nil, WebhookConfig{URL: server.URL, Timeout: time.Second})
+	ctx := auth.WithPrincipal(context.Background(), "acme")
+
+	if err := webhook.Check(ctx, ActionWrite, "partners/acme/in/a.mp4"); err != nil {
+		t.Errorf("Expected the upload to be allowed, got %v", err)
+	}
+	if err := webhook.Check(ctx, ActionRead, "partners/globex/in/a.mp4"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden, got %v", err)
+	}
+	if want := (Request{Principal: "acme", Action: ActionRead, Path: "partners/globex/in/a.mp4"}); !reflect.DeepEqual(asked, want) {
+		t.Errorf("Expected the authorizer to be asked about %+v, got %+v", want, asked)
+	}
+
+	unavailable := NewWebhook(nil, WebhookConfig{URL: "http://127.0.0.1:1", Timeout: time.Second})
+	if err := unavailable.Check(ctx, ActionRead, "partners/acme/a.mp4"); !errors.Is(err, ErrAuthorizerUnavailable) {
+		t.Errorf("Expected ErrAuthorizerUnavailable, got %v", err)
+	}
+}
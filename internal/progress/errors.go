@@ -0,0 +1,9 @@
+package progress
+
+import "errors"
+
+var (
+	ErrNotFound  = errors.New("transfer not found")
+	ErrInvalidID = errors.New("transfer ID must be 1 to 64 letters, digits, '-', '_' or '.'")
+	ErrIDInUse   = errors.New("transfer ID is in use")
+)
@@ -0,0 +1,287 @@
+package progress
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Header carries the ID of an upload's transfer. Clients may choose it to poll
+// the upload while it runs; it is returned with the response either way.
+const Header = "X-Transfer-ID"
+
+// Transfer states
+const (
+	StateReceiving = "receiving"
+	// StateWriting is when the whole body was received and storage is
+	// finishing the object
+	StateWriting   = "writing"
+	StateCompleted = "completed"
+	StateFailed    = "failed"
+)
+
+// maxErrorBytes caps the error message kept from the response of a failed upload
+const maxErrorBytes = 512
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Transfer is the progress of an upload
+type Transfer struct {
+	ID        string `json:"id"`
+	Path      string `json:"path"`
+	Principal string `json:"principal,omitempty"`
+	State     string `json:"state"`
+	// BytesReceived is how much of the request body was read
+	BytesReceived int64 `json:"bytes_received"`
+	// BytesWritten is how much storage accepted, which lags behind while it buffers
+	BytesWritten int64 `json:"bytes_written"`
+	// TotalBytes is the Content-Length of the upload, -1 when it is sent chunked
+	TotalBytes int64 `json:"total_bytes"`
+	// Status is the HTTP status the upload was answered with
+	Status   int        `json:"status,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Updated  time.Time  `json:"updated"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Config decides which uploads are tracked and for how long
+type Config struct {
+	// MinBytes is the Content-Length from which uploads are tracked without
+	// asking for it with Header; uploads of unknown length always are
+	MinBytes int64
+	// TTL is how long finished transfers can still be looked up
+	TTL time.Duration
+}
+
+// Tracker keeps the progress of uploads in memory, so clients can poll it for
+// uploads that report nothing until they are done. Transfers are only known
+// to the replica serving the upload.
+type Tracker struct {
+	uploads func(*http.Request) (path string, ok bool)
+	cfg     Config
+	now     func() time.Time
+
+	mu        sync.Mutex
+	transfers map[string]*Transfer
+}
+
+// New creates a tracker. uploads reports whether a request uploads an object
+// with its body, and its path.
+func New(uploads func(*http.Request) (path string, ok bool), cfg Config) *Tracker {
+	return &Tracker{
+		uploads:   uploads,
+		cfg:       cfg,
+		now:       time.Now,
+		transfers: make(map[string]*Transfer),
+	}
+}
+
+// Middleware tracks the uploads that name a transfer ID in Header, are sent
+// chunked or are at least MinBytes long, and returns their ID in Header. It
+// must run after authentication, so transfers belong to their principal.
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := t.uploads(r)
+		id := r.Header.Get(Header)
+		if !ok || id == "" && r.ContentLength >= 0 && r.ContentLength < t.cfg.MinBytes {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		transfer, err := t.start(id, path, auth.PrincipalFromContext(r.Context()), r.ContentLength)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrIDInUse) {
+				status = http.StatusConflict
+			}
+			http.Error(w, http.StatusText(status)+": "+err.Error(), status)
+			return
+		}
+		w.Header().Set(Header, transfer.ID)
+		r.Body = &body{ReadCloser: r.Body, tracker: t, transfer: transfer}
+		ctx := storage.WithProgress(r.Context(), func(written int64) {
+			t.update(transfer, func(tr *Transfer) { tr.BytesWritten = written })
+		})
+
+		rec := &recorder{ResponseWriter: w}
+		served := false
+		// Deferred so uploads that panic are marked failed as well
+		defer func() { t.finish(transfer, rec, served) }()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		served = true
+	})
+}
+
+// Get returns the transfer with id
+func (t *Tracker) Get(id string) (*Transfer, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.evictExpired()
+
+	transfer, ok := t.transfers[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *transfer
+	return &c, nil
+}
+
+// List returns every transfer, newest first
+func (t *Tracker) List() []Transfer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.evictExpired()
+
+	transfers := make([]Transfer, 0, len(t.transfers))
+	for _, transfer := range t.transfers {
+		transfers = append(transfers, *transfer)
+	}
+	slices.SortFunc(transfers, func(a, b Transfer) int {
+		return cmp.Or(b.Started.Compare(a.Started), strings.Compare(a.ID, b.ID))
+	})
+	return transfers
+}
+
+// start registers a transfer under id, or a new ID if it is empty. A finished
+// transfer's ID may be reused by its principal.
+func (t *Tracker) start(id, path, principal string, total int64) (*Transfer, error) {
+	if id != "" && !validID.MatchString(id) {
+		return nil, ErrInvalidID
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.evictExpired()
+
+	if id == "" {
+		id = newTransferID()
+	}
+	if existing, ok := t.transfers[id]; ok && (existing.Finished == nil || existing.Principal != principal) {
+		return nil, ErrIDInUse
+	}
+	now := t.now().UTC()
+	transfer := &Transfer{
+		ID:         id,
+		Path:       path,
+		Principal:  principal,
+		State:      StateReceiving,
+		TotalBytes: total,
+		Started:    now,
+		Updated:    now,
+	}
+	t.transfers[id] = transfer
+	return transfer, nil
+}
+
+func (t *Tracker) update(transfer *Transfer, fn func(*Transfer)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if transfer.Finished != nil {
+		return
+	}
+	fn(transfer)
+	transfer.Updated = t.now().UTC()
+}
+
+// finish records how the upload was answered
+func (t *Tracker) finish(transfer *Transfer, rec *recorder, served bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now().UTC()
+	transfer.Updated = now
+	transfer.Finished = &now
+	transfer.Status = rec.status
+	if transfer.Status == 0 {
+		transfer.Status = http.StatusOK
+	}
+	switch {
+	case !served:
+		transfer.State = StateFailed
+		transfer.Status = http.StatusInternalServerError
+		transfer.Error = http.StatusText(http.StatusInternalServerError)
+	case transfer.Status >= http.StatusBadRequest:
+		transfer.State = StateFailed
+		transfer.Error = strings.TrimSpace(string(rec.body))
+		if transfer.Error == "" {
+			transfer.Error = http.StatusText(transfer.Status)
+		}
+	default:
+		transfer.State = StateCompleted
+	}
+}
+
+// evictExpired drops transfers finished more than TTL ago; callers hold the lock
+func (t *Tracker) evictExpired() {
+	cutoff := t.now().Add(-t.cfg.TTL)
+	for id, transfer := range t.transfers {
+		if transfer.Finished != nil && transfer.Finished.Before(cutoff) {
+			delete(t.transfers, id)
+		}
+	}
+}
+
+func newTransferID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// body counts the bytes of the request body read by the handler
+type body struct {
+	io.ReadCloser
+	tracker  *Tracker
+	transfer *Transfer
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.tracker.update(b.transfer, func(tr *Transfer) {
+		tr.BytesReceived += int64(n)
+		if err == io.EOF {
+			tr.State = StateWriting
+		}
+	})
+	return n, err
+}
+
+// recorder captures the status of the response and the start of error messages
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.status >= http.StatusBadRequest && len(r.body) < maxErrorBytes {
+		r.body = append(r.body, p[:min(len(p), maxErrorBytes-len(r.body))]...)
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,145 @@
+package progress
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTracker() *Tracker {
+	uploads := func(r *http.Request) (string, bool) {
+		path, ok := strings.CutPrefix(r.URL.Path, "/api/v1/storage/files/")
+		return path, ok && r.Method == http.MethodPut
+	}
+	return New(uploads, Config{MinBytes: 8, TTL: time.Minute})
+}
+
+func TestTracker_Middleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		id          string
+		fail        bool
+		wantTracked bool
+		wantState   string
+		wantError   string
+	}{
+		{name: "large upload", method: http.MethodPut, body: "0123456789", wantTracked: true, wantState: StateCompleted},
+		{name: "small upload", method: http.MethodPut, body: "0123"},
+		{name: "small upload with an ID", method: http.MethodPut, body: "0123", id: "upload-1", wantTracked: true, wantState: StateCompleted},
+		{name: "failed upload", method: http.MethodPut, body: "0123456789", fail: true, wantTracked: true, wantState: StateFailed, wantError: "Failed to write file: boom"},
+		{name: "not an upload", method: http.MethodGet, id: "upload-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newTracker()
+			var during []Transfer
+			h := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id := w.Header().Get(Header)
+				snapshot := func() {
+					if transfer, err := tracker.Get(id); err == nil {
+						during = append(during, *transfer)
+					}
+				}
+				r.Body.Read(make([]byte, 2))
+				snapshot()
+				io.Copy(io.Discard, r.Body)
+				snapshot()
+				if tt.fail {
+					http.Error(w, "Failed to write file: boom", http.StatusInternalServerError)
+				}
+			}))
+
+			r := httptest.NewRequest(tt.method, "/api/v1/storage/files/a.bin", strings.NewReader(tt.body))
+			if tt.id != "" {
+				r.Header.Set(Header, tt.id)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			id := w.Header().Get(Header)
+			if (id != "") != tt.wantTracked {
+				t.Fatalf("Expected tracked %v, got transfer ID %q", tt.wantTracked, id)
+			}
+			if !tt.wantTracked {
+				return
+			}
+			if tt.id != "" && id != tt.id {
+				t.Errorf("Expected transfer ID %s, got %s", tt.id, id)
+			}
+			if len(during) != 2 || during[0].State != StateReceiving || during[0].BytesReceived != 2 || during[1].State != StateWriting {
+				t.Errorf("Expected receiving after 2 bytes, then writing, got %+v", during)
+			}
+
+			transfer, err := tracker.Get(id)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if transfer.State != tt.wantState || transfer.Error != tt.wantError {
+				t.Errorf("Expected state %s with error %q, got %s with %q", tt.wantState, tt.wantError, transfer.State, transfer.Error)
+			}
+			if transfer.Path != "a.bin" || transfer.BytesReceived != int64(len(tt.body)) || transfer.TotalBytes != int64(len(tt.body)) {
+				t.Errorf("Expected %d of %d bytes of a.bin received, got %+v", len(tt.body), len(tt.body), transfer)
+			}
+		})
+	}
+}
+
+func TestTracker_IDs(t *testing.T) {
+	tracker := newTracker()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Block") != "" {
+			close(started)
+			<-release
+		}
+		io.Copy(io.Discard, r.Body)
+	}))
+	upload := func(id string, block bool) int {
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/storage/files/a.bin", strings.NewReader("0123456789"))
+		r.Header.Set(Header, id)
+		if block {
+			r.Header.Set("X-Block", "1")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	done := make(chan struct{})
+	go func() {
+		upload("busy", true)
+		close(done)
+	}()
+	<-started
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+	}{
+		{name: "in use", id: "busy", wantStatus: http.StatusConflict},
+		{name: "invalid", id: "../etc", wantStatus: http.StatusBadRequest},
+		{name: "new", id: "fresh", wantStatus: http.StatusOK},
+		{name: "reused after finishing", id: "fresh", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := upload(tt.id, false); status != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, status)
+			}
+		})
+	}
+	close(release)
+	<-done
+
+	tracker.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if _, err := tracker.Get("fresh"); err != ErrNotFound {
+		t.Errorf("Expected finished transfers to expire, got %v", err)
+	}
+}
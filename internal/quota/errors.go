@@ -0,0 +1,25 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrExceeded is wrapped by ExceededError
+var ErrExceeded = errors.New("upload quota exceeded")
+
+// ExceededError reports which quota a tenant used up and when it resets
+type ExceededError struct {
+	Quota string
+	Limit int64
+	Reset time.Time
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%v: %s limit of %d reached until %s", ErrExceeded, e.Quota, e.Limit, e.Reset.Format(time.RFC3339))
+}
+
+func (e *ExceededError) Unwrap() error {
+	return ErrExceeded
+}
@@ -0,0 +1,239 @@
+package quota
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Limits caps the uploads of a tenant per UTC day and month. Zero fields are unlimited.
+type Limits struct {
+	DailyBytes     int64
+	DailyObjects   int64
+	MonthlyBytes   int64
+	MonthlyObjects int64
+}
+
+func (l Limits) unlimited() bool {
+	return l == Limits{}
+}
+
+// Store keeps the upload counters of every tenant and period
+type Store interface {
+	// Add adds to the counters under key, creating them if needed, and
+	// returns the new totals. Counters may be dropped after expires.
+	Add(ctx context.Context, key string, bytes, objects int64, expires time.Time) (int64, int64, error)
+	// Get returns the counters under key, zero if there are none
+	Get(ctx context.Context, key string) (int64, int64, error)
+}
+
+// Period is a tenant's consumption in the current day or month
+type Period struct {
+	Start      time.Time `json:"start"`
+	Reset      time.Time `json:"reset"`
+	Bytes      int64     `json:"bytes"`
+	Objects    int64     `json:"objects"`
+	MaxBytes   int64     `json:"max_bytes,omitempty"`
+	MaxObjects int64     `json:"max_objects,omitempty"`
+}
+
+// Usage is a tenant's consumption against its quotas
+type Usage struct {
+	Tenant  string `json:"tenant"`
+	Daily   Period `json:"daily"`
+	Monthly Period `json:"monthly"`
+}
+
+// Tracker counts the bytes and objects each tenant uploads and enforces their quotas
+type Tracker struct {
+	store Store
+	now   func() time.Time
+
+	mu       sync.RWMutex
+	defaults Limits
+	tenants  map[string]Limits
+}
+
+// NewTracker creates a tracker counting in store
+func NewTracker(store Store) *Tracker {
+	return &Tracker{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// SetLimits changes the quotas at runtime. Tenants without their own limits get defaults.
+func (t *Tracker) SetLimits(defaults Limits, tenants map[string]Limits) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.defaults = defaults
+	t.tenants = tenants
+}
+
+func (t *Tracker) limits(tenant string) Limits {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if limits, ok := t.tenants[tenant]; ok {
+		return limits
+	}
+	return t.defaults
+}
+
+// Check returns an ExceededError if the tenant has used up a quota. The upload
+// that crosses a quota is allowed, so uploads of unknown size can be checked
+// up front. If the store fails, uploads are allowed.
+func (t *Tracker) Check(ctx context.Context, tenant string) error {
+	limits := t.limits(tenant)
+	if limits.unlimited() {
+		return nil
+	}
+	usage, err := t.usage(ctx, tenant, limits)
+	if err != nil {
+		log.Printf("Failed to read upload quota of %s: %v", tenant, err)
+		return nil
+	}
+
+	for _, check := range []struct {
+		quota  string
+		used   int64
+		limit  int64
+		period Period
+	}{
+		{"daily bytes", usage.Daily.Bytes, limits.DailyBytes, usage.Daily},
+		{"daily objects", usage.Daily.Objects, limits.DailyObjects, usage.Daily},
+		{"monthly bytes", usage.Monthly.Bytes, limits.MonthlyBytes, usage.Monthly},
+		{"monthly objects", usage.Monthly.Objects, limits.MonthlyObjects, usage.Monthly},
+	} {
+		if check.limit > 0 && check.used >= check.limit {
+			return &ExceededError{Quota: check.quota, Limit: check.limit, Reset: check.period.Reset}
+		}
+	}
+	return nil
+}
+
+// Record counts an upload against the tenant's quotas
+func (t *Tracker) Record(ctx context.Context, tenant string, bytes, objects int64) {
+	day, month := periods(t.now())
+	for _, p := range []struct {
+		key   string
+		reset time.Time
+	}{
+		{key(tenant, "day", day), day.AddDate(0, 0, 1)},
+		{key(tenant, "month", month), month.AddDate(0, 1, 0)},
+	} {
+		// Counters are kept a day past their period so late reads still see them
+		if _, _, err := t.store.Add(ctx, p.key, bytes, objects, p.reset.Add(24*time.Hour)); err != nil {
+			log.Printf("Failed to record upload for quota of %s: %v", tenant, err)
+		}
+	}
+}
+
+// Usage returns the tenant's consumption in the current day and month
+func (t *Tracker) Usage(ctx context.Context, tenant string) (*Usage, error) {
+	return t.usage(ctx, tenant, t.limits(tenant))
+}
+
+func (t *Tracker) usage(ctx context.Context, tenant string, limits Limits) (*Usage, error) {
+	day, month := periods(t.now())
+	usage := &Usage{
+		Tenant: tenant,
+		Daily: Period{
+			Start:      day,
+			Reset:      day.AddDate(0, 0, 1),
+			MaxBytes:   limits.DailyBytes,
+			MaxObjects: limits.DailyObjects,
+		},
+		Monthly: Period{
+			Start:      month,
+			Reset:      month.AddDate(0, 1, 0),
+			MaxBytes:   limits.MonthlyBytes,
+			MaxObjects: limits.MonthlyObjects,
+		},
+	}
+
+	var err error
+	usage.Daily.Bytes, usage.Daily.Objects, err = t.store.Get(ctx, key(tenant, "day", day))
+	if err != nil {
+		return nil, err
+	}
+	usage.Monthly.Bytes, usage.Monthly.Objects, err = t.store.Get(ctx, key(tenant, "month", month))
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// periods returns the start of the UTC day and month containing now
+func periods(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return day, month
+}
+
+func key(tenant, period string, start time.Time) string {
+	layout := "2006-01-02"
+	if period == "month" {
+		layout = "2006-01"
+	}
+	return tenant + ":" + period + ":" + start.Format(layout)
+}
+
+type counters struct {
+	bytes   int64
+	objects int64
+	expires time.Time
+}
+
+// MemoryStore keeps counters in memory, so they are per replica and lost on restart
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]*counters
+	swept    time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: make(map[string]*counters),
+	}
+}
+
+func (s *MemoryStore) Add(ctx context.Context, key string, bytes, objects int64, expires time.Time) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(time.Now())
+
+	c, ok := s.counters[key]
+	if !ok {
+		c = &counters{}
+		s.counters[key] = c
+	}
+	c.bytes += bytes
+	c.objects += objects
+	c.expires = expires
+	return c.bytes, c.objects, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.counters[key]; ok {
+		return c.bytes, c.objects, nil
+	}
+	return 0, 0, nil
+}
+
+// sweep drops expired counters; callers hold the lock
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Hour {
+		return
+	}
+	s.swept = now
+	for key, c := range s.counters {
+		if now.After(c.expires) {
+			delete(s.counters, key)
+		}
+	}
+}
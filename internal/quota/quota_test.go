@@ -0,0 +1,103 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTracker_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		bytes   int64
+		objects int64
+		quota   string
+	}{
+		{name: "unlimited", bytes: 1 << 30, objects: 1000},
+		{name: "within quota", limits: Limits{DailyBytes: 100, MonthlyObjects: 10}, bytes: 99, objects: 9},
+		{name: "daily bytes", limits: Limits{DailyBytes: 100}, bytes: 100, objects: 1, quota: "daily bytes"},
+		{name: "daily objects", limits: Limits{DailyObjects: 2}, bytes: 1, objects: 2, quota: "daily objects"},
+		{name: "monthly bytes", limits: Limits{MonthlyBytes: 50}, bytes: 60, objects: 1, quota: "monthly bytes"},
+		{name: "monthly objects", limits: Limits{MonthlyObjects: 1}, bytes: 1, objects: 1, quota: "monthly objects"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			tracker := NewTracker(NewMemoryStore())
+			tracker.SetLimits(tt.limits, nil)
+			tracker.Record(ctx, "tenant", tt.bytes, tt.objects)
+
+			err := tracker.Check(ctx, "tenant")
+			if tt.quota == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			var exceeded *ExceededError
+			if !errors.As(err, &exceeded) || !errors.Is(err, ErrExceeded) {
+				t.Fatalf("Expected ExceededError, got %v", err)
+			}
+			if exceeded.Quota != tt.quota {
+				t.Errorf("Expected quota %q, got %q", tt.quota, exceeded.Quota)
+			}
+		})
+	}
+}
+
+func TestTracker_Periods(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewTracker(NewMemoryStore())
+	tracker.SetLimits(Limits{DailyBytes: 100}, map[string]Limits{"big": {}})
+	now := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	tracker.Record(ctx, "tenant", 100, 1)
+	tracker.Record(ctx, "big", 100, 1)
+	if err := tracker.Check(ctx, "tenant"); err == nil {
+		t.Error("Expected the daily quota to be used up")
+	}
+	if err := tracker.Check(ctx, "big"); err != nil {
+		t.Errorf("Expected the tenant's own limits to apply, got %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := tracker.Check(ctx, "tenant"); err != nil {
+		t.Errorf("Expected the quota to reset the next day, got %v", err)
+	}
+	usage, err := tracker.Usage(ctx, "tenant")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if usage.Daily.Bytes != 0 || usage.Monthly.Bytes != 0 {
+		t.Errorf("Expected a new day and month to start empty, got %+v", usage)
+	}
+	if want := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC); !usage.Monthly.Reset.Equal(want) {
+		t.Errorf("Expected monthly reset %s, got %s", want, usage.Monthly.Reset)
+	}
+	if usage.Daily.MaxBytes != 100 {
+		t.Errorf("Expected daily limit 100, got %d", usage.Daily.MaxBytes)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Add(ctx context.Context, key string, bytes, objects int64, expires time.Time) (int64, int64, error) {
+	return 0, 0, errors.New("unavailable")
+}
+
+func (failingStore) Get(ctx context.Context, key string) (int64, int64, error) {
+	return 0, 0, errors.New("unavailable")
+}
+
+func TestTracker_StoreFailure(t *testing.T) {
+	tracker := NewTracker(failingStore{})
+	tracker.SetLimits(Limits{DailyBytes: 1}, nil)
+
+	if err := tracker.Check(context.Background(), "tenant"); err != nil {
+		t.Errorf("Expected uploads to be allowed while the store fails, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/clientip"
+
+	"golang.org/x/time/rate"
+)
+
+// idleTimeout is how long an unused client limiter is kept
+const idleTimeout = 10 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Shared applies limits across replicas, e.g. through Redis
+type Shared interface {
+	Allow(ctx context.Context, client string, rps float64, burst int) (bool, time.Duration, error)
+}
+
+// Limiter applies a token bucket per client, keyed by authenticated principal or remote IP.
+// A limit of zero disables rate limiting.
+type Limiter struct {
+	mu      sync.Mutex
+	limit   rate.Limit
+	burst   int
+	clients map[string]*clientLimiter
+	swept   time.Time
+	shared  Shared
+}
+
+// New creates a limiter allowing rps requests per second with the given burst per client
+func New(rps float64, burst int) *Limiter {
+	l := &Limiter{
+		clients: make(map[string]*clientLimiter),
+	}
+	l.SetLimit(rps, burst)
+	return l
+}
+
+// SetLimit changes the limit for all clients at runtime
+func (l *Limiter) SetLimit(rps float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if burst < 1 {
+		burst = max(1, int(math.Ceil(rps)))
+	}
+	l.limit = rate.Limit(rps)
+	l.burst = burst
+	for _, c := range l.clients {
+		c.limiter.SetLimit(l.limit)
+		c.limiter.SetBurst(l.burst)
+	}
+}
+
+// SetShared makes the middleware count requests in a store shared by all replicas.
+// While the store fails, each replica falls back to its own limiter.
+func (l *Limiter) SetShared(shared Shared) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.shared = shared
+}
+
+// Allow reports whether the client may make a request now, and otherwise how long to wait
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	l.sweep(now)
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+
+	r := c.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweep drops limiters of idle clients; callers hold the lock
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for key, c := range l.clients {
+		if now.Sub(c.lastSeen) > idleTimeout {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Middleware rejects requests over the limit with 429 Too Many Requests
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, wait := l.allow(r.Context(), ClientKey(r))
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *Limiter) allow(ctx context.Context, client string) (bool, time.Duration) {
+	l.mu.Lock()
+	shared, limit, burst := l.shared, l.limit, l.burst
+	l.mu.Unlock()
+
+	if shared != nil && limit > 0 {
+		if allowed, wait, err := shared.Allow(ctx, client, float64(limit), burst); err == nil {
+			return allowed, wait
+		}
+	}
+	return l.Allow(client)
+}
+
+// ClientKey identifies the client of a request by its authenticated principal or, without one, its IP
+func ClientKey(r *http.Request) string {
+	if principal := auth.PrincipalFromContext(r.Context()); principal != "" {
+		return "principal:" + principal
+	}
+	return "ip:" + clientip.FromRequest(r).Addr.String()
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l := New(1, 2)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("client"); !ok {
+			t.Fatalf("Expected request %d within burst to be allowed", i+1)
+		}
+	}
+
+	ok, wait := l.Allow("client")
+	if ok {
+		t.Fatal("Expected request over burst to be rejected")
+	}
+	if wait <= 0 {
+		t.Errorf("Expected positive wait, got %s", wait)
+	}
+
+	if ok, _ := l.Allow("other"); !ok {
+		t.Error("Expected other clients to have their own bucket")
+	}
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	l := New(0, 0)
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("client"); !ok {
+			t.Fatal("Expected disabled limiter to allow everything")
+		}
+	}
+
+	l.SetLimit(1, 1)
+	l.Allow("client")
+	if ok, _ := l.Allow("client"); ok {
+		t.Error("Expected limit applied at runtime to take effect")
+	}
+}
+
+type fakeShared struct {
+	allowed bool
+	err     error
+	calls   int
+}
+
+func (f *fakeShared) Allow(ctx context.Context, client string, rps float64, burst int) (bool, time.Duration, error) {
+	f.calls++
+	return f.allowed, time.Second, f.err
+}
+
+func TestLimiter_Shared(t *testing.T) {
+	handler := func(l *Limiter) int {
+		w := httptest.NewRecorder()
+		l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+			ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	l := New(1, 1)
+	shared := &fakeShared{allowed: false}
+	l.SetShared(shared)
+	if code := handler(l); code != http.StatusTooManyRequests || shared.calls != 1 {
+		t.Errorf("Expected the shared limit to apply, got status %d after %d calls", code, shared.calls)
+	}
+
+	shared.err = errors.New("connection refused")
+	if code := handler(l); code != http.StatusOK {
+		t.Errorf("Expected fallback to the local limiter, got status %d", code)
+	}
+	if code := handler(l); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the local limit to apply, got status %d", code)
+	}
+}
@@ -0,0 +1,52 @@
+package redisstore
+
+import (
+	"context"
+	"time"
+)
+
+// Cache is a read cache shared by all replicas. Errors count as cache misses.
+type Cache struct {
+	client *Client
+	ttl    time.Duration
+}
+
+// NewCache creates a cache whose entries expire after ttl
+func NewCache(client *Client, ttl time.Duration) *Cache {
+	return &Cache{
+		client: client,
+		ttl:    ttl,
+	}
+}
+
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, bool) {
+	var value []byte
+	err := c.client.do(func() error {
+		var err error
+		value, err = c.client.rdb.Get(ctx, c.client.key("cache:", key)).Bytes()
+		return err
+	})
+	return value, err == nil
+}
+
+func (c *Cache) Has(ctx context.Context, key string) bool {
+	var n int64
+	err := c.client.do(func() error {
+		var err error
+		n, err = c.client.rdb.Exists(ctx, c.client.key("cache:", key)).Result()
+		return err
+	})
+	return err == nil && n > 0
+}
+
+func (c *Cache) Set(ctx context.Context, key string, value []byte) {
+	c.client.do(func() error {
+		return c.client.rdb.Set(ctx, c.client.key("cache:", key), value, c.ttl).Err()
+	})
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) {
+	c.client.do(func() error {
+		return c.client.rdb.Del(ctx, c.client.key("cache:", key)).Err()
+	})
+}
@@ -0,0 +1,134 @@
+package redisstore
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	"gcp-proxy-mity/internal/changes"
+)
+
+// ChangeLog keeps the change feed in a Redis stream shared by all replicas.
+// Cursors are stream entry IDs. Detecting expired cursors needs Redis 7.
+type ChangeLog struct {
+	client    *Client
+	retention int64
+}
+
+// NewChangeLog creates a change log keeping about retention changes
+func NewChangeLog(client *Client, retention int64) *ChangeLog {
+	return &ChangeLog{
+		client:    client,
+		retention: retention,
+	}
+}
+
+func (l *ChangeLog) Append(ctx context.Context, change changes.Change) (string, error) {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return "", err
+	}
+	var id string
+	err = l.client.do(func() error {
+		var err error
+		id, err = l.client.rdb.XAdd(ctx, &redis.XAddArgs{
+			Stream: l.client.key("changes"),
+			MaxLen: l.retention,
+			Approx: true,
+			Values: map[string]any{"change": data},
+		}).Result()
+		return err
+	})
+	return id, err
+}
+
+func (l *ChangeLog) Read(ctx context.Context, cursor string, limit int) ([]changes.Change, error) {
+	stream := l.client.key("changes")
+	start := "-"
+	if cursor != "" {
+		if _, _, ok := parseStreamID(cursor); !ok {
+			return nil, changes.ErrInvalidCursor
+		}
+		start = "(" + cursor
+	}
+
+	var info *redis.XInfoStream
+	var messages []redis.XMessage
+	err := l.client.do(func() error {
+		var err error
+		info, err = l.client.rdb.XInfoStream(ctx, stream).Result()
+		if err != nil {
+			// A stream that was never written has no info
+			if strings.Contains(err.Error(), "no such key") {
+				info = &redis.XInfoStream{}
+				err = nil
+			}
+			return err
+		}
+		messages, err = l.client.rdb.XRangeN(ctx, stream, start, "+", int64(limit)).Result()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Trimming records the newest dropped entry; a cursor before it missed changes
+	if cursor != "" && info.MaxDeletedEntryID != "" && compareStreamIDs(cursor, info.MaxDeletedEntryID) < 0 {
+		return nil, changes.ErrCursorExpired
+	}
+
+	result := make([]changes.Change, 0, len(messages))
+	for _, message := range messages {
+		data, _ := message.Values["change"].(string)
+		var change changes.Change
+		if err := json.Unmarshal([]byte(data), &change); err != nil {
+			return nil, fmt.Errorf("invalid change %s: %w", message.ID, err)
+		}
+		change.Cursor = message.ID
+		result = append(result, change)
+	}
+	return result, nil
+}
+
+func (l *ChangeLog) Latest(ctx context.Context) (string, error) {
+	var messages []redis.XMessage
+	err := l.client.do(func() error {
+		var err error
+		messages, err = l.client.rdb.XRevRangeN(ctx, l.client.key("changes"), "+", "-", 1).Result()
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(messages) == 0 {
+		return "0-0", nil
+	}
+	return messages[0].ID, nil
+}
+
+// parseStreamID splits a stream entry ID into its time and sequence parts
+func parseStreamID(id string) (uint64, uint64, bool) {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	m, err := strconv.ParseUint(ms, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	s, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return m, s, true
+}
+
+func compareStreamIDs(a, b string) int {
+	am, as, _ := parseStreamID(a)
+	bm, bs, _ := parseStreamID(b)
+	return cmp.Or(cmp.Compare(am, bm), cmp.Compare(as, bs))
+}
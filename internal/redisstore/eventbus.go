@@ -0,0 +1,64 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"gcp-proxy-mity/internal/events"
+)
+
+// EventBus relays events between replicas over a Redis channel, so live event
+// streams show the writes of every replica
+type EventBus struct {
+	client *Client
+	local  events.Publisher
+}
+
+// NewEventBus creates a bus delivering the events of all replicas to local
+func NewEventBus(client *Client, local events.Publisher) *EventBus {
+	return &EventBus{
+		client: client,
+		local:  local,
+	}
+}
+
+// Publish sends an event to all replicas; while Redis is down, only to this one
+func (b *EventBus) Publish(ctx context.Context, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	err = b.client.do(func() error {
+		return b.client.rdb.Publish(ctx, b.client.key("events"), data).Err()
+	})
+	if err != nil {
+		return b.local.Publish(ctx, event)
+	}
+	return nil
+}
+
+// Run delivers the events published by all replicas to the local publisher until ctx is done
+func (b *EventBus) Run(ctx context.Context) {
+	// The subscription reconnects by itself after connection failures
+	subscription := b.client.rdb.Subscribe(ctx, b.client.key("events"))
+	defer subscription.Close()
+
+	messages := subscription.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			var event events.Event
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+				log.Printf("Dropping invalid event from Redis: %v", err)
+				continue
+			}
+			b.local.Publish(ctx, event)
+		}
+	}
+}
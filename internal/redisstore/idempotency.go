@@ -0,0 +1,74 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"gcp-proxy-mity/internal/idempotency"
+)
+
+// reserveKey stores ARGV[1] in KEYS[1] for ARGV[2] milliseconds unless the
+// key exists. Returns the existing value, or nil when stored.
+var reserveKey = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return false
+end
+return redis.call("GET", KEYS[1])
+`)
+
+// IdempotencyStore keeps Idempotency-Key records in Redis, so retries are
+// recognized by every replica
+type IdempotencyStore struct {
+	client *Client
+}
+
+// NewIdempotencyStore creates an idempotency store backed by Redis
+func NewIdempotencyStore(client *Client) *IdempotencyStore {
+	return &IdempotencyStore{
+		client: client,
+	}
+}
+
+func (s *IdempotencyStore) Reserve(ctx context.Context, key string, record idempotency.Record, ttl time.Duration) (*idempotency.Record, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var existing string
+	err = s.client.do(func() error {
+		var err error
+		existing, err = reserveKey.Run(ctx, s.client.rdb, []string{s.client.key("idempotency:", key)}, data, ttl.Milliseconds()).Text()
+		return err
+	})
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var current idempotency.Record
+	if err := json.Unmarshal([]byte(existing), &current); err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+func (s *IdempotencyStore) Complete(ctx context.Context, key string, record idempotency.Record, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.client.do(func() error {
+		return s.client.rdb.Set(ctx, s.client.key("idempotency:", key), data, ttl).Err()
+	})
+}
+
+func (s *IdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.client.do(func() error {
+		return s.client.rdb.Del(ctx, s.client.key("idempotency:", key)).Err()
+	})
+}
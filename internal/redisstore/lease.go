@@ -0,0 +1,128 @@
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"gcp-proxy-mity/internal/lease"
+)
+
+// renewLease replaces the lease in KEYS[1] with ARGV[2], expiring at ARGV[3]
+// in Unix milliseconds, if it still has the ID ARGV[1]. Returns 1 when renewed.
+var renewLease = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if not current or cjson.decode(current).id ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[2], "PXAT", ARGV[3])
+return 1
+`)
+
+// releaseLease deletes the lease in KEYS[1] if it has the ID ARGV[1]. Returns 1 when released.
+var releaseLease = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if not current or cjson.decode(current).id ~= ARGV[1] then
+	return 0
+end
+redis.call("DEL", KEYS[1])
+return 1
+`)
+
+// LeaseStore keeps leases in Redis so they guard writes through all replicas.
+// Leases expire with their keys, which needs Redis 6.2.
+type LeaseStore struct {
+	client *Client
+}
+
+// NewLeaseStore creates a lease store backed by Redis
+func NewLeaseStore(client *Client) *LeaseStore {
+	return &LeaseStore{
+		client: client,
+	}
+}
+
+func (s *LeaseStore) Acquire(ctx context.Context, l lease.Lease) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	err = s.client.do(func() error {
+		return s.client.rdb.SetArgs(ctx, s.client.key("lease:", l.Path), data, redis.SetArgs{Mode: "NX", ExpireAt: l.Expires}).Err()
+	})
+	// SET NX replies nil when the key exists
+	if errors.Is(err, redis.Nil) {
+		return lease.ErrLocked
+	}
+	return err
+}
+
+func (s *LeaseStore) Renew(ctx context.Context, path, id string, expires time.Time) (*lease.Lease, error) {
+	current, err := s.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || current.ID != id {
+		return nil, lease.ErrNotHeld
+	}
+	current.Expires = expires
+	data, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+
+	var renewed int64
+	err = s.client.do(func() error {
+		var err error
+		renewed, err = renewLease.Run(ctx, s.client.rdb, []string{s.client.key("lease:", path)}, id, data, expires.UnixMilli()).Int64()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The lease expired or was released since it was read
+	if renewed == 0 {
+		return nil, lease.ErrNotHeld
+	}
+	return current, nil
+}
+
+func (s *LeaseStore) Release(ctx context.Context, path, id string) error {
+	var released int64
+	err := s.client.do(func() error {
+		var err error
+		released, err = releaseLease.Run(ctx, s.client.rdb, []string{s.client.key("lease:", path)}, id).Int64()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if released == 0 {
+		return lease.ErrNotHeld
+	}
+	return nil
+}
+
+func (s *LeaseStore) Get(ctx context.Context, path string) (*lease.Lease, error) {
+	var data []byte
+	err := s.client.do(func() error {
+		var err error
+		data, err = s.client.rdb.Get(ctx, s.client.key("lease:", path)).Bytes()
+		return err
+	})
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l lease.Lease
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("invalid lease on %s: %w", path, err)
+	}
+	return &l, nil
+}
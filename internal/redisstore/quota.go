@@ -0,0 +1,59 @@
+package redisstore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// QuotaStore keeps upload quota counters in Redis so all replicas count against the same quota
+type QuotaStore struct {
+	client *Client
+}
+
+// NewQuotaStore creates a quota store backed by Redis
+func NewQuotaStore(client *Client) *QuotaStore {
+	return &QuotaStore{
+		client: client,
+	}
+}
+
+func (s *QuotaStore) Add(ctx context.Context, key string, bytes, objects int64, expires time.Time) (int64, int64, error) {
+	key = s.client.key("quota:", key)
+	var bytesCmd, objectsCmd *redis.IntCmd
+	err := s.client.do(func() error {
+		_, err := s.client.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			bytesCmd = pipe.HIncrBy(ctx, key, "bytes", bytes)
+			objectsCmd = pipe.HIncrBy(ctx, key, "objects", objects)
+			pipe.ExpireAt(ctx, key, expires)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return bytesCmd.Val(), objectsCmd.Val(), nil
+}
+
+func (s *QuotaStore) Get(ctx context.Context, key string) (int64, int64, error) {
+	var values []any
+	err := s.client.do(func() error {
+		var err error
+		values, err = s.client.rdb.HMGet(ctx, s.client.key("quota:", key), "bytes", "objects").Result()
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseCounter(values[0]), parseCounter(values[1]), nil
+}
+
+// parseCounter reads a hash field returned by HMGET; missing fields are nil
+func parseCounter(value any) int64 {
+	s, _ := value.(string)
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
@@ -0,0 +1,61 @@
+package redisstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// gcra implements the generic cell rate algorithm, which behaves like a token
+// bucket but only stores the theoretical arrival time of the next request.
+// KEYS[1] holds the arrival time in microseconds; ARGV are the emission
+// interval and the burst. Returns the wait in microseconds, 0 when allowed.
+var gcra = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+
+local allow_at = tat + interval - burst * interval
+if now < allow_at then
+	return allow_at - now
+end
+
+tat = tat + interval
+redis.call("SET", KEYS[1], tat, "PX", math.ceil((tat - now) / 1000))
+return 0
+`)
+
+// RateLimiter counts requests per client in Redis so limits hold across replicas
+type RateLimiter struct {
+	client *Client
+}
+
+// NewRateLimiter creates a rate limiter backed by Redis
+func NewRateLimiter(client *Client) *RateLimiter {
+	return &RateLimiter{
+		client: client,
+	}
+}
+
+// Allow reports whether the client may make a request now, and otherwise how long to wait
+func (l *RateLimiter) Allow(ctx context.Context, client string, rps float64, burst int) (bool, time.Duration, error) {
+	interval := int64(float64(time.Second/time.Microsecond) / rps)
+
+	var wait int64
+	err := l.client.do(func() error {
+		var err error
+		wait, err = gcra.Run(ctx, l.client.rdb, []string{l.client.key("ratelimit:", client)}, interval, burst).Int64()
+		return err
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	return wait == 0, time.Duration(wait) * time.Microsecond, nil
+}
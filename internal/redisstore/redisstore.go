@@ -0,0 +1,88 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrUnavailable is returned without contacting Redis while it is considered down
+var ErrUnavailable = errors.New("redis unavailable")
+
+// retryAfter is how long Redis is skipped after a failed command
+const retryAfter = 5 * time.Second
+
+// Client shares state between replicas through Redis. After a failure it
+// reports ErrUnavailable for a few seconds so callers fall back to local
+// state instead of waiting on timeouts for every request.
+type Client struct {
+	rdb    redis.UniversalClient
+	prefix string
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// New connects to the Redis server at a redis:// or rediss:// URL. Keys are namespaced by prefix.
+func New(url, prefix string) (*Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	// Redis sits on the request path; fail fast and degrade rather than stall requests
+	opts.DialTimeout = time.Second
+	opts.ReadTimeout = 500 * time.Millisecond
+	opts.WriteTimeout = 500 * time.Millisecond
+
+	return &Client{
+		rdb:    redis.NewClient(opts),
+		prefix: prefix,
+	}, nil
+}
+
+// Close closes the connections to Redis
+func (c *Client) Close() error {
+	return c.rdb.Close()
+}
+
+// Ping checks that Redis is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(func() error {
+		return c.rdb.Ping(ctx).Err()
+	})
+}
+
+// do runs a command unless Redis is marked down, and marks it down on failure.
+// redis.Nil means a missing key and is not a failure.
+func (c *Client) do(command func() error) error {
+	c.mu.Lock()
+	down := time.Now().Before(c.downUntil)
+	c.mu.Unlock()
+	if down {
+		return ErrUnavailable
+	}
+
+	err := command()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		c.mu.Lock()
+		if time.Now().After(c.downUntil) {
+			log.Printf("Redis command failed, falling back to local state for %s: %v", retryAfter, err)
+		}
+		c.downUntil = time.Now().Add(retryAfter)
+		c.mu.Unlock()
+	}
+	return err
+}
+
+func (c *Client) key(parts ...string) string {
+	key := c.prefix
+	for _, part := range parts {
+		key += part
+	}
+	return key
+}
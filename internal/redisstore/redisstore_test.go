@@ -0,0 +1,80 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/changes"
+	"gcp-proxy-mity/internal/idempotency"
+)
+
+func TestNew_InvalidURL(t *testing.T) {
+	if _, err := New("http://localhost:6379", "test:"); err == nil {
+		t.Error("Expected an error for a non-Redis URL")
+	}
+}
+
+func TestClient_Degrades(t *testing.T) {
+	// Nothing listens on port 1, so every command fails
+	client, err := New("redis://127.0.0.1:1/0", "test:")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	if err := client.Ping(ctx); err == nil || errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected a connection error, got %v", err)
+	}
+
+	start := time.Now()
+	if err := NewSessions(client).Save(ctx, "a", []byte("x"), time.Minute); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable while Redis is down, got %v", err)
+	}
+	if _, _, err := NewRateLimiter(client).Allow(ctx, "client", 1, 1); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable while Redis is down, got %v", err)
+	}
+	if _, _, err := NewQuotaStore(client).Get(ctx, "tenant"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable while Redis is down, got %v", err)
+	}
+	if _, ok := NewCache(client, time.Minute).Get(ctx, "a"); ok {
+		t.Error("Expected a cache miss while Redis is down")
+	}
+	if NewCache(client, time.Minute).Has(ctx, "a") {
+		t.Error("Expected a cache miss while Redis is down")
+	}
+	if _, err := NewChangeLog(client, 10).Append(ctx, changes.Change{Path: "a"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable while Redis is down, got %v", err)
+	}
+	if _, err := NewLeaseStore(client).Get(ctx, "a"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable while Redis is down, got %v", err)
+	}
+	if _, err := NewIdempotencyStore(client).Reserve(ctx, "a", idempotency.Record{}, time.Minute); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable while Redis is down, got %v", err)
+	}
+	if _, err := NewReplayCache(client).Remember(ctx, "a", time.Minute); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable while Redis is down, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected commands to fail fast while Redis is down, took %s", elapsed)
+	}
+}
+
+func TestCompareStreamIDs(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1700000000000-0", "1700000000000-0", 0},
+		{"1700000000000-2", "1700000000000-10", -1},
+		{"1700000000001-0", "1700000000000-10", 1},
+	}
+
+	for _, tt := range tests {
+		if got := compareStreamIDs(tt.a, tt.b); got != tt.want {
+			t.Errorf("Expected %d comparing %s to %s, got %d", tt.want, tt.a, tt.b, got)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package redisstore
+
+import (
+	"context"
+	"time"
+)
+
+// ReplayCache remembers request signatures in Redis, so a signed request is
+// accepted once across all replicas
+type ReplayCache struct {
+	client *Client
+}
+
+// NewReplayCache creates a replay cache backed by Redis
+func NewReplayCache(client *Client) *ReplayCache {
+	return &ReplayCache{
+		client: client,
+	}
+}
+
+func (c *ReplayCache) Remember(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	var fresh bool
+	err := c.client.do(func() error {
+		var err error
+		fresh, err = c.client.rdb.SetNX(ctx, c.client.key("replay:", id), 1, ttl).Result()
+		return err
+	})
+	return fresh, err
+}
@@ -0,0 +1,47 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Sessions stores upload session state that any replica may need to pick up
+type Sessions struct {
+	client *Client
+}
+
+// NewSessions creates a session store backed by Redis
+func NewSessions(client *Client) *Sessions {
+	return &Sessions{
+		client: client,
+	}
+}
+
+func (s *Sessions) Save(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.do(func() error {
+		return s.client.rdb.Set(ctx, s.client.key("session:", key), value, ttl).Err()
+	})
+}
+
+// Load returns nil without an error for unknown keys
+func (s *Sessions) Load(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := s.client.do(func() error {
+		var err error
+		value, err = s.client.rdb.Get(ctx, s.client.key("session:", key)).Bytes()
+		return err
+	})
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (s *Sessions) Delete(ctx context.Context, key string) error {
+	return s.client.do(func() error {
+		return s.client.rdb.Del(ctx, s.client.key("session:", key)).Err()
+	})
+}
@@ -0,0 +1,8 @@
+package replication
+
+import "errors"
+
+var (
+	ErrUnsafePath = errors.New("object name can't be stored as a file")
+	ErrRejected   = errors.New("replication target rejected the request")
+)
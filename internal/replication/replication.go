@@ -0,0 +1,317 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/metrics"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// batchSize is the most queued entries handled in one pass
+const batchSize = 500
+
+// maxBackoff caps the delay between attempts to replicate an object
+const maxBackoff = time.Hour
+
+var (
+	replicatedObjects  = metrics.NewCounter("gcs_proxy_replicated_objects_total", "Writes and deletions copied to the replication target")
+	replicationErrors  = metrics.NewCounter("gcs_proxy_replication_errors_total", "Failed attempts to copy a change to the replication target")
+	replicationDropped = metrics.NewCounter("gcs_proxy_replication_failed_total", "Changes given up on after the last attempt")
+)
+
+// Ways a replica can differ from its object
+const (
+	DriftMissing  = "missing"
+	DriftSize     = "size"
+	DriftChecksum = "checksum"
+)
+
+// errBatchDone stops walking the queue
+var errBatchDone = errors.New("batch done")
+
+// Source opens the objects to replicate
+type Source interface {
+	OpenFile(ctx context.Context, filePath string) (*storage.FileReader, error)
+}
+
+// Target is the backend objects are replicated to
+type Target interface {
+	PutFile(ctx context.Context, file *storage.FileReader) error
+	// StatFile describes a replica, failing with storage.ErrNotFound if there is none
+	StatFile(ctx context.Context, filePath string) (*storage.FileMetadata, error)
+	// DeleteFile removes an object, succeeding if there is none
+	DeleteFile(ctx context.Context, filePath string) error
+}
+
+// Config configures a Replicator
+type Config struct {
+	// Prefix is where the queue is kept in the source bucket
+	Prefix string
+	// Interval is how often the queue is checked besides after every change
+	Interval    time.Duration
+	MaxAttempts int
+	// Exclude lists the prefixes of objects that are not replicated
+	Exclude []string
+}
+
+// entry is a queued change to replicate. Entries are objects named
+// queue/<not before in Unix nanoseconds>-<random>.json, so listing the queue
+// returns them in the order they are due.
+type entry struct {
+	Path     string `json:"path"`
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Replicator copies every write and deletion published by the service to a
+// Target in the background. Changes are queued as objects in the source
+// bucket before the request completes, so they survive restarts and are
+// retried with backoff until MaxAttempts, after which they are moved under
+// failed/ for an operator to look at. The object's current state is copied
+// rather than the one the change saw, so repeated changes to an object are
+// replicated once and a deleted object is deleted from the target.
+type Replicator struct {
+	queue  storage.Storage
+	source Source
+	target Target
+	cfg    Config
+	wake   chan struct{}
+	now    func() time.Time
+}
+
+// New creates a replicator keeping its queue in queue and copying objects from source to target
+func New(queue storage.Storage, source Source, target Target, cfg Config) *Replicator {
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/") + "/"
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	return &Replicator{
+		queue:  queue,
+		source: source,
+		target: target,
+		cfg:    cfg,
+		wake:   make(chan struct{}, 1),
+		now:    time.Now,
+	}
+}
+
+// Publish queues the change an event describes
+func (r *Replicator) Publish(ctx context.Context, event events.Event) error {
+	if event.Type != events.ObjectWritten && event.Type != events.ObjectDeleted {
+		return nil
+	}
+	if strings.HasPrefix(event.Path, r.cfg.Prefix) {
+		return nil
+	}
+	for _, prefix := range r.cfg.Exclude {
+		if strings.HasPrefix(event.Path, prefix) {
+			return nil
+		}
+	}
+	// The change must be queued even if the client goes away meanwhile
+	if err := r.enqueue(context.WithoutCancel(ctx), "queue/", entry{Path: event.Path}, r.now()); err != nil {
+		return fmt.Errorf("failed to queue %s for replication: %w", event.Path, err)
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run replicates queued changes until ctx is canceled
+func (r *Replicator) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if err := r.process(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to process the replication queue: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// process replicates the changes that are due, each object once however
+// often it was queued
+func (r *Replicator) process(ctx context.Context) error {
+	now := r.now()
+	var names []string
+	err := r.queue.WalkFiles(ctx, r.cfg.Prefix+"queue/", func(file storage.FileMetadata) error {
+		due, ok := dueTime(file.Name)
+		if !ok {
+			return nil
+		}
+		if due.After(now) || len(names) == batchSize {
+			return errBatchDone
+		}
+		names = append(names, file.Name)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchDone) {
+		return err
+	}
+
+	// Entries are grouped by object in the order they were first queued
+	var paths []string
+	queued := make(map[string][]string)
+	attempts := make(map[string]int)
+	for _, name := range names {
+		data, err := r.queue.ReadFile(ctx, name)
+		if errors.Is(err, storage.ErrNotFound) {
+			// Another replica handled it
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var e entry
+		if err := json.Unmarshal(data.Content, &e); err != nil {
+			log.Printf("Skipping malformed replication entry %s: %v", name, err)
+			r.remove(ctx, name)
+			continue
+		}
+		if _, ok := queued[e.Path]; !ok {
+			paths = append(paths, e.Path)
+		}
+		queued[e.Path] = append(queued[e.Path], name)
+		attempts[e.Path] = max(attempts[e.Path], e.Attempts)
+	}
+
+	for _, filePath := range paths {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := r.Replicate(ctx, filePath); err != nil {
+			replicationErrors.Inc()
+			if !r.retry(ctx, entry{Path: filePath, Attempts: attempts[filePath] + 1, Error: err.Error()}) {
+				// The entries are kept so the change is retried on the next pass
+				continue
+			}
+		} else {
+			replicatedObjects.Inc()
+		}
+		for _, name := range queued[filePath] {
+			r.remove(ctx, name)
+		}
+	}
+	return nil
+}
+
+// Replicate copies the current state of an object to the target right away
+func (r *Replicator) Replicate(ctx context.Context, filePath string) error {
+	file, err := r.source.OpenFile(ctx, filePath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return r.target.DeleteFile(ctx, filePath)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return r.target.PutFile(ctx, file)
+}
+
+// Compare checks the replica of an object and returns how it differs, or ""
+// if it matches
+func (r *Replicator) Compare(ctx context.Context, file storage.FileMetadata) (string, error) {
+	replica, err := r.target.StatFile(ctx, file.Name)
+	if errors.Is(err, storage.ErrNotFound) {
+		return DriftMissing, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	switch {
+	// The size of objects stored compressed or encrypted is that of their content as written
+	case file.ContentEncoding == "" && !file.Envelope && replica.Size != file.Size:
+		return DriftSize, nil
+	// Composed objects and multipart uploads have no MD5 to compare
+	case file.MD5 != "" && replica.MD5 != "" && !strings.EqualFold(file.MD5, replica.MD5):
+		return DriftChecksum, nil
+	}
+	return "", nil
+}
+
+// retry queues a failed change again after a backoff, or under failed/ after
+// the last attempt, and reports whether it was queued
+func (r *Replicator) retry(ctx context.Context, e entry) bool {
+	dir, due := "queue/", r.now().Add(r.backoff(e.Attempts))
+	if e.Attempts >= r.cfg.MaxAttempts {
+		log.Printf("Giving up replicating %s after %d attempts: %s", e.Path, e.Attempts, e.Error)
+		replicationDropped.Inc()
+		dir, due = "failed/", r.now()
+	}
+	if err := r.enqueue(ctx, dir, e, due); err != nil {
+		log.Printf("Failed to queue %s for replication again: %v", e.Path, err)
+		return false
+	}
+	return true
+}
+
+// backoff doubles the interval with every attempt up to maxBackoff
+func (r *Replicator) backoff(attempts int) time.Duration {
+	delay := r.cfg.Interval
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+func (r *Replicator) enqueue(ctx context.Context, dir string, e entry, due time.Time) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	response, err := r.queue.WriteFiles(ctx, []storage.WriteRequest{{
+		Path:        fmt.Sprintf("%s%s%020d-%s.json", r.cfg.Prefix, dir, due.UnixNano(), hex.EncodeToString(suffix)),
+		Content:     bytes.NewReader(body),
+		ContentType: "application/json",
+	}})
+	if err != nil {
+		return err
+	}
+	if len(response.Errors) > 0 {
+		return errors.New(response.Errors[0].Error)
+	}
+	return nil
+}
+
+func (r *Replicator) remove(ctx context.Context, name string) {
+	if err := r.queue.DeleteFile(ctx, name); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Failed to remove replication entry %s: %v", name, err)
+	}
+}
+
+// dueTime parses the time an entry is due from its name
+func dueTime(name string) (time.Time, bool) {
+	nanos, _, ok := strings.Cut(path.Base(name), "-")
+	if !ok {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
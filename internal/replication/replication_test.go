@@ -0,0 +1,311 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/s3"
+	"gcp-proxy-mity/internal/storage"
+	"gcp-proxy-mity/pkg/storage/gcs"
+)
+
+// newTestBuckets returns storage for a primary and a secondary bucket in fake-gcs-server
+func newTestBuckets(t *testing.T) (primary, secondary *storage.GCSStorage) {
+	t.Helper()
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme:     "http",
+		Host:       "127.0.0.1",
+		PublicHost: "127.0.0.1",
+	})
+	if err != nil {
+		t.Fatalf("Failed to start fake-gcs-server: %v", err)
+	}
+	t.Cleanup(server.Stop)
+
+	buckets := make([]*storage.GCSStorage, 0, 2)
+	for _, bucket := range []string{"primary", "secondary"} {
+		server.CreateBucket(bucket)
+		client, err := gcs.NewEmulatorClient(context.Background(), "test-project", bucket, server.URL())
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		buckets = append(buckets, storage.NewGCSStorage(client))
+	}
+	return buckets[0], buckets[1]
+}
+
+func write(t *testing.T, s storage.Storage, path, content string) storage.FileMetadata {
+	t.Helper()
+	response, err := s.WriteFiles(context.Background(), []storage.WriteRequest{{
+		Path:        path,
+		Content:     strings.NewReader(content),
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"owner": "ci"},
+	}})
+	if err != nil || len(response.Errors) > 0 {
+		t.Fatalf("Failed to write %s: %v %v", path, err, response)
+	}
+	return response.FilesWritten[0]
+}
+
+func queued(t *testing.T, s storage.Storage, prefix string) []string {
+	t.Helper()
+	names, err := s.ListNames(context.Background(), prefix)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return names
+}
+
+func TestReplicator(t *testing.T) {
+	primary, secondary := newTestBuckets(t)
+	replicator := New(primary, primary, secondary, Config{Prefix: "_replication", Exclude: []string{"_trash/"}})
+	ctx := context.Background()
+
+	file := write(t, primary, "docs/a.txt", "hello")
+	write(t, primary, "_trash/old.txt", "bye")
+	for _, event := range []events.Event{
+		events.NewObjectEvent(events.ObjectWritten, file, nil),
+		// Writing twice replicates once
+		events.NewObjectEvent(events.ObjectWritten, file, nil),
+		events.NewObjectEvent(events.ObjectWritten, storage.FileMetadata{Name: "_trash/old.txt"}, nil),
+		{Type: "storage.object.accessed", Path: "docs/a.txt"},
+	} {
+		if err := replicator.Publish(ctx, event); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if names := queued(t, primary, "_replication/queue/"); len(names) != 2 {
+		t.Fatalf("Expected 2 queued changes, got %v", names)
+	}
+
+	if err := replicator.process(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	replica, err := secondary.ReadFile(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatalf("Expected the object to be replicated, got %v", err)
+	}
+	if string(replica.Content) != "hello" || replica.Metadata.ContentType != "text/plain" {
+		t.Errorf("Expected hello as text/plain, got %q as %s", replica.Content, replica.Metadata.ContentType)
+	}
+	if metadata, _ := secondary.GetMetadata(ctx, "docs/a.txt"); metadata["owner"] != "ci" {
+		t.Errorf("Expected the custom metadata to be replicated, got %v", metadata)
+	}
+	if _, err := secondary.StatFile(ctx, "_trash/old.txt"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected excluded objects not to be replicated, got %v", err)
+	}
+	if names := queued(t, primary, "_replication/"); len(names) != 0 {
+		t.Errorf("Expected an empty queue, got %v", names)
+	}
+
+	if err := primary.DeleteFile(ctx, "docs/a.txt"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	replicator.Publish(ctx, events.NewObjectEvent(events.ObjectDeleted, storage.FileMetadata{Name: "docs/a.txt"}, nil))
+	if err := replicator.process(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := secondary.StatFile(ctx, "docs/a.txt"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected the deletion to be replicated, got %v", err)
+	}
+}
+
+func TestReplicator_Compare(t *testing.T) {
+	primary, secondary := newTestBuckets(t)
+	replicator := New(primary, primary, secondary, Config{Prefix: "_replication"})
+	ctx := context.Background()
+
+	same := write(t, primary, "docs/same.txt", "hello")
+	write(t, secondary, "docs/same.txt", "hello")
+	resized := write(t, primary, "docs/resized.txt", "hello")
+	write(t, secondary, "docs/resized.txt", "hello, world")
+	changed := write(t, primary, "docs/changed.txt", "hello")
+	write(t, secondary, "docs/changed.txt", "HELLO")
+	missing := write(t, primary, "docs/missing.txt", "hello")
+
+	tests := []struct {
+		name  string
+		file  storage.FileMetadata
+		drift string
+	}{
+		{name: "matching", file: same},
+		{name: "different size", file: resized, drift: DriftSize},
+		{name: "different content", file: changed, drift: DriftChecksum},
+		{name: "not replicated", file: missing, drift: DriftMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drift, err := replicator.Compare(ctx, tt.file)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if drift != tt.drift {
+				t.Errorf("Expected drift %q, got %q", tt.drift, drift)
+			}
+		})
+	}
+}
+
+type failingTarget struct{}
+
+func (failingTarget) PutFile(ctx context.Context, file *storage.FileReader) error {
+	return errors.New("target is down")
+}
+
+func (failingTarget) StatFile(ctx context.Context, filePath string) (*storage.FileMetadata, error) {
+	return nil, errors.New("target is down")
+}
+
+func (failingTarget) DeleteFile(ctx context.Context, filePath string) error {
+	return errors.New("target is down")
+}
+
+func TestReplicator_Retry(t *testing.T) {
+	primary, _ := newTestBuckets(t)
+	replicator := New(primary, primary, failingTarget{}, Config{Prefix: "_replication", Interval: time.Minute, MaxAttempts: 2})
+	now := time.Now()
+	replicator.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	file := write(t, primary, "docs/a.txt", "hello")
+	replicator.Publish(ctx, events.NewObjectEvent(events.ObjectWritten, file, nil))
+
+	tests := []struct {
+		name   string
+		after  time.Duration
+		queue  int
+		failed int
+	}{
+		{name: "first attempt is retried", queue: 1},
+		{name: "not due yet", after: 30 * time.Second, queue: 1},
+		{name: "last attempt fails", after: time.Minute, failed: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replicator.now = func() time.Time { return now.Add(tt.after) }
+			if err := replicator.process(ctx); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			queue, failed := queued(t, primary, "_replication/queue/"), queued(t, primary, "_replication/failed/")
+			if len(queue) != tt.queue || len(failed) != tt.failed {
+				t.Errorf("Expected %d queued and %d failed, got %v and %v", tt.queue, tt.failed, queue, failed)
+			}
+		})
+	}
+}
+
+func TestDirTarget(t *testing.T) {
+	dir := t.TempDir()
+	target := NewDirTarget(dir)
+	ctx := context.Background()
+	put := func(name, content string) error {
+		return target.PutFile(ctx, &storage.FileReader{
+			ReadCloser: io.NopCloser(strings.NewReader(content)),
+			Metadata:   storage.FileMetadata{Name: name, Size: int64(len(content))},
+		})
+	}
+
+	if err := put("docs/a.txt", "hello"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "docs", "a.txt")); string(content) != "hello" {
+		t.Errorf("Expected hello, got %q", content)
+	}
+	if err := put("docs/", ""); err != nil {
+		t.Errorf("Expected folder placeholders to be skipped, got %v", err)
+	}
+	for _, name := range []string{"../escape.txt", "/etc/passwd", `docs\..\..\escape.txt`} {
+		if err := put(name, "x"); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Expected ErrUnsafePath for %s, got %v", name, err)
+		}
+	}
+
+	file, err := target.StatFile(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if file.Size != 5 || file.MD5 != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("Expected 5 bytes with the MD5 of hello, got %d bytes with %s", file.Size, file.MD5)
+	}
+
+	if err := target.DeleteFile(ctx, "docs/a.txt"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := target.StatFile(ctx, "docs/a.txt"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for the deleted file, got %v", err)
+	}
+	if err := target.DeleteFile(ctx, "docs/a.txt"); err != nil {
+		t.Errorf("Expected deleting a missing file to succeed, got %v", err)
+	}
+}
+
+func TestS3Target(t *testing.T) {
+	verifier := s3.NewVerifier("eu-west-1", map[string]string{"AKIDEXAMPLE": "secret"})
+	received := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, body, err := verifier.Verify(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodHead {
+			w.Header().Set("ETag", `"5d41402abc4b2a76b9719d911017c592"`)
+			w.Header().Set("Content-Length", "5")
+			return
+		}
+		content, _ := io.ReadAll(body)
+		received[r.Method+" "+r.URL.Path] = string(content) + " " + r.Header.Get("X-Amz-Meta-Owner")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	target, err := NewS3Target(server.URL, "backup", "eu-west-1", "AKIDEXAMPLE", "secret")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = target.PutFile(ctx, &storage.FileReader{
+		ReadCloser: io.NopCloser(strings.NewReader("hello")),
+		Metadata:   storage.FileMetadata{Name: "docs/q1 report.txt", Size: 5, ContentType: "text/plain"},
+		Custom:     map[string]string{"owner": "ci"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := target.DeleteFile(ctx, "docs/old.txt"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := received["PUT /backup/docs/q1 report.txt"]; got != "hello ci" {
+		t.Errorf("Expected the object and its metadata to be put, got %v", received)
+	}
+	if _, ok := received["DELETE /backup/docs/old.txt"]; !ok {
+		t.Errorf("Expected the object to be deleted, got %v", received)
+	}
+	file, err := target.StatFile(ctx, "docs/q1 report.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if file.Size != 5 || file.MD5 != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("Expected the size and MD5 from the ETag, got %d bytes with %s", file.Size, file.MD5)
+	}
+
+	rejected, _ := NewS3Target(server.URL, "backup", "eu-west-1", "AKIDEXAMPLE", "wrong")
+	if err := rejected.DeleteFile(ctx, "docs/old.txt"); !errors.Is(err, ErrRejected) {
+		t.Errorf("Expected ErrRejected, got %v", err)
+	}
+}
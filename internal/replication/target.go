@@ -0,0 +1,212 @@
+package replication
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/s3"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// DirTarget replicates objects to files under a directory, e.g. a mounted
+// backup volume. Only the content is kept, not the metadata.
+type DirTarget struct {
+	dir string
+}
+
+// NewDirTarget creates a target writing files under dir
+func NewDirTarget(dir string) *DirTarget {
+	return &DirTarget{dir: dir}
+}
+
+func (t *DirTarget) PutFile(ctx context.Context, file *storage.FileReader) error {
+	name, err := t.file(file.Metadata.Name)
+	if err != nil || name == "" {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	// The file is replaced at once, so it is never seen half written
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".replica-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, file); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (t *DirTarget) StatFile(ctx context.Context, filePath string) (*storage.FileMetadata, error) {
+	name, err := t.file(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return &storage.FileMetadata{Name: filePath}, nil
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, filePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := md5.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.FileMetadata{Name: filePath, Size: size, MD5: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func (t *DirTarget) DeleteFile(ctx context.Context, filePath string) error {
+	name, err := t.file(filePath)
+	if err != nil || name == "" {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// file returns the file an object is stored in, or "" for folder placeholders
+func (t *DirTarget) file(filePath string) (string, error) {
+	if strings.HasSuffix(filePath, "/") {
+		return "", nil
+	}
+	name := filepath.FromSlash(filePath)
+	if !filepath.IsLocal(name) || strings.Contains(filePath, "\\") {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, filePath)
+	}
+	return filepath.Join(t.dir, name), nil
+}
+
+// S3Target replicates objects to a bucket of an S3-compatible service,
+// addressed in the path style
+type S3Target struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secret    string
+}
+
+// NewS3Target creates a target writing to bucket at endpoint, e.g. https://s3.eu-west-1.amazonaws.com
+func NewS3Target(endpoint, bucket, region, accessKey, secret string) (*S3Target, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	return &S3Target{
+		client:    &http.Client{},
+		endpoint:  u,
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secret:    secret,
+	}, nil
+}
+
+func (t *S3Target) PutFile(ctx context.Context, file *storage.FileReader) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPut, t.url(file.Metadata.Name), io.NopCloser(file))
+	if err != nil {
+		return err
+	}
+	r.ContentLength = file.Metadata.Size
+	if file.Metadata.Size == 0 {
+		r.Body = http.NoBody
+	}
+	if file.Metadata.ContentType != "" {
+		r.Header.Set("Content-Type", file.Metadata.ContentType)
+	}
+	if file.ContentEncoding != "" {
+		r.Header.Set("Content-Encoding", file.ContentEncoding)
+	}
+	if file.CacheControl != "" {
+		r.Header.Set("Cache-Control", file.CacheControl)
+	}
+	if sum, err := hex.DecodeString(file.Metadata.MD5); err == nil && len(sum) == md5.Size {
+		r.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum))
+	}
+	for key, value := range file.Custom {
+		r.Header.Set("X-Amz-Meta-"+key, value)
+	}
+	return t.do(r)
+}
+
+func (t *S3Target) StatFile(ctx context.Context, filePath string) (*storage.FileMetadata, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodHead, t.url(filePath), nil)
+	if err != nil {
+		return nil, err
+	}
+	s3.SignRequest(r, t.accessKey, t.secret, t.region, time.Now())
+	resp, err := t.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, filePath)
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("%w: %s", ErrRejected, resp.Status)
+	}
+	file := &storage.FileMetadata{Name: filePath, Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}
+	// The ETag of an object put in one piece is its MD5; multipart ones have a part count suffix
+	if etag := strings.Trim(resp.Header.Get("ETag"), `"`); !strings.Contains(etag, "-") {
+		file.MD5 = etag
+	}
+	return file, nil
+}
+
+func (t *S3Target) DeleteFile(ctx context.Context, filePath string) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodDelete, t.url(filePath), nil)
+	if err != nil {
+		return err
+	}
+	return t.do(r)
+}
+
+func (t *S3Target) url(filePath string) string {
+	u := *t.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + t.bucket + "/" + filePath
+	u.RawPath = ""
+	return u.String()
+}
+
+func (t *S3Target) do(r *http.Request) error {
+	s3.SignRequest(r, t.accessKey, t.secret, t.region, time.Now())
+	resp, err := t.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// S3 deletes answer 204 whether or not the object existed, some compatible services 404
+	gone := r.Method == http.MethodDelete && resp.StatusCode == http.StatusNotFound
+	if resp.StatusCode/100 != 2 && !gone {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%w: %s %s", ErrRejected, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
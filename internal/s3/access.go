@@ -0,0 +1,36 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+
+	"gcp-proxy-mity/internal/policy"
+)
+
+// Action returns what requests to the S3 API of bucket do to objects, for the
+// access policy. Listings act on their prefix, and requests about the service
+// or the bucket itself touch no objects.
+func Action(bucket string) func(*http.Request) (target policy.Target, ok bool) {
+	return func(r *http.Request) (policy.Target, bool) {
+		name, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if name != bucket {
+			return policy.Target{}, false
+		}
+		query := r.URL.Query()
+		if key == "" {
+			if r.Method != http.MethodGet || query.Has("location") {
+				return policy.Target{}, false
+			}
+			return policy.Target{Action: policy.ActionList, Path: query.Get("prefix")}, true
+		}
+
+		target := policy.Target{Action: policy.ActionRead, Path: key}
+		switch r.Method {
+		case http.MethodPut:
+			target.Action = policy.ActionWrite
+		case http.MethodDelete:
+			target.Action = policy.ActionDelete
+		}
+		return target, true
+	}
+}
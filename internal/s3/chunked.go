@@ -0,0 +1,119 @@
+package s3
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxChunkSize bounds the memory used to hold a chunk while its signature is checked
+const maxChunkSize = 16 << 20
+
+// chunkSigner verifies the signature chain of a STREAMING-AWS4-HMAC-SHA256-PAYLOAD body.
+// Each chunk is signed over the previous signature, starting with the request's.
+type chunkSigner struct {
+	key      []byte
+	amzDate  string
+	scope    string
+	previous string
+}
+
+func (s *chunkSigner) verify(signature string, data []byte) bool {
+	stringToSign := strings.Join([]string{
+		signingAlgorithm + "-PAYLOAD",
+		s.amzDate,
+		s.scope,
+		s.previous,
+		emptySHA256,
+		hexSHA256(data),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(s.key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return false
+	}
+	s.previous = signature
+	return true
+}
+
+// chunkedReader decodes an aws-chunked body: hex sizes with optional chunk
+// signatures, the data, and trailing headers after the final empty chunk
+type chunkedReader struct {
+	r      *bufio.Reader
+	signer *chunkSigner
+	buf    []byte
+	chunk  []byte
+	err    error
+}
+
+// newChunkedReader decodes body, checking chunk signatures when signer is set
+func newChunkedReader(body io.Reader, signer *chunkSigner) *chunkedReader {
+	return &chunkedReader{r: bufio.NewReader(body), signer: signer}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.next()
+	}
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+// next reads the following chunk; it returns io.EOF after the final one
+func (c *chunkedReader) next() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	sizeHex, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return fmt.Errorf("%w: invalid chunk size %q", ErrIncompleteBody, sizeHex)
+	}
+
+	if int64(cap(c.buf)) < size {
+		c.buf = make([]byte, size)
+	}
+	data := c.buf[:size]
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return fmt.Errorf("%w: %v", ErrIncompleteBody, err)
+	}
+
+	if c.signer != nil {
+		signature, ok := strings.CutPrefix(extension, "chunk-signature=")
+		if !ok || !c.signer.verify(signature, data) {
+			return fmt.Errorf("%w: invalid chunk signature", ErrSignatureDoesNotMatch)
+		}
+	}
+
+	if size == 0 {
+		// Trailing headers such as checksums end with an empty line; some clients omit it
+		for {
+			line, err := c.readLine()
+			if err != nil || line == "" {
+				return io.EOF
+			}
+		}
+	}
+
+	if line, err := c.readLine(); err != nil || line != "" {
+		return fmt.Errorf("%w: chunk is longer than its size", ErrIncompleteBody)
+	}
+	c.chunk = data
+	return nil
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrIncompleteBody, err)
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
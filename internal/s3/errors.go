@@ -0,0 +1,86 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+
+	"gcp-proxy-mity/internal/mount"
+	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/storage"
+)
+
+var (
+	ErrAccessDenied                 = errors.New("access denied")
+	ErrAuthorizationHeaderMalformed = errors.New("authorization header malformed")
+	ErrInvalidAccessKeyID           = errors.New("access key ID does not exist")
+	ErrSignatureDoesNotMatch        = errors.New("signature does not match")
+	ErrRequestTimeTooSkewed         = errors.New("request time too skewed")
+	ErrRequestExpired               = errors.New("request has expired")
+	ErrContentSHA256Mismatch        = errors.New("x-amz-content-sha256 does not match the content")
+	ErrBadDigest                    = errors.New("Content-MD5 does not match the content")
+	ErrIncompleteBody               = errors.New("malformed or incomplete body")
+	ErrEntityTooLarge               = errors.New("object exceeds the upload limit")
+	ErrInvalidArgument              = errors.New("invalid argument")
+	ErrNoSuchBucket                 = errors.New("bucket does not exist")
+	ErrNotImplemented               = errors.New("not implemented")
+)
+
+// errorResponse is the XML body of S3 error responses
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+// errorCode maps errors to S3 error codes and HTTP status codes
+func errorCode(err error) (string, int) {
+	switch {
+	case errors.Is(err, ErrAccessDenied), errors.Is(err, ErrRequestExpired), errors.Is(err, service.ErrQuarantined),
+		errors.Is(err, mount.ErrForbidden), errors.Is(err, mount.ErrReadOnly):
+		return "AccessDenied", http.StatusForbidden
+	case errors.Is(err, ErrAuthorizationHeaderMalformed):
+		return "AuthorizationHeaderMalformed", http.StatusBadRequest
+	case errors.Is(err, ErrInvalidAccessKeyID):
+		return "InvalidAccessKeyId", http.StatusForbidden
+	case errors.Is(err, ErrSignatureDoesNotMatch):
+		return "SignatureDoesNotMatch", http.StatusForbidden
+	case errors.Is(err, ErrRequestTimeTooSkewed):
+		return "RequestTimeTooSkewed", http.StatusForbidden
+	case errors.Is(err, ErrContentSHA256Mismatch):
+		return "XAmzContentSHA256Mismatch", http.StatusBadRequest
+	case errors.Is(err, ErrBadDigest):
+		return "BadDigest", http.StatusBadRequest
+	case errors.Is(err, ErrIncompleteBody):
+		return "IncompleteBody", http.StatusBadRequest
+	case errors.Is(err, ErrEntityTooLarge):
+		return "EntityTooLarge", http.StatusBadRequest
+	case errors.Is(err, ErrInvalidArgument), errors.Is(err, service.ErrContentTypeNotAllowed),
+		errors.Is(err, service.ErrInvalidKMSKey), errors.Is(err, service.ErrInvalidStorageClass):
+		return "InvalidArgument", http.StatusBadRequest
+	case errors.Is(err, ErrNoSuchBucket):
+		return "NoSuchBucket", http.StatusNotFound
+	case errors.Is(err, storage.ErrNotFound):
+		return "NoSuchKey", http.StatusNotFound
+	case errors.Is(err, ErrNotImplemented):
+		return "NotImplemented", http.StatusNotImplemented
+	default:
+		return "InternalError", http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code, status := errorCode(err)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(errorResponse{
+		Code:     code,
+		Message:  err.Error(),
+		Resource: r.URL.Path,
+	})
+}
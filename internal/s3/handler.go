@@ -0,0 +1,490 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/middleware"
+	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/storage"
+)
+
+const (
+	xmlNamespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+	maxListKeys  = 1000
+)
+
+// Handler serves a subset of the S3 REST API (GetObject, PutObject, DeleteObject,
+// HeadObject, ListObjects and ListObjectsV2) for a single bucket. Requests must be
+// path-style, e.g. /bucket/key, and signed with Signature Version 4.
+type Handler struct {
+	service        *service.StorageService
+	verifier       *Verifier
+	bucket         string
+	region         string
+	maxUploadBytes int64
+	created        time.Time
+	middlewares    []middleware.Middleware
+	// serve handles requests once they are verified
+	serve http.Handler
+}
+
+// NewHandler creates an S3 handler that exposes the service's bucket as bucket
+func NewHandler(service *service.StorageService, verifier *Verifier, bucket, region string, maxUploadBytes int64) *Handler {
+	h := &Handler{
+		service:        service,
+		verifier:       verifier,
+		bucket:         bucket,
+		region:         region,
+		maxUploadBytes: maxUploadBytes,
+		created:        time.Now().UTC(),
+	}
+	h.serve = http.HandlerFunc(h.route)
+	return h
+}
+
+// Use wraps the operations in middleware, like the access policy, which runs
+// once the request is verified and its access key is the principal. Middleware
+// runs in the order it is added.
+func (h *Handler) Use(middlewares ...middleware.Middleware) {
+	h.middlewares = append(h.middlewares, middlewares...)
+	h.serve = middleware.Chain(http.HandlerFunc(h.route), h.middlewares...)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	accessKey, body, err := h.verifier.Verify(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Access keys are the principal, without scopes
+	r = r.WithContext(auth.WithPrincipal(r.Context(), accessKey))
+	r.Body = io.NopCloser(body)
+	h.serve.ServeHTTP(w, r)
+}
+
+// route serves a verified request, whose body is the verified payload
+func (h *Handler) route(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case bucket == "":
+		if r.Method != http.MethodGet {
+			writeError(w, r, fmt.Errorf("%w: %s on the service", ErrNotImplemented, r.Method))
+			return
+		}
+		h.listBuckets(w)
+	case bucket != h.bucket:
+		writeError(w, r, fmt.Errorf("%w: %s", ErrNoSuchBucket, bucket))
+	case key == "":
+		h.serveBucket(w, r)
+	default:
+		h.serveObject(w, r, key, r.Body)
+	}
+}
+
+func (h *Handler) serveBucket(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && query.Has("location"):
+		region := h.region
+		// us-east-1 is reported as an empty location constraint
+		if region == "us-east-1" {
+			region = ""
+		}
+		writeXML(w, http.StatusOK, locationConstraint{Xmlns: xmlNamespace, Region: region})
+	case r.Method == http.MethodGet && len(unsupported(query, "versions", "uploads", "acl", "policy", "tagging", "lifecycle", "cors")) == 0:
+		h.listObjects(w, r)
+	default:
+		writeError(w, r, fmt.Errorf("%w: this bucket operation", ErrNotImplemented))
+	}
+}
+
+func (h *Handler) serveObject(w http.ResponseWriter, r *http.Request, key string, body io.Reader) {
+	if names := unsupported(r.URL.Query(), "uploads", "uploadId", "partNumber", "acl", "tagging", "retention", "legal-hold", "versionId"); len(names) > 0 {
+		writeError(w, r, fmt.Errorf("%w: ?%s", ErrNotImplemented, names[0]))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.getObject(w, r, key)
+	case http.MethodHead:
+		h.headObject(w, r, key)
+	case http.MethodPut:
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			writeError(w, r, fmt.Errorf("%w: CopyObject", ErrNotImplemented))
+			return
+		}
+		h.putObject(w, r, key, body)
+	case http.MethodDelete:
+		if err := h.service.DeleteFile(r.Context(), key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, fmt.Errorf("%w: %s on objects", ErrNotImplemented, r.Method))
+	}
+}
+
+func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	data, err := h.service.ReadFile(r.Context(), key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setObjectHeaders(w, data.Metadata)
+	// ServeContent handles Range and conditional requests
+	http.ServeContent(w, r, "", data.Metadata.Updated, bytes.NewReader(data.Content))
+}
+
+func (h *Handler) headObject(w http.ResponseWriter, r *http.Request, key string) {
+	file, err := h.service.StatFile(r.Context(), key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	setObjectHeaders(w, *file)
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) putObject(w http.ResponseWriter, r *http.Request, key string, body io.Reader) {
+	size := r.ContentLength
+	if decoded := r.Header.Get("X-Amz-Decoded-Content-Length"); decoded != "" {
+		size, _ = strconv.ParseInt(decoded, 10, 64)
+	}
+	if size > h.maxUploadBytes {
+		writeError(w, r, fmt.Errorf("%w: the limit is %d bytes", ErrEntityTooLarge, h.maxUploadBytes))
+		return
+	}
+	body = &limitReader{Reader: body, remaining: h.maxUploadBytes}
+
+	if encoded := r.Header.Get("Content-MD5"); encoded != "" {
+		expected, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(expected) != md5.Size {
+			writeError(w, r, fmt.Errorf("%w: Content-MD5 must be a base64 MD5 digest", ErrInvalidArgument))
+			return
+		}
+		body = &digestReader{Reader: body, hash: md5.New(), expected: expected, mismatch: ErrBadDigest}
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = storage.DetectContentType(key)
+	}
+	metadata := make(map[string]string)
+	for name, values := range r.Header {
+		if field, ok := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); ok {
+			metadata[field] = values[0]
+		}
+	}
+
+	// A failed read of the body aborts the write; keep its error for the response
+	recorder := &errorRecorder{Reader: body}
+	response, err := h.service.WriteFiles(r.Context(), []storage.WriteRequest{{
+		Path:        key,
+		Content:     recorder,
+		ContentType: contentType,
+		Metadata:    metadata,
+	}})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(response.FilesWritten) == 0 {
+		switch {
+		case recorder.err != nil:
+			err = recorder.err
+		case len(response.Errors) > 0:
+			err = errors.New(response.Errors[0].Error)
+		default:
+			err = errors.New("no object was written")
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(response.FilesWritten[0]))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) listBuckets(w http.ResponseWriter) {
+	writeXML(w, http.StatusOK, listAllMyBucketsResult{
+		Xmlns:   xmlNamespace,
+		Owner:   owner{ID: "gcs-proxy", DisplayName: "gcs-proxy"},
+		Buckets: []bucketEntry{{Name: h.bucket, CreationDate: h.created}},
+	})
+}
+
+// listObjects serves ListObjectsV2 (list-type=2) and the original ListObjects
+func (h *Handler) listObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	v2 := query.Get("list-type") == "2"
+
+	maxKeys := maxListKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, r, fmt.Errorf("%w: max-keys must be a non-negative integer", ErrInvalidArgument))
+			return
+		}
+		maxKeys = min(n, maxListKeys)
+	}
+
+	after := query.Get("marker")
+	if v2 {
+		after = query.Get("start-after")
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				writeError(w, r, fmt.Errorf("%w: invalid continuation-token", ErrInvalidArgument))
+				return
+			}
+			after = string(decoded)
+		}
+	}
+
+	files, err := h.service.ListFiles(r.Context(), prefix)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	page := paginate(files, prefix, delimiter, after, maxKeys)
+
+	encode := func(s string) string { return s }
+	if query.Get("encoding-type") == "url" {
+		encode = url.QueryEscape
+	}
+	result := listBucketResult{
+		Xmlns:        xmlNamespace,
+		Name:         h.bucket,
+		Prefix:       encode(prefix),
+		Delimiter:    encode(delimiter),
+		MaxKeys:      maxKeys,
+		IsTruncated:  page.truncated,
+		EncodingType: query.Get("encoding-type"),
+	}
+	for _, file := range page.files {
+		result.Contents = append(result.Contents, objectEntry{
+			Key:          encode(file.Name),
+			LastModified: file.Updated.UTC(),
+			ETag:         etag(file),
+			Size:         file.Size,
+			StorageClass: s3StorageClass(file.StorageClass),
+		})
+	}
+	for _, commonPrefix := range page.prefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, prefixEntry{Prefix: encode(commonPrefix)})
+	}
+
+	if v2 {
+		keyCount := len(result.Contents) + len(result.CommonPrefixes)
+		result.KeyCount = &keyCount
+		result.ContinuationToken = query.Get("continuation-token")
+		result.StartAfter = encode(query.Get("start-after"))
+		if page.truncated {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(page.last))
+		}
+	} else {
+		result.Marker = encode(query.Get("marker"))
+		// Without a delimiter, clients continue from the last key themselves
+		if page.truncated && delimiter != "" {
+			result.NextMarker = encode(page.last)
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// listPage is one page of a listing. last is the key or common prefix to continue after.
+type listPage struct {
+	files     []storage.FileMetadata
+	prefixes  []string
+	truncated bool
+	last      string
+}
+
+// paginate groups keys by delimiter into common prefixes and returns up to maxKeys
+// entries after the given key. files must be sorted and all start with prefix.
+func paginate(files []storage.FileMetadata, prefix, delimiter, after string, maxKeys int) listPage {
+	var page listPage
+	count := 0
+	for _, file := range files {
+		if file.Name <= after {
+			continue
+		}
+
+		entry := file.Name
+		commonPrefix := ""
+		if delimiter != "" {
+			if i := strings.Index(file.Name[len(prefix):], delimiter); i >= 0 {
+				commonPrefix = file.Name[:len(prefix)+i+len(delimiter)]
+				// Keys of a prefix are adjacent; the prefix was returned already or ended the previous page
+				if commonPrefix == after || (len(page.prefixes) > 0 && page.prefixes[len(page.prefixes)-1] == commonPrefix) {
+					continue
+				}
+				entry = commonPrefix
+			}
+		}
+
+		if count == maxKeys {
+			page.truncated = true
+			break
+		}
+		count++
+		page.last = entry
+		if commonPrefix != "" {
+			page.prefixes = append(page.prefixes, commonPrefix)
+		} else {
+			page.files = append(page.files, file)
+		}
+	}
+	return page
+}
+
+// unsupported returns the sub-resources in query that are not implemented
+func unsupported(query url.Values, names ...string) []string {
+	var found []string
+	for _, name := range names {
+		if query.Has(name) {
+			found = append(found, name)
+		}
+	}
+	return found
+}
+
+func setObjectHeaders(w http.ResponseWriter, file storage.FileMetadata) {
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("ETag", etag(file))
+	w.Header().Set("Last-Modified", file.Updated.UTC().Format(http.TimeFormat))
+	w.Header().Set("X-Amz-Storage-Class", s3StorageClass(file.StorageClass))
+}
+
+// etag returns the MD5 of the content like S3 does for single part uploads. Composite
+// objects have no MD5 and get a multipart-style ETag based on their generation.
+func etag(file storage.FileMetadata) string {
+	if file.MD5 != "" {
+		return `"` + file.MD5 + `"`
+	}
+	return fmt.Sprintf(`"%x-1"`, file.Generation)
+}
+
+// s3StorageClass maps GCS storage classes to their closest S3 equivalent
+func s3StorageClass(storageClass string) string {
+	switch storageClass {
+	case "NEARLINE":
+		return "STANDARD_IA"
+	case "COLDLINE":
+		return "GLACIER_IR"
+	case "ARCHIVE":
+		return "DEEP_ARCHIVE"
+	default:
+		return "STANDARD"
+	}
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
+
+// limitReader fails with ErrEntityTooLarge once more than remaining bytes are read
+type limitReader struct {
+	io.Reader
+	remaining int64
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, ErrEntityTooLarge
+	}
+	return n, err
+}
+
+// errorRecorder keeps the first read error other than io.EOF
+type errorRecorder struct {
+	io.Reader
+	err error
+}
+
+func (r *errorRecorder) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Region  string   `xml:",chardata"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Owner   owner         `xml:"Owner"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketEntry struct {
+	Name         string    `xml:"Name"`
+	CreationDate time.Time `xml:"CreationDate"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name      `xml:"ListBucketResult"`
+	Xmlns                 string        `xml:"xmlns,attr"`
+	Name                  string        `xml:"Name"`
+	Prefix                string        `xml:"Prefix"`
+	Delimiter             string        `xml:"Delimiter,omitempty"`
+	Marker                string        `xml:"Marker,omitempty"`
+	NextMarker            string        `xml:"NextMarker,omitempty"`
+	StartAfter            string        `xml:"StartAfter,omitempty"`
+	ContinuationToken     string        `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string        `xml:"NextContinuationToken,omitempty"`
+	KeyCount              *int          `xml:"KeyCount,omitempty"`
+	MaxKeys               int           `xml:"MaxKeys"`
+	EncodingType          string        `xml:"EncodingType,omitempty"`
+	IsTruncated           bool          `xml:"IsTruncated"`
+	Contents              []objectEntry `xml:"Contents"`
+	CommonPrefixes        []prefixEntry `xml:"CommonPrefixes"`
+}
+
+type objectEntry struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+type prefixEntry struct {
+	Prefix string `xml:"Prefix"`
+}
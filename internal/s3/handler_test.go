@@ -0,0 +1,175 @@
+package s3
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/storage"
+)
+
+func TestPaginate(t *testing.T) {
+	files := []storage.FileMetadata{
+		{Name: "a.txt"},
+		{Name: "photos/2024/1.jpg"},
+		{Name: "photos/2024/2.jpg"},
+		{Name: "photos/2025/1.jpg"},
+		{Name: "readme.md"},
+		{Name: "videos/1.mp4"},
+	}
+
+	tests := []struct {
+		name         string
+		prefix       string
+		delimiter    string
+		after        string
+		maxKeys      int
+		wantFiles    []string
+		wantPrefixes []string
+		wantLast     string
+		truncated    bool
+	}{
+		{
+			name:      "flat listing",
+			maxKeys:   1000,
+			wantFiles: []string{"a.txt", "photos/2024/1.jpg", "photos/2024/2.jpg", "photos/2025/1.jpg", "readme.md", "videos/1.mp4"},
+			wantLast:  "videos/1.mp4",
+		},
+		{
+			name:         "delimiter groups common prefixes",
+			delimiter:    "/",
+			maxKeys:      1000,
+			wantFiles:    []string{"a.txt", "readme.md"},
+			wantPrefixes: []string{"photos/", "videos/"},
+			wantLast:     "videos/",
+		},
+		{
+			name:         "page ends on a common prefix",
+			delimiter:    "/",
+			maxKeys:      2,
+			wantFiles:    []string{"a.txt"},
+			wantPrefixes: []string{"photos/"},
+			wantLast:     "photos/",
+			truncated:    true,
+		},
+		{
+			name:         "continuing after a common prefix skips its keys",
+			delimiter:    "/",
+			after:        "photos/",
+			maxKeys:      2,
+			wantFiles:    []string{"readme.md"},
+			wantPrefixes: []string{"videos/"},
+			wantLast:     "videos/",
+		},
+		{
+			name:         "nested prefix",
+			prefix:       "photos/",
+			delimiter:    "/",
+			maxKeys:      1000,
+			wantPrefixes: []string{"photos/2024/", "photos/2025/"},
+			wantLast:     "photos/2025/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var listed []storage.FileMetadata
+			for _, file := range files {
+				if strings.HasPrefix(file.Name, tt.prefix) {
+					listed = append(listed, file)
+				}
+			}
+
+			page := paginate(listed, tt.prefix, tt.delimiter, tt.after, tt.maxKeys)
+
+			var names []string
+			for _, file := range page.files {
+				names = append(names, file.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantFiles) {
+				t.Errorf("Expected files %v, got %v", tt.wantFiles, names)
+			}
+			if !reflect.DeepEqual(page.prefixes, tt.wantPrefixes) {
+				t.Errorf("Expected prefixes %v, got %v", tt.wantPrefixes, page.prefixes)
+			}
+			if page.last != tt.wantLast || page.truncated != tt.truncated {
+				t.Errorf("Expected last %q truncated %v, got %q %v", tt.wantLast, tt.truncated, page.last, page.truncated)
+			}
+		})
+	}
+}
+
+func TestAction(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		want   policy.Target
+		ok     bool
+	}{
+		{method: "GET", target: "/", ok: false},
+		{method: "GET", target: "/other/a.txt", ok: false},
+		{method: "HEAD", target: "/media", ok: false},
+		{method: "GET", target: "/media?location", ok: false},
+		{method: "GET", target: "/media?list-type=2&prefix=photos/", want: policy.Target{Action: policy.ActionList, Path: "photos/"}, ok: true},
+		{method: "GET", target: "/media/photos/1.jpg", want: policy.Target{Action: policy.ActionRead, Path: "photos/1.jpg"}, ok: true},
+		{method: "HEAD", target: "/media/photos/1.jpg", want: policy.Target{Action: policy.ActionRead, Path: "photos/1.jpg"}, ok: true},
+		{method: "PUT", target: "/media/photos/1.jpg", want: policy.Target{Action: policy.ActionWrite, Path: "photos/1.jpg"}, ok: true},
+		{method: "DELETE", target: "/media/photos/1.jpg", want: policy.Target{Action: policy.ActionDelete, Path: "photos/1.jpg"}, ok: true},
+	}
+
+	action := Action("media")
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			got, ok := action(httptest.NewRequest(tt.method, tt.target, nil))
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("Expected %+v, %v, got %+v, %v", tt.want, tt.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestHandler_Policy(t *testing.T) {
+	engine := policy.New(nil)
+	err := engine.SetRules("test", []policy.Rule{
+		{Principals: []string{"AKIDEXAMPLE"}, Actions: []string{"*"}, Paths: []string{"public/**"}, Effect: policy.EffectAllow},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h := NewHandler(service.NewStorageService(storage.NewFSStorage(t.TempDir())), NewVerifier("us-east-1", map[string]string{"AKIDEXAMPLE": "secret"}), "media", "us-east-1", 1<<20)
+	h.Use(engine.MiddlewareFor(Action("media")))
+
+	tests := []struct {
+		method     string
+		key        string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{method: http.MethodPut, key: "public/a.txt", body: "hello", wantStatus: http.StatusOK},
+		{method: http.MethodGet, key: "public/a.txt", wantStatus: http.StatusOK, wantBody: "hello"},
+		{method: http.MethodPut, key: "private/a.txt", body: "hello", wantStatus: http.StatusForbidden},
+		{method: http.MethodGet, key: "private/a.txt", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.key, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://localhost/media/"+tt.key, strings.NewReader(tt.body))
+			SignRequest(r, "AKIDEXAMPLE", "secret", "us-east-1", time.Now())
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if body, _ := io.ReadAll(w.Body); tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("Expected body %q, got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
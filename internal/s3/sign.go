@@ -0,0 +1,53 @@
+package s3
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SignRequest signs a request to an S3-compatible service with AWS Signature
+// Version 4, for replicating to it. The body is left unsigned so it can be
+// streamed, which S3 only accepts over HTTPS.
+func SignRequest(r *http.Request, accessKey, secret, region string, now time.Time) {
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+	// The path is sent encoded the way it is signed
+	r.URL.RawPath = uriEncode(r.URL.Path, false)
+
+	sig := &signature{
+		accessKey:   accessKey,
+		region:      region,
+		service:     "s3",
+		terminator:  "aws4_request",
+		amzDate:     now.UTC().Format(amzDateFormat),
+		payloadHash: unsignedPayload,
+	}
+	sig.date = sig.amzDate[:8]
+	r.Header.Set("X-Amz-Date", sig.amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", sig.payloadHash)
+
+	// S3 requires every x-amz-* header to be signed
+	sig.signedHeaders = []string{"host"}
+	for name := range r.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" || name == "content-md5" {
+			sig.signedHeaders = append(sig.signedHeaders, name)
+		}
+	}
+	slices.Sort(sig.signedHeaders)
+
+	key := signingKey(secret, sig.date, sig.region, sig.service)
+	stringToSign := strings.Join([]string{
+		signingAlgorithm,
+		sig.amzDate,
+		sig.scope(),
+		hexSHA256([]byte(canonicalRequest(r, sig))),
+	}, "\n")
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		signingAlgorithm, accessKey, sig.scope(), strings.Join(sig.signedHeaders, ";"), hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
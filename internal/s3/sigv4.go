@@ -0,0 +1,334 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat    = "20060102T150405Z"
+
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	maxClockSkew     = 15 * time.Minute
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
+var (
+	sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	spaces        = regexp.MustCompile(` +`)
+	emptySHA256   = hexSHA256(nil)
+)
+
+// Verifier checks AWS Signature Version 4 signatures, from the Authorization
+// header or a presigned URL, against a set of access keys
+type Verifier struct {
+	region string
+	now    func() time.Time
+
+	mu   sync.RWMutex
+	keys map[string]string
+}
+
+// NewVerifier creates a verifier for requests signed for region. keys maps access key IDs to secrets.
+func NewVerifier(region string, keys map[string]string) *Verifier {
+	v := &Verifier{region: region, now: time.Now}
+	v.SetKeys(keys)
+	return v
+}
+
+// SetKeys replaces the accepted access keys
+func (v *Verifier) SetKeys(keys map[string]string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys = keys
+}
+
+func (v *Verifier) secret(accessKey string) (string, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	secret, ok := v.keys[accessKey]
+	return secret, ok
+}
+
+// signature holds the parsed signing parameters of a request
+type signature struct {
+	accessKey     string
+	date          string
+	region        string
+	service       string
+	terminator    string
+	amzDate       string
+	signedHeaders []string
+	signature     string
+	payloadHash   string
+	presigned     bool
+}
+
+func (s *signature) scope() string {
+	return strings.Join([]string{s.date, s.region, s.service, s.terminator}, "/")
+}
+
+// Verify authenticates the request and returns its access key ID and body.
+// aws-chunked bodies are decoded, and the content is checked against the
+// signed payload hash or chunk signatures while it is read; a mismatch is
+// returned as the final read error.
+func (v *Verifier) Verify(r *http.Request) (accessKey string, body io.Reader, err error) {
+	var sig *signature
+	switch {
+	case r.URL.Query().Has("X-Amz-Signature"):
+		sig, err = v.parsePresigned(r)
+	case r.Header.Get("Authorization") != "":
+		sig, err = v.parseHeader(r)
+	default:
+		return "", nil, fmt.Errorf("%w: the request is not signed", ErrAccessDenied)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	if sig.region != v.region || sig.service != "s3" || sig.terminator != "aws4_request" || sig.date != sig.amzDate[:8] {
+		return "", nil, fmt.Errorf("%w: credential scope must be <date>/%s/s3/aws4_request", ErrAuthorizationHeaderMalformed, v.region)
+	}
+	if !slices.Contains(sig.signedHeaders, "host") {
+		return "", nil, fmt.Errorf("%w: the host header must be signed", ErrAuthorizationHeaderMalformed)
+	}
+	secret, ok := v.secret(sig.accessKey)
+	if !ok {
+		return "", nil, ErrInvalidAccessKeyID
+	}
+
+	key := signingKey(secret, sig.date, sig.region, sig.service)
+	stringToSign := strings.Join([]string{
+		signingAlgorithm,
+		sig.amzDate,
+		sig.scope(),
+		hexSHA256([]byte(canonicalRequest(r, sig))),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return "", nil, ErrSignatureDoesNotMatch
+	}
+
+	switch {
+	case sig.payloadHash == unsignedPayload:
+		return sig.accessKey, r.Body, nil
+	case sig.payloadHash == streamingPayload:
+		return sig.accessKey, newChunkedReader(r.Body, &chunkSigner{
+			key:      key,
+			amzDate:  sig.amzDate,
+			scope:    sig.scope(),
+			previous: sig.signature,
+		}), nil
+	case sig.payloadHash == streamingUnsignedTrailer:
+		return sig.accessKey, newChunkedReader(r.Body, nil), nil
+	case strings.HasPrefix(sig.payloadHash, "STREAMING-"):
+		return "", nil, fmt.Errorf("%w: payload signing mode %s", ErrNotImplemented, sig.payloadHash)
+	case sha256Pattern.MatchString(sig.payloadHash):
+		expected, _ := hex.DecodeString(sig.payloadHash)
+		return sig.accessKey, &digestReader{Reader: r.Body, hash: sha256.New(), expected: expected, mismatch: ErrContentSHA256Mismatch}, nil
+	default:
+		return "", nil, fmt.Errorf("%w: x-amz-content-sha256 must be a SHA-256, %s or a streaming mode", ErrInvalidArgument, unsignedPayload)
+	}
+}
+
+// parseHeader reads the signing parameters from the Authorization and X-Amz-* headers
+func (v *Verifier) parseHeader(r *http.Request) (*signature, error) {
+	fields, ok := strings.CutPrefix(r.Header.Get("Authorization"), signingAlgorithm+" ")
+	if !ok {
+		return nil, fmt.Errorf("%w: only %s is supported", ErrAuthorizationHeaderMalformed, signingAlgorithm)
+	}
+
+	sig := &signature{}
+	for _, field := range strings.Split(fields, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch name {
+		case "Credential":
+			if !sig.parseCredential(value) {
+				return nil, fmt.Errorf("%w: invalid credential %q", ErrAuthorizationHeaderMalformed, value)
+			}
+		case "SignedHeaders":
+			sig.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			sig.signature = value
+		}
+	}
+	if sig.accessKey == "" || len(sig.signedHeaders) == 0 || sig.signature == "" {
+		return nil, fmt.Errorf("%w: Credential, SignedHeaders and Signature are required", ErrAuthorizationHeaderMalformed)
+	}
+
+	sig.amzDate = r.Header.Get("X-Amz-Date")
+	signed, err := time.Parse(amzDateFormat, sig.amzDate)
+	if err != nil {
+		return nil, fmt.Errorf("%w: X-Amz-Date must be set as %s", ErrAccessDenied, amzDateFormat)
+	}
+	if skew := v.now().Sub(signed); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, ErrRequestTimeTooSkewed
+	}
+
+	sig.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if sig.payloadHash == "" {
+		return nil, fmt.Errorf("%w: the x-amz-content-sha256 header is required", ErrInvalidArgument)
+	}
+	return sig, nil
+}
+
+// parsePresigned reads the signing parameters from the query string of a presigned URL
+func (v *Verifier) parsePresigned(r *http.Request) (*signature, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != signingAlgorithm {
+		return nil, fmt.Errorf("%w: only %s is supported", ErrAuthorizationHeaderMalformed, signingAlgorithm)
+	}
+
+	sig := &signature{
+		amzDate:       query.Get("X-Amz-Date"),
+		signedHeaders: strings.Split(query.Get("X-Amz-SignedHeaders"), ";"),
+		signature:     query.Get("X-Amz-Signature"),
+		payloadHash:   unsignedPayload,
+		presigned:     true,
+	}
+	if !sig.parseCredential(query.Get("X-Amz-Credential")) {
+		return nil, fmt.Errorf("%w: invalid X-Amz-Credential", ErrAuthorizationHeaderMalformed)
+	}
+	if hash := query.Get("X-Amz-Content-Sha256"); hash != "" {
+		sig.payloadHash = hash
+	}
+
+	signed, err := time.Parse(amzDateFormat, sig.amzDate)
+	if err != nil {
+		return nil, fmt.Errorf("%w: X-Amz-Date must be set as %s", ErrAccessDenied, amzDateFormat)
+	}
+	seconds, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	expires := time.Duration(seconds) * time.Second
+	if err != nil || expires <= 0 || expires > maxPresignExpiry {
+		return nil, fmt.Errorf("%w: X-Amz-Expires must be between 1 and %d seconds", ErrAuthorizationHeaderMalformed, int(maxPresignExpiry.Seconds()))
+	}
+	now := v.now()
+	if now.Before(signed.Add(-maxClockSkew)) {
+		return nil, ErrRequestTimeTooSkewed
+	}
+	if now.After(signed.Add(expires)) {
+		return nil, ErrRequestExpired
+	}
+	return sig, nil
+}
+
+// parseCredential parses <access key>/<date>/<region>/<service>/aws4_request
+func (s *signature) parseCredential(credential string) bool {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[0] == "" || len(parts[1]) != 8 {
+		return false
+	}
+	s.accessKey, s.date, s.region, s.service, s.terminator = parts[0], parts[1], parts[2], parts[3], parts[4]
+	return true
+}
+
+// canonicalRequest builds the canonical form of the request that the client signed
+func canonicalRequest(r *http.Request, sig *signature) string {
+	query := r.URL.Query()
+	if sig.presigned {
+		query.Del("X-Amz-Signature")
+	}
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	slices.Sort(params)
+
+	var headers strings.Builder
+	for _, name := range sig.signedHeaders {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = r.Header.Get("Content-Length")
+			if value == "" && r.ContentLength >= 0 {
+				value = strconv.FormatInt(r.ContentLength, 10)
+			}
+		default:
+			values := slices.Clone(r.Header.Values(name))
+			for i, v := range values {
+				values[i] = spaces.ReplaceAllString(strings.TrimSpace(v), " ")
+			}
+			value = strings.Join(values, ",")
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		strings.Join(params, "&"),
+		headers.String(),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+}
+
+// uriEncode percent-encodes everything except unreserved characters, and slashes unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// digestReader returns mismatch instead of io.EOF if the content's digest differs from expected
+type digestReader struct {
+	io.Reader
+	hash     hash.Hash
+	expected []byte
+	mismatch error
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(r.hash.Sum(nil), r.expected) {
+		return n, r.mismatch
+	}
+	return n, err
+}
@@ -0,0 +1,189 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a task runs
+type Schedule interface {
+	// Next returns the first time after t the task runs, or the zero time if it never does
+	Next(t time.Time) time.Time
+}
+
+// Every runs a task at a fixed interval
+type Every time.Duration
+
+// Next returns t plus the interval
+func (e Every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// descriptors are the shorthands accepted in place of the five cron fields
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field is the range of values and names of a cron field
+type field struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var (
+	minutes  = field{name: "minute", min: 0, max: 59}
+	hours    = field{name: "hour", min: 0, max: 23}
+	days     = field{name: "day of month", min: 1, max: 31}
+	months   = field{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	weekdays = field{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// cron matches times against the bit sets of the values allowed in each field
+type cron struct {
+	minute, hour, day, month, weekday uint64
+	// Restricting both days of month and of week runs on either, like cron does
+	anyDay, anyWeekday bool
+}
+
+// Parse reads a cron expression of five fields (minute, hour, day of month,
+// month and day of week, with *, lists, ranges, steps and names), one of the
+// @hourly, @daily, @weekly, @monthly and @yearly shorthands, or "@every"
+// followed by a duration. Cron expressions run in the time zone of the times
+// they are given.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if interval, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%w: %q needs a positive duration", ErrInvalidSchedule, spec)
+		}
+		return Every(d), nil
+	}
+	if expanded, ok := descriptors[spec]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: %q needs 5 fields, got %d", ErrInvalidSchedule, spec, len(fields))
+	}
+	var c cron
+	var err error
+	for i, f := range []struct {
+		bits *uint64
+		field
+	}{{&c.minute, minutes}, {&c.hour, hours}, {&c.day, days}, {&c.month, months}, {&c.weekday, weekdays}} {
+		if *f.bits, err = parseField(fields[i], f.field); err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidSchedule, spec, err)
+		}
+	}
+	// Sunday is both 0 and 7
+	if c.weekday&(1<<7) != 0 {
+		c.weekday |= 1
+	}
+	c.anyDay = fields[2] == "*" || strings.HasPrefix(fields[2], "*/")
+	c.anyWeekday = fields[4] == "*" || strings.HasPrefix(fields[4], "*/")
+
+	if c.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("%w: %q never runs", ErrInvalidSchedule, spec)
+	}
+	return c, nil
+}
+
+// parseField returns the bit set of the values a comma separated list of
+// ranges allows
+func parseField(expr string, f field) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepExpr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepExpr, f.name)
+			}
+		}
+
+		low, high := f.min, f.max
+		if rangeExpr != "*" {
+			lowExpr, highExpr, isRange := strings.Cut(rangeExpr, "-")
+			var err error
+			if low, err = f.value(lowExpr); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = f.value(highExpr); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				high = f.max
+			}
+			if low > high {
+				return 0, fmt.Errorf("empty range %q in %s", rangeExpr, f.name)
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// value parses a number or name within the field's range
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return i + f.min, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%q is not a %s between %d and %d", s, f.name, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first minute after t matching every field. Fields that
+// don't match skip to the start of the next month, day or hour, so few
+// candidates are tried; none within five years means the schedule never runs.
+func (c cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches checks the day of month and of week. When both are restricted,
+// either matching is enough.
+func (c cron) dayMatches(t time.Time) bool {
+	day := c.day&(1<<t.Day()) != 0
+	weekday := c.weekday&(1<<int(t.Weekday())) != 0
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
@@ -0,0 +1,7 @@
+package scheduler
+
+import "errors"
+
+var (
+	ErrInvalidSchedule = errors.New("invalid schedule")
+)
@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gcp-proxy-mity/internal/metrics"
+)
+
+// Task is work run on a schedule
+type Task struct {
+	Name     string
+	Schedule Schedule
+	Run      func(ctx context.Context) error
+}
+
+// Leader tells whether this replica is the one running scheduled tasks
+type Leader interface {
+	IsLeader() bool
+}
+
+// task is a scheduled task with its metrics
+type task struct {
+	Task
+	runs     *metrics.Counter
+	failures *metrics.Counter
+	skipped  *metrics.Counter
+	// lastSuccess is the Unix time the task last succeeded
+	lastSuccess atomic.Int64
+}
+
+// Scheduler runs tasks on their schedules. Each run is delayed by a random
+// jitter so that tasks due at the same time, on one replica or several, don't
+// all start at once.
+type Scheduler struct {
+	jitter time.Duration
+	leader Leader
+	tasks  []*task
+}
+
+// New creates a scheduler delaying runs by up to jitter. With a leader, only
+// the replica leading runs the tasks and the others skip their runs; with nil,
+// every replica runs them.
+func New(jitter time.Duration, leader Leader) *Scheduler {
+	return &Scheduler{jitter: jitter, leader: leader}
+}
+
+// Add schedules a task before Run is called. Its runs, failures and runs
+// skipped by replicas that aren't leading are counted in
+// gcs_proxy_scheduled_{name}_runs_total, _failures_total and _skipped_total.
+func (s *Scheduler) Add(t Task) {
+	name := "gcs_proxy_scheduled_" + strings.ReplaceAll(t.Name, "-", "_")
+	entry := &task{
+		Task:     t,
+		runs:     metrics.NewCounter(name+"_runs_total", "Runs of the "+t.Name+" task"),
+		failures: metrics.NewCounter(name+"_failures_total", "Failed runs of the "+t.Name+" task"),
+		skipped:  metrics.NewCounter(name+"_skipped_total", "Runs of the "+t.Name+" task skipped because another replica leads"),
+	}
+	metrics.NewGaugeFunc(name+"_last_success_timestamp_seconds", "Unix time the "+t.Name+" task last succeeded", func() float64 {
+		return float64(entry.lastSuccess.Load())
+	})
+	s.tasks = append(s.tasks, entry)
+}
+
+// Run runs the tasks until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range s.tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, t)
+		}()
+	}
+	wg.Wait()
+}
+
+// loop waits for each run of t in turn. Runs follow the schedule rather than
+// the end of the previous run, and those missed while a run was too slow are
+// skipped.
+func (s *Scheduler) loop(ctx context.Context, t *task) {
+	due := time.Now()
+	for {
+		due = t.Schedule.Next(due)
+		if now := time.Now(); due.Before(now) {
+			due = t.Schedule.Next(now)
+		}
+		if due.IsZero() {
+			log.Printf("Scheduled task %s never runs again", t.Name)
+			return
+		}
+		wait := time.Until(due)
+		if s.jitter > 0 {
+			wait += rand.N(s.jitter)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.run(ctx, t)
+	}
+}
+
+// run runs t once unless another replica leads
+func (s *Scheduler) run(ctx context.Context, t *task) {
+	if s.leader != nil && !s.leader.IsLeader() {
+		t.skipped.Inc()
+		return
+	}
+	t.runs.Inc()
+	if err := t.Run(ctx); err != nil {
+		t.failures.Inc()
+		log.Printf("Scheduled task %s failed: %v", t.Name, err)
+		return
+	}
+	t.lastSuccess.Store(time.Now().Unix())
+}
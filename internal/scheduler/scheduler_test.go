@@ -0,0 +1,104 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2026, 10, 14, 10, 30, 20, 0, time.UTC)
+
+	tests := []struct {
+		spec     string
+		expected time.Time
+		wantErr  bool
+	}{
+		{spec: "* * * * *", expected: time.Date(2026, 10, 14, 10, 31, 0, 0, time.UTC)},
+		{spec: "*/15 * * * *", expected: time.Date(2026, 10, 14, 10, 45, 0, 0, time.UTC)},
+		{spec: "0 3 * * *", expected: time.Date(2026, 10, 15, 3, 0, 0, 0, time.UTC)},
+		{spec: "30 10 * * *", expected: time.Date(2026, 10, 15, 10, 30, 0, 0, time.UTC)},
+		{spec: "0 9-17/4 * * mon-fri", expected: time.Date(2026, 10, 14, 13, 0, 0, 0, time.UTC)},
+		{spec: "0 0 * * 0", expected: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 * * 7", expected: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 1 jan *", expected: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 13 * fri", expected: time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 29 2 *", expected: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{spec: "5,35 * * * *", expected: time.Date(2026, 10, 14, 10, 35, 0, 0, time.UTC)},
+		{spec: "@hourly", expected: time.Date(2026, 10, 14, 11, 0, 0, 0, time.UTC)},
+		{spec: "@monthly", expected: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "@every 90s", expected: from.Add(90 * time.Second)},
+		{spec: "@every 0s", wantErr: true},
+		{spec: "0 3 * *", wantErr: true},
+		{spec: "60 * * * *", wantErr: true},
+		{spec: "0 5-1 * * *", wantErr: true},
+		{spec: "*/0 * * * *", wantErr: true},
+		{spec: "0 0 * * funday", wantErr: true},
+		{spec: "0 0 30 2 *", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			schedule, err := Parse(tt.spec)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSchedule) {
+					t.Errorf("Expected ErrInvalidSchedule, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := schedule.Next(from); !got.Equal(tt.expected) {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+type fixedLeader bool
+
+func (l fixedLeader) IsLeader() bool {
+	return bool(l)
+}
+
+func TestScheduler_Run(t *testing.T) {
+	tests := []struct {
+		name    string
+		leader  Leader
+		expects bool
+	}{
+		{name: "without election", leader: nil, expects: true},
+		{name: "leading", leader: fixedLeader(true), expects: true},
+		{name: "following", leader: fixedLeader(false), expects: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var runs atomic.Int32
+			s := New(time.Millisecond, tt.leader)
+			s.Add(Task{Name: "test-task", Schedule: Every(5 * time.Millisecond), Run: func(ctx context.Context) error {
+				runs.Add(1)
+				return errors.New("failed")
+			}})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			s.Run(ctx)
+
+			task := s.tasks[0]
+			if ran := runs.Load() > 0; ran != tt.expects {
+				t.Fatalf("Expected the task to run: %v, got %d runs", tt.expects, runs.Load())
+			}
+			if tt.expects && (task.runs.Value() != int64(runs.Load()) || task.failures.Value() != task.runs.Value()) {
+				t.Errorf("Expected %d runs and failures counted, got %d and %d", runs.Load(), task.runs.Value(), task.failures.Value())
+			}
+			if !tt.expects && task.skipped.Value() == 0 {
+				t.Error("Expected the runs to be counted as skipped")
+			}
+		})
+	}
+}
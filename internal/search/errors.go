@@ -0,0 +1,7 @@
+package search
+
+import "errors"
+
+var (
+	ErrNotReady = errors.New("search index is still being built")
+)
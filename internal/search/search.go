@@ -0,0 +1,389 @@
+package search
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Weights of the places a term is found in
+const (
+	nameWeight     = 2
+	metadataWeight = 1
+)
+
+// Entry is an indexed object
+type Entry struct {
+	Name        string
+	ContentType string
+	Size        int64
+	Updated     time.Time
+	Metadata    map[string]string
+}
+
+// Query selects entries whose names or metadata values contain every word of Text.
+// Words match exactly, as a prefix of an indexed word or within a small edit distance.
+type Query struct {
+	Text string
+	// Exclude drops entries under any of these prefixes
+	Exclude []string
+	Offset  int
+	Limit   int
+}
+
+// Result is a page of matches, best first
+type Result struct {
+	Entries []Entry
+	// Total is the number of matches across all pages
+	Total int
+}
+
+// Lister lists the objects to index
+type Lister interface {
+	ListFiles(ctx context.Context, prefix string) ([]storage.FileMetadata, error)
+}
+
+// Index is an in-memory inverted index of object names and metadata values. It
+// is filled by listing the bucket and kept current by object events and bucket
+// notifications in between.
+type Index struct {
+	mu       sync.RWMutex
+	exclude  []string
+	entries  map[string]*Entry
+	postings map[string]map[string]int
+	ready    bool
+	// changes made while a rebuild lists the bucket, replayed onto its result; nil entries are removals
+	changes map[string]*Entry
+}
+
+// New creates an empty index. Objects under the excluded prefixes, like the
+// proxy's internal ones, are never indexed.
+func New(exclude []string) *Index {
+	return &Index{
+		exclude:  exclude,
+		entries:  make(map[string]*Entry),
+		postings: make(map[string]map[string]int),
+	}
+}
+
+// Len returns the number of indexed objects
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.entries)
+}
+
+// Put adds or replaces an entry
+func (x *Index) Put(entry Entry) {
+	if excluded(x.exclude, entry.Name) {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.changes != nil {
+		x.changes[entry.Name] = &entry
+	}
+	x.put(&entry)
+}
+
+// Remove drops the entry of an object
+func (x *Index) Remove(name string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.changes != nil {
+		x.changes[name] = nil
+	}
+	x.remove(name)
+}
+
+func (x *Index) put(entry *Entry) {
+	x.remove(entry.Name)
+	x.entries[entry.Name] = entry
+	for term, weight := range entryTerms(entry) {
+		names, ok := x.postings[term]
+		if !ok {
+			names = make(map[string]int)
+			x.postings[term] = names
+		}
+		names[entry.Name] = weight
+	}
+}
+
+func (x *Index) remove(name string) {
+	entry, ok := x.entries[name]
+	if !ok {
+		return
+	}
+	delete(x.entries, name)
+	for term := range entryTerms(entry) {
+		delete(x.postings[term], name)
+		if len(x.postings[term]) == 0 {
+			delete(x.postings, term)
+		}
+	}
+}
+
+// Rebuild replaces the index with a listing of the bucket. Metadata, which
+// listings don't carry, is kept for objects that weren't written since it was indexed.
+func (x *Index) Rebuild(ctx context.Context, lister Lister) error {
+	x.mu.Lock()
+	x.changes = make(map[string]*Entry)
+	x.mu.Unlock()
+
+	files, err := lister.ListFiles(ctx, "")
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	changes := x.changes
+	x.changes = nil
+	if err != nil {
+		return err
+	}
+
+	previous := x.entries
+	x.entries = make(map[string]*Entry, len(files))
+	x.postings = make(map[string]map[string]int)
+	for _, file := range files {
+		if excluded(x.exclude, file.Name) {
+			continue
+		}
+		entry := &Entry{Name: file.Name, ContentType: file.ContentType, Size: file.Size, Updated: file.Updated}
+		if prev, ok := previous[file.Name]; ok && !file.Updated.After(prev.Updated) {
+			entry.Metadata = prev.Metadata
+		}
+		x.put(entry)
+	}
+	for name, entry := range changes {
+		if entry == nil {
+			x.remove(name)
+		} else {
+			x.put(entry)
+		}
+	}
+	x.ready = true
+	return nil
+}
+
+// Sync rebuilds the index now and then every interval until ctx is done
+func (x *Index) Sync(ctx context.Context, lister Lister, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		if err := x.Rebuild(ctx, lister); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to rebuild search index: %v", err)
+		} else {
+			log.Printf("Search index rebuilt with %d objects in %s", x.Len(), time.Since(start).Round(time.Millisecond))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Publish applies an object event to the index. The index is updated in place,
+// so it is used as a publisher directly rather than through an async queue.
+func (x *Index) Publish(ctx context.Context, event events.Event) error {
+	switch event.Type {
+	case events.ObjectWritten:
+		x.Put(Entry{Name: event.Path, ContentType: event.ContentType, Size: event.Size, Updated: event.Time, Metadata: event.Metadata})
+	case events.ObjectDeleted:
+		x.Remove(event.Path)
+	}
+	return nil
+}
+
+// HandleNotification applies a Cloud Storage notification, catching changes made around the proxy
+func (x *Index) HandleNotification(ctx context.Context, notification events.ObjectNotification) error {
+	switch notification.Type {
+	case events.NotificationFinalize, events.NotificationMetadataUpdate:
+		x.Put(Entry{
+			Name:        notification.Path,
+			ContentType: notification.ContentType,
+			Size:        notification.Size,
+			Updated:     notification.Updated,
+			Metadata:    notification.Metadata,
+		})
+	case events.NotificationDelete:
+		// The replacing generation arrives as its own finalize notification
+		if !notification.Overwritten {
+			x.Remove(notification.Path)
+		}
+	}
+	return nil
+}
+
+// Search returns a page of the entries matching the query, best match first
+// and by name among equal matches
+func (x *Index) Search(query Query) (*Result, error) {
+	words := tokenize(query.Text)
+	if len(words) == 0 {
+		return &Result{Entries: []Entry{}}, nil
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if !x.ready {
+		return nil, ErrNotReady
+	}
+
+	// Every word must match; an entry scores the best match of each word
+	var scores map[string]float64
+	for _, word := range words {
+		matched := make(map[string]float64)
+		for term, names := range x.postings {
+			factor := matchFactor(word, term)
+			if factor == 0 {
+				continue
+			}
+			for name, weight := range names {
+				if scores != nil {
+					if _, ok := scores[name]; !ok {
+						continue
+					}
+				}
+				matched[name] = max(matched[name], factor*float64(weight))
+			}
+		}
+		for name, score := range matched {
+			matched[name] = score + scores[name]
+		}
+		scores = matched
+		if len(scores) == 0 {
+			break
+		}
+	}
+
+	type hit struct {
+		entry *Entry
+		score float64
+	}
+	hits := make([]hit, 0, len(scores))
+	for name, score := range scores {
+		if !excluded(query.Exclude, name) {
+			hits = append(hits, hit{entry: x.entries[name], score: score})
+		}
+	}
+	slices.SortFunc(hits, func(a, b hit) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		return strings.Compare(a.entry.Name, b.entry.Name)
+	})
+
+	result := &Result{Entries: make([]Entry, 0, min(query.Limit, len(hits))), Total: len(hits)}
+	for i := query.Offset; i < len(hits) && len(result.Entries) < query.Limit; i++ {
+		result.Entries = append(result.Entries, *hits[i].entry)
+	}
+	return result, nil
+}
+
+// matchFactor rates how well a query word matches an indexed term: 1 when equal,
+// less for prefixes and typos, 0 for no match
+func matchFactor(word, term string) float64 {
+	if word == term {
+		return 1
+	}
+	if len(word) >= 2 && strings.HasPrefix(term, word) {
+		return 0.75
+	}
+	allowed := maxDistance(word)
+	if allowed == 0 || abs(len(term)-len(word)) > allowed {
+		return 0
+	}
+	if distance := editDistance(word, term); distance <= allowed {
+		return 0.5 / float64(distance)
+	}
+	return 0
+}
+
+// maxDistance is the number of typos tolerated in a word; short words must match exactly
+func maxDistance(word string) int {
+	switch n := len([]rune(word)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance returns the number of insertions, deletions, substitutions and
+// swaps of adjacent characters turning a into b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// Rows i-2, i-1 and i of the distance matrix
+	before := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], before[j-2]+1)
+			}
+		}
+		before, prev, curr = prev, curr, before
+	}
+	return prev[len(rb)]
+}
+
+// entryTerms returns the terms of an entry with the weight of the best place they occur in
+func entryTerms(entry *Entry) map[string]int {
+	terms := make(map[string]int)
+	for _, term := range tokenize(entry.Name) {
+		terms[term] = nameWeight
+	}
+	for _, value := range entry.Metadata {
+		for _, term := range tokenize(value) {
+			terms[term] = max(terms[term], metadataWeight)
+		}
+	}
+	return terms
+}
+
+// tokenize splits text into lowercase words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func excluded(prefixes []string, name string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
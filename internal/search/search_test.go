@@ -0,0 +1,161 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/storage"
+)
+
+type mockLister struct {
+	files []storage.FileMetadata
+	// during runs while the listing is in progress
+	during func()
+}
+
+func (l *mockLister) ListFiles(ctx context.Context, prefix string) ([]storage.FileMetadata, error) {
+	if l.during != nil {
+		l.during()
+	}
+	return l.files, nil
+}
+
+func names(entries []Entry) []string {
+	out := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		out = append(out, entry.Name)
+	}
+	return out
+}
+
+func TestIndex_Search(t *testing.T) {
+	index := New([]string{"_tags/"})
+	lister := &mockLister{files: []storage.FileMetadata{
+		{Name: "videos/launch-intro.mp4"},
+		{Name: "videos/introduction.mp4"},
+		{Name: "videos/outro.mp4"},
+		{Name: "photos/launch/crew.jpg"},
+		{Name: "_tags/project/launch/videos/outro.mp4"},
+	}}
+	if err := index.Rebuild(context.Background(), lister); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	index.Put(Entry{Name: "docs/notes.txt", Metadata: map[string]string{"tag-project": "launch"}})
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+		total int
+	}{
+		{name: "exact before prefix", query: Query{Text: "intro", Limit: 10}, want: []string{"videos/launch-intro.mp4", "videos/introduction.mp4"}, total: 2},
+		{name: "all words", query: Query{Text: "launch intro", Limit: 10}, want: []string{"videos/launch-intro.mp4"}, total: 1},
+		{name: "typo", query: Query{Text: "lanuch", Limit: 10}, want: []string{"photos/launch/crew.jpg", "videos/launch-intro.mp4", "docs/notes.txt"}, total: 3},
+		{name: "case and punctuation", query: Query{Text: "CREW.JPG", Limit: 10}, want: []string{"photos/launch/crew.jpg"}, total: 1},
+		{name: "short words are exact", query: Query{Text: "mp", Limit: 10}, want: []string{"videos/introduction.mp4", "videos/launch-intro.mp4", "videos/outro.mp4"}, total: 3},
+		{name: "page", query: Query{Text: "mp4", Offset: 1, Limit: 1}, want: []string{"videos/launch-intro.mp4"}, total: 3},
+		{name: "excluded", query: Query{Text: "launch", Exclude: []string{"photos/"}, Limit: 10}, want: []string{"videos/launch-intro.mp4", "docs/notes.txt"}, total: 2},
+		{name: "no match", query: Query{Text: "podcast", Limit: 10}, want: []string{}, total: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := index.Search(tt.query)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := names(result.Entries); !reflect.DeepEqual(got, tt.want) || result.Total != tt.total {
+				t.Errorf("Expected %v of %d, got %v of %d", tt.want, tt.total, got, result.Total)
+			}
+		})
+	}
+}
+
+func TestIndex_NotReady(t *testing.T) {
+	index := New(nil)
+	index.Put(Entry{Name: "a.mp4"})
+	if _, err := index.Search(Query{Text: "a", Limit: 10}); !errors.Is(err, ErrNotReady) {
+		t.Errorf("Expected ErrNotReady, got %v", err)
+	}
+}
+
+func TestIndex_Rebuild(t *testing.T) {
+	written := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	index := New(nil)
+	index.Put(Entry{Name: "kept.jpg", Updated: written, Metadata: map[string]string{"tag-project": "apollo"}})
+	index.Put(Entry{Name: "rewritten.jpg", Updated: written, Metadata: map[string]string{"tag-project": "apollo"}})
+
+	lister := &mockLister{files: []storage.FileMetadata{
+		{Name: "kept.jpg", Updated: written},
+		{Name: "rewritten.jpg", Updated: written.Add(time.Hour)},
+		{Name: "deleted-meanwhile.jpg"},
+	}}
+	// Changes made while the bucket is listed must survive the rebuild
+	lister.during = func() {
+		index.Remove("deleted-meanwhile.jpg")
+		index.Put(Entry{Name: "written-meanwhile.jpg"})
+	}
+	if err := index.Rebuild(context.Background(), lister); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	result, err := index.Search(Query{Text: "jpg", Limit: 10})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := []string{"kept.jpg", "rewritten.jpg", "written-meanwhile.jpg"}; !reflect.DeepEqual(names(result.Entries), want) {
+		t.Errorf("Expected %v, got %v", want, names(result.Entries))
+	}
+
+	result, _ = index.Search(Query{Text: "apollo", Limit: 10})
+	if want := []string{"kept.jpg"}; !reflect.DeepEqual(names(result.Entries), want) {
+		t.Errorf("Expected metadata of unchanged objects only, got %v", names(result.Entries))
+	}
+}
+
+func TestIndex_Updates(t *testing.T) {
+	index := New([]string{"_jobs/"})
+	if err := index.Rebuild(context.Background(), &mockLister{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx := context.Background()
+	index.Publish(ctx, events.Event{Type: events.ObjectWritten, Path: "a.mp4"})
+	index.Publish(ctx, events.Event{Type: events.ObjectWritten, Path: "_jobs/result.json"})
+	index.HandleNotification(ctx, events.ObjectNotification{Type: events.NotificationFinalize, Path: "b.mp4"})
+	index.HandleNotification(ctx, events.ObjectNotification{Type: events.NotificationFinalize, Path: "c.mp4"})
+	index.HandleNotification(ctx, events.ObjectNotification{Type: events.NotificationDelete, Path: "b.mp4", Overwritten: true})
+	index.HandleNotification(ctx, events.ObjectNotification{Type: events.NotificationDelete, Path: "c.mp4"})
+	index.Publish(ctx, events.Event{Type: events.ObjectDeleted, Path: "a.mp4"})
+
+	if index.Len() != 1 {
+		t.Errorf("Expected 1 entry, got %d", index.Len())
+	}
+	result, _ := index.Search(Query{Text: "mp4", Limit: 10})
+	if want := []string{"b.mp4"}; !reflect.DeepEqual(names(result.Entries), want) {
+		t.Errorf("Expected %v, got %v", want, names(result.Entries))
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"launch", "launch", 0},
+		{"lanuch", "launch", 1},
+		{"intro", "outro", 2},
+		{"", "abc", 3},
+		{"café", "cafe", 1},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("Expected distance %d between %s and %s, got %d", tt.want, tt.a, tt.b, got)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package selfcheck
+
+import "errors"
+
+var (
+	ErrMissingPermissions = errors.New("missing permissions")
+	ErrContentMismatch    = errors.New("read back different content than written")
+	ErrNotListed          = errors.New("written object is not listed")
+)
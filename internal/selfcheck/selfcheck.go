@@ -0,0 +1,161 @@
+package selfcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"gcp-proxy-mity/pkg/storage/gcs"
+)
+
+// Permissions are the IAM permissions serving the bucket needs
+var Permissions = []string{
+	"storage.buckets.get",
+	"storage.objects.create",
+	"storage.objects.delete",
+	"storage.objects.get",
+	"storage.objects.list",
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Name     string
+	Detail   string
+	Err      error
+	Duration time.Duration
+}
+
+// Run times check and records its outcome as name
+func Run(name string, check func() (string, error)) Result {
+	start := time.Now()
+	detail, err := check()
+	return Result{Name: name, Detail: detail, Err: err, Duration: time.Since(start)}
+}
+
+// CheckBucket checks that the bucket exists, that the credentials hold the
+// Permissions on it, and then that a test object can be written under
+// prefix, read back, listed and deleted. Checks stop at the first failure,
+// but the test object is deleted whenever it was written. Emulators don't
+// support testing permissions, so it is skipped without testPermissions.
+func CheckBucket(ctx context.Context, client *gcs.Client, prefix string, testPermissions bool) []Result {
+	bucket := client.GetBucket()
+	results := []Result{Run("bucket", func() (string, error) {
+		attrs, err := bucket.Attrs(ctx)
+		if err != nil {
+			return "", err
+		}
+		detail := attrs.Name
+		if attrs.Location != "" {
+			detail += " in " + attrs.Location
+		}
+		if attrs.StorageClass != "" {
+			detail += ", " + attrs.StorageClass
+		}
+		return detail, nil
+	})}
+	if testPermissions && results[0].Err == nil {
+		results = append(results, Run("permissions", func() (string, error) {
+			granted, err := bucket.IAM().TestPermissions(ctx, Permissions)
+			if err != nil {
+				return "", err
+			}
+			var missing []string
+			for _, permission := range Permissions {
+				if !slices.Contains(granted, permission) {
+					missing = append(missing, permission)
+				}
+			}
+			if len(missing) > 0 {
+				return "", fmt.Errorf("%w: %s", ErrMissingPermissions, strings.Join(missing, ", "))
+			}
+			return strings.Join(Permissions, ", "), nil
+		}))
+	}
+	if failed(results) {
+		return results
+	}
+
+	name := prefix + "/" + newID()
+	obj := bucket.Object(name)
+	content := []byte("gcp-proxy-mity self-check " + time.Now().UTC().Format(time.RFC3339))
+	write := Run("write", func() (string, error) {
+		writer := obj.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+		writer.ContentType = "text/plain"
+		if _, err := writer.Write(content); err != nil {
+			writer.Close()
+			return "", err
+		}
+		if err := writer.Close(); err != nil {
+			return "", err
+		}
+		return name, nil
+	})
+	results = append(results, write)
+	if write.Err != nil {
+		return results
+	}
+
+	results = append(results, Run("read", func() (string, error) {
+		reader, err := obj.NewReader(ctx)
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		read, err := io.ReadAll(reader)
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(read, content) {
+			return "", ErrContentMismatch
+		}
+		return fmt.Sprintf("%d bytes", len(read)), nil
+	}))
+	if !failed(results) {
+		results = append(results, Run("list", func() (string, error) {
+			attrs, err := bucket.Objects(ctx, &storage.Query{Prefix: name}).Next()
+			if err == iterator.Done || err == nil && attrs.Name != name {
+				return "", ErrNotListed
+			}
+			if err != nil {
+				return "", err
+			}
+			return prefix + "/", nil
+		}))
+	}
+	// The test object is cleaned up even if reading it failed
+	return append(results, Run("delete", func() (string, error) {
+		return name, obj.Delete(ctx)
+	}))
+}
+
+// Print writes a line per result and reports whether all checks passed
+func Print(w io.Writer, results []Result) bool {
+	ok := true
+	for _, r := range results {
+		status, detail := "ok", r.Detail
+		if r.Err != nil {
+			status, detail, ok = "FAIL", r.Err.Error(), false
+		}
+		fmt.Fprintf(w, "%-4s  %-12s %8s  %s\n", status, r.Name, r.Duration.Round(time.Millisecond), detail)
+	}
+	return ok
+}
+
+func failed(results []Result) bool {
+	return slices.ContainsFunc(results, func(r Result) bool { return r.Err != nil })
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,90 @@
+package selfcheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+
+	"gcp-proxy-mity/pkg/storage/gcs"
+)
+
+func TestCheckBucket(t *testing.T) {
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme:     "http",
+		Host:       "127.0.0.1",
+		PublicHost: "127.0.0.1",
+	})
+	if err != nil {
+		t.Fatalf("Failed to start fake-gcs-server: %v", err)
+	}
+	defer server.Stop()
+	server.CreateBucket("test-bucket")
+
+	tests := []struct {
+		name     string
+		bucket   string
+		expected []string
+		failed   string
+	}{
+		{name: "usable bucket", bucket: "test-bucket", expected: []string{"bucket", "write", "read", "list", "delete"}},
+		{name: "missing bucket", bucket: "missing-bucket", expected: []string{"bucket"}, failed: "bucket"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := gcs.NewEmulatorClient(context.Background(), "test-project", tt.bucket, server.URL())
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			defer client.Close()
+
+			results := CheckBucket(context.Background(), client, "_selfcheck", false)
+			var names []string
+			for _, r := range results {
+				names = append(names, r.Name)
+				if (r.Err != nil) != (r.Name == tt.failed) {
+					t.Errorf("Expected %s to fail: %v, got %v", r.Name, r.Name == tt.failed, r.Err)
+				}
+			}
+			if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected checks %v, got %v", tt.expected, names)
+			}
+
+			objects, _, err := server.ListObjectsWithOptions("test-bucket", fakestorage.ListOptions{Prefix: "_selfcheck/"})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(objects) != 0 {
+				t.Errorf("Expected the test object to be deleted, got %d objects", len(objects))
+			}
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	tests := []struct {
+		name     string
+		results  []Result
+		expected bool
+		contains string
+	}{
+		{name: "all passed", results: []Result{{Name: "bucket", Detail: "test-bucket"}, {Name: "write"}}, expected: true, contains: "ok    bucket"},
+		{name: "one failed", results: []Result{{Name: "bucket"}, {Name: "write", Err: errors.New("403 Forbidden")}}, expected: false, contains: "FAIL  write"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if ok := Print(&out, tt.results); ok != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, ok)
+			}
+			if !strings.Contains(out.String(), tt.contains) {
+				t.Errorf("Expected output to contain %q, got %q", tt.contains, out.String())
+			}
+		})
+	}
+}
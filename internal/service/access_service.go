@@ -0,0 +1,23 @@
+package service
+
+import (
+	"context"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// GetAccess reports whether an object is public and its public URL if so
+func (s *StorageService) GetAccess(ctx context.Context, filePath string) (*storage.Access, error) {
+	return s.storage.GetAccess(ctx, filePath)
+}
+
+// SetAccess makes an object public-read or private. Quarantined objects can't
+// be made public.
+func (s *StorageService) SetAccess(ctx context.Context, filePath string, public bool) (*storage.Access, error) {
+	if public {
+		if err := s.checkQuarantine(ctx, filePath); err != nil {
+			return nil, err
+		}
+	}
+	return s.storage.SetAccess(ctx, filePath, public)
+}
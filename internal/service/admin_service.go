@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,61}[a-z0-9]$`)
+
+// AdminService provides bucket management for operators
+type AdminService struct {
+	buckets storage.BucketAdmin
+}
+
+// NewAdminService creates a new admin service
+func NewAdminService(buckets storage.BucketAdmin) *AdminService {
+	return &AdminService{
+		buckets: buckets,
+	}
+}
+
+// CreateBucket creates a bucket in the configured project
+func (s *AdminService) CreateBucket(ctx context.Context, name string, opts storage.BucketOptions) (*storage.BucketInfo, error) {
+	if !bucketNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w: bucket names are 3-63 lowercase letters, digits, dots, dashes and underscores", ErrInvalidBucket)
+	}
+	if opts.StorageClass != "" {
+		storageClass, err := normalizeStorageClass(opts.StorageClass)
+		if err != nil {
+			return nil, err
+		}
+		opts.StorageClass = storageClass
+	}
+	return s.buckets.CreateBucket(ctx, name, opts)
+}
+
+// ListBuckets lists the buckets in the configured project
+func (s *AdminService) ListBuckets(ctx context.Context) ([]storage.BucketInfo, error) {
+	return s.buckets.ListBuckets(ctx)
+}
+
+// GetBucket returns the attributes of a bucket
+func (s *AdminService) GetBucket(ctx context.Context, name string) (*storage.BucketInfo, error) {
+	return s.buckets.GetBucket(ctx, name)
+}
+
+// GetLifecycle returns the lifecycle rules of a bucket
+func (s *AdminService) GetLifecycle(ctx context.Context, name string) ([]storage.LifecycleRule, error) {
+	return s.buckets.GetLifecycle(ctx, name)
+}
+
+// SetLifecycle replaces the lifecycle rules of a bucket; no rules removes the configuration
+func (s *AdminService) SetLifecycle(ctx context.Context, name string, rules []storage.LifecycleRule) ([]storage.LifecycleRule, error) {
+	for i := range rules {
+		if err := validateLifecycleRule(&rules[i]); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return s.buckets.SetLifecycle(ctx, name, rules)
+}
+
+func validateLifecycleRule(rule *storage.LifecycleRule) error {
+	switch rule.Action {
+	case "Delete":
+		if rule.StorageClass != "" {
+			return fmt.Errorf("%w: storage_class is only valid with the SetStorageClass action", ErrInvalidLifecycle)
+		}
+	case "SetStorageClass":
+		storageClass, err := normalizeStorageClass(rule.StorageClass)
+		if err != nil {
+			return err
+		}
+		rule.StorageClass = storageClass
+	default:
+		return fmt.Errorf("%w: action %q must be Delete or SetStorageClass", ErrInvalidLifecycle, rule.Action)
+	}
+
+	if rule.AgeDays < 0 {
+		return fmt.Errorf("%w: age_days must not be negative", ErrInvalidLifecycle)
+	}
+	if rule.DaysSinceCustomTime < 0 {
+		return fmt.Errorf("%w: days_since_custom_time must not be negative", ErrInvalidLifecycle)
+	}
+	for i, storageClass := range rule.MatchesStorageClasses {
+		normalized, err := normalizeStorageClass(storageClass)
+		if err != nil {
+			return err
+		}
+		rule.MatchesStorageClasses[i] = normalized
+	}
+	return nil
+}
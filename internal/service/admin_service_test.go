@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// mockBucketAdmin records the lifecycle rules it is given
+type mockBucketAdmin struct {
+	rules []storage.LifecycleRule
+}
+
+func (m *mockBucketAdmin) CreateBucket(ctx context.Context, name string, opts storage.BucketOptions) (*storage.BucketInfo, error) {
+	return &storage.BucketInfo{Name: name, Location: opts.Location, StorageClass: opts.StorageClass}, nil
+}
+
+func (m *mockBucketAdmin) ListBuckets(ctx context.Context) ([]storage.BucketInfo, error) {
+	return nil, nil
+}
+
+func (m *mockBucketAdmin) GetBucket(ctx context.Context, name string) (*storage.BucketInfo, error) {
+	return &storage.BucketInfo{Name: name}, nil
+}
+
+func (m *mockBucketAdmin) GetLifecycle(ctx context.Context, name string) ([]storage.LifecycleRule, error) {
+	return m.rules, nil
+}
+
+func (m *mockBucketAdmin) SetLifecycle(ctx context.Context, name string, rules []storage.LifecycleRule) ([]storage.LifecycleRule, error) {
+	m.rules = rules
+	return rules, nil
+}
+
+func TestAdminService_CreateBucket(t *testing.T) {
+	service := NewAdminService(&mockBucketAdmin{})
+
+	tests := []struct {
+		name        string
+		bucket      string
+		opts        storage.BucketOptions
+		expectedErr error
+	}{
+		{name: "valid", bucket: "media-eu", opts: storage.BucketOptions{Location: "EU", StorageClass: "nearline"}},
+		{name: "invalid name", bucket: "Media", expectedErr: ErrInvalidBucket},
+		{name: "invalid storage class", bucket: "media-eu", opts: storage.BucketOptions{StorageClass: "cold"}, expectedErr: ErrInvalidStorageClass},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket, err := service.CreateBucket(context.Background(), tt.bucket, tt.opts)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err == nil && bucket.StorageClass != "NEARLINE" {
+				t.Errorf("Expected normalized storage class, got %q", bucket.StorageClass)
+			}
+		})
+	}
+}
+
+func TestAdminService_SetLifecycle(t *testing.T) {
+	tests := []struct {
+		name        string
+		rule        storage.LifecycleRule
+		expectedErr error
+	}{
+		{name: "delete", rule: storage.LifecycleRule{Action: "Delete", AgeDays: 365}},
+		{name: "transition", rule: storage.LifecycleRule{Action: "SetStorageClass", StorageClass: "coldline", AgeDays: 90}},
+		{name: "unknown action", rule: storage.LifecycleRule{Action: "Archive"}, expectedErr: ErrInvalidLifecycle},
+		{name: "delete with storage class", rule: storage.LifecycleRule{Action: "Delete", StorageClass: "COLDLINE"}, expectedErr: ErrInvalidLifecycle},
+		{name: "transition without storage class", rule: storage.LifecycleRule{Action: "SetStorageClass"}, expectedErr: ErrInvalidStorageClass},
+		{name: "negative age", rule: storage.LifecycleRule{Action: "Delete", AgeDays: -1}, expectedErr: ErrInvalidLifecycle},
+		{name: "negative days since custom time", rule: storage.LifecycleRule{Action: "Delete", DaysSinceCustomTime: -1}, expectedErr: ErrInvalidLifecycle},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := &mockBucketAdmin{}
+			service := NewAdminService(admin)
+
+			_, err := service.SetLifecycle(context.Background(), "media-eu", []storage.LifecycleRule{tt.rule})
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err != nil {
+				if admin.rules != nil {
+					t.Error("Expected invalid rules not to be applied")
+				}
+				return
+			}
+			if admin.rules[0].StorageClass != "" && admin.rules[0].StorageClass != "COLDLINE" {
+				t.Errorf("Expected normalized storage class, got %q", admin.rules[0].StorageClass)
+			}
+		})
+	}
+}
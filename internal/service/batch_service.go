@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// BatchLimits caps the number of files and the aggregate bytes of a single batch
+// request. Zero disables a limit.
+type BatchLimits struct {
+	MaxFiles int
+	MaxBytes int64
+}
+
+// WithBatchLimits limits batch reads and writes
+func WithBatchLimits(limits BatchLimits) Option {
+	return func(s *StorageService) {
+		s.batch = limits
+	}
+}
+
+// BatchLimits returns the configured batch limits
+func (s *StorageService) BatchLimits() BatchLimits {
+	return s.batch
+}
+
+// checkBatchSize rejects batches with more files than allowed
+func (s *StorageService) checkBatchSize(count int) error {
+	if s.batch.MaxFiles > 0 && count > s.batch.MaxFiles {
+		return fmt.Errorf("%w: %d files requested, limit is %d", ErrBatchTooManyFiles, count, s.batch.MaxFiles)
+	}
+	return nil
+}
+
+// checkReadPayload sums the sizes of the requested ranges before anything is
+// downloaded so oversized batches fail fast instead of exhausting memory.
+// Files that can't be found are skipped here and reported by the read itself.
+func (s *StorageService) checkReadPayload(ctx context.Context, ranges []storage.ReadRange) error {
+	if s.batch.MaxBytes <= 0 {
+		return nil
+	}
+
+	var total int64
+	for _, r := range ranges {
+		metadata, err := s.storage.StatFile(ctx, r.Path)
+		if err != nil {
+			continue
+		}
+		_, length := r.Bounds(metadata.Size)
+		total += length
+		if total > s.batch.MaxBytes {
+			return fmt.Errorf("%w: requested files exceed the limit of %d bytes", ErrBatchPayloadTooLarge, s.batch.MaxBytes)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// CacheControlRule sets the Cache-Control of objects whose path matches Pattern
+type CacheControlRule struct {
+	// Pattern is a glob matched against the whole path; * and ? don't match /
+	// while ** matches any number of directories
+	Pattern   string
+	MaxAge    time.Duration
+	Immutable bool
+	// NoStore keeps browsers and CDNs from caching the objects at all
+	NoStore bool
+}
+
+// Header returns the Cache-Control value of the rule
+func (r CacheControlRule) Header() string {
+	if r.NoStore {
+		return "no-store"
+	}
+	header := fmt.Sprintf("public, max-age=%d", int(r.MaxAge.Seconds()))
+	if r.Immutable {
+		header += ", immutable"
+	}
+	return header
+}
+
+type cacheControlRule struct {
+	CacheControlRule
+	pattern *regexp.Regexp
+}
+
+// WithCacheControl sets the Cache-Control of downloads and of new objects by
+// path pattern; the first matching rule wins
+func WithCacheControl(rules []CacheControlRule) Option {
+	return func(s *StorageService) {
+		s.cacheControl = nil
+		for _, rule := range rules {
+			s.cacheControl = append(s.cacheControl, cacheControlRule{rule, globPattern(rule.Pattern)})
+		}
+	}
+}
+
+// CacheControl returns the Cache-Control of the first rule matching filePath, or "" if none does
+func (s *StorageService) CacheControl(filePath string) string {
+	for _, rule := range s.cacheControl {
+		if rule.pattern.MatchString(filePath) {
+			return rule.Header()
+		}
+	}
+	return ""
+}
+
+// applyCacheControl stores the Cache-Control of the matching rule with each new object
+func (s *StorageService) applyCacheControl(requests []storage.WriteRequest) {
+	for i := range requests {
+		requests[i].CacheControl = s.CacheControl(requests[i].Path)
+	}
+}
+
+// globPattern compiles a glob into an anchored regular expression
+func globPattern(glob string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+
+	"gcp-proxy-mity/internal/catalog"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// WithCatalog serves catalog queries from c. With serveListings, listings are
+// read from the catalog instead of the bucket, so they only include objects
+// written through the proxy.
+func WithCatalog(c *catalog.Catalog, serveListings bool) Option {
+	return func(s *StorageService) {
+		s.catalog = c
+		s.catalogListings = serveListings
+	}
+}
+
+// CatalogObjects returns a page of the objects recorded in the catalog
+func (s *StorageService) CatalogObjects(ctx context.Context, query catalog.ObjectQuery) ([]catalog.Object, error) {
+	if s.catalog == nil {
+		return nil, ErrCatalogDisabled
+	}
+	return s.catalog.Objects(ctx, query)
+}
+
+// CatalogUsage returns the number and size of the objects each tenant wrote
+func (s *StorageService) CatalogUsage(ctx context.Context) ([]catalog.Usage, error) {
+	if s.catalog == nil {
+		return nil, ErrCatalogDisabled
+	}
+	return s.catalog.Usage(ctx)
+}
+
+// CatalogChanges returns a page of the writes and deletions recorded in the catalog
+func (s *StorageService) CatalogChanges(ctx context.Context, query catalog.ChangeQuery) ([]catalog.Change, error) {
+	if s.catalog == nil {
+		return nil, ErrCatalogDisabled
+	}
+	return s.catalog.Changes(ctx, query)
+}
+
+// listFiles lists the files under prefix from the catalog if it serves listings, or else from the bucket
+func (s *StorageService) listFiles(ctx context.Context, prefix string) ([]storage.FileMetadata, error) {
+	if s.catalog == nil || !s.catalogListings {
+		return s.storage.ListFiles(ctx, prefix)
+	}
+	objects, err := s.catalog.Objects(ctx, catalog.ObjectQuery{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	files := make([]storage.FileMetadata, 0, len(objects))
+	for _, object := range objects {
+		files = append(files, storage.FileMetadata{
+			Name:        object.Path,
+			ContentType: object.ContentType,
+			Size:        object.Size,
+			Updated:     object.UpdatedAt,
+			MD5:         object.MD5,
+		})
+	}
+	return files, nil
+}
+
+// walkFiles is listFiles calling fn for each file instead of collecting them
+func (s *StorageService) walkFiles(ctx context.Context, prefix string, fn func(storage.FileMetadata) error) error {
+	if s.catalog == nil || !s.catalogListings {
+		return s.storage.WalkFiles(ctx, prefix, fn)
+	}
+	files, err := s.listFiles(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
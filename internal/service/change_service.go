@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/changes"
+	"gcp-proxy-mity/internal/moderation"
+)
+
+// LatestCursor starts reading the change feed after its newest change
+const LatestCursor = "latest"
+
+// ChangePage is a page of the change feed
+type ChangePage struct {
+	Changes []changes.Change `json:"changes"`
+	// Cursor is where the next page starts; it stays the same while there are no new changes
+	Cursor string `json:"cursor"`
+}
+
+// WithChangeFeed serves the change feed from feed
+func WithChangeFeed(feed *changes.Feed) Option {
+	return func(s *StorageService) {
+		s.changes = feed
+	}
+}
+
+// Changes returns up to limit changes after cursor, oldest first. LatestCursor
+// returns no changes and the cursor of the newest one.
+func (s *StorageService) Changes(ctx context.Context, cursor string, limit int) (*ChangePage, error) {
+	if s.changes == nil {
+		return nil, ErrChangesDisabled
+	}
+	if cursor == LatestCursor {
+		latest, err := s.changes.Latest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &ChangePage{Changes: []changes.Change{}, Cursor: latest}, nil
+	}
+
+	feed, err := s.changes.Read(ctx, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	page := &ChangePage{Changes: make([]changes.Change, 0, len(feed)), Cursor: cursor}
+	if len(feed) > 0 {
+		page.Cursor = feed[len(feed)-1].Cursor
+	}
+
+	// Quarantined files only show up for admins, but still advance the cursor
+	hide := s.quarantinePrefix != "" && !auth.HasScope(ctx, auth.ScopeAdmin)
+	for _, change := range feed {
+		if hide && moderation.InQuarantine(s.quarantinePrefix, change.Path) {
+			continue
+		}
+		page.Changes = append(page.Changes, change)
+	}
+	return page, nil
+}
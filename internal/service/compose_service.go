@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// maxComposeChunks caps the number of chunks composed into one object
+const maxComposeChunks = 1024
+
+// ComposeFile concatenates previously uploaded chunks, in order, into filePath and deletes the chunks
+func (s *StorageService) ComposeFile(ctx context.Context, filePath string, chunks []string, contentType string, opts ...WriteOption) (*storage.FileMetadata, error) {
+	if len(chunks) == 0 || len(chunks) > maxComposeChunks {
+		return nil, fmt.Errorf("%w: between 1 and %d chunks are required", ErrInvalidCompose, maxComposeChunks)
+	}
+	for _, chunk := range chunks {
+		if chunk == "" || chunk == filePath {
+			return nil, fmt.Errorf("%w: chunks must be other objects than the destination", ErrInvalidCompose)
+		}
+	}
+	if err := policy.Authorize(ctx, policy.ActionWrite, filePath); err != nil {
+		return nil, err
+	}
+	// The chunks are read and then deleted
+	for _, action := range []string{policy.ActionRead, policy.ActionDelete} {
+		if err := policy.Authorize(ctx, action, chunks...); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.checkQuarantine(ctx, chunks...); err != nil {
+		return nil, err
+	}
+	if err := s.checkLeases(ctx, filePath); err != nil {
+		return nil, err
+	}
+
+	options := writeOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if contentType == "" {
+		contentType = storage.DetectContentType(filePath)
+	}
+	if err := s.CheckContentType(contentType); err != nil {
+		return nil, err
+	}
+
+	dst := []storage.WriteRequest{{Path: filePath, ContentType: contentType}}
+	if err := s.applyKMSKeys(ctx, dst, options.kmsKey); err != nil {
+		return nil, err
+	}
+	if err := s.applyStorageClasses(dst, options.storageClass); err != nil {
+		return nil, err
+	}
+	s.applyCacheControl(dst)
+	s.applyEnvelope(dst)
+	if err := s.applyExpiry(dst, options); err != nil {
+		return nil, err
+	}
+
+	file, err := s.storage.ComposeFiles(ctx, dst[0], chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	// A chunk may be listed more than once but is only deleted once
+	for _, chunk := range slices.Compact(slices.Sorted(slices.Values(chunks))) {
+		if err := s.storage.DeleteFile(ctx, chunk); err != nil {
+			log.Printf("Failed to delete chunk %s after composing %s: %v", chunk, filePath, err)
+		}
+	}
+
+	s.indexExpiry(ctx, []storage.FileMetadata{*file})
+	s.publishWritten(ctx, dst, []storage.FileMetadata{*file})
+	return file, nil
+}
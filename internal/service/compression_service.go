@@ -0,0 +1,54 @@
+package service
+
+import (
+	"mime"
+	"strings"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// CompressionRule stores objects of matching content types compressed
+type CompressionRule struct {
+	// ContentType is a media type like application/json, or a type with any
+	// subtype like text/*
+	ContentType string
+	// Encoding is gzip or zstd; an empty encoding stores matching objects as is
+	Encoding string
+}
+
+// matches reports whether the rule covers contentType, ignoring its parameters
+func (r CompressionRule) matches(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(r.ContentType, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return mediaType == strings.ToLower(r.ContentType)
+}
+
+// WithCompression stores new objects compressed by content type; the first
+// matching rule wins. Downloads are decompressed for clients that don't accept
+// the encoding.
+func WithCompression(rules []CompressionRule) Option {
+	return func(s *StorageService) {
+		s.compression = rules
+	}
+}
+
+// applyCompression sets the encoding of the matching rule on each new object
+func (s *StorageService) applyCompression(requests []storage.WriteRequest) {
+	for i, req := range requests {
+		contentType := req.ContentType
+		if contentType == "" {
+			contentType = storage.DetectContentType(req.Path)
+		}
+		for _, rule := range s.compression {
+			if rule.matches(contentType) {
+				requests[i].ContentEncoding = rule.Encoding
+				break
+			}
+		}
+	}
+}
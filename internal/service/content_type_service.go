@@ -0,0 +1,121 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// SetAllowedContentTypes replaces the content types accepted on writes at runtime.
+// Patterns are exact MIME types or wildcards like "image/*"; an empty list allows everything.
+func (s *StorageService) SetAllowedContentTypes(patterns []string) {
+	normalized := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		normalized = append(normalized, strings.ToLower(strings.TrimSpace(p)))
+	}
+	s.allowedTypes.Store(&normalized)
+}
+
+// CheckContentType returns ErrContentTypeNotAllowed if writes of the content type are rejected
+func (s *StorageService) CheckContentType(contentType string) error {
+	patterns := s.allowedTypes.Load()
+	if patterns == nil || len(*patterns) == 0 {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(contentType)
+	}
+
+	for _, pattern := range *patterns {
+		if pattern == mediaType {
+			return nil
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrContentTypeNotAllowed, mediaType)
+}
+
+// sniffLength is how much content http.DetectContentType considers
+const sniffLength = 512
+
+// SetTrustClientContentTypes sets at runtime whether the content types clients
+// declare are stored as sent. Untrusted types are replaced by the type sniffed
+// from the content. Missing and generic types are always sniffed.
+func (s *StorageService) SetTrustClientContentTypes(trust bool) {
+	s.distrustTypes.Store(!trust)
+}
+
+// sniffContentTypes sets the content type of writes declaring none, or only
+// application/octet-stream, from the first bytes of their content. The
+// requests are copied, since callers close the contents they passed in.
+func (s *StorageService) sniffContentTypes(requests []storage.WriteRequest) []storage.WriteRequest {
+	distrust := s.distrustTypes.Load()
+	sniffed := slices.Clone(requests)
+	for i, req := range sniffed {
+		declared, _, _ := mime.ParseMediaType(req.ContentType)
+		if req.Content == nil || (!distrust && declared != "" && declared != octetStream) {
+			continue
+		}
+		// The buffered reader serves the sniffed bytes again, and read errors
+		// after them, so the write fails as it would have without sniffing
+		content := bufio.NewReaderSize(req.Content, sniffLength)
+		head, _ := content.Peek(sniffLength)
+		sniffed[i].Content = content
+		sniffed[i].ContentType = sniffedContentType(req.Path, req.ContentType, head)
+	}
+	return sniffed
+}
+
+const octetStream = "application/octet-stream"
+
+// sniffedContentType picks the type of content starting with head. Signatures
+// win over the declared type; content without a known signature keeps the
+// declared type, or gets the one of its extension.
+func sniffedContentType(filePath, declared string, head []byte) string {
+	sniffed := http.DetectContentType(head)
+	byExtension := storage.DetectContentType(filePath)
+	switch {
+	case len(head) == 0 || sniffed == octetStream:
+		if mediaType, _, _ := mime.ParseMediaType(declared); mediaType != "" && mediaType != octetStream {
+			return declared
+		}
+		return byExtension
+	case strings.HasPrefix(sniffed, "text/plain") && byExtension != octetStream:
+		// CSS, JavaScript, JSON, CSV and other text formats sniff as plain text
+		return byExtension
+	default:
+		return sniffed
+	}
+}
+
+// filterContentTypes splits requests into allowed ones and write errors for rejected ones
+func (s *StorageService) filterContentTypes(requests []storage.WriteRequest) ([]storage.WriteRequest, []storage.WriteError) {
+	accepted := make([]storage.WriteRequest, 0, len(requests))
+	var rejected []storage.WriteError
+
+	for _, req := range requests {
+		contentType := req.ContentType
+		if contentType == "" {
+			contentType = storage.DetectContentType(req.Path)
+		}
+		if err := s.CheckContentType(contentType); err != nil {
+			rejected = append(rejected, storage.WriteError{
+				FilePath: req.Path,
+				Error:    err.Error(),
+			})
+			continue
+		}
+		accepted = append(accepted, req)
+	}
+
+	return accepted, rejected
+}
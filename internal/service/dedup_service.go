@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// WithDeduplication enables content-addressed deduplication. Objects are indexed
+// by SHA-256 under prefix, and a write announcing the hash of indexed content is
+// served by a server-side copy instead of an upload.
+func WithDeduplication(prefix string) Option {
+	return func(s *StorageService) {
+		s.dedupPrefix = strings.Trim(prefix, "/")
+	}
+}
+
+// ContentPath returns where content with the given SHA-256 is indexed
+func (s *StorageService) ContentPath(sum string) string {
+	return path.Join(s.dedupPrefix, sum[:2], sum)
+}
+
+// hashingReader computes the SHA-256 of everything read through it
+type hashingReader struct {
+	io.Reader
+	hash hash.Hash
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	h := sha256.New()
+	return &hashingReader{Reader: io.TeeReader(r, h), hash: h}
+}
+
+func (r *hashingReader) sum() string {
+	return hex.EncodeToString(r.hash.Sum(nil))
+}
+
+// deduplicate copies indexed content for requests announcing a known hash and
+// returns the requests that still need an upload. Announced hashes of uploads
+// are verified afterwards by verifyContentHashes.
+func (s *StorageService) deduplicate(ctx context.Context, requests []storage.WriteRequest) ([]storage.WriteRequest, []storage.FileMetadata, []storage.WriteError, map[string]*hashingReader) {
+	remaining := make([]storage.WriteRequest, 0, len(requests))
+	var copied []storage.FileMetadata
+	var rejected []storage.WriteError
+	hashed := make(map[string]*hashingReader)
+
+	for _, req := range requests {
+		if req.SHA256 == "" {
+			remaining = append(remaining, req)
+			continue
+		}
+		sum := strings.ToLower(req.SHA256)
+		if !sha256Pattern.MatchString(sum) {
+			rejected = append(rejected, storage.WriteError{
+				FilePath: req.Path,
+				Error:    fmt.Sprintf("%v: SHA-256 must be 64 hex characters", ErrInvalidContentHash),
+			})
+			continue
+		}
+		req.SHA256 = sum
+
+		if s.dedupPrefix != "" && indexable(ctx, req) {
+			if file, err := s.copyIndexed(ctx, req); err == nil {
+				copied = append(copied, *file)
+				continue
+			}
+		}
+
+		reader := newHashingReader(req.Content)
+		req.Content = reader
+		hashed[req.Path] = reader
+		remaining = append(remaining, req)
+	}
+
+	return remaining, copied, rejected, hashed
+}
+
+// copyIndexed copies indexed content to the request path; it fails if the content is not indexed
+func (s *StorageService) copyIndexed(ctx context.Context, req storage.WriteRequest) (*storage.FileMetadata, error) {
+	contentPath := s.ContentPath(req.SHA256)
+	if _, err := s.storage.StatFile(ctx, contentPath); err != nil {
+		return nil, err
+	}
+	if err := s.storage.CopyFile(ctx, contentPath, req.Path); err != nil {
+		log.Printf("Failed to copy deduplicated content to %s: %v", req.Path, err)
+		return nil, err
+	}
+	return s.storage.StatFile(ctx, req.Path)
+}
+
+// verifyContentHashes checks announced hashes against the uploaded content. Mismatching
+// objects are deleted and reported as errors; matching ones are added to the index.
+func (s *StorageService) verifyContentHashes(ctx context.Context, requests []storage.WriteRequest, hashed map[string]*hashingReader, response *storage.WriteResponse) {
+	if len(hashed) == 0 {
+		return
+	}
+	announced := make(map[string]storage.WriteRequest, len(requests))
+	for _, req := range requests {
+		announced[req.Path] = req
+	}
+
+	written := make([]storage.FileMetadata, 0, len(response.FilesWritten))
+	for _, file := range response.FilesWritten {
+		req, ok := announced[file.Name]
+		reader := hashed[file.Name]
+		if !ok || reader == nil {
+			written = append(written, file)
+			continue
+		}
+
+		if sum := reader.sum(); sum != req.SHA256 {
+			// Removed even if the client is gone, so a wrong object isn't left behind
+			if err := s.storage.DeleteFile(context.WithoutCancel(ctx), file.Name); err != nil {
+				log.Printf("Failed to delete %s after hash mismatch: %v", file.Name, err)
+			}
+			response.Errors = append(response.Errors, storage.WriteError{
+				FilePath: file.Name,
+				Error:    fmt.Sprintf("%v: content has SHA-256 %s", ErrContentHashMismatch, sum),
+			})
+			continue
+		}
+
+		if s.dedupPrefix != "" && indexable(ctx, req) {
+			if err := s.storage.CopyFile(ctx, file.Name, s.ContentPath(req.SHA256)); err != nil {
+				log.Printf("Failed to index content of %s: %v", file.Name, err)
+			}
+		}
+		written = append(written, file)
+	}
+	response.FilesWritten = written
+}
+
+// indexable reports whether a write can be served from or added to the index. Copies
+// don't carry customer-supplied or KMS keys, storage classes or expiries, so those
+// writes are skipped.
+func indexable(ctx context.Context, req storage.WriteRequest) bool {
+	return storage.EncryptionKeyFromContext(ctx) == nil && req.KMSKeyName == "" && req.StorageClass == "" && req.ExpiresAt.IsZero()
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+var kmsKeyNamePattern = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
+// KMSKeyRule encrypts objects under Prefix with the Cloud KMS key KeyName
+type KMSKeyRule struct {
+	Prefix  string
+	KeyName string
+}
+
+// WithKMSKeys selects Cloud KMS keys by path prefix; the longest matching prefix wins
+func WithKMSKeys(rules []KMSKeyRule) Option {
+	return func(s *StorageService) {
+		s.kmsKeys = rules
+	}
+}
+
+// KMSKey encrypts the written objects with the given Cloud KMS key, overriding prefix rules
+func KMSKey(keyName string) WriteOption {
+	return func(o *writeOptions) {
+		o.kmsKey = keyName
+	}
+}
+
+// applyKMSKeys sets the KMS key of each request. Objects written with a
+// customer-supplied key can't also use a KMS key, so rules are skipped for them.
+func (s *StorageService) applyKMSKeys(ctx context.Context, requests []storage.WriteRequest, explicit string) error {
+	csek := storage.EncryptionKeyFromContext(ctx) != nil
+	if explicit != "" {
+		if !kmsKeyNamePattern.MatchString(explicit) {
+			return fmt.Errorf("%w: %q is not a projects/*/locations/*/keyRings/*/cryptoKeys/* name", ErrInvalidKMSKey, explicit)
+		}
+		if csek {
+			return fmt.Errorf("%w: a KMS key and a customer-supplied key can't be combined", ErrInvalidKMSKey)
+		}
+	}
+	if csek {
+		return nil
+	}
+
+	for i := range requests {
+		requests[i].KMSKeyName = explicit
+		if explicit == "" {
+			if rule, ok := longestPrefix(s.kmsKeys, requests[i].Path, func(r KMSKeyRule) string { return r.Prefix }); ok {
+				requests[i].KMSKeyName = rule.KeyName
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"strings"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// WithEnvelope encrypts new objects under prefixes, or all new objects without
+// prefixes, in the proxy before they reach the bucket. The storage needs a
+// keyring to wrap their data keys.
+func WithEnvelope(prefixes []string) Option {
+	return func(s *StorageService) {
+		s.envelope = true
+		s.envelopePrefixes = prefixes
+	}
+}
+
+// Envelope reports whether an object written to filePath is encrypted by the proxy
+func (s *StorageService) Envelope(filePath string) bool {
+	if !s.envelope {
+		return false
+	}
+	if len(s.envelopePrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range s.envelopePrefixes {
+		if strings.HasPrefix(filePath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEnvelope marks the requests to encrypt in the proxy
+func (s *StorageService) applyEnvelope(requests []storage.WriteRequest) {
+	for i := range requests {
+		requests[i].Envelope = s.Envelope(requests[i].Path)
+	}
+}
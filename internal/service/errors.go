@@ -0,0 +1,51 @@
+package service
+
+import "errors"
+
+var (
+	ErrFrameExtractionDisabled = errors.New("frame extraction is not configured")
+	ErrNotVideo                = errors.New("file is not a supported video")
+	ErrInvalidJob              = errors.New("invalid job")
+	ErrContentTypeNotAllowed   = errors.New("content type not allowed")
+	ErrBatchTooManyFiles       = errors.New("batch has too many files")
+	ErrBatchPayloadTooLarge    = errors.New("batch payload too large")
+	ErrInvalidKMSKey           = errors.New("invalid KMS key")
+	ErrInvalidStorageClass     = errors.New("invalid storage class")
+	ErrInvalidHoldUpdate       = errors.New("invalid hold update")
+	ErrInvalidBucket           = errors.New("invalid bucket")
+	ErrInvalidLifecycle        = errors.New("invalid lifecycle rule")
+	ErrUploadsDisabled         = errors.New("direct uploads are not configured")
+	ErrInvalidUpload           = errors.New("invalid upload")
+	ErrInvalidContentHash      = errors.New("invalid content hash")
+	ErrContentHashMismatch     = errors.New("content does not match its SHA-256")
+	ErrInvalidCompose          = errors.New("invalid compose request")
+	ErrInvalidCopy             = errors.New("invalid copy request")
+	ErrQuotasDisabled          = errors.New("upload quotas are not configured")
+	ErrNoPrincipal             = errors.New("usage is tracked per API key")
+	ErrTrashDisabled           = errors.New("trash is not configured")
+	ErrNotInTrash              = errors.New("file is not in the trash")
+	ErrRestoreConflict         = errors.New("a file already exists at the restore path")
+	ErrGCDisabled              = errors.New("garbage collection is not configured")
+	ErrFetchDisabled           = errors.New("fetching from URLs is not enabled")
+	ErrInvalidFetch            = errors.New("invalid fetch request")
+	ErrQuarantined             = errors.New("file is quarantined by moderation")
+	ErrInvalidRange            = errors.New("invalid range")
+	ErrTagsDisabled            = errors.New("tags are not configured")
+	ErrInvalidTags             = errors.New("invalid tags")
+	ErrSearchDisabled          = errors.New("search index is not configured")
+	ErrInvalidSearch           = errors.New("invalid search")
+	ErrCatalogDisabled         = errors.New("metadata catalog is not configured")
+	ErrChangesDisabled         = errors.New("change feed is not enabled")
+	ErrEventStreamDisabled     = errors.New("event stream is not enabled")
+	ErrLeasesDisabled          = errors.New("leases are not enabled")
+	ErrLogsDisabled            = errors.New("append-only logs are not enabled")
+	ErrSiteDisabled            = errors.New("website serving is not enabled")
+	ErrSharesDisabled          = errors.New("share links are not enabled")
+	ErrStatsDisabled           = errors.New("download statistics are not enabled")
+	ErrReplicationDisabled     = errors.New("replication is not configured")
+	ErrReplicaDrift            = errors.New("replica differs from the object")
+	ErrExpiryDisabled          = errors.New("expiring objects are not enabled")
+	ErrInvalidExpiry           = errors.New("invalid expiry")
+	ErrCacheDisabled           = errors.New("the read cache is not enabled")
+	ErrInvalidCacheRequest     = errors.New("invalid cache request")
+)
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/moderation"
+)
+
+// WithEventStream serves live event streams from broadcaster
+func WithEventStream(broadcaster *events.Broadcaster) Option {
+	return func(s *StorageService) {
+		s.eventStream = broadcaster
+	}
+}
+
+// SubscribeEvents subscribes to the events of objects under any of prefixes,
+// or of all objects without prefixes. The caller closes the subscription.
+func (s *StorageService) SubscribeEvents(ctx context.Context, prefixes []string) (*events.Subscription, error) {
+	if s.eventStream == nil {
+		return nil, ErrEventStreamDisabled
+	}
+	hideQuarantined := s.quarantinePrefix != "" && !auth.HasScope(ctx, auth.ScopeAdmin)
+	return s.eventStream.Subscribe(func(event events.Event) bool {
+		if hideQuarantined && moderation.InQuarantine(s.quarantinePrefix, event.Path) {
+			return false
+		}
+		if len(prefixes) == 0 {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(event.Path, prefix) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
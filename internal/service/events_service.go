@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// WithPublisher publishes an event for every object written or deleted through the service
+func WithPublisher(publisher events.Publisher) Option {
+	return func(s *StorageService) {
+		s.publishers = append(s.publishers, publisher)
+	}
+}
+
+// publishWritten notifies publishers about successfully written files
+func (s *StorageService) publishWritten(ctx context.Context, requests []storage.WriteRequest, files []storage.FileMetadata) {
+	if len(s.publishers) == 0 {
+		return
+	}
+
+	metadata := make(map[string]map[string]string, len(requests))
+	for _, req := range requests {
+		metadata[req.Path] = req.Metadata
+	}
+
+	for _, file := range files {
+		event := events.NewObjectEvent(events.ObjectWritten, file, metadata[file.Name])
+		for _, publisher := range s.publishers {
+			if err := publisher.Publish(ctx, event); err != nil {
+				log.Printf("Failed to publish event for %s: %v", file.Name, err)
+			}
+		}
+	}
+}
+
+// publishDeleted notifies publishers about deleted files
+func (s *StorageService) publishDeleted(ctx context.Context, filePaths ...string) {
+	for _, filePath := range filePaths {
+		event := events.NewObjectEvent(events.ObjectDeleted, storage.FileMetadata{Name: filePath}, nil)
+		for _, publisher := range s.publishers {
+			if err := publisher.Publish(ctx, event); err != nil {
+				log.Printf("Failed to publish event for %s: %v", filePath, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/jobs"
+	"gcp-proxy-mity/internal/metrics"
+	"gcp-proxy-mity/internal/storage"
+)
+
+var (
+	expiryRuns           = metrics.NewCounter("gcs_proxy_expiry_runs_total", "Runs of the job deleting expired objects")
+	expiryDeletedObjects = metrics.NewCounter("gcs_proxy_expiry_deleted_objects_total", "Expired objects deleted")
+	expiryFailures       = metrics.NewCounter("gcs_proxy_expiry_failures_total", "Expired objects that failed to be deleted")
+)
+
+// expiryMarkerLayout names the folder of the markers of objects expiring in
+// the same second, and sorts like the times it formats
+const expiryMarkerLayout = "20060102T150405Z"
+
+// errStopWalk ends a walk of markers at the first one that is not due yet
+var errStopWalk = errors.New("stop walk")
+
+// WithExpiry enables writes with an expiry. Expiring objects are indexed by
+// empty marker objects at {prefix}/{expiry}/{path}, which the expire job lists
+// in order of expiry to delete the objects whose time has come.
+func WithExpiry(prefix string) Option {
+	return func(s *StorageService) {
+		s.expiryPrefix = strings.Trim(prefix, "/")
+	}
+}
+
+// ExpiresAfter deletes the written objects once d has passed, a duration such
+// as 24h or a number of seconds
+func ExpiresAfter(d string) WriteOption {
+	return func(o *writeOptions) {
+		o.expiresAfter = d
+	}
+}
+
+// ExpiresAt deletes the written objects at t, an RFC 3339 time
+func ExpiresAt(t string) WriteOption {
+	return func(o *writeOptions) {
+		o.expiresAt = t
+	}
+}
+
+// expiryMarker returns the path of the marker of an object expiring at t
+func expiryMarker(prefix string, t time.Time, filePath string) string {
+	return prefix + "/" + t.UTC().Format(expiryMarkerLayout) + "/" + filePath
+}
+
+// parseExpiry resolves an expiry given as a duration from now or as a time
+func parseExpiry(after, at string, now time.Time) (time.Time, error) {
+	if after != "" && at != "" {
+		return time.Time{}, fmt.Errorf("%w: give either a duration or a time", ErrInvalidExpiry)
+	}
+	if after != "" {
+		d, err := time.ParseDuration(after)
+		if err != nil {
+			seconds, err := strconv.ParseInt(after, 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("%w: %q is neither a duration nor a number of seconds", ErrInvalidExpiry, after)
+			}
+			d = time.Duration(seconds) * time.Second
+		}
+		if d < time.Second {
+			return time.Time{}, fmt.Errorf("%w: %q must be at least a second", ErrInvalidExpiry, after)
+		}
+		return now.Add(d).Truncate(time.Second), nil
+	}
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q is not an RFC 3339 time", ErrInvalidExpiry, at)
+	}
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("%w: %s has already passed", ErrInvalidExpiry, at)
+	}
+	return t.Truncate(time.Second), nil
+}
+
+// applyExpiry sets the expiry asked for with the write options on every request
+func (s *StorageService) applyExpiry(requests []storage.WriteRequest, options writeOptions) error {
+	if options.expiresAfter == "" && options.expiresAt == "" {
+		return nil
+	}
+	if s.expiryPrefix == "" {
+		return ErrExpiryDisabled
+	}
+	expiresAt, err := parseExpiry(options.expiresAfter, options.expiresAt, time.Now())
+	if err != nil {
+		return err
+	}
+	for i := range requests {
+		requests[i].ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+// indexExpiry adds the markers of the written files that expire. Failures are
+// only logged: the files are hidden once expired all the same, and their
+// Custom-Time lets a bucket lifecycle rule delete them.
+func (s *StorageService) indexExpiry(ctx context.Context, files []storage.FileMetadata) {
+	var markers []storage.WriteRequest
+	for _, file := range files {
+		if !file.ExpiresAt.IsZero() {
+			markers = append(markers, storage.WriteRequest{
+				Path:        expiryMarker(s.expiryPrefix, file.ExpiresAt, file.Name),
+				Content:     strings.NewReader(""),
+				ContentType: "application/octet-stream",
+			})
+		}
+	}
+	if len(markers) == 0 {
+		return
+	}
+	response, err := s.storage.WriteFiles(ctx, markers)
+	if err == nil && len(response.Errors) > 0 {
+		err = errors.New(response.Errors[0].Error)
+	}
+	if err != nil {
+		log.Printf("Failed to index expiry: %v", err)
+	}
+}
+
+// expireObjects deletes the objects whose expiry has passed. Markers are
+// walked in order of expiry up to the first one that isn't due. An object
+// rewritten meanwhile without an expiry, or with a later one, is kept.
+func (s *JobService) expireObjects(ctx context.Context, spec jobs.Spec, t *jobs.Tracker) error {
+	expiryRuns.Inc()
+	now := time.Now()
+	prefix := s.expiryPrefix + "/"
+
+	var due []string
+	err := s.storage.WalkFiles(ctx, prefix, func(marker storage.FileMetadata) error {
+		stamp, _, _ := strings.Cut(strings.TrimPrefix(marker.Name, prefix), "/")
+		at, err := time.Parse(expiryMarkerLayout, stamp)
+		if err != nil {
+			return nil
+		}
+		if at.After(now) {
+			return errStopWalk
+		}
+		due = append(due, marker.Name)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return err
+	}
+	t.SetTotal(len(due))
+
+	for _, marker := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		_, filePath, _ := strings.Cut(strings.TrimPrefix(marker, prefix), "/")
+		if err := s.expireObject(ctx, filePath, now); err != nil {
+			expiryFailures.Inc()
+			t.Fail(filePath, err)
+			continue
+		}
+		if err := s.storage.DeleteFile(ctx, marker); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			t.Fail(filePath, err)
+			continue
+		}
+		t.Succeed()
+	}
+	return nil
+}
+
+// expireObject deletes the object at filePath if its expiry has passed by now
+func (s *JobService) expireObject(ctx context.Context, filePath string, now time.Time) error {
+	metadata, err := s.storage.GetMetadata(ctx, filePath)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	expiresAt, err := time.Parse(time.RFC3339, metadata[storage.ExpiresAtKey])
+	if err != nil || expiresAt.After(now) {
+		return nil
+	}
+	// Another replica may have deleted it already
+	if err := s.storage.DeleteFile(ctx, filePath); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	expiryDeletedObjects.Inc()
+	return nil
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"gcp-proxy-mity/internal/fetch"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// FetchRequest downloads URL into Path. ContentType overrides the type the remote server reports.
+type FetchRequest struct {
+	URL         string `json:"url"`
+	Path        string `json:"path"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// WithFetcher enables writing files downloaded from remote URLs
+func WithFetcher(fetcher *fetch.Fetcher) Option {
+	return func(s *StorageService) {
+		s.fetcher = fetcher
+	}
+}
+
+// FetchFiles downloads each URL and streams it into its path, one after
+// another. Files that fail to download or write are reported in Errors.
+func (s *StorageService) FetchFiles(ctx context.Context, requests []FetchRequest, opts ...WriteOption) (*storage.WriteResponse, error) {
+	if s.fetcher == nil {
+		return nil, ErrFetchDisabled
+	}
+	if err := s.checkBatchSize(len(requests)); err != nil {
+		return nil, err
+	}
+	if err := s.checkQuota(ctx); err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(requests))
+	for _, req := range requests {
+		paths = append(paths, req.Path)
+	}
+	if err := s.checkLeases(ctx, paths...); err != nil {
+		return nil, err
+	}
+
+	response := &storage.WriteResponse{
+		FilesWritten: make([]storage.FileMetadata, 0, len(requests)),
+	}
+	for _, req := range requests {
+		written, err := s.fetchFile(ctx, req, opts)
+		if err != nil {
+			response.Errors = append(response.Errors, storage.WriteError{FilePath: req.Path, Error: err.Error()})
+			continue
+		}
+		response.FilesWritten = append(response.FilesWritten, written.FilesWritten...)
+		response.Errors = append(response.Errors, written.Errors...)
+		response.Deduplicated = append(response.Deduplicated, written.Deduplicated...)
+	}
+	return response, nil
+}
+
+func (s *StorageService) fetchFile(ctx context.Context, req FetchRequest, opts []WriteOption) (*storage.WriteResponse, error) {
+	if req.URL == "" || req.Path == "" {
+		return nil, fmt.Errorf("%w: url and path are required", ErrInvalidFetch)
+	}
+	// Types that will be rejected anyway are checked before anything is downloaded
+	if req.ContentType != "" {
+		if err := s.CheckContentType(req.ContentType); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := s.fetcher.Fetch(ctx, req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", req.URL, err)
+	}
+	defer resp.Body.Close()
+
+	contentType := req.ContentType
+	if contentType == "" && resp.ContentType != "application/octet-stream" {
+		contentType = resp.ContentType
+	}
+	if contentType == "" {
+		contentType = storage.DetectContentType(req.Path)
+	}
+	return s.WriteFiles(ctx, []storage.WriteRequest{{
+		Path:        req.Path,
+		Content:     resp.Body,
+		ContentType: contentType,
+	}}, opts...)
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gcp-proxy-mity/internal/jobs"
+	"gcp-proxy-mity/internal/metrics"
+	"gcp-proxy-mity/internal/storage"
+)
+
+var (
+	gcRuns           = metrics.NewCounter("gcs_proxy_gc_runs_total", "Garbage collection runs")
+	gcDeletedObjects = metrics.NewCounter("gcs_proxy_gc_deleted_objects_total", "Orphaned temporary objects deleted")
+	gcDeletedBytes   = metrics.NewCounter("gcs_proxy_gc_deleted_bytes_total", "Bytes of orphaned temporary objects deleted")
+	gcFailures       = metrics.NewCounter("gcs_proxy_gc_failures_total", "Orphaned temporary objects that failed to be deleted")
+)
+
+// GCConfig deletes objects under Prefixes that were last written more than TTL ago
+type GCConfig struct {
+	Prefixes []string
+	TTL      time.Duration
+}
+
+// collectGarbage deletes the expired objects under the temporary prefixes, such
+// as chunks of uploads that were never composed
+func (s *JobService) collectGarbage(ctx context.Context, spec jobs.Spec, t *jobs.Tracker) error {
+	gcRuns.Inc()
+	cutoff := time.Now().Add(-s.gc.TTL)
+
+	var expired []storage.FileMetadata
+	for _, prefix := range s.gc.Prefixes {
+		files, err := s.storage.ListFiles(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if !file.Updated.IsZero() && file.Updated.Before(cutoff) {
+				expired = append(expired, file)
+			}
+		}
+	}
+	t.SetTotal(len(expired))
+
+	for _, file := range expired {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Another replica may have collected it already
+		if err := s.storage.DeleteFile(ctx, file.Name); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			gcFailures.Inc()
+			t.Fail(file.Name, err)
+			continue
+		}
+		gcDeletedObjects.Inc()
+		gcDeletedBytes.Add(file.Size)
+		t.Succeed()
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"path"
+
+	"gcp-proxy-mity/internal/imaging"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// ImageConfig configures on-the-fly image transformations
+type ImageConfig struct {
+	// SigningKey, when set, requires every transformation to carry a valid signature
+	SigningKey string
+	// VariantPrefix is the bucket prefix under which derived variants are cached
+	VariantPrefix string
+}
+
+// WithImageTransforms enables signed transformations and variant caching
+func WithImageTransforms(cfg ImageConfig) Option {
+	return func(s *StorageService) {
+		s.images = cfg
+	}
+}
+
+// ReadImage returns a transformed variant of an image, generating and caching it on first use
+func (s *StorageService) ReadImage(ctx context.Context, filePath string, opts imaging.Options, signature string) (*storage.FileData, error) {
+	if err := s.checkQuarantine(ctx, filePath); err != nil {
+		return nil, err
+	}
+	if s.images.SigningKey != "" {
+		if err := imaging.Verify(s.images.SigningKey, filePath, opts, signature); err != nil {
+			return nil, err
+		}
+	}
+
+	variantPath := s.variantPath(filePath, opts)
+	if variantPath != "" {
+		if variant, err := s.storage.ReadFile(ctx, variantPath); err == nil {
+			variant.Metadata.Name = filePath
+			s.recordDownloads(*variant)
+			return variant, nil
+		}
+	}
+
+	original, err := s.storage.ReadFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	content, contentType, err := imaging.Transform(original.Content, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if variantPath != "" {
+		response, err := s.storage.WriteFiles(ctx, []storage.WriteRequest{{
+			Path:        variantPath,
+			Content:     bytes.NewReader(content),
+			ContentType: contentType,
+			// Variants reveal the original, so they are encrypted like it
+			Envelope: s.Envelope(variantPath) || original.Metadata.Envelope,
+		}})
+		if err == nil && len(response.Errors) > 0 {
+			err = errors.New(response.Errors[0].Error)
+		}
+		if err != nil {
+			log.Printf("Failed to cache image variant %s: %v", variantPath, err)
+		}
+	}
+
+	image := storage.FileData{
+		Metadata: storage.FileMetadata{
+			Name:        filePath,
+			ContentType: contentType,
+			Size:        int64(len(content)),
+		},
+		Content: content,
+	}
+	s.recordDownloads(image)
+	return &image, nil
+}
+
+func (s *StorageService) variantPath(filePath string, opts imaging.Options) string {
+	if s.images.VariantPrefix == "" {
+		return ""
+	}
+	return path.Join(s.images.VariantPrefix, filePath, opts.Key())
+}
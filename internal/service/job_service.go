@@ -0,0 +1,331 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/jobs"
+	"gcp-proxy-mity/internal/moderation"
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Job types
+const (
+	JobCopyPrefix        = "copy-prefix"
+	JobDeletePrefix      = "delete-prefix"
+	JobBulkRead          = "bulk-read"
+	JobPurgeTrash        = "purge-trash"
+	JobGC                = "gc"
+	JobTransfer          = "transfer"
+	JobVerifyReplication = "verify-replication"
+	JobExpire            = "expire"
+)
+
+// JobConfig configures the built-in job types
+type JobConfig struct {
+	// ResultPrefix is where bulk-read archives are stored
+	ResultPrefix string
+	Trash        TrashConfig
+	GC           GCConfig
+	// Transfer reaches the buckets objects can be transferred between
+	Transfer storage.Transfer
+	// QuarantinePrefix holds files flagged by moderation, which jobs don't copy or read
+	QuarantinePrefix string
+	Verify           VerifyConfig
+	// Envelope encrypts archives in the proxy, as they may hold objects it encrypted
+	Envelope bool
+	// ExpiryPrefix holds the markers of expiring objects
+	ExpiryPrefix string
+}
+
+// JobService runs long bulk operations as background jobs
+type JobService struct {
+	storage      storage.Storage
+	manager      *jobs.Manager
+	resultPrefix string
+	trash        TrashConfig
+	gc           GCConfig
+	transfer     storage.Transfer
+	quarantine   string
+	verify       VerifyConfig
+	envelope     bool
+	expiryPrefix string
+}
+
+// NewJobService creates a job service and registers the built-in job types on the manager
+func NewJobService(storage storage.Storage, manager *jobs.Manager, cfg JobConfig) *JobService {
+	cfg.Trash.Prefix = strings.Trim(cfg.Trash.Prefix, "/")
+	s := &JobService{
+		storage:      storage,
+		manager:      manager,
+		resultPrefix: cfg.ResultPrefix,
+		trash:        cfg.Trash,
+		gc:           cfg.GC,
+		transfer:     cfg.Transfer,
+		quarantine:   cfg.QuarantinePrefix,
+		verify:       cfg.Verify,
+		envelope:     cfg.Envelope,
+		expiryPrefix: strings.Trim(cfg.ExpiryPrefix, "/"),
+	}
+
+	manager.Register(JobCopyPrefix, s.copyPrefix)
+	manager.Register(JobDeletePrefix, s.deletePrefix)
+	manager.Register(JobBulkRead, s.bulkRead)
+	manager.Register(JobPurgeTrash, s.purgeTrash)
+	manager.Register(JobGC, s.collectGarbage)
+	manager.Register(JobTransfer, s.transferObjects)
+	manager.Register(JobVerifyReplication, s.verifyReplication)
+	manager.Register(JobExpire, s.expireObjects)
+
+	return s
+}
+
+// Create validates and queues a job, which runs as the caller in ctx
+func (s *JobService) Create(ctx context.Context, spec jobs.Spec) (*jobs.Job, error) {
+	switch spec.Type {
+	case JobCopyPrefix:
+		if spec.Prefix == "" || spec.Destination == "" {
+			return nil, fmt.Errorf("%w: copy-prefix requires prefix and destination", ErrInvalidJob)
+		}
+		if strings.HasPrefix(spec.Destination, spec.Prefix) {
+			return nil, fmt.Errorf("%w: destination must not be inside prefix", ErrInvalidJob)
+		}
+	case JobDeletePrefix:
+		if spec.Prefix == "" {
+			return nil, fmt.Errorf("%w: delete-prefix requires a non-empty prefix", ErrInvalidJob)
+		}
+	case JobBulkRead:
+		if spec.Prefix == "" && len(spec.Paths) == 0 && spec.Manifest == "" {
+			return nil, fmt.Errorf("%w: bulk-read requires paths, a prefix or a manifest", ErrInvalidJob)
+		}
+	case JobPurgeTrash:
+		if s.trash.Prefix == "" {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJob, ErrTrashDisabled)
+		}
+	case JobGC:
+		if len(s.gc.Prefixes) == 0 {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJob, ErrGCDisabled)
+		}
+	case JobVerifyReplication:
+		if s.verify.Replicas == nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJob, ErrReplicationDisabled)
+		}
+	case JobExpire:
+		if s.expiryPrefix == "" {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJob, ErrExpiryDisabled)
+		}
+	case JobTransfer:
+		// Other buckets are for admins only
+		return nil, fmt.Errorf("%w: transfers between buckets are started through the admin API", ErrInvalidJob)
+	}
+
+	return s.manager.Submit(ctx, spec)
+}
+
+// Authorize checks the access policy for a job queued by a request. Jobs
+// over a prefix or manifest find their objects when they run, so they are
+// checked like requests that may touch any object; jobs naming their paths
+// are checked for each of them.
+func (s *JobService) Authorize(ctx context.Context, spec jobs.Spec) error {
+	action := policy.ActionDelete
+	switch spec.Type {
+	case JobCopyPrefix:
+		if err := policy.Authorize(ctx, policy.ActionWrite, ""); err != nil {
+			return err
+		}
+		action = policy.ActionRead
+	case JobBulkRead, JobVerifyReplication:
+		action = policy.ActionRead
+	}
+	if spec.Type == JobBulkRead && spec.Prefix == "" && spec.Manifest == "" {
+		return policy.Authorize(ctx, action, spec.Paths...)
+	}
+	return policy.Authorize(ctx, action, "")
+}
+
+// Get returns the current state of a job
+func (s *JobService) Get(id string) (*jobs.Job, error) {
+	return s.manager.Get(id)
+}
+
+func (s *JobService) copyPrefix(ctx context.Context, spec jobs.Spec, t *jobs.Tracker) error {
+	files, err := s.storage.ListFiles(ctx, spec.Prefix)
+	if err != nil {
+		return err
+	}
+	files = slices.DeleteFunc(files, func(file storage.FileMetadata) bool {
+		return moderation.InQuarantine(s.quarantine, file.Name)
+	})
+	t.SetTotal(len(files))
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		dst := spec.Destination + strings.TrimPrefix(file.Name, spec.Prefix)
+		if err := s.storage.CopyFile(ctx, file.Name, dst); err != nil {
+			t.Fail(file.Name, err)
+			continue
+		}
+		t.Succeed()
+	}
+	return nil
+}
+
+func (s *JobService) deletePrefix(ctx context.Context, spec jobs.Spec, t *jobs.Tracker) error {
+	files, err := s.storage.ListFiles(ctx, spec.Prefix)
+	if err != nil {
+		return err
+	}
+	t.SetTotal(len(files))
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := s.storage.DeleteFile(ctx, file.Name); err != nil {
+			t.Fail(file.Name, err)
+			continue
+		}
+		t.Succeed()
+	}
+	return nil
+}
+
+// purgeTrash deletes the trashed files under the spec's prefix that are older than the retention
+func (s *JobService) purgeTrash(ctx context.Context, spec jobs.Spec, t *jobs.Tracker) error {
+	trashed, err := listTrash(ctx, s.storage, s.trash.Prefix, spec.Prefix)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-s.trash.Retention)
+	expired := slices.DeleteFunc(trashed, func(file TrashedFile) bool {
+		return file.DeletedAt.After(cutoff)
+	})
+	t.SetTotal(len(expired))
+
+	for _, file := range expired {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Another replica may have purged it already
+		if err := s.storage.DeleteFile(ctx, file.Name); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			t.Fail(file.Name, err)
+			continue
+		}
+		t.Succeed()
+	}
+	return nil
+}
+
+// bulkRead packages the requested files into a zip archive stored in the bucket
+func (s *JobService) bulkRead(ctx context.Context, spec jobs.Spec, t *jobs.Tracker) error {
+	paths := spec.Paths
+	if spec.Manifest != "" {
+		listed, err := s.readManifest(ctx, spec.Manifest)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, listed...)
+	}
+	if spec.Prefix != "" {
+		files, err := s.storage.ListFiles(ctx, spec.Prefix)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			paths = append(paths, file.Name)
+		}
+	}
+	t.SetTotal(len(paths))
+
+	resultPath := path.Join(s.resultPrefix, t.JobID(), "results.zip")
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		zw := zip.NewWriter(pw)
+		for _, filePath := range paths {
+			if ctx.Err() != nil {
+				pw.CloseWithError(ctx.Err())
+				return
+			}
+			if moderation.InQuarantine(s.quarantine, filePath) {
+				t.Fail(filePath, ErrQuarantined)
+				continue
+			}
+			fileData, err := s.storage.ReadFile(ctx, filePath)
+			if err != nil {
+				t.Fail(filePath, err)
+				continue
+			}
+			f, err := zw.Create(filePath)
+			if err == nil {
+				_, err = f.Write(fileData.Content)
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			t.Succeed()
+		}
+		pw.CloseWithError(zw.Close())
+	}()
+
+	response, err := s.storage.WriteFiles(ctx, []storage.WriteRequest{{
+		Path:        resultPath,
+		Content:     pr,
+		ContentType: "application/zip",
+		Envelope:    s.envelope,
+	}})
+	// Unblock the archive writer if storage stopped reading early
+	pr.CloseWithError(io.ErrClosedPipe)
+	<-done
+
+	if err == nil && len(response.Errors) > 0 {
+		err = errors.New(response.Errors[0].Error)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store results: %w", err)
+	}
+
+	t.SetResult(resultPath)
+	return nil
+}
+
+// readManifest loads a list of file paths stored in the bucket, either as a JSON
+// array or as one path per line
+func (s *JobService) readManifest(ctx context.Context, manifestPath string) ([]string, error) {
+	fileData, err := s.storage.ReadFile(ctx, manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	content := bytes.TrimSpace(fileData.Content)
+	if bytes.HasPrefix(content, []byte("[")) {
+		var paths []string
+		if err := json.Unmarshal(content, &paths); err != nil {
+			return nil, fmt.Errorf("%w: manifest is not a JSON array of paths: %v", ErrInvalidJob, err)
+		}
+		return paths, nil
+	}
+
+	var paths []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, nil
+}
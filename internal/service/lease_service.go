@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/lease"
+)
+
+// WithLeases lets clients lease paths to coordinate writes. With required,
+// writes to a leased path fail unless they carry the lease's ID; otherwise
+// leases are advisory.
+func WithLeases(manager *lease.Manager, required bool) Option {
+	return func(s *StorageService) {
+		s.leases = manager
+		s.leasesRequired = required
+	}
+}
+
+// AcquireLease leases filePath to the caller for ttl, or the default duration if ttl is zero
+func (s *StorageService) AcquireLease(ctx context.Context, filePath string, ttl time.Duration) (*lease.Lease, error) {
+	if s.leases == nil {
+		return nil, ErrLeasesDisabled
+	}
+	return s.leases.Acquire(ctx, filePath, auth.PrincipalFromContext(ctx), ttl)
+}
+
+// RenewLease extends a lease by ttl from now
+func (s *StorageService) RenewLease(ctx context.Context, filePath, id string, ttl time.Duration) (*lease.Lease, error) {
+	if s.leases == nil {
+		return nil, ErrLeasesDisabled
+	}
+	return s.leases.Renew(ctx, filePath, id, ttl)
+}
+
+// ReleaseLease ends a lease before it expires
+func (s *StorageService) ReleaseLease(ctx context.Context, filePath, id string) error {
+	if s.leases == nil {
+		return ErrLeasesDisabled
+	}
+	return s.leases.Release(ctx, filePath, id)
+}
+
+// GetLease returns the current lease on filePath
+func (s *StorageService) GetLease(ctx context.Context, filePath string) (*lease.Lease, error) {
+	if s.leases == nil {
+		return nil, ErrLeasesDisabled
+	}
+	return s.leases.Get(ctx, filePath)
+}
+
+// checkLeases rejects writes to leased paths unless the request holds their
+// leases, when leases are required
+func (s *StorageService) checkLeases(ctx context.Context, paths ...string) error {
+	if s.leases == nil || !s.leasesRequired {
+		return nil
+	}
+	ids := lease.IDsFromContext(ctx)
+	for _, path := range paths {
+		if err := s.leases.Check(ctx, path, ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Listing holds the files under a prefix and, when listed with a delimiter, the
+// common prefixes standing in for the files nested below them
+type Listing struct {
+	Files    []storage.FileMetadata `json:"files"`
+	Prefixes []string               `json:"prefixes,omitempty"`
+}
+
+// List lists the files under prefix. With a delimiter, files whose names contain
+// it after the prefix are grouped into common prefixes, like directories.
+func (s *StorageService) List(ctx context.Context, prefix, delimiter string) (*Listing, error) {
+	files, err := s.listFiles(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return groupListing(s.hideQuarantined(ctx, files), prefix, delimiter), nil
+}
+
+// ListNames is List with only the names of the files, which is faster for large prefixes
+func (s *StorageService) ListNames(ctx context.Context, prefix, delimiter string) (*Listing, error) {
+	if s.catalog != nil && s.catalogListings {
+		return s.List(ctx, prefix, delimiter)
+	}
+	names, err := s.storage.ListNames(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]storage.FileMetadata, 0, len(names))
+	for _, name := range names {
+		files = append(files, storage.FileMetadata{Name: name})
+	}
+	return groupListing(s.hideQuarantined(ctx, files), prefix, delimiter), nil
+}
+
+// ListingEntry is one entry of a walked listing: a file or, with a delimiter, a common prefix
+type ListingEntry struct {
+	File   *storage.FileMetadata
+	Prefix string
+}
+
+// WalkList is List calling fn with each entry as the listing is paged in, so
+// large listings are never held in memory. It stops at the first error fn returns.
+func (s *StorageService) WalkList(ctx context.Context, prefix, delimiter string, fn func(ListingEntry) error) error {
+	// Names sharing a common prefix are adjacent in lexical order, so only the last one is remembered
+	var last string
+	return s.walkFiles(ctx, prefix, func(file storage.FileMetadata) error {
+		if s.checkQuarantine(ctx, file.Name) != nil {
+			return nil
+		}
+		if delimiter != "" {
+			rest := strings.TrimPrefix(file.Name, prefix)
+			if i := strings.Index(rest, delimiter); i >= 0 {
+				common := prefix + rest[:i+len(delimiter)]
+				if common == last {
+					return nil
+				}
+				last = common
+				return fn(ListingEntry{Prefix: common})
+			}
+		}
+		return fn(ListingEntry{File: &file})
+	})
+}
+
+// groupListing groups files by the part of their name up to the first delimiter after prefix
+func groupListing(files []storage.FileMetadata, prefix, delimiter string) *Listing {
+	listing := &Listing{Files: make([]storage.FileMetadata, 0, len(files))}
+	if delimiter == "" {
+		listing.Files = append(listing.Files, files...)
+		return listing
+	}
+
+	seen := make(map[string]bool)
+	for _, file := range files {
+		rest := strings.TrimPrefix(file.Name, prefix)
+		if i := strings.Index(rest, delimiter); i >= 0 {
+			common := prefix + rest[:i+len(delimiter)]
+			if !seen[common] {
+				seen[common] = true
+				listing.Prefixes = append(listing.Prefixes, common)
+			}
+			continue
+		}
+		listing.Files = append(listing.Files, file)
+	}
+	return listing
+}
+
+// CopyFile copies a file to dstPath, replacing any file there, and notifies publishers of the new object
+func (s *StorageService) CopyFile(ctx context.Context, srcPath, dstPath string) (*storage.FileMetadata, error) {
+	if srcPath == dstPath {
+		return nil, fmt.Errorf("%w: source and destination are the same", ErrInvalidCopy)
+	}
+	if err := policy.Authorize(ctx, policy.ActionRead, srcPath); err != nil {
+		return nil, err
+	}
+	if err := policy.Authorize(ctx, policy.ActionWrite, dstPath); err != nil {
+		return nil, err
+	}
+	if err := s.checkQuarantine(ctx, srcPath); err != nil {
+		return nil, err
+	}
+	if err := s.checkQuota(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.checkLeases(ctx, dstPath); err != nil {
+		return nil, err
+	}
+	if err := s.storage.CopyFile(ctx, srcPath, dstPath); err != nil {
+		return nil, err
+	}
+	s.reindexTags(ctx, dstPath)
+	file, err := s.storage.StatFile(ctx, dstPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordQuota(ctx, []storage.FileMetadata{*file})
+	s.publishWritten(ctx, []storage.WriteRequest{{Path: dstPath}}, []storage.FileMetadata{*file})
+	return file, nil
+}
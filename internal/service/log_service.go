@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+
+	"gcp-proxy-mity/internal/appendlog"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// WithAppendLogs enables append-only logs
+func WithAppendLogs(logs *appendlog.Logs) Option {
+	return func(s *StorageService) {
+		s.logs = logs
+	}
+}
+
+// AppendLog adds a record to the end of the log at logPath and counts it against the caller's quotas
+func (s *StorageService) AppendLog(ctx context.Context, logPath string, data []byte) (*appendlog.Record, error) {
+	if s.logs == nil {
+		return nil, ErrLogsDisabled
+	}
+	if err := s.checkQuota(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.checkLeases(ctx, logPath); err != nil {
+		return nil, err
+	}
+	record, err := s.logs.Append(ctx, logPath, data)
+	if err != nil {
+		return nil, err
+	}
+	s.recordQuota(ctx, []storage.FileMetadata{{Name: logPath, Size: record.Size}})
+	return record, nil
+}
+
+// ReadLog returns up to limit bytes of the log at logPath from offset
+func (s *StorageService) ReadLog(ctx context.Context, logPath string, offset, limit int64) (*appendlog.Segment, error) {
+	if s.logs == nil {
+		return nil, ErrLogsDisabled
+	}
+	return s.logs.Read(ctx, logPath, offset, limit)
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"maps"
+
+	"gcp-proxy-mity/internal/imaging"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// MetadataScrubbedKey is the object metadata key recording that image metadata was stripped
+const MetadataScrubbedKey = "metadata-scrubbed"
+
+// WithMetadataStripping strips image metadata on every write, not only when requested
+func WithMetadataStripping(enabled bool) Option {
+	return func(s *StorageService) {
+		s.stripMetadata = enabled
+	}
+}
+
+// stripImageMetadata wraps the content of supported images with a metadata stripping reader.
+// Requests whose images cannot be parsed are returned as write errors instead of being stored.
+func stripImageMetadata(requests []storage.WriteRequest) ([]storage.WriteRequest, []storage.WriteError) {
+	accepted := make([]storage.WriteRequest, 0, len(requests))
+	var rejected []storage.WriteError
+
+	for _, req := range requests {
+		contentType := req.ContentType
+		if contentType == "" {
+			contentType = storage.DetectContentType(req.Path)
+		}
+		if !imaging.IsScrubbable(contentType) {
+			accepted = append(accepted, req)
+			continue
+		}
+
+		content, err := imaging.StripMetadata(contentType, req.Content)
+		if err != nil {
+			rejected = append(rejected, storage.WriteError{
+				FilePath: req.Path,
+				Error:    "failed to strip image metadata: " + err.Error(),
+			})
+			continue
+		}
+
+		req.Content = content
+		req.Metadata = maps.Clone(req.Metadata)
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]string)
+		}
+		req.Metadata[MetadataScrubbedKey] = "true"
+		accepted = append(accepted, req)
+	}
+
+	return accepted, rejected
+}
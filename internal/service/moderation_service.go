@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/moderation"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// WithQuarantine restricts the files moderation moved under prefix to keys with the admin scope
+func WithQuarantine(prefix string) Option {
+	return func(s *StorageService) {
+		s.quarantinePrefix = prefix
+	}
+}
+
+// checkQuarantine refuses quarantined files to keys without the admin scope
+func (s *StorageService) checkQuarantine(ctx context.Context, filePaths ...string) error {
+	if s.quarantinePrefix == "" || auth.HasScope(ctx, auth.ScopeAdmin) {
+		return nil
+	}
+	for _, filePath := range filePaths {
+		if moderation.InQuarantine(s.quarantinePrefix, filePath) {
+			return ErrQuarantined
+		}
+	}
+	return nil
+}
+
+// hideQuarantined drops quarantined files from a listing for keys without the admin scope
+func (s *StorageService) hideQuarantined(ctx context.Context, files []storage.FileMetadata) []storage.FileMetadata {
+	if s.quarantinePrefix == "" || auth.HasScope(ctx, auth.ScopeAdmin) {
+		return files
+	}
+	visible := make([]storage.FileMetadata, 0, len(files))
+	for _, file := range files {
+		if !moderation.InQuarantine(s.quarantinePrefix, file.Name) {
+			visible = append(visible, file)
+		}
+	}
+	return visible
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/mount"
+)
+
+// MountService lets operators add and remove storage routes at runtime
+type MountService struct {
+	mounts *mount.Table
+}
+
+// NewMountService creates a mount service managing the mounts of table
+func NewMountService(table *mount.Table) *MountService {
+	return &MountService{
+		mounts: table,
+	}
+}
+
+// ListMounts returns the configured and added mounts by prefix
+func (s *MountService) ListMounts() []mount.Mount {
+	return s.mounts.List()
+}
+
+// CreateMount mounts a backend at a prefix on behalf of the caller; files
+// already under the prefix in the bucket are hidden until it is removed
+func (s *MountService) CreateMount(ctx context.Context, m mount.Mount) (*mount.Mount, error) {
+	return s.mounts.Create(ctx, m, auth.PrincipalFromContext(ctx))
+}
+
+// DeleteMount removes the mount at prefix, leaving its files in its backend
+func (s *MountService) DeleteMount(ctx context.Context, prefix string) error {
+	return s.mounts.Delete(ctx, prefix)
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gcp-proxy-mity/internal/media"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// WithFrameExtractor enables poster-frame extraction for stored videos
+func WithFrameExtractor(extractor media.FrameExtractor) Option {
+	return func(s *StorageService) {
+		s.frames = extractor
+	}
+}
+
+// PosterPath returns where the poster frame of a video taken at the given offset is stored
+func PosterPath(videoPath string, at time.Duration) string {
+	return fmt.Sprintf("%s.poster-%dms.jpg", videoPath, at.Milliseconds())
+}
+
+// ReadPoster returns a JPEG frame of a stored video, extracting and storing it next to the video on first use
+func (s *StorageService) ReadPoster(ctx context.Context, videoPath string, at time.Duration) (*storage.FileData, error) {
+	if s.frames == nil {
+		return nil, ErrFrameExtractionDisabled
+	}
+	if err := s.checkQuarantine(ctx, videoPath); err != nil {
+		return nil, err
+	}
+
+	switch storage.DetectContentType(videoPath) {
+	case "video/mp4", "video/webm", "video/quicktime":
+	default:
+		return nil, ErrNotVideo
+	}
+
+	posterPath := PosterPath(videoPath, at)
+	if poster, err := s.storage.ReadFile(ctx, posterPath); err == nil {
+		return poster, nil
+	}
+
+	video, err := s.storage.ReadFile(ctx, videoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	frame, err := s.frames.ExtractFrame(ctx, bytes.NewReader(video.Content), at)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.storage.WriteFiles(ctx, []storage.WriteRequest{{
+		Path:        posterPath,
+		Content:     bytes.NewReader(frame),
+		ContentType: "image/jpeg",
+		Envelope:    s.Envelope(posterPath) || video.Metadata.Envelope,
+	}})
+	if err == nil && len(response.Errors) > 0 {
+		err = errors.New(response.Errors[0].Error)
+	}
+	if err != nil {
+		log.Printf("Failed to store poster frame %s: %v", posterPath, err)
+	}
+
+	return &storage.FileData{
+		Metadata: storage.FileMetadata{
+			Name:        posterPath,
+			ContentType: "image/jpeg",
+			Size:        int64(len(frame)),
+		},
+		Content: frame,
+	}, nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/quota"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// WithQuotas counts the uploads of every API key and enforces their quotas
+func WithQuotas(tracker *quota.Tracker) Option {
+	return func(s *StorageService) {
+		s.quotas = tracker
+	}
+}
+
+// checkQuota rejects writes from a principal that used up a quota. Requests
+// without a principal, e.g. with authentication disabled, are not counted.
+func (s *StorageService) checkQuota(ctx context.Context) error {
+	principal := auth.PrincipalFromContext(ctx)
+	if s.quotas == nil || principal == "" {
+		return nil
+	}
+	return s.quotas.Check(ctx, principal)
+}
+
+// recordQuota counts written files against the principal's quotas
+func (s *StorageService) recordQuota(ctx context.Context, files []storage.FileMetadata) {
+	principal := auth.PrincipalFromContext(ctx)
+	if s.quotas == nil || principal == "" || len(files) == 0 {
+		return
+	}
+	var bytes int64
+	for _, file := range files {
+		bytes += file.Size
+	}
+	s.quotas.Record(ctx, principal, bytes, int64(len(files)))
+}
+
+// Usage returns the caller's upload consumption against its quotas
+func (s *StorageService) Usage(ctx context.Context) (*quota.Usage, error) {
+	if s.quotas == nil {
+		return nil, ErrQuotasDisabled
+	}
+	principal := auth.PrincipalFromContext(ctx)
+	if principal == "" {
+		return nil, ErrNoPrincipal
+	}
+	return s.quotas.Usage(ctx, principal)
+}
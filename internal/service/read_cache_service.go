@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/cache"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Read cache backends
+const (
+	CacheBackendMemory = "memory"
+	CacheBackendRedis  = "redis"
+)
+
+// warmConcurrency is how many objects a warm request reads at a time
+const warmConcurrency = 8
+
+// cdnTimeout bounds each request sent through the CDN
+const cdnTimeout = 30 * time.Second
+
+// ReadCacheConfig lets operations fill, inspect and purge the read cache
+type ReadCacheConfig struct {
+	Cache cache.Cache
+	// Backend is CacheBackendMemory or CacheBackendRedis
+	Backend        string
+	MaxBytes       int64
+	MaxObjectBytes int64
+	// Size reports the entries and bytes held, for caches that can tell
+	Size func() (entries int, bytes int64)
+	// CDNBaseURL is the public URL of the proxy behind the CDN, which warm
+	// requests send HEAD requests to
+	CDNBaseURL string
+	// CDNSigner signs a CDN token for each HEAD request, so they get through
+	// when API keys are required
+	CDNSigner *auth.CDNSigner
+}
+
+// CacheWarmResult tells what became of each object of a warm request
+type CacheWarmResult struct {
+	// Warmed were read into the cache, Cached were in it already
+	Warmed []string `json:"warmed"`
+	Cached []string `json:"cached"`
+	// Skipped are too large or envelope encrypted, or stored where reads aren't cached
+	Skipped []string `json:"skipped"`
+	// CDN were fetched through the CDN
+	CDN    []string     `json:"cdn,omitempty"`
+	Errors []CacheError `json:"errors"`
+}
+
+// CacheError is an object a warm request failed on
+type CacheError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ReadCacheStats describes the read cache and its outcomes
+type ReadCacheStats struct {
+	Backend        string `json:"backend"`
+	MaxBytes       int64  `json:"max_bytes,omitempty"`
+	MaxObjectBytes int64  `json:"max_object_bytes"`
+	// Entries and Bytes are only known for the in-memory cache
+	Entries *int   `json:"entries,omitempty"`
+	Bytes   *int64 `json:"bytes,omitempty"`
+	storage.CacheCounts
+}
+
+// WithReadCache enables warming, purging and reporting on the read cache
+func WithReadCache(cfg ReadCacheConfig) Option {
+	return func(s *StorageService) {
+		s.readCache = &cfg
+		s.cdnClient = &http.Client{Timeout: cdnTimeout}
+	}
+}
+
+// WarmCache reads the objects at paths and under prefix into the read cache,
+// and with cdn requests them through the CDN as well
+func (s *StorageService) WarmCache(ctx context.Context, paths []string, prefix string, cdn bool) (*CacheWarmResult, error) {
+	if s.readCache == nil {
+		return nil, ErrCacheDisabled
+	}
+	if cdn && s.readCache.CDNBaseURL == "" {
+		return nil, fmt.Errorf("%w: warming the CDN requires a public base URL", ErrInvalidCacheRequest)
+	}
+
+	result := &CacheWarmResult{Warmed: []string{}, Cached: []string{}, Skipped: []string{}, Errors: []CacheError{}}
+	var files []storage.FileMetadata
+	if prefix != "" {
+		listed, err := s.storage.ListFiles(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		files = listed
+	}
+	for _, filePath := range paths {
+		if slices.ContainsFunc(files, func(file storage.FileMetadata) bool { return file.Name == filePath }) {
+			continue
+		}
+		file, err := s.storage.StatFile(ctx, filePath)
+		if err != nil {
+			result.Errors = append(result.Errors, CacheError{Path: filePath, Error: err.Error()})
+			continue
+		}
+		files = append(files, *file)
+	}
+	if err := s.checkBatchSize(len(files)); err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, warmConcurrency)
+	for _, file := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			outcome, err := s.warmFile(ctx, file)
+			var cdnErr error
+			if err == nil && cdn {
+				cdnErr = s.warmCDN(ctx, file.Name)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				result.Errors = append(result.Errors, CacheError{Path: file.Name, Error: err.Error()})
+				return
+			case outcome == warmWarmed:
+				result.Warmed = append(result.Warmed, file.Name)
+			case outcome == warmCached:
+				result.Cached = append(result.Cached, file.Name)
+			default:
+				result.Skipped = append(result.Skipped, file.Name)
+			}
+			if cdnErr != nil {
+				result.Errors = append(result.Errors, CacheError{Path: file.Name, Error: cdnErr.Error()})
+			} else if cdn {
+				result.CDN = append(result.CDN, file.Name)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, list := range [][]string{result.Warmed, result.Cached, result.Skipped, result.CDN} {
+		slices.Sort(list)
+	}
+	slices.SortFunc(result.Errors, func(a, b CacheError) int { return strings.Compare(a.Path, b.Path) })
+	return result, nil
+}
+
+// Outcomes of warming an object
+const (
+	warmWarmed = iota
+	warmCached
+	warmSkipped
+)
+
+// warmFile reads file into the cache unless it is there already or wouldn't be kept
+func (s *StorageService) warmFile(ctx context.Context, file storage.FileMetadata) (int, error) {
+	if file.Envelope || file.Size > s.readCache.MaxObjectBytes {
+		return warmSkipped, nil
+	}
+	key := storage.ReadCacheKey(file.Name)
+	if s.readCache.Cache.Has(ctx, key) {
+		return warmCached, nil
+	}
+	if _, err := s.storage.ReadFile(ctx, file.Name); err != nil {
+		return 0, err
+	}
+	// Objects of mounts without caching are read but not kept
+	if !s.readCache.Cache.Has(ctx, key) {
+		return warmSkipped, nil
+	}
+	return warmWarmed, nil
+}
+
+// warmCDN sends a HEAD request for the object through the CDN, so the CDN
+// fetches it from the proxy if it doesn't hold it yet
+func (s *StorageService) warmCDN(ctx context.Context, filePath string) error {
+	segments := strings.Split(filePath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	target := strings.TrimSuffix(s.readCache.CDNBaseURL, "/") + "/api/v1/storage/files/" + strings.Join(segments, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return err
+	}
+	// The token is sent as a cookie rather than in the query, which would be part of the CDN's cache key
+	if s.readCache.CDNSigner != nil {
+		token, err := s.readCache.CDNSigner.Sign(target, time.Now().Add(cdnTimeout))
+		if err != nil {
+			return err
+		}
+		req.AddCookie(&http.Cookie{Name: auth.CDNCookieName, Value: token.Cookie})
+	}
+	resp, err := s.cdnClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("CDN request failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CDN answered %s", resp.Status)
+	}
+	return nil
+}
+
+// PurgeCache drops the objects at paths and under prefix from the read cache
+// and returns how many were dropped. Objects under prefix are found by listing
+// the bucket, so objects deleted outside the proxy are purged by path.
+func (s *StorageService) PurgeCache(ctx context.Context, paths []string, prefix string) (int, error) {
+	if s.readCache == nil {
+		return 0, ErrCacheDisabled
+	}
+	purge := slices.Clone(paths)
+	if prefix != "" {
+		names, err := s.storage.ListNames(ctx, prefix)
+		if err != nil {
+			return 0, err
+		}
+		purge = append(purge, names...)
+	}
+	for _, filePath := range purge {
+		s.readCache.Cache.Delete(ctx, storage.ReadCacheKey(filePath))
+	}
+	return len(purge), nil
+}
+
+// ReadCacheStats reports the size of the read cache and its hits and misses
+func (s *StorageService) ReadCacheStats() (*ReadCacheStats, error) {
+	if s.readCache == nil {
+		return nil, ErrCacheDisabled
+	}
+	stats := &ReadCacheStats{
+		Backend:        s.readCache.Backend,
+		MaxBytes:       s.readCache.MaxBytes,
+		MaxObjectBytes: s.readCache.MaxObjectBytes,
+		CacheCounts:    storage.ReadCacheCounts(),
+	}
+	if s.readCache.Size != nil {
+		entries, bytes := s.readCache.Size()
+		stats.Entries, stats.Bytes = &entries, &bytes
+	}
+	return stats, nil
+}
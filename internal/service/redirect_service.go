@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// RedirectRule redirects downloads of objects whose path matches Pattern to
+// the bucket, once they are at least MinBytes large
+type RedirectRule struct {
+	// Pattern is a glob like those of CacheControlRule
+	Pattern  string
+	MinBytes int64
+}
+
+type redirectRule struct {
+	RedirectRule
+	pattern *regexp.Regexp
+}
+
+// WithRedirects answers downloads matching a rule with signed URLs of the
+// bucket valid for urlTTL, so their bytes don't pass through the proxy; the
+// first matching rule wins
+func WithRedirects(rules []RedirectRule, urlTTL time.Duration) Option {
+	return func(s *StorageService) {
+		s.redirects = nil
+		for _, rule := range rules {
+			s.redirects = append(s.redirects, redirectRule{rule, globPattern(rule.Pattern)})
+		}
+		s.redirectTTL = urlTTL
+	}
+}
+
+// DownloadURL returns a signed URL of the bucket to redirect a download of
+// filePath to, or "" when the download is proxied: no rule matches, the object
+// is smaller than the rule's MinBytes, or the proxy has to decode it.
+// disposition returns the Content-Disposition the bucket answers with.
+func (s *StorageService) DownloadURL(ctx context.Context, filePath string, disposition func(storage.FileMetadata) string) (string, error) {
+	rule, ok := s.redirectRule(filePath)
+	// Objects encrypted with a customer-supplied key can't be read without it
+	if !ok || storage.EncryptionKeyFromContext(ctx) != nil {
+		return "", nil
+	}
+	metadata, err := s.StatFile(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	if metadata.Size < rule.MinBytes || metadata.Envelope || metadata.ContentEncoding != "" {
+		return "", nil
+	}
+
+	signedURL, err := s.storage.SignedDownloadURL(ctx, filePath, disposition(*metadata), time.Now().Add(s.redirectTTL))
+	if errors.Is(err, storage.ErrUnsupported) {
+		// Mounts of local directories have no URLs to redirect to
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if s.downloadStats != nil {
+		s.downloadStats.Record(metadata.Name, metadata.Size)
+	}
+	return signedURL, nil
+}
+
+func (s *StorageService) redirectRule(filePath string) (RedirectRule, bool) {
+	for _, rule := range s.redirects {
+		if rule.pattern.MatchString(filePath) {
+			return rule.RedirectRule, true
+		}
+	}
+	return RedirectRule{}, false
+}
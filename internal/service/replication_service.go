@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"path"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/jobs"
+	"gcp-proxy-mity/internal/metrics"
+	"gcp-proxy-mity/internal/storage"
+)
+
+var (
+	replicaChecks  = metrics.NewCounter("gcs_proxy_replication_verified_objects_total", "Objects compared with their replica")
+	replicaDrifts  = metrics.NewCounter("gcs_proxy_replication_drift_total", "Replicas found missing or different from their object")
+	replicaRepairs = metrics.NewCounter("gcs_proxy_replication_repaired_total", "Drifted replicas copied again")
+)
+
+// replicationGrace is how old an object must be to be verified; newer ones
+// may still be waiting in the replication queue
+const replicationGrace = time.Hour
+
+// Replicas compares objects with their replicas and copies them again
+type Replicas interface {
+	// Compare returns how the replica of an object differs from it, or "" if it matches
+	Compare(ctx context.Context, file storage.FileMetadata) (string, error)
+	Replicate(ctx context.Context, filePath string) error
+}
+
+// VerifyConfig configures verify-replication jobs; nil Replicas disables them
+type VerifyConfig struct {
+	Replicas Replicas
+	// Sample is how many objects a run compares, picked at random; 0 compares all
+	Sample int
+	// Repair copies drifted objects again
+	Repair bool
+	// Exclude lists the prefixes that are not replicated
+	Exclude []string
+}
+
+// replicationReport is stored as the result of a verify-replication job
+type replicationReport struct {
+	Prefix     string         `json:"prefix,omitempty"`
+	Objects    int            `json:"objects"`
+	Compared   int            `json:"compared"`
+	Drifted    []replicaDrift `json:"drifted"`
+	Repaired   int            `json:"repaired"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+}
+
+type replicaDrift struct {
+	Path     string `json:"path"`
+	Drift    string `json:"drift"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+}
+
+// verifyReplication compares a random sample of the objects under the spec's
+// prefix with their replicas and stores a report of the drift it found
+func (s *JobService) verifyReplication(ctx context.Context, spec jobs.Spec, t *jobs.Tracker) error {
+	report := replicationReport{Prefix: spec.Prefix, Drifted: []replicaDrift{}, StartedAt: time.Now().UTC()}
+	cutoff := report.StartedAt.Add(-replicationGrace)
+
+	// Reservoir sampling keeps an even sample without holding the whole listing
+	var sample []storage.FileMetadata
+	err := s.storage.WalkFiles(ctx, spec.Prefix, func(file storage.FileMetadata) error {
+		if file.Updated.After(cutoff) || s.notReplicated(file.Name) {
+			return nil
+		}
+		report.Objects++
+		switch {
+		case s.verify.Sample == 0 || len(sample) < s.verify.Sample:
+			sample = append(sample, file)
+		default:
+			if i := rand.IntN(report.Objects); i < s.verify.Sample {
+				sample[i] = file
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	t.SetTotal(len(sample))
+
+	for _, file := range sample {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		drift, err := s.verify.Replicas.Compare(ctx, file)
+		if err != nil {
+			t.Fail(file.Name, err)
+			continue
+		}
+		report.Compared++
+		replicaChecks.Inc()
+		if drift == "" {
+			t.Succeed()
+			continue
+		}
+
+		replicaDrifts.Inc()
+		found := replicaDrift{Path: file.Name, Drift: drift}
+		if s.verify.Repair {
+			if err := s.verify.Replicas.Replicate(ctx, file.Name); err != nil {
+				found.Error = err.Error()
+			} else {
+				found.Repaired = true
+				report.Repaired++
+				replicaRepairs.Inc()
+			}
+		}
+		report.Drifted = append(report.Drifted, found)
+		if found.Repaired {
+			t.Succeed()
+		} else {
+			t.Fail(file.Name, fmt.Errorf("%w: %s", ErrReplicaDrift, drift))
+		}
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	reportPath := path.Join(s.resultPrefix, t.JobID(), "report.json")
+	response, err := s.storage.WriteFiles(ctx, []storage.WriteRequest{{
+		Path:        reportPath,
+		Content:     bytes.NewReader(body),
+		ContentType: "application/json",
+	}})
+	if err == nil && len(response.Errors) > 0 {
+		err = errors.New(response.Errors[0].Error)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store report: %w", err)
+	}
+	t.SetResult(reportPath)
+	return nil
+}
+
+func (s *JobService) notReplicated(filePath string) bool {
+	for _, prefix := range s.verify.Exclude {
+		if strings.HasPrefix(filePath, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// GetRetention returns the holds and retention period of an object
+func (s *StorageService) GetRetention(ctx context.Context, filePath string) (*storage.Retention, error) {
+	return s.storage.GetRetention(ctx, filePath)
+}
+
+// SetHolds places or releases temporary and event-based holds on an object
+func (s *StorageService) SetHolds(ctx context.Context, filePath string, update storage.HoldUpdate) (*storage.Retention, error) {
+	if update.TemporaryHold == nil && update.EventBasedHold == nil {
+		return nil, fmt.Errorf("%w: set temporary_hold and/or event_based_hold", ErrInvalidHoldUpdate)
+	}
+	return s.storage.SetHolds(ctx, filePath, update)
+}
@@ -0,0 +1,447 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/mount"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// NewMountedStorage stores the files under each mount of the table in the
+// mount's backend, e.g. videos in one bucket and scratch files in a directory,
+// and holds them to the mount's access policy and quota. The mount with the
+// longest matching prefix wins, and other files stay in fallback.
+func NewMountedStorage(fallback storage.Storage, mounts *mount.Table) storage.Storage {
+	return &routedStorage{fallback: fallback, mounts: mounts}
+}
+
+// routedStorage sends each operation to the backend of the path it is about.
+// Listings spanning several backends are merged in lexical order, and each
+// backend only contributes the files routed to it, so files left in the
+// default backend under a mount's prefix are hidden.
+type routedStorage struct {
+	fallback storage.Storage
+	mounts   *mount.Table
+}
+
+// access is what an operation does to the files of a mount
+type access int
+
+const (
+	accessRead access = iota
+	// accessWrite changes files without storing more bytes, like deleting them
+	accessWrite
+	// accessStore stores bytes, which count against the mount's quota
+	accessStore
+)
+
+// routedItems are the items of a batch stored in one backend, in batch order
+type routedItems[T any] struct {
+	backend storage.Storage
+	mount   *mount.Entry
+	items   []T
+}
+
+// backend returns where filePath is stored and the mount it is under, nil if
+// it is in the default backend
+func (r *routedStorage) backend(filePath string) (storage.Storage, *mount.Entry) {
+	if entry := r.mounts.Resolve(filePath); entry != nil {
+		return entry.Storage, entry
+	}
+	return r.fallback, nil
+}
+
+// open returns the backend of filePath once its mount allows the access
+func (r *routedStorage) open(ctx context.Context, filePath string, a access) (storage.Storage, *mount.Entry, error) {
+	backend, entry := r.backend(filePath)
+	if err := authorize(ctx, entry, a); err != nil {
+		return nil, nil, err
+	}
+	return backend, entry, nil
+}
+
+// authorize checks that a mount, if any, allows the access
+func authorize(ctx context.Context, entry *mount.Entry, a access) error {
+	if entry == nil {
+		return nil
+	}
+	if err := entry.Authorize(ctx, a != accessRead); err != nil {
+		return err
+	}
+	if a == accessStore {
+		return entry.Reserve(ctx)
+	}
+	return nil
+}
+
+// record counts bytes stored under a mount, if any, against its quota
+func record(entry *mount.Entry, bytes int64) {
+	if entry != nil {
+		entry.Record(bytes)
+	}
+}
+
+// backendsUnder returns the backends that may hold files under prefix. The
+// listing fails if the mount prefix is under denies it, while the mounts below
+// prefix that deny it are left out.
+func (r *routedStorage) backendsUnder(ctx context.Context, prefix string) ([]storage.Storage, error) {
+	backend, _, err := r.open(ctx, prefix, accessRead)
+	if err != nil {
+		return nil, err
+	}
+	backends := []storage.Storage{backend}
+	for _, entry := range r.mounts.Entries() {
+		if len(entry.Prefix) > len(prefix) && strings.HasPrefix(entry.Prefix, prefix) && !slices.Contains(backends, entry.Storage) &&
+			authorize(ctx, entry, accessRead) == nil {
+			backends = append(backends, entry.Storage)
+		}
+	}
+	return backends, nil
+}
+
+// routedTo reports whether the file named name is stored in backend
+func (r *routedStorage) routedTo(name string, backend storage.Storage) bool {
+	owner, _ := r.backend(name)
+	return owner == backend
+}
+
+// groupByBackend splits a batch by the backend each item's path is routed to
+func groupByBackend[T any](r *routedStorage, items []T, path func(T) string) []routedItems[T] {
+	var groups []routedItems[T]
+	for _, item := range items {
+		backend, entry := r.backend(path(item))
+		i := slices.IndexFunc(groups, func(g routedItems[T]) bool { return g.backend == backend })
+		if i < 0 {
+			groups = append(groups, routedItems[T]{backend: backend, mount: entry})
+			i = len(groups) - 1
+		}
+		groups[i].items = append(groups[i].items, item)
+	}
+	return groups
+}
+
+func (r *routedStorage) WriteFiles(ctx context.Context, requests []storage.WriteRequest) (*storage.WriteResponse, error) {
+	response := &storage.WriteResponse{
+		FilesWritten: make([]storage.FileMetadata, 0),
+		Errors:       make([]storage.WriteError, 0),
+	}
+	for _, group := range groupByBackend(r, requests, func(req storage.WriteRequest) string { return req.Path }) {
+		if err := authorize(ctx, group.mount, accessStore); err != nil {
+			for _, req := range group.items {
+				response.Errors = append(response.Errors, storage.WriteError{FilePath: req.Path, Error: err.Error()})
+			}
+			continue
+		}
+		written, err := group.backend.WriteFiles(ctx, group.items)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range written.FilesWritten {
+			record(group.mount, file.Size)
+		}
+		response.FilesWritten = append(response.FilesWritten, written.FilesWritten...)
+		response.Errors = append(response.Errors, written.Errors...)
+		response.Deduplicated = append(response.Deduplicated, written.Deduplicated...)
+	}
+	return response, nil
+}
+
+func (r *routedStorage) CreateFile(ctx context.Context, req storage.WriteRequest) (*storage.FileMetadata, error) {
+	backend, entry, err := r.open(ctx, req.Path, accessStore)
+	if err != nil {
+		return nil, err
+	}
+	file, err := backend.CreateFile(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	record(entry, file.Size)
+	return file, nil
+}
+
+func (r *routedStorage) ReadFiles(ctx context.Context, filePaths []string) (*storage.ReadResponse, error) {
+	response := &storage.ReadResponse{
+		Files:  make([]storage.FileData, 0, len(filePaths)),
+		Errors: make([]storage.ReadError, 0),
+	}
+	for _, group := range groupByBackend(r, filePaths, func(filePath string) string { return filePath }) {
+		if err := authorize(ctx, group.mount, accessRead); err != nil {
+			for _, filePath := range group.items {
+				response.Errors = append(response.Errors, storage.ReadError{FilePath: filePath, Error: err.Error()})
+			}
+			continue
+		}
+		read, err := group.backend.ReadFiles(ctx, group.items)
+		if err != nil {
+			return nil, err
+		}
+		response.Files = append(response.Files, read.Files...)
+		response.Errors = append(response.Errors, read.Errors...)
+	}
+	return response, nil
+}
+
+func (r *routedStorage) ReadFile(ctx context.Context, filePath string) (*storage.FileData, error) {
+	backend, _, err := r.open(ctx, filePath, accessRead)
+	if err != nil {
+		return nil, err
+	}
+	return backend.ReadFile(ctx, filePath)
+}
+
+func (r *routedStorage) ReadRanges(ctx context.Context, ranges []storage.ReadRange) (*storage.ReadResponse, error) {
+	response := &storage.ReadResponse{
+		Files:  make([]storage.FileData, 0, len(ranges)),
+		Errors: make([]storage.ReadError, 0),
+	}
+	for _, group := range groupByBackend(r, ranges, func(rng storage.ReadRange) string { return rng.Path }) {
+		if err := authorize(ctx, group.mount, accessRead); err != nil {
+			for _, rng := range group.items {
+				response.Errors = append(response.Errors, storage.ReadError{FilePath: rng.Path, Error: err.Error()})
+			}
+			continue
+		}
+		read, err := group.backend.ReadRanges(ctx, group.items)
+		if err != nil {
+			return nil, err
+		}
+		response.Files = append(response.Files, read.Files...)
+		response.Errors = append(response.Errors, read.Errors...)
+	}
+	return response, nil
+}
+
+func (r *routedStorage) StatFile(ctx context.Context, filePath string) (*storage.FileMetadata, error) {
+	backend, _, err := r.open(ctx, filePath, accessRead)
+	if err != nil {
+		return nil, err
+	}
+	return backend.StatFile(ctx, filePath)
+}
+
+func (r *routedStorage) ListFiles(ctx context.Context, prefix string) ([]storage.FileMetadata, error) {
+	files := make([]storage.FileMetadata, 0)
+	err := r.WalkFiles(ctx, prefix, func(file storage.FileMetadata) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (r *routedStorage) ListNames(ctx context.Context, prefix string) ([]string, error) {
+	backends, err := r.backendsUnder(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, backend := range backends {
+		listed, err := backend.ListNames(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range listed {
+			if r.routedTo(name, backend) {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(backends) > 1 {
+		slices.Sort(names)
+	}
+	return names, nil
+}
+
+// WalkFiles walks a single backend as its listing is paged in; listings
+// spanning several backends are collected and sorted first
+func (r *routedStorage) WalkFiles(ctx context.Context, prefix string, fn func(storage.FileMetadata) error) error {
+	backends, err := r.backendsUnder(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	if len(backends) == 1 {
+		return backends[0].WalkFiles(ctx, prefix, func(file storage.FileMetadata) error {
+			if !r.routedTo(file.Name, backends[0]) {
+				return nil
+			}
+			return fn(file)
+		})
+	}
+
+	var files []storage.FileMetadata
+	for _, backend := range backends {
+		err := backend.WalkFiles(ctx, prefix, func(file storage.FileMetadata) error {
+			if r.routedTo(file.Name, backend) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	slices.SortFunc(files, func(a, b storage.FileMetadata) int { return strings.Compare(a.Name, b.Name) })
+	for _, file := range files {
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyFile copies between backends by reading the whole file, which keeps
+// its content type and custom metadata but not how it was stored, such as
+// its compression or storage class
+func (r *routedStorage) CopyFile(ctx context.Context, srcPath, dstPath string) error {
+	from, _, err := r.open(ctx, srcPath, accessRead)
+	if err != nil {
+		return err
+	}
+	to, entry, err := r.open(ctx, dstPath, accessStore)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		if err := from.CopyFile(ctx, srcPath, dstPath); err != nil {
+			return err
+		}
+		if entry != nil && entry.QuotaBytes > 0 {
+			if file, err := to.StatFile(ctx, dstPath); err == nil {
+				entry.Record(file.Size)
+			}
+		}
+		return nil
+	}
+
+	// The content is written as read, so it must be read decompressed
+	file, err := from.ReadFile(storage.WithAcceptedEncodings(ctx), srcPath)
+	if err != nil {
+		return err
+	}
+	custom, err := from.GetMetadata(ctx, srcPath)
+	if err != nil && !errors.Is(err, storage.ErrUnsupported) {
+		return err
+	}
+	response, err := to.WriteFiles(ctx, []storage.WriteRequest{{
+		Path:        dstPath,
+		Content:     bytes.NewReader(file.Content),
+		ContentType: file.Metadata.ContentType,
+		Metadata:    custom,
+	}})
+	if err != nil {
+		return err
+	}
+	if len(response.Errors) > 0 {
+		return errors.New(response.Errors[0].Error)
+	}
+	record(entry, int64(len(file.Content)))
+	return nil
+}
+
+func (r *routedStorage) ComposeFiles(ctx context.Context, dst storage.WriteRequest, srcPaths []string) (*storage.FileMetadata, error) {
+	backend, entry, err := r.open(ctx, dst.Path, accessStore)
+	if err != nil {
+		return nil, err
+	}
+	for _, srcPath := range srcPaths {
+		if !r.routedTo(srcPath, backend) {
+			return nil, fmt.Errorf("%w: %s is stored in another backend than %s", ErrInvalidCompose, srcPath, dst.Path)
+		}
+	}
+	file, err := backend.ComposeFiles(ctx, dst, srcPaths)
+	if err != nil {
+		return nil, err
+	}
+	record(entry, file.Size)
+	return file, nil
+}
+
+func (r *routedStorage) DeleteFile(ctx context.Context, filePath string) error {
+	backend, _, err := r.open(ctx, filePath, accessWrite)
+	if err != nil {
+		return err
+	}
+	return backend.DeleteFile(ctx, filePath)
+}
+
+func (r *routedStorage) SetStorageClass(ctx context.Context, filePath, storageClass string) (*storage.FileMetadata, error) {
+	backend, _, err := r.open(ctx, filePath, accessWrite)
+	if err != nil {
+		return nil, err
+	}
+	return backend.SetStorageClass(ctx, filePath, storageClass)
+}
+
+func (r *routedStorage) GetRetention(ctx context.Context, filePath string) (*storage.Retention, error) {
+	backend, _, err := r.open(ctx, filePath, accessRead)
+	if err != nil {
+		return nil, err
+	}
+	return backend.GetRetention(ctx, filePath)
+}
+
+func (r *routedStorage) SetHolds(ctx context.Context, filePath string, update storage.HoldUpdate) (*storage.Retention, error) {
+	backend, _, err := r.open(ctx, filePath, accessWrite)
+	if err != nil {
+		return nil, err
+	}
+	return backend.SetHolds(ctx, filePath, update)
+}
+
+func (r *routedStorage) GetAccess(ctx context.Context, filePath string) (*storage.Access, error) {
+	backend, _, err := r.open(ctx, filePath, accessRead)
+	if err != nil {
+		return nil, err
+	}
+	return backend.GetAccess(ctx, filePath)
+}
+
+func (r *routedStorage) SetAccess(ctx context.Context, filePath string, public bool) (*storage.Access, error) {
+	backend, _, err := r.open(ctx, filePath, accessWrite)
+	if err != nil {
+		return nil, err
+	}
+	return backend.SetAccess(ctx, filePath, public)
+}
+
+func (r *routedStorage) GetMetadata(ctx context.Context, filePath string) (map[string]string, error) {
+	backend, _, err := r.open(ctx, filePath, accessRead)
+	if err != nil {
+		return nil, err
+	}
+	return backend.GetMetadata(ctx, filePath)
+}
+
+func (r *routedStorage) UpdateMetadata(ctx context.Context, filePath string, metadata map[string]string) error {
+	backend, _, err := r.open(ctx, filePath, accessWrite)
+	if err != nil {
+		return err
+	}
+	return backend.UpdateMetadata(ctx, filePath, metadata)
+}
+
+// SignedUploadURL checks the quota of the mount when the URL is signed; the
+// bytes uploaded through it aren't counted until the usage is listed again
+func (r *routedStorage) SignedUploadURL(ctx context.Context, req storage.WriteRequest, expires time.Time) (string, error) {
+	backend, _, err := r.open(ctx, req.Path, accessStore)
+	if err != nil {
+		return "", err
+	}
+	return backend.SignedUploadURL(ctx, req, expires)
+}
+
+func (r *routedStorage) SignedDownloadURL(ctx context.Context, filePath, disposition string, expires time.Time) (string, error) {
+	backend, _, err := r.open(ctx, filePath, accessRead)
+	if err != nil {
+		return "", err
+	}
+	return backend.SignedDownloadURL(ctx, filePath, disposition, expires)
+}
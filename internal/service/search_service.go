@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/search"
+)
+
+// WithSearchIndex serves full-text searches of object names from index
+func WithSearchIndex(index *search.Index) Option {
+	return func(s *StorageService) {
+		s.search = index
+	}
+}
+
+// SearchText returns a page of the files whose names or metadata match text, best match first
+func (s *StorageService) SearchText(ctx context.Context, text string, offset, limit int) (*search.Result, error) {
+	if s.search == nil {
+		return nil, ErrSearchDisabled
+	}
+	if offset < 0 || limit < 1 {
+		return nil, fmt.Errorf("%w: offset must not be negative and limit must be positive", ErrInvalidSearch)
+	}
+
+	query := search.Query{Text: text, Offset: offset, Limit: limit}
+	if s.quarantinePrefix != "" && !auth.HasScope(ctx, auth.ScopeAdmin) {
+		query.Exclude = []string{strings.Trim(s.quarantinePrefix, "/") + "/"}
+	}
+	return s.search.Search(query)
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/share"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// WithShares lets clients share objects and prefixes through links that
+// work without an API key
+func WithShares(manager *share.Manager) Option {
+	return func(s *StorageService) {
+		s.shares = manager
+	}
+}
+
+// CreateShare shares an object or prefix on behalf of the caller. Objects
+// must exist when they are shared, and the caller must be able to read them.
+func (s *StorageService) CreateShare(ctx context.Context, request share.Request) (*share.Share, error) {
+	if s.shares == nil {
+		return nil, ErrSharesDisabled
+	}
+	if err := policy.Authorize(ctx, policy.ActionRead, request.Path); err != nil {
+		return nil, err
+	}
+	if err := s.checkQuarantine(ctx, request.Path); err != nil {
+		return nil, err
+	}
+	if request.Prefix {
+		// Walking up to the first file checks the scope of the prefix's mount
+		err := s.storage.WalkFiles(ctx, request.Path, func(storage.FileMetadata) error { return errStopWalk })
+		if err != nil && !errors.Is(err, errStopWalk) {
+			return nil, err
+		}
+	} else if _, err := s.storage.StatFile(ctx, request.Path); err != nil {
+		return nil, err
+	}
+	return s.shares.Create(ctx, request, auth.PrincipalFromContext(ctx))
+}
+
+// ListShares returns the caller's unexpired shares, or everyone's for keys
+// with the admin scope
+func (s *StorageService) ListShares(ctx context.Context) ([]share.Share, error) {
+	if s.shares == nil {
+		return nil, ErrSharesDisabled
+	}
+	return s.shares.List(ctx, s.shareOwner(ctx))
+}
+
+// RevokeShare deletes one of the caller's shares, or anyone's for keys with the admin scope
+func (s *StorageService) RevokeShare(ctx context.Context, token string) error {
+	if s.shares == nil {
+		return ErrSharesDisabled
+	}
+	existing, err := s.shares.Get(ctx, token)
+	if err != nil {
+		return err
+	}
+	// Other clients' shares are reported as missing rather than forbidden
+	if owner := s.shareOwner(ctx); owner != "" && existing.CreatedBy != owner {
+		return share.ErrNotFound
+	}
+	return s.shares.Revoke(ctx, token)
+}
+
+// ReadShare reads the object a share link serves, name below the prefix of
+// prefix shares. With count the read counts against the share's download limit.
+func (s *StorageService) ReadShare(ctx context.Context, token, name, password string, count bool) (*storage.FileData, error) {
+	if s.shares == nil {
+		return nil, ErrSharesDisabled
+	}
+	_, filePath, err := s.shares.Open(ctx, token, name, password)
+	if err != nil {
+		return nil, err
+	}
+	file, err := s.ReadFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if count {
+		if err := s.shares.CountDownload(ctx, token); err != nil {
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+// shareOwner returns whose shares the caller manages, "" for all of them
+func (s *StorageService) shareOwner(ctx context.Context) string {
+	if auth.HasScope(ctx, auth.ScopeAdmin) {
+		return ""
+	}
+	return auth.PrincipalFromContext(ctx)
+}
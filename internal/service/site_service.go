@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// SiteConfig configures serving a prefix of the bucket as a website
+type SiteConfig struct {
+	// Prefix holds the site's files; GET / serves Prefix/Index
+	Prefix string
+	// Index is the document served for directories, usually index.html
+	Index string
+	// SPAFallback serves the root index document for paths without a file
+	// extension that match no object, so client-side routes of single-page apps work
+	SPAFallback bool
+	// AssetMaxAge is the client cache lifetime of files other than HTML.
+	// Fingerprinted files like app.3f9a1c2e.js are cached for a year.
+	AssetMaxAge time.Duration
+}
+
+// SitePage is the object served for a website path
+type SitePage struct {
+	File         *storage.FileData
+	CacheControl string
+	// Redirect is set when a directory was requested without its trailing
+	// slash, so relative links in its index document resolve
+	Redirect string
+}
+
+// immutableMaxAge is the cache lifetime of fingerprinted files, whose name
+// changes whenever their content does
+const immutableMaxAge = 365 * 24 * time.Hour
+
+// WithSite serves the files under a prefix as a website
+func WithSite(cfg SiteConfig) Option {
+	return func(s *StorageService) {
+		cfg.Prefix = strings.Trim(cfg.Prefix, "/") + "/"
+		if cfg.Index == "" {
+			cfg.Index = "index.html"
+		}
+		s.site = &cfg
+	}
+}
+
+// ReadSitePage resolves a website path to an object under the site prefix:
+// the file itself, the index document of the directory, or with the SPA
+// fallback the root index document
+func (s *StorageService) ReadSitePage(ctx context.Context, urlPath string) (*SitePage, error) {
+	if s.site == nil {
+		return nil, ErrSiteDisabled
+	}
+	// Cleaning the rooted path keeps ".." from leaving the prefix
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	isDir := name == "" || strings.HasSuffix(urlPath, "/")
+
+	if !isDir {
+		file, err := s.readSiteFile(ctx, name)
+		if err == nil {
+			return s.sitePage(file), nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	file, err := s.readSiteFile(ctx, path.Join(name, s.site.Index))
+	if err == nil {
+		page := s.sitePage(file)
+		if !isDir {
+			page.Redirect = "/" + name + "/"
+		}
+		return page, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	if s.site.SPAFallback && name != "" && path.Ext(name) == "" {
+		file, err := s.readSiteFile(ctx, s.site.Index)
+		if err != nil {
+			return nil, err
+		}
+		return s.sitePage(file), nil
+	}
+	return nil, fmt.Errorf("site path /%s: %w", name, storage.ErrNotFound)
+}
+
+func (s *StorageService) readSiteFile(ctx context.Context, name string) (*storage.FileData, error) {
+	filePath := s.site.Prefix + name
+	if err := s.checkQuarantine(ctx, filePath); err != nil {
+		return nil, err
+	}
+	file, err := s.storage.ReadFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	s.recordDownloads(*file)
+	return file, nil
+}
+
+func (s *StorageService) sitePage(file *storage.FileData) *SitePage {
+	return &SitePage{File: file, CacheControl: s.siteCacheControl(file.Metadata.Name)}
+}
+
+// siteCacheControl makes browsers revalidate HTML so new deployments show up
+// at once, while the assets it references are cached. Cache-Control rules
+// matching the file take precedence.
+func (s *StorageService) siteCacheControl(filePath string) string {
+	if header := s.CacheControl(filePath); header != "" {
+		return header
+	}
+	switch ext := strings.ToLower(path.Ext(filePath)); {
+	case ext == ".html" || ext == ".htm" || ext == "":
+		return "no-cache"
+	case fingerprinted(path.Base(filePath)):
+		return fmt.Sprintf("public, max-age=%d, immutable", int(immutableMaxAge.Seconds()))
+	default:
+		return fmt.Sprintf("public, max-age=%d", int(s.site.AssetMaxAge.Seconds()))
+	}
+}
+
+// fingerprinted reports whether a file name carries a content hash the way
+// bundlers name their output, like main.3f9a1c2e.js or index-BdT4x9Qa.css
+func fingerprinted(name string) bool {
+	stem := strings.TrimSuffix(name, path.Ext(name))
+	parts := strings.FieldsFunc(stem, func(r rune) bool { return r == '.' || r == '-' || r == '_' })
+	for _, part := range parts[min(1, len(parts)):] {
+		if len(part) < 8 {
+			continue
+		}
+		var digits, letters int
+		for _, r := range part {
+			switch {
+			case r >= '0' && r <= '9':
+				digits++
+			case r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+				letters++
+			}
+		}
+		if digits > 0 && letters > 0 && digits+letters == len(part) {
+			return true
+		}
+	}
+	return false
+}
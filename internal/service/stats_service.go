@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+
+	"gcp-proxy-mity/internal/stats"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// WithDownloadStats counts the downloads of every object. The recorder also
+// receives deletions, so deleted objects drop out of the statistics.
+func WithDownloadStats(recorder *stats.Recorder) Option {
+	return func(s *StorageService) {
+		s.downloadStats = recorder
+		s.publishers = append(s.publishers, recorder)
+	}
+}
+
+// DownloadStats lists the download statistics of objects, e.g. the most
+// downloaded or least recently read ones
+func (s *StorageService) DownloadStats(ctx context.Context, query stats.Query) ([]stats.Stats, error) {
+	if s.downloadStats == nil {
+		return nil, ErrStatsDisabled
+	}
+	return s.downloadStats.List(ctx, query)
+}
+
+// ObjectStats returns the download statistics of one object
+func (s *StorageService) ObjectStats(ctx context.Context, filePath string) (*stats.Stats, error) {
+	if s.downloadStats == nil {
+		return nil, ErrStatsDisabled
+	}
+	return s.downloadStats.Get(ctx, filePath)
+}
+
+// recordDownloads counts the files as downloaded under their own names
+func (s *StorageService) recordDownloads(files ...storage.FileData) {
+	if s.downloadStats == nil {
+		return
+	}
+	for _, file := range files {
+		s.downloadStats.Record(file.Metadata.Name, int64(len(file.Content)))
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// StorageClasses lists the GCS storage classes accepted by the API
+var StorageClasses = []string{"STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE"}
+
+// StorageClassRule stores new objects under Prefix in StorageClass
+type StorageClassRule struct {
+	Prefix       string
+	StorageClass string
+}
+
+// WithStorageClasses selects storage classes by path prefix; the longest matching prefix wins
+func WithStorageClasses(rules []StorageClassRule) Option {
+	return func(s *StorageService) {
+		s.storageClasses = rules
+	}
+}
+
+// StorageClass stores the written objects in the given storage class, overriding prefix rules
+func StorageClass(storageClass string) WriteOption {
+	return func(o *writeOptions) {
+		o.storageClass = storageClass
+	}
+}
+
+// SetStorageClass moves an existing object to another storage class
+func (s *StorageService) SetStorageClass(ctx context.Context, filePath, storageClass string) (*storage.FileMetadata, error) {
+	storageClass, err := normalizeStorageClass(storageClass)
+	if err != nil {
+		return nil, err
+	}
+	return s.storage.SetStorageClass(ctx, filePath, storageClass)
+}
+
+// applyStorageClasses sets the storage class of each request. Requests without
+// an explicit class or matching rule use the bucket's default class.
+func (s *StorageService) applyStorageClasses(requests []storage.WriteRequest, explicit string) error {
+	if explicit != "" {
+		var err error
+		if explicit, err = normalizeStorageClass(explicit); err != nil {
+			return err
+		}
+	}
+
+	for i := range requests {
+		requests[i].StorageClass = explicit
+		if explicit == "" {
+			if rule, ok := longestPrefix(s.storageClasses, requests[i].Path, func(r StorageClassRule) string { return r.Prefix }); ok {
+				requests[i].StorageClass = rule.StorageClass
+			}
+		}
+	}
+	return nil
+}
+
+func normalizeStorageClass(storageClass string) (string, error) {
+	storageClass = strings.ToUpper(storageClass)
+	if !slices.Contains(StorageClasses, storageClass) {
+		return "", fmt.Errorf("%w: %q must be one of %s", ErrInvalidStorageClass, storageClass, strings.Join(StorageClasses, ", "))
+	}
+	return storageClass, nil
+}
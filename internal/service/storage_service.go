@@ -2,32 +2,389 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gcp-proxy-mity/internal/appendlog"
+	"gcp-proxy-mity/internal/catalog"
+	"gcp-proxy-mity/internal/changes"
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/fetch"
+	"gcp-proxy-mity/internal/lease"
+	"gcp-proxy-mity/internal/media"
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/quota"
+	"gcp-proxy-mity/internal/search"
+	"gcp-proxy-mity/internal/share"
+	"gcp-proxy-mity/internal/stats"
 	"gcp-proxy-mity/internal/storage"
 )
 
 // StorageService provides business logic for storage operations
 type StorageService struct {
-	storage storage.Storage
+	storage        storage.Storage
+	images         ImageConfig
+	stripMetadata  bool
+	frames         media.FrameExtractor
+	stream         StreamConfig
+	publishers     []events.Publisher
+	allowedTypes   atomic.Pointer[[]string]
+	distrustTypes  atomic.Bool
+	batch          BatchLimits
+	kmsKeys        []KMSKeyRule
+	storageClasses []StorageClassRule
+	uploads        UploadConfig
+	dedupPrefix    string
+	quotas         *quota.Tracker
+	trashPrefix    string
+	fetcher        *fetch.Fetcher
+	// quarantinePrefix holds files flagged by moderation, readable only by admins
+	quarantinePrefix string
+	tagPrefix        string
+	search           *search.Index
+	catalog          *catalog.Catalog
+	catalogListings  bool
+	changes          *changes.Feed
+	eventStream      *events.Broadcaster
+	leases           *lease.Manager
+	leasesRequired   bool
+	logs             *appendlog.Logs
+	site             *SiteConfig
+	cacheControl     []cacheControlRule
+	redirects        []redirectRule
+	redirectTTL      time.Duration
+	readCache        *ReadCacheConfig
+	cdnClient        *http.Client
+	compression      []CompressionRule
+	envelope         bool
+	envelopePrefixes []string
+	shares           *share.Manager
+	downloadStats    *stats.Recorder
+	usage            usageCache
+	expiryPrefix     string
 }
 
+// Option configures optional StorageService features
+type Option func(*StorageService)
+
 // NewStorageService creates a new storage service
-func NewStorageService(storage storage.Storage) *StorageService {
-	return &StorageService{
+func NewStorageService(storage storage.Storage, opts ...Option) *StorageService {
+	s := &StorageService{
 		storage: storage,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WriteOption configures a single write operation
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	stripMetadata bool
+	kmsKey        string
+	storageClass  string
+	expiresAfter  string
+	expiresAt     string
+}
+
+// StripMetadata removes EXIF/GPS metadata from supported images before they are stored
+func StripMetadata() WriteOption {
+	return func(o *writeOptions) {
+		o.stripMetadata = true
+	}
 }
 
 // WriteFiles writes multiple files to storage
-func (s *StorageService) WriteFiles(ctx context.Context, requests []storage.WriteRequest) (*storage.WriteResponse, error) {
-	return s.storage.WriteFiles(ctx, requests)
+func (s *StorageService) WriteFiles(ctx context.Context, requests []storage.WriteRequest, opts ...WriteOption) (*storage.WriteResponse, error) {
+	options := writeOptions{
+		stripMetadata: s.stripMetadata,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if err := s.checkBatchSize(len(requests)); err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(requests))
+	for _, req := range requests {
+		paths = append(paths, req.Path)
+	}
+	if err := policy.Authorize(ctx, policy.ActionWrite, paths...); err != nil {
+		return nil, err
+	}
+	if err := s.checkQuota(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.checkLeases(ctx, paths...); err != nil {
+		return nil, err
+	}
+	if err := s.applyKMSKeys(ctx, requests, options.kmsKey); err != nil {
+		return nil, err
+	}
+	if err := s.applyStorageClasses(requests, options.storageClass); err != nil {
+		return nil, err
+	}
+	s.applyCacheControl(requests)
+	if err := s.applyExpiry(requests, options); err != nil {
+		return nil, err
+	}
+
+	requests = s.sniffContentTypes(requests)
+	requests, rejected := s.filterContentTypes(requests)
+	requests, copied, invalid, hashed := s.deduplicate(ctx, requests)
+	rejected = append(rejected, invalid...)
+	if options.stripMetadata {
+		var failed []storage.WriteError
+		requests, failed = stripImageMetadata(requests)
+		rejected = append(rejected, failed...)
+	}
+	s.applyCompression(requests)
+	s.applyEnvelope(requests)
+
+	response := &storage.WriteResponse{
+		FilesWritten: make([]storage.FileMetadata, 0),
+	}
+	if len(requests) > 0 {
+		var err error
+		response, err = s.storage.WriteFiles(ctx, requests)
+		if err != nil {
+			return nil, err
+		}
+		s.verifyContentHashes(ctx, requests, hashed, response)
+	}
+	response.Errors = append(response.Errors, rejected...)
+	for _, file := range copied {
+		response.FilesWritten = append(response.FilesWritten, file)
+		response.Deduplicated = append(response.Deduplicated, file.Name)
+		requests = append(requests, storage.WriteRequest{Path: file.Name})
+	}
+	if len(response.FilesWritten) == 0 {
+		return response, nil
+	}
+
+	s.recordQuota(ctx, response.FilesWritten)
+	s.indexExpiry(ctx, response.FilesWritten)
+	s.publishWritten(ctx, requests, response.FilesWritten)
+	return response, nil
 }
 
 // ReadFiles reads multiple files from storage
 func (s *StorageService) ReadFiles(ctx context.Context, filePaths []string) (*storage.ReadResponse, error) {
-	return s.storage.ReadFiles(ctx, filePaths)
+	if err := s.checkBatchSize(len(filePaths)); err != nil {
+		return nil, err
+	}
+	if err := policy.Authorize(ctx, policy.ActionRead, filePaths...); err != nil {
+		return nil, err
+	}
+	if err := s.checkQuarantine(ctx, filePaths...); err != nil {
+		return nil, err
+	}
+	ranges := make([]storage.ReadRange, 0, len(filePaths))
+	for _, filePath := range filePaths {
+		ranges = append(ranges, storage.ReadRange{Path: filePath})
+	}
+	if err := s.checkReadPayload(ctx, ranges); err != nil {
+		return nil, err
+	}
+	response, err := s.storage.ReadFiles(ctx, filePaths)
+	if err != nil {
+		return nil, err
+	}
+	s.recordDownloads(response.Files...)
+	return response, nil
+}
+
+// ReadRanges reads part of each file, e.g. the headers of many videos. The
+// batch limits count the bytes of the ranges rather than of the whole files.
+func (s *StorageService) ReadRanges(ctx context.Context, ranges []storage.ReadRange) (*storage.ReadResponse, error) {
+	if err := s.checkRanges(ctx, ranges); err != nil {
+		return nil, err
+	}
+	response, err := s.storage.ReadRanges(ctx, ranges)
+	if err != nil {
+		return nil, err
+	}
+	s.recordDownloads(response.Files...)
+	return response, nil
+}
+
+// StreamRanges reads the ranges one at a time, calling fn with each file, or
+// the error reading it, as soon as it is read. Only one file is held in memory
+// at a time. A range of a whole file reads the file like ReadFile.
+func (s *StorageService) StreamRanges(ctx context.Context, ranges []storage.ReadRange, fn func(*storage.FileData, *storage.ReadError) error) error {
+	if err := s.checkRanges(ctx, ranges); err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		file, readErr := s.readRange(ctx, r)
+		if file != nil {
+			s.recordDownloads(*file)
+		}
+		if err := fn(file, readErr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *StorageService) readRange(ctx context.Context, r storage.ReadRange) (*storage.FileData, *storage.ReadError) {
+	if r.Offset == 0 && r.Length == 0 {
+		file, err := s.storage.ReadFile(ctx, r.Path)
+		if err != nil {
+			return nil, &storage.ReadError{FilePath: r.Path, Error: err.Error()}
+		}
+		return file, nil
+	}
+	response, err := s.storage.ReadRanges(ctx, []storage.ReadRange{r})
+	switch {
+	case err != nil:
+		return nil, &storage.ReadError{FilePath: r.Path, Error: err.Error()}
+	case len(response.Errors) > 0:
+		return nil, &response.Errors[0]
+	case len(response.Files) == 0:
+		return nil, &storage.ReadError{FilePath: r.Path, Error: "no content returned"}
+	}
+	return &response.Files[0], nil
+}
+
+// checkRanges applies the batch limits and refuses quarantined files
+func (s *StorageService) checkRanges(ctx context.Context, ranges []storage.ReadRange) error {
+	if err := s.checkBatchSize(len(ranges)); err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		if r.Length < 0 {
+			return fmt.Errorf("%w: length of %s must not be negative", ErrInvalidRange, r.Path)
+		}
+		paths = append(paths, r.Path)
+	}
+	if err := policy.Authorize(ctx, policy.ActionRead, paths...); err != nil {
+		return err
+	}
+	if err := s.checkQuarantine(ctx, paths...); err != nil {
+		return err
+	}
+	return s.checkReadPayload(ctx, ranges)
+}
+
+// StatFiles returns the metadata of each file without downloading its content
+func (s *StorageService) StatFiles(ctx context.Context, filePaths []string) ([]storage.FileMetadata, []storage.ReadError, error) {
+	if err := s.checkBatchSize(len(filePaths)); err != nil {
+		return nil, nil, err
+	}
+	if err := policy.Authorize(ctx, policy.ActionRead, filePaths...); err != nil {
+		return nil, nil, err
+	}
+	if err := s.checkQuarantine(ctx, filePaths...); err != nil {
+		return nil, nil, err
+	}
+
+	files := make([]storage.FileMetadata, 0, len(filePaths))
+	errs := make([]storage.ReadError, 0)
+	for _, filePath := range filePaths {
+		metadata, err := s.storage.StatFile(ctx, filePath)
+		if err != nil {
+			errs = append(errs, storage.ReadError{FilePath: filePath, Error: err.Error()})
+			continue
+		}
+		files = append(files, *metadata)
+	}
+	return files, errs, nil
+}
+
+// ReadRange reads part of a single file, as asked for by a Range request. Like
+// ReadFile, and unlike ReadRanges, it isn't bound by the batch limits.
+func (s *StorageService) ReadRange(ctx context.Context, r storage.ReadRange) (*storage.FileData, error) {
+	if r.Length < 0 {
+		return nil, fmt.Errorf("%w: length of %s must not be negative", ErrInvalidRange, r.Path)
+	}
+	if err := s.checkQuarantine(ctx, r.Path); err != nil {
+		return nil, err
+	}
+	file, readErr := s.readRange(ctx, r)
+	if readErr != nil {
+		return nil, errors.New(readErr.Error)
+	}
+	s.recordDownloads(*file)
+	return file, nil
 }
 
 // ReadFile reads a single file from storage
 func (s *StorageService) ReadFile(ctx context.Context, filePath string) (*storage.FileData, error) {
-	return s.storage.ReadFile(ctx, filePath)
-}
\ No newline at end of file
+	if err := policy.Authorize(ctx, policy.ActionRead, filePath); err != nil {
+		return nil, err
+	}
+	if err := s.checkQuarantine(ctx, filePath); err != nil {
+		return nil, err
+	}
+	file, err := s.storage.ReadFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	s.recordDownloads(*file)
+	return file, nil
+}
+
+// StatFile returns the metadata of a single file without downloading its content
+func (s *StorageService) StatFile(ctx context.Context, filePath string) (*storage.FileMetadata, error) {
+	if err := s.checkQuarantine(ctx, filePath); err != nil {
+		return nil, err
+	}
+	return s.storage.StatFile(ctx, filePath)
+}
+
+// ListFiles lists the files under prefix in lexical order
+func (s *StorageService) ListFiles(ctx context.Context, prefix string) ([]storage.FileMetadata, error) {
+	files, err := s.listFiles(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return s.hideQuarantined(ctx, files), nil
+}
+
+// MoveFile renames a file by copying it to dstPath and deleting the original
+func (s *StorageService) MoveFile(ctx context.Context, srcPath, dstPath string) error {
+	if srcPath == dstPath {
+		return nil
+	}
+	if err := s.checkQuarantine(ctx, srcPath); err != nil {
+		return err
+	}
+	if err := s.checkLeases(ctx, srcPath, dstPath); err != nil {
+		return err
+	}
+	if err := s.storage.CopyFile(ctx, srcPath, dstPath); err != nil {
+		return err
+	}
+	s.reindexTags(ctx, dstPath)
+	if err := s.storage.DeleteFile(ctx, srcPath); err != nil {
+		return err
+	}
+
+	if file, err := s.storage.StatFile(ctx, dstPath); err == nil {
+		s.publishWritten(ctx, []storage.WriteRequest{{Path: dstPath}}, []storage.FileMetadata{*file})
+	}
+	s.publishDeleted(ctx, srcPath)
+	return nil
+}
+
+// longestPrefix returns the rule with the longest prefix matching filePath
+func longestPrefix[R any](rules []R, filePath string, prefix func(R) string) (R, bool) {
+	var match R
+	found := false
+	for _, rule := range rules {
+		p := prefix(rule)
+		if strings.HasPrefix(filePath, p) && (!found || len(p) > len(prefix(match))) {
+			match, found = rule, true
+		}
+	}
+	return match, found
+}
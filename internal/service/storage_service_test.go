@@ -2,35 +2,195 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/cache"
+	"gcp-proxy-mity/internal/catalog"
+	"gcp-proxy-mity/internal/changes"
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/fetch"
+	"gcp-proxy-mity/internal/jobs"
+	"gcp-proxy-mity/internal/lease"
+	"gcp-proxy-mity/internal/mount"
+	"gcp-proxy-mity/internal/quota"
+	"gcp-proxy-mity/internal/search"
+	"gcp-proxy-mity/internal/share"
+	"gcp-proxy-mity/internal/stats"
 	"gcp-proxy-mity/internal/storage"
 )
 
 // mockStorage is a mock implementation of storage.Storage
 type mockStorage struct {
+	writeRequests      []storage.WriteRequest
 	writeFilesResponse *storage.WriteResponse
 	writeFilesError    error
 	readFilesResponse  *storage.ReadResponse
 	readFilesError     error
 	readFileData       *storage.FileData
 	readFileError      error
+	listFiles          []storage.FileMetadata
+	listFilesError     error
+	copyError          error
+	deleteError        error
+	copied             map[string]string
+	deleted            []string
+	metadata           map[string]map[string]string
+	// objects makes ReadFile return the content stored at each path
+	objects map[string]string
 }
 
 func (m *mockStorage) WriteFiles(ctx context.Context, requests []storage.WriteRequest) (*storage.WriteResponse, error) {
+	m.writeRequests = append(m.writeRequests, requests...)
+	for _, req := range requests {
+		// Consume the content like an upload would
+		if req.Content != nil {
+			io.Copy(io.Discard, req.Content)
+		}
+	}
 	return m.writeFilesResponse, m.writeFilesError
 }
 
+func (m *mockStorage) CreateFile(ctx context.Context, req storage.WriteRequest) (*storage.FileMetadata, error) {
+	m.writeRequests = append(m.writeRequests, req)
+	if req.Content != nil {
+		io.Copy(io.Discard, req.Content)
+	}
+	return &storage.FileMetadata{Name: req.Path}, m.writeFilesError
+}
+
 func (m *mockStorage) ReadFiles(ctx context.Context, filePaths []string) (*storage.ReadResponse, error) {
 	return m.readFilesResponse, m.readFilesError
 }
 
 func (m *mockStorage) ReadFile(ctx context.Context, filePath string) (*storage.FileData, error) {
+	if m.objects != nil {
+		content, ok := m.objects[filePath]
+		if !ok {
+			return nil, storage.ErrNotFound
+		}
+		return &storage.FileData{Metadata: storage.FileMetadata{Name: filePath, Size: int64(len(content))}, Content: []byte(content)}, nil
+	}
 	return m.readFileData, m.readFileError
 }
 
+func (m *mockStorage) StatFile(ctx context.Context, filePath string) (*storage.FileMetadata, error) {
+	for _, file := range m.listFiles {
+		if file.Name == filePath {
+			return &file, nil
+		}
+	}
+	for _, dst := range m.copied {
+		if dst == filePath {
+			return &storage.FileMetadata{Name: filePath}, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (m *mockStorage) ListFiles(ctx context.Context, prefix string) ([]storage.FileMetadata, error) {
+	return m.listFiles, m.listFilesError
+}
+
+func (m *mockStorage) ListNames(ctx context.Context, prefix string) ([]string, error) {
+	names := make([]string, 0, len(m.listFiles))
+	for _, file := range m.listFiles {
+		names = append(names, file.Name)
+	}
+	return names, m.listFilesError
+}
+
+func (m *mockStorage) WalkFiles(ctx context.Context, prefix string, fn func(storage.FileMetadata) error) error {
+	for _, file := range m.listFiles {
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+	return m.listFilesError
+}
+
+func (m *mockStorage) CopyFile(ctx context.Context, srcPath, dstPath string) error {
+	if m.copyError != nil {
+		return m.copyError
+	}
+	if m.copied == nil {
+		m.copied = make(map[string]string)
+	}
+	m.copied[srcPath] = dstPath
+	return nil
+}
+
+func (m *mockStorage) SetStorageClass(ctx context.Context, filePath, storageClass string) (*storage.FileMetadata, error) {
+	return &storage.FileMetadata{Name: filePath, StorageClass: storageClass}, nil
+}
+
+func (m *mockStorage) GetRetention(ctx context.Context, filePath string) (*storage.Retention, error) {
+	return &storage.Retention{}, nil
+}
+
+func (m *mockStorage) SetHolds(ctx context.Context, filePath string, update storage.HoldUpdate) (*storage.Retention, error) {
+	return &storage.Retention{}, nil
+}
+
+func (m *mockStorage) GetAccess(ctx context.Context, filePath string) (*storage.Access, error) {
+	return &storage.Access{}, nil
+}
+
+func (m *mockStorage) SetAccess(ctx context.Context, filePath string, public bool) (*storage.Access, error) {
+	return &storage.Access{Public: public}, nil
+}
+
+func (m *mockStorage) ReadRanges(ctx context.Context, ranges []storage.ReadRange) (*storage.ReadResponse, error) {
+	return m.readFilesResponse, m.readFilesError
+}
+
+func (m *mockStorage) GetMetadata(ctx context.Context, filePath string) (map[string]string, error) {
+	metadata, ok := m.metadata[filePath]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return metadata, nil
+}
+
+func (m *mockStorage) UpdateMetadata(ctx context.Context, filePath string, metadata map[string]string) error {
+	return nil
+}
+
+func (m *mockStorage) ComposeFiles(ctx context.Context, dst storage.WriteRequest, srcPaths []string) (*storage.FileMetadata, error) {
+	return &storage.FileMetadata{Name: dst.Path, ContentType: dst.ContentType}, nil
+}
+
+func (m *mockStorage) SignedUploadURL(ctx context.Context, req storage.WriteRequest, expires time.Time) (string, error) {
+	return "https://storage.googleapis.com/bucket/" + req.Path, nil
+}
+
+func (m *mockStorage) SignedDownloadURL(ctx context.Context, filePath, disposition string, expires time.Time) (string, error) {
+	return "https://storage.googleapis.com/bucket/" + filePath + "?response-content-disposition=" + url.QueryEscape(disposition), nil
+}
+
+func (m *mockStorage) DeleteFile(ctx context.Context, filePath string) error {
+	if m.deleteError != nil {
+		return m.deleteError
+	}
+	m.deleted = append(m.deleted, filePath)
+	return nil
+}
+
 func TestStorageService_WriteFiles(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -98,6 +258,89 @@ func TestStorageService_WriteFiles(t *testing.T) {
 	}
 }
 
+func TestStorageService_WriteFiles_StripMetadata(t *testing.T) {
+	mock := &mockStorage{
+		writeFilesResponse: &storage.WriteResponse{
+			FilesWritten: []storage.FileMetadata{{Name: "notes.txt", ContentType: "text/plain", Size: 5}},
+			Errors:       []storage.WriteError{},
+		},
+	}
+	service := NewStorageService(mock)
+
+	requests := []storage.WriteRequest{
+		{Path: "photo.jpg", Content: strings.NewReader("not a jpeg"), ContentType: "image/jpeg"},
+		{Path: "notes.txt", Content: strings.NewReader("notes"), ContentType: "text/plain"},
+	}
+
+	response, err := service.WriteFiles(context.Background(), requests, StripMetadata())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(mock.writeRequests) != 1 || mock.writeRequests[0].Path != "notes.txt" {
+		t.Fatalf("Expected only notes.txt to reach storage, got %+v", mock.writeRequests)
+	}
+
+	if mock.writeRequests[0].Metadata[MetadataScrubbedKey] != "" {
+		t.Error("Expected non-image file not to be marked as scrubbed")
+	}
+
+	if len(response.Errors) != 1 || response.Errors[0].FilePath != "photo.jpg" {
+		t.Errorf("Expected write error for photo.jpg, got %+v", response.Errors)
+	}
+}
+
+func TestStorageService_WriteFiles_SniffContentTypes(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	tests := []struct {
+		name        string
+		trust       bool
+		path        string
+		content     string
+		contentType string
+		expected    string
+	}{
+		{name: "octet-stream image", trust: true, path: "upload.bin", content: png, contentType: "application/octet-stream", expected: "image/png"},
+		{name: "missing type", trust: true, path: "page", content: "<!DOCTYPE html><html></html>", expected: "text/html; charset=utf-8"},
+		{name: "trusted type", trust: true, path: "photo.jpg", content: png, contentType: "image/jpeg", expected: "image/jpeg"},
+		{name: "untrusted type", path: "photo.jpg", content: png, contentType: "image/jpeg", expected: "image/png"},
+		{name: "text keeps extension", path: "site.css", content: "body { color: red }", contentType: "text/html", expected: "text/css; charset=utf-8"},
+		{name: "unknown signature keeps declared", path: "movie", content: "\x00\x01\x02", contentType: "video/x-custom", expected: "video/x-custom"},
+		{name: "unknown signature uses extension", trust: true, path: "doc.pdf", content: "", expected: "application/pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockStorage{writeFilesResponse: &storage.WriteResponse{}}
+			service := NewStorageService(mock)
+			service.SetTrustClientContentTypes(tt.trust)
+
+			requests := []storage.WriteRequest{{Path: tt.path, Content: strings.NewReader(tt.content), ContentType: tt.contentType}}
+			if _, err := service.WriteFiles(context.Background(), requests); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(mock.writeRequests) != 1 {
+				t.Fatalf("Expected 1 write, got %d", len(mock.writeRequests))
+			}
+			if got := mock.writeRequests[0].ContentType; got != tt.expected {
+				t.Errorf("Expected content type %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	mock := &mockStorage{writeFilesResponse: &storage.WriteResponse{}}
+	service := NewStorageService(mock)
+	service.SetAllowedContentTypes([]string{"image/*"})
+	requests := []storage.WriteRequest{{Path: "photo.png", Content: strings.NewReader("<html><script></script></html>"), ContentType: "application/octet-stream"}}
+	response, err := service.WriteFiles(context.Background(), requests)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(mock.writeRequests) != 0 || len(response.Errors) != 1 {
+		t.Errorf("Expected the sniffed HTML to be rejected, got writes %+v and errors %+v", mock.writeRequests, response.Errors)
+	}
+}
+
 func TestStorageService_ReadFiles(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -166,12 +409,133 @@ func TestStorageService_ReadFiles(t *testing.T) {
 	}
 }
 
+func TestStorageService_ReadFiles_BatchLimits(t *testing.T) {
+	mock := &mockStorage{
+		readFilesResponse: &storage.ReadResponse{},
+		listFiles: []storage.FileMetadata{
+			{Name: "a.mp4", Size: 600},
+			{Name: "b.mp4", Size: 600},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		limits    BatchLimits
+		filePaths []string
+		expected  error
+	}{
+		{
+			name:      "within limits",
+			limits:    BatchLimits{MaxFiles: 3, MaxBytes: 1200},
+			filePaths: []string{"a.mp4", "b.mp4", "missing.mp4"},
+		},
+		{
+			name:      "too many files",
+			limits:    BatchLimits{MaxFiles: 1},
+			filePaths: []string{"a.mp4", "b.mp4"},
+			expected:  ErrBatchTooManyFiles,
+		},
+		{
+			name:      "payload too large",
+			limits:    BatchLimits{MaxBytes: 1000},
+			filePaths: []string{"a.mp4", "b.mp4"},
+			expected:  ErrBatchPayloadTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewStorageService(mock, WithBatchLimits(tt.limits))
+			_, err := service.ReadFiles(context.Background(), tt.filePaths)
+
+			if tt.expected == nil && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestStorageService_ReadRanges(t *testing.T) {
+	mock := &mockStorage{
+		readFilesResponse: &storage.ReadResponse{},
+		listFiles: []storage.FileMetadata{
+			{Name: "a.mp4", Size: 600},
+			{Name: "b.mp4", Size: 600},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		ranges   []storage.ReadRange
+		expected error
+	}{
+		{
+			name:   "headers within payload limit",
+			ranges: []storage.ReadRange{{Path: "a.mp4", Length: 500}, {Path: "b.mp4", Offset: -500}},
+		},
+		{
+			name:     "whole files exceed payload limit",
+			ranges:   []storage.ReadRange{{Path: "a.mp4"}, {Path: "b.mp4"}},
+			expected: ErrBatchPayloadTooLarge,
+		},
+		{
+			name:     "negative length",
+			ranges:   []storage.ReadRange{{Path: "a.mp4", Length: -1}},
+			expected: ErrInvalidRange,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewStorageService(mock, WithBatchLimits(BatchLimits{MaxBytes: 1000}))
+			_, err := service.ReadRanges(context.Background(), tt.ranges)
+
+			if tt.expected == nil && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestStorageService_ReadRange(t *testing.T) {
+	mock := &mockStorage{
+		readFilesResponse: &storage.ReadResponse{
+			Files: []storage.FileData{{Metadata: storage.FileMetadata{Name: "a.mp4", Size: 600}, Content: []byte("tail"), Offset: 596}},
+		},
+		listFiles: []storage.FileMetadata{{Name: "a.mp4", Size: 600}},
+	}
+	service := NewStorageService(mock, WithBatchLimits(BatchLimits{MaxBytes: 1}))
+
+	file, err := service.ReadRange(context.Background(), storage.ReadRange{Path: "a.mp4", Offset: 596})
+	if err != nil {
+		t.Fatalf("Expected the batch limits not to apply, got %v", err)
+	}
+	if string(file.Content) != "tail" || file.Offset != 596 {
+		t.Errorf("Expected tail at 596, got %q at %d", file.Content, file.Offset)
+	}
+
+	if _, err := service.ReadRange(context.Background(), storage.ReadRange{Path: "a.mp4", Length: -1}); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("Expected %v, got %v", ErrInvalidRange, err)
+	}
+
+	mock.readFilesResponse = &storage.ReadResponse{Errors: []storage.ReadError{{FilePath: "a.mp4", Error: "not found"}}}
+	if _, err := service.ReadRange(context.Background(), storage.ReadRange{Path: "a.mp4", Length: 10}); err == nil {
+		t.Error("Expected the read error to be returned")
+	}
+}
+
 func TestStorageService_ReadFile(t *testing.T) {
 	tests := []struct {
-		name        string
-		mockStorage *mockStorage
-		filePath    string
-		expectError bool
+		name         string
+		mockStorage  *mockStorage
+		filePath     string
+		expectError  bool
 		expectedName string
 	}{
 		{
@@ -217,4 +581,1775 @@ func TestStorageService_ReadFile(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+type mockFrameExtractor struct {
+	frame []byte
+	err   error
+}
+
+func (m *mockFrameExtractor) ExtractFrame(ctx context.Context, video io.Reader, at time.Duration) ([]byte, error) {
+	return m.frame, m.err
+}
+
+func TestStorageService_ReadPoster(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []Option
+		mockStorage   *mockStorage
+		filePath      string
+		expectedError error
+	}{
+		{
+			name:          "extraction disabled",
+			mockStorage:   &mockStorage{},
+			filePath:      "video.mp4",
+			expectedError: ErrFrameExtractionDisabled,
+		},
+		{
+			name:          "not a video",
+			opts:          []Option{WithFrameExtractor(&mockFrameExtractor{frame: []byte("jpeg")})},
+			mockStorage:   &mockStorage{},
+			filePath:      "photo.jpg",
+			expectedError: ErrNotVideo,
+		},
+		{
+			name: "video not found",
+			opts: []Option{WithFrameExtractor(&mockFrameExtractor{frame: []byte("jpeg")})},
+			mockStorage: &mockStorage{
+				readFileError: errors.New("file not found"),
+			},
+			filePath:      "video.mp4",
+			expectedError: errors.New("file not found"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewStorageService(tt.mockStorage, tt.opts...)
+			_, err := service.ReadPoster(context.Background(), tt.filePath, 2*time.Second)
+
+			if tt.expectedError == nil {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tt.expectedError.Error() {
+				t.Errorf("Expected error '%v', got '%v'", tt.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestPosterPath(t *testing.T) {
+	if got := PosterPath("videos/clip.mp4", 2500*time.Millisecond); got != "videos/clip.mp4.poster-2500ms.jpg" {
+		t.Errorf("Unexpected poster path '%s'", got)
+	}
+}
+
+func TestStorageService_WriteFiles_KMSKeys(t *testing.T) {
+	const (
+		defaultKey = "projects/p/locations/eu/keyRings/r/cryptoKeys/default"
+		medicalKey = "projects/p/locations/eu/keyRings/r/cryptoKeys/medical"
+		headerKey  = "projects/p/locations/eu/keyRings/r/cryptoKeys/header"
+	)
+	rules := []KMSKeyRule{
+		{Prefix: "", KeyName: defaultKey},
+		{Prefix: "medical/", KeyName: medicalKey},
+	}
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		path        string
+		opts        []WriteOption
+		expectedKey string
+		expectError bool
+	}{
+		{name: "longest prefix wins", ctx: context.Background(), path: "medical/scan.jpg", expectedKey: medicalKey},
+		{name: "fallback rule", ctx: context.Background(), path: "public/cat.jpg", expectedKey: defaultKey},
+		{name: "header overrides rules", ctx: context.Background(), path: "medical/scan.jpg", opts: []WriteOption{KMSKey(headerKey)}, expectedKey: headerKey},
+		{name: "rules skipped for CSEK", ctx: storage.WithEncryptionKey(context.Background(), make([]byte, 32)), path: "medical/scan.jpg"},
+		{name: "header with CSEK", ctx: storage.WithEncryptionKey(context.Background(), make([]byte, 32)), path: "a.jpg", opts: []WriteOption{KMSKey(headerKey)}, expectError: true},
+		{name: "malformed key name", ctx: context.Background(), path: "a.jpg", opts: []WriteOption{KMSKey("my-key")}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockStorage{writeFilesResponse: &storage.WriteResponse{}}
+			service := NewStorageService(mock, WithKMSKeys(rules))
+
+			_, err := service.WriteFiles(tt.ctx, []storage.WriteRequest{{Path: tt.path, Content: strings.NewReader("x")}}, tt.opts...)
+
+			if tt.expectError {
+				if !errors.Is(err, ErrInvalidKMSKey) {
+					t.Errorf("Expected ErrInvalidKMSKey, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := mock.writeRequests[0].KMSKeyName; got != tt.expectedKey {
+				t.Errorf("Expected KMS key %q, got %q", tt.expectedKey, got)
+			}
+		})
+	}
+}
+
+func TestStorageService_StorageClasses(t *testing.T) {
+	mock := &mockStorage{writeFilesResponse: &storage.WriteResponse{}}
+	service := NewStorageService(mock, WithStorageClasses([]StorageClassRule{
+		{Prefix: "recordings/", StorageClass: "NEARLINE"},
+		{Prefix: "recordings/archive/", StorageClass: "ARCHIVE"},
+	}))
+
+	requests := []storage.WriteRequest{
+		{Path: "recordings/archive/2019.wav", Content: strings.NewReader("a")},
+		{Path: "recordings/today.wav", Content: strings.NewReader("b")},
+		{Path: "images/cat.jpg", Content: strings.NewReader("c")},
+	}
+	if _, err := service.WriteFiles(context.Background(), requests); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, expected := range []string{"ARCHIVE", "NEARLINE", ""} {
+		if got := mock.writeRequests[i].StorageClass; got != expected {
+			t.Errorf("Expected %s to use %q, got %q", requests[i].Path, expected, got)
+		}
+	}
+
+	if _, err := service.WriteFiles(context.Background(), requests[:1], StorageClass("coldline")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := mock.writeRequests[3].StorageClass; got != "COLDLINE" {
+		t.Errorf("Expected header class COLDLINE, got %q", got)
+	}
+
+	if _, err := service.SetStorageClass(context.Background(), "a.wav", "GLACIER"); !errors.Is(err, ErrInvalidStorageClass) {
+		t.Errorf("Expected ErrInvalidStorageClass, got %v", err)
+	}
+}
+
+func TestStorageService_CacheControl(t *testing.T) {
+	mock := &mockStorage{writeFilesResponse: &storage.WriteResponse{}}
+	service := NewStorageService(mock, WithCacheControl([]CacheControlRule{
+		{Pattern: "private/**", NoStore: true},
+		{Pattern: "assets/**/*.js", MaxAge: 365 * 24 * time.Hour, Immutable: true},
+		{Pattern: "*.html", MaxAge: time.Minute},
+		{Pattern: "images/?/*", MaxAge: time.Hour},
+	}))
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "private/reports/q3.pdf", expected: "no-store"},
+		{path: "assets/app.js", expected: "public, max-age=31536000, immutable"},
+		{path: "assets/vendor/lib/react.js", expected: "public, max-age=31536000, immutable"},
+		{path: "assets/app.css", expected: ""},
+		{path: "index.html", expected: "public, max-age=60"},
+		{path: "docs/index.html", expected: ""},
+		{path: "images/a/cat.jpg", expected: "public, max-age=3600"},
+		{path: "images/ab/cat.jpg", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := service.CacheControl(tt.path); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	requests := []storage.WriteRequest{
+		{Path: "private/a.pdf", Content: strings.NewReader("a")},
+		{Path: "notes.txt", Content: strings.NewReader("b")},
+	}
+	if _, err := service.WriteFiles(context.Background(), requests); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, expected := range []string{"no-store", ""} {
+		if got := mock.writeRequests[i].CacheControl; got != expected {
+			t.Errorf("Expected %s to be stored with %q, got %q", requests[i].Path, expected, got)
+		}
+	}
+}
+
+func TestStorageService_DownloadURL(t *testing.T) {
+	mock := &mockStorage{listFiles: []storage.FileMetadata{
+		{Name: "videos/a.mp4", Size: 1 << 30},
+		{Name: "videos/b.mp4", Size: 10},
+		{Name: "videos/c.mp4", Size: 1 << 30, Envelope: true},
+		{Name: "videos/d.json", Size: 1 << 30, ContentEncoding: storage.EncodingGzip},
+		{Name: "_quarantine/videos/e.mp4", Size: 1 << 30},
+		{Name: "notes.txt", Size: 1 << 30},
+	}}
+	service := NewStorageService(mock, WithQuarantine("_quarantine"), WithRedirects([]RedirectRule{
+		{Pattern: "**/*.mp4", MinBytes: 1 << 20},
+		{Pattern: "videos/**"},
+	}, time.Minute))
+	disposition := func(metadata storage.FileMetadata) string {
+		return "attachment; filename=" + path.Base(metadata.Name)
+	}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		path    string
+		wantURL string
+		wantErr error
+	}{
+		{name: "redirected", path: "videos/a.mp4", wantURL: "https://storage.googleapis.com/bucket/videos/a.mp4?response-content-disposition=attachment%3B+filename%3Da.mp4"},
+		{name: "smaller than the rule's minimum", path: "videos/b.mp4"},
+		{name: "encrypted by the proxy", path: "videos/c.mp4"},
+		{name: "stored compressed", path: "videos/d.json"},
+		{name: "customer-supplied key", ctx: storage.WithEncryptionKey(context.Background(), make([]byte, 32)), path: "videos/a.mp4"},
+		{name: "quarantined", path: "_quarantine/videos/e.mp4", wantErr: ErrQuarantined},
+		{name: "no rule", path: "notes.txt"},
+		{name: "missing", path: "videos/f.mp4", wantErr: storage.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			got, err := service.DownloadURL(ctx, tt.path, disposition)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.wantURL {
+				t.Errorf("Expected URL %q, got %q", tt.wantURL, got)
+			}
+		})
+	}
+}
+
+func TestStorageService_WarmCache(t *testing.T) {
+	mock := &mockStorage{
+		listFiles: []storage.FileMetadata{
+			{Name: "launch/a.jpg", Size: 3},
+			{Name: "launch/b.mp4", Size: 100},
+			{Name: "launch/c.txt", Size: 3, Envelope: true},
+		},
+		objects: map[string]string{"launch/a.jpg": "abc", "launch/b.mp4": strings.Repeat("b", 100), "launch/c.txt": "abc"},
+	}
+	signer, err := auth.NewCDNSigner("proxy-key", "c2VjcmV0LWtleS0xMjM0NQ==", []string{"/api/v1/storage/files/"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var heads atomic.Int32
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || !strings.HasPrefix(r.URL.Path, "/api/v1/storage/files/launch/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// Like the proxy behind the CDN when API keys are required
+		if !signer.Verify(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		heads.Add(1)
+	}))
+	defer cdn.Close()
+	lru := cache.NewLRU(1<<20, time.Minute)
+	service := NewStorageService(storage.NewCachedStorage(mock, lru, 10), WithReadCache(ReadCacheConfig{
+		Cache:          lru,
+		Backend:        CacheBackendMemory,
+		MaxBytes:       1 << 20,
+		MaxObjectBytes: 10,
+		Size:           func() (int, int64) { return lru.Len(), lru.Size() },
+		CDNBaseURL:     cdn.URL,
+		CDNSigner:      signer,
+	}))
+	ctx := context.Background()
+
+	got, err := service.WarmCache(ctx, []string{"launch/a.jpg", "launch/d.jpg"}, "launch/", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := &CacheWarmResult{
+		Warmed:  []string{"launch/a.jpg"},
+		Cached:  []string{},
+		Skipped: []string{"launch/b.mp4", "launch/c.txt"},
+		CDN:     []string{"launch/a.jpg", "launch/b.mp4", "launch/c.txt"},
+		Errors:  []CacheError{{Path: "launch/d.jpg", Error: storage.ErrNotFound.Error()}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if heads.Load() != 3 {
+		t.Errorf("Expected 3 HEAD requests through the CDN, got %d", heads.Load())
+	}
+
+	got, err = service.WarmCache(ctx, []string{"launch/a.jpg"}, "", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(got.Cached, []string{"launch/a.jpg"}) || len(got.Warmed) != 0 {
+		t.Errorf("Expected launch/a.jpg to be cached already, got %+v", got)
+	}
+
+	stats, err := service.ReadCacheStats()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.Backend != CacheBackendMemory || stats.Entries == nil || *stats.Entries != 1 {
+		t.Errorf("Expected 1 entry in the memory cache, got %+v", stats)
+	}
+
+	purged, err := service.PurgeCache(ctx, nil, "launch/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if purged != 3 || lru.Len() != 0 {
+		t.Errorf("Expected 3 objects purged and an empty cache, got %d purged and %d entries", purged, lru.Len())
+	}
+
+	if _, err := NewStorageService(mock).WarmCache(ctx, []string{"launch/a.jpg"}, "", false); !errors.Is(err, ErrCacheDisabled) {
+		t.Errorf("Expected ErrCacheDisabled without a read cache, got %v", err)
+	}
+}
+
+func TestStorageService_Compression(t *testing.T) {
+	mock := &mockStorage{writeFilesResponse: &storage.WriteResponse{}}
+	service := NewStorageService(mock, WithCompression([]CompressionRule{
+		{ContentType: "text/event-stream"},
+		{ContentType: "text/*", Encoding: storage.EncodingGzip},
+		{ContentType: "application/json", Encoding: storage.EncodingZstd},
+	}))
+
+	tests := []struct {
+		path        string
+		contentType string
+		expected    string
+	}{
+		{path: "notes.txt", contentType: "text/plain; charset=utf-8", expected: storage.EncodingGzip},
+		{path: "index.html", expected: storage.EncodingGzip},
+		{path: "events", contentType: "text/event-stream", expected: ""},
+		{path: "data", contentType: "application/json", expected: storage.EncodingZstd},
+		{path: "photo.jpg", contentType: "image/jpeg", expected: ""},
+	}
+
+	requests := make([]storage.WriteRequest, 0, len(tests))
+	for _, tt := range tests {
+		requests = append(requests, storage.WriteRequest{Path: tt.path, ContentType: tt.contentType, Content: strings.NewReader("content")})
+	}
+	if _, err := service.WriteFiles(context.Background(), requests); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, tt := range tests {
+		if got := mock.writeRequests[i].ContentEncoding; got != tt.expected {
+			t.Errorf("Expected %s to be stored with %q, got %q", tt.path, tt.expected, got)
+		}
+	}
+}
+
+func TestStorageService_Envelope(t *testing.T) {
+	mock := &mockStorage{writeFilesResponse: &storage.WriteResponse{}}
+	service := NewStorageService(mock, WithEnvelope([]string{"medical/", "hr/"}),
+		WithUploads(UploadConfig{URLTTL: time.Minute, RegistrationPrefix: "_uploads"}))
+
+	requests := []storage.WriteRequest{
+		{Path: "medical/scan.jpg", Content: strings.NewReader("a")},
+		{Path: "public/logo.png", Content: strings.NewReader("b")},
+	}
+	if _, err := service.WriteFiles(context.Background(), requests); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, expected := range []bool{true, false} {
+		if got := mock.writeRequests[i].Envelope; got != expected {
+			t.Errorf("Expected envelope encryption of %s to be %v, got %v", requests[i].Path, expected, got)
+		}
+	}
+
+	if _, err := service.CreateUpload(context.Background(), UploadRequest{Path: "hr/contract.pdf"}); !errors.Is(err, ErrInvalidUpload) {
+		t.Errorf("Expected direct uploads of encrypted paths to fail with ErrInvalidUpload, got %v", err)
+	}
+}
+
+func TestStorageService_CreateUpload(t *testing.T) {
+	uploads := UploadConfig{URLTTL: time.Minute, RegistrationPrefix: "_uploads", ThumbnailWidth: 320, CallbackHosts: []string{"app.example.com"}}
+
+	tests := []struct {
+		name        string
+		request     UploadRequest
+		expectedErr error
+	}{
+		{name: "valid", request: UploadRequest{Path: "photos/cat.jpg", PostProcess: []string{PostProcessThumbnail}, CallbackURL: "https://app.example.com/done"}},
+		{name: "missing path", request: UploadRequest{}, expectedErr: ErrInvalidUpload},
+		{name: "reserved path", request: UploadRequest{Path: "_uploads/x.jpg"}, expectedErr: ErrInvalidUpload},
+		{name: "thumbnail of non-image", request: UploadRequest{Path: "docs/a.pdf", PostProcess: []string{PostProcessThumbnail}}, expectedErr: ErrInvalidUpload},
+		{name: "unknown step", request: UploadRequest{Path: "photos/cat.jpg", PostProcess: []string{"transcode"}}, expectedErr: ErrInvalidUpload},
+		{name: "callback host not allowed", request: UploadRequest{Path: "photos/cat.jpg", CallbackURL: "http://169.254.169.254/"}, expectedErr: ErrInvalidUpload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockStorage{writeFilesResponse: &storage.WriteResponse{}}
+			service := NewStorageService(mock, WithUploads(uploads))
+
+			upload, err := service.CreateUpload(context.Background(), tt.request)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if upload.Headers["Content-Type"] != "image/jpeg" {
+				t.Errorf("Expected detected content type header, got %q", upload.Headers["Content-Type"])
+			}
+			if len(mock.writeRequests) != 1 || mock.writeRequests[0].Path != "_uploads/photos/cat.jpg.json" {
+				t.Errorf("Expected upload registration to be stored, got %+v", mock.writeRequests)
+			}
+		})
+	}
+
+	if _, err := NewStorageService(&mockStorage{}).CreateUpload(context.Background(), UploadRequest{Path: "a.jpg"}); !errors.Is(err, ErrUploadsDisabled) {
+		t.Errorf("Expected ErrUploadsDisabled, got %v", err)
+	}
+}
+
+// recordingPublisher collects published events
+type recordingPublisher struct {
+	events []events.Event
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event events.Event) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+func TestStorageService_CompleteUpload(t *testing.T) {
+	uploads := UploadConfig{URLTTL: time.Minute, RegistrationPrefix: "_uploads", ThumbnailWidth: 320}
+	notification := events.ObjectNotification{Path: "photos/cat.jpg", ContentType: "image/jpeg", Size: 3}
+
+	t.Run("unregistered object", func(t *testing.T) {
+		mock := &mockStorage{readFileError: fmt.Errorf("failed to get object attributes: %w", storage.ErrNotFound)}
+		service := NewStorageService(mock, WithUploads(uploads))
+		if err := service.CompleteUpload(context.Background(), notification); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(mock.deleted) != 0 {
+			t.Errorf("Expected nothing to be deleted, got %v", mock.deleted)
+		}
+	})
+
+	t.Run("registered object", func(t *testing.T) {
+		mock := &mockStorage{readFileData: &storage.FileData{Content: []byte(`{"path":"photos/cat.jpg"}`)}}
+		publisher := &recordingPublisher{}
+		service := NewStorageService(mock, WithUploads(uploads), WithPublisher(publisher))
+		if err := service.CompleteUpload(context.Background(), notification); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(publisher.events) != 1 || publisher.events[0].Path != "photos/cat.jpg" {
+			t.Errorf("Expected one written event, got %+v", publisher.events)
+		}
+		if len(mock.deleted) != 1 || mock.deleted[0] != "_uploads/photos/cat.jpg.json" {
+			t.Errorf("Expected registration to be removed, got %v", mock.deleted)
+		}
+	})
+}
+
+func TestStorageService_WriteFiles_Deduplication(t *testing.T) {
+	sum := func(content string) string {
+		h := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(h[:])
+	}
+	indexed := sum("known video")
+
+	tests := []struct {
+		name         string
+		content      string
+		announced    string
+		deduplicated bool
+		indexedAs    string
+		errContains  string
+	}{
+		{name: "known content is copied", content: "known video", announced: indexed, deduplicated: true},
+		{name: "new content is uploaded and indexed", content: "new video", announced: sum("new video"), indexedAs: "_content/" + sum("new video")[:2] + "/" + sum("new video")},
+		{name: "mismatching hash is rejected", content: "new video", announced: sum("other video"), errContains: ErrContentHashMismatch.Error()},
+		{name: "malformed hash is rejected", content: "new video", announced: "abc", errContains: ErrInvalidContentHash.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockStorage{
+				writeFilesResponse: &storage.WriteResponse{
+					FilesWritten: []storage.FileMetadata{{Name: "videos/a.mp4", Size: int64(len(tt.content))}},
+				},
+				listFiles: []storage.FileMetadata{
+					{Name: "_content/" + indexed[:2] + "/" + indexed},
+					{Name: "videos/a.mp4"},
+				},
+			}
+			service := NewStorageService(mock, WithDeduplication("_content/"))
+
+			response, err := service.WriteFiles(context.Background(), []storage.WriteRequest{
+				{Path: "videos/a.mp4", Content: strings.NewReader(tt.content), SHA256: tt.announced},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if tt.errContains != "" {
+				if len(response.Errors) != 1 || !strings.Contains(response.Errors[0].Error, tt.errContains) {
+					t.Errorf("Expected error containing %q, got %+v", tt.errContains, response.Errors)
+				}
+				if len(response.FilesWritten) != 0 {
+					t.Errorf("Expected no files written, got %+v", response.FilesWritten)
+				}
+				return
+			}
+
+			if got := len(response.Deduplicated) == 1; got != tt.deduplicated {
+				t.Errorf("Expected deduplicated %v, got %v", tt.deduplicated, response.Deduplicated)
+			}
+			if tt.deduplicated && len(mock.writeRequests) != 0 {
+				t.Error("Expected known content not to be uploaded")
+			}
+			if tt.indexedAs != "" && mock.copied["videos/a.mp4"] != tt.indexedAs {
+				t.Errorf("Expected upload to be indexed as %s, got %v", tt.indexedAs, mock.copied)
+			}
+		})
+	}
+}
+
+func TestStorageService_ComposeFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		chunks      []string
+		expectedErr error
+	}{
+		{name: "chunks", chunks: []string{"tmp/a.1", "tmp/a.2", "tmp/a.1"}},
+		{name: "no chunks", chunks: nil, expectedErr: ErrInvalidCompose},
+		{name: "destination as chunk", chunks: []string{"videos/a.mp4"}, expectedErr: ErrInvalidCompose},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockStorage{}
+			service := NewStorageService(mock)
+
+			file, err := service.ComposeFile(context.Background(), "videos/a.mp4", tt.chunks, "")
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if file.ContentType != "video/mp4" {
+				t.Errorf("Expected detected content type, got %q", file.ContentType)
+			}
+			if len(mock.deleted) != 2 {
+				t.Errorf("Expected each chunk to be deleted once, got %v", mock.deleted)
+			}
+		})
+	}
+}
+
+func TestStorageService_List(t *testing.T) {
+	mock := &mockStorage{
+		listFiles: []storage.FileMetadata{
+			{Name: "media/a.jpg"},
+			{Name: "media/clips/1.mp4"},
+			{Name: "media/clips/2.mp4"},
+			{Name: "media/z.png"},
+		},
+	}
+	service := NewStorageService(mock)
+
+	tests := []struct {
+		name         string
+		delimiter    string
+		namesOnly    bool
+		walk         bool
+		wantFiles    []string
+		wantPrefixes []string
+	}{
+		{
+			name:      "recursive",
+			wantFiles: []string{"media/a.jpg", "media/clips/1.mp4", "media/clips/2.mp4", "media/z.png"},
+		},
+		{
+			name:         "grouped by delimiter",
+			delimiter:    "/",
+			wantFiles:    []string{"media/a.jpg", "media/z.png"},
+			wantPrefixes: []string{"media/clips/"},
+		},
+		{
+			name:         "names only",
+			delimiter:    "/",
+			namesOnly:    true,
+			wantFiles:    []string{"media/a.jpg", "media/z.png"},
+			wantPrefixes: []string{"media/clips/"},
+		},
+		{
+			name:         "walked",
+			delimiter:    "/",
+			walk:         true,
+			wantFiles:    []string{"media/a.jpg", "media/z.png"},
+			wantPrefixes: []string{"media/clips/"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := service.List
+			if tt.namesOnly {
+				list = service.ListNames
+			}
+			if tt.walk {
+				list = func(ctx context.Context, prefix, delimiter string) (*Listing, error) {
+					listing := &Listing{}
+					err := service.WalkList(ctx, prefix, delimiter, func(entry ListingEntry) error {
+						if entry.File != nil {
+							listing.Files = append(listing.Files, *entry.File)
+						} else {
+							listing.Prefixes = append(listing.Prefixes, entry.Prefix)
+						}
+						return nil
+					})
+					return listing, err
+				}
+			}
+			listing, err := list(context.Background(), "media/", tt.delimiter)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var names []string
+			for _, file := range listing.Files {
+				names = append(names, file.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantFiles) {
+				t.Errorf("Expected files %v, got %v", tt.wantFiles, names)
+			}
+			if !reflect.DeepEqual(listing.Prefixes, tt.wantPrefixes) {
+				t.Errorf("Expected prefixes %v, got %v", tt.wantPrefixes, listing.Prefixes)
+			}
+		})
+	}
+}
+
+func TestStorageService_StreamRanges(t *testing.T) {
+	mock := &mockStorage{
+		readFileData: &storage.FileData{Metadata: storage.FileMetadata{Name: "a.mp4"}, Content: []byte("whole")},
+		readFilesResponse: &storage.ReadResponse{
+			Errors: []storage.ReadError{{FilePath: "b.mp4", Error: "not found"}},
+		},
+	}
+	service := NewStorageService(mock)
+
+	var got []string
+	err := service.StreamRanges(context.Background(), []storage.ReadRange{{Path: "a.mp4"}, {Path: "b.mp4", Length: 10}},
+		func(file *storage.FileData, readErr *storage.ReadError) error {
+			if readErr != nil {
+				got = append(got, "error "+readErr.FilePath)
+			} else {
+				got = append(got, "file "+file.Metadata.Name+" "+string(file.Content))
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"file a.mp4 whole", "error b.mp4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	err = service.StreamRanges(context.Background(), []storage.ReadRange{{Path: "a.mp4", Length: -1}},
+		func(*storage.FileData, *storage.ReadError) error { return nil })
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("Expected ErrInvalidRange, got %v", err)
+	}
+}
+
+func TestStorageService_CopyFile(t *testing.T) {
+	mock := &mockStorage{listFiles: []storage.FileMetadata{{Name: "b.jpg", Size: 3}}}
+	service := NewStorageService(mock)
+
+	file, err := service.CopyFile(context.Background(), "a.jpg", "b.jpg")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if mock.copied["a.jpg"] != "b.jpg" || file.Name != "b.jpg" {
+		t.Errorf("Expected a.jpg to be copied to b.jpg, got %v and %+v", mock.copied, file)
+	}
+
+	if _, err := service.CopyFile(context.Background(), "a.jpg", "a.jpg"); !errors.Is(err, ErrInvalidCopy) {
+		t.Errorf("Expected ErrInvalidCopy, got %v", err)
+	}
+}
+
+func TestStorageService_WriteFiles_Quotas(t *testing.T) {
+	mock := &mockStorage{writeFilesResponse: &storage.WriteResponse{
+		FilesWritten: []storage.FileMetadata{{Name: "a.jpg", Size: 60}},
+	}}
+	tracker := quota.NewTracker(quota.NewMemoryStore())
+	tracker.SetLimits(quota.Limits{DailyBytes: 100}, nil)
+	service := NewStorageService(mock, WithQuotas(tracker))
+	ctx := auth.WithPrincipal(context.Background(), "tenant")
+	write := func(ctx context.Context) error {
+		_, err := service.WriteFiles(ctx, []storage.WriteRequest{{Path: "a.jpg", Content: strings.NewReader("x")}})
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := write(ctx); err != nil {
+			t.Fatalf("Expected write %d within quota to succeed, got %v", i+1, err)
+		}
+	}
+	if err := write(ctx); !errors.Is(err, quota.ErrExceeded) {
+		t.Errorf("Expected quota.ErrExceeded, got %v", err)
+	}
+	if err := write(context.Background()); err != nil {
+		t.Errorf("Expected writes without a principal not to be counted, got %v", err)
+	}
+
+	usage, err := service.Usage(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if usage.Daily.Bytes != 120 || usage.Daily.Objects != 2 {
+		t.Errorf("Expected 120 bytes in 2 objects, got %d bytes in %d objects", usage.Daily.Bytes, usage.Daily.Objects)
+	}
+}
+
+func TestStorageService_Leases(t *testing.T) {
+	tests := []struct {
+		name     string
+		required bool
+		ids      []string
+		wantErr  error
+	}{
+		{name: "advisory", ids: nil},
+		{name: "required without the lease", required: true, wantErr: lease.ErrLocked},
+		{name: "required with another lease", required: true, ids: []string{"other"}, wantErr: lease.ErrLocked},
+		{name: "required with the lease", required: true, ids: []string{"held"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockStorage{writeFilesResponse: &storage.WriteResponse{}}
+			leases := lease.New(lease.NewMemoryStore(), time.Minute, time.Hour)
+			service := NewStorageService(mock, WithLeases(leases, tt.required))
+			ctx := context.Background()
+
+			held, err := service.AcquireLease(ctx, "a.jpg", 0)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var ids []string
+			for _, id := range tt.ids {
+				if id == "held" {
+					id = held.ID
+				}
+				ids = append(ids, id)
+			}
+			ctx = lease.WithIDs(ctx, ids)
+
+			_, err = service.WriteFiles(ctx, []storage.WriteRequest{{Path: "b.jpg"}, {Path: "a.jpg"}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v writing, got %v", tt.wantErr, err)
+			}
+			if err := service.DeleteFile(ctx, "a.jpg"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v deleting, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := NewStorageService(&mockStorage{}).AcquireLease(context.Background(), "a.jpg", 0); !errors.Is(err, ErrLeasesDisabled) {
+		t.Errorf("Expected ErrLeasesDisabled, got %v", err)
+	}
+}
+
+func TestStorageService_DeleteFile_Trash(t *testing.T) {
+	mock := &mockStorage{listFiles: []storage.FileMetadata{{Name: "a.jpg"}}}
+	service := NewStorageService(mock, WithTrash("/.trash/"))
+
+	if err := service.DeleteFile(context.Background(), "a.jpg"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	filePath, _, ok := parseTrashPath(".trash", mock.copied["a.jpg"])
+	if !ok || filePath != "a.jpg" {
+		t.Errorf("Expected a.jpg to be moved to the trash, got %v", mock.copied)
+	}
+	if err := service.DeleteFile(context.Background(), "b.jpg"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected storage.ErrNotFound for a missing file, got %v", err)
+	}
+	if err := service.DeleteFile(context.Background(), ".trash/20260102T150405.000000000Z/a.jpg"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"a.jpg", ".trash/20260102T150405.000000000Z/a.jpg"}
+	if !reflect.DeepEqual(mock.deleted, want) {
+		t.Errorf("Expected deleted %v, got %v", want, mock.deleted)
+	}
+}
+
+func TestStorageService_RestoreFile(t *testing.T) {
+	older := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	trashed := []storage.FileMetadata{
+		{Name: trashPath(".trash", "a.jpg", older)},
+		{Name: trashPath(".trash", "a.jpg", newer)},
+		{Name: trashPath(".trash", "a.jpg.bak", newer.Add(time.Hour))},
+	}
+
+	tests := []struct {
+		name      string
+		filePath  string
+		deletedAt time.Time
+		existing  bool
+		want      string
+		wantErr   error
+	}{
+		{name: "latest deletion", filePath: "a.jpg", want: trashed[1].Name},
+		{name: "chosen deletion", filePath: "a.jpg", deletedAt: older, want: trashed[0].Name},
+		{name: "unknown deletion", filePath: "a.jpg", deletedAt: older.Add(time.Minute), wantErr: ErrNotInTrash},
+		{name: "never deleted", filePath: "b.jpg", wantErr: ErrNotInTrash},
+		{name: "file exists", filePath: "a.jpg", existing: true, wantErr: ErrRestoreConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockStorage{listFiles: trashed}
+			if tt.existing {
+				mock.listFiles = append(mock.listFiles, storage.FileMetadata{Name: tt.filePath})
+			}
+			service := NewStorageService(mock, WithTrash(".trash"))
+
+			file, err := service.RestoreFile(context.Background(), tt.filePath, tt.deletedAt)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if mock.copied[tt.want] != tt.filePath || file.Name != tt.filePath {
+				t.Errorf("Expected %s to be restored to %s, got %v", tt.want, tt.filePath, mock.copied)
+			}
+			if !reflect.DeepEqual(mock.deleted, []string{tt.want}) {
+				t.Errorf("Expected %s to be removed from the trash, got %v", tt.want, mock.deleted)
+			}
+		})
+	}
+
+	if _, err := NewStorageService(&mockStorage{}).RestoreFile(context.Background(), "a.jpg", time.Time{}); !errors.Is(err, ErrTrashDisabled) {
+		t.Errorf("Expected ErrTrashDisabled, got %v", err)
+	}
+}
+
+func TestJobService_CollectGarbage(t *testing.T) {
+	mock := &mockStorage{listFiles: []storage.FileMetadata{
+		{Name: "_chunks/old", Size: 5, Updated: time.Now().Add(-2 * time.Hour)},
+		{Name: "_chunks/new", Size: 5, Updated: time.Now()},
+	}}
+	manager := jobs.NewManager(1, 1, time.Hour)
+	defer manager.Close()
+
+	if _, err := NewJobService(mock, manager, JobConfig{}).Create(context.Background(), jobs.Spec{Type: JobGC}); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("Expected ErrInvalidJob without prefixes, got %v", err)
+	}
+
+	service := NewJobService(mock, manager, JobConfig{GC: GCConfig{Prefixes: []string{"_chunks/"}, TTL: time.Hour}})
+	job, err := service.Create(context.Background(), jobs.Spec{Type: JobGC})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for job.FinishedAt == nil {
+		time.Sleep(5 * time.Millisecond)
+		if job, err = service.Get(job.ID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if !reflect.DeepEqual(mock.deleted, []string{"_chunks/old"}) {
+		t.Errorf("Expected only the expired object to be deleted, got %v", mock.deleted)
+	}
+	if job.Status != jobs.StatusSucceeded || job.Succeeded != 1 {
+		t.Errorf("Expected 1 object collected, got status %s with %d", job.Status, job.Succeeded)
+	}
+}
+
+type mockReplicas struct {
+	drift      map[string]string
+	replicated []string
+}
+
+func (m *mockReplicas) Compare(ctx context.Context, file storage.FileMetadata) (string, error) {
+	return m.drift[file.Name], nil
+}
+
+func (m *mockReplicas) Replicate(ctx context.Context, filePath string) error {
+	m.replicated = append(m.replicated, filePath)
+	return nil
+}
+
+func TestJobService_VerifyReplication(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	mock := &mockStorage{
+		writeFilesResponse: &storage.WriteResponse{},
+		listFiles: []storage.FileMetadata{
+			{Name: "docs/a.txt", Updated: old},
+			{Name: "docs/b.txt", Updated: old},
+			{Name: "docs/new.txt", Updated: time.Now()},
+			{Name: "_trash/c.txt", Updated: old},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		repair     bool
+		status     string
+		replicated []string
+	}{
+		{name: "report only", status: jobs.StatusPartial},
+		{name: "repair", repair: true, status: jobs.StatusSucceeded, replicated: []string{"docs/b.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := jobs.NewManager(1, 1, time.Hour)
+			defer manager.Close()
+			replicas := &mockReplicas{drift: map[string]string{"docs/b.txt": "checksum", "docs/new.txt": "missing", "_trash/c.txt": "missing"}}
+			service := NewJobService(mock, manager, JobConfig{
+				ResultPrefix: "_jobs",
+				Verify:       VerifyConfig{Replicas: replicas, Repair: tt.repair, Exclude: []string{"_trash/"}},
+			})
+			job, err := service.Create(context.Background(), jobs.Spec{Type: JobVerifyReplication})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for job.FinishedAt == nil {
+				time.Sleep(5 * time.Millisecond)
+				if job, err = service.Get(job.ID); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+
+			if job.Status != tt.status || job.Total != 2 {
+				t.Errorf("Expected status %s comparing 2 objects, got %s comparing %d", tt.status, job.Status, job.Total)
+			}
+			if !reflect.DeepEqual(replicas.replicated, tt.replicated) {
+				t.Errorf("Expected %v to be copied again, got %v", tt.replicated, replicas.replicated)
+			}
+			if job.Result != "_jobs/"+job.ID+"/report.json" {
+				t.Errorf("Expected the report to be stored, got result %q", job.Result)
+			}
+		})
+	}
+
+	manager := jobs.NewManager(1, 1, time.Hour)
+	defer manager.Close()
+	if _, err := NewJobService(mock, manager, JobConfig{}).Create(context.Background(), jobs.Spec{Type: JobVerifyReplication}); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("Expected ErrInvalidJob without replication, got %v", err)
+	}
+}
+
+func TestStorageService_FetchFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/a.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer server.Close()
+
+	mock := &mockStorage{writeFilesResponse: &storage.WriteResponse{FilesWritten: []storage.FileMetadata{{Name: "images/a.png"}}}}
+	service := NewStorageService(mock, WithFetcher(fetch.New(fetch.Config{AllowPrivate: true})))
+
+	response, err := service.FetchFiles(context.Background(), []FetchRequest{
+		{URL: server.URL + "/a.png", Path: "images/a.png"},
+		{URL: server.URL + "/missing.png", Path: "images/b.png"},
+		{Path: "images/c.png"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(mock.writeRequests) != 1 || mock.writeRequests[0].ContentType != "image/png" {
+		t.Errorf("Expected one image/png write, got %+v", mock.writeRequests)
+	}
+	if len(response.FilesWritten) != 1 || len(response.Errors) != 2 {
+		t.Errorf("Expected 1 file written and 2 errors, got %+v", response)
+	}
+
+	if _, err := NewStorageService(mock).FetchFiles(context.Background(), nil); !errors.Is(err, ErrFetchDisabled) {
+		t.Errorf("Expected ErrFetchDisabled, got %v", err)
+	}
+}
+
+type mockTransfer struct {
+	files  []storage.FileMetadata
+	copied []string
+}
+
+func (m *mockTransfer) HasBucket(name string) bool {
+	return name == DefaultBucket || name == "archive"
+}
+
+func (m *mockTransfer) ListFiles(ctx context.Context, bucket, prefix string) ([]storage.FileMetadata, error) {
+	return m.files, nil
+}
+
+func (m *mockTransfer) CopyFile(ctx context.Context, srcBucket, srcPath, dstBucket, dstPath string) error {
+	m.copied = append(m.copied, srcBucket+"/"+srcPath+" -> "+dstBucket+"/"+dstPath)
+	return nil
+}
+
+func TestJobService_Transfer(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    jobs.Spec
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "prefix keeps names",
+			spec: jobs.Spec{SourceBucket: DefaultBucket, DestinationBucket: "archive", Prefix: "videos/"},
+			want: []string{"default/videos/a.mp4 -> archive/videos/a.mp4"},
+		},
+		{
+			name: "prefix replaced by destination",
+			spec: jobs.Spec{SourceBucket: "archive", DestinationBucket: DefaultBucket, Prefix: "videos/", Destination: "restored/"},
+			want: []string{"archive/videos/a.mp4 -> default/restored/a.mp4"},
+		},
+		{
+			name: "paths",
+			spec: jobs.Spec{SourceBucket: DefaultBucket, DestinationBucket: "archive", Paths: []string{"a.jpg"}, Destination: "2026/"},
+			want: []string{"default/a.jpg -> archive/2026/a.jpg"},
+		},
+		{name: "unknown bucket", spec: jobs.Spec{SourceBucket: DefaultBucket, DestinationBucket: "other", Prefix: "videos/"}, wantErr: true},
+		{name: "nothing to transfer", spec: jobs.Spec{SourceBucket: DefaultBucket, DestinationBucket: "archive"}, wantErr: true},
+		{name: "onto itself", spec: jobs.Spec{SourceBucket: "archive", DestinationBucket: "archive", Prefix: "videos/"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transfer := &mockTransfer{files: []storage.FileMetadata{{Name: "videos/a.mp4"}}}
+			manager := jobs.NewManager(1, 1, time.Hour)
+			defer manager.Close()
+			service := NewJobService(&mockStorage{}, manager, JobConfig{Transfer: transfer})
+
+			job, err := service.Transfer(context.Background(), tt.spec)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidJob) {
+					t.Errorf("Expected ErrInvalidJob, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for job.FinishedAt == nil {
+				time.Sleep(5 * time.Millisecond)
+				if job, err = service.Get(job.ID); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			if !reflect.DeepEqual(transfer.copied, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, transfer.copied)
+			}
+		})
+	}
+
+	manager := jobs.NewManager(1, 1, time.Hour)
+	defer manager.Close()
+	service := NewJobService(&mockStorage{}, manager, JobConfig{Transfer: &mockTransfer{}})
+	if _, err := service.Create(context.Background(), jobs.Spec{Type: JobTransfer, SourceBucket: DefaultBucket, DestinationBucket: "archive", Prefix: "a/"}); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("Expected transfers to be refused through Create, got %v", err)
+	}
+}
+
+func TestStorageService_Quarantine(t *testing.T) {
+	mock := &mockStorage{
+		readFileData: &storage.FileData{Metadata: storage.FileMetadata{Name: "a.jpg"}},
+		listFiles: []storage.FileMetadata{
+			{Name: "_quarantine/b.jpg"},
+			{Name: "a.jpg"},
+		},
+	}
+	index := search.New(nil)
+	if err := index.Rebuild(context.Background(), mock); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	service := NewStorageService(mock, WithQuarantine("_quarantine"), WithSearchIndex(index))
+	admin := auth.WithScopes(context.Background(), []string{auth.ScopeAdmin})
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		path      string
+		wantErr   error
+		wantFiles int
+	}{
+		{name: "regular file", ctx: context.Background(), path: "a.jpg", wantFiles: 1},
+		{name: "quarantined file", ctx: context.Background(), path: "_quarantine/b.jpg", wantErr: ErrQuarantined, wantFiles: 1},
+		{name: "admin", ctx: admin, path: "_quarantine/b.jpg", wantFiles: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.ReadFile(tt.ctx, tt.path); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected read error %v, got %v", tt.wantErr, err)
+			}
+			if _, err := service.CopyFile(tt.ctx, tt.path, "copy.jpg"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected copy error %v, got %v", tt.wantErr, err)
+			}
+			files, err := service.ListFiles(tt.ctx, "")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(files) != tt.wantFiles {
+				t.Errorf("Expected %d listed files, got %v", tt.wantFiles, files)
+			}
+			result, err := service.SearchText(tt.ctx, "jpg", 0, 10)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Total != tt.wantFiles {
+				t.Errorf("Expected %d found files, got %v", tt.wantFiles, result.Entries)
+			}
+		})
+	}
+}
+
+func TestStorageService_SearchTags(t *testing.T) {
+	mock := &mockStorage{
+		listFiles: []storage.FileMetadata{
+			{Name: "_tags/project/apollo/b.mp4"},
+			{Name: "_tags/project/apollo/a.mp4"},
+			{Name: "_tags/project/apollo/deleted.mp4"},
+			{Name: "_tags/project/apollo/retagged.mp4"},
+			{Name: "_tags/project/gemini/c.mp4"},
+			{Name: "a.mp4", Size: 1},
+			{Name: "b.mp4", Size: 2},
+			{Name: "c.mp4", Size: 3},
+			{Name: "retagged.mp4", Size: 4},
+		},
+		metadata: map[string]map[string]string{
+			"a.mp4":        {"tag-project": "apollo", "tag-stage": "final"},
+			"b.mp4":        {"tag-project": "apollo"},
+			"c.mp4":        {"tag-project": "gemini"},
+			"retagged.mp4": {"tag-project": "gemini"},
+		},
+	}
+	service := NewStorageService(mock, WithTagIndex("/_tags/"))
+
+	tests := []struct {
+		name string
+		tags map[string]string
+		want []string
+	}{
+		{name: "one tag", tags: map[string]string{"project": "apollo"}, want: []string{"a.mp4", "b.mp4"}},
+		{name: "all tags", tags: map[string]string{"project": "apollo", "stage": "final"}, want: []string{"a.mp4"}},
+		{name: "no match", tags: map[string]string{"project": "mercury"}, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := service.SearchTags(context.Background(), tt.tags)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got := make([]string, 0, len(files))
+			for _, file := range files {
+				got = append(got, file.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// Stale entries are removed once a search comes across them
+	if !slices.Contains(mock.deleted, "_tags/project/apollo/deleted.mp4") || !slices.Contains(mock.deleted, "_tags/project/apollo/retagged.mp4") {
+		t.Errorf("Expected stale index entries to be removed, got %v", mock.deleted)
+	}
+
+	if _, err := NewStorageService(mock).SearchTags(context.Background(), map[string]string{"project": "apollo"}); !errors.Is(err, ErrTagsDisabled) {
+		t.Errorf("Expected ErrTagsDisabled, got %v", err)
+	}
+}
+
+func TestStorageService_SetTags(t *testing.T) {
+	tests := []struct {
+		name        string
+		tags        map[string]string
+		wantErr     error
+		wantIndexed []string
+		wantDeleted []string
+	}{
+		{
+			name:        "retag",
+			tags:        map[string]string{"project": "gemini", "stage": "final"},
+			wantIndexed: []string{"_tags/project/gemini/videos/a.mp4", "_tags/stage/final/videos/a.mp4"},
+			wantDeleted: []string{"_tags/project/apollo/videos/a.mp4"},
+		},
+		{
+			name:        "values are escaped",
+			tags:        map[string]string{"project": "apollo", "title": "a/b c"},
+			wantIndexed: []string{"_tags/project/apollo/videos/a.mp4", "_tags/title/a%2Fb%20c/videos/a.mp4"},
+		},
+		{name: "invalid key", tags: map[string]string{"Project": "apollo"}, wantErr: ErrInvalidTags},
+		{name: "empty value", tags: map[string]string{"project": ""}, wantErr: ErrInvalidTags},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockStorage{
+				writeFilesResponse: &storage.WriteResponse{},
+				metadata:           map[string]map[string]string{"videos/a.mp4": {"tag-project": "apollo"}},
+			}
+			service := NewStorageService(mock, WithTagIndex("_tags"))
+
+			_, err := service.SetTags(context.Background(), "videos/a.mp4", tt.tags)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var indexed []string
+			for _, req := range mock.writeRequests {
+				indexed = append(indexed, req.Path)
+			}
+			slices.Sort(indexed)
+			if !reflect.DeepEqual(indexed, tt.wantIndexed) {
+				t.Errorf("Expected index entries %v, got %v", tt.wantIndexed, indexed)
+			}
+			if !reflect.DeepEqual(mock.deleted, tt.wantDeleted) {
+				t.Errorf("Expected removed entries %v, got %v", tt.wantDeleted, mock.deleted)
+			}
+		})
+	}
+}
+
+func TestStorageService_CatalogListings(t *testing.T) {
+	c, err := catalog.Open(context.Background(), catalog.DriverSQLite, filepath.Join(t.TempDir(), "catalog.db"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer c.Close()
+
+	mock := &mockStorage{
+		writeFilesResponse: &storage.WriteResponse{FilesWritten: []storage.FileMetadata{{Name: "videos/a.mp4", Size: 5}}},
+		listFiles:          []storage.FileMetadata{{Name: "videos/written-elsewhere.mp4"}},
+	}
+	service := NewStorageService(mock, WithPublisher(c), WithCatalog(c, true))
+
+	if _, err := service.WriteFiles(context.Background(), []storage.WriteRequest{{Path: "videos/a.mp4", Content: strings.NewReader("video")}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	listing, err := service.List(context.Background(), "videos/", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(listing.Files) != 1 || listing.Files[0].Name != "videos/a.mp4" || listing.Files[0].Size != 5 {
+		t.Errorf("Expected the listing to come from the catalog, got %+v", listing.Files)
+	}
+}
+
+func TestStorageService_Changes(t *testing.T) {
+	feed := changes.New(changes.NewMemoryStore(10), nil)
+	service := NewStorageService(&mockStorage{}, WithChangeFeed(feed), WithQuarantine("_quarantine"))
+	ctx := context.Background()
+
+	start, err := service.Changes(ctx, LatestCursor, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	feed.Publish(ctx, events.Event{Type: events.ObjectWritten, Path: "a.jpg"})
+	feed.Publish(ctx, events.Event{Type: events.ObjectWritten, Path: "_quarantine/b.jpg"})
+
+	page, err := service.Changes(ctx, start.Cursor, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(page.Changes) != 1 || page.Changes[0].Path != "a.jpg" {
+		t.Errorf("Expected quarantined files to be hidden, got %+v", page.Changes)
+	}
+
+	// The hidden change still advances the cursor
+	page, err = service.Changes(ctx, page.Cursor, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(page.Changes) != 0 {
+		t.Errorf("Expected no more changes, got %+v", page.Changes)
+	}
+
+	admin, _ := service.Changes(auth.WithScopes(ctx, []string{auth.ScopeAdmin}), start.Cursor, 10)
+	if len(admin.Changes) != 2 {
+		t.Errorf("Expected admins to see quarantined files, got %+v", admin.Changes)
+	}
+}
+
+func TestStorageService_SubscribeEvents(t *testing.T) {
+	broadcaster := events.NewBroadcaster(nil)
+	service := NewStorageService(&mockStorage{}, WithEventStream(broadcaster), WithQuarantine("_quarantine"))
+	ctx := context.Background()
+
+	subscription, err := service.SubscribeEvents(ctx, []string{"videos/", "_quarantine/"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer subscription.Close()
+
+	for _, path := range []string{"videos/a.mp4", "photos/b.jpg", "_quarantine/videos/c.mp4"} {
+		broadcaster.Publish(ctx, events.Event{Type: events.ObjectWritten, Path: path})
+	}
+	if len(subscription.Events) != 1 || (<-subscription.Events).Path != "videos/a.mp4" {
+		t.Error("Expected only events under the prefixes and outside the quarantine")
+	}
+
+	if _, err := NewStorageService(&mockStorage{}).SubscribeEvents(ctx, nil); !errors.Is(err, ErrEventStreamDisabled) {
+		t.Errorf("Expected ErrEventStreamDisabled, got %v", err)
+	}
+}
+
+func TestStorageService_ReadSitePage(t *testing.T) {
+	mock := &mockStorage{objects: map[string]string{
+		"www/index.html":              "home",
+		"www/docs/index.html":         "docs",
+		"www/about.html":              "about",
+		"www/assets/main.3f9a1c2e.js": "bundle",
+		"www/favicon.ico":             "icon",
+	}}
+
+	tests := []struct {
+		name             string
+		spa              bool
+		path             string
+		wantContent      string
+		wantRedirect     string
+		wantCacheControl string
+		wantErr          error
+	}{
+		{name: "root", path: "/", wantContent: "home", wantCacheControl: "no-cache"},
+		{name: "file", path: "/about.html", wantContent: "about", wantCacheControl: "no-cache"},
+		{name: "directory", path: "/docs/", wantContent: "docs", wantCacheControl: "no-cache"},
+		{name: "directory without slash", path: "/docs", wantContent: "docs", wantRedirect: "/docs/"},
+		{name: "fingerprinted asset", path: "/assets/main.3f9a1c2e.js", wantContent: "bundle", wantCacheControl: "public, max-age=31536000, immutable"},
+		{name: "asset", path: "/favicon.ico", wantContent: "icon", wantCacheControl: "public, max-age=3600"},
+		{name: "escaping the prefix", path: "/../about.html", wantContent: "about", wantCacheControl: "no-cache"},
+		{name: "missing", path: "/settings/profile", wantErr: storage.ErrNotFound},
+		{name: "spa fallback", spa: true, path: "/settings/profile", wantContent: "home", wantCacheControl: "no-cache"},
+		{name: "spa fallback skips files", spa: true, path: "/missing.png", wantErr: storage.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewStorageService(mock, WithSite(SiteConfig{Prefix: "/www/", SPAFallback: tt.spa, AssetMaxAge: time.Hour}))
+
+			page, err := service.ReadSitePage(context.Background(), tt.path)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(page.File.Content) != tt.wantContent {
+				t.Errorf("Expected content %q, got %q", tt.wantContent, page.File.Content)
+			}
+			if page.Redirect != tt.wantRedirect {
+				t.Errorf("Expected redirect %q, got %q", tt.wantRedirect, page.Redirect)
+			}
+			if tt.wantCacheControl != "" && page.CacheControl != tt.wantCacheControl {
+				t.Errorf("Expected Cache-Control %q, got %q", tt.wantCacheControl, page.CacheControl)
+			}
+		})
+	}
+
+	if _, err := NewStorageService(mock).ReadSitePage(context.Background(), "/"); !errors.Is(err, ErrSiteDisabled) {
+		t.Errorf("Expected ErrSiteDisabled, got %v", err)
+	}
+}
+
+func TestStorageService_Shares(t *testing.T) {
+	mock := &mockStorage{
+		objects:   map[string]string{"docs/a.pdf": "a", "docs/sub/b.pdf": "b"},
+		listFiles: []storage.FileMetadata{{Name: "docs/a.pdf"}},
+	}
+	service := NewStorageService(mock, WithShares(share.New(share.NewMemoryStore(), time.Hour, 24*time.Hour)))
+	acme := auth.WithPrincipal(context.Background(), "acme")
+	globex := auth.WithPrincipal(context.Background(), "globex")
+	anonymous := context.Background()
+
+	if _, err := service.CreateShare(acme, share.Request{Path: "docs/missing.pdf"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound sharing a missing object, got %v", err)
+	}
+	object, err := service.CreateShare(acme, share.Request{Path: "docs/a.pdf", MaxDownloads: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	prefix, err := service.CreateShare(globex, share.Request{Path: "docs/", Prefix: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Uncounted reads, like HEAD requests, don't use up the share
+	for _, count := range []bool{false, true} {
+		file, err := service.ReadShare(anonymous, object.Token, "", "", count)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(file.Content) != "a" {
+			t.Errorf("Expected a, got %s", file.Content)
+		}
+	}
+	if _, err := service.ReadShare(anonymous, object.Token, "", "", true); !errors.Is(err, share.ErrExhausted) {
+		t.Errorf("Expected ErrExhausted, got %v", err)
+	}
+	file, err := service.ReadShare(anonymous, prefix.Token, "sub/b.pdf", "", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(file.Content) != "b" {
+		t.Errorf("Expected b, got %s", file.Content)
+	}
+
+	shares, err := service.ListShares(acme)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(shares) != 1 || shares[0].Token != object.Token {
+		t.Errorf("Expected only the share of acme, got %+v", shares)
+	}
+	admin := auth.WithScopes(acme, []string{auth.ScopeAdmin})
+	if shares, _ := service.ListShares(admin); len(shares) != 2 {
+		t.Errorf("Expected admins to see every share, got %+v", shares)
+	}
+
+	if err := service.RevokeShare(acme, prefix.Token); !errors.Is(err, share.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound revoking another client's share, got %v", err)
+	}
+	if err := service.RevokeShare(globex, prefix.Token); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := service.ReadShare(anonymous, prefix.Token, "sub/b.pdf", "", true); !errors.Is(err, share.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after revoking, got %v", err)
+	}
+
+	if _, err := NewStorageService(mock).ListShares(acme); !errors.Is(err, ErrSharesDisabled) {
+		t.Errorf("Expected ErrSharesDisabled, got %v", err)
+	}
+}
+
+func TestStorageService_DownloadStats(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockStorage{objects: map[string]string{"videos/a.mp4": "aaaa", "videos/b.mp4": "bb"}}
+	recorder := stats.NewRecorder(stats.NewMemoryStore(), time.Minute)
+	service := NewStorageService(mock, WithDownloadStats(recorder))
+
+	for _, filePath := range []string{"videos/a.mp4", "videos/a.mp4", "videos/b.mp4", "videos/missing.mp4"} {
+		service.ReadFile(ctx, filePath)
+	}
+	if err := recorder.Flush(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	list, err := service.DownloadStats(ctx, stats.Query{Sort: stats.SortDownloads})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(list) != 2 || list[0].Path != "videos/a.mp4" || list[0].Downloads != 2 || list[0].Bytes != 8 {
+		t.Errorf("Expected a.mp4 downloaded twice, then b.mp4, got %+v", list)
+	}
+
+	// Deleted objects drop out of the statistics
+	if err := service.DeleteFile(ctx, "videos/a.mp4"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	a, err := service.ObjectStats(ctx, "videos/a.mp4")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if a.Downloads != 0 {
+		t.Errorf("Expected no downloads of the deleted object, got %+v", a)
+	}
+
+	if _, err := NewStorageService(mock).ObjectStats(ctx, "videos/a.mp4"); !errors.Is(err, ErrStatsDisabled) {
+		t.Errorf("Expected ErrStatsDisabled, got %v", err)
+	}
+}
+
+// gatedWalkStorage holds walks until gate is closed and counts them
+type gatedWalkStorage struct {
+	*mockStorage
+	gate  chan struct{}
+	walks atomic.Int32
+}
+
+func (g *gatedWalkStorage) WalkFiles(ctx context.Context, prefix string, fn func(storage.FileMetadata) error) error {
+	g.walks.Add(1)
+	<-g.gate
+	return g.mockStorage.WalkFiles(ctx, prefix, fn)
+}
+
+func TestStorageService_PrefixUsage(t *testing.T) {
+	ctx := context.Background()
+	mock := &gatedWalkStorage{
+		mockStorage: &mockStorage{listFiles: []storage.FileMetadata{{Name: "users/1/a.jpg", Size: 100}, {Name: "users/1/b.jpg", Size: 20}}},
+		gate:        make(chan struct{}),
+	}
+	service := NewStorageService(mock, WithUsage(time.Minute, 0))
+
+	pending, err := service.PrefixUsage(ctx, "users/1/", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !pending.Pending {
+		t.Errorf("Expected async usage to be pending, got %+v", pending)
+	}
+
+	close(mock.gate)
+	usage, err := service.PrefixUsage(ctx, "users/1/", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if usage.Pending || usage.Objects != 2 || usage.Bytes != 120 || usage.ComputedAt.IsZero() {
+		t.Errorf("Expected 2 objects of 120 bytes, got %+v", usage)
+	}
+
+	// The result is cached for other requests of the same prefix, async or not
+	if cached, _ := service.PrefixUsage(ctx, "users/1/", true); cached.Pending || cached.Bytes != 120 {
+		t.Errorf("Expected the cached usage, got %+v", cached)
+	}
+	if walks := mock.walks.Load(); walks != 1 {
+		t.Errorf("Expected the prefix to be walked once, got %d", walks)
+	}
+
+	// Principals may see different buckets, so they don't share results
+	service.PrefixUsage(auth.WithPrincipal(ctx, "tenant-a"), "users/1/", false)
+	if walks := mock.walks.Load(); walks != 2 {
+		t.Errorf("Expected another walk for another principal, got %d", walks)
+	}
+
+	failing := NewStorageService(&mockStorage{listFilesError: errors.New("listing failed")})
+	if _, err := failing.PrefixUsage(ctx, "users/", false); err == nil {
+		t.Error("Expected the listing error")
+	}
+}
+
+func TestStorageService_PrefixUsageWait(t *testing.T) {
+	mock := &gatedWalkStorage{mockStorage: &mockStorage{}, gate: make(chan struct{})}
+	defer close(mock.gate)
+	service := NewStorageService(mock, WithUsage(time.Minute, 10*time.Millisecond))
+
+	usage, err := service.PrefixUsage(context.Background(), "", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !usage.Pending {
+		t.Errorf("Expected usage pending once the wait is over, got %+v", usage)
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+
+	tests := []struct {
+		name        string
+		after       string
+		at          string
+		expected    time.Time
+		expectedErr error
+	}{
+		{name: "duration", after: "90m", expected: time.Date(2024, 5, 1, 13, 30, 0, 0, time.UTC)},
+		{name: "seconds", after: "3600", expected: time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)},
+		{name: "time", at: "2024-05-02T00:00:00Z", expected: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+		{name: "both", after: "1h", at: "2024-05-02T00:00:00Z", expectedErr: ErrInvalidExpiry},
+		{name: "too short", after: "10ms", expectedErr: ErrInvalidExpiry},
+		{name: "negative", after: "-5", expectedErr: ErrInvalidExpiry},
+		{name: "garbage", after: "tomorrow", expectedErr: ErrInvalidExpiry},
+		{name: "past", at: "2024-04-30T00:00:00Z", expectedErr: ErrInvalidExpiry},
+		{name: "not RFC 3339", at: "2024-05-02", expectedErr: ErrInvalidExpiry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExpiry(tt.after, tt.at, now)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if !got.Equal(tt.expected) {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestStorageService_Expiry(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	mock := &mockStorage{writeFilesResponse: &storage.WriteResponse{
+		FilesWritten: []storage.FileMetadata{{Name: "tmp/a.txt", ExpiresAt: expiresAt}},
+	}}
+	requests := []storage.WriteRequest{{Path: "tmp/a.txt", Content: strings.NewReader("a")}}
+
+	if _, err := NewStorageService(mock).WriteFiles(context.Background(), requests, ExpiresAfter("1h")); !errors.Is(err, ErrExpiryDisabled) {
+		t.Fatalf("Expected ErrExpiryDisabled without an index prefix, got %v", err)
+	}
+
+	service := NewStorageService(mock, WithExpiry("/_expiry/"))
+	if _, err := service.WriteFiles(context.Background(), requests, ExpiresAt(expiresAt.Format(time.RFC3339))); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(mock.writeRequests) != 2 {
+		t.Fatalf("Expected the file and its marker to be written, got %+v", mock.writeRequests)
+	}
+	if got := mock.writeRequests[0].ExpiresAt; !got.Equal(expiresAt) {
+		t.Errorf("Expected the file to expire at %s, got %s", expiresAt, got)
+	}
+	if got, expected := mock.writeRequests[1].Path, expiryMarker("_expiry", expiresAt, "tmp/a.txt"); got != expected {
+		t.Errorf("Expected marker %s, got %s", expected, got)
+	}
+}
+
+func TestJobService_ExpireObjects(t *testing.T) {
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	mock := &mockStorage{
+		listFiles: []storage.FileMetadata{
+			{Name: expiryMarker("_expiry", past, "tmp/a.txt")},
+			{Name: expiryMarker("_expiry", past, "tmp/rewritten.txt")},
+			{Name: expiryMarker("_expiry", past, "tmp/gone.txt")},
+			{Name: expiryMarker("_expiry", future, "tmp/b.txt")},
+		},
+		metadata: map[string]map[string]string{
+			"tmp/a.txt":         {storage.ExpiresAtKey: past.UTC().Format(time.RFC3339)},
+			"tmp/rewritten.txt": {},
+			"tmp/b.txt":         {storage.ExpiresAtKey: future.UTC().Format(time.RFC3339)},
+		},
+	}
+	manager := jobs.NewManager(1, 1, time.Hour)
+	defer manager.Close()
+
+	if _, err := NewJobService(mock, manager, JobConfig{}).Create(context.Background(), jobs.Spec{Type: JobExpire}); !errors.Is(err, ErrInvalidJob) {
+		t.Errorf("Expected ErrInvalidJob without an index prefix, got %v", err)
+	}
+
+	service := NewJobService(mock, manager, JobConfig{ExpiryPrefix: "_expiry"})
+	job, err := service.Create(context.Background(), jobs.Spec{Type: JobExpire})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for job.FinishedAt == nil {
+		time.Sleep(5 * time.Millisecond)
+		if job, err = service.Get(job.ID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	expected := []string{
+		"tmp/a.txt",
+		expiryMarker("_expiry", past, "tmp/a.txt"),
+		expiryMarker("_expiry", past, "tmp/rewritten.txt"),
+		expiryMarker("_expiry", past, "tmp/gone.txt"),
+	}
+	if !reflect.DeepEqual(mock.deleted, expected) {
+		t.Errorf("Expected %v to be deleted, got %v", expected, mock.deleted)
+	}
+	if job.Status != jobs.StatusSucceeded || job.Succeeded != 3 {
+		t.Errorf("Expected 3 markers processed, got status %s with %d", job.Status, job.Succeeded)
+	}
+}
+
+func TestMountedStorage(t *testing.T) {
+	ctx := context.Background()
+	bucket := storage.NewFSStorage(t.TempDir())
+	backends := map[string]storage.Storage{"tmp/": storage.NewFSStorage(t.TempDir()), "archive/": storage.NewFSStorage(t.TempDir())}
+	scratch := backends["tmp/"]
+	mounts := mount.NewTable(func(ctx context.Context, m mount.Mount) (storage.Storage, func() error, error) {
+		return backends[m.Prefix], nil, nil
+	})
+	if err := mounts.AddStatic(ctx, mount.Mount{Prefix: "tmp/", Target: "file:///tmp"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	routed := NewMountedStorage(bucket, mounts)
+
+	// A file left in the bucket under the mount's prefix is hidden by the mount
+	if _, err := bucket.CreateFile(ctx, storage.WriteRequest{Path: "tmp/stale.txt", Content: strings.NewReader("stale")}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	response, err := routed.WriteFiles(ctx, []storage.WriteRequest{
+		{Path: "docs/a.txt", Content: strings.NewReader("a")},
+		{Path: "tmp/b.txt", Content: strings.NewReader("b")},
+	})
+	if err != nil || len(response.FilesWritten) != 2 {
+		t.Fatalf("Expected both files to be written, got %+v, %v", response, err)
+	}
+	if _, err := scratch.StatFile(ctx, "tmp/b.txt"); err != nil {
+		t.Errorf("Expected tmp/b.txt in the routed backend, got %v", err)
+	}
+	if err := routed.CopyFile(ctx, "tmp/b.txt", "docs/b.txt"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	names, err := routed.ListNames(ctx, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"docs/a.txt", "docs/b.txt", "tmp/b.txt"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected %v, got %v", want, names)
+	}
+	files, err := routed.ListFiles(ctx, "tmp/")
+	if err != nil || len(files) != 1 || files[0].Name != "tmp/b.txt" {
+		t.Errorf("Expected only tmp/b.txt under tmp/, got %+v, %v", files, err)
+	}
+
+	if _, err := routed.ComposeFiles(ctx, storage.WriteRequest{Path: "docs/c.txt"}, []string{"docs/a.txt", "tmp/b.txt"}); !errors.Is(err, ErrInvalidCompose) {
+		t.Errorf("Expected %v composing across backends, got %v", ErrInvalidCompose, err)
+	}
+
+	// A read-only mount needing a scope, added at runtime
+	if _, err := mounts.Create(ctx, mount.Mount{Prefix: "archive/", Target: "file:///archive", Scope: "archive", ReadOnly: true}, "ops"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := backends["archive/"].CreateFile(ctx, storage.WriteRequest{Path: "archive/old.txt", Content: strings.NewReader("old")}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	caller := auth.WithPrincipal(ctx, "acme")
+	if err := routed.DeleteFile(auth.WithScopes(caller, []string{"archive"}), "archive/old.txt"); !errors.Is(err, mount.ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly, got %v", err)
+	}
+	if _, err := routed.ReadFile(ctx, "archive/old.txt"); !errors.Is(err, mount.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden without a principal, got %v", err)
+	}
+	if _, err := routed.ReadFile(caller, "archive/old.txt"); !errors.Is(err, mount.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden without the scope, got %v", err)
+	}
+	if names, err := routed.ListNames(caller, ""); err != nil || slices.Contains(names, "archive/old.txt") {
+		t.Errorf("Expected the mount to be left out of the listing, got %v, %v", names, err)
+	}
+	if file, err := routed.ReadFile(auth.WithScopes(caller, []string{"archive"}), "archive/old.txt"); err != nil || string(file.Content) != "old" {
+		t.Errorf("Expected the file to be read with the scope, got %v", err)
+	}
+
+	// Sharing the mount needs its scope too, for prefixes as well as objects
+	service := NewStorageService(routed, WithShares(share.New(share.NewMemoryStore(), time.Hour, 24*time.Hour)))
+	for _, request := range []share.Request{{Path: "archive/old.txt"}, {Path: "archive/", Prefix: true}} {
+		if _, err := service.CreateShare(caller, request); !errors.Is(err, mount.ErrForbidden) {
+			t.Errorf("Expected ErrForbidden sharing %s without the scope, got %v", request.Path, err)
+		}
+	}
+	if _, err := service.CreateShare(caller, share.Request{Path: "docs/", Prefix: true}); err != nil {
+		t.Errorf("Expected sharing a prefix outside the mount to succeed, got %v", err)
+	}
+}
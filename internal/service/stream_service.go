@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gcp-proxy-mity/internal/storage"
+	"gcp-proxy-mity/internal/streaming"
+)
+
+// StreamConfig configures HLS/DASH passthrough
+type StreamConfig struct {
+	// Bucket is the bucket whose gs:// references in playlists are rewritten
+	Bucket string
+	// PlaylistMaxAge is the client cache lifetime of playlists and manifests
+	PlaylistMaxAge time.Duration
+	// SegmentMaxAge is the client cache lifetime of media segments
+	SegmentMaxAge time.Duration
+}
+
+// WithStreaming configures playlist rewriting and stream cache headers
+func WithStreaming(cfg StreamConfig) Option {
+	return func(s *StorageService) {
+		s.stream = cfg
+	}
+}
+
+// ReadStream reads a playlist, manifest or segment. Playlists are rewritten so
+// that their references resolve to baseURL, the route serving stream files.
+func (s *StorageService) ReadStream(ctx context.Context, filePath, baseURL string) (*storage.FileData, error) {
+	if err := s.checkQuarantine(ctx, filePath); err != nil {
+		return nil, err
+	}
+	fileData, err := s.storage.ReadFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType := streaming.ContentType(filePath); contentType != "" {
+		fileData.Metadata.ContentType = contentType
+	}
+
+	if streaming.IsPlaylist(filePath) {
+		rewriter := streaming.Rewriter{Bucket: s.stream.Bucket, BaseURL: baseURL}
+		fileData.Content = rewriter.Rewrite(filePath, fileData.Content)
+		fileData.Metadata.Size = int64(len(fileData.Content))
+	}
+
+	s.recordDownloads(*fileData)
+	return fileData, nil
+}
+
+// StreamCacheControl returns the Cache-Control header value for a stream file.
+// Cache-Control rules matching the file take precedence.
+func (s *StorageService) StreamCacheControl(filePath string) string {
+	if header := s.CacheControl(filePath); header != "" {
+		return header
+	}
+	if streaming.IsPlaylist(filePath) {
+		return fmt.Sprintf("public, max-age=%d", int(s.stream.PlaylistMaxAge.Seconds()))
+	}
+	return fmt.Sprintf("public, max-age=%d, immutable", int(s.stream.SegmentMaxAge.Seconds()))
+}
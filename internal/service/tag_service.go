@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// TagKeyPrefix marks the object metadata keys holding tags
+const TagKeyPrefix = "tag-"
+
+const (
+	maxTags        = 16
+	maxTagValueLen = 256
+)
+
+var tagKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,62}$`)
+
+// WithTagIndex enables object tags. Tags are kept in the object's metadata and
+// indexed by empty marker objects at {prefix}/{key}/{value}/{path}, since GCS
+// can't list objects by metadata.
+func WithTagIndex(prefix string) Option {
+	return func(s *StorageService) {
+		s.tagPrefix = strings.Trim(prefix, "/")
+	}
+}
+
+// tagMarkerPrefix returns where the markers of objects tagged key=value are kept
+func tagMarkerPrefix(prefix, key, value string) string {
+	return path.Join(prefix, url.PathEscape(key), url.PathEscape(value)) + "/"
+}
+
+// validateTags checks tag keys and values before they are stored
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxTags {
+		return fmt.Errorf("%w: at most %d tags per file", ErrInvalidTags, maxTags)
+	}
+	for key, value := range tags {
+		if !tagKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: key %q must be 1-63 lowercase letters, digits, '_', '.' or '-'", ErrInvalidTags, key)
+		}
+		if value == "" || len(value) > maxTagValueLen {
+			return fmt.Errorf("%w: value of %s must be 1-%d bytes", ErrInvalidTags, key, maxTagValueLen)
+		}
+	}
+	return nil
+}
+
+// tagsFromMetadata picks the tags out of an object's metadata
+func tagsFromMetadata(metadata map[string]string) map[string]string {
+	tags := make(map[string]string)
+	for key, value := range metadata {
+		if tag, ok := strings.CutPrefix(key, TagKeyPrefix); ok {
+			tags[tag] = value
+		}
+	}
+	return tags
+}
+
+// GetTags returns the tags of a file
+func (s *StorageService) GetTags(ctx context.Context, filePath string) (map[string]string, error) {
+	if s.tagPrefix == "" {
+		return nil, ErrTagsDisabled
+	}
+	if err := s.checkQuarantine(ctx, filePath); err != nil {
+		return nil, err
+	}
+	metadata, err := s.storage.GetMetadata(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return tagsFromMetadata(metadata), nil
+}
+
+// SetTags replaces the tags of a file and updates the index. Index entries of
+// removed tags that can't be deleted are dropped by later searches.
+func (s *StorageService) SetTags(ctx context.Context, filePath string, tags map[string]string) (map[string]string, error) {
+	if err := validateTags(tags); err != nil {
+		return nil, err
+	}
+	old, err := s.GetTags(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty value removes a metadata key
+	update := make(map[string]string, len(tags)+len(old))
+	for key := range old {
+		if _, ok := tags[key]; !ok {
+			update[TagKeyPrefix+key] = ""
+		}
+	}
+	for key, value := range tags {
+		update[TagKeyPrefix+key] = value
+	}
+	if err := s.storage.UpdateMetadata(ctx, filePath, update); err != nil {
+		return nil, err
+	}
+
+	for key, value := range old {
+		if tags[key] == value {
+			continue
+		}
+		marker := tagMarkerPrefix(s.tagPrefix, key, value) + filePath
+		if err := s.storage.DeleteFile(ctx, marker); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Failed to remove tag index entry %s: %v", marker, err)
+		}
+	}
+	if err := s.indexTags(ctx, filePath, tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// indexTags writes the index markers of a file's tags
+func (s *StorageService) indexTags(ctx context.Context, filePath string, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	requests := make([]storage.WriteRequest, 0, len(tags))
+	for key, value := range tags {
+		requests = append(requests, storage.WriteRequest{
+			Path:        tagMarkerPrefix(s.tagPrefix, key, value) + filePath,
+			Content:     strings.NewReader(""),
+			ContentType: "application/octet-stream",
+		})
+	}
+	response, err := s.storage.WriteFiles(ctx, requests)
+	if err != nil {
+		return fmt.Errorf("failed to index tags: %w", err)
+	}
+	if len(response.Errors) > 0 {
+		return fmt.Errorf("failed to index tags: %s", response.Errors[0].Error)
+	}
+	return nil
+}
+
+// reindexTags indexes the tags a file got from a copy; failures are only logged
+func (s *StorageService) reindexTags(ctx context.Context, filePath string) {
+	if s.tagPrefix == "" {
+		return
+	}
+	metadata, err := s.storage.GetMetadata(ctx, filePath)
+	if err == nil {
+		err = s.indexTags(ctx, filePath, tagsFromMetadata(metadata))
+	}
+	if err != nil {
+		log.Printf("Failed to index tags of %s: %v", filePath, err)
+	}
+}
+
+// SearchTags returns the files carrying all of the given tags, in path order.
+// Index entries of files that were deleted or retagged are removed on the way.
+func (s *StorageService) SearchTags(ctx context.Context, tags map[string]string) ([]storage.FileMetadata, error) {
+	if s.tagPrefix == "" {
+		return nil, ErrTagsDisabled
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("%w: at least one tag is required", ErrInvalidTags)
+	}
+
+	// Candidates come from the index of one tag and are checked against all of them
+	keys := slices.Sorted(maps.Keys(tags))
+	markerPrefix := tagMarkerPrefix(s.tagPrefix, keys[0], tags[keys[0]])
+	markers, err := s.storage.ListFiles(ctx, markerPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]storage.FileMetadata, 0)
+	for _, marker := range markers {
+		filePath, ok := strings.CutPrefix(marker.Name, markerPrefix)
+		if !ok || filePath == "" {
+			continue
+		}
+		metadata, err := s.storage.GetMetadata(ctx, filePath)
+		if errors.Is(err, storage.ErrNotFound) || (err == nil && metadata[TagKeyPrefix+keys[0]] != tags[keys[0]]) {
+			if err := s.storage.DeleteFile(ctx, marker.Name); err != nil {
+				log.Printf("Failed to remove stale tag index entry %s: %v", marker.Name, err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !matchesTags(metadata, tags) {
+			continue
+		}
+		file, err := s.storage.StatFile(ctx, filePath)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		files = append(files, *file)
+	}
+	slices.SortFunc(files, func(a, b storage.FileMetadata) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return s.hideQuarantined(ctx, files), nil
+}
+
+// matchesTags reports whether an object's metadata carries all of the tags
+func matchesTags(metadata, tags map[string]string) bool {
+	for key, value := range tags {
+		if metadata[TagKeyPrefix+key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gcp-proxy-mity/internal/jobs"
+)
+
+// DefaultBucket is the transfer name of the bucket the proxy serves
+const DefaultBucket = "default"
+
+// Transfer queues a job copying the spec's paths, or the files under its prefix,
+// from SourceBucket to DestinationBucket. Destination replaces the prefix in the
+// copied names, or is prepended to the paths; without it names are kept.
+func (s *JobService) Transfer(ctx context.Context, spec jobs.Spec) (*jobs.Job, error) {
+	spec.Type = JobTransfer
+	switch {
+	case s.transfer == nil:
+		return nil, fmt.Errorf("%w: transfers are not configured", ErrInvalidJob)
+	case !s.transfer.HasBucket(spec.SourceBucket) || !s.transfer.HasBucket(spec.DestinationBucket):
+		return nil, fmt.Errorf("%w: source_bucket and destination_bucket must be configured transfer buckets", ErrInvalidJob)
+	case spec.Prefix == "" && len(spec.Paths) == 0:
+		return nil, fmt.Errorf("%w: transfer requires paths or a prefix", ErrInvalidJob)
+	case spec.SourceBucket == spec.DestinationBucket && spec.Destination == "":
+		return nil, fmt.Errorf("%w: a transfer within a bucket requires a destination", ErrInvalidJob)
+	case spec.SourceBucket == spec.DestinationBucket && spec.Prefix != "" && strings.HasPrefix(spec.Destination, spec.Prefix):
+		return nil, fmt.Errorf("%w: destination must not be inside prefix", ErrInvalidJob)
+	}
+	return s.manager.Submit(ctx, spec)
+}
+
+func (s *JobService) transferObjects(ctx context.Context, spec jobs.Spec, t *jobs.Tracker) error {
+	type transfer struct{ src, dst string }
+	var transfers []transfer
+	for _, p := range spec.Paths {
+		transfers = append(transfers, transfer{p, spec.Destination + p})
+	}
+	if spec.Prefix != "" {
+		files, err := s.transfer.ListFiles(ctx, spec.SourceBucket, spec.Prefix)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			dst := file.Name
+			if spec.Destination != "" {
+				dst = spec.Destination + strings.TrimPrefix(file.Name, spec.Prefix)
+			}
+			transfers = append(transfers, transfer{file.Name, dst})
+		}
+	}
+	t.SetTotal(len(transfers))
+
+	for _, tr := range transfers {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := s.transfer.CopyFile(ctx, spec.SourceBucket, tr.src, spec.DestinationBucket, tr.dst); err != nil {
+			t.Fail(tr.src, err)
+			continue
+		}
+		t.Succeed()
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/storage"
+)
+
+// trashTimeLayout names the folder of a deletion; it sorts in deletion order
+const trashTimeLayout = "20060102T150405.000000000Z"
+
+// TrashConfig keeps deleted files under Prefix for Retention before they are purged
+type TrashConfig struct {
+	Prefix    string
+	Retention time.Duration
+}
+
+// WithTrash moves deleted files to {prefix}/{deletion time}/{path} instead of
+// deleting them, so they can be restored until the trash is purged
+func WithTrash(prefix string) Option {
+	return func(s *StorageService) {
+		s.trashPrefix = strings.Trim(prefix, "/")
+	}
+}
+
+// TrashedFile is a deleted file waiting in the trash. Name is where it is kept.
+type TrashedFile struct {
+	storage.FileMetadata
+	Path      string
+	DeletedAt time.Time
+}
+
+// inTrash reports whether filePath is kept in the trash
+func inTrash(prefix, filePath string) bool {
+	return prefix != "" && strings.HasPrefix(filePath, prefix+"/")
+}
+
+// trashPath returns where filePath is kept when it is deleted at deletedAt
+func trashPath(prefix, filePath string, deletedAt time.Time) string {
+	return path.Join(prefix, deletedAt.UTC().Format(trashTimeLayout), filePath)
+}
+
+// parseTrashPath splits a trash object name into the deleted file's path and deletion time
+func parseTrashPath(prefix, name string) (string, time.Time, bool) {
+	if !inTrash(prefix, name) {
+		return "", time.Time{}, false
+	}
+	stamp, filePath, ok := strings.Cut(strings.TrimPrefix(name, prefix+"/"), "/")
+	if !ok || filePath == "" {
+		return "", time.Time{}, false
+	}
+	deletedAt, err := time.Parse(trashTimeLayout, stamp)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return filePath, deletedAt, true
+}
+
+// listTrash returns the trashed files whose original path starts with prefix, oldest deletion first
+func listTrash(ctx context.Context, store storage.Storage, trashPrefix, prefix string) ([]TrashedFile, error) {
+	files, err := store.ListFiles(ctx, trashPrefix+"/")
+	if err != nil {
+		return nil, err
+	}
+	trashed := make([]TrashedFile, 0, len(files))
+	for _, file := range files {
+		filePath, deletedAt, ok := parseTrashPath(trashPrefix, file.Name)
+		if !ok || !strings.HasPrefix(filePath, prefix) {
+			continue
+		}
+		trashed = append(trashed, TrashedFile{FileMetadata: file, Path: filePath, DeletedAt: deletedAt})
+	}
+	return trashed, nil
+}
+
+// DeleteFile deletes a single file and notifies publishers. With a trash, the
+// file is moved there instead; files already in the trash are deleted for good.
+func (s *StorageService) DeleteFile(ctx context.Context, filePath string) error {
+	if err := s.checkLeases(ctx, filePath); err != nil {
+		return err
+	}
+	if err := s.deleteFile(ctx, filePath); err != nil {
+		return err
+	}
+	s.publishDeleted(ctx, filePath)
+	return nil
+}
+
+func (s *StorageService) deleteFile(ctx context.Context, filePath string) error {
+	if s.trashPrefix == "" || inTrash(s.trashPrefix, filePath) {
+		return s.storage.DeleteFile(ctx, filePath)
+	}
+
+	// Copying a missing object doesn't report ErrNotFound, so check first
+	if _, err := s.storage.StatFile(ctx, filePath); err != nil {
+		return err
+	}
+	dst := trashPath(s.trashPrefix, filePath, time.Now())
+	if err := s.storage.CopyFile(ctx, filePath, dst); err != nil {
+		return fmt.Errorf("failed to move file to trash: %w", err)
+	}
+	if err := s.storage.DeleteFile(ctx, filePath); err != nil {
+		// The file stays, e.g. under a hold, so it must not also be restorable
+		if err := s.storage.DeleteFile(ctx, dst); err != nil {
+			log.Printf("Failed to remove trash copy %s of %s: %v", dst, filePath, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// ListTrash lists the deleted files whose original path starts with prefix
+func (s *StorageService) ListTrash(ctx context.Context, prefix string) ([]TrashedFile, error) {
+	if s.trashPrefix == "" {
+		return nil, ErrTrashDisabled
+	}
+	return listTrash(ctx, s.storage, s.trashPrefix, prefix)
+}
+
+// RestoreFile moves a deleted file back to its path. Without deletedAt the
+// latest deletion is restored. An existing file at the path is not replaced.
+func (s *StorageService) RestoreFile(ctx context.Context, filePath string, deletedAt time.Time) (*storage.FileMetadata, error) {
+	if s.trashPrefix == "" {
+		return nil, ErrTrashDisabled
+	}
+	if inTrash(s.trashPrefix, filePath) {
+		return nil, fmt.Errorf("%w: restore the original path, not the trash path", ErrNotInTrash)
+	}
+
+	trashed, err := listTrash(ctx, s.storage, s.trashPrefix, filePath)
+	if err != nil {
+		return nil, err
+	}
+	var restore *TrashedFile
+	for i, file := range trashed {
+		if file.Path != filePath || (!deletedAt.IsZero() && !file.DeletedAt.Equal(deletedAt)) {
+			continue
+		}
+		if restore == nil || file.DeletedAt.After(restore.DeletedAt) {
+			restore = &trashed[i]
+		}
+	}
+	if restore == nil {
+		return nil, ErrNotInTrash
+	}
+
+	if _, err := s.storage.StatFile(ctx, filePath); err == nil {
+		return nil, ErrRestoreConflict
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	if err := s.storage.CopyFile(ctx, restore.Name, filePath); err != nil {
+		return nil, err
+	}
+	s.reindexTags(ctx, filePath)
+	if err := s.storage.DeleteFile(ctx, restore.Name); err != nil {
+		log.Printf("Failed to remove restored file %s from trash: %v", restore.Name, err)
+	}
+	file, err := s.storage.StatFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	s.publishWritten(ctx, []storage.WriteRequest{{Path: filePath}}, []storage.FileMetadata{*file})
+	return file, nil
+}
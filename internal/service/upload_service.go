@@ -0,0 +1,301 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/imaging"
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// Post-processing steps that can be requested for a direct upload
+const (
+	PostProcessThumbnail = "thumbnail"
+)
+
+// UploadConfig configures direct browser uploads through signed URLs
+type UploadConfig struct {
+	// URLTTL is how long an upload URL stays valid
+	URLTTL time.Duration
+	// RegistrationPrefix is the bucket prefix under which pending uploads are recorded
+	RegistrationPrefix string
+	// ThumbnailWidth is the width of generated thumbnails
+	ThumbnailWidth int
+	// CallbackSecret signs callback requests with an X-Signature header
+	CallbackSecret string
+	// CallbackHosts restricts callback URLs to these hosts; empty allows any host
+	CallbackHosts []string
+	// Sessions, when set, keeps registrations out of the bucket. The bucket is
+	// still used while the session store fails.
+	Sessions UploadSessions
+}
+
+// UploadSessions stores pending upload registrations shared by all replicas
+type UploadSessions interface {
+	Save(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Load returns nil without an error for unknown keys
+	Load(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// UploadRequest registers a direct upload and what should happen once it lands
+type UploadRequest struct {
+	Path        string            `json:"path"`
+	ContentType string            `json:"content_type"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	CallbackURL string            `json:"callback_url,omitempty"`
+	PostProcess []string          `json:"post_process,omitempty"`
+}
+
+// Upload tells the client where and how to send the object
+type Upload struct {
+	Path    string            `json:"path"`
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Expires time.Time         `json:"expires"`
+}
+
+// WithUploads enables signed upload URLs with post-processing once the object is stored
+func WithUploads(cfg UploadConfig) Option {
+	return func(s *StorageService) {
+		s.uploads = cfg
+	}
+}
+
+// ThumbnailPath returns where the thumbnail of an uploaded image is stored
+func ThumbnailPath(imagePath string, width int) string {
+	return fmt.Sprintf("%s.thumb-%dw.jpg", imagePath, width)
+}
+
+// CreateUpload records the upload and returns a signed URL the client uploads the object to
+func (s *StorageService) CreateUpload(ctx context.Context, req UploadRequest) (*Upload, error) {
+	if s.uploads.URLTTL <= 0 {
+		return nil, ErrUploadsDisabled
+	}
+	if err := s.validateUpload(&req); err != nil {
+		return nil, err
+	}
+	if err := policy.Authorize(ctx, policy.ActionWrite, req.Path); err != nil {
+		return nil, err
+	}
+
+	registration, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.saveRegistration(ctx, req.Path, registration); err != nil {
+		return nil, fmt.Errorf("failed to register upload: %w", err)
+	}
+
+	expires := time.Now().Add(s.uploads.URLTTL)
+	cacheControl := s.CacheControl(req.Path)
+	signedURL, err := s.storage.SignedUploadURL(ctx, storage.WriteRequest{
+		Path:         req.Path,
+		ContentType:  req.ContentType,
+		Metadata:     req.Metadata,
+		CacheControl: cacheControl,
+	}, expires)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{"Content-Type": req.ContentType}
+	if cacheControl != "" {
+		headers["Cache-Control"] = cacheControl
+	}
+	for key, value := range req.Metadata {
+		headers["x-goog-meta-"+key] = value
+	}
+
+	return &Upload{
+		Path:    req.Path,
+		URL:     signedURL,
+		Method:  "PUT",
+		Headers: headers,
+		Expires: expires,
+	}, nil
+}
+
+// CompleteUpload runs the post-processing registered for an object that was uploaded directly.
+// Objects without a registration are ignored. An error means the notification should be retried.
+func (s *StorageService) CompleteUpload(ctx context.Context, notification events.ObjectNotification) error {
+	if s.uploads.URLTTL <= 0 || strings.HasPrefix(notification.Path, s.uploads.RegistrationPrefix+"/") {
+		return nil
+	}
+
+	registration, inBucket, err := s.loadRegistration(ctx, notification.Path)
+	if err != nil || registration == nil {
+		return err
+	}
+
+	var req UploadRequest
+	if err := json.Unmarshal(registration, &req); err != nil {
+		log.Printf("Dropping invalid upload registration for %s: %v", notification.Path, err)
+		s.deleteRegistration(ctx, notification.Path, inBucket)
+		return nil
+	}
+
+	file := storage.FileMetadata{
+		Name:        notification.Path,
+		ContentType: notification.ContentType,
+		Size:        notification.Size,
+	}
+
+	if slices.Contains(req.PostProcess, PostProcessThumbnail) {
+		if err := s.storeThumbnail(ctx, notification.Path); err != nil {
+			log.Printf("Failed to generate thumbnail for %s: %v", notification.Path, err)
+		}
+	}
+
+	s.publishWritten(ctx, []storage.WriteRequest{{Path: file.Name, Metadata: notification.Metadata}}, []storage.FileMetadata{file})
+
+	if req.CallbackURL != "" {
+		event := events.NewObjectEvent(events.ObjectWritten, file, notification.Metadata)
+		if err := events.NewWebhookSender(req.CallbackURL, s.uploads.CallbackSecret).Send(ctx, event); err != nil {
+			return fmt.Errorf("callback to %s failed: %w", req.CallbackURL, err)
+		}
+	}
+
+	s.deleteRegistration(ctx, notification.Path, inBucket)
+	return nil
+}
+
+func (s *StorageService) validateUpload(req *UploadRequest) error {
+	req.Path = strings.TrimPrefix(req.Path, "/")
+	if req.Path == "" {
+		return fmt.Errorf("%w: path is required", ErrInvalidUpload)
+	}
+	if strings.HasPrefix(req.Path, s.uploads.RegistrationPrefix+"/") {
+		return fmt.Errorf("%w: path is reserved", ErrInvalidUpload)
+	}
+	// Direct uploads go to the bucket as sent, so the proxy can't encrypt them
+	if s.Envelope(req.Path) {
+		return fmt.Errorf("%w: objects at %s are encrypted by the proxy and must be written through it", ErrInvalidUpload, req.Path)
+	}
+
+	if req.ContentType == "" {
+		req.ContentType = storage.DetectContentType(req.Path)
+	}
+	if err := s.CheckContentType(req.ContentType); err != nil {
+		return err
+	}
+
+	for _, step := range req.PostProcess {
+		switch step {
+		case PostProcessThumbnail:
+			if !strings.HasPrefix(req.ContentType, "image/") {
+				return fmt.Errorf("%w: thumbnails require an image content type", ErrInvalidUpload)
+			}
+		default:
+			return fmt.Errorf("%w: unknown post-processing step %q", ErrInvalidUpload, step)
+		}
+	}
+
+	if req.CallbackURL != "" {
+		u, err := url.Parse(req.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: callback_url must be an http(s) URL", ErrInvalidUpload)
+		}
+		if len(s.uploads.CallbackHosts) > 0 && !slices.Contains(s.uploads.CallbackHosts, u.Hostname()) {
+			return fmt.Errorf("%w: callback host %s is not allowed", ErrInvalidUpload, u.Hostname())
+		}
+	}
+	return nil
+}
+
+func (s *StorageService) storeThumbnail(ctx context.Context, imagePath string) error {
+	original, err := s.storage.ReadFile(ctx, imagePath)
+	if err != nil {
+		return err
+	}
+
+	content, contentType, err := imaging.Transform(original.Content, imaging.Options{
+		Width:  s.uploads.ThumbnailWidth,
+		Format: "jpeg",
+	})
+	if err != nil {
+		return err
+	}
+
+	response, err := s.storage.WriteFiles(ctx, []storage.WriteRequest{{
+		Path:        ThumbnailPath(imagePath, s.uploads.ThumbnailWidth),
+		Content:     bytes.NewReader(content),
+		ContentType: contentType,
+	}})
+	if err == nil && len(response.Errors) > 0 {
+		err = errors.New(response.Errors[0].Error)
+	}
+	return err
+}
+
+// saveRegistration stores the registration in the session store, falling back to the bucket
+func (s *StorageService) saveRegistration(ctx context.Context, filePath string, registration []byte) error {
+	if s.uploads.Sessions != nil {
+		// Keep registrations long enough for late or redelivered notifications
+		err := s.uploads.Sessions.Save(ctx, filePath, registration, s.uploads.URLTTL+24*time.Hour)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Failed to store upload session for %s, using the bucket: %v", filePath, err)
+	}
+
+	response, err := s.storage.WriteFiles(ctx, []storage.WriteRequest{{
+		Path:        s.registrationPath(filePath),
+		Content:     bytes.NewReader(registration),
+		ContentType: "application/json",
+	}})
+	if err == nil && len(response.Errors) > 0 {
+		err = errors.New(response.Errors[0].Error)
+	}
+	return err
+}
+
+// loadRegistration returns the registration for an object, or nil if it was not uploaded through a signed URL
+func (s *StorageService) loadRegistration(ctx context.Context, filePath string) ([]byte, bool, error) {
+	if s.uploads.Sessions != nil {
+		registration, err := s.uploads.Sessions.Load(ctx, filePath)
+		if err == nil && registration != nil {
+			return registration, false, nil
+		}
+		if err != nil {
+			log.Printf("Failed to load upload session for %s, checking the bucket: %v", filePath, err)
+		}
+	}
+
+	data, err := s.storage.ReadFile(ctx, s.registrationPath(filePath))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data.Content, true, nil
+}
+
+func (s *StorageService) deleteRegistration(ctx context.Context, filePath string, inBucket bool) {
+	var err error
+	if inBucket {
+		err = s.storage.DeleteFile(ctx, s.registrationPath(filePath))
+	} else {
+		err = s.uploads.Sessions.Delete(ctx, filePath)
+	}
+	if err != nil {
+		log.Printf("Failed to remove upload registration for %s: %v", filePath, err)
+	}
+}
+
+func (s *StorageService) registrationPath(filePath string) string {
+	return path.Join(s.uploads.RegistrationPrefix, filePath+".json")
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// maxUsageEntries bounds the prefixes whose usage is remembered
+const maxUsageEntries = 10000
+
+// Usage is the number and size of the objects under a prefix. Pending usage
+// is still being computed and has no counts yet.
+type Usage struct {
+	Prefix     string    `json:"prefix"`
+	Objects    int64     `json:"objects"`
+	Bytes      int64     `json:"bytes"`
+	ComputedAt time.Time `json:"computed_at,omitzero"`
+	Pending    bool      `json:"pending,omitempty"`
+}
+
+// usageEntry is the usage of a prefix, being computed until done is closed
+type usageEntry struct {
+	done  chan struct{}
+	usage Usage
+	err   error
+}
+
+// finished reports whether the computation of the entry is over
+func (e *usageEntry) finished() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// usageCache shares the computation of a prefix's usage between concurrent
+// requests and keeps the result for ttl
+type usageCache struct {
+	ttl  time.Duration
+	wait time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*usageEntry
+}
+
+// WithUsage keeps the usage of a prefix for ttl once computed. Requests wait
+// for a computation up to wait, after which it goes on in the background; a
+// wait of 0 waits until it is done.
+func WithUsage(ttl, wait time.Duration) Option {
+	return func(s *StorageService) {
+		s.usage.ttl = ttl
+		s.usage.wait = wait
+	}
+}
+
+// PrefixUsage returns the number and size of the objects under prefix. The
+// objects are listed, or counted in the catalog when it serves listings, so
+// huge prefixes take a while: with async, or once the configured wait is
+// over, the usage is returned as pending while it is computed in the
+// background, and asking again returns it when it is ready.
+func (s *StorageService) PrefixUsage(ctx context.Context, prefix string, async bool) (*Usage, error) {
+	entry := s.usageEntry(ctx, prefix)
+
+	var timeout <-chan time.Time
+	if async {
+		timeout = closedTimeout
+	} else if s.usage.wait > 0 {
+		timer := time.NewTimer(s.usage.wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-entry.done:
+	case <-timeout:
+		// A computation that ended meanwhile wins over the timeout
+		if !entry.finished() {
+			return &Usage{Prefix: prefix, Pending: true}, nil
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	usage := entry.usage
+	return &usage, nil
+}
+
+// closedTimeout has already fired, for async requests not waiting at all
+var closedTimeout = func() <-chan time.Time {
+	c := make(chan time.Time)
+	close(c)
+	return c
+}()
+
+// usageEntry returns the cached usage of prefix as seen by the principal of
+// ctx, whose bucket may differ from others', starting to compute it unless it
+// is cached and fresh or already being computed
+func (s *StorageService) usageEntry(ctx context.Context, prefix string) *usageEntry {
+	key := auth.PrincipalFromContext(ctx) + "\x00" + prefix
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+
+	entry, ok := s.usage.entries[key]
+	if ok && (!entry.finished() || time.Since(entry.usage.ComputedAt) < s.usage.ttl) {
+		return entry
+	}
+	if s.usage.entries == nil {
+		s.usage.entries = make(map[string]*usageEntry)
+	}
+	if len(s.usage.entries) >= maxUsageEntries {
+		for k, e := range s.usage.entries {
+			if e.finished() {
+				delete(s.usage.entries, k)
+			}
+		}
+	}
+
+	entry = &usageEntry{done: make(chan struct{})}
+	s.usage.entries[key] = entry
+	// The computation outlives the request that started it, for the next one to pick up
+	go func() {
+		entry.usage, entry.err = s.computeUsage(context.WithoutCancel(ctx), prefix)
+		if entry.err != nil {
+			s.usage.mu.Lock()
+			if s.usage.entries[key] == entry {
+				delete(s.usage.entries, key)
+			}
+			s.usage.mu.Unlock()
+		}
+		close(entry.done)
+	}()
+	return entry
+}
+
+// computeUsage counts the objects under prefix and their bytes
+func (s *StorageService) computeUsage(ctx context.Context, prefix string) (Usage, error) {
+	usage := Usage{Prefix: prefix}
+	if s.catalog != nil && s.catalogListings {
+		objects, bytes, err := s.catalog.PrefixUsage(ctx, prefix)
+		if err != nil {
+			return usage, err
+		}
+		usage.Objects, usage.Bytes = objects, bytes
+	} else {
+		err := s.storage.WalkFiles(ctx, prefix, func(file storage.FileMetadata) error {
+			usage.Objects++
+			usage.Bytes += file.Size
+			return nil
+		})
+		if err != nil {
+			return usage, err
+		}
+	}
+	usage.ComputedAt = time.Now()
+	return usage, nil
+}
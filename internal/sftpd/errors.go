@@ -0,0 +1,32 @@
+package sftpd
+
+import (
+	"errors"
+	"os"
+
+	"gcp-proxy-mity/internal/mount"
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/storage"
+)
+
+var (
+	ErrUnknownKey         = errors.New("public key is not authorized")
+	ErrUploadTooLarge     = errors.New("upload exceeds the size limit")
+	ErrIncompleteUpload   = errors.New("upload has gaps between written ranges")
+	ErrNonSequentialWrite = errors.New("writes must not overlap data already stored")
+	ErrTooManyPending     = errors.New("too much data written ahead of the upload offset")
+	ErrDirectoryNotEmpty  = errors.New("directory not empty")
+	ErrAppendUnsupported  = errors.New("appending to objects is not supported")
+)
+
+// fsError translates storage errors into the os errors the SFTP server maps to status codes
+func fsError(err error) error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return os.ErrNotExist
+	}
+	if errors.Is(err, service.ErrQuarantined) || errors.Is(err, mount.ErrForbidden) || errors.Is(err, mount.ErrReadOnly) || errors.Is(err, policy.ErrForbidden) {
+		return os.ErrPermission
+	}
+	return err
+}
@@ -0,0 +1,361 @@
+package sftpd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/service"
+	"gcp-proxy-mity/internal/storage"
+)
+
+// fileSystem translates the SFTP operations of one session into storage
+// operations on the objects under the user's prefix. Buckets have no
+// directories, so directories are derived from object names; directories
+// created with mkdir only exist for the session until a file is put in them.
+type fileSystem struct {
+	service        *service.StorageService
+	user           string
+	prefix         string
+	maxUploadBytes int64
+	checks         []Check
+
+	mu   sync.Mutex
+	dirs map[string]bool
+}
+
+func newFileSystem(service *service.StorageService, user, prefix string, maxUploadBytes int64, checks []Check) *fileSystem {
+	return &fileSystem{
+		service:        service,
+		user:           user,
+		prefix:         strings.Trim(prefix, "/"),
+		maxUploadBytes: maxUploadBytes,
+		checks:         checks,
+		dirs:           make(map[string]bool),
+	}
+}
+
+// handlers returns the SFTP request handlers of the session
+func (f *fileSystem) handlers() sftp.Handlers {
+	return sftp.Handlers{FileGet: f, FilePut: f, FileCmd: f, FileList: f}
+}
+
+// context returns the context of the request, with the user as the principal
+func (f *fileSystem) context(r *sftp.Request) context.Context {
+	return auth.WithPrincipal(r.Context(), f.user)
+}
+
+// authorize runs the checks of the server for the action on each object
+func (f *fileSystem) authorize(ctx context.Context, action string, names ...string) error {
+	for _, name := range names {
+		for _, check := range f.checks {
+			if err := check(ctx, action, name); err != nil {
+				return fsError(err)
+			}
+		}
+	}
+	return nil
+}
+
+// objectPath maps an SFTP path onto the object name under the user's prefix.
+// The root directory maps to the prefix itself, which is empty without one.
+func objectPath(prefix, filePath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+filePath), "/")
+	if prefix == "" || name == "" {
+		return prefix + name
+	}
+	return prefix + "/" + name
+}
+
+// dirPrefix returns the object name prefix of the files in the directory
+func dirPrefix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return name + "/"
+}
+
+// Fileread implements sftp.FileReader
+func (f *fileSystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	ctx, name := f.context(r), objectPath(f.prefix, r.Filepath)
+	if err := f.authorize(ctx, policy.ActionRead, name); err != nil {
+		return nil, err
+	}
+	file, err := f.service.ReadFile(ctx, name)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	return bytes.NewReader(file.Content), nil
+}
+
+// Filewrite implements sftp.FileWriter. The upload is streamed into the object as
+// the client writes it; objects are replaced as a whole, so appends are refused.
+func (f *fileSystem) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if r.Pflags().Append {
+		return nil, ErrAppendUnsupported
+	}
+	name := objectPath(f.prefix, r.Filepath)
+	if name == f.prefix {
+		return nil, sftp.ErrSSHFxFailure
+	}
+
+	ctx := f.context(r)
+	if err := f.authorize(ctx, policy.ActionWrite, name); err != nil {
+		return nil, err
+	}
+
+	contentType := storage.DetectContentType(name)
+	if err := f.service.CheckContentType(contentType); err != nil {
+		return nil, err
+	}
+	return newObjectWriter(ctx, f.maxUploadBytes, func(ctx context.Context, content io.Reader) error {
+		response, err := f.service.WriteFiles(ctx, []storage.WriteRequest{{
+			Path:        name,
+			Content:     content,
+			ContentType: contentType,
+		}})
+		if err != nil {
+			return err
+		}
+		if len(response.FilesWritten) == 0 {
+			if len(response.Errors) > 0 {
+				return errors.New(response.Errors[0].Error)
+			}
+			return errors.New("no object was written")
+		}
+		return nil
+	}), nil
+}
+
+// Filecmd implements sftp.FileCmder
+func (f *fileSystem) Filecmd(r *sftp.Request) error {
+	ctx := f.context(r)
+	name := objectPath(f.prefix, r.Filepath)
+
+	switch r.Method {
+	case "Setstat":
+		// Objects have no permissions or settable times; accept so uploads that preserve them succeed
+		return nil
+	case "Remove":
+		if err := f.authorize(ctx, policy.ActionDelete, name); err != nil {
+			return err
+		}
+		return fsError(f.service.DeleteFile(ctx, name))
+	case "Rename", "PosixRename":
+		target := objectPath(f.prefix, r.Target)
+		// Renaming reads the object, writes it under the target and deletes it
+		if err := f.authorize(ctx, policy.ActionRead, name); err != nil {
+			return err
+		}
+		if err := f.authorize(ctx, policy.ActionWrite, target); err != nil {
+			return err
+		}
+		if err := f.authorize(ctx, policy.ActionDelete, name); err != nil {
+			return err
+		}
+		if _, err := f.service.StatFile(ctx, name); err != nil {
+			// Renaming directories would mean copying every object under them
+			return fsError(err)
+		}
+		if r.Method == "Rename" {
+			if _, err := f.service.StatFile(ctx, target); err == nil {
+				return os.ErrExist
+			}
+		}
+		return fsError(f.service.MoveFile(ctx, name, target))
+	case "Mkdir":
+		if name == f.prefix {
+			return os.ErrExist
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.dirs[name] = true
+		return nil
+	case "Rmdir":
+		if err := f.authorize(ctx, policy.ActionList, dirPrefix(name)); err != nil {
+			return err
+		}
+		entries, err := f.list(ctx, name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return ErrDirectoryNotEmpty
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.dirs, name)
+		return nil
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// Filelist implements sftp.FileLister
+func (f *fileSystem) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	ctx := f.context(r)
+	name := objectPath(f.prefix, r.Filepath)
+
+	switch r.Method {
+	case "List":
+		if err := f.authorize(ctx, policy.ActionList, dirPrefix(name)); err != nil {
+			return nil, err
+		}
+		info, err := f.stat(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return listerAt{info}, nil
+		}
+		entries, err := f.list(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt(entries), nil
+	case "Stat", "Lstat", "Readlink":
+		// The root directory is always there, which clients check as they log in
+		if name != f.prefix {
+			if err := f.authorize(ctx, policy.ActionRead, name); err != nil {
+				return nil, err
+			}
+		}
+		info, err := f.stat(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// stat describes the object or directory called name
+func (f *fileSystem) stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if name == f.prefix {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+	base := path.Base(name)
+
+	metadata, err := f.service.StatFile(ctx, name)
+	if err == nil {
+		return &fileInfo{name: base, size: metadata.Size, modTime: metadata.Updated}, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	created := f.dirs[name]
+	f.mu.Unlock()
+	if created {
+		return &fileInfo{name: base, dir: true}, nil
+	}
+	files, err := f.service.ListFiles(ctx, dirPrefix(name))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return &fileInfo{name: base, dir: true, modTime: files[0].Updated}, nil
+}
+
+// list returns the entries of the directory called name
+func (f *fileSystem) list(ctx context.Context, name string) ([]os.FileInfo, error) {
+	files, err := f.service.ListFiles(ctx, dirPrefix(name))
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	var created []string
+	for dir := range f.dirs {
+		if path.Dir(dir) == name || name == "" && !strings.Contains(dir, "/") {
+			created = append(created, path.Base(dir))
+		}
+	}
+	f.mu.Unlock()
+
+	return entries(files, dirPrefix(name), created), nil
+}
+
+// entries lists the files and subdirectories directly under prefix, given all
+// files under it and the names of directories created in the session
+func entries(files []storage.FileMetadata, prefix string, created []string) []os.FileInfo {
+	var list []os.FileInfo
+	dirs := make(map[string]*fileInfo)
+	for _, file := range files {
+		rest := strings.TrimPrefix(file.Name, prefix)
+		if dir, _, nested := strings.Cut(rest, "/"); nested {
+			if info, ok := dirs[dir]; ok {
+				if file.Updated.After(info.modTime) {
+					info.modTime = file.Updated
+				}
+				continue
+			}
+			dirs[dir] = &fileInfo{name: dir, dir: true, modTime: file.Updated}
+			list = append(list, dirs[dir])
+		} else if rest != "" {
+			list = append(list, &fileInfo{name: rest, size: file.Size, modTime: file.Updated})
+		}
+	}
+	for _, dir := range created {
+		if _, ok := dirs[dir]; !ok {
+			dirs[dir] = &fileInfo{name: dir, dir: true}
+			list = append(list, dirs[dir])
+		}
+	}
+
+	slices.SortFunc(list, func(a, b os.FileInfo) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return list
+}
+
+// fileInfo describes an object or a directory derived from object names
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.dir }
+func (i *fileInfo) Sys() any           { return nil }
+
+func (i *fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// listerAt implements sftp.ListerAt over a fixed list of entries
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(list []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(list, l[offset:])
+	if n < len(list) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,109 @@
+package sftpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/policy"
+	"gcp-proxy-mity/internal/storage"
+)
+
+func TestObjectPath(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		filePath string
+		want     string
+	}{
+		{prefix: "", filePath: "/", want: ""},
+		{prefix: "", filePath: "/media/a.jpg", want: "media/a.jpg"},
+		{prefix: "partners/acme", filePath: "/", want: "partners/acme"},
+		{prefix: "partners/acme", filePath: "/in/a.mp4", want: "partners/acme/in/a.mp4"},
+		{prefix: "partners/acme", filePath: "in/./b.mp4", want: "partners/acme/in/b.mp4"},
+		{prefix: "partners/acme", filePath: "/../../other/secret", want: "partners/acme/other/secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filePath, func(t *testing.T) {
+			if got := objectPath(tt.prefix, tt.filePath); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEntries(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	files := []storage.FileMetadata{
+		{Name: "in/a.mp4", Size: 10, Updated: older},
+		{Name: "in/clips/1.mp4", Size: 1, Updated: older},
+		{Name: "in/clips/2.mp4", Size: 2, Updated: newer},
+		{Name: "in/readme.txt", Size: 3, Updated: older},
+	}
+
+	list := entries(files, "in/", []string{"new", "clips"})
+
+	type entry struct {
+		Name    string
+		Dir     bool
+		Size    int64
+		ModTime time.Time
+	}
+	var got []entry
+	for _, info := range list {
+		got = append(got, entry{info.Name(), info.IsDir(), info.Size(), info.ModTime()})
+	}
+	want := []entry{
+		{Name: "a.mp4", Size: 10, ModTime: older},
+		{Name: "clips", Dir: true, ModTime: newer},
+		{Name: "new", Dir: true},
+		{Name: "readme.txt", Size: 3, ModTime: older},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestFileSystem_Checks(t *testing.T) {
+	var checked []string
+	deny := func(ctx context.Context, action, path string) error {
+		checked = append(checked, auth.PrincipalFromContext(ctx)+" "+action+" "+path)
+		return fmt.Errorf("%w: no rule allows it", policy.ErrForbidden)
+	}
+	fs := newFileSystem(nil, "acme", "partners/acme", 0, []Check{deny})
+
+	tests := []struct {
+		method string
+		run    func(r *sftp.Request) error
+		want   string
+	}{
+		{method: "Get", run: func(r *sftp.Request) error { _, err := fs.Fileread(r); return err }, want: "acme read partners/acme/in/a.mp4"},
+		{method: "Put", run: func(r *sftp.Request) error { _, err := fs.Filewrite(r); return err }, want: "acme write partners/acme/in/a.mp4"},
+		{method: "Remove", run: fs.Filecmd, want: "acme delete partners/acme/in/a.mp4"},
+		{method: "Rename", run: fs.Filecmd, want: "acme read partners/acme/in/a.mp4"},
+		{method: "List", run: func(r *sftp.Request) error { _, err := fs.Filelist(r); return err }, want: "acme list partners/acme/in/a.mp4/"},
+		{method: "Stat", run: func(r *sftp.Request) error { _, err := fs.Filelist(r); return err }, want: "acme read partners/acme/in/a.mp4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			checked = nil
+			r := sftp.NewRequest(tt.method, "/in/a.mp4")
+			r.Target = "/out/a.mp4"
+			if err := tt.run(r); !errors.Is(err, os.ErrPermission) {
+				t.Errorf("Expected os.ErrPermission, got %v", err)
+			}
+			if len(checked) != 1 || checked[0] != tt.want {
+				t.Errorf("Expected the check %q, got %v", tt.want, checked)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package sftpd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"gcp-proxy-mity/internal/service"
+)
+
+const (
+	handshakeTimeout = 30 * time.Second
+	prefixExtension  = "prefix"
+)
+
+// User is an SFTP account that logs in with one of its public keys
+type User struct {
+	Name           string
+	AuthorizedKeys []ssh.PublicKey
+	// Prefix confines the user to the objects under it, which appear as the root directory
+	Prefix string
+}
+
+// Server is an SSH server that only offers the sftp subsystem
+type Server struct {
+	service        *service.StorageService
+	config         *ssh.ServerConfig
+	maxUploadBytes int64
+
+	// checks decide on every operation on objects, like the access policy
+	checks []Check
+
+	mu    sync.RWMutex
+	users map[string]User
+
+	connMu   sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer creates an SFTP server identified by hostKey. Uploads larger than
+// maxUploadBytes are aborted; zero means no limit.
+func NewServer(service *service.StorageService, hostKey ssh.Signer, maxUploadBytes int64) *Server {
+	s := &Server{
+		service:        service,
+		maxUploadBytes: maxUploadBytes,
+		users:          make(map[string]User),
+		conns:          make(map[net.Conn]struct{}),
+	}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: s.authenticate,
+		ServerVersion:     "SSH-2.0-gcs-proxy",
+	}
+	s.config.AddHostKey(hostKey)
+	return s
+}
+
+// Check decides whether the user of ctx may take action on the object at
+// path, or the files under it for listings
+type Check func(ctx context.Context, action, path string) error
+
+// AddCheck has every operation on objects pass check, which returns an error
+// wrapping policy.ErrForbidden to deny it. It must be called before Serve.
+func (s *Server) AddCheck(check Check) {
+	s.checks = append(s.checks, check)
+}
+
+// LoadHostKey reads a PEM encoded private host key
+func LoadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(data)
+}
+
+// SetUsers replaces the accounts allowed to log in. Sessions that are already
+// open keep the prefix they logged in with.
+func (s *Server) SetUsers(users []User) {
+	byName := make(map[string]User, len(users))
+	for _, user := range users {
+		byName[user.Name] = user
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = byName
+}
+
+func (s *Server) authenticate(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	s.mu.RLock()
+	user, ok := s.users[conn.User()]
+	s.mu.RUnlock()
+
+	if ok {
+		marshaled := key.Marshal()
+		for _, authorized := range user.AuthorizedKeys {
+			if bytes.Equal(authorized.Marshal(), marshaled) {
+				return &ssh.Permissions{Extensions: map[string]string{prefixExtension: user.Prefix}}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w for user %q", ErrUnknownKey, conn.User())
+}
+
+// Serve accepts connections on l until Close is called
+func (s *Server) Serve(l net.Listener) error {
+	s.connMu.Lock()
+	if s.closed {
+		s.connMu.Unlock()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.connMu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.connMu.Lock()
+			closed := s.closed
+			s.connMu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer s.untrack(conn)
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Close stops accepting connections, disconnects open sessions and waits for
+// them to end. Uploads that are still in progress are aborted.
+func (s *Server) Close() error {
+	s.connMu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connMu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.connMu.Lock()
+	delete(s.conns, conn)
+	s.connMu.Unlock()
+	conn.Close()
+	s.wg.Done()
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			log.Printf("SFTP handshake with %s failed: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	defer sshConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	prefix := sshConn.Permissions.Extensions[prefixExtension]
+	var sessions sync.WaitGroup
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			s.handleSession(sshConn.User(), prefix, channel, channelRequests)
+		}()
+	}
+	sessions.Wait()
+}
+
+// handleSession serves the sftp subsystem; shells and commands are refused
+func (s *Server) handleSession(user, prefix string, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		var subsystem struct{ Name string }
+		ok := req.Type == "subsystem" && ssh.Unmarshal(req.Payload, &subsystem) == nil && subsystem.Name == "sftp"
+		req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+
+		go ssh.DiscardRequests(requests)
+		server := sftp.NewRequestServer(channel, newFileSystem(s.service, user, prefix, s.maxUploadBytes, s.checks).handlers())
+		if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+			log.Printf("SFTP session of %s ended: %v", user, err)
+		}
+		server.Close()
+		return
+	}
+}
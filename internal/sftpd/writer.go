@@ -0,0 +1,145 @@
+package sftpd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// maxPendingBytes bounds the data a client may write ahead of the upload offset.
+// Clients pipeline a few dozen 32KB writes, which the server may handle out of order.
+const maxPendingBytes = 8 << 20
+
+// objectWriter streams an SFTP upload into a single object write. Chunks that
+// arrive ahead of the current offset are held until the gap before them is
+// filled; an upload that is aborted or left with gaps is never committed.
+type objectWriter struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	result error
+
+	mu      sync.Mutex
+	pipe    *io.PipeWriter
+	offset  int64
+	limit   int64
+	pending map[int64][]byte
+	held    int
+	err     error
+}
+
+// newObjectWriter starts write with a reader of the upload's content. write runs
+// until the content is complete or ctx is cancelled because the upload failed.
+func newObjectWriter(ctx context.Context, limit int64, write func(ctx context.Context, content io.Reader) error) *objectWriter {
+	ctx, cancel := context.WithCancel(ctx)
+	reader, pipe := io.Pipe()
+	w := &objectWriter{
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		pipe:    pipe,
+		limit:   limit,
+		pending: make(map[int64][]byte),
+	}
+
+	go func() {
+		defer close(w.done)
+		err := write(ctx, reader)
+		w.result = err
+		// A write that stops reading early must not block the client's next chunk
+		if err == nil {
+			err = io.ErrClosedPipe
+		}
+		reader.CloseWithError(err)
+	}()
+	return w
+}
+
+// WriteAt implements io.WriterAt
+func (w *objectWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	switch {
+	case off < w.offset:
+		w.fail(ErrNonSequentialWrite)
+		return 0, w.err
+	case off > w.offset:
+		if _, ok := w.pending[off]; ok || w.held+len(p) > maxPendingBytes {
+			w.fail(ErrTooManyPending)
+			return 0, w.err
+		}
+		w.pending[off] = bytes.Clone(p)
+		w.held += len(p)
+		return len(p), nil
+	}
+
+	if err := w.write(p); err != nil {
+		return 0, err
+	}
+	for {
+		next, ok := w.pending[w.offset]
+		if !ok {
+			break
+		}
+		delete(w.pending, w.offset)
+		w.held -= len(next)
+		if err := w.write(next); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// write passes p on to the object at the current offset
+func (w *objectWriter) write(p []byte) error {
+	if w.limit > 0 && w.offset+int64(len(p)) > w.limit {
+		w.fail(ErrUploadTooLarge)
+		return w.err
+	}
+	n, err := w.pipe.Write(p)
+	w.offset += int64(n)
+	if err != nil {
+		w.fail(err)
+		return w.err
+	}
+	return nil
+}
+
+// fail aborts the object write so nothing is committed
+func (w *objectWriter) fail(err error) {
+	w.err = err
+	w.cancel()
+	w.pipe.CloseWithError(err)
+}
+
+// TransferError aborts the upload when the client disconnects or a write fails
+func (w *objectWriter) TransferError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.fail(err)
+	}
+}
+
+// Close completes the upload and waits for the object to be stored
+func (w *objectWriter) Close() error {
+	w.mu.Lock()
+	if w.err == nil && len(w.pending) > 0 {
+		w.fail(ErrIncompleteUpload)
+	}
+	err := w.err
+	if err == nil {
+		w.pipe.Close()
+	}
+	w.mu.Unlock()
+
+	<-w.done
+	w.cancel()
+	if err != nil {
+		return err
+	}
+	return w.result
+}
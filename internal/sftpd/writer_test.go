@@ -0,0 +1,112 @@
+package sftpd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+type chunk struct {
+	data   string
+	offset int64
+}
+
+func TestObjectWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunks  []chunk
+		limit   int64
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "sequential writes",
+			chunks: []chunk{{"hello ", 0}, {"world", 6}},
+			want:   "hello world",
+		},
+		{
+			name:   "out of order writes are reordered",
+			chunks: []chunk{{"world", 6}, {"lo ", 3}, {"hel", 0}},
+			want:   "hello world",
+		},
+		{
+			name:   "empty upload",
+			chunks: nil,
+			want:   "",
+		},
+		{
+			name:    "gap left at close",
+			chunks:  []chunk{{"hel", 0}, {"world", 6}},
+			wantErr: ErrIncompleteUpload,
+		},
+		{
+			name:    "overlapping write",
+			chunks:  []chunk{{"hello", 0}, {"lo", 3}},
+			wantErr: ErrNonSequentialWrite,
+		},
+		{
+			name:    "upload over the limit",
+			chunks:  []chunk{{"hello ", 0}, {"world", 6}},
+			limit:   8,
+			wantErr: ErrUploadTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored []byte
+			var storeErr error
+			w := newObjectWriter(context.Background(), tt.limit, func(ctx context.Context, content io.Reader) error {
+				data, err := io.ReadAll(content)
+				if err != nil {
+					storeErr = err
+					return err
+				}
+				stored = data
+				return nil
+			})
+
+			var err error
+			for _, c := range tt.chunks {
+				if _, err = w.WriteAt([]byte(c.data), c.offset); err != nil {
+					break
+				}
+			}
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				if stored != nil || storeErr == nil {
+					t.Errorf("Expected the object write to be aborted, stored %q", stored)
+				}
+				return
+			}
+			if string(stored) != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, stored)
+			}
+		})
+	}
+}
+
+func TestObjectWriter_TransferError(t *testing.T) {
+	var storeErr error
+	w := newObjectWriter(context.Background(), 0, func(ctx context.Context, content io.Reader) error {
+		_, storeErr = io.ReadAll(content)
+		return storeErr
+	})
+
+	w.WriteAt([]byte("partial"), 0)
+	w.TransferError(io.ErrUnexpectedEOF)
+
+	if err := w.Close(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if storeErr == nil {
+		t.Error("Expected the object write to be aborted")
+	}
+}
@@ -0,0 +1,11 @@
+package share
+
+import "errors"
+
+var (
+	ErrNotFound         = errors.New("share not found or expired")
+	ErrExhausted        = errors.New("share has reached its download limit")
+	ErrPasswordRequired = errors.New("share requires a password")
+	ErrWrongPassword    = errors.New("wrong share password")
+	ErrInvalidShare     = errors.New("invalid share")
+)
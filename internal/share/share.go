@@ -0,0 +1,266 @@
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Share grants anyone with its token read access to an object, or to every
+// object under a prefix, until it expires or runs out of downloads
+type Share struct {
+	Token string `json:"token"`
+	Path  string `json:"path"`
+	// Prefix shares the objects under Path, which ends with a slash
+	Prefix       bool      `json:"prefix,omitempty"`
+	Expires      time.Time `json:"expires"`
+	MaxDownloads int64     `json:"max_downloads,omitempty"`
+	Downloads    int64     `json:"downloads"`
+	// PasswordHash is the bcrypt hash of the password, empty for shares without one
+	PasswordHash string    `json:"-"`
+	CreatedBy    string    `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Request describes a share to create
+type Request struct {
+	Path   string
+	Prefix bool
+	// TTL is how long the share lasts, the default duration if zero
+	TTL time.Duration
+	// MaxDownloads limits the downloads through the share; zero is unlimited
+	MaxDownloads int64
+	Password     string
+}
+
+// Store keeps share records
+type Store interface {
+	// Create stores a new share
+	Create(ctx context.Context, share Share) error
+	// Get returns the share with token, nil if there is none
+	Get(ctx context.Context, token string) (*Share, error)
+	// List returns the shares created by createdBy, or all shares if it is
+	// empty, newest first
+	List(ctx context.Context, createdBy string) ([]Share, error)
+	// Delete removes the share with token, failing with ErrNotFound if there is none
+	Delete(ctx context.Context, token string) error
+	// CountDownload counts a download through the share with token, failing
+	// with ErrExhausted once it reached its limit
+	CountDownload(ctx context.Context, token string) error
+}
+
+// Manager creates shares with bounded lifetimes and resolves their tokens
+type Manager struct {
+	store      Store
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+	now        func() time.Time
+}
+
+// New creates a manager keeping shares in store. Shares requested without a
+// duration last defaultTTL, and none lasts longer than maxTTL.
+func New(store Store, defaultTTL, maxTTL time.Duration) *Manager {
+	return &Manager{
+		store:      store,
+		defaultTTL: defaultTTL,
+		maxTTL:     maxTTL,
+		now:        time.Now,
+	}
+}
+
+// Create shares the object or prefix of request on behalf of createdBy
+func (m *Manager) Create(ctx context.Context, request Request, createdBy string) (*Share, error) {
+	filePath := strings.TrimPrefix(request.Path, "/")
+	if request.Prefix && filePath != "" && !strings.HasSuffix(filePath, "/") {
+		filePath += "/"
+	}
+	if filePath == "" || strings.HasSuffix(filePath, "/") != request.Prefix {
+		return nil, fmt.Errorf("%w: path must name an object, or a prefix with prefix set", ErrInvalidShare)
+	}
+	if request.MaxDownloads < 0 {
+		return nil, fmt.Errorf("%w: max_downloads must not be negative", ErrInvalidShare)
+	}
+	ttl := request.TTL
+	if ttl == 0 {
+		ttl = m.defaultTTL
+	}
+	if ttl < time.Second || ttl > m.maxTTL {
+		return nil, fmt.Errorf("%w: ttl must be between 1s and %s", ErrInvalidShare, m.maxTTL)
+	}
+
+	now := m.now().UTC().Truncate(time.Millisecond)
+	share := Share{
+		Token:        rand.Text(),
+		Path:         filePath,
+		Prefix:       request.Prefix,
+		Expires:      now.Add(ttl),
+		MaxDownloads: request.MaxDownloads,
+		CreatedBy:    createdBy,
+		CreatedAt:    now,
+	}
+	if request.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidShare, err)
+		}
+		share.PasswordHash = string(hash)
+	}
+	if err := m.store.Create(ctx, share); err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// Open checks the password of the share with token and returns it with the
+// path of the object it serves: its own path, or name below its prefix
+func (m *Manager) Open(ctx context.Context, token, name, password string) (*Share, string, error) {
+	share, err := m.get(ctx, token)
+	if err != nil {
+		return nil, "", err
+	}
+	if share.PasswordHash != "" {
+		if password == "" {
+			return nil, "", ErrPasswordRequired
+		}
+		if bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)) != nil {
+			return nil, "", ErrWrongPassword
+		}
+	}
+	if share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
+		return nil, "", ErrExhausted
+	}
+
+	if !share.Prefix {
+		if name != "" {
+			return nil, "", ErrNotFound
+		}
+		return share, share.Path, nil
+	}
+	// Cleaning the rooted name keeps ".." from leaving the prefix
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil, "", ErrNotFound
+	}
+	return share, share.Path + name, nil
+}
+
+// CountDownload counts a download through the share with token
+func (m *Manager) CountDownload(ctx context.Context, token string) error {
+	return m.store.CountDownload(ctx, token)
+}
+
+// List returns the unexpired shares created by createdBy, or all of them if it is empty
+func (m *Manager) List(ctx context.Context, createdBy string) ([]Share, error) {
+	shares, err := m.store.List(ctx, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	return slices.DeleteFunc(shares, func(s Share) bool { return !now.Before(s.Expires) }), nil
+}
+
+// Get returns the unexpired share with token
+func (m *Manager) Get(ctx context.Context, token string) (*Share, error) {
+	return m.get(ctx, token)
+}
+
+// Revoke deletes the share with token before it expires
+func (m *Manager) Revoke(ctx context.Context, token string) error {
+	return m.store.Delete(ctx, token)
+}
+
+func (m *Manager) get(ctx context.Context, token string) (*Share, error) {
+	share, err := m.store.Get(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if share == nil || !m.now().Before(share.Expires) {
+		return nil, ErrNotFound
+	}
+	return share, nil
+}
+
+// MemoryStore keeps shares in memory, so they are lost on restart and only
+// work through the replica that created them
+type MemoryStore struct {
+	mu     sync.Mutex
+	shares map[string]Share
+	now    func() time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		shares: make(map[string]Share),
+		now:    time.Now,
+	}
+}
+
+func (s *MemoryStore) Create(ctx context.Context, share Share) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Expired shares are dropped here, since nothing else would
+	now := s.now()
+	for token, existing := range s.shares {
+		if !now.Before(existing.Expires) {
+			delete(s.shares, token)
+		}
+	}
+	s.shares[share.Token] = share
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, token string) (*Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share, ok := s.shares[token]
+	if !ok {
+		return nil, nil
+	}
+	return &share, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, createdBy string) ([]Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shares := make([]Share, 0)
+	for _, share := range s.shares {
+		if createdBy == "" || share.CreatedBy == createdBy {
+			shares = append(shares, share)
+		}
+	}
+	slices.SortFunc(shares, func(a, b Share) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return shares, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.shares[token]; !ok {
+		return ErrNotFound
+	}
+	delete(s.shares, token)
+	return nil
+}
+
+func (s *MemoryStore) CountDownload(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	share, ok := s.shares[token]
+	if !ok {
+		return ErrNotFound
+	}
+	if share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
+		return ErrExhausted
+	}
+	share.Downloads++
+	s.shares[token] = share
+	return nil
+}
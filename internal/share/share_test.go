@@ -0,0 +1,161 @@
+package share
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestManager() (*Manager, *time.Time) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	store := NewMemoryStore()
+	store.now = clock
+	manager := New(store, time.Hour, 24*time.Hour)
+	manager.now = clock
+	return manager, &now
+}
+
+func TestManager_Create(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  Request
+		wantPath string
+		wantTTL  time.Duration
+		wantErr  error
+	}{
+		{name: "object", request: Request{Path: "/docs/a.pdf"}, wantPath: "docs/a.pdf", wantTTL: time.Hour},
+		{name: "prefix", request: Request{Path: "docs", Prefix: true, TTL: 2 * time.Hour}, wantPath: "docs/", wantTTL: 2 * time.Hour},
+		{name: "prefix without flag", request: Request{Path: "docs/"}, wantErr: ErrInvalidShare},
+		{name: "empty path", request: Request{Prefix: true}, wantErr: ErrInvalidShare},
+		{name: "too long", request: Request{Path: "a.pdf", TTL: 48 * time.Hour}, wantErr: ErrInvalidShare},
+		{name: "negative limit", request: Request{Path: "a.pdf", MaxDownloads: -1}, wantErr: ErrInvalidShare},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, now := newTestManager()
+			share, err := manager.Create(context.Background(), tt.request, "ci")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if share.Path != tt.wantPath {
+				t.Errorf("Expected path %s, got %s", tt.wantPath, share.Path)
+			}
+			if got := share.Expires.Sub(*now); got != tt.wantTTL {
+				t.Errorf("Expected ttl %s, got %s", tt.wantTTL, got)
+			}
+			if share.Token == "" || share.CreatedBy != "ci" {
+				t.Errorf("Expected a token created by ci, got %+v", share)
+			}
+		})
+	}
+}
+
+func TestManager_Open(t *testing.T) {
+	manager, now := newTestManager()
+	ctx := context.Background()
+	object, err := manager.Create(ctx, Request{Path: "docs/a.pdf", MaxDownloads: 1}, "ci")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	prefix, err := manager.Create(ctx, Request{Path: "docs/", Prefix: true, Password: "secret"}, "ci")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		file     string
+		password string
+		wantPath string
+		wantErr  error
+	}{
+		{name: "object", token: object.Token, wantPath: "docs/a.pdf"},
+		{name: "name below an object", token: object.Token, file: "b.pdf", wantErr: ErrNotFound},
+		{name: "unknown token", token: "nope", wantErr: ErrNotFound},
+		{name: "file under prefix", token: prefix.Token, file: "sub/b.pdf", password: "secret", wantPath: "docs/sub/b.pdf"},
+		{name: "escaping prefix", token: prefix.Token, file: "../private.pdf", password: "secret", wantPath: "docs/private.pdf"},
+		{name: "prefix itself", token: prefix.Token, password: "secret", wantErr: ErrNotFound},
+		{name: "no password", token: prefix.Token, file: "b.pdf", wantErr: ErrPasswordRequired},
+		{name: "wrong password", token: prefix.Token, file: "b.pdf", password: "guess", wantErr: ErrWrongPassword},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, filePath, err := manager.Open(ctx, tt.token, tt.file, tt.password)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if filePath != tt.wantPath {
+				t.Errorf("Expected %s, got %s", tt.wantPath, filePath)
+			}
+		})
+	}
+
+	if err := manager.CountDownload(ctx, object.Token); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := manager.CountDownload(ctx, object.Token); !errors.Is(err, ErrExhausted) {
+		t.Errorf("Expected ErrExhausted counting past the limit, got %v", err)
+	}
+	if _, _, err := manager.Open(ctx, object.Token, "", ""); !errors.Is(err, ErrExhausted) {
+		t.Errorf("Expected ErrExhausted opening a used up share, got %v", err)
+	}
+
+	*now = now.Add(2 * time.Hour)
+	if _, _, err := manager.Open(ctx, prefix.Token, "b.pdf", "secret"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an expired share, got %v", err)
+	}
+	shares, err := manager.List(ctx, "ci")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(shares) != 0 {
+		t.Errorf("Expected expired shares to be left out, got %+v", shares)
+	}
+}
+
+func TestManager_Revoke(t *testing.T) {
+	manager, _ := newTestManager()
+	ctx := context.Background()
+	share, err := manager.Create(ctx, Request{Path: "a.pdf"}, "ci")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := manager.Create(ctx, Request{Path: "b.pdf"}, "other"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	shares, err := manager.List(ctx, "ci")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(shares) != 1 || shares[0].Token != share.Token {
+		t.Errorf("Expected only the share of ci, got %+v", shares)
+	}
+
+	if err := manager.Revoke(ctx, share.Token); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, err := manager.Open(ctx, share.Token, "", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after revoking, got %v", err)
+	}
+	if err := manager.Revoke(ctx, share.Token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound revoking twice, got %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+package spool
+
+import "errors"
+
+// ErrFull is returned when spooling would exceed the disk usage cap
+var ErrFull = errors.New("spool disk usage limit reached")
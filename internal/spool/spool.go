@@ -0,0 +1,143 @@
+package spool
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// filePrefix marks the files a Spooler creates, so leftovers of a crashed
+// process can be told apart from other files in the directory
+const filePrefix = "upload-"
+
+// chunkSize is how much is copied to disk at a time. Disk usage is reserved
+// before each chunk is written, so concurrent uploads can't overshoot the cap.
+const chunkSize = 1 << 20
+
+// Spooler buffers request bodies in memory and spills them to files in a
+// directory once they outgrow their memory budget. The disk space all
+// spooled files use together is capped. The zero Spooler writes to the
+// system temp directory without a cap.
+type Spooler struct {
+	dir      string
+	maxBytes int64
+	used     atomic.Int64
+}
+
+// New creates a Spooler writing to dir, a directory below the system temp
+// directory if empty. A maxBytes of zero leaves disk usage uncapped. Files
+// left behind by an earlier process are removed, so dir must not be shared
+// with another running instance.
+func New(dir string, maxBytes int64) (*Spooler, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "gcp-proxy-mity-spool")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, filePrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range stale {
+		if err := os.Remove(name); err != nil {
+			log.Printf("Failed to remove stale spool file %s: %v", name, err)
+		}
+	}
+	return &Spooler{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// Used returns the bytes currently spooled to disk
+func (s *Spooler) Used() int64 {
+	return s.used.Load()
+}
+
+// Spool reads r to the end. Up to memory bytes are kept in memory, the rest
+// is written to a file. The returned File must be closed to free the disk
+// space. ErrFull is returned when the disk usage cap would be exceeded.
+func (s *Spooler) Spool(r io.Reader, memory int64) (*File, error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, memory+1)
+	if err == io.EOF {
+		return &File{ReadSeeker: bytes.NewReader(buf.Bytes()), size: n}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(s.dir, filePrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	file := &File{ReadSeeker: f, spooler: s, file: f}
+	if err := file.fill(io.MultiReader(&buf, r)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// reserve accounts for n more bytes on disk
+func (s *Spooler) reserve(n int64) error {
+	if s.used.Add(n) > s.maxBytes && s.maxBytes > 0 {
+		s.used.Add(-n)
+		return fmt.Errorf("%w of %d bytes", ErrFull, s.maxBytes)
+	}
+	return nil
+}
+
+// File is spooled content, held in memory or in a file on disk
+type File struct {
+	io.ReadSeeker
+	size    int64
+	spooler *Spooler
+	file    *os.File
+}
+
+// Size returns the length of the content
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// OnDisk reports whether the content was spilled to disk
+func (f *File) OnDisk() bool {
+	return f.file != nil
+}
+
+// Close deletes the file on disk and releases its disk usage
+func (f *File) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	f.file.Close()
+	err := os.Remove(f.file.Name())
+	f.spooler.used.Add(-f.size)
+	f.file = nil
+	return err
+}
+
+func (f *File) fill(r io.Reader) error {
+	for {
+		if err := f.spooler.reserve(chunkSize); err != nil {
+			return err
+		}
+		n, err := io.CopyN(f.file, r, chunkSize)
+		// Only the bytes written stay reserved
+		f.spooler.used.Add(n - chunkSize)
+		f.size += n
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
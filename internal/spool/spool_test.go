@@ -0,0 +1,104 @@
+package spool
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpooler_Spool(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		memory   int64
+		maxBytes int64
+		onDisk   bool
+		wantErr  error
+	}{
+		{name: "in memory", content: "hello", memory: 5},
+		{name: "empty", content: "", memory: 0},
+		{name: "spilled", content: "hello world", memory: 5, onDisk: true},
+		{name: "no memory", content: "hello", memory: 0, onDisk: true},
+		{name: "over cap", content: strings.Repeat("a", chunkSize+1), memory: 0, maxBytes: chunkSize, wantErr: ErrFull},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := New(dir, tt.maxBytes)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			f, err := s.Spool(strings.NewReader(tt.content), tt.memory)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				assertEmpty(t, s, dir)
+				return
+			}
+
+			if f.OnDisk() != tt.onDisk {
+				t.Errorf("Expected on disk %v, got %v", tt.onDisk, f.OnDisk())
+			}
+			if f.Size() != int64(len(tt.content)) {
+				t.Errorf("Expected size %d, got %d", len(tt.content), f.Size())
+			}
+			got, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(got) != tt.content {
+				t.Errorf("Expected content %q, got %q", tt.content, got)
+			}
+			if tt.onDisk && s.Used() != f.Size() {
+				t.Errorf("Expected %d bytes used, got %d", f.Size(), s.Used())
+			}
+
+			if err := f.Close(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			assertEmpty(t, s, dir)
+		})
+	}
+}
+
+func TestNew_RemovesStaleFiles(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, filePrefix+"123")
+	other := filepath.Join(dir, "keep.txt")
+	for _, name := range []string{stale, other} {
+		if err := os.WriteFile(name, []byte("x"), 0o600); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if _, err := New(dir, 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Expected stale spool file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Expected other files to be kept, got %v", err)
+	}
+}
+
+func assertEmpty(t *testing.T, s *Spooler, dir string) {
+	t.Helper()
+	if s.Used() != 0 {
+		t.Errorf("Expected no bytes used, got %d", s.Used())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected spool directory to be empty, got %d files", len(entries))
+	}
+}
@@ -0,0 +1,7 @@
+package stats
+
+import "errors"
+
+var (
+	ErrInvalidQuery = errors.New("invalid stats query")
+)
@@ -0,0 +1,288 @@
+package stats
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/events"
+	"gcp-proxy-mity/internal/metrics"
+)
+
+var (
+	downloadsTotal = metrics.NewCounter("gcs_proxy_downloads_total", "Object downloads served")
+	downloadBytes  = metrics.NewCounter("gcs_proxy_download_bytes_total", "Bytes of objects served by downloads")
+	droppedHits    = metrics.NewCounter("gcs_proxy_download_stats_dropped_total", "Downloads left out of the per-object statistics because the recorder fell behind")
+)
+
+// Sort orders of Query
+const (
+	SortDownloads  = "downloads"
+	SortBytes      = "bytes"
+	SortLastAccess = "last_access"
+)
+
+// bufferSize is how many downloads can wait for the recorder before new ones are dropped
+const bufferSize = 4096
+
+// Stats are the downloads of an object
+type Stats struct {
+	Path       string    `json:"path"`
+	Downloads  int64     `json:"downloads"`
+	Bytes      int64     `json:"bytes"`
+	LastAccess time.Time `json:"last_access,omitzero"`
+}
+
+// Query selects statistics under Prefix, ordered by Sort, descending unless Ascending
+type Query struct {
+	Prefix    string
+	Sort      string
+	Ascending bool
+	Limit     int
+}
+
+// Validate checks the sort order and limit of the query
+func (q Query) Validate() error {
+	switch q.Sort {
+	case SortDownloads, SortBytes, SortLastAccess:
+	default:
+		return fmt.Errorf("%w: sort must be downloads, bytes or last_access", ErrInvalidQuery)
+	}
+	if q.Limit < 0 {
+		return fmt.Errorf("%w: limit must not be negative", ErrInvalidQuery)
+	}
+	return nil
+}
+
+// Store keeps the totals of every downloaded object
+type Store interface {
+	// Add adds the downloads and bytes of each entry to its object's totals
+	// and moves the object's last access forward to the entry's
+	Add(ctx context.Context, entries []Stats) error
+	// Get returns the totals of path, nil if it was never downloaded
+	Get(ctx context.Context, path string) (*Stats, error)
+	// List returns the totals selected by query
+	List(ctx context.Context, query Query) ([]Stats, error)
+	// Delete drops the totals of path
+	Delete(ctx context.Context, path string) error
+}
+
+// Recorder counts downloads without holding up the requests serving them.
+// Downloads are queued and summed per object in memory, then added to the
+// store every flush interval, so the store sees one write per object and
+// interval however many times it was downloaded.
+type Recorder struct {
+	store    Store
+	interval time.Duration
+	hits     chan Stats
+
+	mu      sync.Mutex
+	pending map[string]*Stats
+}
+
+// NewRecorder creates a recorder adding to store every interval
+func NewRecorder(store Store, interval time.Duration) *Recorder {
+	return &Recorder{
+		store:    store,
+		interval: interval,
+		hits:     make(chan Stats, bufferSize),
+		pending:  make(map[string]*Stats),
+	}
+}
+
+// Record counts a download of n bytes of path. It never blocks; when the
+// recorder falls behind the download is only counted by the metrics.
+func (r *Recorder) Record(path string, n int64) {
+	downloadsTotal.Inc()
+	downloadBytes.Add(n)
+	select {
+	case r.hits <- Stats{Path: path, Downloads: 1, Bytes: n, LastAccess: time.Now().UTC()}:
+	default:
+		droppedHits.Inc()
+	}
+}
+
+// Run sums queued downloads and flushes them every interval until ctx is
+// done, then flushes what is left
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case hit := <-r.hits:
+			r.mu.Lock()
+			r.add(hit)
+			r.mu.Unlock()
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				log.Printf("Failed to record download statistics: %v", err)
+			}
+		case <-ctx.Done():
+			if err := r.Flush(context.WithoutCancel(ctx)); err != nil {
+				log.Printf("Failed to record download statistics: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// Flush adds the downloads counted so far to the store. They are kept for
+// the next flush if the store fails.
+func (r *Recorder) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for drained := false; !drained; {
+		select {
+		case hit := <-r.hits:
+			r.add(hit)
+		default:
+			drained = true
+		}
+	}
+	if len(r.pending) == 0 {
+		return nil
+	}
+
+	entries := make([]Stats, 0, len(r.pending))
+	for _, entry := range r.pending {
+		entries = append(entries, *entry)
+	}
+	slices.SortFunc(entries, func(a, b Stats) int { return strings.Compare(a.Path, b.Path) })
+	if err := r.store.Add(ctx, entries); err != nil {
+		return err
+	}
+	clear(r.pending)
+	return nil
+}
+
+// add sums a download into the pending totals; callers hold the lock
+func (r *Recorder) add(hit Stats) {
+	entry, ok := r.pending[hit.Path]
+	if !ok {
+		r.pending[hit.Path] = &hit
+		return
+	}
+	entry.Downloads += hit.Downloads
+	entry.Bytes += hit.Bytes
+	if hit.LastAccess.After(entry.LastAccess) {
+		entry.LastAccess = hit.LastAccess
+	}
+}
+
+// Get returns the totals of path, with no downloads if it was never downloaded
+func (r *Recorder) Get(ctx context.Context, path string) (*Stats, error) {
+	stats, err := r.store.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if stats == nil {
+		return &Stats{Path: path}, nil
+	}
+	return stats, nil
+}
+
+// List returns the totals selected by query
+func (r *Recorder) List(ctx context.Context, query Query) ([]Stats, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	return r.store.List(ctx, query)
+}
+
+// Publish drops the statistics of deleted objects, so they don't show up as
+// cold objects. Moved objects start over at their new path.
+func (r *Recorder) Publish(ctx context.Context, event events.Event) error {
+	if event.Type != events.ObjectDeleted {
+		return nil
+	}
+	r.mu.Lock()
+	delete(r.pending, event.Path)
+	r.mu.Unlock()
+	return r.store.Delete(ctx, event.Path)
+}
+
+// MemoryStore keeps the totals in memory, so they are lost on restart and
+// each replica only counts its own downloads
+type MemoryStore struct {
+	mu    sync.Mutex
+	stats map[string]Stats
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		stats: make(map[string]Stats),
+	}
+}
+
+func (s *MemoryStore) Add(ctx context.Context, entries []Stats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range entries {
+		total := s.stats[entry.Path]
+		total.Path = entry.Path
+		total.Downloads += entry.Downloads
+		total.Bytes += entry.Bytes
+		if entry.LastAccess.After(total.LastAccess) {
+			total.LastAccess = entry.LastAccess
+		}
+		s.stats[entry.Path] = total
+	}
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, path string) (*Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats, ok := s.stats[path]
+	if !ok {
+		return nil, nil
+	}
+	return &stats, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, query Query) ([]Stats, error) {
+	s.mu.Lock()
+	list := make([]Stats, 0)
+	for path, stats := range s.stats {
+		if strings.HasPrefix(path, query.Prefix) {
+			list = append(list, stats)
+		}
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(list, func(a, b Stats) int {
+		var c int
+		switch query.Sort {
+		case SortBytes:
+			c = cmp.Compare(a.Bytes, b.Bytes)
+		case SortLastAccess:
+			c = a.LastAccess.Compare(b.LastAccess)
+		default:
+			c = cmp.Compare(a.Downloads, b.Downloads)
+		}
+		if !query.Ascending {
+			c = -c
+		}
+		if c == 0 {
+			c = strings.Compare(a.Path, b.Path)
+		}
+		return c
+	})
+	if query.Limit > 0 && len(list) > query.Limit {
+		list = list[:query.Limit]
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.stats, path)
+	return nil
+}
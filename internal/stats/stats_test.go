@@ -0,0 +1,112 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gcp-proxy-mity/internal/events"
+)
+
+func paths(list []Stats) []string {
+	out := make([]string, 0, len(list))
+	for _, entry := range list {
+		out = append(out, entry.Path)
+	}
+	return out
+}
+
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+	recorder := NewRecorder(NewMemoryStore(), 0)
+	recorder.Record("videos/a.mp4", 100)
+	recorder.Record("videos/a.mp4", 50)
+	recorder.Record("videos/b.mp4", 10)
+	recorder.Record("photos/c.jpg", 5)
+
+	// Nothing reaches the store before a flush
+	if got, _ := recorder.Get(ctx, "videos/a.mp4"); got.Downloads != 0 {
+		t.Errorf("Expected no downloads before flushing, got %+v", got)
+	}
+	if err := recorder.Flush(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	recorder.Record("videos/b.mp4", 10)
+	recorder.Record("videos/b.mp4", 10)
+	if err := recorder.Flush(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	a, err := recorder.Get(ctx, "videos/a.mp4")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if a.Downloads != 2 || a.Bytes != 150 || a.LastAccess.IsZero() {
+		t.Errorf("Expected 2 downloads of 150 bytes, got %+v", a)
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{name: "most downloaded", query: Query{Sort: SortDownloads}, want: []string{"videos/b.mp4", "videos/a.mp4", "photos/c.jpg"}},
+		{name: "least downloaded", query: Query{Sort: SortDownloads, Ascending: true}, want: []string{"photos/c.jpg", "videos/a.mp4", "videos/b.mp4"}},
+		{name: "most bytes", query: Query{Sort: SortBytes, Limit: 1}, want: []string{"videos/a.mp4"}},
+		{name: "prefix", query: Query{Prefix: "videos/", Sort: SortBytes}, want: []string{"videos/a.mp4", "videos/b.mp4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := recorder.List(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := paths(list); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := recorder.List(ctx, Query{Sort: "size"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Expected ErrInvalidQuery, got %v", err)
+	}
+
+	if err := recorder.Publish(ctx, events.Event{Type: events.ObjectDeleted, Path: "videos/a.mp4"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, _ := recorder.Get(ctx, "videos/a.mp4"); got.Downloads != 0 {
+		t.Errorf("Expected the statistics of deleted objects to be dropped, got %+v", got)
+	}
+}
+
+type flakyStore struct {
+	*MemoryStore
+	fail bool
+}
+
+func (s *flakyStore) Add(ctx context.Context, entries []Stats) error {
+	if s.fail {
+		return errors.New("unavailable")
+	}
+	return s.MemoryStore.Add(ctx, entries)
+}
+
+func TestRecorder_FlushFailure(t *testing.T) {
+	ctx := context.Background()
+	store := &flakyStore{MemoryStore: NewMemoryStore(), fail: true}
+	recorder := NewRecorder(store, 0)
+	recorder.Record("a.mp4", 10)
+
+	if err := recorder.Flush(ctx); err == nil {
+		t.Fatal("Expected the store's error")
+	}
+	store.fail = false
+	if err := recorder.Flush(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got, _ := recorder.Get(ctx, "a.mp4"); got.Downloads != 1 || got.Bytes != 10 {
+		t.Errorf("Expected the download to be kept for the next flush, got %+v", got)
+	}
+}
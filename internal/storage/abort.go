@@ -0,0 +1,17 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// aborted marks the error of a write whose caller went away, like a client
+// that disconnected mid-upload, with ErrAborted. The context of the write is
+// canceled then, so nothing was stored.
+func aborted(ctx context.Context, err error) error {
+	if ctx.Err() == nil || errors.Is(err, ErrAborted) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrAborted, err)
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// BucketInfo describes a bucket's configuration
+type BucketInfo struct {
+	Name                     string            `json:"name"`
+	Location                 string            `json:"location"`
+	LocationType             string            `json:"location_type,omitempty"`
+	StorageClass             string            `json:"storage_class"`
+	Created                  time.Time         `json:"created"`
+	VersioningEnabled        bool              `json:"versioning_enabled"`
+	UniformBucketLevelAccess bool              `json:"uniform_bucket_level_access"`
+	RetentionPeriod          string            `json:"retention_period,omitempty"`
+	Labels                   map[string]string `json:"labels,omitempty"`
+}
+
+// BucketOptions configures a new bucket
+type BucketOptions struct {
+	Location     string            `json:"location"`
+	StorageClass string            `json:"storage_class"`
+	Labels       map[string]string `json:"labels"`
+}
+
+// LifecycleRule deletes objects or changes their storage class once they match all conditions
+type LifecycleRule struct {
+	// Action is "Delete" or "SetStorageClass"
+	Action       string `json:"action"`
+	StorageClass string `json:"storage_class,omitempty"`
+
+	AgeDays               int64    `json:"age_days,omitempty"`
+	DaysSinceCustomTime   int64    `json:"days_since_custom_time,omitempty"`
+	MatchesPrefix         []string `json:"matches_prefix,omitempty"`
+	MatchesSuffix         []string `json:"matches_suffix,omitempty"`
+	MatchesStorageClasses []string `json:"matches_storage_classes,omitempty"`
+}
+
+// BucketAdmin manages buckets in the project
+type BucketAdmin interface {
+	CreateBucket(ctx context.Context, name string, opts BucketOptions) (*BucketInfo, error)
+	ListBuckets(ctx context.Context) ([]BucketInfo, error)
+	GetBucket(ctx context.Context, name string) (*BucketInfo, error)
+	GetLifecycle(ctx context.Context, name string) ([]LifecycleRule, error)
+	SetLifecycle(ctx context.Context, name string, rules []LifecycleRule) ([]LifecycleRule, error)
+}
+
+// Transfer copies objects between named buckets, which may belong to other projects
+type Transfer interface {
+	HasBucket(name string) bool
+	ListFiles(ctx context.Context, bucket, prefix string) ([]FileMetadata, error)
+	CopyFile(ctx context.Context, srcBucket, srcPath, dstBucket, dstPath string) error
+}
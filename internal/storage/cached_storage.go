@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"log"
+
+	"gcp-proxy-mity/internal/cache"
+	"gcp-proxy-mity/internal/metrics"
+)
+
+var (
+	cacheHits          = metrics.NewCounter("gcs_proxy_read_cache_hits_total", "Single file reads served from the read cache")
+	cacheMisses        = metrics.NewCounter("gcs_proxy_read_cache_misses_total", "Single file reads that went to the bucket")
+	cacheInvalidations = metrics.NewCounter("gcs_proxy_read_cache_invalidations_total", "Cache entries dropped because the object changed")
+)
+
+// CachedStorage serves single file reads of small objects from a cache and
+// drops entries when the object is written, copied over or deleted through it.
+// A cached copy is only served while the object still has the generation it
+// was read at, so changes made directly in the bucket are picked up at once.
+// Reads with a customer-supplied encryption key and envelope encrypted objects
+// always bypass the cache.
+type CachedStorage struct {
+	Storage
+	cache          cache.Cache
+	maxObjectBytes int64
+}
+
+// NewCachedStorage wraps next with a read-through cache for objects up to maxObjectBytes
+func NewCachedStorage(next Storage, cache cache.Cache, maxObjectBytes int64) *CachedStorage {
+	return &CachedStorage{
+		Storage:        next,
+		cache:          cache,
+		maxObjectBytes: maxObjectBytes,
+	}
+}
+
+func (s *CachedStorage) ReadFile(ctx context.Context, filePath string) (*FileData, error) {
+	if EncryptionKeyFromContext(ctx) != nil {
+		return s.Storage.ReadFile(ctx, filePath)
+	}
+
+	key := ReadCacheKey(filePath)
+	if value, ok := s.cache.Get(ctx, key); ok {
+		var data FileData
+		if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&data); err == nil && !data.Metadata.Expired() && s.current(ctx, filePath, data.Metadata.Generation) {
+			cacheHits.Inc()
+			if err := decodeFile(ctx, &data); err != nil {
+				return nil, err
+			}
+			return &data, nil
+		}
+		s.cache.Delete(ctx, key)
+	}
+	cacheMisses.Inc()
+
+	// Compressed objects are cached as stored and decompressed for each caller that needs it
+	data, err := s.Storage.ReadFile(WithAcceptedEncodings(ctx, EncodingGzip, EncodingZstd), filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encrypted objects cannot be read without their key, so they never end up here,
+	// and the plaintext of envelope encrypted ones is kept out of a possibly shared cache
+	if !data.Metadata.Envelope && int64(len(data.Content)) <= s.maxObjectBytes {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+			log.Printf("Failed to cache %s: %v", filePath, err)
+		} else {
+			s.cache.Set(ctx, key, buf.Bytes())
+		}
+	}
+	if err := decodeFile(ctx, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *CachedStorage) WriteFiles(ctx context.Context, requests []WriteRequest) (*WriteResponse, error) {
+	defer func() {
+		for _, req := range requests {
+			s.invalidate(ctx, req.Path)
+		}
+	}()
+	return s.Storage.WriteFiles(ctx, requests)
+}
+
+func (s *CachedStorage) CreateFile(ctx context.Context, req WriteRequest) (*FileMetadata, error) {
+	defer s.invalidate(ctx, req.Path)
+	return s.Storage.CreateFile(ctx, req)
+}
+
+func (s *CachedStorage) CopyFile(ctx context.Context, srcPath, dstPath string) error {
+	defer s.invalidate(ctx, dstPath)
+	return s.Storage.CopyFile(ctx, srcPath, dstPath)
+}
+
+func (s *CachedStorage) ComposeFiles(ctx context.Context, dst WriteRequest, srcPaths []string) (*FileMetadata, error) {
+	defer s.invalidate(ctx, dst.Path)
+	return s.Storage.ComposeFiles(ctx, dst, srcPaths)
+}
+
+func (s *CachedStorage) DeleteFile(ctx context.Context, filePath string) error {
+	defer s.invalidate(ctx, filePath)
+	return s.Storage.DeleteFile(ctx, filePath)
+}
+
+func (s *CachedStorage) SetStorageClass(ctx context.Context, filePath, storageClass string) (*FileMetadata, error) {
+	defer s.invalidate(ctx, filePath)
+	return s.Storage.SetStorageClass(ctx, filePath, storageClass)
+}
+
+// current reports whether the object still has the generation of the cached copy
+func (s *CachedStorage) current(ctx context.Context, filePath string, generation int64) bool {
+	file, err := s.Storage.StatFile(ctx, filePath)
+	if err != nil || file.Generation != generation {
+		cacheInvalidations.Inc()
+		return false
+	}
+	return true
+}
+
+// invalidate drops the cached entry. It runs after the change so that a
+// concurrent read cannot put the old content back into the cache.
+func (s *CachedStorage) invalidate(ctx context.Context, filePath string) {
+	cacheInvalidations.Inc()
+	s.cache.Delete(ctx, ReadCacheKey(filePath))
+}
+
+// ReadCacheKey returns the key an object is cached under
+func ReadCacheKey(filePath string) string {
+	return "object:" + filePath
+}
+
+// CacheCounts are the outcomes of the read caches since the server started
+type CacheCounts struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Invalidations int64 `json:"invalidations"`
+}
+
+// ReadCacheCounts returns the outcomes of all read caches together
+func ReadCacheCounts() CacheCounts {
+	return CacheCounts{
+		Hits:          cacheHits.Value(),
+		Misses:        cacheMisses.Value(),
+		Invalidations: cacheInvalidations.Value(),
+	}
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/metrics"
+)
+
+var coalescedReads = metrics.NewCounter("gcs_proxy_read_coalesced_total", "Single file and range reads served by a read of the same object already in progress")
+
+// CoalescedStorage shares a read of an object between the callers asking for
+// the same file or range while it is in progress, so a burst of requests for a
+// newly published object reaches the bucket once. Reads are shared per
+// principal, since impersonated principals may be refused objects others can
+// read. Callers arriving after a write through it start a new read, and reads
+// with a customer-supplied encryption key are never shared.
+type CoalescedStorage struct {
+	Storage
+
+	mu      sync.Mutex
+	flights map[flightKey]*flight
+}
+
+// flightKey identifies a read; read is "file" or the bounds of a range
+type flightKey struct {
+	principal string
+	path      string
+	read      string
+}
+
+// flight is a read in progress and the callers waiting for it
+type flight struct {
+	done    chan struct{}
+	val     any
+	err     error
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// NewCoalescedStorage wraps next, sharing concurrent identical reads
+func NewCoalescedStorage(next Storage) *CoalescedStorage {
+	return &CoalescedStorage{
+		Storage: next,
+		flights: make(map[flightKey]*flight),
+	}
+}
+
+func (s *CoalescedStorage) ReadFile(ctx context.Context, filePath string) (*FileData, error) {
+	if EncryptionKeyFromContext(ctx) != nil {
+		return s.Storage.ReadFile(ctx, filePath)
+	}
+	val, err := s.do(ctx, filePath, "file", func(ctx context.Context) (any, error) {
+		// Compressed objects are shared as stored and decompressed for each caller that needs it
+		return s.Storage.ReadFile(WithAcceptedEncodings(ctx, EncodingGzip, EncodingZstd), filePath)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Callers get their own copy of the metadata; the content is shared and never modified
+	file := *val.(*FileData)
+	if err := decodeFile(ctx, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// ReadRanges shares reads of a single range, like those of Range requests;
+// batches are read as usual
+func (s *CoalescedStorage) ReadRanges(ctx context.Context, ranges []ReadRange) (*ReadResponse, error) {
+	if len(ranges) != 1 || EncryptionKeyFromContext(ctx) != nil {
+		return s.Storage.ReadRanges(ctx, ranges)
+	}
+	r := ranges[0]
+	val, err := s.do(ctx, r.Path, fmt.Sprintf("range:%d:%d", r.Offset, r.Length), func(ctx context.Context) (any, error) {
+		return s.Storage.ReadRanges(ctx, ranges)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := *val.(*ReadResponse)
+	resp.Files = slices.Clone(resp.Files)
+	resp.Errors = slices.Clone(resp.Errors)
+	return &resp, nil
+}
+
+// do runs read, or waits for the same read already in progress. The read
+// carries on when the caller that started it gives up, as long as others wait
+// for it, and is canceled once nobody does.
+func (s *CoalescedStorage) do(ctx context.Context, filePath, read string, fn func(context.Context) (any, error)) (any, error) {
+	key := flightKey{principal: auth.PrincipalFromContext(ctx), path: filePath, read: read}
+
+	s.mu.Lock()
+	f, ok := s.flights[key]
+	if ok {
+		coalescedReads.Inc()
+	} else {
+		flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		s.flights[key] = f
+		go s.run(flightCtx, key, f, fn)
+	}
+	f.waiters++
+	s.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if f.waiters--; f.waiters == 0 {
+			f.cancel()
+			s.forget(key, f)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (s *CoalescedStorage) run(ctx context.Context, key flightKey, f *flight, fn func(context.Context) (any, error)) {
+	defer f.cancel()
+	f.val, f.err = fn(ctx)
+
+	s.mu.Lock()
+	s.forget(key, f)
+	s.mu.Unlock()
+	close(f.done)
+}
+
+// forget drops f unless it was replaced already; callers hold the lock
+func (s *CoalescedStorage) forget(key flightKey, f *flight) {
+	if s.flights[key] == f {
+		delete(s.flights, key)
+	}
+}
+
+func (s *CoalescedStorage) WriteFiles(ctx context.Context, requests []WriteRequest) (*WriteResponse, error) {
+	defer func() {
+		for _, req := range requests {
+			s.invalidate(req.Path)
+		}
+	}()
+	return s.Storage.WriteFiles(ctx, requests)
+}
+
+func (s *CoalescedStorage) CreateFile(ctx context.Context, req WriteRequest) (*FileMetadata, error) {
+	defer s.invalidate(req.Path)
+	return s.Storage.CreateFile(ctx, req)
+}
+
+func (s *CoalescedStorage) CopyFile(ctx context.Context, srcPath, dstPath string) error {
+	defer s.invalidate(dstPath)
+	return s.Storage.CopyFile(ctx, srcPath, dstPath)
+}
+
+func (s *CoalescedStorage) ComposeFiles(ctx context.Context, dst WriteRequest, srcPaths []string) (*FileMetadata, error) {
+	defer s.invalidate(dst.Path)
+	return s.Storage.ComposeFiles(ctx, dst, srcPaths)
+}
+
+func (s *CoalescedStorage) DeleteFile(ctx context.Context, filePath string) error {
+	defer s.invalidate(filePath)
+	return s.Storage.DeleteFile(ctx, filePath)
+}
+
+func (s *CoalescedStorage) SetStorageClass(ctx context.Context, filePath, storageClass string) (*FileMetadata, error) {
+	defer s.invalidate(filePath)
+	return s.Storage.SetStorageClass(ctx, filePath, storageClass)
+}
+
+// invalidate makes later reads of filePath start anew. Reads in progress still
+// answer the callers waiting for them, who asked before the change was done.
+func (s *CoalescedStorage) invalidate(filePath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.flights {
+		if key.path == filePath {
+			delete(s.flights, key)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"github.com/klauspost/compress/zstd"
+
+	"gcp-proxy-mity/internal/bufpool"
+)
+
+// Encodings objects can be stored compressed with
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// UncompressedSizeKey is the custom metadata key recording the size of a
+// compressed object's content before compression
+const UncompressedSizeKey = "uncompressed-size"
+
+// zstdDecoder decodes whole objects; DecodeAll is safe for concurrent use
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+
+type acceptedEncodingsContextKey struct{}
+
+// WithAcceptedEncodings attaches the encodings the caller can decode to the
+// context. Objects stored with one of them are read as stored, with
+// FileData.ContentEncoding set, instead of being decompressed.
+func WithAcceptedEncodings(ctx context.Context, encodings ...string) context.Context {
+	return context.WithValue(ctx, acceptedEncodingsContextKey{}, encodings)
+}
+
+// acceptsEncoding reports whether the caller can decode content in encoding
+func acceptsEncoding(ctx context.Context, encoding string) bool {
+	encodings, _ := ctx.Value(acceptedEncodingsContextKey{}).([]string)
+	return slices.Contains(encodings, encoding)
+}
+
+// ValidEncoding reports whether objects can be stored compressed with encoding
+func ValidEncoding(encoding string) bool {
+	return encoding == EncodingGzip || encoding == EncodingZstd
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// compressor returns a writer compressing into w; closing it flushes the
+// compressed stream but leaves w open
+func compressor(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case "":
+		return nopWriteCloser{w}, nil
+	case EncodingGzip:
+		return gzip.NewWriter(w), nil
+	case EncodingZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+// copyContent copies src into w, which compresses or encrypts it, closes w to
+// flush it and returns the number of bytes read from src
+func copyContent(w io.WriteCloser, src io.Reader) (int64, error) {
+	written, err := bufpool.Copy(w, src)
+	if err != nil {
+		return written, err
+	}
+	return written, w.Close()
+}
+
+// decompress decodes content stored with encoding
+func decompress(encoding string, content []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress content: %w", err)
+		}
+		defer reader.Close()
+		decoded, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress content: %w", err)
+		}
+		return decoded, nil
+	case EncodingZstd:
+		decoded, err := zstdDecoder.DecodeAll(content, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress content: %w", err)
+		}
+		return decoded, nil
+	}
+	return content, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// decompressor returns a reader decoding the content r reads, stored with
+// encoding. Closing it closes r.
+func decompressor(r io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case EncodingGzip:
+		decoder, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress content: %w", err)
+		}
+		return readCloser{decoder, r}, nil
+	case EncodingZstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress content: %w", err)
+		}
+		return readCloser{decoder, closerFunc(func() error {
+			decoder.Close()
+			return r.Close()
+		})}, nil
+	}
+	return r, nil
+}
+
+// decodeFile decompresses the content of file unless the context accepts its
+// encoding. Content in encodings the proxy doesn't know is left as stored.
+func decodeFile(ctx context.Context, file *FileData) error {
+	if !ValidEncoding(file.ContentEncoding) || acceptsEncoding(ctx, file.ContentEncoding) {
+		return nil
+	}
+	content, err := decompress(file.ContentEncoding, file.Content)
+	if err != nil {
+		return err
+	}
+	file.Content = content
+	file.Metadata.Size = int64(len(content))
+	file.ContentEncoding = ""
+	return nil
+}
+
+// recordUncompressedSize stores the size of an object's content before
+// compression with its custom metadata, once the object is written
+func recordUncompressedSize(ctx context.Context, obj *storage.ObjectHandle, metadata map[string]string, size int64) (*storage.ObjectAttrs, error) {
+	merged := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	merged[UncompressedSizeKey] = strconv.FormatInt(size, 10)
+	attrs, err := obj.Update(ctx, storage.ObjectAttrsToUpdate{Metadata: merged})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record uncompressed size: %w", err)
+	}
+	return attrs, nil
+}
+
+// composedEncoding returns the encoding of compressed sources of a compose,
+// given the attributes of the first and the paths of the others, and the sum
+// of their sizes before compression, or -1 if one wasn't recorded.
+// Concatenated gzip members and zstd frames decode as a single stream, so
+// compressed sources compose as long as they share an encoding. The others are
+// only looked at when the first is compressed.
+func composedEncoding(ctx context.Context, bucket *storage.BucketHandle, first *storage.ObjectAttrs, others []string) (string, int64, error) {
+	encoding := first.ContentEncoding
+	if !ValidEncoding(encoding) {
+		return "", -1, nil
+	}
+	total, ok := uncompressedSize(first)
+	if !ok {
+		total = -1
+	}
+	for _, path := range others {
+		attrs, err := object(ctx, bucket, path).Attrs(ctx)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to get object attributes: %w", err)
+		}
+		if attrs.ContentEncoding != encoding {
+			return "", 0, fmt.Errorf("%w: %s is stored with %q, %s with %q", ErrMixedEncodings, first.Name, encoding, path, attrs.ContentEncoding)
+		}
+		size, ok := uncompressedSize(attrs)
+		if !ok || total < 0 {
+			total = -1
+			continue
+		}
+		total += size
+	}
+	return encoding, total, nil
+}
+
+// uncompressedSize returns the size of a compressed object's content before
+// compression, when it was recorded
+func uncompressedSize(attrs *storage.ObjectAttrs) (int64, bool) {
+	if !ValidEncoding(attrs.ContentEncoding) {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(attrs.Metadata[UncompressedSizeKey], 10, 64)
+	return size, err == nil
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// mediaTypes maps common media file extensions to MIME types
+var mediaTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".avi":  "video/x-msvideo",
+	".webm": "video/webm",
+	".heim": "image/heic",
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".bmp":  "image/bmp",
+}
+
+// DetectContentType detects content type from file extension
+func DetectContentType(filePath string) string {
+	ext := strings.ToLower(filepath.Ext(filePath))
+
+	if mimeType, ok := mediaTypes[ext]; ok {
+		return mimeType
+	}
+
+	// Try system MIME type detection
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return mimeType
+	}
+
+	// Default to binary if unknown
+	return "application/octet-stream"
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+type encryptionKeyContextKey struct{}
+
+// WithEncryptionKey attaches a customer-supplied AES-256 key (CSEK) to the context.
+// Objects written and read with this context are encrypted with the key.
+func WithEncryptionKey(ctx context.Context, key []byte) context.Context {
+	return context.WithValue(ctx, encryptionKeyContextKey{}, key)
+}
+
+// EncryptionKeyFromContext returns the customer-supplied key, if any
+func EncryptionKeyFromContext(ctx context.Context) []byte {
+	key, _ := ctx.Value(encryptionKeyContextKey{}).([]byte)
+	return key
+}
+
+// ParseEncryptionKey decodes a base64 AES-256 key and, when given, checks it
+// against its base64 SHA-256 hash
+func ParseEncryptionKey(encoded, encodedHash string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%w: key must be 32 bytes, base64 encoded", ErrInvalidEncryptionKey)
+	}
+	if encodedHash != "" {
+		sum := sha256.Sum256(key)
+		expected := base64.StdEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(encodedHash)) != 1 {
+			return nil, fmt.Errorf("%w: key does not match its SHA-256 hash", ErrInvalidEncryptionKey)
+		}
+	}
+	return key, nil
+}
+
+// object returns a handle for the path, using the context's encryption key if set
+func object(ctx context.Context, bucket *storage.BucketHandle, path string) *storage.ObjectHandle {
+	obj := bucket.Object(path)
+	if key := EncryptionKeyFromContext(ctx); key != nil {
+		obj = obj.Key(key)
+	}
+	return obj
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"maps"
+
+	"cloud.google.com/go/storage"
+
+	"gcp-proxy-mity/internal/bufpool"
+	"gcp-proxy-mity/internal/envelope"
+)
+
+// Custom metadata keys of objects encrypted by the proxy, holding the wrapped
+// data key and the ID of the key that wrapped it
+const (
+	EnvelopeKeyKey   = "envelope-key"
+	EnvelopeKeyIDKey = "envelope-key-id"
+)
+
+// SetKeyring enables envelope encryption of writes asking for it, and
+// decryption of objects written that way
+func (s *GCSStorage) SetKeyring(keyring *envelope.Keyring) {
+	s.keyring = keyring
+}
+
+// isEnvelope reports whether an object was encrypted by the proxy
+func isEnvelope(attrs *storage.ObjectAttrs) bool {
+	return attrs.Metadata[EnvelopeKeyKey] != ""
+}
+
+// contentWriter prepares writer for the content of req and returns the writer
+// to copy the content into, which envelope encrypts or compresses it on the
+// way. Encrypted content doesn't compress, so it is never both.
+func (s *GCSStorage) contentWriter(ctx context.Context, writer *storage.Writer, req WriteRequest) (io.WriteCloser, error) {
+	if !req.Envelope {
+		writer.ContentEncoding = req.ContentEncoding
+		return compressor(writer, req.ContentEncoding)
+	}
+	if s.keyring == nil {
+		return nil, ErrEnvelopeDisabled
+	}
+	dataKey, wrapped, keyID, err := s.keyring.NewDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	writer.ContentEncoding = ""
+	writer.Metadata = maps.Clone(writer.Metadata)
+	if writer.Metadata == nil {
+		writer.Metadata = make(map[string]string, 2)
+	}
+	writer.Metadata[EnvelopeKeyKey] = base64.StdEncoding.EncodeToString(wrapped)
+	writer.Metadata[EnvelopeKeyIDKey] = keyID
+	return envelope.NewWriter(writer, dataKey)
+}
+
+// dataKey unwraps the data key of an object encrypted by the proxy
+func (s *GCSStorage) dataKey(ctx context.Context, attrs *storage.ObjectAttrs) ([]byte, error) {
+	if s.keyring == nil {
+		return nil, fmt.Errorf("%w: %s is envelope encrypted", ErrEnvelopeDisabled, attrs.Name)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(attrs.Metadata[EnvelopeKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed data key of %s", envelope.ErrDecrypt, attrs.Name)
+	}
+	return s.keyring.DataKey(ctx, wrapped, attrs.Metadata[EnvelopeKeyIDKey])
+}
+
+// openEnvelope decrypts the whole content of an object encrypted by the proxy
+func (s *GCSStorage) openEnvelope(ctx context.Context, attrs *storage.ObjectAttrs, content []byte) ([]byte, error) {
+	key, err := s.dataKey(ctx, attrs)
+	if err != nil {
+		return nil, err
+	}
+	return envelope.Open(key, content)
+}
+
+// readEnvelopeRange reads a range of an object encrypted by the proxy, fetching
+// only the segments holding it
+func (s *GCSStorage) readEnvelopeRange(ctx context.Context, obj *storage.ObjectHandle, attrs *storage.ObjectAttrs, r ReadRange) (*FileData, error) {
+	size := envelope.PlaintextSize(attrs.Size)
+	if r.Offset > size {
+		return nil, fmt.Errorf("%w: offset %d, size %d", ErrInvalidRange, r.Offset, size)
+	}
+	offset, length := r.Bounds(size)
+	metadata := fileMetadata(attrs)
+	if length == 0 {
+		return &FileData{Metadata: metadata, Content: []byte{}, Offset: offset}, nil
+	}
+
+	key, err := s.dataKey(ctx, attrs)
+	if err != nil {
+		return nil, err
+	}
+	start, end, first := envelope.CiphertextRange(offset, length, attrs.Size)
+	reader, err := obj.Generation(attrs.Generation).ReadCompressed(true).NewRangeReader(ctx, start, end-start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
+	defer reader.Close()
+	// The ciphertext is only needed until it is opened into a new plaintext
+	ciphertext, err := bufpool.ReadPooled(reader, end-start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read content: %w", err)
+	}
+	defer bufpool.Put(ciphertext)
+
+	plaintext, err := envelope.OpenSegments(key, ciphertext, first, end == attrs.Size)
+	if err != nil {
+		return nil, err
+	}
+	skip := offset - int64(first)*envelope.SegmentSize
+	return &FileData{
+		Metadata: metadata,
+		Content:  plaintext[skip : skip+length],
+		Offset:   offset,
+	}, nil
+}
+
+// sourceReader opens an object for composing through the proxy, decrypting
+// and decompressing it on the way
+func (s *GCSStorage) sourceReader(ctx context.Context, obj *storage.ObjectHandle, attrs *storage.ObjectAttrs) (io.ReadCloser, error) {
+	reader, err := obj.Generation(attrs.Generation).ReadCompressed(true).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
+	if isEnvelope(attrs) {
+		key, err := s.dataKey(ctx, attrs)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		plaintext, err := envelope.NewReader(reader, key)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		return readCloser{plaintext, reader}, nil
+	}
+	if ValidEncoding(attrs.ContentEncoding) {
+		decoded, err := decompressor(reader, attrs.ContentEncoding)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		return decoded, nil
+	}
+	return reader, nil
+}
+
+// composeThrough concatenates the sources by copying them through the proxy,
+// which encrypted content needs since each object has its own data key
+func (s *GCSStorage) composeThrough(ctx context.Context, bucket *storage.BucketHandle, dst WriteRequest, srcPaths []string) (*FileMetadata, error) {
+	obj := object(ctx, bucket, dst.Path)
+	// Canceling the writer's context discards a partial upload
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writer := obj.NewWriter(writeCtx)
+	writer.ContentType = dst.ContentType
+	writer.Metadata = dst.Metadata
+	writer.KMSKeyName = dst.KMSKeyName
+	writer.StorageClass = dst.StorageClass
+	writer.CacheControl = dst.CacheControl
+	setExpiry(&writer.ObjectAttrs, dst.ExpiresAt)
+	dst.ContentEncoding = ""
+	content, err := s.contentWriter(ctx, writer, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	var written int64
+	for _, path := range srcPaths {
+		n, err := s.copySource(ctx, content, object(ctx, bucket, path))
+		written += n
+		if err != nil {
+			cancel()
+			writer.Close()
+			return nil, err
+		}
+	}
+	if err := content.Close(); err != nil {
+		cancel()
+		writer.Close()
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compose object: %w", err)
+	}
+
+	file := fileMetadata(writer.Attrs())
+	file.Size = written
+	return &file, nil
+}
+
+// copySource copies the content of a compose source into w
+func (s *GCSStorage) copySource(ctx context.Context, w io.Writer, obj *storage.ObjectHandle) (int64, error) {
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get object attributes: %w", err)
+	}
+	reader, err := s.sourceReader(ctx, obj, attrs)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	n, err := bufpool.Copy(w, reader)
+	if err != nil {
+		return n, fmt.Errorf("failed to copy %s: %w", attrs.Name, err)
+	}
+	return n, nil
+}
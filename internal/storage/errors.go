@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"errors"
+
+	"cloud.google.com/go/storage"
+)
+
+var (
+	ErrInvalidEncryptionKey = errors.New("invalid encryption key")
+	ErrUnknownBucket        = errors.New("unknown bucket")
+	ErrInvalidRange         = errors.New("range starts beyond the end of the object")
+	ErrExists               = errors.New("object already exists")
+	ErrUniformAccess        = errors.New("the bucket uses uniform bucket-level access, so objects can't be made public one by one")
+	ErrMixedEncodings       = errors.New("sources are stored with different content encodings")
+	ErrEnvelopeDisabled     = errors.New("envelope encryption is not configured")
+	ErrChecksumMismatch     = errors.New("content read doesn't match the object's checksum")
+	ErrUnsupported          = errors.New("not supported by the storage backend")
+	ErrInvalidPath          = errors.New("invalid object path")
+	ErrAborted              = errors.New("write aborted before the object was stored")
+	// ErrNotFound is wrapped in errors for objects that do not exist
+	ErrNotFound = storage.ErrObjectNotExist
+)
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"fmt"
+	"maps"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// ExpiresAtKey is the custom metadata key holding when an object expires, in RFC 3339
+const ExpiresAtKey = "expires-at"
+
+// Expired reports whether the file's expiry has passed
+func (f FileMetadata) Expired() bool {
+	return !f.ExpiresAt.IsZero() && !time.Now().Before(f.ExpiresAt)
+}
+
+// setExpiry records when an object written with attrs expires in its custom
+// metadata, and as its Custom-Time so bucket lifecycle rules can delete it too
+func setExpiry(attrs *storage.ObjectAttrs, expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		return
+	}
+	attrs.Metadata = maps.Clone(attrs.Metadata)
+	if attrs.Metadata == nil {
+		attrs.Metadata = make(map[string]string, 1)
+	}
+	attrs.Metadata[ExpiresAtKey] = expiresAt.UTC().Format(time.RFC3339)
+	attrs.CustomTime = expiresAt
+}
+
+// expiresAt returns when an object expires, or the zero time if it doesn't
+func expiresAt(attrs *storage.ObjectAttrs) time.Time {
+	t, _ := time.Parse(time.RFC3339, attrs.Metadata[ExpiresAtKey])
+	return t
+}
+
+// checkExpiry reports expired objects as not found, since they are only
+// waiting to be deleted
+func checkExpiry(attrs *storage.ObjectAttrs) error {
+	if t := expiresAt(attrs); !t.IsZero() && !time.Now().Before(t) {
+		return fmt.Errorf("%w: %s expired at %s", ErrNotFound, attrs.Name, t.Format(time.RFC3339))
+	}
+	return nil
+}
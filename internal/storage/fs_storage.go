@@ -0,0 +1,532 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"mime"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/bufpool"
+)
+
+// fsStateDir holds the attributes of the objects and the files being written,
+// so that neither shows up in listings
+const fsStateDir = ".fsstorage"
+
+// FSStorage keeps objects as files under a directory, e.g. scratch data that
+// needn't go to a bucket. The content type, cache control, custom metadata and
+// MD5 of each object are kept in a JSON file beside the tree. Objects are
+// stored as written, neither compressed nor encrypted. Storage classes, holds,
+// public access, signed URLs and customer-supplied encryption keys are GCS
+// features and fail with ErrUnsupported.
+type FSStorage struct {
+	dir string
+	// mu keeps reads from seeing the content of one write with the attributes of another
+	mu sync.RWMutex
+}
+
+// fsAttrs is what the attributes file of an object holds
+type fsAttrs struct {
+	ContentType  string            `json:"content_type,omitempty"`
+	CacheControl string            `json:"cache_control,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	MD5          string            `json:"md5,omitempty"`
+	// Generation is the modification time of the file MD5 was taken of, so
+	// the MD5 of a file changed by hand isn't trusted
+	Generation int64 `json:"generation,omitempty"`
+}
+
+// NewFSStorage creates a storage keeping the object at a path in {dir}/{path}
+func NewFSStorage(dir string) *FSStorage {
+	return &FSStorage{dir: dir}
+}
+
+func (s *FSStorage) WriteFiles(ctx context.Context, requests []WriteRequest) (*WriteResponse, error) {
+	response := &WriteResponse{
+		FilesWritten: make([]FileMetadata, 0),
+		Errors:       make([]WriteError, 0),
+	}
+	for _, req := range requests {
+		file, err := s.write(ctx, req, false)
+		if err != nil {
+			response.Errors = append(response.Errors, WriteError{FilePath: req.Path, Error: err.Error()})
+			continue
+		}
+		response.FilesWritten = append(response.FilesWritten, *file)
+	}
+	return response, nil
+}
+
+func (s *FSStorage) CreateFile(ctx context.Context, req WriteRequest) (*FileMetadata, error) {
+	return s.write(ctx, req, true)
+}
+
+func (s *FSStorage) ReadFiles(ctx context.Context, filePaths []string) (*ReadResponse, error) {
+	response := &ReadResponse{
+		Files:  make([]FileData, 0, len(filePaths)),
+		Errors: make([]ReadError, 0),
+	}
+	for _, filePath := range filePaths {
+		file, err := s.ReadFile(ctx, filePath)
+		if err != nil {
+			response.Errors = append(response.Errors, ReadError{FilePath: filePath, Error: err.Error()})
+			continue
+		}
+		response.Files = append(response.Files, *file)
+	}
+	return response, nil
+}
+
+func (s *FSStorage) ReadFile(ctx context.Context, filePath string) (*FileData, error) {
+	return s.readRange(ctx, ReadRange{Path: filePath})
+}
+
+func (s *FSStorage) ReadRanges(ctx context.Context, ranges []ReadRange) (*ReadResponse, error) {
+	response := &ReadResponse{
+		Files:  make([]FileData, 0, len(ranges)),
+		Errors: make([]ReadError, 0),
+	}
+	for _, r := range ranges {
+		file, err := s.readRange(ctx, r)
+		if err != nil {
+			response.Errors = append(response.Errors, ReadError{FilePath: r.Path, Error: err.Error()})
+			continue
+		}
+		response.Files = append(response.Files, *file)
+	}
+	return response, nil
+}
+
+func (s *FSStorage) StatFile(ctx context.Context, filePath string) (*FileMetadata, error) {
+	name, err := s.file(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	file, _, err := s.stat(filePath, name)
+	return file, err
+}
+
+func (s *FSStorage) ListFiles(ctx context.Context, prefix string) ([]FileMetadata, error) {
+	files := make([]FileMetadata, 0)
+	err := s.WalkFiles(ctx, prefix, func(file FileMetadata) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (s *FSStorage) ListNames(ctx context.Context, prefix string) ([]string, error) {
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+	if dir != "" && !filepath.IsLocal(filepath.FromSlash(dir)) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPath, prefix)
+	}
+
+	names := make([]string, 0)
+	err := filepath.WalkDir(filepath.Join(s.dir, filepath.FromSlash(dir)), func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if entry.IsDir() {
+			if name == fsStateDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	// Walking orders names by directory, which isn't their lexical order
+	slices.Sort(names)
+	return names, nil
+}
+
+func (s *FSStorage) WalkFiles(ctx context.Context, prefix string, fn func(FileMetadata) error) error {
+	names, err := s.ListNames(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, filePath := range names {
+		s.mu.RLock()
+		file, _, err := s.stat(filePath, filepath.Join(s.dir, filepath.FromSlash(filePath)))
+		s.mu.RUnlock()
+		// Deleted since it was listed
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(*file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FSStorage) CopyFile(ctx context.Context, srcPath, dstPath string) error {
+	name, err := s.file(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	file, attrs, err := s.stat(srcPath, name)
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(name)
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = s.write(ctx, WriteRequest{
+		Path:         dstPath,
+		Content:      f,
+		ContentType:  file.ContentType,
+		CacheControl: attrs.CacheControl,
+		Metadata:     attrs.Metadata,
+	}, false)
+	return err
+}
+
+func (s *FSStorage) ComposeFiles(ctx context.Context, dst WriteRequest, srcPaths []string) (*FileMetadata, error) {
+	sources := make([]io.Reader, 0, len(srcPaths))
+	for _, srcPath := range srcPaths {
+		name, err := s.file(ctx, srcPath)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, srcPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sources = append(sources, f)
+	}
+	dst.Content = io.MultiReader(sources...)
+	return s.write(ctx, dst, false)
+}
+
+func (s *FSStorage) DeleteFile(ctx context.Context, filePath string) error {
+	name, err := s.file(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(name); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete object: %w: %s", ErrNotFound, filePath)
+	} else if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	if err := os.Remove(s.attrsFile(filePath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FSStorage) SetStorageClass(ctx context.Context, filePath, storageClass string) (*FileMetadata, error) {
+	return nil, fmt.Errorf("%w: storage classes", ErrUnsupported)
+}
+
+// GetRetention reports no holds, since files can't have any
+func (s *FSStorage) GetRetention(ctx context.Context, filePath string) (*Retention, error) {
+	if _, err := s.StatFile(ctx, filePath); err != nil {
+		return nil, err
+	}
+	return &Retention{}, nil
+}
+
+func (s *FSStorage) SetHolds(ctx context.Context, filePath string, update HoldUpdate) (*Retention, error) {
+	return nil, fmt.Errorf("%w: holds", ErrUnsupported)
+}
+
+// GetAccess reports files as private, since only the proxy serves them
+func (s *FSStorage) GetAccess(ctx context.Context, filePath string) (*Access, error) {
+	if _, err := s.StatFile(ctx, filePath); err != nil {
+		return nil, err
+	}
+	return &Access{}, nil
+}
+
+func (s *FSStorage) SetAccess(ctx context.Context, filePath string, public bool) (*Access, error) {
+	return nil, fmt.Errorf("%w: public access", ErrUnsupported)
+}
+
+func (s *FSStorage) GetMetadata(ctx context.Context, filePath string) (map[string]string, error) {
+	name, err := s.file(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, attrs, err := s.stat(filePath, name)
+	if err != nil {
+		return nil, err
+	}
+	return attrs.Metadata, nil
+}
+
+// UpdateMetadata sets the given keys like GCS does: an empty value removes a key
+func (s *FSStorage) UpdateMetadata(ctx context.Context, filePath string, metadata map[string]string) error {
+	name, err := s.file(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, attrs, err := s.stat(filePath, name)
+	if err != nil {
+		return err
+	}
+	attrs.Metadata = maps.Clone(attrs.Metadata)
+	if attrs.Metadata == nil {
+		attrs.Metadata = make(map[string]string, len(metadata))
+	}
+	for key, value := range metadata {
+		if value == "" {
+			delete(attrs.Metadata, key)
+		} else {
+			attrs.Metadata[key] = value
+		}
+	}
+	return s.writeAttrs(filePath, attrs)
+}
+
+func (s *FSStorage) SignedUploadURL(ctx context.Context, req WriteRequest, expires time.Time) (string, error) {
+	return "", fmt.Errorf("%w: signed URLs", ErrUnsupported)
+}
+
+func (s *FSStorage) SignedDownloadURL(ctx context.Context, filePath, disposition string, expires time.Time) (string, error) {
+	return "", fmt.Errorf("%w: signed URLs", ErrUnsupported)
+}
+
+// write stores req, failing with ErrExists if exclusive and there is a file at its path
+func (s *FSStorage) write(ctx context.Context, req WriteRequest, exclusive bool) (*FileMetadata, error) {
+	name, err := s.file(ctx, req.Path)
+	if err != nil {
+		return nil, err
+	}
+	if req.Envelope {
+		return nil, fmt.Errorf("%w: envelope encryption", ErrUnsupported)
+	}
+	attrs := fsAttrs{
+		ContentType:  req.ContentType,
+		CacheControl: req.CacheControl,
+		Metadata:     maps.Clone(req.Metadata),
+	}
+	if !req.ExpiresAt.IsZero() {
+		if attrs.Metadata == nil {
+			attrs.Metadata = make(map[string]string, 1)
+		}
+		attrs.Metadata[ExpiresAtKey] = req.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+
+	// The content is written aside and renamed into place, so it is never seen half written
+	tmp, err := s.tempFile()
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	hash := md5.New()
+	writers := []io.Writer{tmp, hash}
+	if report := progressFromContext(ctx); report != nil {
+		writers = append(writers, &progressWriter{report: report})
+	}
+	if req.Content != nil {
+		if _, err := bufpool.Copy(io.MultiWriter(writers...), req.Content); err != nil {
+			tmp.Close()
+			return nil, aborted(ctx, fmt.Errorf("failed to write file: %w", err))
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	// Content read in full may still come from a caller that is gone
+	if err := ctx.Err(); err != nil {
+		return nil, aborted(ctx, err)
+	}
+	attrs.MD5 = hex.EncodeToString(hash.Sum(nil))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(name); exclusive && err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrExists, req.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	attrs.Generation = info.ModTime().UnixNano()
+	if err := s.writeAttrs(req.Path, attrs); err != nil {
+		return nil, err
+	}
+	file := fsMetadata(req.Path, info, attrs)
+	return &file, nil
+}
+
+// readRange reads r, or the whole file for a zero range
+func (s *FSStorage) readRange(ctx context.Context, r ReadRange) (*FileData, error) {
+	name, err := s.file(ctx, r.Path)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	file, _, err := s.stat(r.Path, name)
+	if err != nil {
+		return nil, err
+	}
+	if file.Expired() {
+		return nil, fmt.Errorf("%w: %s expired at %s", ErrNotFound, r.Path, file.ExpiresAt.Format(time.RFC3339))
+	}
+	if r.Offset > file.Size {
+		return nil, fmt.Errorf("%w: offset %d, size %d", ErrInvalidRange, r.Offset, file.Size)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	offset, length := r.Bounds(file.Size)
+	content, err := bufpool.ReadAll(io.NewSectionReader(f, offset, length), length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return &FileData{Metadata: *file, Content: content, Offset: offset}, nil
+}
+
+// stat returns the metadata and attributes of the object at filePath, stored in name
+func (s *FSStorage) stat(filePath, name string) (*FileMetadata, fsAttrs, error) {
+	info, err := os.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && info.IsDir() {
+		return nil, fsAttrs{}, fmt.Errorf("%w: %s", ErrNotFound, filePath)
+	}
+	if err != nil {
+		return nil, fsAttrs{}, err
+	}
+	var attrs fsAttrs
+	data, err := os.ReadFile(s.attrsFile(filePath))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fsAttrs{}, err
+	}
+	// Files put under the directory by other means have no attributes
+	if err == nil {
+		if err := json.Unmarshal(data, &attrs); err != nil {
+			return nil, fsAttrs{}, fmt.Errorf("failed to decode the attributes of %s: %w", filePath, err)
+		}
+	}
+	file := fsMetadata(filePath, info, attrs)
+	return &file, attrs, nil
+}
+
+func fsMetadata(filePath string, info fs.FileInfo, attrs fsAttrs) FileMetadata {
+	file := FileMetadata{
+		Name:        filePath,
+		ContentType: attrs.ContentType,
+		Size:        info.Size(),
+		Generation:  info.ModTime().UnixNano(),
+		Updated:     info.ModTime(),
+	}
+	if file.ContentType == "" {
+		file.ContentType = mime.TypeByExtension(getExtension(filePath))
+	}
+	if attrs.Generation == file.Generation {
+		file.MD5 = attrs.MD5
+	}
+	file.ExpiresAt, _ = time.Parse(time.RFC3339, attrs.Metadata[ExpiresAtKey])
+	return file
+}
+
+// writeAttrs replaces the attributes of the object at filePath at once
+func (s *FSStorage) writeAttrs(filePath string, attrs fsAttrs) error {
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	name := s.attrsFile(filePath)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := s.tempFile()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// file returns the file an object is stored in. Paths that would alias
+// another object, like a/./b, or reach outside the directory are refused.
+func (s *FSStorage) file(ctx context.Context, filePath string) (string, error) {
+	if EncryptionKeyFromContext(ctx) != nil {
+		return "", fmt.Errorf("%w: customer-supplied encryption keys", ErrUnsupported)
+	}
+	name := filepath.FromSlash(filePath)
+	if !filepath.IsLocal(name) || filepath.Clean(name) != name || strings.Contains(filePath, "\\") ||
+		filePath == fsStateDir || strings.HasPrefix(filePath, fsStateDir+"/") {
+		return "", fmt.Errorf("%w: %s", ErrInvalidPath, filePath)
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+func (s *FSStorage) attrsFile(filePath string) string {
+	return filepath.Join(s.dir, fsStateDir, "attrs", filepath.FromSlash(filePath)+".json")
+}
+
+// tempFile creates a file to write aside on the directory's file system, so it can be renamed into place
+func (s *FSStorage) tempFile() (*os.File, error) {
+	dir := filepath.Join(s.dir, fsStateDir, "tmp")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, "write-*")
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFSStorage_WriteAndRead(t *testing.T) {
+	s := NewFSStorage(t.TempDir())
+	ctx := context.Background()
+
+	response, err := s.WriteFiles(ctx, []WriteRequest{
+		{Path: "docs/a.txt", Content: strings.NewReader("hello"), ContentType: "text/plain", Metadata: map[string]string{"owner": "ci"}},
+		{Path: "../escape.txt", Content: strings.NewReader("x")},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.FilesWritten) != 1 || response.FilesWritten[0].Size != 5 || response.FilesWritten[0].MD5 != "5d41402abc4b2a76b9719d911017c592" {
+		t.Fatalf("Expected a 5 byte file to be written with its MD5, got %+v", response)
+	}
+	if len(response.Errors) != 1 || !strings.Contains(response.Errors[0].Error, ErrInvalidPath.Error()) {
+		t.Errorf("Expected the path outside the directory to be refused, got %+v", response.Errors)
+	}
+
+	file, err := s.ReadFile(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(file.Content) != "hello" || file.Metadata.ContentType != "text/plain" {
+		t.Errorf("Expected hello as text/plain, got %q as %s", file.Content, file.Metadata.ContentType)
+	}
+
+	if err := s.UpdateMetadata(ctx, "docs/a.txt", map[string]string{"owner": "", "tag": "x"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	metadata, err := s.GetMetadata(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := map[string]string{"tag": "x"}; !reflect.DeepEqual(metadata, want) {
+		t.Errorf("Expected %v, got %v", want, metadata)
+	}
+
+	response, err = s.WriteFiles(ctx, []WriteRequest{{Path: "old.txt", Content: strings.NewReader("x"), ExpiresAt: time.Now().Add(-time.Minute)}})
+	if err != nil || len(response.FilesWritten) != 1 {
+		t.Fatalf("Unexpected error: %v %+v", err, response)
+	}
+	if _, err := s.ReadFile(ctx, "old.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an expired file not to be found, got %v", err)
+	}
+	if _, err := s.SetAccess(ctx, "docs/a.txt", true); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestFSStorage_CreateFile(t *testing.T) {
+	s := NewFSStorage(t.TempDir())
+	ctx := context.Background()
+
+	if _, err := s.CreateFile(ctx, WriteRequest{Path: "logs/0", Content: strings.NewReader("first")}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := s.CreateFile(ctx, WriteRequest{Path: "logs/0", Content: strings.NewReader("second")}); !errors.Is(err, ErrExists) {
+		t.Fatalf("Expected ErrExists, got %v", err)
+	}
+	read, err := s.ReadFile(ctx, "logs/0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(read.Content) != "first" {
+		t.Errorf("Expected the existing file to be kept, got %q", read.Content)
+	}
+}
+
+func TestFSStorage_ReadRanges(t *testing.T) {
+	s := NewFSStorage(t.TempDir())
+	if _, err := s.CreateFile(context.Background(), WriteRequest{Path: "video.mp4", Content: strings.NewReader("0123456789")}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		r          ReadRange
+		wantData   string
+		wantOffset int64
+		wantErr    bool
+	}{
+		{name: "head", r: ReadRange{Path: "video.mp4", Length: 4}, wantData: "0123"},
+		{name: "middle", r: ReadRange{Path: "video.mp4", Offset: 3, Length: 2}, wantData: "34", wantOffset: 3},
+		{name: "tail", r: ReadRange{Path: "video.mp4", Offset: -3}, wantData: "789", wantOffset: 7},
+		{name: "beyond the end", r: ReadRange{Path: "video.mp4", Offset: 20}, wantErr: true},
+		{name: "missing", r: ReadRange{Path: "missing.mp4"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := s.ReadRanges(context.Background(), []ReadRange{tt.r})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				if len(response.Errors) != 1 {
+					t.Errorf("Expected an error, got %+v", response)
+				}
+				return
+			}
+			if len(response.Files) != 1 {
+				t.Fatalf("Expected one file, got %+v", response)
+			}
+			file := response.Files[0]
+			if string(file.Content) != tt.wantData || file.Offset != tt.wantOffset || file.Metadata.Size != 10 {
+				t.Errorf("Expected %q at %d of 10 bytes, got %q at %d of %d", tt.wantData, tt.wantOffset, file.Content, file.Offset, file.Metadata.Size)
+			}
+		})
+	}
+}
+
+func TestFSStorage_Abort(t *testing.T) {
+	s := NewFSStorage(t.TempDir())
+
+	for _, tt := range abortTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			response, err := s.WriteFiles(ctx, []WriteRequest{
+				{Path: "video.mp4", Content: &disconnectingReader{content: strings.NewReader("partial"), cancel: cancel, err: tt.err}},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(response.Errors) != 1 || !strings.HasPrefix(response.Errors[0].Error, ErrAborted.Error()) {
+				t.Errorf("Expected the write to be aborted, got %+v", response)
+			}
+			if _, err := s.StatFile(context.Background(), "video.mp4"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected no file, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFSStorage_ListCopyDelete(t *testing.T) {
+	s := NewFSStorage(t.TempDir())
+	ctx := context.Background()
+	for _, path := range []string{"media/a.jpg", "media/clips/1.mp4", "media.txt", "other/b.jpg"} {
+		if _, err := s.CreateFile(ctx, WriteRequest{Path: path, Content: strings.NewReader("a")}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if err := s.CopyFile(ctx, "media/a.jpg", "media/copy.jpg"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := s.DeleteFile(ctx, "media/clips/1.mp4"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	names, err := s.ListNames(ctx, "media")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"media.txt", "media/a.jpg", "media/copy.jpg"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected %v, got %v", want, names)
+	}
+
+	files, err := s.ListFiles(ctx, "media/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(files) != 2 || files[1].Name != "media/copy.jpg" || files[1].ContentType != "image/jpeg" {
+		t.Errorf("Expected the copy to be listed with its content type, got %+v", files)
+	}
+
+	if err := s.DeleteFile(ctx, "media/clips/1.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting it again, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"gcp-proxy-mity/pkg/storage/gcs"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+type GCSBucketAdmin struct {
+	client *gcs.Client
+}
+
+func NewGCSBucketAdmin(client *gcs.Client) *GCSBucketAdmin {
+	return &GCSBucketAdmin{
+		client: client,
+	}
+}
+
+func (a *GCSBucketAdmin) CreateBucket(ctx context.Context, name string, opts BucketOptions) (*BucketInfo, error) {
+	attrs := &storage.BucketAttrs{
+		Location:     opts.Location,
+		StorageClass: opts.StorageClass,
+		Labels:       opts.Labels,
+		// New buckets are managed through IAM only
+		UniformBucketLevelAccess: storage.UniformBucketLevelAccess{Enabled: true},
+	}
+
+	bucket := a.client.StorageClient().Bucket(name)
+	if err := bucket.Create(ctx, a.client.ProjectID(), attrs); err != nil {
+		return nil, fmt.Errorf("failed to create bucket: %w", err)
+	}
+	return a.GetBucket(ctx, name)
+}
+
+func (a *GCSBucketAdmin) ListBuckets(ctx context.Context) ([]BucketInfo, error) {
+	buckets := make([]BucketInfo, 0)
+
+	it := a.client.StorageClient().Buckets(ctx, a.client.ProjectID())
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list buckets: %w", err)
+		}
+		buckets = append(buckets, *bucketInfo(attrs))
+	}
+
+	return buckets, nil
+}
+
+func (a *GCSBucketAdmin) GetBucket(ctx context.Context, name string) (*BucketInfo, error) {
+	attrs, err := a.client.StorageClient().Bucket(name).Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bucket attributes: %w", err)
+	}
+	return bucketInfo(attrs), nil
+}
+
+func (a *GCSBucketAdmin) GetLifecycle(ctx context.Context, name string) ([]LifecycleRule, error) {
+	attrs, err := a.client.StorageClient().Bucket(name).Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bucket attributes: %w", err)
+	}
+	return lifecycleRules(attrs.Lifecycle), nil
+}
+
+func (a *GCSBucketAdmin) SetLifecycle(ctx context.Context, name string, rules []LifecycleRule) ([]LifecycleRule, error) {
+	lifecycle := storage.Lifecycle{Rules: make([]storage.LifecycleRule, 0, len(rules))}
+	for _, rule := range rules {
+		lifecycle.Rules = append(lifecycle.Rules, storage.LifecycleRule{
+			Action: storage.LifecycleAction{
+				Type:         rule.Action,
+				StorageClass: rule.StorageClass,
+			},
+			Condition: storage.LifecycleCondition{
+				AgeInDays:             rule.AgeDays,
+				DaysSinceCustomTime:   rule.DaysSinceCustomTime,
+				AllObjects:            rule.AgeDays == 0 && rule.DaysSinceCustomTime == 0,
+				MatchesPrefix:         rule.MatchesPrefix,
+				MatchesSuffix:         rule.MatchesSuffix,
+				MatchesStorageClasses: rule.MatchesStorageClasses,
+			},
+		})
+	}
+
+	// An empty rule list removes the lifecycle configuration
+	attrs, err := a.client.StorageClient().Bucket(name).Update(ctx, storage.BucketAttrsToUpdate{Lifecycle: &lifecycle})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update bucket lifecycle: %w", err)
+	}
+	return lifecycleRules(attrs.Lifecycle), nil
+}
+
+func lifecycleRules(lifecycle storage.Lifecycle) []LifecycleRule {
+	rules := make([]LifecycleRule, 0, len(lifecycle.Rules))
+	for _, rule := range lifecycle.Rules {
+		rules = append(rules, LifecycleRule{
+			Action:                rule.Action.Type,
+			StorageClass:          rule.Action.StorageClass,
+			AgeDays:               rule.Condition.AgeInDays,
+			DaysSinceCustomTime:   rule.Condition.DaysSinceCustomTime,
+			MatchesPrefix:         rule.Condition.MatchesPrefix,
+			MatchesSuffix:         rule.Condition.MatchesSuffix,
+			MatchesStorageClasses: rule.Condition.MatchesStorageClasses,
+		})
+	}
+	return rules
+}
+
+func bucketInfo(attrs *storage.BucketAttrs) *BucketInfo {
+	info := &BucketInfo{
+		Name:                     attrs.Name,
+		Location:                 attrs.Location,
+		LocationType:             attrs.LocationType,
+		StorageClass:             attrs.StorageClass,
+		Created:                  attrs.Created,
+		VersioningEnabled:        attrs.VersioningEnabled,
+		UniformBucketLevelAccess: attrs.UniformBucketLevelAccess.Enabled,
+		Labels:                   attrs.Labels,
+	}
+	if attrs.RetentionPolicy != nil {
+		info.RetentionPeriod = attrs.RetentionPolicy.RetentionPeriod.String()
+	}
+	return info
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+
+	"gcp-proxy-mity/internal/lease"
+	"gcp-proxy-mity/pkg/storage/gcs"
+)
+
+// GCSLeaseStore keeps each lease in a small JSON object of the bucket. Objects
+// are only replaced or deleted if their generation didn't change since they
+// were read, so replicas racing for a lease can't both win it. Expiry is
+// checked against the local clock, so replicas' clocks must roughly agree.
+type GCSLeaseStore struct {
+	client *gcs.Client
+	prefix string
+	now    func() time.Time
+}
+
+// NewGCSLeaseStore creates a lease store keeping the lease on a path at
+// {prefix}/{path}
+func NewGCSLeaseStore(client *gcs.Client, prefix string) *GCSLeaseStore {
+	return &GCSLeaseStore{
+		client: client,
+		prefix: prefix,
+		now:    time.Now,
+	}
+}
+
+func (s *GCSLeaseStore) Acquire(ctx context.Context, l lease.Lease) error {
+	current, generation, err := s.read(ctx, l.Path)
+	if err != nil {
+		return err
+	}
+	if current != nil && s.now().Before(current.Expires) {
+		return lease.ErrLocked
+	}
+	// A missing lease object must still be missing, an expired one unchanged
+	conditions := storage.Conditions{DoesNotExist: true}
+	if current != nil {
+		conditions = storage.Conditions{GenerationMatch: generation}
+	}
+	if err := s.write(ctx, l, conditions); isPreconditionFailed(err) {
+		return lease.ErrLocked
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *GCSLeaseStore) Renew(ctx context.Context, path, id string, expires time.Time) (*lease.Lease, error) {
+	current, generation, err := s.read(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || current.ID != id || !s.now().Before(current.Expires) {
+		return nil, lease.ErrNotHeld
+	}
+	current.Expires = expires
+	if err := s.write(ctx, *current, storage.Conditions{GenerationMatch: generation}); isPreconditionFailed(err) {
+		return nil, lease.ErrNotHeld
+	} else if err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+func (s *GCSLeaseStore) Release(ctx context.Context, path, id string) error {
+	current, generation, err := s.read(ctx, path)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.ID != id {
+		return lease.ErrNotHeld
+	}
+	err = s.object(path).If(storage.Conditions{GenerationMatch: generation}).Delete(ctx)
+	if isPreconditionFailed(err) || errors.Is(err, storage.ErrObjectNotExist) {
+		return lease.ErrNotHeld
+	}
+	return err
+}
+
+func (s *GCSLeaseStore) Get(ctx context.Context, path string) (*lease.Lease, error) {
+	current, _, err := s.read(ctx, path)
+	if err != nil || current == nil || !s.now().Before(current.Expires) {
+		return nil, err
+	}
+	return current, nil
+}
+
+func (s *GCSLeaseStore) object(path string) *storage.ObjectHandle {
+	return s.client.GetBucket().Object(s.prefix + "/" + path)
+}
+
+// read returns the lease stored for path, expired or not, with the generation
+// of its object; nil if there is none
+func (s *GCSLeaseStore) read(ctx context.Context, path string) (*lease.Lease, int64, error) {
+	reader, err := s.object(path).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	var l lease.Lease
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode the lease on %s: %w", path, err)
+	}
+	return &l, reader.Attrs.Generation, nil
+}
+
+// write stores l if the conditions on its object hold
+func (s *GCSLeaseStore) write(ctx context.Context, l lease.Lease, conditions storage.Conditions) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writer := s.object(l.Path).If(conditions).NewWriter(writeCtx)
+	writer.ContentType = "application/json"
+	writer.CacheControl = "no-store"
+	if _, err := writer.Write(data); err != nil {
+		cancel()
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// isPreconditionFailed reports whether err is GCS refusing a request whose
+// conditions didn't hold
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
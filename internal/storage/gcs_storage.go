@@ -2,17 +2,44 @@ package storage
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
+	"hash/crc32"
+	"log"
+	"maps"
 	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"gcp-proxy-mity/internal/auth"
+	"gcp-proxy-mity/internal/bufpool"
+	"gcp-proxy-mity/internal/envelope"
 	"gcp-proxy-mity/pkg/storage/gcs"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 )
 
+// castagnoli is the CRC32C table GCS checksums objects with
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
 type GCSStorage struct {
 	client *gcs.Client
+
+	mu sync.RWMutex
+	// tenants maps principals to the clients impersonating their service accounts
+	tenants map[string]*gcs.Client
+	// keyring wraps the data keys of envelope encrypted objects
+	keyring *envelope.Keyring
+	// parallel splits reads of large objects into concurrent range reads
+	parallel ParallelReads
 }
 
 func NewGCSStorage(client *gcs.Client) *GCSStorage {
@@ -21,43 +48,103 @@ func NewGCSStorage(client *gcs.Client) *GCSStorage {
 	}
 }
 
+// SetServiceAccounts makes the requests of each principal act as its service
+// account, limiting what a leaked key can reach to that account's permissions.
+// Other principals use the configured credentials.
+func (s *GCSStorage) SetServiceAccounts(ctx context.Context, accounts map[string]string) error {
+	tenants := make(map[string]*gcs.Client, len(accounts))
+	for principal, serviceAccount := range accounts {
+		client, err := s.client.Impersonate(ctx, serviceAccount)
+		if err != nil {
+			return fmt.Errorf("failed to impersonate %s for %s: %w", serviceAccount, principal, err)
+		}
+		tenants[principal] = client
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tenants = tenants
+	return nil
+}
+
+// bucket returns the bucket as seen by the principal of the request
+func (s *GCSStorage) bucket(ctx context.Context) *storage.BucketHandle {
+	s.mu.RLock()
+	client, ok := s.tenants[auth.PrincipalFromContext(ctx)]
+	s.mu.RUnlock()
+	if !ok {
+		client = s.client
+	}
+	return client.GetBucket()
+}
+
 func (s *GCSStorage) WriteFiles(ctx context.Context, requests []WriteRequest) (*WriteResponse, error) {
 	response := &WriteResponse{
 		FilesWritten: make([]FileMetadata, 0),
 		Errors:       make([]WriteError, 0),
 	}
 
-	bucket := s.client.GetBucket()
+	bucket := s.bucket(ctx)
 
 	for _, req := range requests {
-		obj := bucket.Object(req.Path)
-		writer := obj.NewWriter(ctx)
+		// Once the caller is gone, like a client that disconnected mid-upload,
+		// the rest of the batch isn't started
+		if err := ctx.Err(); err != nil {
+			response.Errors = append(response.Errors, WriteError{
+				FilePath: req.Path,
+				Error:    aborted(ctx, err).Error(),
+			})
+			continue
+		}
+
+		obj := object(ctx, bucket, req.Path)
+		writeCtx, cancel := context.WithCancel(ctx)
+		writer := obj.NewWriter(writeCtx)
 
 		if req.ContentType != "" {
 			writer.ContentType = req.ContentType
 		} else {
 			writer.ContentType = mime.TypeByExtension(getExtension(req.Path))
 		}
+		writer.Metadata = req.Metadata
+		writer.KMSKeyName = req.KMSKeyName
+		writer.StorageClass = req.StorageClass
+		writer.CacheControl = req.CacheControl
+		writer.ProgressFunc = progressFromContext(ctx)
+		setExpiry(&writer.ObjectAttrs, req.ExpiresAt)
 
-		written, err := io.Copy(writer, req.Content)
+		content, err := s.contentWriter(ctx, writer, req)
+		var written int64
+		if err == nil {
+			written, err = copyContent(content, req.Content)
+		}
 		if err != nil {
+			// Cancelling first makes Close discard the upload instead of storing a truncated object
+			cancel()
 			writer.Close()
 			response.Errors = append(response.Errors, WriteError{
 				FilePath: req.Path,
-				Error:    err.Error(),
+				Error:    aborted(ctx, err).Error(),
 			})
 			continue
 		}
 
-		if err := writer.Close(); err != nil {
+		err = writer.Close()
+		cancel()
+		if err != nil {
 			response.Errors = append(response.Errors, WriteError{
 				FilePath: req.Path,
-				Error:    err.Error(),
+				Error:    aborted(ctx, err).Error(),
 			})
 			continue
 		}
 
-		attrs, err := obj.Attrs(ctx)
+		var attrs *storage.ObjectAttrs
+		if writer.ContentEncoding != "" {
+			attrs, err = recordUncompressedSize(ctx, obj, writer.Metadata, written)
+		} else {
+			attrs, err = obj.Attrs(ctx)
+		}
 		if err != nil {
 			response.Errors = append(response.Errors, WriteError{
 				FilePath: req.Path,
@@ -66,23 +153,67 @@ func (s *GCSStorage) WriteFiles(ctx context.Context, requests []WriteRequest) (*
 			continue
 		}
 
-		response.FilesWritten = append(response.FilesWritten, FileMetadata{
-			Name:        req.Path,
-			ContentType: attrs.ContentType,
-			Size:        written,
-		})
+		file := fileMetadata(attrs)
+		file.Name = req.Path
+		file.Size = written
+		response.FilesWritten = append(response.FilesWritten, file)
 	}
 
 	return response, nil
 }
 
+func (s *GCSStorage) CreateFile(ctx context.Context, req WriteRequest) (*FileMetadata, error) {
+	obj := object(ctx, s.bucket(ctx), req.Path)
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writer := obj.If(storage.Conditions{DoesNotExist: true}).NewWriter(writeCtx)
+	writer.ContentType = req.ContentType
+	if writer.ContentType == "" {
+		writer.ContentType = mime.TypeByExtension(getExtension(req.Path))
+	}
+	writer.Metadata = req.Metadata
+	writer.KMSKeyName = req.KMSKeyName
+	writer.StorageClass = req.StorageClass
+	writer.CacheControl = req.CacheControl
+	writer.ProgressFunc = progressFromContext(ctx)
+	setExpiry(&writer.ObjectAttrs, req.ExpiresAt)
+
+	content, err := s.contentWriter(ctx, writer, req)
+	if err != nil {
+		return nil, err
+	}
+	written, err := copyContent(content, req.Content)
+	if err != nil {
+		cancel()
+		writer.Close()
+		return nil, aborted(ctx, err)
+	}
+	if err := writer.Close(); err != nil {
+		if isPreconditionFailed(err) {
+			return nil, fmt.Errorf("%w: %s", ErrExists, req.Path)
+		}
+		return nil, aborted(ctx, err)
+	}
+
+	attrs := writer.Attrs()
+	if writer.ContentEncoding != "" {
+		if attrs, err = recordUncompressedSize(ctx, obj, writer.Metadata, written); err != nil {
+			return nil, err
+		}
+	}
+	file := fileMetadata(attrs)
+	file.Name = req.Path
+	file.Size = written
+	return &file, nil
+}
+
 func (s *GCSStorage) ReadFiles(ctx context.Context, filePaths []string) (*ReadResponse, error) {
 	response := &ReadResponse{
 		Files:  make([]FileData, 0),
 		Errors: make([]ReadError, 0),
 	}
 
-	bucket := s.client.GetBucket()
+	bucket := s.bucket(ctx)
 
 	for _, filePath := range filePaths {
 		fileData, err := s.readSingleFile(ctx, bucket, filePath)
@@ -100,40 +231,582 @@ func (s *GCSStorage) ReadFiles(ctx context.Context, filePaths []string) (*ReadRe
 	return response, nil
 }
 
+func (s *GCSStorage) ReadRanges(ctx context.Context, ranges []ReadRange) (*ReadResponse, error) {
+	response := &ReadResponse{
+		Files:  make([]FileData, 0, len(ranges)),
+		Errors: make([]ReadError, 0),
+	}
+
+	bucket := s.bucket(ctx)
+
+	for _, r := range ranges {
+		fileData, err := s.readRange(ctx, bucket, r)
+		if err != nil {
+			response.Errors = append(response.Errors, ReadError{
+				FilePath: r.Path,
+				Error:    err.Error(),
+			})
+			continue
+		}
+
+		response.Files = append(response.Files, *fileData)
+	}
+
+	return response, nil
+}
+
 func (s *GCSStorage) ReadFile(ctx context.Context, filePath string) (*FileData, error) {
-	bucket := s.client.GetBucket()
+	bucket := s.bucket(ctx)
 	return s.readSingleFile(ctx, bucket, filePath)
 }
 
-func (s *GCSStorage) readSingleFile(ctx context.Context, bucket *storage.BucketHandle, filePath string) (*FileData, error) {
+// OpenFile opens an object for reading without loading it into memory. The
+// generation described by the metadata is read, even if the object is
+// replaced meanwhile, and compressed objects are read as stored.
+func (s *GCSStorage) OpenFile(ctx context.Context, filePath string) (*FileReader, error) {
+	obj := object(ctx, s.bucket(ctx), filePath)
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object attributes: %w", err)
+	}
+	reader, err := obj.Generation(attrs.Generation).ReadCompressed(true).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reader: %w", err)
+	}
+	metadata := fileMetadata(attrs)
+	// The reader yields the stored bytes, so the size is that of the compressed content
+	metadata.Size = attrs.Size
+	return &FileReader{
+		ReadCloser:      reader,
+		Metadata:        metadata,
+		Custom:          attrs.Metadata,
+		CacheControl:    attrs.CacheControl,
+		ContentEncoding: attrs.ContentEncoding,
+	}, nil
+}
+
+// PutFile writes the object read by file under its name, keeping its
+// attributes and checking its MD5
+func (s *GCSStorage) PutFile(ctx context.Context, file *FileReader) error {
+	// Canceling the writer's context discards a partial upload
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writer := object(ctx, s.bucket(ctx), file.Metadata.Name).NewWriter(writeCtx)
+	writer.ContentType = file.Metadata.ContentType
+	writer.ContentEncoding = file.ContentEncoding
+	writer.CacheControl = file.CacheControl
+	writer.Metadata = file.Custom
+	if sum, err := hex.DecodeString(file.Metadata.MD5); err == nil && len(sum) == md5.Size {
+		writer.MD5 = sum
+	}
+
+	if _, err := bufpool.Copy(writer, file); err != nil {
+		cancel()
+		writer.Close()
+		return fmt.Errorf("failed to copy object: %w", aborted(ctx, err))
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write object: %w", aborted(ctx, err))
+	}
+	return nil
+}
+
+func (s *GCSStorage) StatFile(ctx context.Context, filePath string) (*FileMetadata, error) {
+	attrs, err := object(ctx, s.bucket(ctx), filePath).Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file attributes: %w", err)
+	}
+	if err := checkExpiry(attrs); err != nil {
+		return nil, err
+	}
+
+	file := fileMetadata(attrs)
+	return &file, nil
+}
+
+func (s *GCSStorage) ListFiles(ctx context.Context, prefix string) ([]FileMetadata, error) {
+	files := make([]FileMetadata, 0)
+	err := s.WalkFiles(ctx, prefix, func(file FileMetadata) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (s *GCSStorage) WalkFiles(ctx context.Context, prefix string, fn func(FileMetadata) error) error {
+	it := s.bucket(ctx).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		if err := fn(fileMetadata(attrs)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *GCSStorage) ListNames(ctx context.Context, prefix string) ([]string, error) {
+	names := make([]string, 0)
+
+	query := &storage.Query{Prefix: prefix}
+	// Only names are fetched, which shrinks the listing responses considerably
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return nil, err
+	}
+	it := s.bucket(ctx).Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		names = append(names, attrs.Name)
+	}
+
+	return names, nil
+}
+
+func (s *GCSStorage) CopyFile(ctx context.Context, srcPath, dstPath string) error {
+	bucket := s.bucket(ctx)
+	if _, err := object(ctx, bucket, dstPath).CopierFrom(object(ctx, bucket, srcPath)).Run(ctx); err != nil {
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+	return nil
+}
+
+// maxComposeSources is the number of sources GCS accepts in a single compose request
+const maxComposeSources = 32
+
+func (s *GCSStorage) ComposeFiles(ctx context.Context, dst WriteRequest, srcPaths []string) (*FileMetadata, error) {
+	bucket := s.bucket(ctx)
+	if len(srcPaths) == 0 {
+		return nil, errors.New("failed to compose object: no sources")
+	}
+
+	first, err := object(ctx, bucket, srcPaths[0]).Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object attributes: %w", err)
+	}
+	// Encrypted content can't be concatenated as stored, since every object has its own data key
+	if dst.Envelope || isEnvelope(first) {
+		return s.composeThrough(ctx, bucket, dst, srcPaths)
+	}
+
+	encoding, size, err := composedEncoding(ctx, bucket, first, srcPaths[1:])
+	if err != nil {
+		return nil, err
+	}
+	dst.ContentEncoding = encoding
+	if size >= 0 {
+		dst.Metadata = maps.Clone(dst.Metadata)
+		if dst.Metadata == nil {
+			dst.Metadata = make(map[string]string, 1)
+		}
+		dst.Metadata[UncompressedSizeKey] = strconv.FormatInt(size, 10)
+	}
+
+	// Larger lists are composed in rounds through temporary objects next to the destination
+	var temporary []string
+	defer func() {
+		for _, path := range temporary {
+			if err := object(ctx, bucket, path).Delete(ctx); err != nil {
+				log.Printf("Failed to delete temporary compose object %s: %v", path, err)
+			}
+		}
+	}()
+	for round := 0; len(srcPaths) > maxComposeSources; round++ {
+		next := make([]string, 0, len(srcPaths)/maxComposeSources+1)
+		for i := 0; i < len(srcPaths); i += maxComposeSources {
+			group := srcPaths[i:min(i+maxComposeSources, len(srcPaths))]
+			path := fmt.Sprintf("%s.compose-%d-%d", dst.Path, round, i/maxComposeSources)
+			if _, err := s.compose(ctx, bucket, WriteRequest{Path: path, KMSKeyName: dst.KMSKeyName}, group); err != nil {
+				return nil, err
+			}
+			temporary = append(temporary, path)
+			next = append(next, path)
+		}
+		srcPaths = next
+	}
+
+	attrs, err := s.compose(ctx, bucket, dst, srcPaths)
+	if err != nil {
+		return nil, err
+	}
+	file := fileMetadata(attrs)
+	return &file, nil
+}
+
+func (s *GCSStorage) compose(ctx context.Context, bucket *storage.BucketHandle, dst WriteRequest, srcPaths []string) (*storage.ObjectAttrs, error) {
+	sources := make([]*storage.ObjectHandle, 0, len(srcPaths))
+	for _, path := range srcPaths {
+		sources = append(sources, object(ctx, bucket, path))
+	}
+
+	composer := object(ctx, bucket, dst.Path).ComposerFrom(sources...)
+	composer.ContentType = dst.ContentType
+	composer.Metadata = dst.Metadata
+	composer.KMSKeyName = dst.KMSKeyName
+	composer.StorageClass = dst.StorageClass
+	composer.CacheControl = dst.CacheControl
+	composer.ContentEncoding = dst.ContentEncoding
+	setExpiry(&composer.ObjectAttrs, dst.ExpiresAt)
+
+	attrs, err := composer.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compose object: %w", err)
+	}
+	return attrs, nil
+}
+
+// SetStorageClass rewrites an object in place with a new storage class, keeping
+// its metadata and encryption key
+func (s *GCSStorage) SetStorageClass(ctx context.Context, filePath, storageClass string) (*FileMetadata, error) {
+	obj := object(ctx, s.bucket(ctx), filePath)
+
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object attributes: %w", err)
+	}
+
+	copier := obj.CopierFrom(obj)
+	// A destination resource replaces the source metadata, so carry it over
+	copier.ContentType = attrs.ContentType
+	copier.ContentLanguage = attrs.ContentLanguage
+	copier.ContentEncoding = attrs.ContentEncoding
+	copier.ContentDisposition = attrs.ContentDisposition
+	copier.CacheControl = attrs.CacheControl
+	copier.Metadata = attrs.Metadata
+	copier.StorageClass = storageClass
+	if attrs.KMSKeyName != "" {
+		// Rewrites otherwise fall back to the bucket's default key
+		copier.DestinationKMSKeyName, _, _ = strings.Cut(attrs.KMSKeyName, "/cryptoKeyVersions/")
+	}
+
+	attrs, err = copier.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewrite object: %w", err)
+	}
+
+	file := fileMetadata(attrs)
+	return &file, nil
+}
+
+func (s *GCSStorage) GetRetention(ctx context.Context, filePath string) (*Retention, error) {
+	attrs, err := s.bucket(ctx).Object(filePath).Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object attributes: %w", err)
+	}
+	return retentionFromAttrs(attrs), nil
+}
+
+func (s *GCSStorage) SetHolds(ctx context.Context, filePath string, update HoldUpdate) (*Retention, error) {
+	var attrs storage.ObjectAttrsToUpdate
+	if update.TemporaryHold != nil {
+		attrs.TemporaryHold = *update.TemporaryHold
+	}
+	if update.EventBasedHold != nil {
+		attrs.EventBasedHold = *update.EventBasedHold
+	}
+
+	updated, err := s.bucket(ctx).Object(filePath).Update(ctx, attrs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update holds: %w", err)
+	}
+	return retentionFromAttrs(updated), nil
+}
+
+func (s *GCSStorage) GetAccess(ctx context.Context, filePath string) (*Access, error) {
+	bucket := s.bucket(ctx)
+	public, err := isPublic(ctx, bucket.Object(filePath))
+	if err != nil {
+		return nil, err
+	}
+	if !public {
+		return &Access{}, nil
+	}
+	return publicAccess(bucket.BucketName(), filePath), nil
+}
+
+func (s *GCSStorage) SetAccess(ctx context.Context, filePath string, public bool) (*Access, error) {
+	bucket := s.bucket(ctx)
 	obj := bucket.Object(filePath)
+	if public {
+		if err := obj.ACL().Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
+			return nil, fmt.Errorf("failed to update object ACL: %w", aclError(err))
+		}
+		return publicAccess(bucket.BucketName(), filePath), nil
+	}
+
+	// Deleting an entry that doesn't exist fails, so only delete it if it does
+	wasPublic, err := isPublic(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+	if wasPublic {
+		if err := obj.ACL().Delete(ctx, storage.AllUsers); err != nil {
+			return nil, fmt.Errorf("failed to update object ACL: %w", aclError(err))
+		}
+	}
+	return &Access{}, nil
+}
+
+// isPublic reports whether the object's ACL lets allUsers read it
+func isPublic(ctx context.Context, obj *storage.ObjectHandle) (bool, error) {
+	rules, err := obj.ACL().List(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get object ACL: %w", aclError(err))
+	}
+	for _, rule := range rules {
+		if rule.Entity == storage.AllUsers && (rule.Role == storage.RoleReader || rule.Role == storage.RoleOwner) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// publicAccess returns the access of a public object with its public URL
+func publicAccess(bucket, filePath string) *Access {
+	segments := strings.Split(filePath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return &Access{Public: true, URL: "https://storage.googleapis.com/" + bucket + "/" + strings.Join(segments, "/")}
+}
+
+// aclError maps the errors of ACL requests, which the client doesn't translate,
+// to ErrNotFound and ErrUniformAccess
+func aclError(err error) error {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch {
+	case apiErr.Code == http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrNotFound, apiErr.Message)
+	case apiErr.Code == http.StatusBadRequest && strings.Contains(strings.ToLower(apiErr.Message), "uniform bucket-level access"):
+		return ErrUniformAccess
+	}
+	return err
+}
+
+func (s *GCSStorage) GetMetadata(ctx context.Context, filePath string) (map[string]string, error) {
+	attrs, err := s.bucket(ctx).Object(filePath).Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object attributes: %w", err)
+	}
+	return attrs.Metadata, nil
+}
+
+func (s *GCSStorage) UpdateMetadata(ctx context.Context, filePath string, metadata map[string]string) error {
+	// Metadata in a patch is merged into the object's metadata
+	_, err := s.bucket(ctx).Object(filePath).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	if err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
+}
+
+func retentionFromAttrs(attrs *storage.ObjectAttrs) *Retention {
+	retention := &Retention{
+		TemporaryHold:  attrs.TemporaryHold,
+		EventBasedHold: attrs.EventBasedHold,
+	}
+	if !attrs.RetentionExpirationTime.IsZero() {
+		retention.RetentionExpiration = &attrs.RetentionExpirationTime
+	}
+	if attrs.Retention != nil {
+		retention.RetentionMode = attrs.Retention.Mode
+		retention.RetainUntil = &attrs.Retention.RetainUntil
+	}
+	return retention
+}
+
+func (s *GCSStorage) SignedUploadURL(ctx context.Context, req WriteRequest, expires time.Time) (string, error) {
+	headers := make([]string, 0, len(req.Metadata))
+	for key, value := range req.Metadata {
+		headers = append(headers, "x-goog-meta-"+key+":"+value)
+	}
+	if req.CacheControl != "" {
+		headers = append(headers, "Cache-Control:"+req.CacheControl)
+	}
+
+	// Impersonated clients only hold a token, so URLs are signed with the configured credentials
+	url, err := s.client.GetBucket().SignedURL(req.Path, &storage.SignedURLOptions{
+		Scheme:      storage.SigningSchemeV4,
+		Method:      http.MethodPut,
+		ContentType: req.ContentType,
+		Headers:     headers,
+		Expires:     expires,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign upload URL: %w", err)
+	}
+	return url, nil
+}
+
+func (s *GCSStorage) SignedDownloadURL(ctx context.Context, filePath, disposition string, expires time.Time) (string, error) {
+	query := url.Values{}
+	if disposition != "" {
+		query.Set("response-content-disposition", disposition)
+	}
+	signed, err := s.client.GetBucket().SignedURL(filePath, &storage.SignedURLOptions{
+		Scheme:          storage.SigningSchemeV4,
+		Method:          http.MethodGet,
+		Expires:         expires,
+		QueryParameters: query,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign download URL: %w", err)
+	}
+	return signed, nil
+}
+
+func (s *GCSStorage) DeleteFile(ctx context.Context, filePath string) error {
+	if err := s.bucket(ctx).Object(filePath).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+func (s *GCSStorage) readSingleFile(ctx context.Context, bucket *storage.BucketHandle, filePath string) (*FileData, error) {
+	obj := object(ctx, bucket, filePath)
 
 	attrs, err := obj.Attrs(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get object attributes: %w", err)
 	}
+	if err := checkExpiry(attrs); err != nil {
+		return nil, err
+	}
 
-	reader, err := obj.NewReader(ctx)
+	content, err := s.readObject(ctx, obj, attrs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create reader: %w", err)
+		return nil, err
+	}
+	if isEnvelope(attrs) {
+		if content, err = s.openEnvelope(ctx, attrs, content); err != nil {
+			return nil, err
+		}
+	}
+
+	file := &FileData{
+		Metadata:        fileMetadata(attrs),
+		Content:         content,
+		ContentEncoding: attrs.ContentEncoding,
+	}
+	if err := decodeFile(ctx, file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *GCSStorage) readRange(ctx context.Context, bucket *storage.BucketHandle, r ReadRange) (*FileData, error) {
+	obj := object(ctx, bucket, r.Path)
+
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object attributes: %w", err)
+	}
+	if err := checkExpiry(attrs); err != nil {
+		return nil, err
+	}
+	if isEnvelope(attrs) {
+		return s.readEnvelopeRange(ctx, obj, attrs, r)
+	}
+	if ValidEncoding(attrs.ContentEncoding) {
+		return s.readCompressedRange(ctx, obj, attrs, r)
+	}
+	if r.Offset > attrs.Size {
+		return nil, fmt.Errorf("%w: offset %d, size %d", ErrInvalidRange, r.Offset, attrs.Size)
 	}
-	defer reader.Close()
 
-	content, err := io.ReadAll(reader)
+	// The range is resolved against this generation, so it is read from it even if the object is replaced meanwhile
+	offset, length := r.Bounds(attrs.Size)
+	content, err := s.readContent(ctx, obj.Generation(attrs.Generation), offset, length)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read content: %w", err)
+		return nil, err
 	}
 
 	return &FileData{
-		Metadata: FileMetadata{
-			Name:        filePath,
-			ContentType: attrs.ContentType,
-			Size:        attrs.Size,
-		},
-		Content: content,
+		Metadata: fileMetadata(attrs),
+		Content:  content,
+		Offset:   offset,
 	}, nil
 }
 
+// readCompressedRange reads a range of the decompressed content of an object
+// stored compressed, which GCS can't serve ranges of
+func (s *GCSStorage) readCompressedRange(ctx context.Context, obj *storage.ObjectHandle, attrs *storage.ObjectAttrs, r ReadRange) (*FileData, error) {
+	content, err := s.readObject(ctx, obj, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = decompress(attrs.ContentEncoding, content); err != nil {
+		return nil, err
+	}
+	size := int64(len(content))
+	if r.Offset > size {
+		return nil, fmt.Errorf("%w: offset %d, size %d", ErrInvalidRange, r.Offset, size)
+	}
+
+	offset, length := r.Bounds(size)
+	metadata := fileMetadata(attrs)
+	metadata.Size = size
+	return &FileData{
+		Metadata: metadata,
+		Content:  content[offset : offset+length],
+		Offset:   offset,
+	}, nil
+}
+
+// readObject reads the whole content of the generation of an object attrs
+// describe as stored, without GCS decompressing gzip objects on the way, and
+// checks it against the object's CRC32C
+func (s *GCSStorage) readObject(ctx context.Context, obj *storage.ObjectHandle, attrs *storage.ObjectAttrs) ([]byte, error) {
+	content, err := s.readContent(ctx, obj.Generation(attrs.Generation).ReadCompressed(true), 0, attrs.Size)
+	if err != nil {
+		return nil, err
+	}
+	if sum := crc32.Checksum(content, castagnoli); sum != attrs.CRC32C {
+		return nil, fmt.Errorf("%w: %s has CRC32C %08x, read %08x", ErrChecksumMismatch, attrs.Name, attrs.CRC32C, sum)
+	}
+	return content, nil
+}
+
+func fileMetadata(attrs *storage.ObjectAttrs) FileMetadata {
+	file := FileMetadata{
+		Name:            attrs.Name,
+		ContentType:     attrs.ContentType,
+		Size:            attrs.Size,
+		KMSKeyName:      attrs.KMSKeyName,
+		StorageClass:    attrs.StorageClass,
+		Generation:      attrs.Generation,
+		Updated:         attrs.Updated,
+		MD5:             hex.EncodeToString(attrs.MD5),
+		ContentEncoding: attrs.ContentEncoding,
+		ExpiresAt:       expiresAt(attrs),
+	}
+	if size, ok := uncompressedSize(attrs); ok {
+		file.Size = size
+	}
+	if isEnvelope(attrs) {
+		file.Envelope = true
+		file.Size = envelope.PlaintextSize(attrs.Size)
+	}
+	return file
+}
+
 func getExtension(path string) string {
 	for i := len(path) - 1; i >= 0; i-- {
 		if path[i] == '.' {
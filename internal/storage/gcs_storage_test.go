@@ -0,0 +1,619 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+
+	"gcp-proxy-mity/internal/envelope"
+	"gcp-proxy-mity/internal/lease"
+	"gcp-proxy-mity/pkg/storage/gcs"
+)
+
+// newEmulatedStorage runs fake-gcs-server in-process and returns a GCSStorage
+// talking to it over HTTP like it would to Cloud Storage
+func newEmulatedStorage(t *testing.T) *GCSStorage {
+	t.Helper()
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme:     "http",
+		Host:       "127.0.0.1",
+		PublicHost: "127.0.0.1",
+	})
+	if err != nil {
+		t.Fatalf("Failed to start fake-gcs-server: %v", err)
+	}
+	t.Cleanup(server.Stop)
+	server.CreateBucket("test-bucket")
+
+	client, err := gcs.NewEmulatorClient(context.Background(), "test-project", "test-bucket", server.URL())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return NewGCSStorage(client)
+}
+
+func writeEmulated(t *testing.T, s *GCSStorage, files map[string]string) {
+	t.Helper()
+	requests := make([]WriteRequest, 0, len(files))
+	for path, content := range files {
+		requests = append(requests, WriteRequest{Path: path, Content: strings.NewReader(content), ContentType: "text/plain"})
+	}
+	response, err := s.WriteFiles(context.Background(), requests)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Errors) > 0 {
+		t.Fatalf("Expected no write errors, got %v", response.Errors)
+	}
+}
+
+func TestGCSStorage_WriteAndRead(t *testing.T) {
+	s := newEmulatedStorage(t)
+	ctx := context.Background()
+
+	response, err := s.WriteFiles(ctx, []WriteRequest{
+		{Path: "docs/a.txt", Content: strings.NewReader("hello"), ContentType: "text/plain", Metadata: map[string]string{"owner": "ci"}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.FilesWritten) != 1 || response.FilesWritten[0].Size != 5 {
+		t.Fatalf("Expected a 5 byte file to be written, got %+v", response)
+	}
+
+	file, err := s.ReadFile(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(file.Content) != "hello" || file.Metadata.ContentType != "text/plain" {
+		t.Errorf("Expected hello as text/plain, got %q as %s", file.Content, file.Metadata.ContentType)
+	}
+
+	metadata, err := s.GetMetadata(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if metadata["owner"] != "ci" {
+		t.Errorf("Expected the metadata to be stored, got %v", metadata)
+	}
+
+	read, err := s.ReadFiles(ctx, []string{"docs/a.txt", "docs/missing.txt"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(read.Files) != 1 || len(read.Errors) != 1 || read.Errors[0].FilePath != "docs/missing.txt" {
+		t.Errorf("Expected one file and an error for the missing one, got %+v", read)
+	}
+}
+
+func TestGCSStorage_CreateFile(t *testing.T) {
+	s := newEmulatedStorage(t)
+	ctx := context.Background()
+
+	file, err := s.CreateFile(ctx, WriteRequest{Path: "logs/0", Content: strings.NewReader("first")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if file.Name != "logs/0" || file.Size != 5 {
+		t.Errorf("Expected a 5 byte file at logs/0, got %+v", file)
+	}
+
+	if _, err := s.CreateFile(ctx, WriteRequest{Path: "logs/0", Content: strings.NewReader("second")}); !errors.Is(err, ErrExists) {
+		t.Fatalf("Expected ErrExists, got %v", err)
+	}
+	read, err := s.ReadFile(ctx, "logs/0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(read.Content) != "first" {
+		t.Errorf("Expected the existing object to be kept, got %q", read.Content)
+	}
+}
+
+func TestGCSStorage_ReadRanges(t *testing.T) {
+	s := newEmulatedStorage(t)
+	writeEmulated(t, s, map[string]string{"video.mp4": "0123456789"})
+
+	tests := []struct {
+		name       string
+		r          ReadRange
+		wantData   string
+		wantOffset int64
+		wantErr    bool
+	}{
+		{name: "head", r: ReadRange{Path: "video.mp4", Length: 4}, wantData: "0123"},
+		{name: "middle", r: ReadRange{Path: "video.mp4", Offset: 3, Length: 2}, wantData: "34", wantOffset: 3},
+		{name: "tail", r: ReadRange{Path: "video.mp4", Offset: -3}, wantData: "789", wantOffset: 7},
+		{name: "beyond the end", r: ReadRange{Path: "video.mp4", Offset: 20}, wantErr: true},
+		{name: "missing", r: ReadRange{Path: "missing.mp4"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := s.ReadRanges(context.Background(), []ReadRange{tt.r})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				if len(response.Errors) != 1 {
+					t.Errorf("Expected an error, got %+v", response)
+				}
+				return
+			}
+			if len(response.Files) != 1 {
+				t.Fatalf("Expected one file, got %+v", response)
+			}
+			file := response.Files[0]
+			if string(file.Content) != tt.wantData || file.Offset != tt.wantOffset || file.Metadata.Size != 10 {
+				t.Errorf("Expected %q at %d of 10 bytes, got %q at %d of %d", tt.wantData, tt.wantOffset, file.Content, file.Offset, file.Metadata.Size)
+			}
+		})
+	}
+}
+
+func TestGCSStorage_ParallelReads(t *testing.T) {
+	s := newEmulatedStorage(t)
+	ctx := context.Background()
+	content := "0123456789abcdefghij"
+	writeEmulated(t, s, map[string]string{"video.mp4": content})
+	if _, err := s.WriteFiles(ctx, []WriteRequest{{Path: "log.txt", Content: strings.NewReader(content), ContentType: "text/plain", ContentEncoding: EncodingGzip}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	s.SetParallelReads(ParallelReads{PartSize: 3, Concurrency: 4})
+
+	tests := []struct {
+		name     string
+		r        ReadRange
+		wantData string
+		parallel bool
+	}{
+		{name: "whole object", r: ReadRange{Path: "video.mp4"}, wantData: content, parallel: true},
+		{name: "range of parts", r: ReadRange{Path: "video.mp4", Offset: 5, Length: 8}, wantData: "56789abc", parallel: true},
+		{name: "within a part", r: ReadRange{Path: "video.mp4", Offset: 5, Length: 3}, wantData: "567"},
+		{name: "compressed object", r: ReadRange{Path: "log.txt", Offset: 2, Length: 4}, wantData: "2345", parallel: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := parallelReads.Value()
+			response, err := s.ReadRanges(ctx, []ReadRange{tt.r})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(response.Files) != 1 {
+				t.Fatalf("Expected one file, got %+v", response)
+			}
+			if string(response.Files[0].Content) != tt.wantData {
+				t.Errorf("Expected %q, got %q", tt.wantData, response.Files[0].Content)
+			}
+			if parallel := parallelReads.Value() > before; parallel != tt.parallel {
+				t.Errorf("Expected parallel read %v, got %v", tt.parallel, parallel)
+			}
+		})
+	}
+
+	file, err := s.ReadFile(ctx, "video.mp4")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(file.Content) != content {
+		t.Errorf("Expected %q, got %q", content, file.Content)
+	}
+}
+
+func TestGCSStorage_Checksum(t *testing.T) {
+	s := newEmulatedStorage(t)
+	ctx := context.Background()
+	writeEmulated(t, s, map[string]string{"docs/a.txt": "hello"})
+
+	obj := s.bucket(ctx).Object("docs/a.txt")
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := s.readObject(ctx, obj, attrs); err != nil {
+		t.Errorf("Expected the content to match its CRC32C, got %v", err)
+	}
+
+	// The emulator recomputes checksums, so the mismatch is in the attributes
+	attrs.CRC32C ^= 1
+	if _, err := s.readObject(ctx, obj, attrs); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestGCSStorage_Expiry(t *testing.T) {
+	s := newEmulatedStorage(t)
+	ctx := context.Background()
+	later := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	response, err := s.WriteFiles(ctx, []WriteRequest{
+		{Path: "tmp/later.txt", Content: strings.NewReader("later"), ExpiresAt: later},
+		{Path: "tmp/past.txt", Content: strings.NewReader("past"), ExpiresAt: time.Now().Add(-time.Second)},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.FilesWritten) != 2 || !response.FilesWritten[0].ExpiresAt.Equal(later) {
+		t.Fatalf("Expected tmp/later.txt to expire at %s, got %+v", later, response)
+	}
+
+	attrs, err := s.bucket(ctx).Object("tmp/later.txt").Attrs(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attrs.Metadata[ExpiresAtKey] != later.UTC().Format(time.RFC3339) || !attrs.CustomTime.Equal(later) {
+		t.Errorf("Expected the expiry in the metadata and Custom-Time, got %v and %s", attrs.Metadata, attrs.CustomTime)
+	}
+	if _, err := s.ReadFile(ctx, "tmp/later.txt"); err != nil {
+		t.Errorf("Expected an object that hasn't expired to be read, got %v", err)
+	}
+
+	if _, err := s.ReadFile(ctx, "tmp/past.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound reading an expired object, got %v", err)
+	}
+	if _, err := s.StatFile(ctx, "tmp/past.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for the metadata of an expired object, got %v", err)
+	}
+	if metadata, err := s.GetMetadata(ctx, "tmp/past.txt"); err != nil || metadata[ExpiresAtKey] == "" {
+		t.Errorf("Expected the expiry of an expired object to stay readable for the reaper, got %v, %v", metadata, err)
+	}
+}
+
+func TestGCSStorage_Compression(t *testing.T) {
+	s := newEmulatedStorage(t)
+	ctx := context.Background()
+	content := strings.Repeat("compressible text ", 100)
+
+	for _, encoding := range []string{EncodingGzip, EncodingZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			path := "docs/" + encoding + ".txt"
+			response, err := s.WriteFiles(ctx, []WriteRequest{
+				{Path: path, Content: strings.NewReader(content), ContentType: "text/plain", ContentEncoding: encoding},
+			})
+			if err != nil || len(response.Errors) > 0 {
+				t.Fatalf("Unexpected write errors: %v %v", err, response)
+			}
+			if written := response.FilesWritten[0]; written.Size != int64(len(content)) || written.ContentEncoding != encoding {
+				t.Errorf("Expected %d bytes in %s, got %+v", len(content), encoding, written)
+			}
+
+			stat, err := s.StatFile(ctx, path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if stat.Size != int64(len(content)) {
+				t.Errorf("Expected uncompressed size %d, got %d", len(content), stat.Size)
+			}
+
+			file, err := s.ReadFile(ctx, path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(file.Content) != content || file.ContentEncoding != "" {
+				t.Errorf("Expected decompressed content, got %d bytes in %q", len(file.Content), file.ContentEncoding)
+			}
+
+			stored, err := s.ReadFile(WithAcceptedEncodings(ctx, encoding), path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if stored.ContentEncoding != encoding || len(stored.Content) >= len(content) {
+				t.Errorf("Expected content as stored in %s, got %d bytes in %q", encoding, len(stored.Content), stored.ContentEncoding)
+			}
+			if decoded, err := decompress(encoding, stored.Content); err != nil || string(decoded) != content {
+				t.Errorf("Expected stored content to decompress to the original, got %v", err)
+			}
+
+			ranges, err := s.ReadRanges(ctx, []ReadRange{{Path: path, Offset: 18, Length: 12}})
+			if err != nil || len(ranges.Files) != 1 {
+				t.Fatalf("Unexpected range errors: %v %v", err, ranges)
+			}
+			if got := ranges.Files[0]; string(got.Content) != "compressible" || got.Offset != 18 {
+				t.Errorf("Expected %q at 18, got %q at %d", "compressible", got.Content, got.Offset)
+			}
+		})
+	}
+
+	t.Run("compose", func(t *testing.T) {
+		var paths []string
+		for _, part := range []string{"first ", "second"} {
+			path := "parts/" + part
+			if _, err := s.CreateFile(ctx, WriteRequest{Path: path, Content: strings.NewReader(part), ContentEncoding: EncodingGzip}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			paths = append(paths, path)
+		}
+		// fake-gcs-server drops the content encoding of compose requests, so the attributes are checked directly
+		first, err := s.bucket(ctx).Object(paths[0]).Attrs(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		encoding, size, err := composedEncoding(ctx, s.bucket(ctx), first, paths[1:])
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if encoding != EncodingGzip || size != 12 {
+			t.Errorf("Expected 12 bytes in gzip, got %d in %q", size, encoding)
+		}
+
+		writeEmulated(t, s, map[string]string{"parts/plain": "plain"})
+		if _, err := s.ComposeFiles(ctx, WriteRequest{Path: "mixed.txt"}, []string{paths[0], "parts/plain"}); !errors.Is(err, ErrMixedEncodings) {
+			t.Errorf("Expected ErrMixedEncodings, got %v", err)
+		}
+	})
+}
+
+func TestGCSStorage_Envelope(t *testing.T) {
+	s := newEmulatedStorage(t)
+	ctx := context.Background()
+	wrapper, err := envelope.NewLocalWrapper(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	content := strings.Repeat("secret ", 20000)
+
+	if _, err := s.CreateFile(ctx, WriteRequest{Path: "plain.txt", Content: strings.NewReader("x"), Envelope: true}); !errors.Is(err, ErrEnvelopeDisabled) {
+		t.Errorf("Expected ErrEnvelopeDisabled without a keyring, got %v", err)
+	}
+	s.SetKeyring(envelope.NewKeyring(wrapper))
+
+	response, err := s.WriteFiles(ctx, []WriteRequest{
+		{Path: "secret.txt", Content: strings.NewReader(content), ContentType: "text/plain", Envelope: true, ContentEncoding: EncodingGzip},
+	})
+	if err != nil || len(response.Errors) > 0 {
+		t.Fatalf("Unexpected write errors: %v %v", err, response)
+	}
+	if written := response.FilesWritten[0]; written.Size != int64(len(content)) || !written.Envelope {
+		t.Errorf("Expected %d envelope encrypted bytes, got %+v", len(content), written)
+	}
+
+	raw, err := s.OpenFile(ctx, "secret.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stored, _ := io.ReadAll(raw)
+	raw.Close()
+	if bytes.Contains(stored, []byte("secret")) || raw.ContentEncoding != "" || raw.Custom[EnvelopeKeyIDKey] != wrapper.KeyID() {
+		t.Errorf("Expected ciphertext stored uncompressed with the wrapped key, got %q %v", raw.ContentEncoding, raw.Custom)
+	}
+
+	stat, err := s.StatFile(ctx, "secret.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stat.Size != int64(len(content)) {
+		t.Errorf("Expected plaintext size %d, got %d", len(content), stat.Size)
+	}
+
+	file, err := s.ReadFile(ctx, "secret.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(file.Content) != content {
+		t.Error("Expected the plaintext")
+	}
+
+	ranges, err := s.ReadRanges(ctx, []ReadRange{{Path: "secret.txt", Offset: 70000, Length: 14}, {Path: "secret.txt", Offset: -6}})
+	if err != nil || len(ranges.Files) != 2 {
+		t.Fatalf("Unexpected range errors: %v %v", err, ranges)
+	}
+	for i, want := range []string{content[70000:70014], content[len(content)-6:]} {
+		if got := string(ranges.Files[i].Content); got != want {
+			t.Errorf("Expected range %q, got %q", want, got)
+		}
+	}
+
+	writeEmulated(t, s, map[string]string{"parts/b": " and plain"})
+	composed, err := s.ComposeFiles(ctx, WriteRequest{Path: "composed.txt", Envelope: true}, []string{"secret.txt", "parts/b"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !composed.Envelope || composed.Size != int64(len(content))+10 {
+		t.Errorf("Expected %d envelope encrypted bytes, got %+v", len(content)+10, composed)
+	}
+	file, err = s.ReadFile(ctx, "composed.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(file.Content) != content+" and plain" {
+		t.Error("Expected the sources concatenated")
+	}
+
+	other, _ := envelope.NewLocalWrapper(bytes.Repeat([]byte{8}, 32))
+	s.SetKeyring(envelope.NewKeyring(other))
+	if _, err := s.ReadFile(ctx, "secret.txt"); !errors.Is(err, envelope.ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey after losing the master key, got %v", err)
+	}
+}
+
+func TestGCSLeaseStore(t *testing.T) {
+	s := newEmulatedStorage(t)
+	ctx := context.Background()
+	store := NewGCSLeaseStore(s.client, "_election")
+	expires := time.Now().Add(time.Minute).UTC().Truncate(time.Millisecond)
+
+	if err := store.Acquire(ctx, lease.Lease{Path: "scheduler", ID: "a", Expires: expires}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := store.Acquire(ctx, lease.Lease{Path: "scheduler", ID: "b", Expires: expires}); !errors.Is(err, lease.ErrLocked) {
+		t.Errorf("Expected ErrLocked for a held lease, got %v", err)
+	}
+	current, err := store.Get(ctx, "scheduler")
+	if err != nil || current == nil || current.ID != "a" {
+		t.Fatalf("Expected the lease of a, got %+v, %v", current, err)
+	}
+
+	if _, err := store.Renew(ctx, "scheduler", "b", expires); !errors.Is(err, lease.ErrNotHeld) {
+		t.Errorf("Expected ErrNotHeld renewing another's lease, got %v", err)
+	}
+	renewed, err := store.Renew(ctx, "scheduler", "a", expires.Add(time.Minute))
+	if err != nil || !renewed.Expires.Equal(expires.Add(time.Minute)) {
+		t.Errorf("Expected the lease renewed, got %+v, %v", renewed, err)
+	}
+
+	// Once expired, the lease can be taken over
+	store.now = func() time.Time { return expires.Add(time.Hour) }
+	if err := store.Acquire(ctx, lease.Lease{Path: "scheduler", ID: "b", Expires: expires.Add(2 * time.Hour)}); err != nil {
+		t.Fatalf("Expected an expired lease to be taken over, got %v", err)
+	}
+	if err := store.Release(ctx, "scheduler", "a"); !errors.Is(err, lease.ErrNotHeld) {
+		t.Errorf("Expected ErrNotHeld releasing a lease taken over, got %v", err)
+	}
+	if err := store.Release(ctx, "scheduler", "b"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if current, err := store.Get(ctx, "scheduler"); err != nil || current != nil {
+		t.Errorf("Expected no lease once released, got %+v, %v", current, err)
+	}
+}
+
+// disconnectingReader returns content and then fails with err like the body of
+// a request whose client went away, canceling the request's context as
+// net/http does
+type disconnectingReader struct {
+	content io.Reader
+	cancel  context.CancelFunc
+	err     error
+}
+
+func (r *disconnectingReader) Read(p []byte) (int, error) {
+	n, err := r.content.Read(p)
+	if err == io.EOF {
+		r.cancel()
+		return n, r.err
+	}
+	return n, err
+}
+
+// abortTests are clients going away during an upload
+var abortTests = []struct {
+	name string
+	err  error
+}{
+	{name: "body cut off", err: io.ErrUnexpectedEOF},
+	{name: "gone after the body", err: io.EOF},
+}
+
+func TestGCSStorage_Abort(t *testing.T) {
+	s := newEmulatedStorage(t)
+
+	for _, tt := range abortTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			response, err := s.WriteFiles(ctx, []WriteRequest{
+				{Path: "video.mp4", Content: &disconnectingReader{content: strings.NewReader("partial"), cancel: cancel, err: tt.err}},
+				{Path: "next.txt", Content: strings.NewReader("next")},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(response.FilesWritten) != 0 || len(response.Errors) != 2 {
+				t.Fatalf("Expected both writes to fail, got %+v", response)
+			}
+			for _, writeErr := range response.Errors {
+				if !strings.HasPrefix(writeErr.Error, ErrAborted.Error()) {
+					t.Errorf("Expected %s to be aborted, got %s", writeErr.FilePath, writeErr.Error)
+				}
+			}
+
+			ctx, cancel = context.WithCancel(context.Background())
+			defer cancel()
+			_, err = s.CreateFile(ctx, WriteRequest{Path: "logs/0", Content: &disconnectingReader{content: strings.NewReader("partial"), cancel: cancel, err: tt.err}})
+			if !errors.Is(err, ErrAborted) {
+				t.Errorf("Expected ErrAborted, got %v", err)
+			}
+
+			for _, path := range []string{"video.mp4", "next.txt", "logs/0"} {
+				if _, err := s.StatFile(context.Background(), path); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Expected no object at %s, got %v", path, err)
+				}
+			}
+		})
+	}
+}
+
+func TestGCSStorage_ListCopyDelete(t *testing.T) {
+	s := newEmulatedStorage(t)
+	ctx := context.Background()
+	writeEmulated(t, s, map[string]string{"media/a.jpg": "a", "media/clips/1.mp4": "1", "other/b.jpg": "b"})
+
+	if err := s.CopyFile(ctx, "media/a.jpg", "media/copy.jpg"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := s.DeleteFile(ctx, "media/clips/1.mp4"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	names, err := s.ListNames(ctx, "media/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"media/a.jpg", "media/copy.jpg"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected %v, got %v", want, names)
+	}
+
+	files, err := s.ListFiles(ctx, "media/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(files) != 2 || files[1].Name != "media/copy.jpg" || files[1].Size != 1 {
+		t.Errorf("Expected the copy to be listed with its size, got %+v", files)
+	}
+
+	if _, err := s.StatFile(ctx, "media/clips/1.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for the deleted file, got %v", err)
+	}
+	if err := s.DeleteFile(ctx, "media/clips/1.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting it again, got %v", err)
+	}
+}
+
+func TestGCSStorage_Access(t *testing.T) {
+	s := newEmulatedStorage(t)
+	ctx := context.Background()
+	writeEmulated(t, s, map[string]string{"media/a b.jpg": "a"})
+
+	access, err := s.GetAccess(ctx, "media/a b.jpg")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if access.Public {
+		t.Error("Expected new objects to be private")
+	}
+
+	access, err = s.SetAccess(ctx, "media/a b.jpg", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "https://storage.googleapis.com/test-bucket/media/a%20b.jpg"
+	if !access.Public || access.URL != want {
+		t.Errorf("Expected public access at %s, got %+v", want, access)
+	}
+	if access, err = s.GetAccess(ctx, "media/a b.jpg"); err != nil || !access.Public {
+		t.Errorf("Expected the object to be public, got %+v, %v", access, err)
+	}
+
+	for range 2 {
+		if access, err = s.SetAccess(ctx, "media/a b.jpg", false); err != nil || access.Public {
+			t.Errorf("Expected the object to be private, got %+v, %v", access, err)
+		}
+	}
+	if access, err = s.GetAccess(ctx, "media/a b.jpg"); err != nil || access.Public {
+		t.Errorf("Expected the object to be private, got %+v, %v", access, err)
+	}
+
+	if _, err := s.GetAccess(ctx, "media/missing.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
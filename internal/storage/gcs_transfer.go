@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"gcp-proxy-mity/internal/bufpool"
+	"gcp-proxy-mity/pkg/storage/gcs"
+
+	"cloud.google.com/go/storage"
+)
+
+type GCSTransfer struct {
+	buckets map[string]transferBucket
+}
+
+type transferBucket struct {
+	client      *gcs.Client
+	credentials gcs.Credentials
+}
+
+func NewGCSTransfer() *GCSTransfer {
+	return &GCSTransfer{
+		buckets: make(map[string]transferBucket),
+	}
+}
+
+// AddBucket makes the client's bucket available under name. Objects are copied
+// server-side between buckets added with the same credentials and streamed
+// through the proxy otherwise.
+func (t *GCSTransfer) AddBucket(name string, client *gcs.Client, credentials gcs.Credentials) {
+	t.buckets[name] = transferBucket{client: client, credentials: credentials}
+}
+
+func (t *GCSTransfer) HasBucket(name string) bool {
+	_, ok := t.buckets[name]
+	return ok
+}
+
+func (t *GCSTransfer) bucket(name string) (transferBucket, error) {
+	b, ok := t.buckets[name]
+	if !ok {
+		return transferBucket{}, fmt.Errorf("%w: %s", ErrUnknownBucket, name)
+	}
+	return b, nil
+}
+
+func (t *GCSTransfer) ListFiles(ctx context.Context, bucket, prefix string) ([]FileMetadata, error) {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return NewGCSStorage(b.client).ListFiles(ctx, prefix)
+}
+
+func (t *GCSTransfer) CopyFile(ctx context.Context, srcBucket, srcPath, dstBucket, dstPath string) error {
+	src, err := t.bucket(srcBucket)
+	if err != nil {
+		return err
+	}
+	dst, err := t.bucket(dstBucket)
+	if err != nil {
+		return err
+	}
+
+	dstObject := dst.client.GetBucket().Object(dstPath)
+	if src.credentials == dst.credentials {
+		// One identity reaches both buckets, so GCS rewrites the object without it passing through the proxy
+		srcObject := dst.client.StorageClient().Bucket(src.client.GetBucket().BucketName()).Object(srcPath)
+		if _, err := dstObject.CopierFrom(srcObject).Run(ctx); err != nil {
+			return fmt.Errorf("failed to copy object: %w", err)
+		}
+		return nil
+	}
+	return streamObject(ctx, src.client.GetBucket().Object(srcPath), dstObject)
+}
+
+// streamObject copies an object through the proxy, keeping its metadata and checking its CRC32C
+func streamObject(ctx context.Context, src, dst *storage.ObjectHandle) error {
+	attrs, err := src.Attrs(ctx)
+	if err != nil {
+		return err
+	}
+	// Compressed objects are copied as stored instead of being decompressed on the way
+	reader, err := src.Generation(attrs.Generation).ReadCompressed(true).NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+	defer reader.Close()
+
+	// Canceling the writer's context discards a partial upload
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writer := dst.NewWriter(writeCtx)
+	writer.ContentType = attrs.ContentType
+	writer.ContentEncoding = attrs.ContentEncoding
+	writer.ContentDisposition = attrs.ContentDisposition
+	writer.ContentLanguage = attrs.ContentLanguage
+	writer.CacheControl = attrs.CacheControl
+	writer.Metadata = attrs.Metadata
+	writer.CRC32C = attrs.CRC32C
+	writer.SendCRC32C = true
+
+	if _, err := bufpool.Copy(writer, reader); err != nil {
+		cancel()
+		writer.Close()
+		return fmt.Errorf("failed to copy object: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"cloud.google.com/go/storage"
+
+	"gcp-proxy-mity/internal/bufpool"
+	"gcp-proxy-mity/internal/metrics"
+)
+
+var (
+	parallelReads     = metrics.NewCounter("gcs_proxy_parallel_reads_total", "Reads of large objects split into ranges read concurrently")
+	parallelReadParts = metrics.NewCounter("gcs_proxy_parallel_read_parts_total", "Ranges read concurrently for parallel reads")
+)
+
+// ParallelReads splits reads of large objects into ranges read concurrently,
+// since a single stream from GCS is much slower than the network allows
+type ParallelReads struct {
+	// PartSize is the size of each range; reads up to it are made at once
+	PartSize int64
+	// Concurrency is how many ranges of a read are read at a time; 1 disables parallel reads
+	Concurrency int
+}
+
+// SetParallelReads enables parallel reads of objects larger than a part
+func (s *GCSStorage) SetParallelReads(p ParallelReads) {
+	s.parallel = p
+}
+
+// readContent reads length bytes at offset of obj, which names the
+// generation to read, into a buffer of that size. Content spanning several
+// parts is read in ranges concurrently, each into its place in the buffer.
+func (s *GCSStorage) readContent(ctx context.Context, obj *storage.ObjectHandle, offset, length int64) ([]byte, error) {
+	p := s.parallel
+	if p.Concurrency <= 1 || p.PartSize <= 0 || length <= p.PartSize {
+		reader, err := obj.NewRangeReader(ctx, offset, length)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create reader: %w", err)
+		}
+		defer reader.Close()
+		content, err := bufpool.ReadAll(reader, reader.Remain())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read content: %w", err)
+		}
+		return content, nil
+	}
+
+	parallelReads.Inc()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	content := make([]byte, length)
+	parts := make(chan int64)
+	errs := make(chan error, p.Concurrency)
+	var wg sync.WaitGroup
+	for range min(p.Concurrency, int((length+p.PartSize-1)/p.PartSize)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range parts {
+				part := content[start:min(start+p.PartSize, length)]
+				if err := readPart(ctx, obj, offset+start, part); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+				parallelReadParts.Inc()
+			}
+		}()
+	}
+	// Parts are handed out in order, so the start of the content arrives first
+dispatch:
+	for start := int64(0); start < length; start += p.PartSize {
+		select {
+		case parts <- start:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(parts)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// readPart fills part with the range of obj at offset
+func readPart(ctx context.Context, obj *storage.ObjectHandle, offset int64, part []byte) error {
+	reader, err := obj.NewRangeReader(ctx, offset, int64(len(part)))
+	if err != nil {
+		return fmt.Errorf("failed to create reader at %d: %w", offset, err)
+	}
+	defer reader.Close()
+	if _, err := io.ReadFull(reader, part); err != nil {
+		return fmt.Errorf("failed to read content at %d: %w", offset, err)
+	}
+	return nil
+}
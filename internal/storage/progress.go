@@ -0,0 +1,29 @@
+package storage
+
+import "context"
+
+type progressContextKey struct{}
+
+// WithProgress attaches a callback to the context that writes made with it
+// call with the bytes stored so far, as storage accepts them
+func WithProgress(ctx context.Context, progress func(written int64)) context.Context {
+	return context.WithValue(ctx, progressContextKey{}, progress)
+}
+
+// progressFromContext returns the progress callback of the context, nil if there is none
+func progressFromContext(ctx context.Context) func(int64) {
+	progress, _ := ctx.Value(progressContextKey{}).(func(int64))
+	return progress
+}
+
+// progressWriter reports the bytes written through it
+type progressWriter struct {
+	written int64
+	report  func(written int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	w.report(w.written)
+	return len(p), nil
+}
@@ -3,23 +3,52 @@ package storage
 import (
 	"context"
 	"io"
+	"time"
 )
 
 type FileMetadata struct {
-	Name        string
-	ContentType string
-	Size        int64
+	Name         string
+	ContentType  string
+	Size         int64
+	KMSKeyName   string `json:",omitempty"`
+	StorageClass string `json:",omitempty"`
+	Generation   int64  `json:",omitempty"`
+	// Updated is when the object was last written
+	Updated time.Time `json:",omitzero"`
+	// MD5 is the hex digest of the content; composite objects have none
+	MD5 string `json:",omitempty"`
+	// ContentEncoding is set for objects stored compressed, whose Size is that of the content before compression
+	ContentEncoding string `json:",omitempty"`
+	// Envelope is set for objects encrypted by the proxy, whose Size is that of the plaintext
+	Envelope bool `json:",omitempty"`
+	// ExpiresAt is when the object is deleted, if it was written with an expiry
+	ExpiresAt time.Time `json:",omitzero"`
 }
 
 type WriteRequest struct {
-	Path        string
-	Content     io.Reader
-	ContentType string
+	Path         string
+	Content      io.Reader
+	ContentType  string
+	Metadata     map[string]string
+	KMSKeyName   string
+	StorageClass string
+	// SHA256 is the hex digest of the content announced by the client, if any
+	SHA256 string
+	// CacheControl is stored as the object's Cache-Control, which GCS and CDNs serve it with
+	CacheControl string
+	// ContentEncoding compresses the content with gzip or zstd before it is stored
+	ContentEncoding string
+	// Envelope encrypts the content in the proxy with a new data key, instead of compressing it
+	Envelope bool
+	// ExpiresAt is recorded with the object for it to be deleted then
+	ExpiresAt time.Time
 }
 
 type WriteResponse struct {
 	FilesWritten []FileMetadata
 	Errors       []WriteError
+	// Deduplicated lists paths copied from an existing object with the same content instead of uploaded
+	Deduplicated []string `json:",omitempty"`
 }
 
 type WriteError struct {
@@ -35,6 +64,42 @@ type ReadResponse struct {
 type FileData struct {
 	Metadata FileMetadata
 	Content  []byte
+	// Offset is where Content starts in the object when only a range was read
+	Offset int64 `json:",omitempty"`
+	// ContentEncoding is set when Content is still compressed, see WithAcceptedEncodings
+	ContentEncoding string `json:",omitempty"`
+}
+
+// ReadRange selects part of an object. A negative Offset counts back from the
+// end of the object; a Length of 0 reads to the end.
+type ReadRange struct {
+	Path   string
+	Offset int64
+	Length int64
+}
+
+// Bounds returns where the range starts in an object of size bytes and how many bytes it covers
+func (r ReadRange) Bounds(size int64) (offset, length int64) {
+	offset = r.Offset
+	if offset < 0 {
+		offset = max(size+offset, 0)
+	}
+	length = max(size-offset, 0)
+	if r.Length > 0 {
+		length = min(length, r.Length)
+	}
+	return offset, length
+}
+
+// FileReader streams the content of an object
+type FileReader struct {
+	io.ReadCloser
+	Metadata FileMetadata
+	// Custom is the object's custom metadata
+	Custom       map[string]string
+	CacheControl string
+	// ContentEncoding is set for objects stored compressed, which are read as stored
+	ContentEncoding string
 }
 
 type ReadError struct {
@@ -42,8 +107,61 @@ type ReadError struct {
 	Error    string
 }
 
+// Retention describes the holds and retention period that prevent an object from being deleted or replaced
+type Retention struct {
+	TemporaryHold       bool       `json:"temporary_hold"`
+	EventBasedHold      bool       `json:"event_based_hold"`
+	RetentionExpiration *time.Time `json:"retention_expiration,omitempty"`
+	RetentionMode       string     `json:"retention_mode,omitempty"`
+	RetainUntil         *time.Time `json:"retain_until,omitempty"`
+}
+
+// HoldUpdate places (true) or releases (false) holds; nil leaves a hold unchanged
+type HoldUpdate struct {
+	TemporaryHold  *bool `json:"temporary_hold"`
+	EventBasedHold *bool `json:"event_based_hold"`
+}
+
+// Access describes whether anyone may read an object without credentials
+type Access struct {
+	Public bool `json:"public"`
+	// URL is where anyone can read the object while it is public
+	URL string `json:"url,omitempty"`
+}
+
 type Storage interface {
 	WriteFiles(ctx context.Context, requests []WriteRequest) (*WriteResponse, error)
+	// CreateFile writes a new object, failing with ErrExists if there is one at the path
+	CreateFile(ctx context.Context, req WriteRequest) (*FileMetadata, error)
 	ReadFiles(ctx context.Context, filePaths []string) (*ReadResponse, error)
 	ReadFile(ctx context.Context, filePath string) (*FileData, error)
+	// ReadRanges reads part of each object, reporting failures per range like ReadFiles
+	ReadRanges(ctx context.Context, ranges []ReadRange) (*ReadResponse, error)
+	StatFile(ctx context.Context, filePath string) (*FileMetadata, error)
+	ListFiles(ctx context.Context, prefix string) ([]FileMetadata, error)
+	// ListNames lists only the names of the objects under prefix, which is cheaper than ListFiles
+	ListNames(ctx context.Context, prefix string) ([]string, error)
+	// WalkFiles calls fn for each object under prefix in lexical order as the
+	// listing is paged in, stopping at the first error fn returns
+	WalkFiles(ctx context.Context, prefix string, fn func(FileMetadata) error) error
+	CopyFile(ctx context.Context, srcPath, dstPath string) error
+	// ComposeFiles concatenates the source objects, in order, into the object described by dst
+	ComposeFiles(ctx context.Context, dst WriteRequest, srcPaths []string) (*FileMetadata, error)
+	DeleteFile(ctx context.Context, filePath string) error
+	SetStorageClass(ctx context.Context, filePath, storageClass string) (*FileMetadata, error)
+	GetRetention(ctx context.Context, filePath string) (*Retention, error)
+	SetHolds(ctx context.Context, filePath string, update HoldUpdate) (*Retention, error)
+	// GetAccess reports whether an object is readable by anyone
+	GetAccess(ctx context.Context, filePath string) (*Access, error)
+	// SetAccess grants or revokes public read access to an object
+	SetAccess(ctx context.Context, filePath string, public bool) (*Access, error)
+	// GetMetadata returns the custom metadata of an object
+	GetMetadata(ctx context.Context, filePath string) (map[string]string, error)
+	// UpdateMetadata sets the given custom metadata keys, keeping the object's other keys
+	UpdateMetadata(ctx context.Context, filePath string, metadata map[string]string) error
+	// SignedUploadURL returns a URL accepting a PUT of the object with the request's content type and metadata
+	SignedUploadURL(ctx context.Context, req WriteRequest, expires time.Time) (string, error)
+	// SignedDownloadURL returns a URL accepting a GET of the object, answered
+	// with disposition as its Content-Disposition when it is set
+	SignedDownloadURL(ctx context.Context, filePath, disposition string, expires time.Time) (string, error)
 }
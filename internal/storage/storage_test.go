@@ -3,9 +3,17 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"io"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"gcp-proxy-mity/internal/cache"
 )
 
 // mockStorage is a mock implementation of Storage for testing
@@ -13,6 +21,9 @@ type mockStorage struct {
 	writeFilesFunc func(ctx context.Context, requests []WriteRequest) (*WriteResponse, error)
 	readFilesFunc  func(ctx context.Context, filePaths []string) (*ReadResponse, error)
 	readFileFunc   func(ctx context.Context, filePath string) (*FileData, error)
+	readRangesFunc func(ctx context.Context, ranges []ReadRange) (*ReadResponse, error)
+	listFilesFunc  func(ctx context.Context, prefix string) ([]FileMetadata, error)
+	statFileFunc   func(ctx context.Context, filePath string) (*FileMetadata, error)
 }
 
 func (m *mockStorage) WriteFiles(ctx context.Context, requests []WriteRequest) (*WriteResponse, error) {
@@ -22,6 +33,10 @@ func (m *mockStorage) WriteFiles(ctx context.Context, requests []WriteRequest) (
 	return nil, nil
 }
 
+func (m *mockStorage) CreateFile(ctx context.Context, req WriteRequest) (*FileMetadata, error) {
+	return &FileMetadata{Name: req.Path}, nil
+}
+
 func (m *mockStorage) ReadFiles(ctx context.Context, filePaths []string) (*ReadResponse, error) {
 	if m.readFilesFunc != nil {
 		return m.readFilesFunc(ctx, filePaths)
@@ -36,6 +51,83 @@ func (m *mockStorage) ReadFile(ctx context.Context, filePath string) (*FileData,
 	return nil, nil
 }
 
+func (m *mockStorage) StatFile(ctx context.Context, filePath string) (*FileMetadata, error) {
+	if m.statFileFunc != nil {
+		return m.statFileFunc(ctx, filePath)
+	}
+	return nil, nil
+}
+
+func (m *mockStorage) ListFiles(ctx context.Context, prefix string) ([]FileMetadata, error) {
+	if m.listFilesFunc != nil {
+		return m.listFilesFunc(ctx, prefix)
+	}
+	return nil, nil
+}
+
+func (m *mockStorage) ListNames(ctx context.Context, prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) WalkFiles(ctx context.Context, prefix string, fn func(FileMetadata) error) error {
+	return nil
+}
+
+func (m *mockStorage) CopyFile(ctx context.Context, srcPath, dstPath string) error {
+	return nil
+}
+
+func (m *mockStorage) SetStorageClass(ctx context.Context, filePath, storageClass string) (*FileMetadata, error) {
+	return &FileMetadata{Name: filePath, StorageClass: storageClass}, nil
+}
+
+func (m *mockStorage) GetRetention(ctx context.Context, filePath string) (*Retention, error) {
+	return &Retention{}, nil
+}
+
+func (m *mockStorage) SetHolds(ctx context.Context, filePath string, update HoldUpdate) (*Retention, error) {
+	return &Retention{}, nil
+}
+
+func (m *mockStorage) GetAccess(ctx context.Context, filePath string) (*Access, error) {
+	return &Access{}, nil
+}
+
+func (m *mockStorage) SetAccess(ctx context.Context, filePath string, public bool) (*Access, error) {
+	return &Access{Public: public}, nil
+}
+
+func (m *mockStorage) ReadRanges(ctx context.Context, ranges []ReadRange) (*ReadResponse, error) {
+	if m.readRangesFunc != nil {
+		return m.readRangesFunc(ctx, ranges)
+	}
+	return nil, nil
+}
+
+func (m *mockStorage) GetMetadata(ctx context.Context, filePath string) (map[string]string, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) UpdateMetadata(ctx context.Context, filePath string, metadata map[string]string) error {
+	return nil
+}
+
+func (m *mockStorage) ComposeFiles(ctx context.Context, dst WriteRequest, srcPaths []string) (*FileMetadata, error) {
+	return &FileMetadata{Name: dst.Path, ContentType: dst.ContentType}, nil
+}
+
+func (m *mockStorage) SignedUploadURL(ctx context.Context, req WriteRequest, expires time.Time) (string, error) {
+	return "https://storage.googleapis.com/bucket/" + req.Path, nil
+}
+
+func (m *mockStorage) SignedDownloadURL(ctx context.Context, filePath, disposition string, expires time.Time) (string, error) {
+	return "https://storage.googleapis.com/bucket/" + filePath, nil
+}
+
+func (m *mockStorage) DeleteFile(ctx context.Context, filePath string) error {
+	return nil
+}
+
 func TestStorage_WriteFiles_Success(t *testing.T) {
 	mock := &mockStorage{
 		writeFilesFunc: func(ctx context.Context, requests []WriteRequest) (*WriteResponse, error) {
@@ -223,4 +315,296 @@ type mockError struct {
 
 func (e *mockError) Error() string {
 	return e.message
-}
\ No newline at end of file
+}
+func TestReadRange_Bounds(t *testing.T) {
+	tests := []struct {
+		name       string
+		r          ReadRange
+		wantOffset int64
+		wantLength int64
+	}{
+		{name: "whole object", r: ReadRange{}, wantOffset: 0, wantLength: 100},
+		{name: "head", r: ReadRange{Length: 10}, wantOffset: 0, wantLength: 10},
+		{name: "middle", r: ReadRange{Offset: 20, Length: 10}, wantOffset: 20, wantLength: 10},
+		{name: "past the end", r: ReadRange{Offset: 95, Length: 10}, wantOffset: 95, wantLength: 5},
+		{name: "tail", r: ReadRange{Offset: -10}, wantOffset: 90, wantLength: 10},
+		{name: "tail larger than object", r: ReadRange{Offset: -200, Length: 50}, wantOffset: 0, wantLength: 50},
+		{name: "at the end", r: ReadRange{Offset: 100}, wantOffset: 100, wantLength: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset, length := tt.r.Bounds(100)
+			if offset != tt.wantOffset || length != tt.wantLength {
+				t.Errorf("Expected offset %d and length %d, got %d and %d", tt.wantOffset, tt.wantLength, offset, length)
+			}
+		})
+	}
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	sum := sha256.Sum256(key)
+	hash := base64.StdEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name        string
+		key         string
+		hash        string
+		expectError bool
+	}{
+		{name: "valid key", key: encoded},
+		{name: "valid key and hash", key: encoded, hash: hash},
+		{name: "wrong hash", key: encoded, hash: base64.StdEncoding.EncodeToString(make([]byte, 32)), expectError: true},
+		{name: "short key", key: base64.StdEncoding.EncodeToString(key[:16]), expectError: true},
+		{name: "not base64", key: "not-a-key!", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseEncryptionKey(tt.key, tt.hash)
+
+			if tt.expectError {
+				if !errors.Is(err, ErrInvalidEncryptionKey) {
+					t.Errorf("Expected ErrInvalidEncryptionKey, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(parsed, key) {
+				t.Error("Parsed key does not match")
+			}
+		})
+	}
+}
+
+func TestCachedStorage_ReadFile(t *testing.T) {
+	reads := 0
+	generation := int64(1)
+	mock := &mockStorage{
+		readFileFunc: func(ctx context.Context, filePath string) (*FileData, error) {
+			reads++
+			return &FileData{
+				Metadata: FileMetadata{Name: filePath, ContentType: "image/jpeg", Size: 4, Generation: generation},
+				Content:  []byte("data"),
+			}, nil
+		},
+		statFileFunc: func(ctx context.Context, filePath string) (*FileMetadata, error) {
+			return &FileMetadata{Name: filePath, ContentType: "image/jpeg", Size: 4, Generation: generation}, nil
+		},
+		writeFilesFunc: func(ctx context.Context, requests []WriteRequest) (*WriteResponse, error) {
+			generation++
+			return &WriteResponse{}, nil
+		},
+	}
+	ctx := context.Background()
+	s := NewCachedStorage(mock, cache.NewLRU(1<<10, time.Minute), 1<<10)
+
+	first, _ := s.ReadFile(ctx, "thumbs/a.jpg")
+	second, _ := s.ReadFile(ctx, "thumbs/a.jpg")
+	if reads != 1 {
+		t.Fatalf("Expected second read to be served from cache, got %d reads", reads)
+	}
+	if second.Metadata != first.Metadata || string(second.Content) != "data" {
+		t.Errorf("Expected cached copy of %+v, got %+v", first.Metadata, second.Metadata)
+	}
+
+	s.WriteFiles(ctx, []WriteRequest{{Path: "thumbs/a.jpg"}})
+	if data, _ := s.ReadFile(ctx, "thumbs/a.jpg"); data.Metadata.Generation != 2 {
+		t.Errorf("Expected a fresh read after write, got generation %d", data.Metadata.Generation)
+	}
+
+	// A write made directly in the bucket doesn't go through the cache
+	generation++
+	if data, _ := s.ReadFile(ctx, "thumbs/a.jpg"); data.Metadata.Generation != 3 || reads != 3 {
+		t.Errorf("Expected a fresh read after the object changed in the bucket, got generation %d after %d reads", data.Metadata.Generation, reads)
+	}
+
+	s.ReadFile(WithEncryptionKey(ctx, make([]byte, 32)), "thumbs/a.jpg")
+	if reads != 4 {
+		t.Errorf("Expected reads with an encryption key to bypass the cache, got %d reads", reads)
+	}
+
+	small := NewCachedStorage(mock, cache.NewLRU(1<<10, time.Minute), 2)
+	small.ReadFile(ctx, "thumbs/a.jpg")
+	small.ReadFile(ctx, "thumbs/a.jpg")
+	if reads != 6 {
+		t.Errorf("Expected objects over the size limit not to be cached, got %d reads", reads)
+	}
+}
+
+func TestCoalescedStorage(t *testing.T) {
+	var reads atomic.Int64
+	release := make(chan struct{})
+	mock := &mockStorage{
+		readFileFunc: func(ctx context.Context, filePath string) (*FileData, error) {
+			n := reads.Add(1)
+			<-release
+			return &FileData{
+				Metadata: FileMetadata{Name: filePath, ContentType: "image/jpeg", Size: 4, Generation: n},
+				Content:  []byte("data"),
+			}, nil
+		},
+		readRangesFunc: func(ctx context.Context, ranges []ReadRange) (*ReadResponse, error) {
+			reads.Add(1)
+			<-release
+			return &ReadResponse{Files: []FileData{{Metadata: FileMetadata{Name: ranges[0].Path}, Content: []byte("at"), Offset: ranges[0].Offset}}}, nil
+		},
+		writeFilesFunc: func(ctx context.Context, requests []WriteRequest) (*WriteResponse, error) {
+			return &WriteResponse{}, nil
+		},
+	}
+	s := NewCoalescedStorage(mock)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		read      func(ctx context.Context) (string, error)
+		wantReads int64
+	}{
+		{
+			name:      "same file",
+			ctx:       ctx,
+			read:      readContent(s, "thumbs/a.jpg"),
+			wantReads: 1,
+		},
+		{
+			name: "same range",
+			ctx:  ctx,
+			read: func(ctx context.Context) (string, error) {
+				resp, err := s.ReadRanges(ctx, []ReadRange{{Path: "thumbs/a.jpg", Offset: 1, Length: 2}})
+				if err != nil {
+					return "", err
+				}
+				return string(resp.Files[0].Content), nil
+			},
+			wantReads: 1,
+		},
+		{
+			name:      "encryption key",
+			ctx:       WithEncryptionKey(ctx, make([]byte, 32)),
+			read:      readContent(s, "thumbs/a.jpg"),
+			wantReads: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reads.Store(0)
+			release = make(chan struct{})
+			var wg sync.WaitGroup
+			errs := make(chan error, 5)
+			for range 5 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := tt.read(tt.ctx); err != nil {
+						errs <- err
+					}
+				}()
+			}
+			// Let the readers pile up behind the first read, or each start their own
+			for reads.Load() < tt.wantReads || tt.wantReads == 1 && s.waiters() < 5 {
+				time.Sleep(time.Millisecond)
+			}
+			close(release)
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if got := reads.Load(); got != tt.wantReads {
+				t.Errorf("Expected %d reads of the bucket, got %d", tt.wantReads, got)
+			}
+		})
+	}
+}
+
+func TestCoalescedStorage_Invalidate(t *testing.T) {
+	var reads atomic.Int64
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	mock := &mockStorage{
+		readFileFunc: func(ctx context.Context, filePath string) (*FileData, error) {
+			n := reads.Add(1)
+			started <- struct{}{}
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return &FileData{Metadata: FileMetadata{Name: filePath, Generation: n}, Content: []byte("data")}, nil
+		},
+		writeFilesFunc: func(ctx context.Context, requests []WriteRequest) (*WriteResponse, error) {
+			return &WriteResponse{}, nil
+		},
+	}
+	s := NewCoalescedStorage(mock)
+	ctx := context.Background()
+
+	// The caller starting a read gives up, but the read goes on for another one
+	first, cancel := context.WithCancel(ctx)
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := s.ReadFile(first, "thumbs/a.jpg")
+		firstErr <- err
+	}()
+	<-started
+	second := make(chan *FileData, 1)
+	go func() {
+		file, _ := s.ReadFile(ctx, "thumbs/a.jpg")
+		second <- file
+	}()
+	for s.waiters() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the first caller to give up, got %v", err)
+	}
+
+	// A read after a write doesn't join the read of the old content
+	s.WriteFiles(ctx, []WriteRequest{{Path: "thumbs/a.jpg"}})
+	third := make(chan *FileData, 1)
+	go func() {
+		file, _ := s.ReadFile(ctx, "thumbs/a.jpg")
+		third <- file
+	}()
+	<-started
+	close(release)
+
+	if file := <-second; file == nil || file.Metadata.Generation != 1 {
+		t.Errorf("Expected the second caller to get generation 1, got %+v", file)
+	}
+	if file := <-third; file == nil || file.Metadata.Generation != 2 {
+		t.Errorf("Expected a fresh read after the write, got %+v", file)
+	}
+}
+
+// waiters counts the callers waiting for reads in progress
+func (s *CoalescedStorage) waiters() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, f := range s.flights {
+		n += f.waiters
+	}
+	return n
+}
+
+// readContent returns a read of filePath through s
+func readContent(s *CoalescedStorage, filePath string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		file, err := s.ReadFile(ctx, filePath)
+		if err != nil {
+			return "", err
+		}
+		return string(file.Content), nil
+	}
+}
@@ -0,0 +1,118 @@
+package streaming
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// contentTypes maps streaming manifest and segment extensions to MIME types
+var contentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".m3u":  "application/vnd.apple.mpegurl",
+	".mpd":  "application/dash+xml",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+	".m4a":  "audio/mp4",
+	".m4v":  "video/mp4",
+	".aac":  "audio/aac",
+	".vtt":  "text/vtt",
+	".key":  "application/octet-stream",
+}
+
+// ContentType returns the MIME type for a streaming file, or "" if the extension is unknown
+func ContentType(filePath string) string {
+	return contentTypes[strings.ToLower(filepath.Ext(filePath))]
+}
+
+// IsPlaylist reports whether the file is an HLS playlist or DASH manifest that needs rewriting
+func IsPlaylist(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".m3u8", ".m3u", ".mpd":
+		return true
+	}
+	return false
+}
+
+// Rewriter rewrites references inside playlists so they resolve to proxy routes
+type Rewriter struct {
+	// Bucket is the bucket whose gs:// URLs are served by the proxy
+	Bucket string
+	// BaseURL is the route prefix under which stream files are served, e.g. /api/v1/storage/stream/
+	BaseURL string
+}
+
+var uriAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// Rewrite rewrites a playlist or manifest stored at playlistPath
+func (rw Rewriter) Rewrite(playlistPath string, content []byte) []byte {
+	if strings.ToLower(filepath.Ext(playlistPath)) == ".mpd" {
+		return rw.rewriteDASH(content)
+	}
+	return rw.rewriteHLS(playlistPath, content)
+}
+
+// rewriteHLS rewrites segment and variant URIs as well as URI attributes of tags
+// such as EXT-X-KEY, EXT-X-MAP and EXT-X-MEDIA
+func (rw Rewriter) rewriteHLS(playlistPath string, content []byte) []byte {
+	dir := path.Dir(playlistPath)
+	var out bytes.Buffer
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "#"):
+			line = uriAttribute.ReplaceAllStringFunc(line, func(attr string) string {
+				uri := uriAttribute.FindStringSubmatch(attr)[1]
+				return `URI="` + rw.resolve(dir, uri) + `"`
+			})
+		default:
+			line = rw.resolve(dir, trimmed)
+		}
+
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+
+	return out.Bytes()
+}
+
+// rewriteDASH replaces gs:// URLs of the configured bucket; relative references
+// already resolve against the manifest URL when served through the proxy
+func (rw Rewriter) rewriteDASH(content []byte) []byte {
+	if rw.Bucket == "" {
+		return content
+	}
+	return bytes.ReplaceAll(content, []byte("gs://"+rw.Bucket+"/"), []byte(rw.BaseURL))
+}
+
+// resolve maps a playlist reference to a proxy URL
+func (rw Rewriter) resolve(dir, uri string) string {
+	if strings.HasPrefix(uri, "gs://") {
+		rest := strings.TrimPrefix(uri, "gs://")
+		bucket, objectPath, ok := strings.Cut(rest, "/")
+		if !ok || bucket != rw.Bucket {
+			return uri
+		}
+		return rw.BaseURL + objectPath
+	}
+
+	// Absolute URLs and data URIs are left untouched
+	if strings.Contains(uri, "://") || strings.HasPrefix(uri, "data:") {
+		return uri
+	}
+
+	if strings.HasPrefix(uri, "/") {
+		return rw.BaseURL + strings.TrimPrefix(path.Clean(uri), "/")
+	}
+	return rw.BaseURL + strings.TrimPrefix(path.Join(dir, uri), "/")
+}
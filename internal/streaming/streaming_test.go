@@ -0,0 +1,68 @@
+package streaming
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriter_HLS(t *testing.T) {
+	rw := Rewriter{Bucket: "media", BaseURL: "/api/v1/storage/stream/"}
+
+	playlist := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:7",
+		`#EXT-X-MAP:URI="init.mp4"`,
+		`#EXT-X-KEY:METHOD=AES-128,URI="gs://media/keys/k1.key"`,
+		"#EXTINF:6.0,",
+		"seg-001.m4s",
+		"#EXTINF:6.0,",
+		"gs://media/videos/show/seg-002.m4s",
+		"#EXTINF:6.0,",
+		"gs://other-bucket/seg-003.m4s",
+		"#EXTINF:6.0,",
+		"https://cdn.example.com/seg-004.m4s",
+		"../shared/seg-005.m4s",
+	}, "\n")
+
+	got := string(rw.Rewrite("videos/show/index.m3u8", []byte(playlist)))
+
+	expected := []string{
+		`#EXT-X-MAP:URI="/api/v1/storage/stream/videos/show/init.mp4"`,
+		`#EXT-X-KEY:METHOD=AES-128,URI="/api/v1/storage/stream/keys/k1.key"`,
+		"/api/v1/storage/stream/videos/show/seg-001.m4s",
+		"/api/v1/storage/stream/videos/show/seg-002.m4s",
+		"gs://other-bucket/seg-003.m4s",
+		"https://cdn.example.com/seg-004.m4s",
+		"/api/v1/storage/stream/videos/shared/seg-005.m4s",
+	}
+	for _, line := range expected {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("Expected rewritten playlist to contain %q, got:\n%s", line, got)
+		}
+	}
+}
+
+func TestRewriter_DASH(t *testing.T) {
+	rw := Rewriter{Bucket: "media", BaseURL: "/api/v1/storage/stream/"}
+	manifest := `<MPD><BaseURL>gs://media/videos/show/</BaseURL></MPD>`
+
+	got := string(rw.Rewrite("videos/show/manifest.mpd", []byte(manifest)))
+	if got != `<MPD><BaseURL>/api/v1/storage/stream/videos/show/</BaseURL></MPD>` {
+		t.Errorf("Unexpected manifest: %s", got)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tests := map[string]string{
+		"a/index.m3u8":   "application/vnd.apple.mpegurl",
+		"a/seg.TS":       "video/mp2t",
+		"a/seg.m4s":      "video/iso.segment",
+		"a/manifest.mpd": "application/dash+xml",
+		"a/file.bin":     "",
+	}
+	for filePath, expected := range tests {
+		if got := ContentType(filePath); got != expected {
+			t.Errorf("ContentType(%s) = '%s', expected '%s'", filePath, got, expected)
+		}
+	}
+}
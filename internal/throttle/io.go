@@ -0,0 +1,100 @@
+package throttle
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// Reader is an io.Reader that reads no faster than all of its limiters allow,
+// counting one token per byte
+type Reader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rate.Limiter
+}
+
+// NewReader throttles r. Waiting ends with the context's error when ctx is done.
+func NewReader(ctx context.Context, r io.Reader, limiters ...*rate.Limiter) *Reader {
+	return &Reader{ctx: ctx, r: r, limiters: limiters}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	// Small reads keep the bytes in flight within what a limiter can grant at once
+	if chunk := chunkSize(r.limiters); chunk > 0 && len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := wait(r.ctx, r.limiters, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Writer is an io.Writer that writes no faster than all of its limiters allow,
+// counting one token per byte
+type Writer struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*rate.Limiter
+}
+
+// NewWriter throttles w. Waiting ends with the context's error when ctx is done.
+func NewWriter(ctx context.Context, w io.Writer, limiters ...*rate.Limiter) *Writer {
+	return &Writer{ctx: ctx, w: w, limiters: limiters}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := len(p)
+		if size := chunkSize(w.limiters); size > 0 && chunk > size {
+			chunk = size
+		}
+		if err := wait(w.ctx, w.limiters, chunk); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(p[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[chunk:]
+	}
+	return written, nil
+}
+
+// chunkSize is the smallest burst of the limiters, 0 if none of them limits
+func chunkSize(limiters []*rate.Limiter) int {
+	size := 0
+	for _, l := range limiters {
+		if l.Limit() == rate.Inf {
+			continue
+		}
+		if burst := l.Burst(); size == 0 || burst < size {
+			size = burst
+		}
+	}
+	return size
+}
+
+// wait blocks until every limiter granted n tokens
+func wait(ctx context.Context, limiters []*rate.Limiter, n int) error {
+	for _, l := range limiters {
+		if l.Limit() == rate.Inf {
+			continue
+		}
+		// The burst may have shrunk since the chunk was sized
+		for remaining := n; remaining > 0; {
+			tokens := min(remaining, max(l.Burst(), 1))
+			if err := l.WaitN(ctx, tokens); err != nil {
+				return err
+			}
+			remaining -= tokens
+		}
+	}
+	return nil
+}
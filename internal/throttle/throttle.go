@@ -0,0 +1,202 @@
+package throttle
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gcp-proxy-mity/internal/ratelimit"
+
+	"golang.org/x/time/rate"
+)
+
+// idleTimeout is how long the limiters of a tenant without requests are kept
+const idleTimeout = 10 * time.Minute
+
+// Limits are transfer rates in bytes per second; 0 is unlimited
+type Limits struct {
+	Download int64
+	Upload   int64
+}
+
+// Route replaces the per-request limits for paths starting with Prefix
+type Route struct {
+	Prefix string
+	Limits
+}
+
+// Config shapes the bandwidth of requests
+type Config struct {
+	// Request limits every request on its own
+	Request Limits
+	// Tenant limits all concurrent requests of a client together
+	Tenant Limits
+	Routes []Route
+}
+
+type tenant struct {
+	download *rate.Limiter
+	upload   *rate.Limiter
+	active   int
+	lastSeen time.Time
+}
+
+// Throttle slows request bodies and responses down to the configured rates, so
+// that one client pulling large files can't starve the others. Tenants are keyed
+// like the rate limiter, by API key or remote IP.
+type Throttle struct {
+	mu      sync.Mutex
+	cfg     Config
+	tenants map[string]*tenant
+	swept   time.Time
+}
+
+// New creates a throttle
+func New(cfg Config) *Throttle {
+	t := &Throttle{
+		tenants: make(map[string]*tenant),
+	}
+	t.SetConfig(cfg)
+	return t
+}
+
+// SetConfig changes the limits at runtime, including for requests in progress of a tenant
+func (t *Throttle) SetConfig(cfg Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.cfg = cfg
+	for _, tn := range t.tenants {
+		setRate(tn.download, cfg.Tenant.Download)
+		setRate(tn.upload, cfg.Tenant.Upload)
+	}
+}
+
+// Middleware throttles request bodies and response bodies
+func (t *Throttle) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := ratelimit.ClientKey(r)
+		download, upload := t.acquire(key, r.URL.Path)
+		defer t.release(key)
+
+		if len(upload) > 0 && r.Body != nil && r.Body != http.NoBody {
+			r.Body = &readCloser{Reader: NewReader(r.Context(), r.Body, upload...), Closer: r.Body}
+		}
+		if len(download) > 0 {
+			w = &responseWriter{ResponseWriter: w, throttled: NewWriter(r.Context(), w, download...)}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire returns the limiters applying to a request of the tenant, which is
+// kept until the request is released
+func (t *Throttle) acquire(key, path string) (download, upload []*rate.Limiter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.sweep(now)
+
+	tn, ok := t.tenants[key]
+	if !ok {
+		tn = &tenant{download: newLimiter(t.cfg.Tenant.Download), upload: newLimiter(t.cfg.Tenant.Upload)}
+		t.tenants[key] = tn
+	}
+	tn.active++
+	tn.lastSeen = now
+
+	limits := t.cfg.Request
+	matched := -1
+	for _, route := range t.cfg.Routes {
+		if strings.HasPrefix(path, route.Prefix) && len(route.Prefix) > matched {
+			limits, matched = route.Limits, len(route.Prefix)
+		}
+	}
+
+	// Limiters of unlimited tenants are kept so a reload can still limit them
+	if limits.Download > 0 {
+		download = append(download, newLimiter(limits.Download))
+	}
+	if t.cfg.Tenant.Download > 0 {
+		download = append(download, tn.download)
+	}
+	if limits.Upload > 0 {
+		upload = append(upload, newLimiter(limits.Upload))
+	}
+	if t.cfg.Tenant.Upload > 0 {
+		upload = append(upload, tn.upload)
+	}
+	return download, upload
+}
+
+func (t *Throttle) release(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tn, ok := t.tenants[key]; ok {
+		tn.active--
+		tn.lastSeen = time.Now()
+	}
+}
+
+// sweep drops the limiters of idle tenants; callers hold the lock
+func (t *Throttle) sweep(now time.Time) {
+	if now.Sub(t.swept) < time.Minute {
+		return
+	}
+	t.swept = now
+	for key, tn := range t.tenants {
+		if tn.active == 0 && now.Sub(tn.lastSeen) > idleTimeout {
+			delete(t.tenants, key)
+		}
+	}
+}
+
+// newLimiter allows bytesPerSecond with a burst of one second's worth
+func newLimiter(bytesPerSecond int64) *rate.Limiter {
+	l := rate.NewLimiter(rate.Inf, 0)
+	setRate(l, bytesPerSecond)
+	return l
+}
+
+func setRate(l *rate.Limiter, bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		l.SetLimit(rate.Inf)
+		return
+	}
+	l.SetLimit(rate.Limit(bytesPerSecond))
+	l.SetBurst(int(bytesPerSecond))
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// responseWriter throttles the response body. It passes flushes and hijacks
+// through, so streaming responses and WebSockets keep working.
+type responseWriter struct {
+	http.ResponseWriter
+	throttled io.Writer
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	return w.throttled.Write(p)
+}
+
+func (w *responseWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,123 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(context.Background(), &buf, newLimiter(10000))
+
+	start := time.Now()
+	n, err := w.Write(make([]byte, 15000))
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 15000 || buf.Len() != 15000 {
+		t.Errorf("Expected 15000 bytes written, got %d and %d", n, buf.Len())
+	}
+	// The first second's worth is the burst, the rest takes half a second
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected about 500ms, got %s", elapsed)
+	}
+}
+
+func TestReader_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewReader(ctx, strings.NewReader(strings.Repeat("x", 300)), newLimiter(100))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err := io.ReadAll(r)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestReader_Unlimited(t *testing.T) {
+	r := NewReader(context.Background(), strings.NewReader("hello"), newLimiter(0))
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Expected hello, got %q and %v", data, err)
+	}
+}
+
+func TestThrottle_Limiters(t *testing.T) {
+	throttle := New(Config{
+		Request: Limits{Download: 1000, Upload: 500},
+		Tenant:  Limits{Download: 5000},
+		Routes: []Route{
+			{Prefix: "/api/v1/storage/", Limits: Limits{Download: 2000}},
+			{Prefix: "/api/v1/storage/stream/"},
+		},
+	})
+
+	tests := []struct {
+		name         string
+		path         string
+		wantDownload []float64
+		wantUpload   []float64
+	}{
+		{name: "default", path: "/health", wantDownload: []float64{1000, 5000}, wantUpload: []float64{500}},
+		{name: "route", path: "/api/v1/storage/files/a.mp4", wantDownload: []float64{2000, 5000}},
+		{name: "longest route", path: "/api/v1/storage/stream/a.m3u8", wantDownload: []float64{5000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			download, upload := throttle.acquire("principal:acme", tt.path)
+			defer throttle.release("principal:acme")
+
+			var gotDownload, gotUpload []float64
+			for _, l := range download {
+				gotDownload = append(gotDownload, float64(l.Limit()))
+			}
+			for _, l := range upload {
+				gotUpload = append(gotUpload, float64(l.Limit()))
+			}
+			if !slices.Equal(gotDownload, tt.wantDownload) || !slices.Equal(gotUpload, tt.wantUpload) {
+				t.Errorf("Expected %v and %v, got %v and %v", tt.wantDownload, tt.wantUpload, gotDownload, gotUpload)
+			}
+		})
+	}
+
+	// Concurrent requests of a tenant share its limiter
+	a, _ := throttle.acquire("principal:acme", "/health")
+	b, _ := throttle.acquire("principal:acme", "/health")
+	c, _ := throttle.acquire("principal:other", "/health")
+	if a[1] != b[1] || a[1] == c[1] {
+		t.Error("Expected one tenant limiter per client")
+	}
+
+	throttle.SetConfig(Config{Tenant: Limits{Download: 100}})
+	if a[1].Limit() != 100 {
+		t.Errorf("Expected reload to change tenant limits in use, got %v", a[1].Limit())
+	}
+}
+
+func TestThrottle_Middleware(t *testing.T) {
+	throttle := New(Config{Request: Limits{Download: 1 << 20, Upload: 1 << 20}})
+	handler := throttle.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(bytes.ToUpper(body))
+		w.(http.Flusher).Flush()
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello")))
+	if rec.Body.String() != "HELLO" || !rec.Flushed {
+		t.Errorf("Expected flushed HELLO, got %q (flushed %v)", rec.Body.String(), rec.Flushed)
+	}
+}
@@ -0,0 +1,8 @@
+package tlsconfig
+
+import "errors"
+
+var (
+	ErrNoCertificate   = errors.New("TLS requires a certificate and key or autocert domains")
+	ErrInvalidClientCA = errors.New("client CA file contains no certificates")
+)
@@ -0,0 +1,125 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Client authentication modes for mTLS
+const (
+	ClientAuthRequire  = "require"
+	ClientAuthOptional = "optional"
+)
+
+// Options describes how the server obtains its certificate and verifies clients
+type Options struct {
+	CertFile string
+	KeyFile  string
+
+	// AutocertDomains enables Let's Encrypt certificates for the listed hosts
+	AutocertDomains  []string
+	AutocertCacheDir string
+	AutocertEmail    string
+
+	// ClientCAFile enables mTLS; client certificates must be signed by one of its CAs
+	ClientCAFile string
+	ClientAuth   string
+}
+
+// New builds the server TLS configuration
+func New(opts Options) (*tls.Config, error) {
+	var cfg *tls.Config
+
+	switch {
+	case len(opts.AutocertDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(opts.AutocertDomains...),
+			Email:      opts.AutocertEmail,
+		}
+		if opts.AutocertCacheDir != "" {
+			manager.Cache = autocert.DirCache(opts.AutocertCacheDir)
+		}
+		// Challenges are answered over TLS-ALPN-01, so the listener must be reachable on 443
+		cfg = manager.TLSConfig()
+	case opts.CertFile != "" && opts.KeyFile != "":
+		certs, err := newCertReloader(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg = &tls.Config{GetCertificate: certs.GetCertificate}
+	default:
+		return nil, ErrNoCertificate
+	}
+	cfg.MinVersion = tls.VersionTLS12
+
+	if opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidClientCA, opts.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		if opts.ClientAuth == ClientAuthOptional {
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return cfg, nil
+}
+
+// certReloader serves a certificate from disk and picks up renewed files
+// without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	r.cert = &cert
+	if info, err := os.Stat(r.certFile); err == nil {
+		r.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// GetCertificate returns the current certificate, reloading it if the file changed.
+// A failed reload keeps serving the previous certificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if info, err := os.Stat(r.certFile); err == nil && info.ModTime().After(r.modTime) {
+		if err := r.load(); err != nil {
+			log.Printf("Keeping previous certificate: %v", err)
+		}
+	}
+	return r.cert, nil
+}
@@ -0,0 +1,187 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+// handshake runs a TLS handshake between the server config and a client over an in-memory pipe
+func handshake(t *testing.T, serverCfg *tls.Config, ca *testCert, clientCert *testCert) error {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCfg := &tls.Config{RootCAs: roots, ServerName: "proxy.test"}
+	if clientCert != nil {
+		clientCfg.Certificates = []tls.Certificate{clientCert.tlsCertificate()}
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- tls.Server(serverConn, serverCfg).Handshake()
+	}()
+
+	client := tls.Client(clientConn, clientCfg)
+	clientErr := client.Handshake()
+	if clientErr == nil {
+		// TLS 1.3 reports client certificate rejections on the first read
+		client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		var buf [1]byte
+		if _, err := client.Read(buf[:]); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			clientErr = err
+		}
+	}
+	clientConn.Close()
+
+	if err := <-serverErr; err != nil {
+		return err
+	}
+	return clientErr
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil)
+	server := newTestCert(t, "proxy.test", ca)
+	client := newTestCert(t, "client", ca)
+	otherCA := newTestCert(t, "other-ca", nil)
+	stranger := newTestCert(t, "stranger", otherCA)
+
+	certFile, keyFile := server.write(t, dir, "server")
+	caFile, _ := ca.write(t, dir, "ca")
+
+	tests := []struct {
+		name        string
+		opts        Options
+		clientCert  *testCert
+		expectError bool
+	}{
+		{
+			name: "server certificate only",
+			opts: Options{CertFile: certFile, KeyFile: keyFile},
+		},
+		{
+			name:       "mTLS with trusted client certificate",
+			opts:       Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+			clientCert: client,
+		},
+		{
+			name:        "mTLS without client certificate",
+			opts:        Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+			expectError: true,
+		},
+		{
+			name:        "mTLS with untrusted client certificate",
+			opts:        Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile},
+			clientCert:  stranger,
+			expectError: true,
+		},
+		{
+			name: "optional mTLS without client certificate",
+			opts: Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: ClientAuthOptional},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := New(tt.opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			err = handshake(t, cfg, ca, tt.clientCert)
+			if tt.expectError && err == nil {
+				t.Error("Expected handshake to fail")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected handshake error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	dir := t.TempDir()
+	bogus := filepath.Join(dir, "bogus.pem")
+	os.WriteFile(bogus, []byte("not a certificate"), 0o600)
+
+	if _, err := New(Options{}); !errors.Is(err, ErrNoCertificate) {
+		t.Errorf("Expected ErrNoCertificate, got %v", err)
+	}
+
+	ca := newTestCert(t, "test-ca", nil)
+	certFile, keyFile := newTestCert(t, "proxy.test", ca).write(t, dir, "server")
+	if _, err := New(Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: bogus}); !errors.Is(err, ErrInvalidClientCA) {
+		t.Errorf("Expected ErrInvalidClientCA, got %v", err)
+	}
+}
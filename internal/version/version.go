@@ -0,0 +1,54 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set when building, with
+// -ldflags "-X gcp-proxy-mity/internal/version.Version=v1.2.3 -X gcp-proxy-mity/internal/version.Commit=$(git rev-parse HEAD) -X gcp-proxy-mity/internal/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info describes the build of the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information. The commit and date fall back to those
+// Go records when building from a git checkout, in case ldflags didn't set them.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: Date, GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.BuildDate == "" {
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// String returns the version with the short commit it was built from, like
+// "v1.2.3 (4f2a9c1)"
+func (i Info) String() string {
+	if i.Commit == "" {
+		return i.Version
+	}
+	return i.Version + " (" + i.Commit[:min(len(i.Commit), 7)] + ")"
+}
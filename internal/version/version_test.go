@@ -0,0 +1,37 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	Version, Commit, Date = "v1.2.3", "4f2a9c1e5b", "2026-10-15T09:00:00Z"
+	defer func() { Version, Commit, Date = "dev", "", "" }()
+
+	info := Get()
+	expected := Info{Version: "v1.2.3", Commit: "4f2a9c1e5b", BuildDate: "2026-10-15T09:00:00Z", GoVersion: runtime.Version()}
+	if info != expected {
+		t.Errorf("Expected %+v, got %+v", expected, info)
+	}
+}
+
+func TestInfo_String(t *testing.T) {
+	tests := []struct {
+		name     string
+		info     Info
+		expected string
+	}{
+		{name: "with commit", info: Info{Version: "v1.2.3", Commit: "4f2a9c1e5b"}, expected: "v1.2.3 (4f2a9c1)"},
+		{name: "short commit", info: Info{Version: "v1.2.3", Commit: "4f2a"}, expected: "v1.2.3 (4f2a)"},
+		{name: "without commit", info: Info{Version: "dev"}, expected: "dev"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if s := tt.info.String(); s != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+"use strict";
+
+// Previews are fetched into memory when an API key is used, since media
+// elements can't send it; larger files are only offered as downloads
+const maxBlobPreview = 50 << 20;
+const maxTextPreview = 256 << 10;
+// Larger uploads are polled for how much the bucket has stored once they are sent
+const minPolledUpload = 8 << 20;
+
+const $ = (id) => document.getElementById(id);
+let apiKey = sessionStorage.getItem("apiKey") || "";
+let previewURL = "";
+
+// objectURL is the API URL of an object, with every path segment escaped
+function objectURL(path, query) {
+  const url = "/api/v1/storage/files/" + path.split("/").map(encodeURIComponent).join("/");
+  return query ? url + "?" + query : url;
+}
+
+function api(url, options = {}) {
+  const headers = new Headers(options.headers);
+  if (apiKey) headers.set("X-API-Key", apiKey);
+  return fetch(url, { ...options, headers, credentials: "same-origin" });
+}
+
+async function failure(resp) {
+  const text = (await resp.text()).trim();
+  return new Error(text || resp.status + " " + resp.statusText);
+}
+
+function showError(err) {
+  $("error").textContent = err ? err.message : "";
+  $("error").hidden = !err;
+}
+
+function currentPrefix() {
+  return decodeURIComponent(location.hash.replace(/^#\/?/, ""));
+}
+
+function formatSize(bytes) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return (i ? bytes.toFixed(1) : bytes) + " " + units[i];
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (className) td.className = className;
+  return td;
+}
+
+// Account
+
+async function loadAccount() {
+  const resp = await fetch("/auth/session", { credentials: "same-origin" });
+  const loginEnabled = resp.status !== 404;
+  if (resp.ok && !apiKey) {
+    const session = await resp.json();
+    $("user").textContent = session.email;
+    $("user").hidden = false;
+    $("logout").hidden = false;
+    return;
+  }
+  $("login").hidden = !loginEnabled;
+  $("key-form").hidden = false;
+  $("key").value = apiKey;
+}
+
+$("logout").addEventListener("click", async () => {
+  await fetch("/auth/logout", { method: "POST", credentials: "same-origin" });
+  location.reload();
+});
+
+$("key-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  apiKey = $("key").value.trim();
+  if (apiKey) {
+    sessionStorage.setItem("apiKey", apiKey);
+  } else {
+    sessionStorage.removeItem("apiKey");
+  }
+  list();
+});
+
+// Browsing
+
+function renderCrumbs(prefix) {
+  const crumbs = $("crumbs");
+  crumbs.replaceChildren();
+  const parts = prefix.split("/").filter(Boolean);
+  const link = (text, target) => {
+    const a = document.createElement("a");
+    a.textContent = text;
+    a.href = "#/" + encodeURIComponent(target);
+    crumbs.append(a);
+  };
+  link("bucket", "");
+  parts.forEach((part, i) => {
+    crumbs.append(" / ");
+    link(part, parts.slice(0, i + 1).join("/") + "/");
+  });
+}
+
+async function list() {
+  const prefix = currentPrefix();
+  renderCrumbs(prefix);
+  showError(null);
+  const resp = await api("/api/v1/storage/list?delimiter=/&prefix=" + encodeURIComponent(prefix));
+  if (!resp.ok) {
+    $("entries").replaceChildren();
+    showError(await failure(resp));
+    return;
+  }
+  const listing = await resp.json();
+  const rows = [];
+  for (const sub of listing.prefixes || []) {
+    const row = document.createElement("tr");
+    cell(row, sub.slice(prefix.length), "folder");
+    cell(row, "");
+    cell(row, "");
+    cell(row, "");
+    row.addEventListener("click", () => {
+      location.hash = "#/" + encodeURIComponent(sub);
+    });
+    rows.push(row);
+  }
+  for (const file of listing.files || []) {
+    if (file.Name === prefix) continue;
+    const row = document.createElement("tr");
+    cell(row, file.Name.slice(prefix.length));
+    cell(row, formatSize(file.Size));
+    cell(row, file.ContentType || "");
+    cell(row, file.Updated ? new Date(file.Updated).toLocaleString() : "");
+    row.addEventListener("click", () => {
+      document.querySelectorAll("#entries tr.selected").forEach((r) => r.classList.remove("selected"));
+      row.classList.add("selected");
+      showDetails(file);
+    });
+    rows.push(row);
+  }
+  $("entries").replaceChildren(...rows);
+}
+
+// Details and previews
+
+async function showDetails(file) {
+  if (previewURL) URL.revokeObjectURL(previewURL);
+  previewURL = "";
+  $("details").hidden = false;
+  $("details-name").textContent = file.Name;
+
+  const metadata = $("metadata");
+  metadata.replaceChildren();
+  for (const [name, value] of Object.entries(file)) {
+    if (value === "" || value === null || value === undefined) continue;
+    const dt = document.createElement("dt");
+    dt.textContent = name;
+    const dd = document.createElement("dd");
+    dd.textContent = name === "Size" ? formatSize(value) + " (" + value + " bytes)" : String(value);
+    metadata.append(dt, dd);
+  }
+
+  const download = $("download");
+  download.href = objectURL(file.Name, "disposition=attachment");
+  download.onclick = apiKey ? (event) => {
+    event.preventDefault();
+    downloadWithKey(file);
+  } : null;
+
+  const preview = $("preview");
+  preview.replaceChildren();
+  const type = file.ContentType || "";
+  const media = type.startsWith("image/") ? "img" : type.startsWith("video/") ? "video" : type.startsWith("audio/") ? "audio" : "";
+  if (media) {
+    const element = document.createElement(media);
+    element.alt = file.Name;
+    if (media !== "img") element.controls = true;
+    if (!apiKey) {
+      element.src = objectURL(file.Name);
+    } else if (file.Size <= maxBlobPreview) {
+      const resp = await api(objectURL(file.Name));
+      if (!resp.ok) {
+        showError(await failure(resp));
+        return;
+      }
+      previewURL = URL.createObjectURL(await resp.blob());
+      element.src = previewURL;
+    } else {
+      preview.textContent = "Too large to preview with an API key; download it instead.";
+      return;
+    }
+    preview.append(element);
+  } else if ((type.startsWith("text/") || type === "application/json") && file.Size <= maxTextPreview) {
+    const resp = await api(objectURL(file.Name));
+    const pre = document.createElement("pre");
+    pre.textContent = resp.ok ? await resp.text() : (await failure(resp)).message;
+    preview.append(pre);
+  }
+}
+
+async function downloadWithKey(file) {
+  const resp = await api(objectURL(file.Name));
+  if (!resp.ok) {
+    showError(await failure(resp));
+    return;
+  }
+  const a = document.createElement("a");
+  a.href = URL.createObjectURL(await resp.blob());
+  a.download = file.Name.split("/").pop();
+  a.click();
+  setTimeout(() => URL.revokeObjectURL(a.href), 60000);
+}
+
+// Uploads
+
+function randomID() {
+  const bytes = crypto.getRandomValues(new Uint8Array(16));
+  return Array.from(bytes, (b) => b.toString(16).padStart(2, "0")).join("");
+}
+
+function upload(file, prefix) {
+  return new Promise((resolve) => {
+    const item = document.createElement("li");
+    const name = document.createElement("span");
+    name.textContent = prefix + file.name;
+    const progress = document.createElement("progress");
+    progress.max = file.size || 1;
+    const status = document.createElement("span");
+    item.append(name, progress, status);
+    $("uploads").append(item);
+
+    const xhr = new XMLHttpRequest();
+    xhr.open("PUT", objectURL(prefix + file.name));
+    if (apiKey) xhr.setRequestHeader("X-API-Key", apiKey);
+    if (file.type) xhr.setRequestHeader("Content-Type", file.type);
+    let poll = 0;
+    const transferID = file.size >= minPolledUpload ? randomID() : "";
+    if (transferID) xhr.setRequestHeader("X-Transfer-ID", transferID);
+    xhr.upload.onprogress = (event) => {
+      progress.value = event.loaded;
+      status.textContent = Math.round((event.loaded / (event.total || 1)) * 100) + "%";
+    };
+    xhr.upload.onload = () => {
+      if (!transferID) return;
+      status.textContent = "storing";
+      poll = setInterval(async () => {
+        const resp = await api("/api/v1/transfers/" + transferID);
+        if (!resp.ok) {
+          // Progress isn't tracked by this server
+          clearInterval(poll);
+          return;
+        }
+        const transfer = await resp.json();
+        if (transfer.state === "writing" && poll) {
+          status.textContent = "storing " + Math.round((transfer.bytes_written / (transfer.total_bytes || 1)) * 100) + "%";
+        }
+      }, 1000);
+    };
+    xhr.onloadend = () => {
+      clearInterval(poll);
+      poll = 0;
+    };
+    xhr.onload = () => {
+      if (xhr.status >= 200 && xhr.status < 300) {
+        progress.value = progress.max;
+        status.textContent = "done";
+        setTimeout(() => item.remove(), 3000);
+      } else {
+        status.textContent = xhr.responseText.trim() || "failed with status " + xhr.status;
+        status.className = "failed";
+      }
+      resolve();
+    };
+    xhr.onerror = () => {
+      status.textContent = "failed";
+      status.className = "failed";
+      resolve();
+    };
+    xhr.send(file);
+  });
+}
+
+async function uploadAll(files) {
+  const prefix = currentPrefix();
+  for (const file of files) {
+    await upload(file, prefix);
+  }
+  list();
+}
+
+$("files").addEventListener("change", (event) => {
+  uploadAll([...event.target.files]);
+  event.target.value = "";
+});
+
+let dragDepth = 0;
+document.addEventListener("dragenter", (event) => {
+  if (!event.dataTransfer.types.includes("Files")) return;
+  dragDepth++;
+  $("drop").hidden = false;
+});
+document.addEventListener("dragleave", () => {
+  dragDepth = Math.max(0, dragDepth - 1);
+  if (!dragDepth) $("drop").hidden = true;
+});
+document.addEventListener("dragover", (event) => event.preventDefault());
+document.addEventListener("drop", (event) => {
+  event.preventDefault();
+  dragDepth = 0;
+  $("drop").hidden = true;
+  const files = [...event.dataTransfer.files];
+  if (files.length) uploadAll(files);
+});
+
+$("refresh").addEventListener("click", list);
+window.addEventListener("hashchange", () => {
+  $("details").hidden = true;
+  list();
+});
+
+loadAccount().finally(list);
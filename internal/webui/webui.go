@@ -0,0 +1,24 @@
+package webui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the single-page UI, with request paths relative to where it
+// is mounted, like /app.js. The UI calls the storage API of the same origin
+// with the session cookie of a login, or with an API key entered in it.
+func Handler() http.Handler {
+	files, _ := fs.Sub(static, "static")
+	server := http.FileServerFS(files)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Embedded files have no modification time, so browsers must ask again
+		// to pick up a new release
+		w.Header().Set("Cache-Control", "no-cache")
+		server.ServeHTTP(w, r)
+	})
+}
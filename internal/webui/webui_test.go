@@ -0,0 +1,38 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	h := Handler()
+
+	tests := []struct {
+		path        string
+		wantType    string
+		wantContent string
+	}{
+		{path: "/", wantType: "text/html", wantContent: `<script src="app.js"></script>`},
+		{path: "/app.js", wantType: "text/javascript", wantContent: "/api/v1/storage/list"},
+		{path: "/app.css", wantType: "text/css", wantContent: "#details"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if !strings.HasPrefix(w.Header().Get("Content-Type"), tt.wantType) {
+				t.Errorf("Expected content type %s, got %s", tt.wantType, w.Header().Get("Content-Type"))
+			}
+			if !strings.Contains(w.Body.String(), tt.wantContent) {
+				t.Errorf("Expected the body to contain %q", tt.wantContent)
+			}
+		})
+	}
+}
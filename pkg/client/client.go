@@ -0,0 +1,221 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthFunc adds credentials to a request before every attempt
+type AuthFunc func(ctx context.Context, req *http.Request) error
+
+// Client calls the proxy's HTTP API
+type Client struct {
+	baseURL        string
+	http           *http.Client
+	auth           AuthFunc
+	maxAttempts    int
+	initialBackoff time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests
+func WithHTTPClient(c *http.Client) Option {
+	return func(client *Client) {
+		client.http = c
+	}
+}
+
+// WithAPIKey authenticates requests with an API key sent as a bearer token
+func WithAPIKey(key string) Option {
+	return WithAuth(func(ctx context.Context, req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+key)
+		return nil
+	})
+}
+
+// WithAuth authenticates requests with fn, for credentials that change over time
+func WithAuth(fn AuthFunc) Option {
+	return func(c *Client) {
+		c.auth = fn
+	}
+}
+
+// WithRetries sets how often a request is attempted; initialBackoff is doubled after every failed attempt
+func WithRetries(maxAttempts int, initialBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxAttempts = maxAttempts
+		c.initialBackoff = initialBackoff
+	}
+}
+
+// New creates a client for the proxy at baseURL, e.g. https://proxy.example.com
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		http:           http.DefaultClient,
+		maxAttempts:    3,
+		initialBackoff: 250 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.maxAttempts < 1 {
+		c.maxAttempts = 1
+	}
+	return c
+}
+
+// request describes an API call so it can be rebuilt for every attempt
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	// body opens the request body; nil for requests without one
+	body func() (io.Reader, error)
+	// size is the length of the body, or -1 if unknown
+	size int64
+	// once is set when the body can only be sent a single time
+	once bool
+}
+
+// do sends the request, retrying network errors, 429 and 5xx gateway
+// responses while the body can be sent again. Responses other than 2xx are
+// returned as *Error.
+func (c *Client) do(ctx context.Context, r *request) (*http.Response, error) {
+	backoff := c.initialBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := c.send(ctx, r)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		var wait time.Duration
+		if err == nil {
+			wait = retryAfter(resp)
+			err = readError(resp)
+			if !retryable(resp.StatusCode) {
+				return nil, err
+			}
+		}
+		if attempt >= c.maxAttempts || r.once || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait = max(wait, backoff)
+		backoff *= 2
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, r *request) (*http.Response, error) {
+	target := c.baseURL + r.path
+	if len(r.query) > 0 {
+		target += "?" + r.query.Encode()
+	}
+
+	var body io.Reader
+	if r.body != nil {
+		var err error
+		if body, err = r.body(); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, target, body)
+	if err != nil {
+		closeBody(body)
+		return nil, err
+	}
+	if r.body != nil && r.size >= 0 {
+		req.ContentLength = r.size
+	}
+	for key, values := range r.header {
+		req.Header[key] = values
+	}
+	if c.auth != nil {
+		if err := c.auth(ctx, req); err != nil {
+			closeBody(body)
+			return nil, err
+		}
+	}
+	return c.http.Do(req)
+}
+
+// closeBody closes a body that was not handed to the transport
+func closeBody(body io.Reader) {
+	if closer, ok := body.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// retryable reports whether a response status is worth retrying
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfter returns the delay requested by a Retry-After header in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func readError(resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	// Errors in the JSON envelope carry their message in the error field
+	var envelope struct {
+		Error string `json:"error"`
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Unmarshal(body, &envelope) == nil && envelope.Error != "" {
+		return &Error{StatusCode: resp.StatusCode, Message: envelope.Error}
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+}
+
+// replayable returns a body function for content, which can be sent again
+// only if it is an io.Seeker. The content is never closed by the transport.
+func replayable(content io.Reader) (body func() (io.Reader, error), once bool) {
+	seeker, ok := content.(io.Seeker)
+	if !ok {
+		return func() (io.Reader, error) { return io.NopCloser(content), nil }, true
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return func() (io.Reader, error) { return io.NopCloser(content), nil }, true
+	}
+	return func() (io.Reader, error) {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(content), nil
+	}, false
+}
+
+// filesPath returns the path of an object under /api/v1/storage/files/ with each segment escaped
+func filesPath(name string) string {
+	segments := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/api/v1/storage/files/" + strings.Join(segments, "/")
+}
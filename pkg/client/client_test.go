@@ -0,0 +1,197 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return New(server.URL, WithAPIKey("secret"), WithRetries(3, time.Millisecond))
+}
+
+func TestClient_Retries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		content      io.Reader
+		wantAttempts int32
+		wantStatus   int
+	}{
+		{
+			name:         "succeeds after unavailable",
+			statuses:     []int{503, 502, 200},
+			content:      strings.NewReader("data"),
+			wantAttempts: 3,
+		},
+		{
+			name:         "gives up after max attempts",
+			statuses:     []int{503, 503, 503, 200},
+			content:      strings.NewReader("data"),
+			wantAttempts: 3,
+			wantStatus:   503,
+		},
+		{
+			name:         "client errors are not retried",
+			statuses:     []int{400, 200},
+			content:      strings.NewReader("data"),
+			wantAttempts: 1,
+			wantStatus:   400,
+		},
+		{
+			name:         "streams are sent once",
+			statuses:     []int{503, 200},
+			content:      io.MultiReader(strings.NewReader("data")),
+			wantAttempts: 1,
+			wantStatus:   503,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				n := attempts.Add(1)
+				if r.Header.Get("Authorization") != "Bearer secret" {
+					t.Errorf("Expected the API key on attempt %d, got %q", n, r.Header.Get("Authorization"))
+				}
+				if body, _ := io.ReadAll(r.Body); string(body) != "data" {
+					t.Errorf("Expected body %q on attempt %d, got %q", "data", n, body)
+				}
+				if status := tt.statuses[n-1]; status != http.StatusOK {
+					http.Error(w, "failed", status)
+					return
+				}
+				w.Write([]byte(`{"Name":"a.txt","Size":4}`))
+			})
+
+			file, err := c.WriteFile(context.Background(), "a.txt", tt.content, 4, "text/plain")
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.wantAttempts, got)
+			}
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if file.Name != "a.txt" || file.Size != 4 {
+					t.Errorf("Expected a.txt of 4 bytes, got %+v", file)
+				}
+				return
+			}
+			var apiErr *Error
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus {
+				t.Errorf("Expected status %d, got %v", tt.wantStatus, err)
+			}
+		})
+	}
+}
+
+func TestClient_WriteFiles(t *testing.T) {
+	var attempts atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if attempts.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		var written []string
+		for key, headers := range r.MultipartForm.File {
+			f, _ := headers[0].Open()
+			content, _ := io.ReadAll(f)
+			written = append(written, key+"="+string(content)+";"+headers[0].Header.Get("Content-Type"))
+		}
+		if len(written) == 2 && written[0] > written[1] {
+			written[0], written[1] = written[1], written[0]
+		}
+		want := []string{"docs/a.txt=alpha;text/plain", "docs/b.json=beta;application/json"}
+		if !reflect.DeepEqual(written, want) {
+			t.Errorf("Expected %v, got %v", want, written)
+		}
+		w.Write([]byte(`{"FilesWritten":[{"Name":"docs/a.txt"},{"Name":"docs/b.json"}],"Errors":[]}`))
+	})
+
+	response, err := c.WriteFiles(context.Background(), []File{
+		{Path: "docs/a.txt", Content: strings.NewReader("alpha"), ContentType: "text/plain"},
+		{Path: "docs/b.json", Content: bytes.NewReader([]byte("beta")), ContentType: "application/json"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts.Load())
+	}
+	if len(response.FilesWritten) != 2 {
+		t.Errorf("Expected 2 files written, got %d", len(response.FilesWritten))
+	}
+}
+
+func TestClient_ReadFile(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v1/storage/files/media/a%20b.txt" {
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("content"))
+	})
+
+	file, err := c.ReadFile(context.Background(), "media/a b.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer file.Close()
+	content, _ := io.ReadAll(file)
+	if string(content) != "content" || file.ContentType != "text/plain" || file.Size != 7 {
+		t.Errorf("Expected 7 bytes of text/plain content, got %q (%s, %d)", content, file.ContentType, file.Size)
+	}
+
+	if _, err := c.ReadFile(context.Background(), "missing.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClient_ErrorEnvelope(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid request: path is required", "violations": [{"field": "path", "message": "is required"}]}`))
+	})
+
+	_, err := c.ReadFile(context.Background(), "a.txt")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Message != "invalid request: path is required" {
+		t.Errorf("Expected the message of the error envelope, got %v", err)
+	}
+}
+
+func TestClient_List(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/storage/list" || r.URL.Query().Get("prefix") != "media/" || r.URL.Query().Get("delimiter") != "/" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"files":[{"Name":"media/a.jpg","Size":3}],"prefixes":["media/clips/"]}`))
+	})
+
+	listing, err := c.List(context.Background(), "media/", "/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := &Listing{Files: []FileMetadata{{Name: "media/a.jpg", Size: 3}}, Prefixes: []string{"media/clips/"}}
+	if !reflect.DeepEqual(listing, want) {
+		t.Errorf("Expected %+v, got %+v", want, listing)
+	}
+}
@@ -0,0 +1,273 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"time"
+)
+
+// FileMetadata describes a stored object
+type FileMetadata struct {
+	Name         string
+	ContentType  string
+	Size         int64
+	KMSKeyName   string    `json:",omitempty"`
+	StorageClass string    `json:",omitempty"`
+	Generation   int64     `json:",omitempty"`
+	Updated      time.Time `json:",omitzero"`
+	MD5          string    `json:",omitempty"`
+}
+
+// File is a file to upload with WriteFiles
+type File struct {
+	Path        string
+	Content     io.Reader
+	ContentType string
+}
+
+// WriteResponse is the outcome of a batch upload
+type WriteResponse struct {
+	FilesWritten []FileMetadata
+	Errors       []WriteError
+	// Deduplicated lists paths copied from an existing object with the same content
+	Deduplicated []string `json:",omitempty"`
+}
+
+// WriteError is a file of a batch that could not be written
+type WriteError struct {
+	FilePath string
+	Error    string
+}
+
+// Listing is the result of List
+type Listing struct {
+	Files    []FileMetadata `json:"files"`
+	Prefixes []string       `json:"prefixes"`
+}
+
+// SignedURL is a signed download URL for an object
+type SignedURL struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SignedURLs is the result of SignedURLs
+type SignedURLs struct {
+	Files  []SignedURL `json:"files"`
+	Errors []struct {
+		FilePath string `json:"file_path"`
+		Error    string `json:"error"`
+	} `json:"errors"`
+}
+
+// FileReader streams the content of an object; it must be closed
+type FileReader struct {
+	io.ReadCloser
+	ContentType string
+	// Size is the length of the content, or -1 if unknown
+	Size int64
+}
+
+// WriteFile uploads content as the object name. size is the length of the
+// content, or -1 if unknown; contentType may be empty to let the proxy detect it.
+// The upload is only retried if content is an io.Seeker.
+func (c *Client) WriteFile(ctx context.Context, name string, content io.Reader, size int64, contentType string) (*FileMetadata, error) {
+	body, once := replayable(content)
+	r := &request{method: http.MethodPut, path: filesPath(name), body: body, size: size, once: once}
+	if contentType != "" {
+		r.header = http.Header{"Content-Type": {contentType}}
+	}
+	var file FileMetadata
+	if err := c.doJSON(ctx, r, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// WriteFiles uploads files in a single multipart request. Files that could not
+// be written are reported in the response rather than as an error. The batch
+// is only retried if every content is an io.Seeker.
+func (c *Client) WriteFiles(ctx context.Context, files []File) (*WriteResponse, error) {
+	bodies := make([]func() (io.Reader, error), len(files))
+	once := false
+	for i, file := range files {
+		var fileOnce bool
+		bodies[i], fileOnce = replayable(file.Content)
+		once = once || fileOnce
+	}
+
+	// Every attempt streams the parts through a new pipe with the same boundary
+	boundary := multipart.NewWriter(nil).Boundary()
+	r := &request{
+		method: http.MethodPost,
+		path:   "/api/v1/storage/files",
+		header: http.Header{"Content-Type": {"multipart/form-data; boundary=" + boundary}},
+		size:   -1,
+		once:   once,
+	}
+	var previous *io.PipeReader
+	var written chan struct{}
+	r.body = func() (io.Reader, error) {
+		// The contents are rewound for a retry only once the previous attempt stopped reading them
+		if previous != nil {
+			previous.Close()
+			<-written
+		}
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		if err := mw.SetBoundary(boundary); err != nil {
+			return nil, err
+		}
+		previous, written = pr, make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+			pw.CloseWithError(writeParts(mw, files, bodies))
+		}(written)
+		return pr, nil
+	}
+
+	var response WriteResponse
+	if err := c.doJSON(ctx, r, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// writeParts writes every file as a form part named after its path
+func writeParts(mw *multipart.Writer, files []File, bodies []func() (io.Reader, error)) error {
+	for i, file := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+			"name":     file.Path,
+			"filename": path.Base(file.Path),
+		}))
+		if file.ContentType != "" {
+			header.Set("Content-Type", file.ContentType)
+		}
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		content, err := bodies[i]()
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, content); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// ReadFile opens the content of an object for streaming
+func (c *Client) ReadFile(ctx context.Context, name string) (*FileReader, error) {
+	resp, err := c.do(ctx, &request{method: http.MethodGet, path: filesPath(name)})
+	if err != nil {
+		return nil, err
+	}
+	return &FileReader{
+		ReadCloser:  resp.Body,
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+	}, nil
+}
+
+// List lists the objects under prefix. With a delimiter, objects below the next
+// delimiter are grouped into prefixes instead of being listed.
+func (c *Client) List(ctx context.Context, prefix, delimiter string) (*Listing, error) {
+	query := url.Values{"prefix": {prefix}}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	var listing Listing
+	if err := c.doJSON(ctx, &request{method: http.MethodGet, path: "/api/v1/storage/list", query: query}, &listing); err != nil {
+		return nil, err
+	}
+	return &listing, nil
+}
+
+// Delete deletes an object
+func (c *Client) Delete(ctx context.Context, name string) error {
+	return c.doJSON(ctx, &request{method: http.MethodDelete, path: filesPath(name)}, nil)
+}
+
+// Copy copies an object within the bucket
+func (c *Client) Copy(ctx context.Context, src, dst string) (*FileMetadata, error) {
+	r, err := jsonRequest(http.MethodPost, filesPath(src)+"/copy", map[string]string{"destination": dst})
+	if err != nil {
+		return nil, err
+	}
+	var file FileMetadata
+	if err := c.doJSON(ctx, r, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// SignedURLs returns signed download URLs for the objects; the proxy must have
+// URL signing enabled
+func (c *Client) SignedURLs(ctx context.Context, names []string) (*SignedURLs, error) {
+	r, err := jsonRequest(http.MethodPost, "/api/v1/storage/files/read", map[string][]string{"file_paths": names})
+	if err != nil {
+		return nil, err
+	}
+	r.query = url.Values{"encoding": {"url"}}
+	var result SignedURLs
+	if err := c.doJSON(ctx, r, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SignedURL returns a signed download URL for an object
+func (c *Client) SignedURL(ctx context.Context, name string) (*SignedURL, error) {
+	result, err := c.SignedURLs(ctx, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Files) == 0 {
+		message := "no URL returned"
+		if len(result.Errors) > 0 {
+			message = result.Errors[0].Error
+		}
+		return nil, fmt.Errorf("%s: %s", name, message)
+	}
+	return &result.Files[0], nil
+}
+
+// doJSON sends the request and decodes a JSON response into out, if set
+func (c *Client) doJSON(ctx context.Context, r *request, out any) error {
+	resp, err := c.do(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func jsonRequest(method, path string, body any) (*request, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &request{
+		method: method,
+		path:   path,
+		header: http.Header{"Content-Type": {"application/json"}},
+		body:   func() (io.Reader, error) { return bytes.NewReader(data), nil },
+		size:   int64(len(data)),
+	}, nil
+}
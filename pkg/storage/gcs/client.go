@@ -2,25 +2,31 @@ package gcs
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/impersonate"
 	"google.golang.org/api/option"
 )
 
 type Client struct {
-	client     *storage.Client
-	bucketName string
+	client      *storage.Client
+	projectID   string
+	bucketName  string
+	credentials Credentials
+
+	mu sync.Mutex
+	// impersonated holds a client per service account acting on the same bucket
+	impersonated map[string]*Client
 }
 
-func NewClient(ctx context.Context, projectID, bucketName string, credentialsPath string) (*Client, error) {
-	var opts []option.ClientOption
-	if credentialsPath != "" {
-		d, err := base64.StdEncoding.DecodeString(credentialsPath)
-		if err != nil {
-			return nil, err
-		}
-		opts = append(opts, option.WithCredentialsJSON(d))
+func NewClient(ctx context.Context, projectID, bucketName string, credentials Credentials) (*Client, error) {
+	opts, err := ClientOptions(credentials)
+	if err != nil {
+		return nil, err
 	}
 
 	client, err := storage.NewClient(ctx, opts...)
@@ -29,15 +35,102 @@ func NewClient(ctx context.Context, projectID, bucketName string, credentialsPat
 	}
 
 	return &Client{
-		client:     client,
-		bucketName: bucketName,
+		client:       client,
+		projectID:    projectID,
+		bucketName:   bucketName,
+		credentials:  credentials,
+		impersonated: make(map[string]*Client),
 	}, nil
 }
 
+// NewEmulatorClient creates a client for a Cloud Storage emulator such as
+// fake-gcs-server listening at host, e.g. localhost:4443 or http://localhost:4443.
+// No credentials are sent.
+func NewEmulatorClient(ctx context.Context, projectID, bucketName, host string) (*Client, error) {
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	endpoint, err := url.JoinPath(host, "storage/v1/")
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := storage.NewClient(ctx, option.WithEndpoint(endpoint), option.WithoutAuthentication())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		client:       client,
+		projectID:    projectID,
+		bucketName:   bucketName,
+		impersonated: make(map[string]*Client),
+	}, nil
+}
+
+// Impersonate returns a client for the same bucket acting as serviceAccount,
+// created once per account. The configured credentials need the Service
+// Account Token Creator role on it; tokens are minted on first use.
+func (c *Client) Impersonate(ctx context.Context, serviceAccount string) (*Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.impersonated[serviceAccount]; ok {
+		return client, nil
+	}
+
+	opts, err := ClientOptions(c.credentials)
+	if err != nil {
+		return nil, err
+	}
+	// The token source outlives ctx, it refreshes the token in the background
+	tokens, err := impersonate.CredentialsTokenSource(context.Background(), impersonate.CredentialsConfig{
+		TargetPrincipal: serviceAccount,
+		Scopes:          []string{storage.ScopeFullControl},
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	client, err := storage.NewClient(ctx, option.WithTokenSource(tokens))
+	if err != nil {
+		return nil, err
+	}
+
+	impersonated := &Client{
+		client:       client,
+		projectID:    c.projectID,
+		bucketName:   c.bucketName,
+		impersonated: make(map[string]*Client),
+	}
+	c.impersonated[serviceAccount] = impersonated
+	return impersonated, nil
+}
+
 func (c *Client) Close() error {
-	return c.client.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	errs := []error{c.client.Close()}
+	for _, client := range c.impersonated {
+		errs = append(errs, client.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// CheckBucket verifies that the bucket is reachable with the configured credentials
+func (c *Client) CheckBucket(ctx context.Context) error {
+	_, err := c.GetBucket().Attrs(ctx)
+	return err
 }
 
 func (c *Client) GetBucket() *storage.BucketHandle {
 	return c.client.Bucket(c.bucketName)
 }
+
+// ProjectID returns the project that new buckets are created in
+func (c *Client) ProjectID() string {
+	return c.projectID
+}
+
+// StorageClient returns the underlying client for operations outside the configured bucket
+func (c *Client) StorageClient() *storage.Client {
+	return c.client
+}
@@ -0,0 +1,75 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// Credentials modes
+const (
+	// CredentialsAuto uses ADC without a value, the value as base64 JSON if it
+	// decodes as such and as a key file path otherwise
+	CredentialsAuto = ""
+	// CredentialsADC uses Application Default Credentials: GOOGLE_APPLICATION_CREDENTIALS,
+	// gcloud's user credentials or the metadata server, e.g. with GKE Workload Identity
+	CredentialsADC           = "adc"
+	CredentialsFile          = "file"
+	CredentialsBase64JSON    = "base64-json"
+	CredentialsImpersonation = "impersonation"
+)
+
+// Credentials selects how the proxy authenticates to Google APIs
+type Credentials struct {
+	Mode string
+	// Value is the key file path in file mode and the base64-encoded JSON key in base64-json mode
+	Value string
+	// ServiceAccount is impersonated with Application Default Credentials in impersonation mode
+	ServiceAccount string
+}
+
+// ResolvedMode returns the mode, resolving CredentialsAuto by the value
+func (c Credentials) ResolvedMode() string {
+	if c.Mode != CredentialsAuto {
+		return c.Mode
+	}
+	if c.Value == "" {
+		return CredentialsADC
+	}
+	if d, err := base64.StdEncoding.DecodeString(c.Value); err == nil && bytes.HasPrefix(bytes.TrimSpace(d), []byte("{")) {
+		return CredentialsBase64JSON
+	}
+	return CredentialsFile
+}
+
+// ClientOptions returns the client options for the credentials, shared by all Google API clients
+func ClientOptions(credentials Credentials) ([]option.ClientOption, error) {
+	switch mode := credentials.ResolvedMode(); mode {
+	case CredentialsADC:
+		return nil, nil
+	case CredentialsFile:
+		return []option.ClientOption{option.WithCredentialsFile(credentials.Value)}, nil
+	case CredentialsBase64JSON:
+		d, err := base64.StdEncoding.DecodeString(credentials.Value)
+		if err != nil {
+			return nil, err
+		}
+		return []option.ClientOption{option.WithCredentialsJSON(d)}, nil
+	case CredentialsImpersonation:
+		// The token source outlives the caller's context, it refreshes the token in the background
+		tokens, err := impersonate.CredentialsTokenSource(context.Background(), impersonate.CredentialsConfig{
+			TargetPrincipal: credentials.ServiceAccount,
+			Scopes:          []string{"https://www.googleapis.com/auth/cloud-platform"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []option.ClientOption{option.WithTokenSource(tokens)}, nil
+	default:
+		return nil, fmt.Errorf("unknown credentials mode %q", mode)
+	}
+}
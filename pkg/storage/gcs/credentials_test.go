@@ -0,0 +1,28 @@
+package gcs
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestCredentials_ResolvedMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials Credentials
+		want        string
+	}{
+		{name: "nothing", credentials: Credentials{}, want: CredentialsADC},
+		{name: "base64 JSON", credentials: Credentials{Value: base64.StdEncoding.EncodeToString([]byte(`{"type": "service_account"}`))}, want: CredentialsBase64JSON},
+		{name: "file path", credentials: Credentials{Value: "/secrets/key.json"}, want: CredentialsFile},
+		{name: "base64 of something else", credentials: Credentials{Value: base64.StdEncoding.EncodeToString([]byte("key"))}, want: CredentialsFile},
+		{name: "explicit", credentials: Credentials{Mode: CredentialsImpersonation, ServiceAccount: "proxy@project.iam.gserviceaccount.com"}, want: CredentialsImpersonation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.credentials.ResolvedMode(); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}